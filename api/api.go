@@ -0,0 +1,197 @@
+//api包通过HTTP提供调度管理的REST接口，请求和返回均为JSON：
+//
+//	GET    /schedules             调度列表
+//	POST   /schedules             新增调度，返回201，Location为新调度的地址
+//	GET    /schedules/{id}        指定的调度
+//	DELETE /schedules/{id}        删除调度
+//	PUT    /schedules/{id}/pause  暂停调度
+//	PUT    /schedules/{id}/resume 恢复暂停的调度
+//	POST   /schedules/{id}/run    立即执行一次，返回批次ID
+//
+//失败时按schedule.ErrorCodeOf的错误类型返回状态码：调度不存在返回404，参数或调度信息校验失败返回400，
+//与调度当前的状态冲突返回409，ScheduleManager已关闭返回503，其余错误返回500，
+//错误信息见ErrorResponse。监听地址由GlobalConfigStruct.ApiAddr设置。
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rprp/hivego/schedule"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//错误类型对应的HTTP状态码，未列出的错误类型返回500
+var statusCodes = map[schedule.ErrorCode]int{
+	schedule.CodeNotFound: http.StatusNotFound,
+	schedule.CodeInvalid:  http.StatusBadRequest,
+	schedule.CodeConflict: http.StatusConflict,
+	schedule.CodeShutdown: http.StatusServiceUnavailable,
+}
+
+//请求失败时返回的信息
+type ErrorResponse struct { // {{{
+	Error string `json:"error"` //错误信息
+} // }}}
+
+//立即执行调度时返回的信息
+type RunResponse struct { // {{{
+	RunId string `json:"run_id"` //批次ID，可通过/runs?batch=查询执行进度
+} // }}}
+
+//Server将ScheduleManager的管理方法包装为REST接口
+type Server struct { // {{{
+	sl *schedule.ScheduleManager
+} // }}}
+
+//NewServer创建sl的REST接口
+func NewServer(sl *schedule.ScheduleManager) *Server { // {{{
+	return &Server{sl: sl}
+} // }}}
+
+//StartServer在GlobalConfigStruct.ApiAddr上启动REST接口，ApiAddr为空时不启动。
+//服务异常退出时返回error信息。
+func StartServer(sl *schedule.ScheduleManager) error { // {{{
+	addr := sl.Global.ApiAddr
+	if addr == "" {
+		return nil
+	}
+
+	sl.Global.L.Println("REST api is running in ", addr)
+	if err := http.ListenAndServe(addr, NewServer(sl)); err != nil {
+		e := fmt.Sprintf("\n[api.StartServer] listen on [%s] error %s.", addr, err.Error())
+		return errors.New(e)
+	}
+	return nil
+} // }}}
+
+//ServeHTTP按路径和请求方法转发请求
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) { // {{{
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "schedules" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("[api] not found [%s]", r.URL.Path))
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, srv.sl.AllSchedules())
+		case http.MethodPost:
+			srv.addSchedule(w, r)
+		default:
+			notAllowed(w, r, http.MethodGet, http.MethodPost)
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("[api] schedule id [%s] is not a number", parts[1]))
+		return
+	}
+	s := srv.sl.GetScheduleById(id)
+	if s == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("[api] not found schedule by id %d", id))
+		return
+	}
+
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, s)
+		case http.MethodDelete:
+			if err = srv.sl.DeleteSchedule(id); err != nil {
+				srv.fail(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			notAllowed(w, r, http.MethodGet, http.MethodDelete)
+		}
+		return
+	}
+
+	switch parts[2] {
+	case "pause", "resume":
+		if r.Method != http.MethodPut {
+			notAllowed(w, r, http.MethodPut)
+			return
+		}
+		if parts[2] == "pause" {
+			err = srv.sl.PauseScheduleById(id)
+		} else {
+			err = srv.sl.ResumeScheduleById(id)
+		}
+		if err != nil {
+			srv.fail(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s)
+
+	case "run":
+		if r.Method != http.MethodPost {
+			notAllowed(w, r, http.MethodPost)
+			return
+		}
+		batchId, err := srv.sl.RunScheduleNow(id)
+		if err != nil {
+			srv.fail(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, RunResponse{RunId: batchId})
+
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("[api] not found [%s]", r.URL.Path))
+	}
+} // }}}
+
+//addSchedule新增请求中的调度，成功时返回201，并在Location中返回新调度的地址
+func (srv *Server) addSchedule(w http.ResponseWriter, r *http.Request) { // {{{
+	s := &schedule.Schedule{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("[api] decode schedule error %s", err.Error()))
+		return
+	}
+	if s.Name == "" {
+		writeError(w, http.StatusBadRequest, "[api] schedule name is required")
+		return
+	}
+
+	id, err := srv.sl.AddSchedule(s)
+	if err != nil {
+		srv.fail(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/schedules/%d", id))
+	writeJSON(w, http.StatusCreated, s)
+} // }}}
+
+//fail记录调用失败的信息，并按错误类型返回状态码，见statusCodes
+func (srv *Server) fail(w http.ResponseWriter, err error) { // {{{
+	srv.sl.Global.L.Warningln(fmt.Sprintf("[api] %s", err.Error()))
+
+	code, ok := statusCodes[schedule.ErrorCodeOf(err)]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	writeError(w, code, strings.TrimSpace(err.Error()))
+} // }}}
+
+//notAllowed返回405，并在Allow中列出支持的请求方法
+func notAllowed(w http.ResponseWriter, r *http.Request, methods ...string) { // {{{
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("[api] method %s is not allowed on [%s]", r.Method, r.URL.Path))
+} // }}}
+
+func writeError(w http.ResponseWriter, code int, msg string) { // {{{
+	writeJSON(w, code, ErrorResponse{Error: msg})
+} // }}}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) { // {{{
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+} // }}}
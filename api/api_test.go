@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/rprp/hivego/schedule"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T) (*Server, *schedule.GlobalConfigStruct) {
+	sc := schedule.DefaultGlobal()
+	sc.L.Out = ioutil.Discard
+	sc.MetaStore = schedule.NewMemStore()
+	if err := sc.Schedules.InitScheduleList(); err != nil {
+		t.Fatal(err)
+	}
+	return NewServer(sc.Schedules), sc
+}
+
+//do发送请求，返回状态码，并检查失败时返回的错误信息
+func do(t *testing.T, srv *Server, method, path, body string) int {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code >= 400 {
+		var er ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&er); err != nil || er.Error == "" {
+			t.Fatalf("%s %s want error message, got %v %q", method, path, err, er.Error)
+		}
+	}
+	return w.Code
+}
+
+func TestStatusCode(t *testing.T) {
+	srv, sc := newTestServer(t)
+	sl := sc.Schedules
+
+	//校验失败返回400
+	if c := do(t, srv, http.MethodPost, "/schedules", `{"Name":"bad","Cyc":"x"}`); c != http.StatusBadRequest {
+		t.Fatalf("want 400 for invalid schedule, got %d", c)
+	}
+	if c := do(t, srv, http.MethodPost, "/schedules/abc/run", ""); c != http.StatusBadRequest {
+		t.Fatalf("want 400 for bad id, got %d", c)
+	}
+
+	//不支持的请求方法返回405
+	if c := do(t, srv, http.MethodPatch, "/schedules", ""); c != http.StatusMethodNotAllowed {
+		t.Fatalf("want 405, got %d", c)
+	}
+
+	//调度不存在返回404，包括调度列表中存在而元数据库中已删除的调度
+	if c := do(t, srv, http.MethodGet, "/schedules/99", ""); c != http.StatusNotFound {
+		t.Fatalf("want 404 for unknown schedule, got %d", c)
+	}
+	s := &schedule.Schedule{Name: "gone"}
+	if _, err := sl.AddSchedule(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.MetaStore.DeleteSchedule(s); err != nil {
+		t.Fatal(err)
+	}
+	if c := do(t, srv, http.MethodPost, "/schedules/"+strconv.FormatInt(s.Id, 10)+"/run", ""); c != http.StatusNotFound {
+		t.Fatalf("want 404 for schedule deleted from store, got %d", c)
+	}
+
+	//与当前状态冲突返回409，未分类的错误返回500
+	for code, want := range map[schedule.ErrorCode]int{
+		schedule.CodeConflict: http.StatusConflict,
+		schedule.CodeStore:    http.StatusInternalServerError,
+		schedule.CodeUnknown:  http.StatusInternalServerError,
+	} {
+		w := httptest.NewRecorder()
+		srv.fail(w, &schedule.ScheduleError{Code: code, Message: code.Error()})
+		if w.Code != want {
+			t.Fatalf("want %d for %s, got %d", want, code, w.Code)
+		}
+	}
+
+	//ScheduleManager关闭后返回503
+	ok := &schedule.Schedule{Name: "ok"}
+	if _, err := sl.AddSchedule(ok); err != nil {
+		t.Fatal(err)
+	}
+	sc.Shutdown(context.Background())
+	if c := do(t, srv, http.MethodPut, "/schedules/"+strconv.FormatInt(ok.Id, 10)+"/pause", ""); c != http.StatusServiceUnavailable {
+		t.Fatalf("want 503 after shutdown, got %d", c)
+	}
+}
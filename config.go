@@ -9,12 +9,63 @@ type HiveConfig struct {
 	Maxprocs        int                `toml:"maxprocs"`
 	Dbinfo          map[string]*dbinfo `toml:"dbinfo"`
 	ManagerPort     string             `toml:"managerport"`
+	ApiAddr         string             `toml:"api_addr"`
 	Port            string             `toml:"port"`
 	Loglevel        uint8              `toml:"loglevel"`
 	SchedulePidFile string             `toml:"schedule_pid_file"`
 	WorkerPidFile   string             `toml:"worker_pid_file"`
 	CpuProfName     string             `toml:"cpuprof"`
 	MemProfName     string             `toml:"memprof"`
+	EmptyPolicy     string             `toml:"empty_schedule_policy"`
+	LogAttempts     bool               `toml:"log_attempts"`
+	PruneOnLoad     bool               `toml:"prune_on_load"`
+	ScheduleDir     string             `toml:"schedule_dir"`
+	MetaStore       string             `toml:"meta_store"`
+	UniqueNames     bool               `toml:"unique_names"`
+	RetryJitter     string             `toml:"retry_jitter"`
+	MaxTasksPerRun  int                `toml:"max_tasks_per_run"`
+	Workers         []string           `toml:"workers"`
+	LogDir          string             `toml:"log_dir"`
+	LogRoute        string             `toml:"log_route"`
+	MaxParallelJobs int                `toml:"max_parallel_jobs"`
+	GroupFailPolicy string             `toml:"group_fail_policy"`
+	Metrics         bool               `toml:"metrics"`
+	LogFormat       string             `toml:"log_format"`
+	HealthTimeout   int64              `toml:"health_timeout"`
+	WorkerDown      string             `toml:"worker_down_policy"`
+	WorkerDelay     int64              `toml:"worker_delay"`
+	DBMaxRetry      int                `toml:"db_max_retry"`
+	DBBackoff       int64              `toml:"db_backoff"`
+	DBTimeout       int64              `toml:"db_timeout"`
+	Webhooks        []string           `toml:"webhooks"`
+	WebhookRetry    int                `toml:"webhook_retry"`
+	WebhookBackoff  int64              `toml:"webhook_backoff"`
+	ResourcePools   map[string]int     `toml:"resource_pools"`
+	WorkerSelector  string             `toml:"worker_selector"`
+	WorkerRecheck   int64              `toml:"worker_recheck"`
+	EventBuffer     int                `toml:"event_buffer"`
+	EventOverflow   string             `toml:"event_overflow"`
+	MisfireLimit    int                `toml:"misfire_limit"`
+	MaxConcurrent   int                `toml:"max_concurrent_schedules"`
+	Throttle        string             `toml:"schedule_throttle"`
+	OutputLimit     int                `toml:"task_output_limit"`
+	Executor        string             `toml:"executor"`
+	TLSCA           string             `toml:"tls_ca"`
+	TLSCert         string             `toml:"tls_cert"`
+	TLSKey          string             `toml:"tls_key"`
+	TLSClientAuth   bool               `toml:"tls_client_auth"`
+	WorkerToken     string             `toml:"worker_token"`
+	WorkerInsecure  bool               `toml:"worker_insecure"`
+	DispatchRetry   int                `toml:"dispatch_retry"`
+	DispatchBackoff int64              `toml:"dispatch_backoff"`
+	DispatchMax     int64              `toml:"dispatch_backoff_max"`
+	TransientCodes  []int              `toml:"dispatch_transient_codes"`
+	ShutdownTimeout int64              `toml:"shutdown_timeout"`
+	ExecMaxAge      int64              `toml:"exec_max_age"`
+	RunLockTable    string             `toml:"run_lock_table"`
+	LeaderTable     string             `toml:"leader_table"`
+	LeaderName      string             `toml:"leader_name"`
+	LeaderLease     int64              `toml:"leader_lease"`
 }
 
 type dbinfo struct {
@@ -1,13 +1,15 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"flag"
 	"fmt"
-	"github.com/Sirupsen/logrus"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rprp/hivego/api"
 	"github.com/rprp/hivego/manager"
 	"github.com/rprp/hivego/schedule"
 	"github.com/rprp/hivego/worker"
@@ -20,6 +22,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 const (
@@ -30,16 +33,125 @@ func setConfig(config *HiveConfig) (*schedule.GlobalConfigStruct, string, string
 	maxprocs := config.Maxprocs
 	port := config.Port
 	managerport := config.ManagerPort
-	loglevel := config.Loglevel
 	cpuProfName := config.CpuProfName
 	memProfName := config.MemProfName
 
 	runtime.GOMAXPROCS(maxprocs)
 
 	dg := schedule.DefaultGlobal()
-	dg.L.Level = logrus.Level(loglevel)
+	//环境变量中的日志级别优先于配置文件
+	loglevel := strconv.Itoa(int(config.Loglevel))
+	if v := os.Getenv(schedule.LogLevelEnv); v != "" {
+		loglevel = v
+	}
+	if err := dg.SetLogLevel(loglevel); err != nil {
+		log.Fatal(err)
+	}
+	if err := schedule.SetLogFormat(dg.L, config.LogFormat); err != nil {
+		log.Fatal(err)
+	}
 	dg.Port = ":" + port
 	dg.ManagerPort = ":" + managerport
+	dg.ApiAddr = config.ApiAddr
+	if config.EmptyPolicy != "" {
+		dg.EmptyPolicy = config.EmptyPolicy
+	}
+	dg.LogAttempts = config.LogAttempts
+	dg.PruneOnLoad = config.PruneOnLoad
+	dg.UniqueNames = config.UniqueNames
+	if config.RetryJitter != "" {
+		dg.RetryJitter = config.RetryJitter
+	}
+	if config.MaxTasksPerRun != 0 {
+		dg.MaxTasksPerRun = config.MaxTasksPerRun
+	}
+	dg.MaxParallelJobs = config.MaxParallelJobs
+	if config.GroupFailPolicy != "" {
+		dg.GroupFailPolicy = config.GroupFailPolicy
+	}
+	if err := dg.Schedules.UpdateWorkers(config.Workers); err != nil {
+		log.Fatal(err)
+	}
+	dg.WorkerToken = config.WorkerToken
+	dg.WorkerInsecure = config.WorkerInsecure
+	if config.Executor != "" {
+		exec, err := schedule.NewExecutor(config.Executor)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dg.Executor = exec
+	}
+	if config.WorkerSelector != "" {
+		sel, err := schedule.NewWorkerSelector(config.WorkerSelector)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dg.WorkerSelector = sel
+	}
+	if config.WorkerRecheck > 0 {
+		dg.WorkerRecheck = time.Duration(config.WorkerRecheck) * time.Second
+	}
+	if config.EventBuffer > 0 {
+		dg.EventBuffer = config.EventBuffer
+	}
+	if config.EventOverflow != "" {
+		dg.EventOverflow = config.EventOverflow
+	}
+	if config.MisfireLimit != 0 {
+		dg.MisfireLimit = config.MisfireLimit
+	}
+	dg.MaxConcurrentSchedules = config.MaxConcurrent
+	if config.Throttle != "" {
+		dg.ScheduleThrottle = config.Throttle
+	}
+	if config.OutputLimit != 0 {
+		dg.TaskOutputLimit = config.OutputLimit
+	}
+	if config.LogDir != "" {
+		dg.LoggerFactory = schedule.FileLoggerFactory(dg.L, config.LogDir, config.LogRoute)
+	}
+	if config.HealthTimeout > 0 {
+		dg.HealthTimeout = time.Duration(config.HealthTimeout) * time.Second
+	}
+	if config.WorkerDown != "" {
+		dg.WorkerDownPolicy = config.WorkerDown
+	}
+	if config.WorkerDelay > 0 {
+		dg.WorkerDelay = time.Duration(config.WorkerDelay) * time.Second
+	}
+	if config.DBMaxRetry != 0 {
+		dg.DBMaxRetry = config.DBMaxRetry
+	}
+	if config.DBBackoff > 0 {
+		dg.DBBackoff = time.Duration(config.DBBackoff) * time.Second
+	}
+	if config.ExecMaxAge > 0 {
+		dg.ExecMaxAge = time.Duration(config.ExecMaxAge) * time.Second
+	}
+	if config.DBTimeout != 0 {
+		dg.DBTimeout = time.Duration(config.DBTimeout) * time.Second
+	}
+	dg.Webhooks = config.Webhooks
+	dg.ResourcePools = config.ResourcePools
+	if config.WebhookRetry != 0 {
+		dg.WebhookRetry = config.WebhookRetry
+	}
+	if config.WebhookBackoff > 0 {
+		dg.WebhookBackoff = time.Duration(config.WebhookBackoff) * time.Second
+	}
+	if config.DispatchRetry != 0 {
+		dg.DispatchRetry = config.DispatchRetry
+	}
+	if config.DispatchBackoff > 0 {
+		dg.DispatchBackoff = time.Duration(config.DispatchBackoff) * time.Second
+	}
+	if config.DispatchMax > 0 {
+		dg.DispatchBackoffMax = time.Duration(config.DispatchMax) * time.Second
+	}
+	dg.DispatchClassifier = schedule.NewCodeClassifier(config.TransientCodes...)
+	if config.Metrics {
+		dg.Registry = prometheus.NewRegistry()
+	}
 
 	return dg, cpuProfName, memProfName
 }
@@ -98,29 +210,95 @@ func main() {
 			}()
 		}
 
-		cnn, err := sql.Open(config.Dbinfo["hivedb"].Dbtype, config.Dbinfo["hivedb"].Conn)
-		if err != nil {
-			log.Fatalf("Unable to connect metadata database. %s", err)
+		//连接Worker使用的TLS配置
+		if config.TLSCA != "" || config.TLSCert != "" {
+			conf, err := schedule.LoadWorkerTLS(config.TLSCA, config.TLSCert, config.TLSKey)
+			if err != nil {
+				log.Fatal(err)
+			}
+			global.WorkerTLS = conf
 		}
-		global.HiveConn = cnn
-		defer global.HiveConn.Close()
 
-		cnn, err = sql.Open(config.Dbinfo["logdb"].Dbtype, config.Dbinfo["logdb"].Conn)
-		if err != nil {
-			log.Fatalf("Unable to connect metadata database. %s", err)
+		//元数据只保存在内存中时不连接元数据库，调度从定义文件目录加载
+		if config.MetaStore == "memory" {
+			global.MetaStore = schedule.NewMemStore()
+		} else {
+			cnn, err := sql.Open(config.Dbinfo["hivedb"].Dbtype, config.Dbinfo["hivedb"].Conn)
+			if err != nil {
+				log.Fatalf("Unable to connect metadata database. %s", err)
+			}
+			global.HiveConn = cnn
+
+			//多实例部署时通过元数据库中的表抢占调度启动
+			if config.RunLockTable != "" {
+				rl, err := schedule.NewTableRunLock(config.RunLockTable)
+				if err != nil {
+					log.Fatal(err)
+				}
+				global.RunLock = rl
+			}
+
+			//多实例部署时竞选主节点，只有主节点启动调度的监听
+			if config.LeaderTable != "" {
+				name := config.LeaderName
+				if name == "" {
+					name = "hivego"
+				}
+				le, err := schedule.NewTableLeaderElector(config.LeaderTable, name)
+				if err != nil {
+					log.Fatal(err)
+				}
+				global.Elector = le
+				global.LeaderLease = time.Duration(config.LeaderLease) * time.Second
+			}
+		}
+
+		//未配置日志库时不记录执行日志
+		if ldb, ok := config.Dbinfo["logdb"]; ok {
+			cnn, err := sql.Open(ldb.Dbtype, ldb.Conn)
+			if err != nil {
+				log.Fatalf("Unable to connect metadata database. %s", err)
+			}
+			global.LogConn = cnn
+		} else {
+			global.NoLog = true
 		}
-		global.LogConn = cnn
-		defer global.LogConn.Close()
 
 		//初始化
-		global.Schedules.InitScheduleList()
+		if err := global.Schedules.InitScheduleList(); err != nil {
+			log.Fatal(err)
+		}
+		//从定义文件目录同步调度
+		if config.ScheduleDir != "" {
+			if _, err := global.Schedules.LoadFromDir(config.ScheduleDir); err != nil {
+				log.Fatal(err)
+			}
+		}
 		//启动调度
 		go global.Schedules.StartListener()
 
 		//启动管理模块
 		go manager.StartManager(global.Schedules)
 
+		//启动REST接口
+		go func() {
+			if err := api.StartServer(global.Schedules); err != nil {
+				log.Fatal(err)
+			}
+		}()
+
 		waitExit("Schedule")
+
+		//停止调度的监听并等待正在执行的批次结束，超时后中止批次，最后关闭数据库连接
+		timeout := time.Duration(config.ShutdownTimeout) * time.Second
+		if timeout <= 0 {
+			timeout = time.Minute
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := global.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
 	} else { // }}}
 
 		if config.SchedulePidFile != "" { // {{{
@@ -135,7 +313,19 @@ func main() {
 			}()
 		} // }}}
 
-		worker.ListenAndServer(global.Port)
+		sec := worker.Security{Token: config.WorkerToken, Insecure: config.WorkerInsecure}
+		if config.TLSCert != "" {
+			clientCA := ""
+			if config.TLSClientAuth {
+				clientCA = config.TLSCA
+			}
+			conf, err := worker.LoadServerTLS(config.TLSCert, config.TLSKey, clientCA)
+			if err != nil {
+				log.Fatal(err)
+			}
+			sec.TLS = conf
+		}
+		worker.ListenAndServer(global.Port, sec)
 
 		waitExit("Worker")
 	}
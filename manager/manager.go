@@ -1,11 +1,14 @@
 package manager
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/binding"
 	"github.com/martini-contrib/render"
 	"github.com/martini-contrib/web"
+	"github.com/rprp/hivego/metrics"
 	"github.com/rprp/hivego/schedule"
 	"log"
 	"net/http"
@@ -57,6 +60,11 @@ func controller(m *martini.ClassicMartini) { // {{{
 		r.Get("/:id", GetScheduleById)
 		r.Put("/:id", binding.Bind(schedule.Schedule{}), UpdateSchedule)
 		r.Delete("/:id", DeleteSchedule)
+		r.Put("/:id/pause", PauseSchedule)
+		r.Put("/:id/resume", ResumeSchedule)
+		r.Put("/:id/reload", ReloadSchedule)
+		r.Post("/:id/run", RunSchedule)
+		r.Post("/:id/backfill", BackfillSchedule)
 
 		//Job部分
 		r.Get("/:sid/jobs", GetJobsForSchedule)
@@ -74,11 +82,46 @@ func controller(m *martini.ClassicMartini) { // {{{
 		r.Delete("/:sid/jobs/:jid/tasks/:id/reltask/:relid", DeleteRelTask)
 	})
 
+	//任务产出物的引用
+	m.Get("/artifacts", GetTaskArtifacts)
+
+	//从执行日志还原调度的执行过程
+	m.Get("/replay", ReplayRun)
+
+	//调度批次的执行进度
+	m.Get("/runs", GetExecSchedule)
+	m.Delete("/runs", CancelRun)
+	m.Get("/runs/tasks", GetTaskResults)
+
+	//Worker池
+	m.Get("/workers", GetWorkers)
+	m.Put("/workers", UpdateWorkers)
+
+	//资源池的使用情况
+	m.Get("/pools", GetResourcePools)
+
+	//同时执行的调度批次数量
+	m.Get("/concurrency", GetConcurrency)
+	m.Put("/concurrency", SetConcurrency)
+
+	//Prometheus格式的监控指标
+	m.Get("/metrics", GetMetrics)
+
+	//就绪探针，检查是否有可用的Worker
+	m.Get("/health/workers", WorkerHealth)
+
+	//运行期间修改日志级别
+	m.Put("/loglevel", SetLogLevel)
+
 } // }}}
 
-//返回当前的调度列表
-func GetSchedules(r render.Render, Ss *schedule.ScheduleManager) { // {{{
-	r.JSON(200, Ss.ScheduleList)
+//返回当前的调度列表，参数tag不为空时只返回带有该标签的调度
+func GetSchedules(req *http.Request, r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	if tag := req.FormValue("tag"); tag != "" {
+		r.JSON(200, Ss.ListByTag(tag))
+		return
+	}
+	r.JSON(200, Ss.AllSchedules())
 	return
 } // }}}
 
@@ -86,11 +129,9 @@ func GetSchedules(r render.Render, Ss *schedule.ScheduleManager) { // {{{
 func GetScheduleById(params martini.Params, r render.Render, Ss *schedule.ScheduleManager) { // {{{
 	if i, ok := params["id"]; ok {
 		id, _ := strconv.Atoi(i)
-		for _, s := range Ss.ScheduleList {
-			if s.Id == int64(id) {
-				r.JSON(200, s)
-				return
-			}
+		if s := Ss.GetScheduleById(int64(id)); s != nil {
+			r.JSON(200, s)
+			return
 		}
 	}
 
@@ -108,11 +149,11 @@ func AddSchedule(params martini.Params, r render.Render, Ss *schedule.ScheduleMa
 		return
 	}
 
-	err := Ss.AddSchedule(&scd)
+	_, err := Ss.AddSchedule(&scd)
 	if err != nil {
 		e := fmt.Sprintf("[AddSchedule] add schedule error %s.", err.Error())
 		g.L.Warningln(e)
-		r.JSON(500, e)
+		r.JSON(errStatus(err), e)
 		return
 	}
 
@@ -132,11 +173,17 @@ func UpdateSchedule(params martini.Params, r render.Render, Ss *schedule.Schedul
 	}
 	if s := Ss.GetScheduleById(int64(scd.Id)); s != nil {
 		s.Name, s.Desc, s.Cyc, s.StartMonth = scd.Name, scd.Desc, scd.Cyc, scd.StartMonth
-		s.StartSecond, s.ModifyTime, s.ModifyUserId = scd.StartSecond, time.Now(), scd.ModifyUserId
+		s.StartSecond, s.StartWeekday, s.ModifyTime, s.ModifyUserId = scd.StartSecond, scd.StartWeekday, time.Now(), scd.ModifyUserId
+		s.WarmupTaskId, s.Group = scd.WarmupTaskId, scd.Group
+		s.TimeOut, s.SoftTimeOut, s.Overlap, s.Misfire = scd.TimeOut, scd.SoftTimeOut, scd.Overlap, scd.Misfire
+		s.TimeZone, s.Tags, s.Enabled, s.Params = scd.TimeZone, scd.Tags, scd.Enabled, scd.Params
+		s.StartJitter = scd.StartJitter
+		s.ScheduleRetryCount, s.ScheduleRetryDelay = scd.ScheduleRetryCount, scd.ScheduleRetryDelay
+		s.OnStartupPolicy, s.Priority = scd.OnStartupPolicy, scd.Priority
 		if err := s.UpdateSchedule(); err != nil {
 			e := fmt.Sprintf("[UpdateSchedule] update schedule error %s.", err.Error())
 			g.L.Warningln(e)
-			r.JSON(500, e)
+			r.JSON(errStatus(err), e)
 			return
 		} else {
 			r.JSON(200, s)
@@ -150,7 +197,9 @@ func UpdateSchedule(params martini.Params, r render.Render, Ss *schedule.Schedul
 } // }}}
 
 //调用Schedule的DeleteJob方法删除作业
-func DeleteJob(params martini.Params, r render.Render, Ss *schedule.ScheduleManager) { // {{{
+//参数relink为true时调用DeleteJobAndRelink删除调度链中任意位置的作业，
+//参数force为true时将作业下的任务迁移至相邻的作业
+func DeleteJob(params martini.Params, req *http.Request, r render.Render, Ss *schedule.ScheduleManager) { // {{{
 
 	sid, sidok := params["sid"]
 	id, idok := params["id"]
@@ -166,10 +215,16 @@ func DeleteJob(params martini.Params, r render.Render, Ss *schedule.ScheduleMana
 	iid, _ := strconv.Atoi(id)
 
 	if s := Ss.GetScheduleById(int64(ssid)); s != nil {
-		if err := s.DeleteJob(int64(iid)); err != nil {
+		var err error
+		if req.URL.Query().Get("relink") == "true" {
+			err = s.DeleteJobAndRelink(int64(iid), req.URL.Query().Get("force") == "true")
+		} else {
+			err = s.DeleteJob(int64(iid))
+		}
+		if err != nil {
 			e := fmt.Sprintf("[DeleteJob] delete job error %s.", err.Error())
 			g.L.Warningln(e)
-			r.JSON(500, e)
+			r.JSON(errStatus(err), e)
 			return
 		} else {
 			e := fmt.Sprintf("[DeleteJob] delete job success.")
@@ -181,10 +236,10 @@ func DeleteJob(params martini.Params, r render.Render, Ss *schedule.ScheduleMana
 } // }}}
 
 //addJob获取客户端发送的Job信息，并调用Schedule的AddJob方法将其
-//持久化并添加至Schedule中。
+//持久化并添加至Schedule中，参数after不为空时调用InsertJobAfter插入到该作业之后。
 //成功返回添加好的Job信息
 //错误返回err信息
-func AddJob(r render.Render, Ss *schedule.ScheduleManager, job schedule.Job) { // {{{
+func AddJob(req *http.Request, r render.Render, Ss *schedule.ScheduleManager, job schedule.Job) { // {{{
 	if job.Name == "" {
 		e := fmt.Sprintf("[AddJob] Job name is required")
 		g.L.Warningln(e)
@@ -197,10 +252,16 @@ func AddJob(r render.Render, Ss *schedule.ScheduleManager, job schedule.Job) { /
 		job.ModifyUserId = 1
 		job.CreateTime = time.Now()
 		job.ModifyTime = time.Now()
-		if err := s.AddJob(&job); err != nil {
+		var err error
+		if after, _ := strconv.Atoi(req.URL.Query().Get("after")); after != 0 {
+			err = s.InsertJobAfter(int64(after), &job)
+		} else {
+			_, err = s.AddJob(&job)
+		}
+		if err != nil {
 			e := fmt.Sprintf("[AddJob] add job error %s.", err.Error())
 			g.L.Warningln(e)
-			r.JSON(500, e)
+			r.JSON(errStatus(err), e)
 			return
 		} else {
 			r.JSON(200, job)
@@ -227,7 +288,7 @@ func UpdateJob(r render.Render, Ss *schedule.ScheduleManager, job schedule.Job)
 		if err := s.UpdateJob(&job); err != nil {
 			e := fmt.Sprintf("[UpdateJob] update job error %s.", err.Error())
 			g.L.Warningln(e)
-			r.JSON(500, e)
+			r.JSON(errStatus(err), e)
 			return
 		} else {
 			r.JSON(200, job)
@@ -267,7 +328,7 @@ func AddTask(params martini.Params, r render.Render, Ss *schedule.ScheduleManage
 		if err != nil {
 			e := fmt.Sprintf("[AddTask] add task error %s.", err.Error())
 			g.L.Warningln(e)
-			r.JSON(500, e)
+			r.JSON(errStatus(err), e)
 			return
 		}
 	}
@@ -292,7 +353,7 @@ func DeleteTask(params martini.Params, r render.Render, Ss *schedule.ScheduleMan
 		if err := s.DeleteTask(int64(id)); err != nil {
 			e := fmt.Sprintf("[Delete Task] delete task error %s.", err.Error())
 			g.L.Warningln(e)
-			r.JSON(500, e)
+			r.JSON(errStatus(err), e)
 			return
 		} else {
 			r.JSON(200, nil)
@@ -321,7 +382,7 @@ func UpdateTask(params martini.Params, r render.Render, Ss *schedule.ScheduleMan
 		if err != nil {
 			e := fmt.Sprintf("[UpdateTask] get job error %s.", err.Error())
 			g.L.Warningln(e)
-			r.JSON(500, e)
+			r.JSON(errStatus(err), e)
 			return
 		}
 
@@ -333,7 +394,7 @@ func UpdateTask(params martini.Params, r render.Render, Ss *schedule.ScheduleMan
 	} else {
 		e := fmt.Sprintf("[UpdateTask] update task error %s.", err.Error())
 		g.L.Warningln(e)
-		r.JSON(500, e)
+		r.JSON(errStatus(err), e)
 		return
 	}
 
@@ -374,13 +435,104 @@ func DeleteSchedule(params martini.Params, ctx *web.Context, r render.Render, Ss
 	if err := Ss.DeleteSchedule(int64(id)); err != nil {
 		e := fmt.Sprintf("[DeleteSchedule] delete schedule error %s.", err.Error())
 		g.L.Warningln(e)
-		r.JSON(500, e)
+		r.JSON(errStatus(err), e)
 		return
 	}
 	r.JSON(200, nil)
 
 } // }}}
 
+//PauseSchedule暂停指定的调度，调度信息保留，可通过ResumeSchedule恢复
+func PauseSchedule(params martini.Params, r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	id, _ := strconv.Atoi(params["id"])
+
+	if err := Ss.PauseScheduleById(int64(id)); err != nil {
+		e := fmt.Sprintf("[PauseSchedule] pause schedule error %s.", err.Error())
+		g.L.Warningln(e)
+		r.JSON(errStatus(err), e)
+		return
+	}
+	r.JSON(200, Ss.GetScheduleById(int64(id)))
+
+} // }}}
+
+//ResumeSchedule恢复暂停的调度
+func ResumeSchedule(params martini.Params, r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	id, _ := strconv.Atoi(params["id"])
+
+	if err := Ss.ResumeScheduleById(int64(id)); err != nil {
+		e := fmt.Sprintf("[ResumeSchedule] resume schedule error %s.", err.Error())
+		g.L.Warningln(e)
+		r.JSON(errStatus(err), e)
+		return
+	}
+	r.JSON(200, Ss.GetScheduleById(int64(id)))
+
+} // }}}
+
+//ReloadSchedule从元数据库重新加载调度
+func ReloadSchedule(params martini.Params, r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	id, _ := strconv.Atoi(params["id"])
+
+	if err := Ss.ReloadSchedule(int64(id)); err != nil {
+		e := fmt.Sprintf("[ReloadSchedule] reload schedule error %s.", err.Error())
+		g.L.Warningln(e)
+		r.JSON(errStatus(err), e)
+		return
+	}
+	r.JSON(200, Ss.GetScheduleById(int64(id)))
+
+} // }}}
+
+//RunSchedule立即手动执行调度，返回本次执行的批次ID
+func RunSchedule(params martini.Params, r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	id, _ := strconv.Atoi(params["id"])
+
+	batchId, err := Ss.RunScheduleNow(int64(id))
+	if err != nil {
+		e := fmt.Sprintf("[RunSchedule] run schedule error %s.", err.Error())
+		g.L.Warningln(e)
+		r.JSON(errStatus(err), e)
+		return
+	}
+	r.JSON(200, map[string]string{"batchId": batchId})
+
+} // }}}
+
+//BackfillSchedule在后台补齐调度在参数from、to（RFC3339格式）之间错过的执行，
+//补数的结果记录在日志中，中止正在补数的批次（DELETE /runs）时停止补数
+func BackfillSchedule(params martini.Params, req *http.Request, r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	id, _ := strconv.Atoi(params["id"])
+	from, err := time.Parse(time.RFC3339, req.URL.Query().Get("from"))
+	if err != nil {
+		e := fmt.Sprintf("[BackfillSchedule] bad from %s.", err.Error())
+		g.L.Warningln(e)
+		r.JSON(500, e)
+		return
+	}
+	to, err := time.Parse(time.RFC3339, req.URL.Query().Get("to"))
+	if err != nil {
+		e := fmt.Sprintf("[BackfillSchedule] bad to %s.", err.Error())
+		g.L.Warningln(e)
+		r.JSON(500, e)
+		return
+	}
+	if Ss.GetScheduleById(int64(id)) == nil || !from.Before(to) {
+		e := fmt.Sprintf("[BackfillSchedule] not found schedule %d or from %s is not before to %s.", id, from, to)
+		g.L.Warningln(e)
+		r.JSON(500, e)
+		return
+	}
+
+	go func() {
+		if err := Ss.Backfill(context.Background(), int64(id), from, to); err != nil {
+			g.L.Warningln(fmt.Sprintf("[BackfillSchedule] %s", err.Error()))
+		}
+	}()
+	r.JSON(202, map[string]string{"from": from.String(), "to": to.String()})
+
+} // }}}
+
 //addRelTask根据Url参数获取到要添加的Task关系
 func AddRelTask(params martini.Params, ctx *web.Context, r render.Render, Ss *schedule.ScheduleManager) { // {{{
 	sid, _ := strconv.Atoi(params["sid"])
@@ -410,7 +562,7 @@ func AddRelTask(params martini.Params, ctx *web.Context, r render.Render, Ss *sc
 		if err != nil {
 			e := fmt.Sprintf("[AddRelTask] add task is error %s.", err.Error())
 			g.L.Warningln(e)
-			r.JSON(500, e)
+			r.JSON(errStatus(err), e)
 			return
 		}
 		r.JSON(200, t)
@@ -445,7 +597,7 @@ func DeleteRelTask(params martini.Params, ctx *web.Context, r render.Render, Ss
 		if err != nil {
 			e := fmt.Sprintf("[DeleteRelTask] delete task is error %s.", err.Error())
 			g.L.Warningln(e)
-			r.JSON(500, e)
+			r.JSON(errStatus(err), e)
 			return
 		}
 		r.JSON(200, t)
@@ -453,6 +605,216 @@ func DeleteRelTask(params martini.Params, ctx *web.Context, r render.Render, Ss
 
 } // }}}
 
+//GetTaskArtifacts根据参数batch（批次ID）、task（任务ID），返回任务登记的产出物引用
+func GetTaskArtifacts(req *http.Request, r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	batchId := req.URL.Query().Get("batch")
+	id, _ := strconv.Atoi(req.URL.Query().Get("task"))
+
+	if batchId == "" || id == 0 {
+		e := fmt.Sprintf("[GetTaskArtifacts] [batch task] is required")
+		g.L.Warningln(e)
+		r.JSON(500, e)
+		return
+	}
+
+	artifacts, err := Ss.GetTaskArtifacts(batchId, int64(id))
+	if err != nil {
+		e := fmt.Sprintf("[GetTaskArtifacts] get artifacts error %s.", err.Error())
+		g.L.Warningln(e)
+		r.JSON(errStatus(err), e)
+		return
+	}
+	r.JSON(200, artifacts)
+
+} // }}}
+
+//GetExecSchedule根据参数batch（批次ID）返回该批次的执行进度
+func GetExecSchedule(req *http.Request, r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	batchId := req.URL.Query().Get("batch")
+	if batchId == "" {
+		e := fmt.Sprintf("[GetExecSchedule] batch is required")
+		g.L.Warningln(e)
+		r.JSON(500, e)
+		return
+	}
+
+	info, err := Ss.GetExecSchedule(batchId)
+	if err != nil {
+		e := fmt.Sprintf("[GetExecSchedule] get run error %s.", err.Error())
+		g.L.Warningln(e)
+		r.JSON(404, e)
+		return
+	}
+	r.JSON(200, info)
+
+} // }}}
+
+//CancelRun根据参数batch（批次ID）中止正在执行的批次
+func CancelRun(req *http.Request, r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	batchId := req.URL.Query().Get("batch")
+	if batchId == "" {
+		e := fmt.Sprintf("[CancelRun] batch is required")
+		g.L.Warningln(e)
+		r.JSON(500, e)
+		return
+	}
+
+	if err := Ss.CancelRun(batchId); err != nil {
+		e := fmt.Sprintf("[CancelRun] cancel run error %s.", err.Error())
+		g.L.Warningln(e)
+		r.JSON(404, e)
+		return
+	}
+	r.JSON(202, batchId)
+} // }}}
+
+//GetTaskResults根据参数batch（批次ID）返回该批次中各任务的执行结果
+func GetTaskResults(req *http.Request, r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	batchId := req.URL.Query().Get("batch")
+	if batchId == "" {
+		e := fmt.Sprintf("[GetTaskResults] batch is required")
+		g.L.Warningln(e)
+		r.JSON(500, e)
+		return
+	}
+
+	results, err := Ss.GetTaskResults(batchId)
+	if err != nil {
+		e := fmt.Sprintf("[GetTaskResults] get task results error %s.", err.Error())
+		g.L.Warningln(e)
+		r.JSON(404, e)
+		return
+	}
+	r.JSON(200, results)
+
+} // }}}
+
+//ReplayRun根据参数batch（批次ID）返回该批次执行过程的时间线，
+//参数format为text时按行输出文本，否则返回JSON
+func ReplayRun(req *http.Request, res http.ResponseWriter, r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	batchId := req.URL.Query().Get("batch")
+	if batchId == "" {
+		e := fmt.Sprintf("[ReplayRun] batch is required")
+		g.L.Warningln(e)
+		r.JSON(500, e)
+		return
+	}
+
+	tl, err := Ss.ReplayRun(batchId)
+	if err != nil {
+		e := fmt.Sprintf("[ReplayRun] replay error %s.", err.Error())
+		g.L.Warningln(e)
+		r.JSON(errStatus(err), e)
+		return
+	}
+
+	if req.URL.Query().Get("format") == "text" {
+		res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		res.Write([]byte(tl.String()))
+		return
+	}
+	r.JSON(200, tl)
+
+} // }}}
+
+//GetWorkers返回可分配任务的Worker、排空中的Worker和被标记为不可用的Worker
+func GetWorkers(r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	active, draining := Ss.Workers()
+	r.JSON(200, map[string][]string{"active": active, "draining": draining, "down": Ss.DownWorkers()})
+} // }}}
+
+//GetResourcePools返回全部资源池的容量、占用与等待数量
+func GetResourcePools(r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	r.JSON(200, Ss.ResourcePoolUsage())
+} // }}}
+
+//GetConcurrency返回同时执行的调度批次的上限、执行中与等待中的数量
+func GetConcurrency(r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	r.JSON(200, Ss.ConcurrentSchedules())
+} // }}}
+
+//SetConcurrency根据参数max调整同时执行的调度批次数量上限，小于等于0表示不限制
+func SetConcurrency(req *http.Request, r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	n, err := strconv.Atoi(req.URL.Query().Get("max"))
+	if err != nil {
+		e := fmt.Sprintf("[SetConcurrency] parse max error %s.", err.Error())
+		g.L.Warningln(e)
+		r.JSON(400, e)
+		return
+	}
+	Ss.SetMaxConcurrentSchedules(n)
+	GetConcurrency(r, Ss)
+} // }}}
+
+//UpdateWorkers使用请求中JSON格式的地址列表替换Worker池的Worker列表
+func UpdateWorkers(req *http.Request, r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	addrs := make([]string, 0)
+	if err := json.NewDecoder(req.Body).Decode(&addrs); err != nil {
+		e := fmt.Sprintf("[UpdateWorkers] decode workers error %s.", err.Error())
+		g.L.Warningln(e)
+		r.JSON(500, e)
+		return
+	}
+
+	if err := Ss.UpdateWorkers(addrs); err != nil {
+		e := fmt.Sprintf("[UpdateWorkers] update workers error %s.", err.Error())
+		g.L.Warningln(e)
+		r.JSON(errStatus(err), e)
+		return
+	}
+	GetWorkers(r, Ss)
+} // }}}
+
+//SetLogLevel根据参数level修改日志级别，取值见schedule.ParseLogLevel
+func SetLogLevel(req *http.Request, r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	level := req.URL.Query().Get("level")
+	if err := Ss.Global.SetLogLevel(level); err != nil {
+		e := fmt.Sprintf("[SetLogLevel] set log level error %s.", err.Error())
+		g.L.Warningln(e)
+		r.JSON(400, e)
+		return
+	}
+	r.JSON(200, Ss.Global.L.Level.String())
+} // }}}
+
+//GetMetrics按Prometheus文本格式返回调度的监控指标
+func GetMetrics(res http.ResponseWriter, Ss *schedule.ScheduleManager) { // {{{
+	res.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := Ss.WriteCatalogMetrics(res); err != nil {
+		g.L.Warningln(fmt.Sprintf("[GetMetrics] write metrics error %s.", err.Error()))
+	}
+	if err := metrics.WriteText(res, g.Registry); err != nil {
+		g.L.Warningln(fmt.Sprintf("[GetMetrics] write metrics error %s.", err.Error()))
+	}
+
+} // }}}
+
+//WorkerHealth检查是否有可用的Worker，不可用时返回503
+func WorkerHealth(r render.Render, Ss *schedule.ScheduleManager) { // {{{
+	if err := Ss.WorkerHealthCheck(); err != nil {
+		e := fmt.Sprintf("[WorkerHealth] %s", err.Error())
+		g.L.Warningln(e)
+		r.JSON(503, e)
+		return
+	}
+	r.JSON(200, "ok")
+} // }}}
+
+//errStatus按调度返回的错误类型确定HTTP状态码，未分类的错误返回500
+func errStatus(err error) int { // {{{
+	switch schedule.ErrorCodeOf(err) {
+	case schedule.CodeNotFound:
+		return http.StatusNotFound
+	case schedule.CodeInvalid:
+		return http.StatusBadRequest
+	case schedule.CodeConflict:
+		return http.StatusConflict
+	case schedule.CodeShutdown:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+} // }}}
+
 func Logger() martini.Handler { // {{{
 	return func(res http.ResponseWriter, req *http.Request, ctx martini.Context, log *log.Logger) {
 
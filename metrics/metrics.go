@@ -0,0 +1,223 @@
+//metrics包记录调度执行情况的Prometheus指标：
+//
+//	schedule_runs_total          调度批次的执行次数，标签为调度ID（schedule）与执行结果（outcome）
+//	schedule_duration_seconds    调度批次的执行时间，标签为调度ID
+//	tasks_running                正在执行的任务数量
+//	schedules_running            正在执行的调度批次数量
+//	exec_schedules               执行列表中的调度批次数量，包括等待开始的批次，持续增长说明有批次未被移除
+//	schedule_throttled_total     因同时执行的批次达到上限而等待或放弃的批次数量
+//	schedules_waiting            等待并发位置的调度批次数量，即等待队列的长度
+//	worker_conn_rejected_total   与Worker建立连接时TLS握手或认证失败的次数，标签为失败的原因（reason）
+//	schedule_timer_errors_total  计算下次启动时间失败、调度不再启动的次数，标签为调度ID
+//	panics_recovered_total       调度批次或任务执行中发生并已恢复的panic次数，标签为发生的位置（where）
+//
+//未设置Registry时New返回nil，nil的*Metrics上调用记录方法不做任何处理，
+//测试或未开启监控时不会产生额外的开销。
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+//调度批次的执行结果，作为schedule_runs_total的outcome标签
+const (
+	OutcomeSuccess = "success" //全部任务执行成功
+	OutcomeFail    = "fail"    //执行结束，但有任务执行失败
+	OutcomeError   = "error"   //批次异常中止，如超时、启动失败
+)
+
+//与Worker建立连接失败的原因，作为worker_conn_rejected_total的reason标签
+const (
+	RejectTLS  = "tls"  //TLS握手失败，如证书无法验证
+	RejectAuth = "auth" //Worker拒绝了认证信息
+)
+
+//发生panic的位置，作为panics_recovered_total的where标签
+const (
+	PanicRun  = "run"  //调度批次的执行，批次意外中止
+	PanicTask = "task" //任务的执行，任务意外中止
+)
+
+//调度执行的指标
+type Metrics struct { // {{{
+	runs     *prometheus.CounterVec   //调度批次的执行次数
+	duration *prometheus.HistogramVec //调度批次的执行时间
+	running  prometheus.Gauge         //正在执行的任务数量
+	batches  prometheus.Gauge         //正在执行的调度批次数量
+	listed   prometheus.Gauge         //执行列表中的调度批次数量
+	throttle prometheus.Counter       //因并发上限而等待或放弃的批次数量
+	waiting  prometheus.Gauge         //等待并发位置的调度批次数量
+	rejected *prometheus.CounterVec   //与Worker建立连接时TLS握手或认证失败的次数
+	timerErr *prometheus.CounterVec   //计算下次启动时间失败的次数
+	panics   *prometheus.CounterVec   //已恢复的panic次数
+} // }}}
+
+//New创建调度执行的指标并注册到reg中，reg为nil时返回nil，表示不记录指标。
+//同一reg只能注册一次，重复注册会panic。
+func New(reg *prometheus.Registry) *Metrics { // {{{
+	if reg == nil {
+		return nil
+	}
+
+	m := &Metrics{
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "schedule_runs_total",
+			Help: "Number of schedule runs by schedule id and outcome.",
+		}, []string{"schedule", "outcome"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "schedule_duration_seconds",
+			Help:    "Duration of schedule runs in seconds.",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+		}, []string{"schedule"}),
+		running: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "tasks_running",
+			Help: "Number of tasks being executed.",
+		}),
+		batches: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "schedules_running",
+			Help: "Number of schedule runs being executed.",
+		}),
+		listed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "exec_schedules",
+			Help: "Number of schedule runs in the execution list, including runs waiting to start.",
+		}),
+		throttle: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "schedule_throttled_total",
+			Help: "Number of schedule runs that waited or were dropped by the concurrency limit.",
+		}),
+		waiting: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "schedules_waiting",
+			Help: "Number of schedule runs waiting for a slot under the concurrency limit.",
+		}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "worker_conn_rejected_total",
+			Help: "Number of worker connections that failed the TLS handshake or authentication.",
+		}, []string{"reason"}),
+		timerErr: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "schedule_timer_errors_total",
+			Help: "Number of times the next start of a schedule could not be computed by schedule id.",
+		}, []string{"schedule"}),
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "panics_recovered_total",
+			Help: "Number of panics recovered in schedule runs and tasks by where they occurred.",
+		}, []string{"where"}),
+	}
+	reg.MustRegister(m.runs, m.duration, m.running, m.batches, m.listed, m.throttle, m.waiting, m.rejected, m.timerErr, m.panics)
+	return m
+} // }}}
+
+//RunDone记录调度id的一个批次执行结束，outcome取值见OutcomeSuccess、OutcomeFail、OutcomeError，
+//d为批次的执行时间，批次未开始执行时为0，不计入执行时间。
+func (m *Metrics) RunDone(id int64, outcome string, d time.Duration) { // {{{
+	if m == nil {
+		return
+	}
+
+	sid := strconv.FormatInt(id, 10)
+	m.runs.WithLabelValues(sid, outcome).Inc()
+	if d > 0 {
+		m.duration.WithLabelValues(sid).Observe(d.Seconds())
+	}
+} // }}}
+
+//TaskStart记录一个任务开始执行
+func (m *Metrics) TaskStart() { // {{{
+	if m == nil {
+		return
+	}
+	m.running.Inc()
+} // }}}
+
+//TaskEnd记录一个任务执行结束
+func (m *Metrics) TaskEnd() { // {{{
+	if m == nil {
+		return
+	}
+	m.running.Dec()
+} // }}}
+
+//ScheduleStart记录一个调度批次开始执行
+func (m *Metrics) ScheduleStart() { // {{{
+	if m == nil {
+		return
+	}
+	m.batches.Inc()
+} // }}}
+
+//ScheduleEnd记录一个调度批次执行结束
+func (m *Metrics) ScheduleEnd() { // {{{
+	if m == nil {
+		return
+	}
+	m.batches.Dec()
+} // }}}
+
+//ExecSchedules记录执行列表中的调度批次数量
+func (m *Metrics) ExecSchedules(n int) { // {{{
+	if m == nil {
+		return
+	}
+	m.listed.Set(float64(n))
+} // }}}
+
+//ScheduleThrottled记录一个调度批次因并发上限而等待或放弃
+func (m *Metrics) ScheduleThrottled() { // {{{
+	if m == nil {
+		return
+	}
+	m.throttle.Inc()
+} // }}}
+
+//SchedulesWaiting记录等待并发位置的调度批次数量
+func (m *Metrics) SchedulesWaiting(n int) { // {{{
+	if m == nil {
+		return
+	}
+	m.waiting.Set(float64(n))
+} // }}}
+
+//WorkerRejected记录一次与Worker建立连接失败，reason取值见RejectTLS、RejectAuth
+func (m *Metrics) WorkerRejected(reason string) { // {{{
+	if m == nil {
+		return
+	}
+	m.rejected.WithLabelValues(reason).Inc()
+} // }}}
+
+//TimerError记录调度id计算下次启动时间失败一次
+func (m *Metrics) TimerError(id int64) { // {{{
+	if m == nil {
+		return
+	}
+	m.timerErr.WithLabelValues(strconv.FormatInt(id, 10)).Inc()
+} // }}}
+
+//Panic记录一次已恢复的panic，where取值见PanicRun、PanicTask
+func (m *Metrics) Panic(where string) { // {{{
+	if m == nil {
+		return
+	}
+	m.panics.WithLabelValues(where).Inc()
+} // }}}
+
+//WriteText将reg中的全部指标按Prometheus文本格式写入w，reg为nil时不输出。
+func WriteText(w io.Writer, reg *prometheus.Registry) error { // {{{
+	if reg == nil {
+		return nil
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		return err
+	}
+	for _, mf := range mfs {
+		if _, err = expfmt.MetricFamilyToText(w, mf); err != nil {
+			return err
+		}
+	}
+	return nil
+} // }}}
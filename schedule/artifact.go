@@ -0,0 +1,90 @@
+package schedule
+
+import (
+	"regexp"
+	"time"
+)
+
+//任务产出物的引用。
+//产出物本身（文件、数据集等）保存在外部存储中，调度只记录它的名称和地址。
+//
+//任务在标准输出中按以下格式输出一行即可登记一个产出物，同名的产出物以最后一次为准：
+//
+//	##artifact <name> <uri>
+//	##artifact orders s3://warehouse/etl/2015-01-01/orders.csv
+//
+//name由字母、数字、下划线、中划线组成，uri为任意不含空白的地址。
+//下级任务在参数中使用${artifact:<任务名称>.<name>}引用同一批次中已完成任务的产出物，
+//任务发送执行前会替换为对应的uri，找不到时保留原样并记录警告。
+//
+//产出物的引用随任务日志保存在scd_task_artifact表中，生命周期与执行日志相同；
+//调度不会读取、复制或删除产出物本身，外部存储中的清理由产出方负责。
+type Artifact struct { // {{{
+	BatchId    string    //批次ID
+	TaskId     int64     //任务ID
+	Name       string    //产出物名称
+	Uri        string    //产出物的外部存储地址
+	CreateTime time.Time //登记时间
+} // }}}
+
+var (
+	artifactLine = regexp.MustCompile(`(?m)^##artifact[ \t]+([A-Za-z0-9_-]+)[ \t]+(\S+)[ \t]*$`)
+	artifactRef  = regexp.MustCompile(`\$\{artifact:([^.}]+)\.([A-Za-z0-9_-]+)\}`)
+)
+
+//parseArtifacts从任务的输出中解析登记的产出物，返回名称与地址的对应关系。
+func parseArtifacts(output string) map[string]string { // {{{
+	artifacts := make(map[string]string)
+	for _, m := range artifactLine.FindAllStringSubmatch(output, -1) {
+		artifacts[m[1]] = m[2]
+	}
+	return artifacts
+} // }}}
+
+//addArtifacts登记已完成任务的产出物，供同一批次中的下级任务引用。
+func (es *ExecSchedule) addArtifacts(et *ExecTask) { // {{{
+	if len(et.artifacts) == 0 {
+		return
+	}
+
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	if es.artifacts == nil {
+		es.artifacts = make(map[string]string)
+	}
+	for name, uri := range et.artifacts {
+		es.artifacts[et.task.Name+"."+name] = uri
+	}
+} // }}}
+
+//resolveParam将参数中对产出物的引用替换为产出物的地址，对批次逻辑时间的引用替换为批次的启动时间。
+func (es *ExecSchedule) resolveParam(et *ExecTask) []string { // {{{
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	param := make([]string, 0, len(et.task.Param))
+	for _, p := range et.task.Param {
+		p = es.resolveWindow(p)
+		p = artifactRef.ReplaceAllStringFunc(p, func(ref string) string {
+			m := artifactRef.FindStringSubmatch(ref)
+			if uri, ok := es.artifacts[m[1]+"."+m[2]]; ok {
+				return uri
+			}
+			es.log.Warningln("[es.resolveParam] task", et.task.Name, "batchTaskId[", et.batchTaskId,
+				"] artifact", ref, "is not found")
+			return ref
+		})
+		param = append(param, p)
+	}
+
+	return param
+} // }}}
+
+//GetTaskArtifacts返回指定批次中某个任务登记的产出物引用，按名称排序。
+func (sl *ScheduleManager) GetTaskArtifacts(batchId string, taskId int64) ([]Artifact, error) { // {{{
+	artifacts, err := getTaskArtifacts(batchId, taskId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.GetTaskArtifacts] %s", err.Error())
+	}
+	return artifacts, nil
+} // }}}
@@ -0,0 +1,162 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+//一次补数最多执行的批次数量，避免按秒、分调度的调度在较长的时间范围内生成过多的批次
+const BackfillLimit = 1000
+
+//任务参数中对批次逻辑时间的引用，${window}替换为"2006-01-02 15:04:05"格式的时间，
+//${window:<layout>}按Go的时间格式layout替换，例如${window:20060102}。
+//补数执行时为补数的启动时间，其余批次为批次的开始时间。
+var windowRef = regexp.MustCompile(`\$\{window(?::([^}]+))?\}`)
+
+//windowLayout为${window}默认的时间格式
+const windowLayout = "2006-01-02 15:04:05"
+
+//Windows返回调度在[from, to)内应当启动的时间，按时间先后排序，计算方式与Timer相同。
+//超过BackfillLimit个时返回error。
+func (s *Schedule) Windows(from, to time.Time) ([]time.Time, error) { // {{{
+	loc, err := s.location()
+	if err != nil {
+		return nil, newError(CodeInvalid, err, "\n[s.Windows] %s", err.Error())
+	}
+	anchor := s.intervalAnchor(loc)
+	sm, ss := s.starts()
+
+	windows := make([]time.Time, 0)
+	//nextStart返回晚于参数的启动时间，从from之前开始计算以包含from本身
+	for t := from.Add(-time.Nanosecond); ; {
+		next, err := nextStart(s.Cyc, sm, ss, loc, anchor, t)
+		if err != nil {
+			return nil, newError(CodeInvalid, err, "\n[s.Windows] schedule [%d %s] %s", s.Id, s.Name, err.Error())
+		}
+		if !next.After(t) {
+			return nil, newError(CodeInvalid, nil, "\n[s.Windows] schedule [%d %s] cycle [%s] is not supported.", s.Id, s.Name, s.Cyc)
+		}
+		if !next.Before(to) {
+			break
+		}
+		if len(windows) == BackfillLimit {
+			return nil, newError(CodeInvalid, nil, "\n[s.Windows] schedule [%d %s] has more than %d windows between %s and %s.",
+				s.Id, s.Name, BackfillLimit, from, to)
+		}
+		windows = append(windows, next)
+		t = next
+	}
+
+	return windows, nil
+} // }}}
+
+//Backfill补齐调度在[from, to)内错过的执行，例如调度服务停止期间应当启动的批次。
+//按调度的周期与启动时间列出这段时间内的启动时间，按先后顺序逐个执行，上一批次结束后再启动下一个。
+//批次的执行类型为4（补数执行），任务参数中的${window}替换为对应的启动时间而不是当前时间，见windowRef。
+//
+//某个批次执行失败时停止补数并返回error，错误信息中包含该批次的启动时间，修复后可从该时间继续补数。
+//ctx被取消时中止正在执行的批次（同CancelRun），不再启动之后的批次，返回ctx的错误。
+//补数不影响调度的定时启动，与定时启动的批次可能同时执行。
+func (sl *ScheduleManager) Backfill(ctx context.Context, id int64, from, to time.Time) error { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return err
+	}
+	s := sl.GetScheduleById(id)
+	if s == nil {
+		return newError(CodeNotFound, nil, "\n[sl.Backfill] not found schedule by id %d", id)
+	}
+	if !from.Before(to) {
+		return newError(CodeInvalid, nil, "\n[sl.Backfill] from %s must be before to %s.", from, to)
+	}
+
+	windows, err := s.Windows(from, to)
+	if err != nil {
+		return newError(CodeInvalid, err, "\n[sl.Backfill] %s", err.Error())
+	}
+	log := s.logEntry()
+	log.Infoln(fmt.Sprintf("[sl.Backfill] schedule [%d %s] backfill %d windows between %s and %s.",
+		id, s.Name, len(windows), from, to))
+
+	for _, w := range windows {
+		if err := ctx.Err(); err != nil {
+			return newError(CodeUnknown, err, "\n[sl.Backfill] schedule [%d %s] backfill is cancelled before window %s. %s", id, s.Name, w, err.Error())
+		}
+
+		es, err := sl.backfillRun(ctx, s, w)
+		if err != nil {
+			return newError(CodeUnknown, err, "\n[sl.Backfill] schedule [%d %s] window %s %s", id, s.Name, w, err.Error())
+		}
+		if err := ctx.Err(); err != nil {
+			return newError(CodeUnknown, err, "\n[sl.Backfill] schedule [%d %s] backfill is cancelled in window %s batchId=[%s]. %s",
+				id, s.Name, w, es.batchId, err.Error())
+		}
+		if info := es.snapshot(); info.Status != RunSuccess {
+			return newError(CodeUnknown, nil, "\n[sl.Backfill] schedule [%d %s] window %s batchId=[%s] is %s, success=%d fail=%d.",
+				id, s.Name, w, es.batchId, info.Status, info.SuccessTaskCnt, info.FailTaskCnt)
+		}
+		log.Infoln(fmt.Sprintf("[sl.Backfill] schedule [%d %s] window %s batchId=[%s] is done.", id, s.Name, w, es.batchId))
+	}
+
+	return nil
+} // }}}
+
+//backfillRun以启动时间window执行一次调度，等待批次结束后返回。
+//ctx被取消时中止该批次，批次结束后返回。
+func (sl *ScheduleManager) backfillRun(ctx context.Context, s *Schedule, window time.Time) (*ExecSchedule, error) { // {{{
+	//从元数据库加载调度链信息，正在监听的调度不被修改
+	s, err := s.loadCopy()
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.backfillRun] init schedule error %s.", err.Error())
+	}
+	if s.isEmpty() {
+		return nil, newError(CodeInvalid, nil, "\n[sl.backfillRun] schedule [%d %s] has no task.", s.Id, s.Name)
+	}
+
+	es := ExecScheduleWarper(s)
+	es.execType, es.window = 4, window
+	es.log = es.log.WithField(LogFieldWindow, window.Format(windowLayout))
+	sl.AddExecSchedule(es)
+	if err := es.InitExecSchedule(); err != nil {
+		sl.RemoveExecSchedule(es.batchId)
+		return nil, newError(CodeStore, err, "\n[sl.backfillRun] %s", err.Error())
+	}
+	es.publishEvent(EventScheduleFired, 0, es.state, "backfill")
+
+	end := make(chan struct{})
+	go func() {
+		es.Run()
+		close(end)
+	}()
+
+	select {
+	case <-end:
+	case <-ctx.Done():
+		if err := sl.CancelRun(es.batchId); err != nil {
+			s.logEntry().Warningln(fmt.Sprintf("[sl.backfillRun] %s", err.Error()))
+		}
+		<-end
+	}
+	return es, nil
+} // }}}
+
+//logicalTime返回批次的逻辑时间，补数执行时为补数的启动时间，其余批次为批次的开始时间。
+func (es *ExecSchedule) logicalTime() time.Time { // {{{
+	if es.window.IsZero() {
+		return es.startTime
+	}
+	return es.window
+} // }}}
+
+//resolveWindow将参数中对批次逻辑时间的引用替换为批次的启动时间。
+func (es *ExecSchedule) resolveWindow(p string) string { // {{{
+	window := es.logicalTime()
+	return windowRef.ReplaceAllStringFunc(p, func(ref string) string {
+		layout := windowRef.FindStringSubmatch(ref)[1]
+		if layout == "" {
+			layout = windowLayout
+		}
+		return window.Format(layout)
+	})
+} // }}}
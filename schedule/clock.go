@@ -0,0 +1,31 @@
+package schedule
+
+import (
+	"time"
+)
+
+//Clock提供调度计时使用的当前时间与定时器，
+//默认使用系统时间，测试时可通过GlobalConfigStruct.Clock替换为可手动推进的时钟。
+type Clock interface {
+	Now() time.Time                         //当前时间
+	After(d time.Duration) <-chan time.Time //经过d后发送当时的时间
+}
+
+//realClock使用系统时间
+type realClock struct{}
+
+func (realClock) Now() time.Time { // {{{
+	return time.Now()
+} // }}}
+
+func (realClock) After(d time.Duration) <-chan time.Time { // {{{
+	return time.After(d)
+} // }}}
+
+//clock返回调度计时使用的时钟，未设置Clock时使用系统时间
+func (sc *GlobalConfigStruct) clock() Clock { // {{{
+	if sc == nil || sc.Clock == nil {
+		return realClock{}
+	}
+	return sc.Clock
+} // }}}
@@ -0,0 +1,185 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+//CloneSchedule复制id对应的调度为名称为newName的新调度，返回新调度的Id。
+//调度的基本信息、启动列表、标签、运行参数以及调度链中的作业和任务均复制一份并分配新的Id，
+//作业的上下级关系、任务的依赖关系与执行条件、预热任务指向副本中对应的作业和任务。
+//新调度为禁用状态，调整后通过UpdateSchedule启用；依赖的上游调度、触发的下游调度与暂缓时间不复制。
+//全部写入在一个事务中完成，失败时事务回滚，元数据库与ScheduleList均保持不变。
+//任务依赖调度之外的任务时无法复制，返回error信息。
+func (sl *ScheduleManager) CloneSchedule(id int64, newName string) (int64, error) { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return 0, err
+	}
+	if newName == "" {
+		return 0, newError(CodeInvalid, nil, "\n[sl.CloneSchedule] clone schedule [%d] error. name is empty.", id)
+	}
+	s := sl.GetScheduleById(id)
+	if s == nil {
+		return 0, newError(CodeNotFound, nil, "\n[sl.CloneSchedule] not found schedule by id %d", id)
+	}
+
+	ns := s.cloneBase(newName)
+	if sl.Global.UniqueNames {
+		sl.names.Lock()
+		defer sl.names.Unlock()
+		if err := sl.checkName(ns); err != nil {
+			return 0, err
+		}
+	}
+	if err := ns.Validate(); err != nil {
+		return 0, err
+	}
+
+	err := g.store().InTx(func(ms MetaStore) error {
+		if err := ms.AddSchedule(ns); err != nil {
+			return newError(CodeStore, err, "\n[sl.CloneSchedule] %s.", err.Error())
+		}
+		for _, save := range []func(*Schedule) error{ms.SaveScheduleTags, ms.SaveScheduleParams, ms.SaveScheduleStart} {
+			if err := save(ns); err != nil {
+				return newError(CodeStore, err, "\n[sl.CloneSchedule] %s.", err.Error())
+			}
+		}
+		return s.cloneChain(ms, ns)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	//从元数据库初始化副本的调度链后加入ScheduleList，副本为禁用状态，不启动监听
+	cs := &Schedule{Id: ns.Id}
+	if err := cs.InitSchedule(); err != nil {
+		return 0, newError(CodeStore, err, "\n[sl.CloneSchedule] %s.", err.Error())
+	}
+	if cs.isRefresh == nil {
+		cs.isRefresh = make(chan bool)
+	}
+	sl.lock.Lock()
+	sl.ScheduleList = append(sl.ScheduleList, cs)
+	sl.lock.Unlock()
+
+	g.L.Infoln(fmt.Sprintf("[sl.CloneSchedule] schedule [%d %s] is cloned to [%d %s] with %d jobs and %d tasks.",
+		s.Id, s.Name, cs.Id, cs.Name, cs.JobCnt, cs.TaskCnt))
+	return cs.Id, nil
+} // }}}
+
+//cloneBase复制调度的基本信息、启动列表、标签与运行参数，副本为禁用状态，剩余次数重新计算
+func (s *Schedule) cloneBase(name string) *Schedule { // {{{
+	tm := time.Now()
+	return &Schedule{
+		Name:               name,
+		Group:              s.Group,
+		Tags:               copyStrings(s.Tags),
+		Params:             copyParams(s.Params),
+		Enabled:            false,
+		Count:              s.Count,
+		Remain:             int(s.Count),
+		Cyc:                s.Cyc,
+		StartSecond:        append([]time.Duration(nil), s.StartSecond...),
+		StartMonth:         append([]int(nil), s.StartMonth...),
+		StartWeekday:       append([]time.Weekday(nil), s.StartWeekday...),
+		StartJitter:        s.StartJitter,
+		ScheduleRetryCount: s.ScheduleRetryCount,
+		ScheduleRetryDelay: s.ScheduleRetryDelay,
+		OnStartupPolicy:    s.OnStartupPolicy,
+		Priority:           s.Priority,
+		TimeOut:            s.TimeOut,
+		SoftTimeOut:        s.SoftTimeOut,
+		Overlap:            s.Overlap,
+		Misfire:            s.Misfire,
+		TimeZone:           s.TimeZone,
+		Desc:               s.Desc,
+		Jobs:               make([]*Job, 0),
+		Tasks:              make([]*Task, 0),
+		CreateUserId:       s.CreateUserId,
+		CreateTime:         tm,
+		ModifyUserId:       s.ModifyUserId,
+		ModifyTime:         tm,
+	}
+} // }}}
+
+//cloneChain在ms中按调度链的顺序复制s的作业和任务到ns，
+//先增加作业和任务取得新的Id，再按新旧Id的对应关系设置作业的下级作业、任务的依赖与调度的预热任务。
+func (s *Schedule) cloneChain(ms MetaStore, ns *Schedule) error { // {{{
+	tm := time.Now()
+	tasks := make(map[int64]*Task) //原任务Id对应的副本任务
+	olds := make([]*Task, 0, s.TaskCnt)
+	var pj *Job
+	for j := s.Job; j != nil; j = j.NextJob {
+		nj := &Job{
+			ScheduleId:    ns.Id,
+			ScheduleCyc:   ns.Cyc,
+			Name:          j.Name,
+			Desc:          j.Desc,
+			ParallelGroup: j.ParallelGroup,
+			TimeOut:       j.TimeOut,
+			Tasks:         make(map[string]*Task),
+			CreateUserId:  j.CreateUserId,
+			CreateTime:    tm,
+			ModifyUserId:  j.ModifyUserId,
+			ModifyTime:    tm,
+		}
+		if pj != nil {
+			nj.PreJobId = pj.Id
+		}
+		if err := ms.AddJob(nj); err != nil {
+			return newError(CodeStore, err, "\n[s.cloneChain] clone job [%d %s] error %s.", j.Id, j.Name, err.Error())
+		}
+
+		if pj == nil {
+			ns.JobId = nj.Id
+		} else {
+			pj.NextJobId = nj.Id
+			if err := ms.UpdateJob(pj); err != nil {
+				return newError(CodeStore, err, "\n[s.cloneChain] update job [%d] error %s.", pj.Id, err.Error())
+			}
+		}
+
+		for _, t := range j.Tasks {
+			if _, ok := tasks[t.Id]; ok {
+				return newError(CodeConflict, nil, "\n[s.cloneChain] task id [%d] is duplicated in schedule [%d %s].", t.Id, s.Id, s.Name)
+			}
+			nt := *t
+			nt.Id, nt.JobId, nt.ScheduleCyc, nt.BatchTaskId = 0, nj.Id, ns.Cyc, ""
+			nt.Param, nt.Attr, nt.Params = copyStrings(t.Param), copyParams(t.Attr), copyParams(t.Params)
+			nt.RelTasks, nt.RelTasksId, nt.RelConditions, nt.RelTaskCnt = make(map[string]*Task), nil, make(map[int64]string), 0
+			nt.CreateTime, nt.ModifyTime = tm, tm
+			if err := ms.AddTask(&nt); err != nil {
+				return newError(CodeStore, err, "\n[s.cloneChain] clone task [%d %s] error %s.", t.Id, t.Name, err.Error())
+			}
+			tasks[t.Id] = &nt
+			olds = append(olds, t)
+		}
+		pj = nj
+	}
+
+	//依赖关系与执行条件指向副本中的任务
+	for _, t := range olds {
+		nt := tasks[t.Id]
+		for _, rt := range t.RelTasks {
+			if rt == nil {
+				continue
+			}
+			nrt, ok := tasks[rt.Id]
+			if !ok {
+				return newError(CodeInvalid, nil, "\n[s.cloneChain] task [%d %s] depends on task [%d] which is not in schedule [%d %s].",
+					t.Id, t.Name, rt.Id, s.Id, s.Name)
+			}
+			if err := ms.AddRelTask(nt, nrt.Id, t.relCondition(rt.Id)); err != nil {
+				return newError(CodeStore, err, "\n[s.cloneChain] clone reltask [%d] of task [%d] error %s.", rt.Id, t.Id, err.Error())
+			}
+		}
+	}
+
+	if wt, ok := tasks[s.WarmupTaskId]; ok {
+		ns.WarmupTaskId = wt.Id
+	}
+	if err := ms.UpdateSchedule(ns); err != nil {
+		return newError(CodeStore, err, "\n[s.cloneChain] update schedule [%d] error %s.", ns.Id, err.Error())
+	}
+	return nil
+} // }}}
@@ -0,0 +1,183 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//调度周期使用cron表达式时的前缀，例如 cron:*/15 * * * 1-5 表示工作日每15分钟
+const CronPrefix = "cron:"
+
+//cron表达式查找下次启动时间的最大范围，超出时认为表达式永远不会触发（如2月30日）
+const cronMaxYears = 5
+
+//解析后的5段cron表达式：分 时 日 月 周。
+//每段支持 *、数字、范围a-b、步长*/n或a-b/n，以及用逗号分隔的列表；
+//周的取值为0-7，0和7均表示周日。
+//日和周均被限定时（都不是*），满足其一即可触发，与标准cron一致。
+//
+//启动时间按本地时区的钟表时间计算，夏令时切换时的处理是确定的：
+//切换时被跳过的钟表时间（如02:30不存在）不触发；
+//切换时重复出现的钟表时间只在第一次出现时触发一次。
+type CronSpec struct { // {{{
+	minute [60]bool
+	hour   [24]bool
+	dom    [32]bool
+	month  [13]bool
+	dow    [7]bool
+	domAll bool //日为*
+	dowAll bool //周为*
+} // }}}
+
+//ParseCron解析cron表达式，返回当前时间之后的下次启动时间。
+func ParseCron(expr string) (next time.Time, err error) { // {{{
+	spec, err := ParseCronSpec(expr)
+	if err != nil {
+		return next, err
+	}
+
+	next = spec.Next(GetNow())
+	if next.IsZero() {
+		e := fmt.Sprintf("\n[ParseCron] cron [%s] never fires.", expr)
+		return next, errors.New(e)
+	}
+	return next, nil
+} // }}}
+
+//ParseCronSpec解析5段的cron表达式，表达式可以带有CronPrefix前缀。
+func ParseCronSpec(expr string) (*CronSpec, error) { // {{{
+	fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(expr), CronPrefix))
+	if len(fields) != 5 {
+		e := fmt.Sprintf("\n[ParseCronSpec] cron [%s] must have 5 fields, got %d.", expr, len(fields))
+		return nil, errors.New(e)
+	}
+
+	spec := &CronSpec{domAll: fields[2] == "*", dowAll: fields[4] == "*"}
+	var dow [8]bool
+	for _, f := range []struct {
+		name     string
+		field    string
+		min, max int
+		set      []bool
+	}{
+		{"minute", fields[0], 0, 59, spec.minute[:]},
+		{"hour", fields[1], 0, 23, spec.hour[:]},
+		{"day of month", fields[2], 1, 31, spec.dom[:]},
+		{"month", fields[3], 1, 12, spec.month[:]},
+		{"day of week", fields[4], 0, 7, dow[:]},
+	} {
+		if err := parseCronField(f.field, f.min, f.max, f.set); err != nil {
+			e := fmt.Sprintf("\n[ParseCronSpec] cron [%s] %s %s", expr, f.name, err.Error())
+			return nil, errors.New(e)
+		}
+	}
+	copy(spec.dow[:], dow[:7])
+	spec.dow[0] = spec.dow[0] || dow[7]
+
+	return spec, nil
+} // }}}
+
+//parseCronField解析cron表达式中的一段，将取值范围[min, max]中匹配的值在set中置为true。
+func parseCronField(field string, min, max int, set []bool) error { // {{{
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return errors.New(fmt.Sprintf("step [%s] is invalid.", part))
+			}
+			step, part = n, part[:i]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(part[:i])
+			hi, err2 = strconv.Atoi(part[i+1:])
+			if err1 != nil || err2 != nil || lo > hi {
+				return errors.New(fmt.Sprintf("range [%s] is invalid.", part))
+			}
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return errors.New(fmt.Sprintf("value [%s] is invalid.", part))
+			}
+			lo, hi = n, n
+			if step > 1 { //a/n 表示从a开始到最大值
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max {
+			return errors.New(fmt.Sprintf("[%s] is out of range %d-%d.", part, min, max))
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+
+	return nil
+} // }}}
+
+//dayMatch判断日期是否满足表达式中日、月、周的限定
+func (spec *CronSpec) dayMatch(t time.Time) bool { // {{{
+	if !spec.month[t.Month()] {
+		return false
+	}
+
+	dom, dow := spec.dom[t.Day()], spec.dow[t.Weekday()]
+	switch {
+	case spec.domAll && spec.dowAll:
+		return true
+	case spec.domAll:
+		return dow
+	case spec.dowAll:
+		return dom
+	}
+	return dom || dow
+} // }}}
+
+//Next返回from之后（不含from）的第一个启动时间，按from所在的时区计算，找不到时返回零值。
+func (spec *CronSpec) Next(from time.Time) time.Time { // {{{
+	loc := from.Location()
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+	end := day.AddDate(cronMaxYears, 0, 0)
+
+	for ; day.Before(end); day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, loc) {
+		if !spec.dayMatch(day) {
+			continue
+		}
+
+		for h := 0; h < 24; h++ {
+			if !spec.hour[h] {
+				continue
+			}
+			for m := 0; m < 60; m++ {
+				if !spec.minute[m] {
+					continue
+				}
+
+				t := time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, loc)
+				//夏令时开始时被跳过的钟表时间
+				if t.Hour() != h || t.Minute() != m {
+					continue
+				}
+				//夏令时结束时重复的钟表时间，取第一次出现
+				if e := t.Add(-time.Hour); e.Hour() == h && e.Minute() == m && e.Day() == t.Day() {
+					t = e
+				}
+				if t.After(from) {
+					return t
+				}
+			}
+		}
+	}
+
+	return time.Time{}
+} // }}}
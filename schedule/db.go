@@ -1,69 +1,131 @@
 package schedule
 
 import (
-	"errors"
+	"context"
+	"database/sql"
 	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
-//从元数据库获取Schedule列表。
-func (sl *ScheduleManager) getAllSchedules() error { // {{{
-	sl.ScheduleList = make([]*Schedule, 0)
+//execer为元数据库的写入接口，*sql.DB与*sql.Tx均实现了该接口。
+//需要与其它写入放在同一事务中的操作通过它传入事务，单独执行时传入GlobalConfigStruct.HiveConn。
+type execer interface { // {{{
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+} // }}}
+
+//inTx在一个元数据库事务中执行fn，fn返回error时回滚，否则提交。
+//事务整体的执行时间不超过GlobalConfigStruct.DBTimeout，超时后事务回滚。
+func inTx(fn func(tx execer) error) error { // {{{
+	if g.Schedules.closed() {
+		return ErrShutdown
+	}
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	tx, err := g.HiveConn.BeginTx(ctx, nil)
+	if err != nil {
+		return newError(CodeStore, err, "[inTx] begin transaction error %s.", dbError(ctx, err).Error())
+	}
+
+	if err = fn(tx); err != nil {
+		if e := tx.Rollback(); e != nil {
+			g.L.Warningln(fmt.Sprintf("[inTx] rollback error %s.", e.Error()))
+		}
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return newError(CodeStore, err, "[inTx] commit error %s.", dbError(ctx, err).Error())
+	}
+	return nil
+} // }}}
+
+//getSchedules从元数据库获取Schedule列表。
+func getSchedules(ctx context.Context) ([]*Schedule, error) { // {{{
+	ctx, cancel := dbContext(ctx)
+	defer cancel()
+	scds := make([]*Schedule, 0)
 	//查询全部schedule列表
 	sql := `SELECT scd.scd_id,
 				scd.scd_name,
+				scd.scd_group,
+				scd.scd_status,
+				scd.scd_enabled,
 				scd.scd_num,
 				scd.scd_cyc,
 				scd.scd_timeout,
+				scd.scd_soft_timeout,
+				scd.scd_overlap,
+				scd.scd_misfire,
+				scd.scd_timezone,
+				scd.scd_start_jitter,
+				scd.scd_retry_count,
+				scd.scd_retry_delay,
+				scd.scd_on_startup,
+				scd.scd_priority,
 				scd.scd_job_id,
+				scd.scd_warmup_task_id,
 				scd.scd_desc,
 				scd.create_user_id,
 				scd.create_time,
 				scd.modify_user_id,
 				scd.modify_time
 			FROM scd_schedule scd`
-	rows, err := g.HiveConn.Query(sql)
+	rows, err := queryHive(ctx, sql)
 	if err != nil {
-		e := fmt.Sprintf("\n[sl.getAllSchedule] run Sql error %s %s", sql, err.Error())
-		return errors.New(e)
+		return nil, newError(CodeStore, err, "\n[getSchedules] run Sql error %s %s", sql, err.Error())
 	}
-	g.L.Debugln("[getAllSchedule] ", "\nsql=", sql)
+	g.L.Debugln("[getSchedules] ", "\nsql=", sql)
 
 	for rows.Next() {
-		scd := &Schedule{
-			Jobs:  make([]*Job, 0),
-			Tasks: make([]*Task, 0),
-		}
+		var jitter int64
+		scd := &Schedule{}
 		scd.StartSecond = make([]time.Duration, 0)
-		err = rows.Scan(&scd.Id, &scd.Name, &scd.Count, &scd.Cyc, &scd.TimeOut,
-			&scd.JobId, &scd.Desc, &scd.CreateUserId, &scd.CreateTime, &scd.ModifyUserId,
+		err = rows.Scan(&scd.Id, &scd.Name, &scd.Group, &scd.Status, &scd.Enabled, &scd.Count, &scd.Cyc, &scd.TimeOut, &scd.SoftTimeOut, &scd.Overlap,
+			&scd.Misfire, &scd.TimeZone, &jitter, &scd.ScheduleRetryCount, &scd.ScheduleRetryDelay, &scd.OnStartupPolicy, &scd.Priority, &scd.JobId, &scd.WarmupTaskId, &scd.Desc, &scd.CreateUserId, &scd.CreateTime, &scd.ModifyUserId,
 			&scd.ModifyTime)
+		scd.StartJitter = time.Duration(jitter) * time.Second
 		scd.setStart()
-
-		sl.ScheduleList = append(sl.ScheduleList, scd)
+		scd.setSnooze()
+		scd.setRemain()
+		scd.setNextStart()
+		scd.setRelSchedules()
+		scd.setTriggers()
+		scd.setTags()
+		scd.setEnv()
+
+		scds = append(scds, scd)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getSchedules] read schedule error %s", dbError(ctx, err).Error())
 	}
 
-	return err
+	return scds, nil
 } // }}}
 
 //Add方法会将Schedule对象增加到元数据库中。
-func (s *Schedule) add() error { // {{{
-	err := s.setNewId()
+func (s *Schedule) add(tx execer) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	err := s.setNewId(tx)
 	if err != nil {
-		e := fmt.Sprintf("\n[s.add] %s.", err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[s.add] %s.", err.Error())
 	}
 
 	sql := `INSERT INTO scd_schedule
-            (scd_id, scd_name, scd_num, scd_cyc,
-             scd_timeout, scd_job_id, scd_desc, create_user_id,
-             create_time, modify_user_id, modify_time)
-		VALUES      (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	_, err = g.HiveConn.Exec(sql, &s.Id, &s.Name, &s.Count, &s.Cyc,
-		&s.TimeOut, &s.JobId, &s.Desc, &s.CreateUserId, &s.CreateTime, &s.ModifyUserId, &s.ModifyTime)
+            (scd_id, scd_name, scd_group, scd_status, scd_enabled, scd_num, scd_cyc,
+             scd_timeout, scd_soft_timeout, scd_overlap, scd_misfire, scd_timezone, scd_start_jitter, scd_retry_count, scd_retry_delay, scd_on_startup, scd_priority,
+             scd_job_id, scd_warmup_task_id, scd_desc, create_user_id, create_time, modify_user_id, modify_time)
+		VALUES      (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = execDB(ctx, tx, sql, &s.Id, &s.Name, &s.Group, &s.Status, &s.Enabled, &s.Count, &s.Cyc,
+		&s.TimeOut, &s.SoftTimeOut, &s.Overlap, &s.Misfire, &s.TimeZone, int64(s.StartJitter/time.Second), &s.ScheduleRetryCount, &s.ScheduleRetryDelay,
+		&s.OnStartupPolicy, &s.Priority, &s.JobId, &s.WarmupTaskId, &s.Desc, &s.CreateUserId, &s.CreateTime, &s.ModifyUserId, &s.ModifyTime)
 	if err != nil {
-		e := fmt.Sprintf("[s.add] Query sql [%s] error %s.\n", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "[s.add] Query sql [%s] error %s.\n", sql, err.Error())
 	}
 	g.L.Debugln("[s.add] schedule", s, "\nsql=", sql)
 
@@ -71,24 +133,39 @@ func (s *Schedule) add() error { // {{{
 } // }}}
 
 //Update方法将Schedule对象更新到元数据库。
-func (s *Schedule) update() error { // {{{
+func (s *Schedule) update(tx execer) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	sql := `UPDATE scd_schedule 
 		SET  scd_name=?,
+             scd_group=?,
+             scd_status=?,
+             scd_enabled=?,
              scd_num=?,
              scd_cyc=?,
              scd_timeout=?,
+             scd_soft_timeout=?,
+             scd_overlap=?,
+             scd_misfire=?,
+             scd_timezone=?,
+             scd_start_jitter=?,
+             scd_retry_count=?,
+             scd_retry_delay=?,
+             scd_on_startup=?,
+             scd_priority=?,
              scd_job_id=?,
+             scd_warmup_task_id=?,
              scd_desc=?,
              create_user_id=?,
              create_time=?,
              modify_user_id=?,
              modify_time=?
 		 WHERE scd_id=?`
-	_, err := g.HiveConn.Exec(sql, &s.Name, &s.Count, &s.Cyc,
-		&s.TimeOut, &s.JobId, &s.Desc, &s.CreateUserId, &s.CreateTime, &s.ModifyUserId, &s.ModifyTime, &s.Id)
+	_, err := execDB(ctx, tx, sql, &s.Name, &s.Group, &s.Status, &s.Enabled, &s.Count, &s.Cyc,
+		&s.TimeOut, &s.SoftTimeOut, &s.Overlap, &s.Misfire, &s.TimeZone, int64(s.StartJitter/time.Second), &s.ScheduleRetryCount, &s.ScheduleRetryDelay,
+		&s.OnStartupPolicy, &s.Priority, &s.JobId, &s.WarmupTaskId, &s.Desc, &s.CreateUserId, &s.CreateTime, &s.ModifyUserId, &s.ModifyTime, &s.Id)
 	if err != nil {
-		e := fmt.Sprintf("[s.update] Query sql [%s] error %s.\n", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "[s.update] Query sql [%s] error %s.\n", sql, err.Error())
 	}
 	g.L.Debugln("[s.update] schedule", s, "\nsql=", sql)
 
@@ -97,28 +174,169 @@ func (s *Schedule) update() error { // {{{
 
 //Delete方法，删除元数据库中的调度信息
 func (s *Schedule) deleteSchedule() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	sql := `Delete FROM scd_schedule WHERE scd_id=?`
-	_, err := g.HiveConn.Exec(sql, &s.Id)
+	_, err := execDB(ctx, g.HiveConn, sql, &s.Id)
 	if err != nil {
-		e := fmt.Sprintf("[s.deleteSchedule] Query sql [%s] error %s.\n", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "[s.deleteSchedule] Query sql [%s] error %s.\n", sql, err.Error())
 	}
 	g.L.Debugln("[s.deleteSchedule] schedule", s, "\nsql=", sql)
 
 	return err
 } // }}}
 
+//setRelSchedules从元数据库获取Schedule依赖的上游调度Id
+func (s *Schedule) setRelSchedules() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	s.DependsOn = make([]int64, 0)
+
+	sql := `SELECT sr.rel_scd_id
+			FROM scd_schedule_rel sr
+			WHERE sr.scd_id=?
+			ORDER BY sr.rel_scd_id`
+	rows, err := queryHive(ctx, sql, s.Id)
+	if err != nil {
+		return newError(CodeStore, err, "[s.setRelSchedules] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[s.setRelSchedules] ", "\nsql=", sql)
+
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return newError(CodeStore, err, "[s.setRelSchedules] %s.\n", err.Error())
+		}
+		s.DependsOn = append(s.DependsOn, id)
+	}
+
+	return rows.Err()
+} // }}}
+
+//addRelSchedule保存Schedule对上游调度的依赖关系至元数据库
+func (s *Schedule) addRelSchedule(id int64) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	tm := time.Now()
+	sql := `INSERT INTO scd_schedule_rel
+            (scd_id, rel_scd_id, create_user_id, create_time)
+			VALUES      (?, ?, ?, ?)`
+	_, err := execDB(ctx, g.HiveConn, sql, &s.Id, &id, &s.ModifyUserId, &tm)
+	if err != nil {
+		return newError(CodeStore, err, "\n[s.addRelSchedule] sql %s error %s.", sql, err.Error())
+	}
+	g.L.Debugln("[s.addRelSchedule] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//deleteRelSchedule删除Schedule对指定上游调度的依赖关系
+func (s *Schedule) deleteRelSchedule(id int64) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `DELETE FROM scd_schedule_rel WHERE scd_id=? and rel_scd_id=?`
+	_, err := execDB(ctx, g.HiveConn, sql, &s.Id, &id)
+	if err != nil {
+		return newError(CodeStore, err, "\n[s.deleteRelSchedule] sql %s error %s.", sql, err.Error())
+	}
+	g.L.Debugln("[s.deleteRelSchedule] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//setTriggers从元数据库获取Schedule触发的下游调度
+func (s *Schedule) setTriggers() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	s.Triggers = make([]Trigger, 0)
+
+	sql := `SELECT st.trigger_scd_id,
+				st.on_failure
+			FROM scd_schedule_trigger st
+			WHERE st.scd_id=?
+			ORDER BY st.trigger_scd_id`
+	rows, err := queryHive(ctx, sql, s.Id)
+	if err != nil {
+		return newError(CodeStore, err, "[s.setTriggers] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[s.setTriggers] ", "\nsql=", sql)
+
+	for rows.Next() {
+		var t Trigger
+		if err = rows.Scan(&t.ScheduleId, &t.OnFailure); err != nil {
+			return newError(CodeStore, err, "[s.setTriggers] %s.\n", err.Error())
+		}
+		s.Triggers = append(s.Triggers, t)
+	}
+
+	return rows.Err()
+} // }}}
+
+//saveTriggers删除Schedule原有的触发关系后，按Triggers逐个添加
+func (s *Schedule) saveTriggers() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `DELETE FROM scd_schedule_trigger WHERE scd_id=?`
+	if _, err := execDB(ctx, g.HiveConn, sql, &s.Id); err != nil {
+		return newError(CodeStore, err, "\n[s.saveTriggers] sql %s error %s.", sql, err.Error())
+	}
+
+	sql = `INSERT INTO scd_schedule_trigger
+            (scd_id, trigger_scd_id, on_failure, create_user_id, create_time)
+			VALUES      (?, ?, ?, ?, ?)`
+	tm := time.Now()
+	for _, t := range s.Triggers {
+		if _, err := execDB(ctx, g.HiveConn, sql, &s.Id, t.ScheduleId, t.OnFailure, &s.ModifyUserId, &tm); err != nil {
+			return newError(CodeStore, err, "\n[s.saveTriggers] sql %s error %s.", sql, err.Error())
+		}
+	}
+	g.L.Debugln("[s.saveTriggers] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//deleteAllTriggers删除Schedule触发以及被触发的全部关系
+func (s *Schedule) deleteAllTriggers() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `DELETE FROM scd_schedule_trigger WHERE scd_id=? or trigger_scd_id=?`
+	_, err := execDB(ctx, g.HiveConn, sql, &s.Id, &s.Id)
+	if err != nil {
+		return newError(CodeStore, err, "\n[s.deleteAllTriggers] sql %s error %s.", sql, err.Error())
+	}
+	g.L.Debugln("[s.deleteAllTriggers] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//deleteAllRelSchedule删除Schedule依赖以及被依赖的全部关系
+func (s *Schedule) deleteAllRelSchedule() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `DELETE FROM scd_schedule_rel WHERE scd_id=? or rel_scd_id=?`
+	_, err := execDB(ctx, g.HiveConn, sql, &s.Id, &s.Id)
+	if err != nil {
+		return newError(CodeStore, err, "\n[s.deleteAllRelSchedule] sql %s error %s.", sql, err.Error())
+	}
+	g.L.Debugln("[s.deleteAllRelSchedule] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
 //setNewId方法，检索元数据库返回新的Schedule Id
-func (s *Schedule) setNewId() error { // {{{
+func (s *Schedule) setNewId(tx execer) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	var id int64
 
 	//查询全部schedule列表
 	sql := `SELECT ifnull(max(scd.scd_id),0) as scd_id
 			FROM scd_schedule scd`
-	rows, err := g.HiveConn.Query(sql)
+	rows, err := queryTx(ctx, tx, sql)
 	if err != nil {
-		e := fmt.Sprintf("[s.setNewid] Query sql [%s] error %s.\n", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "[s.setNewid] Query sql [%s] error %s.\n", sql, err.Error())
 	}
 
 	for rows.Next() {
@@ -129,27 +347,29 @@ func (s *Schedule) setNewId() error { // {{{
 	return nil
 } // }}}
 
-func (s *Schedule) addStart(t time.Duration, m int) error { // {{{
+func (s *Schedule) addStart(tx execer, t time.Duration, m int) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	sql := `INSERT INTO scd_start 
             (scd_id, scd_start, scd_start_month,
             create_user_id, create_time)
          VALUES  (?, ?, ?, ?, ?)`
-	_, err := g.HiveConn.Exec(sql, &s.Id, &t, &m, &s.ModifyUserId, &s.ModifyTime)
+	_, err := execDB(ctx, tx, sql, &s.Id, &t, &m, &s.ModifyUserId, &s.ModifyTime)
 	if err != nil {
-		e := fmt.Sprintf("[s.addStart] Exec sql [%s] error %s.\n", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "[s.addStart] Exec sql [%s] error %s.\n", sql, err.Error())
 	}
 	g.L.Debugln("[s.addStart] ", "\nsql=", sql)
 	return nil
 } // }}}
 
 //delStart删除该Schedule的所有启动时间列表
-func (s *Schedule) delStart() error { // {{{
+func (s *Schedule) delStart(tx execer) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	sql := `DELETE FROM scd_start WHERE scd_id=?`
-	_, err := g.HiveConn.Exec(sql, &s.Id)
+	_, err := execDB(ctx, tx, sql, &s.Id)
 	if err != nil {
-		e := fmt.Sprintf("[s.delStart] Exec sql [%s] error %s.\n", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "[s.delStart] Exec sql [%s] error %s.\n", sql, err.Error())
 	}
 	g.L.Debugln("[s.delStart] ", "\nsql=", sql)
 
@@ -158,6 +378,8 @@ func (s *Schedule) delStart() error { // {{{
 
 //getStart，从元数据库获取指定Schedule的启动时间。
 func (s *Schedule) setStart() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 
 	s.StartSecond = make([]time.Duration, 0)
 	s.StartMonth = make([]int, 0)
@@ -166,10 +388,9 @@ func (s *Schedule) setStart() error { // {{{
 	sql := `SELECT s.scd_start,s.scd_start_month
 			FROM scd_start s
 			WHERE s.scd_id=?`
-	rows, err := g.HiveConn.Query(sql, s.Id)
+	rows, err := queryHive(ctx, sql, s.Id)
 	if err != nil {
-		e := fmt.Sprintf("[s.setStart] Exec sql [%s] error %s.\n", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "[s.setStart] Exec sql [%s] error %s.\n", sql, err.Error())
 	}
 	g.L.Debugln("[s.setStart] ", "\nsql=", sql)
 
@@ -190,21 +411,412 @@ func (s *Schedule) setStart() error { // {{{
 		s.StartSecond = append(s.StartSecond, time.Duration(0))
 		s.StartMonth = append(s.StartMonth, int(0))
 	}
+	if err = s.setWeekdays(); err != nil {
+		return err
+	}
 
 	//排序时间
 	s.sortStart()
 	return nil
 } // }}}
 
+//setWeekdays从元数据库获取Schedule的启动星期
+func (s *Schedule) setWeekdays() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	s.StartWeekday = make([]time.Weekday, 0)
+
+	sql := `SELECT sw.scd_weekday
+			FROM scd_start_weekday sw
+			WHERE sw.scd_id=?
+			ORDER BY sw.scd_weekday`
+	rows, err := queryHive(ctx, sql, s.Id)
+	if err != nil {
+		return newError(CodeStore, err, "[s.setWeekdays] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[s.setWeekdays] ", "\nsql=", sql)
+
+	for rows.Next() {
+		var wd int
+		if err = rows.Scan(&wd); err != nil {
+			return newError(CodeStore, err, "[s.setWeekdays] %s.\n", err.Error())
+		}
+		s.StartWeekday = append(s.StartWeekday, time.Weekday(wd))
+	}
+
+	return rows.Err()
+} // }}}
+
+//saveWeekdays删除Schedule原有的启动星期后将StartWeekday持久化到元数据库
+func (s *Schedule) saveWeekdays(tx execer) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	if err := s.delWeekdays(tx); err != nil {
+		return newError(CodeStore, err, "\n[s.saveWeekdays] %s", err.Error())
+	}
+
+	sql := `INSERT INTO scd_start_weekday
+            (scd_id, scd_weekday, create_user_id, create_time)
+		VALUES      (?, ?, ?, ?)`
+	tm := time.Now()
+	for _, wd := range s.StartWeekday {
+		if _, err := execDB(ctx, tx, sql, &s.Id, int(wd), &s.ModifyUserId, &tm); err != nil {
+			return newError(CodeStore, err, "[s.saveWeekdays] Exec sql [%s] error %s.\n", sql, err.Error())
+		}
+	}
+	g.L.Debugln("[s.saveWeekdays] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//delWeekdays删除Schedule的全部启动星期
+func (s *Schedule) delWeekdays(tx execer) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `DELETE FROM scd_start_weekday WHERE scd_id=?`
+	_, err := execDB(ctx, tx, sql, &s.Id)
+	if err != nil {
+		return newError(CodeStore, err, "[s.delWeekdays] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	g.L.Debugln("[s.delWeekdays] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//setSnooze从元数据库获取Schedule的暂缓执行时间，没有记录时为零值。
+func (s *Schedule) setSnooze() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	s.SnoozeUntil = time.Time{}
+
+	sql := `SELECT snooze_until
+			FROM scd_snooze
+			WHERE scd_id=?`
+	rows, err := queryHive(ctx, sql, s.Id)
+	if err != nil {
+		return newError(CodeStore, err, "[s.setSnooze] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[s.setSnooze] ", "\nsql=", sql)
+
+	for rows.Next() {
+		if err = rows.Scan(&s.SnoozeUntil); err != nil {
+			return newError(CodeStore, err, "[s.setSnooze] %s.\n", err.Error())
+		}
+	}
+
+	return rows.Err()
+} // }}}
+
+//setRemain从元数据库获取Schedule剩余的调度次数。
+//没有记录或记录时的调度次数与当前的Count不同（调度次数被修改）时，剩余次数重置为Count。
+func (s *Schedule) setRemain() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	s.Remain = int(s.Count)
+
+	sql := `SELECT scd_num,
+				remain
+			FROM scd_remain
+			WHERE scd_id=?`
+	rows, err := queryHive(ctx, sql, s.Id)
+	if err != nil {
+		return newError(CodeStore, err, "[s.setRemain] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[s.setRemain] ", "\nsql=", sql)
+
+	for rows.Next() {
+		var num int8
+		var remain int
+		if err = rows.Scan(&num, &remain); err != nil {
+			return newError(CodeStore, err, "[s.setRemain] %s.\n", err.Error())
+		}
+		if num == s.Count {
+			s.Remain = remain
+		}
+	}
+
+	return rows.Err()
+} // }}}
+
+//saveRemain将Schedule剩余的调度次数持久化到元数据库，未连接元数据库时不做处理。
+func (s *Schedule) saveRemain() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	if g.HiveConn == nil {
+		return nil
+	}
+
+	sql := `DELETE FROM scd_remain WHERE scd_id=?`
+	if _, err := execDB(ctx, g.HiveConn, sql, &s.Id); err != nil {
+		return newError(CodeStore, err, "[s.saveRemain] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+
+	sql = `INSERT INTO scd_remain
+            (scd_id, scd_num, remain, create_time)
+		VALUES      (?, ?, ?, ?)`
+	_, err := execDB(ctx, g.HiveConn, sql, &s.Id, &s.Count, &s.Remain, time.Now())
+	if err != nil {
+		return newError(CodeStore, err, "[s.saveRemain] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	g.L.Debugln("[s.saveRemain] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//setNextStart从元数据库获取进程停止前保存的下次启动时间，没有记录时为零值。
+//读取后Timer首次计算启动时间时按它恢复，见misfire。
+func (s *Schedule) setNextStart() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	s.NextStart, s.restored = time.Time{}, true
+
+	sql := `SELECT next_start
+			FROM scd_next_start
+			WHERE scd_id=?`
+	rows, err := queryHive(ctx, sql, s.Id)
+	if err != nil {
+		return newError(CodeStore, err, "[s.setNextStart] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[s.setNextStart] ", "\nsql=", sql)
+
+	for rows.Next() {
+		if err = rows.Scan(&s.NextStart); err != nil {
+			return newError(CodeStore, err, "[s.setNextStart] %s.\n", err.Error())
+		}
+	}
+
+	return rows.Err()
+} // }}}
+
+//saveNextStart将Schedule的下次启动时间持久化到元数据库，未连接元数据库时不做处理。
+func (s *Schedule) saveNextStart() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	if g.HiveConn == nil {
+		return nil
+	}
+
+	sql := `DELETE FROM scd_next_start WHERE scd_id=?`
+	if _, err := execDB(ctx, g.HiveConn, sql, &s.Id); err != nil {
+		return newError(CodeStore, err, "[s.saveNextStart] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+
+	sql = `INSERT INTO scd_next_start
+            (scd_id, next_start, create_time)
+		VALUES      (?, ?, ?)`
+	_, err := execDB(ctx, g.HiveConn, sql, &s.Id, &s.NextStart, time.Now())
+	if err != nil {
+		return newError(CodeStore, err, "[s.saveNextStart] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	g.L.Debugln("[s.saveNextStart] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//saveStatus将Schedule的状态持久化到元数据库
+func (s *Schedule) saveStatus() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `UPDATE scd_schedule SET scd_status=? WHERE scd_id=?`
+	_, err := execDB(ctx, g.HiveConn, sql, &s.Status, &s.Id)
+	if err != nil {
+		return newError(CodeStore, err, "[s.saveStatus] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	g.L.Debugln("[s.saveStatus] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//saveSnooze将Schedule的暂缓执行时间持久化到元数据库
+func (s *Schedule) saveSnooze() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	if err := s.delSnooze(); err != nil {
+		return newError(CodeStore, err, "\n[s.saveSnooze] %s", err.Error())
+	}
+
+	sql := `INSERT INTO scd_snooze
+            (scd_id, snooze_until, create_time)
+		VALUES      (?, ?, ?)`
+	_, err := execDB(ctx, g.HiveConn, sql, &s.Id, &s.SnoozeUntil, time.Now())
+	if err != nil {
+		return newError(CodeStore, err, "[s.saveSnooze] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	g.L.Debugln("[s.saveSnooze] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//delSnooze删除Schedule的暂缓执行时间
+func (s *Schedule) delSnooze() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `DELETE FROM scd_snooze WHERE scd_id=?`
+	_, err := execDB(ctx, g.HiveConn, sql, &s.Id)
+	if err != nil {
+		return newError(CodeStore, err, "[s.delSnooze] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	g.L.Debugln("[s.delSnooze] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//setTags从元数据库获取Schedule的标签，按标签排序
+func (s *Schedule) setTags() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	s.Tags = make([]string, 0)
+
+	sql := `SELECT st.tag
+			FROM scd_schedule_tag st
+			WHERE st.scd_id=?
+			ORDER BY st.tag`
+	rows, err := queryHive(ctx, sql, s.Id)
+	if err != nil {
+		return newError(CodeStore, err, "[s.setTags] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[s.setTags] ", "\nsql=", sql)
+
+	for rows.Next() {
+		var tag string
+		if err = rows.Scan(&tag); err != nil {
+			return newError(CodeStore, err, "[s.setTags] %s.\n", err.Error())
+		}
+		s.Tags = append(s.Tags, tag)
+	}
+
+	return rows.Err()
+} // }}}
+
+//saveTags删除Schedule原有的标签后将Tags持久化到元数据库
+func (s *Schedule) saveTags(tx execer) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	if err := s.delTags(tx); err != nil {
+		return newError(CodeStore, err, "\n[s.saveTags] %s", err.Error())
+	}
+
+	sql := `INSERT INTO scd_schedule_tag
+            (scd_id, tag, create_user_id, create_time)
+		VALUES      (?, ?, ?, ?)`
+	tm := time.Now()
+	for _, tag := range s.Tags {
+		if _, err := execDB(ctx, tx, sql, &s.Id, tag, &s.ModifyUserId, &tm); err != nil {
+			return newError(CodeStore, err, "[s.saveTags] Exec sql [%s] error %s.\n", sql, err.Error())
+		}
+	}
+	g.L.Debugln("[s.saveTags] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//delTags删除Schedule的全部标签
+func (s *Schedule) delTags(tx execer) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `DELETE FROM scd_schedule_tag WHERE scd_id=?`
+	_, err := execDB(ctx, tx, sql, &s.Id)
+	if err != nil {
+		return newError(CodeStore, err, "[s.delTags] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	g.L.Debugln("[s.delTags] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//setEnv从元数据库获取Schedule的运行参数
+func (s *Schedule) setEnv() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	s.Params = make(map[string]string)
+
+	sql := `SELECT se.env_name,
+				se.env_value
+			FROM scd_schedule_env se
+			WHERE se.scd_id=?`
+	rows, err := queryHive(ctx, sql, s.Id)
+	if err != nil {
+		return newError(CodeStore, err, "[s.setEnv] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[s.setEnv] ", "\nsql=", sql)
+
+	for rows.Next() {
+		var name, value string
+		if err = rows.Scan(&name, &value); err != nil {
+			return newError(CodeStore, err, "[s.setEnv] %s.\n", err.Error())
+		}
+		s.Params[name] = value
+	}
+
+	return rows.Err()
+} // }}}
+
+//saveEnv删除Schedule原有的运行参数后将Params持久化到元数据库
+func (s *Schedule) saveEnv(tx execer) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	if err := s.delEnv(tx); err != nil {
+		return newError(CodeStore, err, "\n[s.saveEnv] %s", err.Error())
+	}
+
+	sql := `INSERT INTO scd_schedule_env
+            (scd_id, env_name, env_value, create_user_id, create_time)
+		VALUES      (?, ?, ?, ?, ?)`
+	tm := time.Now()
+	for name, value := range s.Params {
+		if _, err := execDB(ctx, tx, sql, &s.Id, name, value, &s.ModifyUserId, &tm); err != nil {
+			return newError(CodeStore, err, "[s.saveEnv] Exec sql [%s] error %s.\n", sql, err.Error())
+		}
+	}
+	g.L.Debugln("[s.saveEnv] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//delEnv删除Schedule的全部运行参数
+func (s *Schedule) delEnv(tx execer) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `DELETE FROM scd_schedule_env WHERE scd_id=?`
+	_, err := execDB(ctx, tx, sql, &s.Id)
+	if err != nil {
+		return newError(CodeStore, err, "[s.delEnv] Exec sql [%s] error %s.\n", sql, err.Error())
+	}
+	g.L.Debugln("[s.delEnv] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
 //getSchedule，从元数据库获取指定的Schedule信息。
-func (s *Schedule) getSchedule() error { // {{{
+func (s *Schedule) getSchedule(ctx context.Context) error { // {{{
+	ctx, cancel := dbContext(ctx)
+	defer cancel()
 	//查询全部schedule列表
 	sql := `SELECT scd.scd_id,
 				scd.scd_name,
+				scd.scd_group,
+				scd.scd_status,
+				scd.scd_enabled,
 				scd.scd_num,
 				scd.scd_cyc,
 				scd.scd_timeout,
+				scd.scd_soft_timeout,
+				scd.scd_overlap,
+				scd.scd_misfire,
+				scd.scd_timezone,
+				scd.scd_start_jitter,
+				scd.scd_retry_count,
+				scd.scd_retry_delay,
+				scd.scd_on_startup,
+				scd.scd_priority,
 				scd.scd_job_id,
+				scd.scd_warmup_task_id,
 				scd.scd_desc,
                 scd.create_user_id,
                 scd.create_time,
@@ -212,45 +824,53 @@ func (s *Schedule) getSchedule() error { // {{{
                 scd.modify_time
 			FROM scd_schedule scd
 			WHERE scd.scd_id=?`
-	rows, err := g.HiveConn.Query(sql, s.Id)
+	rows, err := queryHive(ctx, sql, s.Id)
 	if err != nil {
-		e := fmt.Sprintf("\n[s.getSchedule] run Sql %s error %s", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[s.getSchedule] run Sql %s error %s", sql, err.Error())
 	}
 	g.L.Debugln("[s.getSchedule] ", "\nsql=", sql)
 
 	id := -1
+	var jitter int64
 	s.StartSecond = make([]time.Duration, 0)
 	//循环读取记录，格式化后存入变量ｂ
 	for rows.Next() {
-		err = rows.Scan(&id, &s.Name, &s.Count, &s.Cyc,
-			&s.TimeOut, &s.JobId, &s.Desc, &s.CreateUserId, &s.CreateTime, &s.ModifyUserId, &s.ModifyTime)
+		err = rows.Scan(&id, &s.Name, &s.Group, &s.Status, &s.Enabled, &s.Count, &s.Cyc,
+			&s.TimeOut, &s.SoftTimeOut, &s.Overlap, &s.Misfire, &s.TimeZone, &jitter, &s.ScheduleRetryCount, &s.ScheduleRetryDelay, &s.OnStartupPolicy, &s.Priority, &s.JobId, &s.WarmupTaskId, &s.Desc, &s.CreateUserId, &s.CreateTime, &s.ModifyUserId, &s.ModifyTime)
+		s.StartJitter = time.Duration(jitter) * time.Second
 		s.setStart()
+		s.setSnooze()
+		s.setRemain()
+		s.setRelSchedules()
+		s.setTriggers()
+		s.setTags()
+		s.setEnv()
 		if err != nil {
-			e := fmt.Sprintf("getSchedule error %s\n", err.Error())
-			return errors.New(e)
+			return newError(CodeStore, err, "getSchedule error %s\n", err.Error())
 		}
 
 	}
+	if err = rows.Err(); err != nil {
+		return newError(CodeStore, err, "getSchedule error %s\n", dbError(ctx, err).Error())
+	}
 
 	if id == -1 {
-		e := fmt.Sprintf("not found schedule [%d] from db.\n", s.Id)
-		err = errors.New(e)
+		err = newError(CodeNotFound, nil, "not found schedule [%d] from db.\n", s.Id)
 	}
 
-	s.Jobs = make([]*Job, 0)
-	s.Tasks = make([]*Task, 0)
-	s.isRefresh = make(chan bool)
-	s.JobCnt, s.TaskCnt = 0, 0
 	return err
 } // }}}
 
 //从元数据库获取Job信息。
-func (j *Job) getJob() error { // {{{
+func (j *Job) getJob(ctx context.Context) error { // {{{
+	ctx, cancel := dbContext(ctx)
+	defer cancel()
 	//查询全部Job列表
 	sql := `SELECT job.job_id,
 			   job.job_name,
 			   job.job_desc,
+			   job.job_parallel_group,
+			   job.job_timeout,
 			   job.prev_job_id,
 			   job.next_job_id,
                job.create_user_id,
@@ -259,48 +879,47 @@ func (j *Job) getJob() error { // {{{
                job.modify_time
 			FROM scd_job job
 			WHERE job.job_id=?`
-	rows, err := g.HiveConn.Query(sql, j.Id)
+	rows, err := queryHive(ctx, sql, j.Id)
 	if err != nil {
-		e := fmt.Sprintf("[\nj.getJob] run Sql %s error %s", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "[\nj.getJob] run Sql %s error %s", sql, err.Error())
 	}
 	g.L.Debugln("[getJob] ", "\nsql=", sql)
 
 	id := -1
 	//循环读取记录，格式化后存入变量ｂ
 	for rows.Next() {
-		err = rows.Scan(&id, &j.Name, &j.Desc, &j.PreJobId, &j.NextJobId, &j.CreateUserId, &j.CreateTime, &j.ModifyUserId, &j.ModifyTime)
+		err = rows.Scan(&id, &j.Name, &j.Desc, &j.ParallelGroup, &j.TimeOut, &j.PreJobId, &j.NextJobId, &j.CreateUserId, &j.CreateTime, &j.ModifyUserId, &j.ModifyTime)
 		if err != nil {
-			e := fmt.Sprintf("\n[getJob] %s.", err.Error())
-			return errors.New(e)
+			return newError(CodeStore, err, "\n[getJob] %s.", err.Error())
 		}
 
 		//初始化Task内存
 		j.Tasks = make(map[string]*Task)
 	}
+	if err = rows.Err(); err != nil {
+		return newError(CodeStore, err, "\n[getJob] %s.", dbError(ctx, err).Error())
+	}
 
 	if id == -1 {
-		e := fmt.Sprintf("[getJob] job [%d] not found \n", id)
-		err = errors.New(e)
+		err = newError(CodeNotFound, nil, "[getJob] job [%d] not found \n", id)
 	}
 
 	return err
 } // }}}
 
 //增加作业信息至元数据库
-func (j *Job) add() (err error) { // {{{
-	j.setNewId()
-	j.Tasks = make(map[string]*Task)
-	j.CreateTime, j.ModifyTime = time.Now(), time.Now()
+func (j *Job) add(tx execer) (err error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	j.setNewId(tx)
 	sql := `INSERT INTO scd_job
-            (job_id, job_name, job_desc, prev_job_id,
+            (job_id, job_name, job_desc, job_parallel_group, job_timeout, prev_job_id,
              next_job_id, create_user_id, create_time,
              modify_user_id, modify_time)
-		VALUES      (?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	_, err = g.HiveConn.Exec(sql, &j.Id, &j.Name, &j.Desc, &j.PreJobId, &j.NextJobId, &j.CreateUserId, &j.CreateTime, &j.ModifyUserId, &j.ModifyTime)
+		VALUES      (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = execDB(ctx, tx, sql, &j.Id, &j.Name, &j.Desc, &j.ParallelGroup, &j.TimeOut, &j.PreJobId, &j.NextJobId, &j.CreateUserId, &j.CreateTime, &j.ModifyUserId, &j.ModifyTime)
 	if err != nil {
-		e := fmt.Sprintf("[j.add] run Sql error %s %s\n", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "[j.add] run Sql error %s %s\n", sql, err.Error())
 	}
 	g.L.Debugln("[j.add] ", "\nsql=", sql)
 	return err
@@ -308,16 +927,17 @@ func (j *Job) add() (err error) { // {{{
 
 //从元数据库获取Job下的Task列表。
 func (j *Job) getTasksId() ([]int64, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	tasksid := make([]int64, 0)
 
 	//查询Job中全部Task列表
 	sql := `SELECT jt.task_id
 			FROM scd_job_task jt
             WHERE jt.job_id=?`
-	rows, err := g.HiveConn.Query(sql, &j.Id)
+	rows, err := queryHive(ctx, sql, &j.Id)
 	if err != nil {
-		e := fmt.Sprintf("[j.getTasksId] Query sql [%s] error %s.\n", sql, err.Error())
-		return tasksid, errors.New(e)
+		return tasksid, newError(CodeStore, err, "[j.getTasksId] Query sql [%s] error %s.\n", sql, err.Error())
 	}
 	g.L.Debugln("[j.getTasksId] ", "\nsql=", sql)
 
@@ -331,16 +951,17 @@ func (j *Job) getTasksId() ([]int64, error) { // {{{
 } // }}}
 
 //获取新Id
-func (j *Job) setNewId() (err error) { // {{{
+func (j *Job) setNewId(tx execer) (err error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	var id int64
 
 	//查询全部schedule列表
 	sql := `SELECT ifnull(max(job.job_id),0) as job_id
 			FROM scd_job job`
-	rows, err := g.HiveConn.Query(sql)
+	rows, err := queryTx(ctx, tx, sql)
 	if err != nil {
-		e := fmt.Sprintf("[j.setNewId] Query sql [%s] error %s.\n", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "[j.setNewId] Query sql [%s] error %s.\n", sql, err.Error())
 	}
 
 	//循环读取记录，格式化后存入变量ｂ
@@ -354,42 +975,66 @@ func (j *Job) setNewId() (err error) { // {{{
 } // }}}
 
 //修改作业信息至元数据库
-func (j *Job) update() (err error) { // {{{
+func (j *Job) update(tx execer) (err error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	sql := `UPDATE scd_job
 		SET job_name=?, 
 			job_desc=?,
+			job_parallel_group=?,
+			job_timeout=?,
 			prev_job_id=?,
             next_job_id=?, 
             modify_user_id=?, 
 			modify_time=?
 	    WHERE job_id=?`
-	_, err = g.HiveConn.Exec(sql, &j.Name, &j.Desc, &j.PreJobId, &j.NextJobId, &j.ModifyUserId, &j.ModifyTime, &j.Id)
+	_, err = execDB(ctx, tx, sql, &j.Name, &j.Desc, &j.ParallelGroup, &j.TimeOut, &j.PreJobId, &j.NextJobId, &j.ModifyUserId, &j.ModifyTime, &j.Id)
+	if err != nil {
+		err = newError(CodeStore, err, "[j.update] Query sql [%s] error %s.\n", sql, err.Error())
+	}
+	return err
+} // }}}
+
+//将作业下的任务迁移至作业jobId
+func (j *Job) moveTasks(tx execer, jobId int64) (err error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `UPDATE scd_job_task SET job_id=? WHERE job_id=?`
+	_, err = execDB(ctx, tx, sql, &jobId, &j.Id)
 	if err != nil {
-		e := fmt.Sprintf("[j.update] Query sql [%s] error %s.\n", sql, err.Error())
-		err = errors.New(e)
+		err = newError(CodeStore, err, "[j.moveTasks] Query sql [%s] error %s.\n", sql, err.Error())
 	}
 	return err
 } // }}}
 
 //删除作业信息至元数据库
-func (j *Job) deleteJob() (err error) { // {{{
+func (j *Job) deleteJob(tx execer) (err error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	sql := `DELETE FROM scd_job WHERE job_id=?`
-	_, err = g.HiveConn.Exec(sql, &j.Id)
+	_, err = execDB(ctx, tx, sql, &j.Id)
 	if err != nil {
-		e := fmt.Sprintf("[j.setNewId] Query sql [%s] error %s.\n", sql, err.Error())
-		err = errors.New(e)
+		err = newError(CodeStore, err, "[j.setNewId] Query sql [%s] error %s.\n", sql, err.Error())
 	}
 	return err
 } // }}}
 
 //从元数据库获取Task信息。
 func (t *Task) getTask() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	var td, id int64
 	//查询全部Task列表
 	sql := `SELECT task.task_id,
                task.task_address,
 			   task.task_name,
 			   task.task_time_out,
+			   task.task_retry_count,
+			   task.task_retry_interval,
+			   task.task_wave,
+			   task.task_resource_pool,
+			   task.task_executor_type,
+			   task.task_disabled,
 			   task.task_type_id,
 			   task.task_cyc,
 			   task.task_desc,
@@ -401,18 +1046,16 @@ func (t *Task) getTask() error { // {{{
                task.modify_time
 			FROM scd_task task
 			WHERE task.task_id=?`
-	rows, err := g.HiveConn.Query(sql, t.Id)
+	rows, err := queryHive(ctx, sql, t.Id)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.getTask] sql %s error %s.", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.getTask] sql %s error %s.", sql, err.Error())
 	}
 
 	//循环读取记录，格式化后存入变量ｂ
 	for rows.Next() {
-		err = rows.Scan(&id, &t.Address, &t.Name, &t.TimeOut, &t.TaskType, &t.TaskCyc, &t.Desc, &td, &t.Cmd, &t.CreateUserId, &t.CreateTime, &t.ModifyUserId, &t.ModifyTime)
+		err = rows.Scan(&id, &t.Address, &t.Name, &t.TimeOut, &t.RetryCount, &t.RetryInterval, &t.Wave, &t.ResourcePool, &t.ExecutorType, &t.Disabled, &t.TaskType, &t.TaskCyc, &t.Desc, &td, &t.Cmd, &t.CreateUserId, &t.CreateTime, &t.ModifyUserId, &t.ModifyTime)
 		if err != nil {
-			e := fmt.Sprintf("\n[t.getTask] %s.", err.Error())
-			return errors.New(e)
+			return newError(CodeStore, err, "\n[t.getTask] %s.", err.Error())
 		}
 
 		t.StartSecond = time.Duration(td) * time.Second
@@ -424,8 +1067,7 @@ func (t *Task) getTask() error { // {{{
 	}
 
 	if id == 0 {
-		e := fmt.Sprintf("\n[t.getTask] task [%d] not found.", t.Id)
-		err = errors.New(e)
+		err = newError(CodeNotFound, nil, "\n[t.getTask] task [%d] not found.", t.Id)
 	}
 
 	return err
@@ -433,16 +1075,17 @@ func (t *Task) getTask() error { // {{{
 
 //从元数据库获取任务参数信息
 func (t *Task) getTaskParam() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	//查询指定的Task属性列表
 	sql := `SELECT pm.scd_param_name,
 				   pm.scd_param_value
 			FROM   scd_task_param pm
 			WHERE pm.task_id=?`
 
-	rows, err := g.HiveConn.Query(sql, t.Id)
+	rows, err := queryHive(ctx, sql, t.Id)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.getTaskParam] sql %s error %s.", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.getTaskParam] sql %s error %s.", sql, err.Error())
 	}
 
 	//循环读取记录，格式化后存入变量ｂ
@@ -450,8 +1093,7 @@ func (t *Task) getTaskParam() error { // {{{
 		var name, value string
 		err = rows.Scan(&name, &value)
 		if err != nil {
-			e := fmt.Sprintf("\n[t.getTaskParam] %s.", err.Error())
-			return errors.New(e)
+			return newError(CodeStore, err, "\n[t.getTaskParam] %s.", err.Error())
 		}
 		t.Param = append(t.Param, value)
 	}
@@ -460,16 +1102,17 @@ func (t *Task) getTaskParam() error { // {{{
 
 //从元数据库获取Job下的Task列表。
 func (t *Task) getTaskAttr() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 
 	//查询指定的Task属性列表
 	sql := `SELECT ta.task_attr_name,
 			   ta.task_attr_value
 			FROM   scd_task_attr ta
 			WHERE  task_id = ?`
-	rows, err := g.HiveConn.Query(sql, t.Id)
+	rows, err := queryHive(ctx, sql, t.Id)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.getTaskAttr] sql %s error %s.", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.getTaskAttr] sql %s error %s.", sql, err.Error())
 	}
 
 	//循环读取记录，格式化后存入变量ｂ
@@ -477,8 +1120,7 @@ func (t *Task) getTaskAttr() error { // {{{
 		var name, value string
 		err = rows.Scan(&name, &value)
 		if err != nil {
-			e := fmt.Sprintf("\n[t.getTaskAttr] %s.", err.Error())
-			return errors.New(e)
+			return newError(CodeStore, err, "\n[t.getTaskAttr] %s.", err.Error())
 		}
 		t.Attr[name] = value
 	}
@@ -487,36 +1129,49 @@ func (t *Task) getTaskAttr() error { // {{{
 
 //从元数据库获取Task的依赖列表。
 func (t *Task) getRelTaskId() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	//查询Task的依赖列表
-	sql := `SELECT tr.rel_task_id
+	sql := `SELECT tr.rel_task_id,
+				   tr.rel_condition
 			FROM scd_task_rel tr
 			Where tr.task_id=?`
-	rows, err := g.HiveConn.Query(sql, t.Id)
+	rows, err := queryHive(ctx, sql, t.Id)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.getRelTaskId] sql %s error %s.", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.getRelTaskId] sql %s error %s.", sql, err.Error())
 	}
 
 	//循环读取记录
 	for rows.Next() {
 		var rtid int64
-		err = rows.Scan(&rtid)
+		var cond string
+		err = rows.Scan(&rtid, &cond)
 		if err != nil {
-			e := fmt.Sprintf("\n[t.getRelTaskId] %s.", err.Error())
-			return errors.New(e)
+			return newError(CodeStore, err, "\n[t.getRelTaskId] %s.", err.Error())
 		}
 		t.RelTasksId = append(t.RelTasksId, rtid)
+		if cond != "" && cond != RelOnSuccess {
+			t.RelConditions[rtid] = cond
+		}
 	}
 	return err
 } // }}}
 
 //更新任务至元数据库
 func (t *Task) update() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	sql := `UPDATE scd_task
 			SET task_address=?,
 				task_name=?,
 				task_cyc=?,
 				task_time_out=?,
+				task_retry_count=?,
+				task_retry_interval=?,
+				task_wave=?,
+				task_resource_pool=?,
+				task_executor_type=?,
+				task_disabled=?,
 				task_start=?,
 				task_type_id=?,
 				task_cmd=?,
@@ -524,54 +1179,131 @@ func (t *Task) update() error { // {{{
 				modify_user_id=?,
 				modify_time=?
 			WHERE task_id=?`
-	_, err := g.HiveConn.Exec(sql, &t.Address, &t.Name, &t.TaskCyc, &t.TimeOut, &t.StartSecond, &t.TaskType, &t.Cmd, &t.Desc, &t.ModifyUserId, &t.ModifyTime, &t.Id)
+	_, err := execDB(ctx, g.HiveConn, sql, &t.Address, &t.Name, &t.TaskCyc, &t.TimeOut, &t.RetryCount, &t.RetryInterval, &t.Wave, &t.ResourcePool, &t.ExecutorType, &t.Disabled, &t.StartSecond, &t.TaskType, &t.Cmd, &t.Desc, &t.ModifyUserId, &t.ModifyTime, &t.Id)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.update] sql %s error %s.", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.update] sql %s error %s.", sql, err.Error())
 	}
 	return err
 } // }}}
 
+//saveDisabled保存任务是否禁用
+func (t *Task) saveDisabled() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `UPDATE scd_task
+			SET task_disabled=?
+			WHERE task_id=?`
+	_, err := execDB(ctx, g.HiveConn, sql, &t.Disabled, &t.Id)
+	if err != nil {
+		return newError(CodeStore, err, "\n[t.saveDisabled] sql %s error %s.", sql, err.Error())
+	}
+	return nil
+} // }}}
+
+//getTaskEnv从元数据库获取Task的运行参数
+func (t *Task) getTaskEnv() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	t.Params = make(map[string]string)
+
+	sql := `SELECT te.env_name,
+			   te.env_value
+			FROM   scd_task_env te
+			WHERE  te.task_id = ?`
+	rows, err := queryHive(ctx, sql, t.Id)
+	if err != nil {
+		return newError(CodeStore, err, "\n[t.getTaskEnv] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, value string
+		if err = rows.Scan(&name, &value); err != nil {
+			return newError(CodeStore, err, "\n[t.getTaskEnv] %s.", err.Error())
+		}
+		t.Params[name] = value
+	}
+	return rows.Err()
+} // }}}
+
+//saveEnv删除Task原有的运行参数后将Params持久化到元数据库
+func (t *Task) saveEnv(tx execer) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	if err := t.delEnv(tx); err != nil {
+		return newError(CodeStore, err, "\n[t.saveEnv] %s", err.Error())
+	}
+
+	sql := `INSERT INTO scd_task_env
+            (task_id, env_name, env_value, create_user_id, create_time)
+			VALUES      (?, ?, ?, ?, ?)`
+	tm := time.Now()
+	for name, value := range t.Params {
+		if _, err := execDB(ctx, tx, sql, &t.Id, name, value, &t.ModifyUserId, &tm); err != nil {
+			return newError(CodeStore, err, "\n[t.saveEnv] sql %s error %s.", sql, err.Error())
+		}
+	}
+
+	return nil
+} // }}}
+
+//delEnv从元数据库删除Task的运行参数
+func (t *Task) delEnv(tx execer) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `DELETE FROM scd_task_env
+			WHERE task_id=?`
+	_, err := execDB(ctx, tx, sql, &t.Id)
+	if err != nil {
+		return newError(CodeStore, err, "\n[t.delEnv] sql %s error %s.", sql, err.Error())
+	}
+
+	return err
+} // }}}
+
 //DelParam方法从元数据库删除Task的Param信息
-func (t *Task) delParam() error { // {{{
+func (t *Task) delParam(tx execer) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	sql := `DELETE FROM scd_task_param
 			WHERE task_id=?`
-	_, err := g.HiveConn.Exec(sql, &t.Id)
+	_, err := execDB(ctx, tx, sql, &t.Id)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.delParam] sql %s error %s.", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.delParam] sql %s error %s.", sql, err.Error())
 	}
 
 	return err
 } // }}}
 
 //增加作业参数信息至元数据库
-func (t *Task) addParam(pvalue string) error { // {{{
-	pid, _ := t.getNewParamTaskId()
+func (t *Task) addParam(tx execer, pvalue string) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	pid, _ := t.getNewParamTaskId(tx)
 	sql := `INSERT INTO scd_task_param
             (scd_param_id,task_id, scd_param_name, scd_param_value,
              create_user_id, create_time)
 			VALUES      (?, ?, ?, ?, ?, ?)`
-	_, err := g.HiveConn.Exec(sql, &pid, &t.Id, "0", &pvalue, &t.CreateUserId, &t.CreateTime)
+	_, err := execDB(ctx, tx, sql, &pid, &t.Id, "0", &pvalue, &t.CreateUserId, &t.CreateTime)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.addParam] sql %s error %s.", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.addParam] sql %s error %s.", sql, err.Error())
 	}
 
 	return err
 } // }}}
 
 //获取新TaskParamId
-func (t *Task) getNewParamTaskId() (int64, error) { // {{{
+func (t *Task) getNewParamTaskId(tx execer) (int64, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 
 	//查询全部schedule列表
 	sql := `SELECT ifnull(max(p.scd_param_id),0) as scd_param_id
 			FROM scd_task_param p`
 
-	rows, err := g.HiveConn.Query(sql)
+	rows, err := queryTx(ctx, tx, sql)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.getNewParamTaskId] sql %s error %s.", sql, err.Error())
-		return -1, errors.New(e)
+		return -1, newError(CodeStore, err, "\n[t.getNewParamTaskId] sql %s error %s.", sql, err.Error())
 	}
 
 	var id int64
@@ -579,8 +1311,7 @@ func (t *Task) getNewParamTaskId() (int64, error) { // {{{
 	for rows.Next() {
 		err = rows.Scan(&id)
 		if err != nil {
-			e := fmt.Sprintf("[t.getNewParamTaskId] %s.\n", err.Error())
-			return -1, errors.New(e)
+			return -1, newError(CodeStore, err, "[t.getNewParamTaskId] %s.\n", err.Error())
 		}
 
 	}
@@ -589,16 +1320,17 @@ func (t *Task) getNewParamTaskId() (int64, error) { // {{{
 } // }}}
 
 //获取新JobTaskId
-func (t *Task) getNewRelTaskId() (int64, error) { // {{{
+func (t *Task) getNewRelTaskId(tx execer) (int64, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 
 	//查询全部schedule列表
 	sql := `SELECT ifnull(max(rt.task_rel_id),0) as task_rel_id
 			FROM scd_task_rel rt`
 
-	rows, err := g.HiveConn.Query(sql)
+	rows, err := queryTx(ctx, tx, sql)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.getNewRelTaskId] sql %s error %s.", sql, err.Error())
-		return -1, errors.New(e)
+		return -1, newError(CodeStore, err, "\n[t.getNewRelTaskId] sql %s error %s.", sql, err.Error())
 	}
 
 	var id int64
@@ -606,8 +1338,7 @@ func (t *Task) getNewRelTaskId() (int64, error) { // {{{
 	for rows.Next() {
 		err = rows.Scan(&id)
 		if err != nil {
-			e := fmt.Sprintf("[t.getNewRelTaskId] %s.\n", err.Error())
-			return -1, errors.New(e)
+			return -1, newError(CodeStore, err, "[t.getNewRelTaskId] %s.\n", err.Error())
 		}
 	}
 
@@ -615,24 +1346,24 @@ func (t *Task) getNewRelTaskId() (int64, error) { // {{{
 } // }}}
 
 //获取新Id
-func (t *Task) setNewId() (err error) { // {{{
+func (t *Task) setNewId(tx execer) (err error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	var id int64
 
 	//查询全部schedule列表
 	sql := `SELECT ifnull(max(t.task_id),0) as task_id
 			FROM scd_task t`
-	rows, err := g.HiveConn.Query(sql)
+	rows, err := queryTx(ctx, tx, sql)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.setNewId] sql %s error %s.", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.setNewId] sql %s error %s.", sql, err.Error())
 	}
 
 	//循环读取记录，格式化后存入变量ｂ
 	for rows.Next() {
 		err = rows.Scan(&id)
 		if err != nil {
-			e := fmt.Sprintf("[t.setNewId] %s.\n", err.Error())
-			return errors.New(e)
+			return newError(CodeStore, err, "[t.setNewId] %s.\n", err.Error())
 		}
 	}
 	t.Id = id + 1
@@ -642,58 +1373,56 @@ func (t *Task) setNewId() (err error) { // {{{
 } // }}}
 
 //增加作业信息至元数据库
-func (t *Task) add() (err error) { // {{{
-	err = t.setNewId()
+func (t *Task) add(tx execer) (err error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	err = t.setNewId(tx)
 	if err != nil {
-		e := fmt.Sprintf("[t.add] %s.\n", err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "[t.add] %s.\n", err.Error())
 	}
 
 	sql := `INSERT INTO scd_task
             (task_id, task_address, task_name, task_cyc,
-             task_time_out, task_start, task_type_id,
-             task_cmd, task_desc, create_user_id, create_time,
+             task_time_out, task_retry_count, task_retry_interval, task_wave, task_resource_pool, task_executor_type, task_disabled, task_start,
+             task_type_id, task_cmd, task_desc, create_user_id, create_time,
              modify_user_id, modify_time)
-			VALUES      (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	_, err = g.HiveConn.Exec(sql, &t.Id, &t.Address, &t.Name, &t.TaskCyc, &t.TimeOut, &t.StartSecond, &t.TaskType, &t.Cmd, &t.Desc, &t.CreateUserId, &t.CreateTime, &t.ModifyUserId, &t.ModifyTime)
+			VALUES      (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = execDB(ctx, tx, sql, &t.Id, &t.Address, &t.Name, &t.TaskCyc, &t.TimeOut, &t.RetryCount, &t.RetryInterval, &t.Wave, &t.ResourcePool, &t.ExecutorType, &t.Disabled, &t.StartSecond, &t.TaskType, &t.Cmd, &t.Desc, &t.CreateUserId, &t.CreateTime, &t.ModifyUserId, &t.ModifyTime)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.add] sql %s error %s.", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.add] sql %s error %s.", sql, err.Error())
 	}
 
-	t.RelTasksId = make([]int64, 0)
-	t.RelTasks = make(map[string]*Task)
-	t.Attr = make(map[string]string)
-	t.Param = make([]string, 0)
 	return err
 } // }}}
 
 //增加依赖任务至元数据库
-func (t *Task) addRelTask(id int64) error { // {{{
+func (t *Task) addRelTask(tx execer, id int64, cond string) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	tm := time.Now()
-	relid, _ := t.getNewRelTaskId()
+	relid, _ := t.getNewRelTaskId(tx)
 	sql := `INSERT INTO scd_task_rel
-            (task_rel_id, task_id, rel_task_id, create_user_id, create_time)
-			VALUES      (?, ?, ?, ?, ? )`
-	_, err := g.HiveConn.Exec(sql, &relid, &t.Id, &id, &t.CreateUserId, &tm)
+            (task_rel_id, task_id, rel_task_id, rel_condition, create_user_id, create_time)
+			VALUES      (?, ?, ?, ?, ?, ? )`
+	_, err := execDB(ctx, tx, sql, &relid, &t.Id, &id, &cond, &t.CreateUserId, &tm)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.addRelTask] sql %s error %s.", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.addRelTask] sql %s error %s.", sql, err.Error())
 	}
 
 	return err
 } // }}}
 
 //GetRelJobId获取最大的Id
-func (t *Task) getRelJobId() (int64, error) { // {{{
+func (t *Task) getRelJobId(tx execer) (int64, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 
 	//查询全部schedule列表
 	sql := `SELECT ifnull(max(t.job_task_id),0) as job_task_id
 			FROM scd_job_task t`
-	rows, err := g.HiveConn.Query(sql)
+	rows, err := queryTx(ctx, tx, sql)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.getRelJobId] sql %s error %s.", sql, err.Error())
-		return -1, errors.New(e)
+		return -1, newError(CodeStore, err, "\n[t.getRelJobId] sql %s error %s.", sql, err.Error())
 	}
 
 	var id int64
@@ -701,8 +1430,7 @@ func (t *Task) getRelJobId() (int64, error) { // {{{
 	for rows.Next() {
 		err = rows.Scan(&id)
 		if err != nil {
-			e := fmt.Sprintf("[t.getRelJobId] %s.\n", err.Error())
-			return -1, errors.New(e)
+			return -1, newError(CodeStore, err, "[t.getRelJobId] %s.\n", err.Error())
 		}
 	}
 
@@ -710,48 +1438,66 @@ func (t *Task) getRelJobId() (int64, error) { // {{{
 } // }}}
 
 //AddRelJob将Task与Job的关系持久化。
-func (t *Task) addRelJob() (err error) { // {{{
+func (t *Task) addRelJob(tx execer) (err error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	var id int64
-	if id, err = t.getRelJobId(); err == nil {
+	if id, err = t.getRelJobId(tx); err == nil {
 		sql := `INSERT INTO scd_job_task
             (job_task_id,job_id,task_id,job_task_no,
             create_user_id,create_time)
             VALUES    (?, ?, ?, ?, ?, ?)`
-		_, err = g.HiveConn.Exec(sql, &id, &t.JobId, &t.Id, &t.Id, &t.CreateUserId, &t.CreateTime)
+		_, err = execDB(ctx, tx, sql, &id, &t.JobId, &t.Id, &t.Id, &t.CreateUserId, &t.CreateTime)
 	}
 	return err
 } // }}}
 
 //删除依赖任务至元数据库
-func (t *Task) deleteRelTask(id int64) error { // {{{
+func (t *Task) deleteRelTask(tx execer, id int64) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	sql := `DELETE FROM scd_task_rel WHERE task_id=? and rel_task_id=?`
-	_, err := g.HiveConn.Exec(sql, &t.Id, &id)
+	_, err := execDB(ctx, tx, sql, &t.Id, &id)
+	if err != nil {
+		return newError(CodeStore, err, "\n[t.deleteRelTask] sql %s error %s.", sql, err.Error())
+	}
+
+	return err
+} // }}}
+
+//删除其它任务对本任务的依赖关系至元数据库
+func (t *Task) deleteDependents(tx execer) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `DELETE FROM scd_task_rel WHERE rel_task_id=?`
+	_, err := execDB(ctx, tx, sql, &t.Id)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.deleteRelTask] sql %s error %s.", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.deleteDependents] sql %s error %s.", sql, err.Error())
 	}
 
 	return err
 } // }}}
 
-func (t *Task) deleteJobTaskRel() (err error) { // {{{
+func (t *Task) deleteJobTaskRel(tx execer) (err error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	sql := `DELETE FROM scd_job_task WHERE job_id=? and task_id=?`
-	_, err = g.HiveConn.Exec(sql, &t.JobId, &t.Id)
+	_, err = execDB(ctx, tx, sql, &t.JobId, &t.Id)
 	if err != nil {
-		e := fmt.Sprintf("[t.deleteJobTaskRel] Query sql [%s] error %s.\n", sql, err.Error())
-		err = errors.New(e)
+		err = newError(CodeStore, err, "[t.deleteJobTaskRel] Query sql [%s] error %s.\n", sql, err.Error())
 	}
 
 	return err
 } // }}}
 
 //删除任务至元数据库
-func (t *Task) deleteTask() error { // {{{
+func (t *Task) deleteTask(tx execer) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 	sql := `DELETE FROM scd_task WHERE task_id=?`
-	_, err := g.HiveConn.Exec(sql, &t.Id)
+	_, err := execDB(ctx, tx, sql, &t.Id)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.deleteTask] sql %s error %s.", sql, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.deleteTask] sql %s error %s.", sql, err.Error())
 	}
 
 	return err
@@ -759,6 +1505,11 @@ func (t *Task) deleteTask() error { // {{{
 
 //保存执行日志
 func (s *ExecSchedule) Log() (err error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	if g.NoLog || s.DryRun {
+		return nil
+	}
 
 	if s.state == 0 {
 		sql := `INSERT INTO scd_schedule_log
@@ -768,23 +1519,32 @@ func (s *ExecSchedule) Log() (err error) { // {{{
 						 end_time,
 						 state,
 						 result,
-						 batch_type)
+						 batch_type,
+						 retry_no,
+						 prev_batch_id)
 			VALUES      (?,
 						 ?,
 						 ?,
 						 ?,
 						 ?,
 						 ?,
+						 ?,
+						 ?,
 						 ?)`
-		_, err = g.LogConn.Exec(sql, &s.batchId, &s.schedule.Id, &s.startTime, &s.endTime, &s.state, &s.result, &s.execType)
+		_, err = execDB(ctx, g.LogConn, sql, &s.batchId, &s.schedule.Id, &s.startTime, &s.endTime, &s.state, &s.result, &s.execType,
+			&s.retryNo, &s.prevBatchId)
 	} else {
+		s.lock.Lock()
+		errMsg := truncateOutput(s.lastError)
+		s.lock.Unlock()
 		sql := `UPDATE scd_schedule_log
 						 set start_time=?,
 						 end_time=?,
 						 state=?,
-						 result=?
+						 result=?,
+						 error_msg=?
 				WHERE batch_id=?`
-		_, err = g.LogConn.Exec(sql, &s.startTime, &s.endTime, &s.state, &s.result, &s.batchId)
+		_, err = execDB(ctx, g.LogConn, sql, &s.startTime, &s.endTime, &s.state, &s.result, &errMsg, &s.batchId)
 	}
 
 	return err
@@ -792,6 +1552,12 @@ func (s *ExecSchedule) Log() (err error) { // {{{
 
 //保存执行日志
 func (j *ExecJob) Log() (err error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	if g.NoLog {
+		return nil
+	}
+
 	if j.state == 0 {
 		sql := `INSERT INTO scd_job_log
 						(batch_job_id,batch_id,
@@ -809,7 +1575,7 @@ func (j *ExecJob) Log() (err error) { // {{{
 						 ?,
 						 ?,
 						 ?)`
-		_, err = g.LogConn.Exec(sql, &j.batchJobId, &j.batchId, &j.job.Id, &j.startTime, &j.endTime, &j.state, &j.result, &j.execType)
+		_, err = execDB(ctx, g.LogConn, sql, &j.batchJobId, &j.batchId, &j.job.Id, &j.startTime, &j.endTime, &j.state, &j.result, &j.execType)
 	} else {
 		sql := `UPDATE scd_job_log
 						 set start_time=?,
@@ -817,7 +1583,7 @@ func (j *ExecJob) Log() (err error) { // {{{
 						 state=?,
 						 result=?
 				WHERE batch_job_id=?`
-		_, err = g.LogConn.Exec(sql, &j.startTime, &j.endTime, &j.state, &j.result, &j.batchJobId)
+		_, err = execDB(ctx, g.LogConn, sql, &j.startTime, &j.endTime, &j.state, &j.result, &j.batchJobId)
 	}
 
 	return err
@@ -825,6 +1591,12 @@ func (j *ExecJob) Log() (err error) { // {{{
 
 //保存执行日志
 func (t *ExecTask) Log() (err error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	if g.NoLog {
+		return nil
+	}
+
 	if t.state == 0 {
 		sql := `INSERT INTO scd_task_log
 						(batch_task_id,batch_job_id,batch_id,
@@ -832,7 +1604,8 @@ func (t *ExecTask) Log() (err error) { // {{{
 						 start_time,
 						 end_time,
 						 state,
-						 batch_type)
+						 batch_type,
+						 exit_code)
 			VALUES      (?,
 						 ?,
 						 ?,
@@ -840,37 +1613,743 @@ func (t *ExecTask) Log() (err error) { // {{{
 						 ?,
 						 ?,
 						 ?,
+						 ?,
 						 ?)`
-		_, err = g.LogConn.Exec(sql, &t.batchTaskId, &t.batchJobId, &t.batchId, &t.task.Id, &t.startTime, &t.endTime, &t.state, &t.execType)
+		_, err = execDB(ctx, g.LogConn, sql, &t.batchTaskId, &t.batchJobId, &t.batchId, &t.task.Id, &t.startTime, &t.endTime, &t.state, &t.execType, &t.exitCode)
 	} else {
+		output := truncateOutput(t.output)
+		backoff := int64(t.dispatchBackoff / time.Millisecond)
+		timedOut, level := t.isTimedOut(), t.getTimeoutLevel()
 		sql := `UPDATE scd_task_log
 						 set start_time=?,
 						 end_time=?,
-						 state=?
+						 state=?,
+						 exit_code=?,
+						 output=?,
+						 dispatch_retry=?,
+						 dispatch_backoff=?,
+						 timed_out=?,
+						 timeout_level=?
 				WHERE batch_task_id=?`
-		_, err = g.LogConn.Exec(sql, &t.startTime, &t.endTime, &t.state, &t.batchTaskId)
+		_, err = execDB(ctx, g.LogConn, sql, &t.startTime, &t.endTime, &t.state, &t.exitCode, &output, &t.dispatchRetry, &backoff, &timedOut, &level, &t.batchTaskId)
 	}
 
 	return err
 } // }}}
 
-//getSuccessTaskId会根据传入的batchId从元数据库查找出执行成功的task
-func getSuccessTaskId(batchId string) []int64 { // {{{
+//truncateOutput按GlobalConfigStruct.TaskOutputLimit截断保存至日志库的任务输出，
+//保留开头的部分并在末尾注明截断前的长度，截断位置不会拆开多字节字符。
+func truncateOutput(output string) string { // {{{
+	limit := g.TaskOutputLimit
+	if limit <= 0 || len(output) <= limit {
+		return output
+	}
+
+	n := limit
+	for n > 0 && !utf8.RuneStart(output[n]) {
+		n--
+	}
+	return fmt.Sprintf("%s...(truncated, %d bytes)", output[:n], len(output))
+} // }}}
+
+//getTaskResults从日志库获取指定批次中全部任务的执行结果，按开始时间、任务ID排序。
+func getTaskResults(batchId string) ([]TaskResult, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `SELECT tl.task_id,
+				   tl.state,
+				   (SELECT COUNT(*)
+					FROM   scd_task_attempt_log al
+					WHERE  al.batch_task_id = tl.batch_task_id),
+				   COALESCE(tl.exit_code, -1),
+				   tl.start_time,
+				   tl.end_time,
+				   COALESCE(tl.output, ''),
+				   COALESCE(tl.dispatch_retry, 0),
+				   COALESCE(tl.dispatch_backoff, 0),
+				   COALESCE(tl.timed_out, 0),
+				   COALESCE(tl.timeout_level, '')
+			FROM   scd_task_log tl
+			WHERE  tl.batch_id = ?
+			ORDER  BY tl.start_time, tl.task_id`
+	rows, err := queryLog(ctx, sql, batchId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getTaskResults] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[getTaskResults] ", "\nsql=", sql)
+
+	results := make([]TaskResult, 0)
+	for rows.Next() {
+		var r TaskResult
+		var backoff int64
+		//升级前的记录没有退出码、输出、发送重试与超时标记，按未取得退出码、空输出、未重试、未超时返回
+		err = rows.Scan(&r.TaskId, &r.State, &r.Attempt, &r.ExitCode, &r.StartTime, &r.EndTime, &r.Output, &r.DispatchRetry, &backoff,
+			&r.TimedOut, &r.TimeoutLevel)
+		if err != nil {
+			return nil, newError(CodeStore, err, "\n[getTaskResults] %s.", err.Error())
+		}
+		r.Backoff = time.Duration(backoff) * time.Millisecond
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+} // }}}
+
+//getDoneTaskIds从日志库获取批次中已完成（执行成功或被忽略）的任务ID
+func getDoneTaskIds(batchId string) ([]int64, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
 
-	sql := `SELECT task_id
+	sql := `SELECT DISTINCT task_id
 			FROM   scd_task_log
-			WHERE  state = 3
+			WHERE  state IN (3, 5)
 			   AND batch_id =?`
-	rows, err := g.HiveConn.Query(sql, batchId)
-	CheckErr("getSuccessTaskId run Sql "+sql, err)
+	rows, err := queryLog(ctx, sql, batchId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getDoneTaskIds] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
 
 	taskIds := make([]int64, 0)
-	//循环读取记录，格式化后存入变量ｂ
 	for rows.Next() {
 		var id int64
-		rows.Scan(&id)
+		if err = rows.Scan(&id); err != nil {
+			return nil, newError(CodeStore, err, "\n[getDoneTaskIds] %s.", err.Error())
+		}
 		taskIds = append(taskIds, id)
 	}
 
-	return taskIds
-} // }}}// }}}
+	return taskIds, rows.Err()
+} // }}}
+
+//getTaskAttemptNo从日志库获取批次中各任务已记录的最大执行次数，键为任务ID
+func getTaskAttemptNo(batchId string) (map[int64]int, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+
+	sql := `SELECT task_id, max(attempt_no)
+			FROM   scd_task_attempt_log
+			WHERE  batch_id =?
+			GROUP  BY task_id`
+	rows, err := queryLog(ctx, sql, batchId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getTaskAttemptNo] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+
+	attempts := make(map[int64]int)
+	for rows.Next() {
+		var id int64
+		var no int
+		if err = rows.Scan(&id, &no); err != nil {
+			return nil, newError(CodeStore, err, "\n[getTaskAttemptNo] %s.", err.Error())
+		}
+		attempts[id] = no
+	}
+
+	return attempts, rows.Err()
+} // }}}
+
+//getTaskAvgDuration从日志库读取指定调度下执行成功的任务记录，
+//按任务计算平均执行时长并返回。
+func getTaskAvgDuration(scdId int64) (map[int64]time.Duration, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `SELECT tl.task_id,
+				   tl.start_time,
+				   tl.end_time
+			FROM   scd_task_log tl,
+				   scd_schedule_log sl
+			WHERE  tl.batch_id = sl.batch_id
+			   AND tl.state = 3
+			   AND sl.scd_id = ?`
+	rows, err := queryLog(ctx, sql, scdId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getTaskAvgDuration] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[getTaskAvgDuration] ", "\nsql=", sql)
+
+	total := make(map[int64]time.Duration)
+	cnt := make(map[int64]int64)
+	for rows.Next() {
+		var id int64
+		var st, et time.Time
+		if err = rows.Scan(&id, &st, &et); err != nil {
+			return nil, newError(CodeStore, err, "\n[getTaskAvgDuration] %s.", err.Error())
+		}
+
+		//未正常结束的记录不参与计算
+		if et.Before(st) {
+			continue
+		}
+		total[id] += et.Sub(st)
+		cnt[id]++
+	}
+
+	avg := make(map[int64]time.Duration)
+	for id, d := range total {
+		avg[id] = d / time.Duration(cnt[id])
+	}
+
+	return avg, rows.Err()
+} // }}}
+
+//logAttempt将任务的本次执行情况作为单独的一条记录保存至日志库。
+//GlobalConfigStruct.LogAttempts为false、NoLog为true或任务尚未发送执行时不做记录。
+func (t *ExecTask) logAttempt() (err error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	if !g.LogAttempts || g.NoLog || t.attempt == 0 {
+		return nil
+	}
+
+	worker := t.worker + g.Port
+	output := truncateOutput(t.output)
+	no := t.prevAttempts + t.attempt
+	sql := `INSERT INTO scd_task_attempt_log
+					(batch_task_id,
+					 batch_id,
+					 task_id,
+					 attempt_no,
+					 worker_addr,
+					 start_time,
+					 end_time,
+					 state,
+					 output)
+			VALUES  (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = execDB(ctx, g.LogConn, sql, &t.batchTaskId, &t.batchId, &t.task.Id, &no, &worker,
+		&t.attemptTime, &t.endTime, &t.state, &output)
+	if err != nil {
+		return newError(CodeStore, err, "\n[t.logAttempt] sql %s error %s.", sql, err.Error())
+	}
+
+	return nil
+} // }}}
+
+//getTaskAttempts从日志库获取指定批次中某个任务的全部执行记录，按执行次数排序。
+func getTaskAttempts(batchId string, taskId int64) ([]TaskAttempt, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `SELECT al.batch_id,
+				   al.task_id,
+				   al.attempt_no,
+				   al.worker_addr,
+				   al.start_time,
+				   al.end_time,
+				   al.state,
+				   al.output
+			FROM   scd_task_attempt_log al
+			WHERE  al.batch_id = ?
+			   AND al.task_id = ?
+			ORDER  BY al.attempt_no`
+	rows, err := queryLog(ctx, sql, batchId, taskId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getTaskAttempts] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[getTaskAttempts] ", "\nsql=", sql)
+
+	attempts := make([]TaskAttempt, 0)
+	for rows.Next() {
+		var a TaskAttempt
+		err = rows.Scan(&a.BatchId, &a.TaskId, &a.Attempt, &a.Worker, &a.StartTime, &a.EndTime, &a.State, &a.Output)
+		if err != nil {
+			return nil, newError(CodeStore, err, "\n[getTaskAttempts] %s.", err.Error())
+		}
+		attempts = append(attempts, a)
+	}
+
+	return attempts, rows.Err()
+} // }}}
+
+//getScheduleSources从元数据库获取全部调度定义文件的同步记录，以调度名称为key返回。
+func getScheduleSources() (map[string]*ScheduleSource, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `SELECT ss.scd_name,
+				   ss.scd_id,
+				   ss.source_file,
+				   ss.content_hash
+			FROM   scd_schedule_source ss`
+	rows, err := queryHive(ctx, sql)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getScheduleSources] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[getScheduleSources] ", "\nsql=", sql)
+
+	sources := make(map[string]*ScheduleSource)
+	for rows.Next() {
+		var name string
+		src := &ScheduleSource{}
+		if err = rows.Scan(&name, &src.ScheduleId, &src.File, &src.Hash); err != nil {
+			return nil, newError(CodeStore, err, "\n[getScheduleSources] %s.", err.Error())
+		}
+		sources[name] = src
+	}
+
+	return sources, rows.Err()
+} // }}}
+
+//saveScheduleSource保存调度定义文件的同步记录，已有记录先删除后增加。
+func saveScheduleSource(name string, scdId int64, file string, hash string) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	if err := deleteScheduleSource(name); err != nil {
+		return err
+	}
+
+	tm := time.Now()
+	sql := `INSERT INTO scd_schedule_source
+            (scd_name, scd_id, source_file, content_hash, modify_time)
+			VALUES      (?, ?, ?, ?, ?)`
+	_, err := execDB(ctx, g.HiveConn, sql, &name, &scdId, &file, &hash, &tm)
+	if err != nil {
+		return newError(CodeStore, err, "\n[saveScheduleSource] sql %s error %s.", sql, err.Error())
+	}
+	g.L.Debugln("[saveScheduleSource] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//deleteScheduleSource删除调度定义文件的同步记录
+func deleteScheduleSource(name string) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `DELETE FROM scd_schedule_source WHERE scd_name=?`
+	_, err := execDB(ctx, g.HiveConn, sql, &name)
+	if err != nil {
+		return newError(CodeStore, err, "\n[deleteScheduleSource] sql %s error %s.", sql, err.Error())
+	}
+
+	return nil
+} // }}}
+
+//getRunStates从日志库读取指定调度在start之后启动且已结束的批次，
+//返回批次总数以及其中执行成功的批次数。
+//批次状态为完成（3）且批次中没有失败、暂停的任务时视为成功。
+func getRunStates(scdId int64, start time.Time) (total int, success int, err error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `SELECT sl.batch_id,
+				   sl.state,
+				   (SELECT count(*)
+					FROM   scd_task_log tl
+					WHERE  tl.batch_id = sl.batch_id
+					   AND tl.state NOT IN (3, 5, 6)) fail_cnt
+			FROM   scd_schedule_log sl
+			WHERE  sl.scd_id = ?
+			   AND sl.start_time >= ?
+			   AND sl.state IN (3, 4)`
+	rows, err := queryLog(ctx, sql, scdId, start)
+	if err != nil {
+		return 0, 0, newError(CodeStore, err, "\n[getRunStates] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[getRunStates] ", "\nsql=", sql)
+
+	for rows.Next() {
+		var batchId string
+		var state int8
+		var failCnt int
+		if err = rows.Scan(&batchId, &state, &failCnt); err != nil {
+			return 0, 0, newError(CodeStore, err, "\n[getRunStates] %s.", err.Error())
+		}
+
+		total++
+		if state == 3 && failCnt == 0 {
+			success++
+		}
+	}
+
+	return total, success, rows.Err()
+} // }}}
+
+//getLastRunResults从日志库读取每个调度最近一个已结束（状态为3或4）的批次，按调度ID返回执行结果。
+//批次异常中止或其中有失败、暂停的任务时视为执行失败。
+func getLastRunResults() (map[int64]runResult, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `SELECT sl.scd_id,
+				   sl.start_time,
+				   sl.end_time,
+				   sl.state,
+				   coalesce(sl.error_msg, '') error_msg,
+				   (SELECT count(*)
+					FROM   scd_task_log tl
+					WHERE  tl.batch_id = sl.batch_id
+					   AND tl.state NOT IN (3, 5, 6)) fail_cnt
+			FROM   scd_schedule_log sl
+			WHERE  sl.state IN (3, 4)
+			   AND sl.start_time = (SELECT max(start_time)
+									FROM   scd_schedule_log
+									WHERE  scd_id = sl.scd_id
+									   AND state IN (3, 4))`
+	rows, err := queryLog(ctx, sql)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getLastRunResults] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[getLastRunResults] ", "\nsql=", sql)
+
+	results := make(map[int64]runResult)
+	for rows.Next() {
+		var scdId int64
+		var state int8
+		var failCnt int
+		r := runResult{}
+		if err = rows.Scan(&scdId, &r.startTime, &r.endTime, &state, &r.err, &failCnt); err != nil {
+			return nil, newError(CodeStore, err, "\n[getLastRunResults] %s.", err.Error())
+		}
+
+		r.failed = state != 3 || failCnt > 0
+		results[scdId] = r
+	}
+
+	return results, rows.Err()
+} // }}}
+
+//getRunHistory从日志库读取调度在[from, to)内启动的一页批次记录及其中任务的执行情况
+func getRunHistory(scdId int64, from, to time.Time, limit, offset int) ([]RunRecord, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `SELECT batch_id, batch_type, start_time, end_time, state
+			FROM   scd_schedule_log
+			WHERE  scd_id = ?
+			   AND start_time >= ?
+			   AND start_time < ?
+			ORDER  BY start_time DESC
+			LIMIT  ? OFFSET ?`
+	rows, err := queryLog(ctx, sql, scdId, from, to, limit, offset)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getRunHistory] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[getRunHistory] ", "\nsql=", sql)
+
+	records := make([]RunRecord, 0)
+	for rows.Next() {
+		r := RunRecord{ScheduleId: scdId, Tasks: make([]TaskRecord, 0)}
+		if err = rows.Scan(&r.BatchId, &r.ExecType, &r.StartTime, &r.EndTime, &r.State); err != nil {
+			return nil, newError(CodeStore, err, "\n[getRunHistory] %s.", err.Error())
+		}
+		records = append(records, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, newError(CodeStore, err, "\n[getRunHistory] %s.", err.Error())
+	}
+	rows.Close()
+
+	if len(records) == 0 {
+		return records, nil
+	}
+
+	//一次读取本页全部批次的任务记录
+	batchIds := make([]interface{}, 0, len(records))
+	marks := make([]string, 0, len(records))
+	for _, r := range records {
+		batchIds = append(batchIds, r.BatchId)
+		marks = append(marks, "?")
+	}
+	sql = `SELECT batch_id, task_id, start_time, end_time, state
+			FROM   scd_task_log
+			WHERE  batch_id IN (` + strings.Join(marks, ", ") + `)
+			ORDER  BY batch_id, task_id, start_time`
+	rows, err = queryLog(ctx, sql, batchIds...)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getRunHistory] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+
+	tasks := make(map[string][]TaskRecord)
+	for rows.Next() {
+		var batchId string
+		var tr TaskRecord
+		if err = rows.Scan(&batchId, &tr.TaskId, &tr.StartTime, &tr.EndTime, &tr.State); err != nil {
+			return nil, newError(CodeStore, err, "\n[getRunHistory] %s.", err.Error())
+		}
+
+		//重试的任务有多条记录，保留最后一次执行的信息
+		trs := tasks[batchId]
+		if n := len(trs); n > 0 && trs[n-1].TaskId == tr.TaskId {
+			trs[n-1] = tr
+			continue
+		}
+		tasks[batchId] = append(trs, tr)
+	}
+
+	for i := range records {
+		r := &records[i]
+		failCnt := 0
+		if trs, ok := tasks[r.BatchId]; ok {
+			r.Tasks = trs
+		}
+		for _, tr := range r.Tasks {
+			if tr.State == 2 || tr.State == 4 {
+				failCnt++
+			}
+		}
+		r.Outcome = runStatus(r.State, failCnt)
+	}
+
+	return records, rows.Err()
+} // }}}
+
+//getExecScheduleLog从日志库读取批次batchId的执行进度，批次不存在时返回nil。
+//任务数量按任务日志中各状态的任务统计，未开始执行的任务不计入。
+func getExecScheduleLog(batchId string) (*ExecScheduleInfo, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `SELECT scd_id, state, start_time, end_time,
+				   coalesce(retry_no, 0) retry_no,
+				   coalesce(prev_batch_id, '') prev_batch_id
+			FROM   scd_schedule_log
+			WHERE  batch_id = ?
+			ORDER  BY start_time DESC`
+	rows, err := queryLog(ctx, sql, batchId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getExecScheduleLog] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	info := &ExecScheduleInfo{BatchId: batchId}
+	var state int8
+	if err = rows.Scan(&info.ScheduleId, &state, &info.StartTime, &info.EndTime, &info.RetryNo, &info.PrevBatchId); err != nil {
+		return nil, newError(CodeStore, err, "\n[getExecScheduleLog] %s.", err.Error())
+	}
+	rows.Close()
+
+	//失败后重试的批次
+	sql = `SELECT batch_id
+			FROM   scd_schedule_log
+			WHERE  prev_batch_id = ?`
+	rows, err = queryLog(ctx, sql, batchId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getExecScheduleLog] sql %s error %s.", sql, err.Error())
+	}
+	if rows.Next() {
+		err = rows.Scan(&info.RetryBatchId)
+	}
+	rows.Close()
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getExecScheduleLog] %s.", err.Error())
+	}
+
+	sql = `SELECT state, count(DISTINCT task_id)
+			FROM   scd_task_log
+			WHERE  batch_id = ?
+			GROUP  BY state`
+	rows, err = queryLog(ctx, sql, batchId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getExecScheduleLog] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ts int8
+		var cnt int
+		if err = rows.Scan(&ts, &cnt); err != nil {
+			return nil, newError(CodeStore, err, "\n[getExecScheduleLog] %s.", err.Error())
+		}
+
+		info.TaskCnt += cnt
+		switch ts {
+		case 0, 1:
+			continue
+		case 3, 5:
+			info.SuccessTaskCnt += cnt
+		case 6:
+			info.SkipTaskCnt += cnt
+		default:
+			info.FailTaskCnt += cnt
+		}
+		info.DoneTaskCnt += cnt
+	}
+	info.Status = runStatus(state, info.FailTaskCnt)
+
+	return info, rows.Err()
+} // }}}
+
+//logArtifacts将任务登记的产出物引用保存至日志库
+func (t *ExecTask) logArtifacts() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	if g.NoLog || len(t.artifacts) == 0 {
+		return nil
+	}
+
+	tm := time.Now()
+	sql := `DELETE FROM scd_task_artifact WHERE batch_task_id=?`
+	if _, err := execDB(ctx, g.LogConn, sql, &t.batchTaskId); err != nil {
+		return newError(CodeStore, err, "\n[t.logArtifacts] sql %s error %s.", sql, err.Error())
+	}
+
+	sql = `INSERT INTO scd_task_artifact
+					(batch_task_id,
+					 batch_id,
+					 task_id,
+					 artifact_name,
+					 artifact_uri,
+					 create_time)
+			VALUES  (?, ?, ?, ?, ?, ?)`
+	for name, uri := range t.artifacts {
+		_, err := execDB(ctx, g.LogConn, sql, &t.batchTaskId, &t.batchId, &t.task.Id, name, uri, &tm)
+		if err != nil {
+			return newError(CodeStore, err, "\n[t.logArtifacts] sql %s error %s.", sql, err.Error())
+		}
+	}
+	g.L.Debugln("[t.logArtifacts] ", "\nsql=", sql)
+
+	return nil
+} // }}}
+
+//getTaskArtifacts从日志库读取指定批次中某个任务登记的产出物引用
+func getTaskArtifacts(batchId string, taskId int64) ([]Artifact, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `SELECT batch_id,
+				   task_id,
+				   artifact_name,
+				   artifact_uri,
+				   create_time
+			FROM   scd_task_artifact
+			WHERE  batch_id = ?
+			   AND task_id = ?
+			ORDER  BY artifact_name`
+	rows, err := queryLog(ctx, sql, batchId, taskId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getTaskArtifacts] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[getTaskArtifacts] ", "\nsql=", sql)
+
+	artifacts := make([]Artifact, 0)
+	for rows.Next() {
+		a := Artifact{}
+		if err = rows.Scan(&a.BatchId, &a.TaskId, &a.Name, &a.Uri, &a.CreateTime); err != nil {
+			return nil, newError(CodeStore, err, "\n[getTaskArtifacts] %s.", err.Error())
+		}
+		artifacts = append(artifacts, a)
+	}
+
+	return artifacts, rows.Err()
+} // }}}
+
+//getJobNext从元数据库读取全部作业的下级作业Id，返回作业Id与下级作业Id的对应关系
+func getJobNext() (map[int64]int64, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `SELECT job.job_id,
+				   job.next_job_id
+			FROM scd_job job`
+	rows, err := queryHive(ctx, sql)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getJobNext] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[getJobNext] ", "\nsql=", sql)
+
+	next := make(map[int64]int64)
+	for rows.Next() {
+		var id, nid int64
+		if err = rows.Scan(&id, &nid); err != nil {
+			return nil, newError(CodeStore, err, "\n[getJobNext] %s.", err.Error())
+		}
+		next[id] = nid
+	}
+
+	return next, rows.Err()
+} // }}}
+
+//getTaskJobsId从元数据库读取包含指定任务的作业Id
+func getTaskJobsId(taskId int64) ([]int64, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `SELECT jt.job_id
+			FROM scd_job_task jt
+			WHERE jt.task_id=?`
+	rows, err := queryHive(ctx, sql, taskId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getTaskJobsId] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[getTaskJobsId] ", "\nsql=", sql)
+
+	jobsId := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return nil, newError(CodeStore, err, "\n[getTaskJobsId] %s.", err.Error())
+		}
+		jobsId = append(jobsId, id)
+	}
+
+	return jobsId, rows.Err()
+} // }}}
+
+//logJournal向日志库追加一条执行日志
+func logJournal(scdId int64, batchId string, seq int64, t EventType, taskId int64, taskName string, attempt int, state int8, msg string) error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `INSERT INTO scd_run_journal
+					(batch_id,
+					 seq,
+					 scd_id,
+					 event_time,
+					 event_type,
+					 task_id,
+					 task_name,
+					 attempt_no,
+					 state,
+					 message)
+			VALUES  (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := execDB(ctx, g.LogConn, sql, batchId, seq, scdId, time.Now().Local(), string(t), taskId, taskName, attempt, state, msg)
+	if err != nil {
+		return newError(CodeStore, err, "\n[logJournal] sql %s error %s.", sql, err.Error())
+	}
+
+	return nil
+} // }}}
+
+//getJournal从日志库获取指定批次的全部执行日志，按序号排序。
+func getJournal(batchId string) ([]JournalEntry, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := `SELECT j.batch_id,
+				   j.seq,
+				   j.event_time,
+				   j.event_type,
+				   j.task_id,
+				   j.task_name,
+				   j.attempt_no,
+				   j.state,
+				   j.message
+			FROM   scd_run_journal j
+			WHERE  j.batch_id = ?
+			ORDER  BY j.seq`
+	rows, err := queryLog(ctx, sql, batchId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[getJournal] sql %s error %s.", sql, err.Error())
+	}
+	defer rows.Close()
+	g.L.Debugln("[getJournal] ", "\nsql=", sql)
+
+	entries := make([]JournalEntry, 0)
+	for rows.Next() {
+		var en JournalEntry
+		var t string
+		err = rows.Scan(&en.BatchId, &en.Seq, &en.Time, &t, &en.TaskId, &en.TaskName, &en.Attempt, &en.State, &en.Message)
+		if err != nil {
+			return nil, newError(CodeStore, err, "\n[getJournal] %s.", err.Error())
+		}
+		en.Type = EventType(t)
+		entries = append(entries, en)
+	}
+
+	return entries, rows.Err()
+} // }}}
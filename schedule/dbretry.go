@@ -0,0 +1,123 @@
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+//dbContext返回元数据库与日志库的一次操作使用的context，超时时间为GlobalConfigStruct.DBTimeout，
+//小于等于0时不设置超时。调用方在操作完成（包括读取完查询结果）后调用cancel。
+func dbContext(parent context.Context) (context.Context, context.CancelFunc) { // {{{
+	if g.DBTimeout > 0 {
+		return context.WithTimeout(parent, g.DBTimeout)
+	}
+	return context.WithCancel(parent)
+} // }}}
+
+//dbError在ctx超时或被取消时返回注明原因的error，其它情况原样返回err
+func dbError(ctx context.Context, err error) error { // {{{
+	if err == nil {
+		return nil
+	}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return errors.New(fmt.Sprintf("database operation timeout after %s, %s", g.DBTimeout, err.Error()))
+	case context.Canceled:
+		return errors.New(fmt.Sprintf("database operation is canceled, %s", err.Error()))
+	}
+	return err
+} // }}}
+
+//queryHive在元数据库上执行查询。
+//连接中断等临时错误按GlobalConfigStruct.DBMaxRetry重试，第n次重试前等待DBBackoff的2^(n-1)倍，
+//重试次数用完、ctx超时或遇到其它错误时返回error，由调用方决定如何处理，Shutdown关闭连接后返回ErrShutdown。
+//只用于查询，写入操作不能保证重复执行的结果一致，不做重试。
+func queryHive(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) { // {{{
+	if g.Schedules.closed() {
+		return nil, ErrShutdown
+	}
+	wait := g.DBBackoff
+	for attempt := 0; ; attempt++ {
+		rows, err := g.HiveConn.QueryContext(ctx, query, args...)
+		if err == nil || ctx.Err() != nil || !isTransient(err) || attempt >= g.DBMaxRetry {
+			return rows, dbError(ctx, err)
+		}
+
+		g.L.Warningln(fmt.Sprintf("[queryHive] metadata database error %s, retry %d/%d after %s.",
+			err.Error(), attempt+1, g.DBMaxRetry, wait))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, dbError(ctx, err)
+		}
+		wait *= 2
+	}
+} // }}}
+
+//queryTx在ex上执行查询，ex为元数据库事务时读取事务中尚未提交的写入，例如在同一事务中
+//连续生成新的Id；事务中不做重试。ex不是事务时同queryHive。
+func queryTx(ctx context.Context, ex execer, query string, args ...interface{}) (*sql.Rows, error) { // {{{
+	tx, ok := ex.(*sql.Tx)
+	if !ok {
+		return queryHive(ctx, query, args...)
+	}
+	if g.Schedules.closed() {
+		return nil, ErrShutdown
+	}
+	rows, err := tx.QueryContext(ctx, query, args...)
+	return rows, dbError(ctx, err)
+} // }}}
+
+//queryLog在日志库上执行查询，不做重试。Shutdown关闭连接后返回ErrShutdown
+func queryLog(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) { // {{{
+	if g.Schedules.closed() {
+		return nil, ErrShutdown
+	}
+	rows, err := g.LogConn.QueryContext(ctx, query, args...)
+	return rows, dbError(ctx, err)
+} // }}}
+
+//execDB通过ex执行写入操作，ex为元数据库、日志库的连接或事务。Shutdown关闭连接后返回ErrShutdown
+func execDB(ctx context.Context, ex execer, query string, args ...interface{}) (sql.Result, error) { // {{{
+	if g.Schedules.closed() {
+		return nil, ErrShutdown
+	}
+	r, err := ex.ExecContext(ctx, query, args...)
+	return r, dbError(ctx, err)
+} // }}}
+
+//临时错误的特征信息，不同驱动返回的连接错误类型不一致，按错误信息判断
+var transientMessages = []string{
+	"bad connection",
+	"invalid connection",
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"database is locked",
+}
+
+//isTransient判断错误是否为连接中断等可以重试的临时错误
+func isTransient(err error) bool { // {{{
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, m := range transientMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+} // }}}
@@ -0,0 +1,97 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+//默认按暂时性错误处理的状态码：请求过多、网关错误、服务不可用、网关超时
+var DefaultTransientCodes = []int{429, 502, 503, 504}
+
+//DispatchClassifier判断Executor发送任务时返回的错误是否为暂时性错误。
+//暂时性错误（如连接被拒绝、Worker繁忙）按GlobalConfigStruct.DispatchBackoff退避后重新发送，
+//其余错误视为永久性错误，任务立即失败。自定义的实现设置在GlobalConfigStruct.DispatchClassifier中。
+type DispatchClassifier interface {
+	Transient(err error) bool
+}
+
+//DispatchError为Executor发送任务被Worker拒绝时可以返回的错误，Code为Worker返回的状态码，
+//例如基于HTTP的Executor可以返回HTTP状态码，由DispatchClassifier按状态码判断是否重试。
+type DispatchError struct { // {{{
+	Addr    string //Worker地址
+	Code    int    //Worker返回的状态码
+	Message string //错误信息
+} // }}}
+
+func (de *DispatchError) Error() string { // {{{
+	return fmt.Sprintf("dispatch to [%s] is rejected, code %d %s", de.Addr, de.Code, de.Message)
+} // }}}
+
+//CodeClassifier为默认的DispatchClassifier。
+//DispatchError按状态码判断，状态码在列表中时为暂时性错误；
+//其它错误中连接被拒绝、连接中断、超时等网络错误为暂时性错误，判断方式与元数据库查询的重试相同，见isTransient。
+type CodeClassifier struct { // {{{
+	codes map[int]bool
+} // }}}
+
+//NewCodeClassifier返回按DefaultTransientCodes以及参数codes判断的CodeClassifier
+func NewCodeClassifier(codes ...int) *CodeClassifier { // {{{
+	c := &CodeClassifier{codes: make(map[int]bool)}
+	for _, code := range DefaultTransientCodes {
+		c.codes[code] = true
+	}
+	for _, code := range codes {
+		c.codes[code] = true
+	}
+	return c
+} // }}}
+
+func (c *CodeClassifier) Transient(err error) bool { // {{{
+	var de *DispatchError
+	if errors.As(err, &de) {
+		return c.codes[de.Code]
+	}
+	return isTransient(err)
+} // }}}
+
+//dispatch发送任务执行一次，发送遇到暂时性错误时退避后重新发送，最多重试GlobalConfigStruct.DispatchRetry次。
+//第n次重试前等待DispatchBackoff的2^(n-1)倍，不超过DispatchBackoffMax，并按RetryJitter浮动。
+//永久性错误、重试次数用完、批次被取消或等待会超过调度与作业的超时时间时返回最后一次的错误。
+//重试次数与累计等待的时间记录在dispatchRetry、dispatchBackoff中。
+func (et *ExecTask) dispatch(task *Task, reply *Reply) error { // {{{
+	classifier := g.DispatchClassifier
+	if classifier == nil {
+		classifier = NewCodeClassifier()
+	}
+
+	backoff := g.DispatchBackoff
+	for retry := 0; ; retry++ {
+		err := et.execute(task, reply)
+		if err == nil || retry >= g.DispatchRetry || !classifier.Transient(err) || et.cancelled() {
+			return err
+		}
+
+		if g.DispatchBackoffMax > 0 && backoff > g.DispatchBackoffMax {
+			backoff = g.DispatchBackoffMax
+		}
+		wait := retryWait(backoff)
+		if !et.deadline.IsZero() && time.Now().Add(wait).After(et.deadline) {
+			et.log.Warningln("task", et.task.Name, "dispatch is fail batchTaskId[", et.batchTaskId,
+				"] retry after", wait, "would run past the", et.deadlineBy, "timeout, give up")
+			return err
+		}
+
+		et.dispatchRetry++
+		et.dispatchBackoff += wait
+		et.log.Warningln("task", et.task.Name, "dispatch is fail batchTaskId[", et.batchTaskId, "]",
+			err.Error(), "retry", retry+1, "/", g.DispatchRetry, "after", wait)
+		select {
+		case <-time.After(wait):
+		case <-et.done:
+			return err
+		}
+		*reply = Reply{}
+		backoff *= 2
+	}
+} // }}}
@@ -0,0 +1,116 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//ErrorCode为ScheduleError的错误类型，调用方可以按它区分错误，例如映射为HTTP状态码。
+//ErrorCode实现了error，可以直接用于errors.Is：
+//
+//	if errors.Is(err, schedule.CodeNotFound) { ... }
+type ErrorCode int
+
+const (
+	CodeUnknown  ErrorCode = iota //未分类的错误
+	CodeNotFound                  //调度、作业、任务或批次不存在
+	CodeInvalid                   //参数或配置不正确，包括ValidationError
+	CodeConflict                  //与当前状态冲突，如调度已暂停、作业中还有任务
+	CodeStore                     //读写元数据库失败
+	CodeShutdown                  //ScheduleManager已关闭，见ErrShutdown
+)
+
+var codeNames = map[ErrorCode]string{
+	CodeUnknown:  "unknown",
+	CodeNotFound: "not found",
+	CodeInvalid:  "invalid",
+	CodeConflict: "conflict",
+	CodeStore:    "store error",
+	CodeShutdown: "shutdown",
+}
+
+func (c ErrorCode) Error() string { // {{{
+	if n, ok := codeNames[c]; ok {
+		return n
+	}
+	return fmt.Sprintf("error code %d", int(c))
+} // }}}
+
+//ScheduleError为ScheduleManager、Schedule的方法返回的错误。
+//Message为原有的错误信息，Err为引起错误的下层错误，可以通过errors.As、errors.Unwrap取得。
+//StartListener中按调度记录的初始化错误也使用ScheduleError，此时Message为空，
+//错误信息由调度与Err组成。
+//元数据库读写、执行结构的构建等下层错误在产生处即带有错误类型，经过多层包装后类型保持不变。
+type ScheduleError struct { // {{{
+	Code         ErrorCode //错误类型
+	ScheduleId   int64     //调度ID
+	ScheduleName string    //调度名称
+	Message      string    //错误信息
+	Err          error     //下层错误，可以为nil
+} // }}}
+
+func (se ScheduleError) Error() string { // {{{
+	if se.Message != "" {
+		return se.Message
+	}
+	return fmt.Sprintf("schedule [%d %s] %s", se.ScheduleId, se.ScheduleName, strings.TrimSpace(se.Err.Error()))
+} // }}}
+
+func (se ScheduleError) Unwrap() error { // {{{
+	return se.Err
+} // }}}
+
+//Is使errors.Is(err, code)在错误类型为code时成立
+func (se ScheduleError) Is(target error) bool { // {{{
+	c, ok := target.(ErrorCode)
+	return ok && c == se.Code
+} // }}}
+
+//Is使errors.Is(err, CodeInvalid)对校验失败的错误成立
+func (ve *ValidationError) Is(target error) bool { // {{{
+	return target == CodeInvalid
+} // }}}
+
+//newError返回错误信息按format生成的*ScheduleError。
+//cause为引起错误的下层错误，它带有错误类型时沿用它的类型，否则使用code，
+//例如元数据库返回的不存在错误经过多层包装后仍为CodeNotFound。
+func newError(code ErrorCode, cause error, format string, a ...interface{}) error { // {{{
+	if c := ErrorCodeOf(cause); c != CodeUnknown {
+		code = c
+	}
+	return &ScheduleError{Code: code, Message: fmt.Sprintf(format, a...), Err: cause}
+} // }}}
+
+//ErrorCodeOf返回err的错误类型，err为nil或没有错误类型时返回CodeUnknown
+func ErrorCodeOf(err error) ErrorCode { // {{{
+	var se *ScheduleError
+	if errors.As(err, &se) && se.Code != CodeUnknown {
+		return se.Code
+	}
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		return CodeInvalid
+	}
+	return CodeUnknown
+} // }}}
+
+//IsNotFound判断err是否因调度、作业、任务或批次不存在
+func IsNotFound(err error) bool { // {{{
+	return errors.Is(err, CodeNotFound)
+} // }}}
+
+//IsInvalid判断err是否因参数或配置不正确
+func IsInvalid(err error) bool { // {{{
+	return errors.Is(err, CodeInvalid)
+} // }}}
+
+//IsConflict判断err是否因与当前状态冲突
+func IsConflict(err error) bool { // {{{
+	return errors.Is(err, CodeConflict)
+} // }}}
+
+//IsStoreError判断err是否因读写元数据库失败
+func IsStoreError(err error) bool { // {{{
+	return errors.Is(err, CodeStore)
+} // }}}
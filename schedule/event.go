@@ -0,0 +1,283 @@
+package schedule
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+//事件类型
+type EventType string
+
+//一个批次的事件依次为：EventScheduleFired、EventRunStart，
+//之后每个作业开始时发布EventJobStart，每个任务发布EventTaskStart、EventTaskDone，
+//最后以EventRunEnd或EventRunFail结束。暂停、跳过的任务只发布EventTaskDone。
+const (
+	EventScheduleFired EventType = "schedule_fired" //调度被触发，定时启动或手动执行，批次已构建尚未开始
+	EventRunStart      EventType = "run_start"      //调度开始执行
+	EventJobStart      EventType = "job_start"      //作业中的第一个任务开始执行
+	EventTaskStart     EventType = "task_start"     //任务开始执行，重试不再发布
+	EventTaskDone      EventType = "task_done"      //任务执行完成，执行结果见State
+	EventRunEnd        EventType = "run_end"        //调度执行结束
+	EventRunFail       EventType = "run_fail"       //调度执行异常中止，执行超时中止时Message为timeout
+	EventRunWarn       EventType = "run_warn"       //调度执行时间超过预警时间（SoftTimeOut）
+)
+
+//订阅者通道已满时的处理策略，见GlobalConfigStruct.EventOverflow
+const (
+	EventOverflowDrop       = "drop"        //丢弃新的事件
+	EventOverflowDropOldest = "drop_oldest" //丢弃通道中最早的事件，放入新的事件
+	EventOverflowBuffer     = "buffer"      //不丢弃，超出的事件在内存中排队，按顺序继续发送
+)
+
+//调度事件信息结构
+type Event struct { // {{{
+	Type       EventType //事件类型
+	ScheduleId int64     //调度ID
+	BatchId    string    //批次ID
+	JobId      int64     //作业ID，仅作业、任务事件有值
+	TaskId     int64     //任务ID，仅任务事件有值
+	State      int8      //调度、作业或任务的状态
+	Message    string    //附加信息
+	Time       time.Time //事件发生时间
+} // }}}
+
+//订阅者信息
+type subscriber struct { // {{{
+	ch      chan Event    //事件通道
+	policy  string        //通道已满时的处理策略，订阅时确定
+	pending []Event       //EventOverflowBuffer策略下等待发送的事件
+	pumping bool          //正在将pending中的事件发送至通道
+	quit    chan struct{} //取消订阅时关闭，停止发送pending中的事件
+	dropped int64         //因通道已满被丢弃的事件数量
+} // }}}
+
+//eventBus负责将调度事件分发给全部订阅者。
+//每个订阅者持有一个有界的事件通道，发送时不等待，通道已满时按订阅者的策略丢弃或排队，
+//保证处理缓慢的订阅者不会阻塞调度的执行。
+type eventBus struct { // {{{
+	lock        sync.Mutex
+	subscribers map[int64]*subscriber //订阅者列表
+	nextId      int64                 //下一个订阅者ID
+	dropped     int64                 //全部订阅者被丢弃的事件数量
+} // }}}
+
+//创建事件分发结构
+func newEventBus() *eventBus { // {{{
+	return &eventBus{subscribers: make(map[int64]*subscriber)}
+} // }}}
+
+//subscribe增加一个订阅者，返回事件通道以及取消订阅的方法。
+func (b *eventBus) subscribe(size int, policy string) (<-chan Event, func()) { // {{{
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	id := b.nextId
+	b.nextId++
+	sub := &subscriber{ch: make(chan Event, size), policy: policy, quit: make(chan struct{})}
+	b.subscribers[id] = sub
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.lock.Lock()
+			defer b.lock.Unlock()
+			b.remove(id)
+		})
+	}
+
+	return sub.ch, cancel
+} // }}}
+
+//remove删除订阅者并关闭其通道，已删除时不做处理，调用方需持有lock
+func (b *eventBus) remove(id int64) { // {{{
+	sub, ok := b.subscribers[id]
+	if !ok {
+		return
+	}
+	delete(b.subscribers, id)
+	close(sub.quit)
+	//正在发送排队事件时由pump关闭通道，避免向已关闭的通道发送
+	if !sub.pumping {
+		close(sub.ch)
+	}
+} // }}}
+
+//close删除全部订阅者并关闭它们的通道，Shutdown时调用
+func (b *eventBus) close() { // {{{
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for id := range b.subscribers {
+		b.remove(id)
+	}
+} // }}}
+
+//publish将事件发送给全部订阅者，订阅者通道已满时按其策略处理，丢弃的事件计数。
+func (b *eventBus) publish(ev Event) { // {{{
+	if b == nil {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, sub := range b.subscribers {
+		//已有排队的事件时新事件也要排队，保证顺序
+		if sub.pumping {
+			sub.pending = append(sub.pending, ev)
+			continue
+		}
+
+		select {
+		case sub.ch <- ev:
+			continue
+		default:
+		}
+
+		switch sub.policy {
+		case EventOverflowBuffer:
+			sub.pending, sub.pumping = append(sub.pending, ev), true
+			go b.pump(sub)
+		case EventOverflowDropOldest:
+			select {
+			case <-sub.ch:
+			default:
+			}
+			select {
+			case sub.ch <- ev:
+			default:
+			}
+			sub.dropped++
+			b.dropped++
+		default:
+			sub.dropped++
+			b.dropped++
+		}
+	}
+} // }}}
+
+//pump将订阅者排队的事件按顺序发送至通道，全部发送后退出，取消订阅时关闭通道并退出。
+func (b *eventBus) pump(sub *subscriber) { // {{{
+	for {
+		b.lock.Lock()
+		if len(sub.pending) == 0 {
+			sub.pumping = false
+			//发送期间已取消订阅的，由这里关闭通道
+			select {
+			case <-sub.quit:
+				close(sub.ch)
+			default:
+			}
+			b.lock.Unlock()
+			return
+		}
+		ev := sub.pending[0]
+		b.lock.Unlock()
+
+		select {
+		case sub.ch <- ev:
+			b.lock.Lock()
+			sub.pending = sub.pending[1:]
+			b.lock.Unlock()
+		case <-sub.quit:
+			b.lock.Lock()
+			sub.pending, sub.pumping = nil, false
+			close(sub.ch)
+			b.lock.Unlock()
+			return
+		}
+	}
+} // }}}
+
+//Subscribe订阅调度的执行事件，返回一个只读的事件通道和取消订阅的方法。
+//通道的容量由GlobalConfigStruct.EventBuffer设置，订阅者来不及处理时，
+//按订阅时的GlobalConfigStruct.EventOverflow丢弃或排队，丢弃数量可通过EventsDropped查询。
+//发布事件不会等待订阅者，取消订阅后通道会被关闭。
+func (sl *ScheduleManager) Subscribe() (<-chan Event, func()) { // {{{
+	size := sl.Global.EventBuffer
+	if size <= 0 {
+		size = 1
+	}
+	return sl.events.subscribe(size, sl.Global.EventOverflow)
+} // }}}
+
+//EventsDropped返回因订阅者通道已满而被丢弃的事件总数。
+func (sl *ScheduleManager) EventsDropped() int64 { // {{{
+	sl.events.lock.Lock()
+	defer sl.events.lock.Unlock()
+	return sl.events.dropped
+} // }}}
+
+//publishEvent构建调度执行结构的事件并发送给订阅者。
+func (es *ExecSchedule) publishEvent(t EventType, taskId int64, state int8, msg string) { // {{{
+	if g == nil || g.Schedules == nil {
+		return
+	}
+
+	//状态变化同时追加到执行日志中
+	var task *Task
+	if taskId != 0 {
+		task = es.schedule.GetTaskById(taskId)
+	}
+	journal(es.schedule.Id, es.batchId, t, task, 0, state, msg)
+
+	//记录批次的执行结果，供GetScheduleStatus、ListSchedules使用
+	switch t {
+	case EventRunEnd:
+		g.Schedules.setRunResult(es.schedule.Id, es.runResult(es.failTaskCnt > 0))
+	case EventRunFail:
+		es.setError(msg)
+		g.Schedules.setRunResult(es.schedule.Id, es.runResult(true))
+	}
+
+	var jobId int64
+	if task != nil {
+		jobId = task.JobId
+	}
+	g.Schedules.events.publish(Event{
+		Type:       t,
+		ScheduleId: es.schedule.Id,
+		BatchId:    es.batchId,
+		JobId:      jobId,
+		TaskId:     taskId,
+		State:      state,
+		Message:    msg,
+		Time:       time.Now().Local(),
+	})
+} // }}}
+
+//publishJobStart在作业开始执行时发布EventJobStart事件。
+func (es *ExecSchedule) publishJobStart(ej *ExecJob) { // {{{
+	if g == nil || g.Schedules == nil {
+		return
+	}
+
+	msg := fmt.Sprintf("job [%d %s] is start", ej.job.Id, ej.job.Name)
+	journal(es.schedule.Id, es.batchId, EventJobStart, nil, 0, ej.state, msg)
+	g.Schedules.events.publish(Event{
+		Type:       EventJobStart,
+		ScheduleId: es.schedule.Id,
+		BatchId:    es.batchId,
+		JobId:      ej.job.Id,
+		State:      ej.state,
+		Message:    msg,
+		Time:       ej.startTime,
+	})
+} // }}}
+
+//publishStart在任务开始执行时发布EventTaskStart事件。
+//执行日志中已按每次发送记录JournalTaskStart，这里不再写入执行日志。
+func (et *ExecTask) publishStart() { // {{{
+	if g == nil || g.Schedules == nil {
+		return
+	}
+
+	g.Schedules.events.publish(Event{
+		Type:       EventTaskStart,
+		ScheduleId: et.execJob.job.ScheduleId,
+		BatchId:    et.batchId,
+		JobId:      et.execJob.job.Id,
+		TaskId:     et.task.Id,
+		State:      et.state,
+		Time:       et.startTime,
+	})
+} // }}}
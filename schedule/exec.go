@@ -2,9 +2,10 @@ package schedule
 
 import (
 	"bytes"
-	"errors"
+	"context"
 	"fmt"
-	"net/rpc"
+	"github.com/Sirupsen/logrus"
+	"github.com/rprp/hivego/metrics"
 	"runtime/debug"
 	"sync"
 	"time"
@@ -12,62 +13,143 @@ import (
 
 //根据传入的Schedule参数来构建一个调度的执行结构，并返回。
 func ExecScheduleWarper(s *Schedule) *ExecSchedule { // {{{
+	batchId := fmt.Sprintf("%s %d", time.Now().Local().Format("2006-01-02 15:04:05.000000"), s.Id) //批次ID
+	ctx, cancel := context.WithCancel(context.Background())
 	return &ExecSchedule{
-		batchId:      fmt.Sprintf("%s %d", time.Now().Local().Format("2006-01-02 15:04:05.000000"), s.Id), //批次ID
+		batchId:      batchId,
+		ctx:          ctx,
+		cancelRun:    cancel,
+		origin:       s,
 		schedule:     s,
+		log:          s.logEntry().WithField(LogFieldRun, batchId),
+		done:         make(chan struct{}),
 		execType:     1,
 		jobCnt:       s.JobCnt,
 		taskCnt:      s.TaskCnt,
 		execTasks:    make(map[int64]*ExecTask), //设置任务列表
 		execTaskChan: make(chan *ExecTask),
+		jobTimeout:   make(chan *ExecJob),
+		stopRun:      make(chan struct{}, 1),
 	}
 } // }}}
 
 //调度执行信息结构
 type ExecSchedule struct { // {{{
-	lock           sync.Mutex
+	lock           sync.Mutex          //保护产出物、任务列表以及状态、开始结束时间、任务计数，见snapshot
 	batchId        string              //批次ID，规则scheduleId + 周期开始时间(不含周期内启动时间)
-	schedule       *Schedule           //调度
+	origin         *Schedule           //发起执行的调度，执行结束后由它设置下次执行时间
+	schedule       *Schedule           //调度链的快照，初始化执行结构时生成
+	log            *logrus.Entry       //调度执行过程使用的log对象，附加了调度ID、批次ID字段
 	startTime      time.Time           //开始时间
 	endTime        time.Time           //结束时间
 	state          int8                //状态 0.不满足条件未执行 1. 执行中 2. 暂停 3. 完成 4.意外中止
 	result         float32             //结果,调度中执行成功任务的百分比
-	execType       int8                //执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行
+	execType       int8                //执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行 4.补数执行
+	window         time.Time           //批次的逻辑时间，补数执行时为补数的启动时间，其余批次为零值，见resolveWindow
 	execJob        *ExecJob            //作业执行信息
 	execTasks      map[int64]*ExecTask //任务执行信息
+	tasks          []*ExecTask         //批次中的全部任务，只在初始化时持有es.lock追加，见FindExecTasksByStatus
 	execTaskChan   chan *ExecTask      //taskChan用来传递完成的任务。当一个作业完成后会将自己放入taskChan变量中
+	jobTimeout     chan *ExecJob       //执行超过TimeOut的作业，由作业的超时定时器发送
+	stopRun        chan struct{}       //CancelRun发送的中止请求
 	jobCnt         int                 //调度中作业数量
 	taskCnt        int                 //调度中任务数量
 	successTaskCnt int                 //执行成功任务数量
 	failTaskCnt    int                 //执行失败任务数量
+	skipTaskCnt    int                 //执行条件不满足被跳过的任务数量
+	failedTasks    []int64             //执行失败的任务ID，见WebhookPayload
+	lastError      string              //批次失败的原因，任务失败时为第一个失败任务的输出，异常中止时为中止原因
+	artifacts      map[string]string   //本批次已完成任务的产出物，键为"任务名称.产出物名称"
+	waveCnt        map[int]int         //各执行阶段中尚未结束的任务数量
+	timedOut       bool                //批次执行超过TimeOut被中止，见cancel
+	groupCnt       map[int]int         //各并行组中尚未结束的任务数量
+	done           chan struct{}       //批次结束，从执行列表中移除时关闭
+	ctx            context.Context     //批次的上下文，CancelRun时取消，逐级传递到作业、正在执行的任务，见stop
+	cancelRun      context.CancelFunc  //取消批次的上下文
+	aborts         sync.WaitGroup      //正在执行、取消时需要通知中止的任务，见watch
+	abortMsgs      []string            //通知任务中止的结果，stop中全部返回后再记录
+	taskRuns       sync.WaitGroup      //正在执行的任务线程，超时或取消的批次结束时可能尚未结束，见TestRun
+	addTime        time.Time           //加入执行列表的时间，见reap
+	queued         bool                //排队等待上一批次结束后启动，结束后由上一批次设置下次执行时间
+	plan           *ExecPlan           //DryRun时生成的执行计划
+	resume         bool                //恢复执行的批次，执行日志已存在，初始化时不再写入，见ResumeRun
+	stopped        bool                //批次被CancelRun中止，不再重试
+	retryNo        int                 //调度级重试的序号，首次执行为0，见retry
+	prevBatchId    string              //重试前一次执行的批次ID，首次执行为空
+	firstStart     time.Time           //重试的批次中首次执行的开始时间，超时时间从它开始计算，见runStart
+
+	DryRun bool //只生成执行计划，不发送任务，也不写入执行日志，见Plan
 } // }}}
 
 //初始化调度的执行结构，使之包含完整的执行链。
+//执行结构基于调度链的快照构建，执行期间调度被重新初始化（InitSchedule）或修改时，
+//新的调度链只影响之后的批次，不影响正在执行的批次。
+//调度链的结构不完整时返回error信息，见Schedule.checkChain。
 func (es *ExecSchedule) InitExecSchedule() (err error) { // {{{
-	if err = es.Log(); err != nil {
-		return errors.New(fmt.Sprintf("\n[es.InitExecSchedule] %s", err.Error()))
+	if err = es.origin.checkChain(); err != nil {
+		return newError(CodeInvalid, err, "\n[es.InitExecSchedule] %s", err.Error())
+	}
+	if es.schedule, err = cloneSchedule(es.origin, nil); err != nil {
+		return newError(CodeInvalid, err, "\n[es.InitExecSchedule] %s", err.Error())
+	}
+	es.jobCnt, es.taskCnt = es.schedule.JobCnt, es.schedule.TaskCnt
+
+	if !es.resume {
+		if err = es.Log(); err != nil {
+			return newError(CodeStore, err, "\n[es.InitExecSchedule] %s", err.Error())
+		}
 	}
 
 	if es.schedule.Job != nil {
 		es.execJob = ExecJobWarper(es.batchId, es.schedule.Job)
 		err = es.execJob.InitExecJob(es)
 		if err != nil {
-			return errors.New(fmt.Sprintf("\n[es.InitExecSchedule] %s", err.Error()))
+			return newError(CodeInvalid, err, "\n[es.InitExecSchedule] %s", err.Error())
+		}
+		if err = es.initGroups(); err != nil {
+			return newError(CodeInvalid, err, "\n[es.InitExecSchedule] %s", err.Error())
 		}
 	}
 
 	return err
 } // }}}
 
+//initGroups设置各作业需等待的并行组。
+//调度链中连续且并行组相同的作业组成一组，组内作业之间只受任务依赖的约束，可以同时执行；
+//位于组之后的作业要等组内任务全部结束才能开始。未分组的作业不会阻塞之后的作业。
+//同一并行组的作业在调度链中不连续时返回error信息。
+func (es *ExecSchedule) initGroups() error { // {{{
+	groups := make([]int, 0)
+	prev := 0
+	for ej := es.execJob; ej != nil; ej = ej.nextJob {
+		pg := ej.job.ParallelGroup
+		for _, gid := range groups {
+			if gid == pg && gid != prev {
+				return newError(CodeInvalid, nil, "\n[es.initGroups] jobs of parallel group %d are not adjacent, job [%s] is separated from the group.", pg, ej.job.Name)
+			}
+			if gid != pg {
+				ej.waitGroups = append(ej.waitGroups, gid)
+			}
+		}
+		if pg != 0 && pg != prev {
+			groups = append(groups, pg)
+		}
+		prev = pg
+	}
+	return nil
+} // }}}
+
 //ExecSchedule执行前状态记录
 func (es *ExecSchedule) Start() (err error) { // {{{
+	es.lock.Lock()
 	es.startTime = time.Now().Local()
 	es.state = 1
+	es.lock.Unlock()
 	if err = es.Log(); err != nil {
-		es.state = 4
-		err = errors.New(fmt.Sprintf("\n[es.Start] %s", err.Error()))
+		es.setState(4)
+		err = newError(CodeStore, err, "\n[es.Start] %s", err.Error())
 	}
-	g.L.Infoln(es.schedule.Name, "is start batchId=[", es.batchId, "]")
+	es.log.Infoln(es.schedule.Name, "is start batchId=[", es.batchId, "]")
 
 	return err
 } // }}}
@@ -78,26 +160,28 @@ func (es *ExecSchedule) TaskDone(et *ExecTask) (finish bool, err error) { // {{{
 
 	//计算任务完成百分比
 	s := es.schedule
+	es.lock.Lock()
 	es.result = float32(s.TaskCnt-es.taskCnt) / float32(s.TaskCnt)
+	es.lock.Unlock()
 
 	if es.taskCnt == 0 { //调度结束
 		g.Schedules.RemoveExecSchedule(es.batchId)
 
 		//全部完成后，写入日志存储至数据库，设置下次启动时间
-		es.endTime = time.Now().Local()
-		es.state = 3
+		es.finish(3)
 		if err = es.Log(); err != nil {
-			es.state = 4
-			return true, errors.New(fmt.Sprintf("\n[es.TaskDone] %s", err.Error()))
+			es.setState(4)
+			return true, newError(CodeStore, err, "\n[es.TaskDone] %s", err.Error())
 		}
 
-		g.L.Infoln("schedule ", s.Name, " is end ", " batchId=", es.batchId,
-			" success=", es.successTaskCnt, " fail=", es.failTaskCnt, " result=", es.result)
+		es.log.Infoln("schedule ", s.Name, " is end ", " batchId=", es.batchId,
+			" success=", es.successTaskCnt, " fail=", es.failTaskCnt, " skip=", es.skipTaskCnt, " result=", es.result)
+		es.publishEvent(EventRunEnd, 0, es.state, "")
 
 		//自动调度执行，完成后设置下次执行时间
-		if es.execType == 1 {
+		if es.execType == 1 && !es.queued {
 			//设置下次执行时间
-			go s.Timer()
+			go es.origin.Timer()
 		}
 		return true, nil
 	}
@@ -112,52 +196,161 @@ func (es *ExecSchedule) TaskDone(et *ExecTask) (finish bool, err error) { // {{{
 //staskChan，从其中取出执行完毕的task后，会从其它任务的依赖列表中将已执行完毕的task删除，
 //并重新找出依赖列表为空的task，启动线程运行它的Run方法。
 //全部执行结束后，设置Schedule的下次启动时间。
+//
+//执行时间按调度的SoftTimeOut、TimeOut分两级控制，均为从开始执行起经过的秒数：
+//超过SoftTimeOut时记录警告并发布EventRunWarn事件，调度继续执行；
+//超过TimeOut时中止本次执行，见cancel。
+//只设置其中一个时只有对应的一级生效，SoftTimeOut不小于TimeOut时不会发出预警。
+//
+//设置了DryRun时只推演执行过程生成执行计划，见Plan，不发送任务、不发布事件，
+//除一条计划的概要信息外不记录日志。
 func (es *ExecSchedule) Run() { // {{{
 	var err error
+	if es.DryRun {
+		if es.plan, err = es.buildPlan(); err != nil {
+			es.log.Warningln(fmt.Sprintf("\n[es.Run] %s", err.Error()))
+			return
+		}
+		es.log.Infoln("schedule ", es.schedule.Name, " dry run batchId=", es.batchId,
+			" steps=", es.plan.Steps, " tasks=", len(es.plan.Tasks))
+		return
+	}
+
+	defer es.afterRun()
+	defer es.summarize()
+	defer es.notify()
+	defer es.observe()
+	defer es.cleanup()
+	defer es.recoverRun()
+
+	//同时执行的批次达到上限时按ScheduleThrottle等待或放弃
+	release, err := es.throttle()
+	if err != nil {
+		es.log.Warningln(fmt.Sprintf("\n[es.Run] %s", err.Error()))
+		es.publishEvent(EventRunFail, 0, es.state, err.Error())
+		return
+	}
+	defer release()
+
+	if err = es.checkTaskCap(); err != nil {
+		es.log.Warningln(fmt.Sprintf("\n[es.Run] %s", err.Error()))
+		es.publishEvent(EventRunFail, 0, es.state, err.Error())
+		return
+	}
 
 	if err = es.Start(); err != nil {
-		g.L.Warningln(fmt.Sprintf("\n[es.Run] %s", err.Error()))
+		es.log.Warningln(fmt.Sprintf("\n[es.Run] %s", err.Error()))
+		es.publishEvent(EventRunFail, 0, es.state, err.Error())
 		return
 	}
+	es.publishEvent(EventRunStart, 0, es.state, "")
+
+	//统计各执行阶段、并行组待执行的任务，并设置任务重试的截止时间
+	es.waveCnt, es.groupCnt = make(map[int]int), make(map[int]int)
+	for _, et := range es.execTasks {
+		es.waveCnt[et.task.Wave]++
+		if pg := et.execJob.job.ParallelGroup; pg != 0 {
+			es.groupCnt[pg]++
+		}
+		if es.schedule.TimeOut > 0 {
+			et.deadline = es.runStart().Add(time.Duration(es.schedule.TimeOut) * time.Second)
+			et.deadlineBy = TimeoutSchedule
+		}
+		et.done = es.done
+	}
+	defer es.stopJobTimers()
 
 	if err = es.RunTasks(); err != nil {
-		g.L.Warningln(fmt.Sprintf("\n[es.Run] %s", err.Error()))
+		es.log.Warningln(fmt.Sprintf("\n[es.Run] %s", err.Error()))
+		es.publishEvent(EventRunFail, 0, es.state, err.Error())
 		return
 	}
 
+	//执行时间的预警与超时定时器，未设置时通道为nil，不会触发
+	var softC, hardC <-chan time.Time
+	s := es.schedule
+	if s.SoftTimeOut > 0 && (s.TimeOut <= 0 || s.SoftTimeOut < s.TimeOut) {
+		soft := time.NewTimer(time.Until(es.runStart().Add(time.Duration(s.SoftTimeOut) * time.Second)))
+		defer soft.Stop()
+		softC = soft.C
+	}
+	if s.TimeOut > 0 {
+		hard := time.NewTimer(time.Until(es.runStart().Add(time.Duration(s.TimeOut) * time.Second)))
+		defer hard.Stop()
+		hardC = hard.C
+	}
+
 	//不断轮询taskChan中的信息，直到最后一个任务完成
 	//调用执行结构的Timer方法，并退出线程。
 	for {
 		select {
+		case <-softC:
+			e := fmt.Sprintf("schedule [%d %s] batchId=[%s] has run over soft timeout %ds, %d tasks left.",
+				s.Id, s.Name, es.batchId, s.SoftTimeOut, es.taskCnt)
+			es.log.Warningln("[es.Run]", e)
+			es.publishEvent(EventRunWarn, 0, es.state, e)
+
+		case <-hardC:
+			es.cancel()
+			return
+
+		case ej := <-es.jobTimeout:
+			es.abortJob(ej)
+
+		case <-es.stopRun:
+			es.stop()
+			return
+
 		case et := <-es.execTaskChan:
-			es.taskCnt--
+			es.unwatch(et)
+			et.setStatus(taskStatus(et.state))
+			es.waveCnt[et.task.Wave]--
+			if pg := et.execJob.job.ParallelGroup; pg != 0 {
+				es.groupCnt[pg]--
+				if et.failed() && g.GroupFailPolicy == GroupFailAbort {
+					es.abortGroup(pg, et)
+				}
+			}
 
-			//将该任务从其它任务的依赖列表中删除。
+			//将该任务从其它任务的依赖列表中删除，并按执行条件设置下级任务的状态。
 			for _, et1 := range es.execTasks {
-
-				//任务执行失败，将依赖的下级任务状态设置为2（暂停）
-				if et.state != 3 && et.state != 5 {
-					if _, ok := et1.relExecTasks[et.task.Id]; ok && et1.state != 2 {
-						et1.state = 2
-					}
+				if _, ok := et1.relExecTasks[et.task.Id]; ok {
+					et1.relDone(et)
 				}
 
 				delete(et1.relExecTasks, et.task.Id)
 				delete(et1.nextExecTasks, et.task.Id)
 			}
 
+			es.lock.Lock()
+			es.taskCnt--
 			if et.state == 3 || et.state == 5 { //任务执行成功或可以忽略
 				es.successTaskCnt++
-			} else if et.state == 2 {
-				es.failTaskCnt++ //暂停的也计入失败数量
-				g.L.Infoln("task", et.task.Name, "is pause batchTaskId[", et.batchTaskId, "] state=", et.state)
-			} else {
+			} else if et.state == 6 { //执行条件不满足，任务被跳过
+				es.skipTaskCnt++
+			} else { //暂停的也计入失败数量
 				es.failTaskCnt++
-				g.L.Infoln("task", et.task.Name, "is fail batchTaskId[", et.batchTaskId, "] state=", et.state)
+				es.failedTasks = append(es.failedTasks, et.task.Id)
+				if es.lastError == "" {
+					es.lastError = fmt.Sprintf("task [%d %s] is fail state=%d %s", et.task.Id, et.task.Name, et.state, et.output)
+				}
+			}
+			es.lock.Unlock()
+
+			if et.state == 6 {
+				es.log.Infoln("task", et.task.Name, "is skipped batchTaskId[", et.batchTaskId, "]")
+			} else if et.state == 2 {
+				es.log.Infoln("task", et.task.Name, "is pause batchTaskId[", et.batchTaskId, "] state=", et.state)
+			} else if et.state != 3 && et.state != 5 {
+				es.log.Infoln("task", et.task.Name, "is fail batchTaskId[", et.batchTaskId, "] state=", et.state)
 			}
 
+			es.addArtifacts(et)
+			es.publishEvent(EventTaskDone, et.task.Id, et.state, et.output)
+
 			if err = et.execJob.TaskDone(et); err != nil {
-				g.L.Warningln(fmt.Sprintf("\n[es.Run] %s", err.Error()))
+				es.log.Warningln(fmt.Sprintf("\n[es.Run] %s", err.Error()))
+				es.publishEvent(EventRunFail, 0, es.state, err.Error())
 				return
 			}
 
@@ -165,12 +358,14 @@ func (es *ExecSchedule) Run() { // {{{
 			if finish, err = es.TaskDone(et); finish && err == nil {
 				return
 			} else if err != nil {
-				g.L.Warningln(fmt.Sprintf("\n[es.Run] %s", err.Error()))
+				es.log.Warningln(fmt.Sprintf("\n[es.Run] %s", err.Error()))
+				es.publishEvent(EventRunFail, 0, es.state, err.Error())
 				return
 			}
 
 			if err = es.RunTasks(); err != nil {
-				g.L.Warningln(fmt.Sprintf("\n[es.Run] %s", err.Error()))
+				es.log.Warningln(fmt.Sprintf("\n[es.Run] %s", err.Error()))
+				es.publishEvent(EventRunFail, 0, es.state, err.Error())
 				return
 			}
 
@@ -179,31 +374,407 @@ func (es *ExecSchedule) Run() { // {{{
 
 } // }}}
 
+//observe在批次结束后记录执行次数与执行时间，见metrics包，执行结果见outcome。
+func (es *ExecSchedule) observe() { // {{{
+	outcome := es.outcome()
+	var d time.Duration
+	if !es.startTime.IsZero() {
+		d = time.Since(es.startTime)
+	}
+	g.metrics().RunDone(es.schedule.Id, outcome, d)
+} // }}}
+
 //执行参数ets中符合运行条件的任务
 func (es *ExecSchedule) RunTasks() (err error) { // {{{
 	//启动独立的任务
 	for _, et := range es.execTasks {
 
-		//依赖任务列表为空，任务可以执行
-		if len(et.relExecTasks) == 0 && (et.state == 0 || et.state == 2) {
+		//依赖任务列表为空且之前的执行阶段已全部结束，任务可以执行
+		if len(et.relExecTasks) == 0 && (et.state == 0 || et.state == 2 || et.state == 6) && es.waveReady(et.task.Wave) &&
+			es.jobReady(et.execJob) {
 
 			//任务所属作业开始时间为空，设置作业启动信息
+			first := et.execJob.startTime.IsZero()
 			if err = et.execJob.Start(); err != nil {
-				es.state = 4
-				return errors.New(fmt.Sprintf("\n[es.RunTasks] %s", err.Error()))
+				es.setState(4)
+				return newError(CodeStore, err, "\n[es.RunTasks] %s", err.Error())
+			}
+			if first {
+				es.publishJobStart(et.execJob)
+			}
+
+			//作业开始时启动超时定时器，任务的截止时间取调度与作业中较早的一个
+			es.watchJob(et.execJob)
+			if jd := et.execJob.deadline; !jd.IsZero() && (et.deadline.IsZero() || jd.Before(et.deadline)) {
+				et.deadline, et.deadlineBy = jd, TimeoutJob
 			}
 
 			//将该任务从任务列表中删除。
 			delete(es.execTasks, et.task.Id)
 
+			//替换参数中引用的上级任务产出物，生成运行参数
+			et.param = es.resolveParam(et)
+			et.params = es.resolveParams(et)
+
 			//执行任务，完成后任务会放入taskChan中
-			go et.Run(es.execTaskChan)
+			es.watch(et)
+			es.taskRuns.Add(1)
+			go func(et *ExecTask) {
+				defer es.taskRuns.Done()
+				et.Run(es.execTaskChan)
+			}(et)
 		}
 	}
 
 	return err
 } // }}}
 
+//checkTaskCap检查批次中待执行的任务数量是否超过GlobalConfigStruct.MaxTasksPerRun，
+//防止错误的调度链生成过多的任务耗尽内存和Worker。
+//超过时不执行任何任务，批次直接以状态4（意外中止）结束并返回error信息。
+func (es *ExecSchedule) checkTaskCap() error { // {{{
+	if g.MaxTasksPerRun <= 0 || len(es.execTasks) <= g.MaxTasksPerRun {
+		return nil
+	}
+
+	g.Schedules.RemoveExecSchedule(es.batchId)
+	es.lock.Lock()
+	es.startTime = time.Now().Local()
+	es.lock.Unlock()
+	es.finish(4)
+	if err := es.Log(); err != nil {
+		es.log.Warningln(fmt.Sprintf("\n[es.checkTaskCap] %s", err.Error()))
+	}
+
+	return newError(CodeInvalid, nil, "\n[es.checkTaskCap] schedule [%d %s] batchId=[%s] has %d tasks, exceeds the limit %d per run.",
+		es.schedule.Id, es.schedule.Name, es.batchId, len(es.execTasks), g.MaxTasksPerRun)
+} // }}}
+
+//waveReady判断执行阶段wave之前的阶段是否已全部结束。
+//执行阶段在任务依赖关系之上增加了屏障：即使依赖的任务都已完成，任务也要等待
+//所有阶段更小的任务（无论是否有依赖关系、执行成功或失败）结束后才开始执行。
+//任务的阶段均相同（默认为0）时，调度按依赖关系自由执行。
+func (es *ExecSchedule) waveReady(wave int) bool { // {{{
+	for w, cnt := range es.waveCnt {
+		if w < wave && cnt > 0 {
+			return false
+		}
+	}
+	return true
+} // }}}
+
+//failed判断任务是否执行失败：意外中止，或因上级任务失败而暂停
+func (et *ExecTask) failed() bool { // {{{
+	return et.state == 2 || et.state == 4
+} // }}}
+
+//relDone在依赖的任务rel结束后，按任务对rel的执行条件设置任务的状态：
+//条件为RelOnSuccess时rel失败则暂停（状态2）、rel被跳过则跳过（状态6）；
+//条件为RelOnFailure时rel未失败则跳过；RelAlways总是执行。
+//暂停优先于跳过，已暂停的任务不再改变状态。
+//rel被禁用时按GlobalConfigStruct.DisabledTaskPolicy处理，DisabledTaskPass时视同rel成功。
+func (et *ExecTask) relDone(rel *ExecTask) { // {{{
+	if et.state == 2 {
+		return
+	}
+
+	skipped := rel.state == 6 && !(rel.task.Disabled && g.DisabledTaskPolicy != DisabledTaskSkip)
+	switch et.task.relCondition(rel.task.Id) {
+	case RelAlways:
+	case RelOnFailure:
+		if !rel.failed() {
+			et.state = 6
+		}
+	default:
+		if rel.failed() {
+			et.state = 2
+		} else if skipped {
+			et.state = 6
+		}
+	}
+} // }}}
+
+//jobReady判断作业是否可以开始执行任务：作业之前的并行组已全部结束，
+//且作业尚未开始时，所在并行组中执行中的作业数量未达到GlobalConfigStruct.MaxParallelJobs。
+func (es *ExecSchedule) jobReady(ej *ExecJob) bool { // {{{
+	for _, gid := range ej.waitGroups {
+		if es.groupCnt[gid] > 0 {
+			return false
+		}
+	}
+
+	pg := ej.job.ParallelGroup
+	if pg == 0 || g.MaxParallelJobs <= 0 || !ej.startTime.IsZero() {
+		return true
+	}
+	running := 0
+	for j := es.execJob; j != nil; j = j.nextJob {
+		if j.job.ParallelGroup == pg && j.state == 1 {
+			running++
+		}
+	}
+	return running < g.MaxParallelJobs
+} // }}}
+
+//abortGroup在并行组中的任务失败时，将组内尚未开始的任务状态设置为2（暂停），
+//这些任务不再执行，随后按暂停的任务结束。
+func (es *ExecSchedule) abortGroup(pg int, failed *ExecTask) { // {{{
+	n := 0
+	for _, et := range es.execTasks {
+		if et.execJob.job.ParallelGroup == pg && et.state == 0 {
+			et.state = 2
+			n++
+		}
+	}
+	if n > 0 {
+		es.log.Warningln("task", failed.task.Name, "of parallel group", pg, "is fail batchTaskId[", failed.batchTaskId,
+			"], pause", n, "tasks of the group")
+	}
+} // }}}
+
+//watchJob在作业开始执行后按作业的TimeOut设置超时时间并启动定时器，
+//超时后作业被发送至jobTimeout，由Run调用abortJob中止。未设置TimeOut或已启动时不做处理。
+func (es *ExecSchedule) watchJob(ej *ExecJob) { // {{{
+	if ej.job.TimeOut <= 0 || ej.timer != nil {
+		return
+	}
+
+	ej.deadline = ej.startTime.Add(time.Duration(ej.job.TimeOut) * time.Second)
+	ej.timer = time.AfterFunc(ej.deadline.Sub(time.Now()), func() {
+		select {
+		case es.jobTimeout <- ej:
+		case <-es.done:
+		}
+	})
+} // }}}
+
+//stopJobTimers在Run结束时停止全部作业的超时定时器
+func (es *ExecSchedule) stopJobTimers() { // {{{
+	for ej := es.execJob; ej != nil; ej = ej.nextJob {
+		if ej.timer != nil {
+			ej.timer.Stop()
+		}
+	}
+} // }}}
+
+//abortJob在作业执行超过TimeOut时中止作业，调度中其它作业继续执行。
+//作业中尚未开始的任务置为4（意外中止）后按执行结束处理：依赖它们的任务按执行条件暂停或跳过，
+//位于并行组中时按GroupFailPolicy处理组内的其它任务。
+//与调度超时相同，取消作业的上下文通知Worker中止作业中正在执行的任务，见cancel。
+//尚未开始与正在执行的任务均记录为超时中止，执行日志中的timeout_level为job，调度超时中止的为schedule。
+func (es *ExecSchedule) abortJob(ej *ExecJob) { // {{{
+	if ej.timedOut || ej.taskCnt == 0 {
+		return
+	}
+	es.lock.Lock()
+	ej.timedOut = true
+	es.lock.Unlock()
+
+	aborted := make([]*ExecTask, 0)
+	for id, et := range es.execTasks {
+		if et.execJob == ej {
+			delete(es.execTasks, id)
+			aborted = append(aborted, et)
+		}
+	}
+
+	e := fmt.Sprintf("job [%d %s] batchJobId=[%s] has run over job timeout %ds, abort running tasks and %d tasks not started.",
+		ej.job.Id, ej.job.Name, ej.batchJobId, ej.job.TimeOut, len(aborted))
+	ej.log.Warningln("[es.abortJob]", e)
+	es.publishEvent(EventRunWarn, 0, es.state, e)
+
+	for _, et := range aborted {
+		et.startTime = time.Now().Local()
+		et.endTime = et.startTime
+		et.state = 4
+		et.output = fmt.Sprintf("task is aborted, job [%s] has run over job timeout %ds", ej.job.Name, ej.job.TimeOut)
+		et.timedOut, et.timeoutLevel = true, TimeoutJob
+		et.Log()
+		go func(et *ExecTask) { es.execTaskChan <- et }(et)
+	}
+
+	//取消作业的上下文，传递到作业中正在执行的任务，由各任务并发通知Worker中止，
+	//全部返回后再记录结果。被中止的任务结束后按执行失败处理
+	ej.cancel()
+	ej.aborts.Wait()
+	es.lock.Lock()
+	msgs := es.abortMsgs
+	es.abortMsgs = nil
+	es.lock.Unlock()
+	for _, msg := range msgs {
+		ej.log.Warningln("[es.abortJob]", msg)
+	}
+} // }}}
+
+//cancel在调度执行超过TimeOut时中止本次执行。
+//尚未开始的任务状态置为4（意外中止）并记录为超时中止，取消批次的上下文通知Worker中止正在执行的任务，
+//被中止的任务结束后同样记录为超时中止，执行日志中的timed_out为1，据此可以查询被超时中止的批次与任务。
+//调度状态置为4并发布Message为timeout的EventRunFail事件。自动调度的批次中止后会设置下次执行时间。
+func (es *ExecSchedule) cancel() { // {{{
+	s := es.schedule
+	now := time.Now().Local()
+	msg := fmt.Sprintf("task is aborted, schedule has run over timeout %ds", s.TimeOut)
+	es.lock.Lock()
+	es.timedOut = true
+	pending := make([]*ExecTask, 0, len(es.execTasks))
+	for _, et := range es.execTasks {
+		et.state, et.endTime, et.output = 4, now, msg
+		et.timedOut, et.timeoutLevel = true, TimeoutSchedule
+		et.setStatus(TaskFailed)
+		pending = append(pending, et)
+	}
+	es.lock.Unlock()
+	for _, et := range pending {
+		if err := et.Log(); err != nil {
+			et.log.Warningln(fmt.Sprintf("[es.cancel] %s", err.Error()))
+		}
+	}
+
+	es.cancelRun()
+	es.aborts.Wait()
+	es.lock.Lock()
+	msgs := es.abortMsgs
+	es.lock.Unlock()
+	for _, m := range msgs {
+		es.log.Warningln("[es.cancel]", m)
+	}
+	es.setError("timeout")
+	es.abandon()
+
+	es.log.Warningln("schedule", s.Name, "batchId=[", es.batchId, "] is cancelled, run over timeout", s.TimeOut,
+		"s success=", es.successTaskCnt, "fail=", es.failTaskCnt, "left=", es.taskCnt)
+	es.publishEvent(EventRunFail, 0, es.state, "timeout")
+
+	if es.execType == 1 && !es.queued {
+		go es.origin.Timer()
+	}
+} // }}}
+
+//stop在CancelRun时中止本次执行。
+//尚未开始的任务状态置为4（意外中止）并记录为已取消，取消批次的上下文，
+//逐级经作业传递到正在执行的任务，通知Worker中止，已结束的任务保持原有的执行日志。调度状态置为4并发布Message为cancelled的EventRunFail事件。
+//自动调度的批次中止后会设置下次执行时间。
+func (es *ExecSchedule) stop() { // {{{
+	s := es.schedule
+	now := time.Now().Local()
+	es.lock.Lock()
+	es.stopped = true
+	pending := make([]*ExecTask, 0, len(es.execTasks))
+	for _, et := range es.execTasks {
+		et.state, et.endTime, et.output = 4, now, "task is cancelled"
+		et.setStatus(TaskFailed)
+		pending = append(pending, et)
+	}
+	es.lock.Unlock()
+	for _, et := range pending {
+		if err := et.Log(); err != nil {
+			et.log.Warningln(fmt.Sprintf("[es.stop] %s", err.Error()))
+		}
+	}
+
+	//取消批次的上下文，逐级传递到正在执行的任务，由各任务并发通知Worker中止，
+	//全部返回后再记录结果，避免与任务的执行线程同时使用log对象
+	es.cancelRun()
+	es.aborts.Wait()
+	es.lock.Lock()
+	msgs := es.abortMsgs
+	es.lock.Unlock()
+	for _, msg := range msgs {
+		es.log.Warningln("[es.stop]", msg)
+	}
+	es.setError("cancelled")
+	es.abandon()
+
+	es.log.Warningln("schedule", s.Name, "batchId=[", es.batchId, "] is cancelled, success=", es.successTaskCnt,
+		"fail=", es.failTaskCnt, "left=", es.taskCnt)
+	es.publishEvent(EventRunFail, 0, es.state, "cancelled")
+
+	if es.execType == 1 && !es.queued {
+		go es.origin.Timer()
+	}
+} // }}}
+
+//watch在任务开始执行前从作业的上下文派生任务的上下文，上下文被取消时通知Worker中止任务。
+//任务结束被接收后通过unwatch停止监听，已结束的任务不受之后的取消影响。
+func (es *ExecSchedule) watch(et *ExecTask) { // {{{
+	ej := et.execJob
+	et.ctx, et.cancelCtx = context.WithCancel(ej.ctx)
+	es.aborts.Add(1)
+	ej.aborts.Add(1)
+	et.stopWatch = context.AfterFunc(et.ctx, func() {
+		defer es.aborts.Done()
+		defer ej.aborts.Done()
+		es.lock.Lock()
+		timedOut, jobTimedOut := es.timedOut, ej.timedOut
+		es.lock.Unlock()
+		if timedOut {
+			et.markTimedOut(TimeoutSchedule)
+		} else if jobTimedOut {
+			et.markTimedOut(TimeoutJob)
+		}
+		if msg := et.abort(); msg != "" {
+			es.lock.Lock()
+			es.abortMsgs = append(es.abortMsgs, msg)
+			es.lock.Unlock()
+		}
+	})
+} // }}}
+
+//unwatch在任务结束后停止监听并释放任务的上下文
+func (es *ExecSchedule) unwatch(et *ExecTask) { // {{{
+	if et.stopWatch == nil {
+		return
+	}
+	if et.stopWatch() {
+		es.aborts.Done()
+		et.execJob.aborts.Done()
+	}
+	et.cancelCtx()
+} // }}}
+
+//abandon将批次从执行列表中移除并记录为意外中止，正在执行的任务结束后不再影响本批次。
+func (es *ExecSchedule) abandon() { // {{{
+	g.Schedules.RemoveExecSchedule(es.batchId)
+
+	//接收正在执行的任务，避免其无法结束
+	if running := es.taskCnt - len(es.execTasks); running > 0 {
+		go func(c chan *ExecTask, n int) {
+			for ; n > 0; n-- {
+				et := <-c
+				es.unwatch(et)
+				et.log.Infoln("task", et.task.Name, "of cancelled batchTaskId[", et.batchTaskId, "] is end state=", et.state)
+			}
+		}(es.execTaskChan, running)
+	}
+
+	es.finish(4)
+	if err := es.Log(); err != nil {
+		es.log.Warningln(fmt.Sprintf("\n[es.abandon] %s", err.Error()))
+	}
+} // }}}
+
+//setState设置批次的状态
+func (es *ExecSchedule) setState(state int8) { // {{{
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	es.state = state
+} // }}}
+
+//finish记录批次结束，设置结束时间与状态
+func (es *ExecSchedule) finish(state int8) { // {{{
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	es.endTime = time.Now().Local()
+	es.state = state
+} // }}}
+
+//setError记录批次失败的原因，批次结束时随执行日志保存
+func (es *ExecSchedule) setError(msg string) { // {{{
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	es.lastError = msg
+} // }}}
+
 //Pause暂停调度执行
 func (es *ExecSchedule) Pause() { // {{{
 	es.lock.Lock()
@@ -224,9 +795,17 @@ type ExecJob struct { // {{{
 	state      int8                //状态 0.不满足条件未执行 1. 执行中 2. 暂停 3. 完成 4.意外中止
 	result     float32             //结果执行成功任务的百分比
 	nextJob    *ExecJob            //下一个作业
-	execType   int8                //执行类型1. 自动定时调度 2.手动人工调度 3.修复执行
+	execType   int8                //执行类型1. 自动定时调度 2.手动人工调度 3.修复执行 4.补数执行
 	execTasks  map[int64]*ExecTask //任务执行信息
 	taskCnt    int                 //作业中任务数量
+	waitGroups []int               //调度链中位于该作业之前的并行组，全部结束后作业才能开始
+	deadline   time.Time           //作业的超时时间，零值表示不限制
+	timer      *time.Timer         //作业的超时定时器，未设置TimeOut时为nil
+	timedOut   bool                //作业执行超过TimeOut被中止，Run之外读取时需持有es.lock
+	ctx        context.Context     //作业的上下文，从批次的上下文派生，作业结束或超时时取消
+	cancel     context.CancelFunc  //取消作业的上下文
+	aborts     sync.WaitGroup      //作业中正在执行的任务，作业超时时等待它们的中止通知返回，见abortJob
+	log        *logrus.Entry       //作业执行过程使用的log对象，在调度的基础上附加了作业ID字段
 } // }}}
 
 //根据传入的batchId和Job参数来构建一个调度的执行结构，并返回。
@@ -244,20 +823,29 @@ func ExecJobWarper(batchId string, j *Job) *ExecJob { // {{{
 
 //初始化作业执行链，并返回。
 func (ej *ExecJob) InitExecJob(es *ExecSchedule) (err error) { // {{{
-	if err = ej.Log(); err != nil {
-		e := fmt.Sprintf("\n[ej.InitExecJob] %s %s", ej.job.Name, err.Error())
-		return errors.New(e)
+	ej.log, ej.execType = es.log.WithField(LogFieldJob, ej.job.Id), es.execType
+	ej.ctx, ej.cancel = context.WithCancel(es.ctx)
+	if !es.DryRun && !es.resume {
+		if err = ej.Log(); err != nil {
+			return newError(CodeStore, err, "\n[ej.InitExecJob] %s %s", ej.job.Name, err.Error())
+		}
 	}
 
 	//构建当前作业中的任务执行结构
 	for _, t := range ej.job.Tasks { // {{{
 		et := ExecTaskWarper(ej, t)
-		if err = et.InitExecTask(es); err != nil {
-			e := fmt.Sprintf("\n[ej.InitExecJob] %s %s", ej.job.Name, err.Error())
-			return errors.New(e)
-		}
 		ej.execTasks[t.Id] = et
 		es.execTasks[t.Id] = et
+		es.lock.Lock()
+		es.tasks = append(es.tasks, et)
+		es.lock.Unlock()
+	} // }}}
+
+	//作业中的任务全部构建后再设置依赖关系，任务可以依赖同一作业中的任务
+	for _, et := range ej.execTasks { // {{{
+		if err = et.InitExecTask(es); err != nil {
+			return newError(CodeInvalid, err, "\n[ej.InitExecJob] %s %s", ej.job.Name, err.Error())
+		}
 	} // }}}
 
 	ej.taskCnt = len(ej.execTasks)
@@ -278,9 +866,9 @@ func (ej *ExecJob) Start() (err error) { // {{{
 		ej.state = 1
 		if err = ej.Log(); err != nil {
 			ej.state = 4
-			err = errors.New(fmt.Sprintf("\n[ej.Start] %s", err.Error()))
+			err = newError(CodeStore, err, "\n[ej.Start] %s", err.Error())
 		}
-		g.L.Infoln("job ", ej.job.Name, " is start ", " batchJobId[", ej.batchJobId, "]")
+		ej.log.Infoln("job ", ej.job.Name, " is start ", " batchJobId[", ej.batchJobId, "]")
 	}
 
 	return err
@@ -292,13 +880,17 @@ func (ej *ExecJob) TaskDone(et *ExecTask) (err error) { // {{{
 	//计算任务完成百分比
 	ej.result = float32(ej.job.TaskCnt-ej.taskCnt) / float32(ej.job.TaskCnt)
 	if ej.taskCnt == 0 { //作业结束
+		ej.cancel()
 		ej.endTime = time.Now().Local()
 		ej.state = 3
+		if ej.timedOut {
+			ej.state = 4
+		}
 		if err = ej.Log(); err != nil {
 			ej.state = 4
-			err = errors.New(fmt.Sprintf("\n[ej.TaskDone] %s", err.Error()))
+			err = newError(CodeStore, err, "\n[ej.TaskDone] %s", err.Error())
 		}
-		g.L.Infoln("job ", ej.job.Name, " is end ", " batchJobId[", ej.batchJobId, "] result=", ej.result)
+		ej.log.Infoln("job ", ej.job.Name, " is end ", " batchJobId[", ej.batchJobId, "] result=", ej.result)
 	}
 
 	return err
@@ -306,18 +898,42 @@ func (ej *ExecJob) TaskDone(et *ExecTask) (err error) { // {{{
 
 //任务执行信息结构
 type ExecTask struct { // {{{
-	batchTaskId   string              //任务批次ID，作业批次ID + 任务ID
-	batchJobId    string              //作业批次ID，批次ID + 作业ID
-	batchId       string              //批次ID，规则scheduleId + 周期开始时间(不含周期内启动时间)
-	task          *Task               //任务
-	startTime     time.Time           //开始时间
-	endTime       time.Time           //结束时间
-	state         int8                //状态 0.初始状态 1. 执行中 2. 暂停 3. 完成 4.意外中止 5.忽略
-	execType      int8                //执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行
-	execJob       *ExecJob            //任务所属作业
-	output        string              //任务输出
-	nextExecTasks map[int64]*ExecTask //下级任务执行信息
-	relExecTasks  map[int64]*ExecTask //依赖的任务
+	batchTaskId     string              //任务批次ID，作业批次ID + 任务ID
+	batchJobId      string              //作业批次ID，批次ID + 作业ID
+	batchId         string              //批次ID，规则scheduleId + 周期开始时间(不含周期内启动时间)
+	task            *Task               //任务
+	startTime       time.Time           //开始时间
+	endTime         time.Time           //结束时间
+	state           int8                //状态 0.初始状态 1. 执行中 2. 暂停 3. 完成 4.意外中止 5.忽略 6.跳过
+	execType        int8                //执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行 4.补数执行
+	execJob         *ExecJob            //任务所属作业
+	output          string              //任务输出
+	exitCode        int                 //任务命令的退出码，-1表示未取得
+	attempt         int                 //任务的执行次数
+	prevAttempts    int                 //恢复执行前已记录的执行次数，见ResumeRun
+	dispatchRetry   int                 //发送遇到暂时性错误后的重试次数，各次执行累计，见dispatch
+	dispatchBackoff time.Duration       //发送重试累计等待的时间
+	attemptTime     time.Time           //本次执行的开始时间
+	worker          string              //本次执行的Worker地址
+	timedOut        bool                //任务因调度或作业执行超过TimeOut被中止，而不是自然结束，随执行日志保存
+	timeoutLevel    string              //任务被中止时超时的级别，取值见TimeoutSchedule、TimeoutJob，随执行日志保存
+	deadline        time.Time           //调度与作业超时时间中较早的一个，重试不能超过该时间，零值表示不限制
+	deadlineBy      string              //deadline所属的超时级别，取值见TimeoutSchedule、TimeoutJob
+	done            <-chan struct{}     //批次结束或被取消时关闭，等待资源池时放弃等待
+	param           []string            //发送执行的任务参数，已替换其中的产出物引用
+	params          map[string]string   //发送执行的运行参数，已合并调度的参数并执行模板，见resolveParams
+	artifacts       map[string]string   //任务登记的产出物
+	nextExecTasks   map[int64]*ExecTask //下级任务执行信息
+	relExecTasks    map[int64]*ExecTask //依赖的任务
+	log             *logrus.Entry       //任务执行过程使用的log对象，在作业的基础上附加了任务ID字段
+	status          TaskStatus          //供外部查询的执行状态，state只在执行过程内部使用，见setStatus
+	statusTime      time.Time           //进入当前执行状态的时间
+	lock            sync.Mutex          //保护sent、cancel、status、statusTime、timedOut、timeoutLevel
+	sent            *Task               //正在执行的任务，包含实际的执行地址，CancelRun或调度超时时据此中止
+	cancel          func()              //在进程内执行时取消任务的执行，见executeLocal
+	ctx             context.Context     //任务的上下文，从作业的上下文派生，开始执行时创建
+	cancelCtx       context.CancelFunc  //取消任务的上下文
+	stopWatch       func() bool         //停止监听任务上下文的取消，见watch
 } // }}}
 
 //根据传入的batchId和Job参数来构建一个调度的执行结构，并返回。
@@ -328,22 +944,47 @@ func ExecTaskWarper(ej *ExecJob, t *Task) *ExecTask { // {{{
 		batchId:       ej.batchId,
 		task:          t,
 		state:         0,
-		execType:      1,
+		execType:      ej.execType,
 		execJob:       ej,
+		exitCode:      -1,
+		status:        TaskPending,
+		statusTime:    time.Now().Local(),
+		log:           ej.log.WithField(LogFieldTask, t.Id),
 		relExecTasks:  make(map[int64]*ExecTask),
 		nextExecTasks: make(map[int64]*ExecTask),
 	}
 } // }}}
 
+//setStatus在持有et.lock的情况下设置供外部查询的执行状态
+func (et *ExecTask) setStatus(status TaskStatus) { // {{{
+	et.lock.Lock()
+	defer et.lock.Unlock()
+	if et.status != status {
+		et.status, et.statusTime = status, time.Now().Local()
+	}
+} // }}}
+
 //初始化Task执行结构
 func (et *ExecTask) InitExecTask(es *ExecSchedule) error { // {{{
-	if err := et.Log(); err != nil {
-		e := fmt.Sprintf("\n[et.InitExecTask] %s %s", et.task.Name, err.Error())
-		return errors.New(e)
+	if !es.DryRun && !es.resume {
+		if err := et.Log(); err != nil {
+			return newError(CodeStore, err, "\n[et.InitExecTask] %s %s", et.task.Name, err.Error())
+		}
 	}
 
 	for _, relTask := range et.task.RelTasks {
-		retask := es.execTasks[relTask.Id]
+		if relTask == nil {
+			continue
+		}
+		retask, ok := es.execTasks[relTask.Id]
+		if !ok {
+			return newError(CodeInvalid, nil, "\n[et.InitExecTask] task [%s] depends on task [%d] which is not found in current or previous jobs.", et.task.Name, relTask.Id)
+		}
+
+		//依赖的任务处于更晚的执行阶段时，任务永远无法开始
+		if relTask.Wave > et.task.Wave {
+			return newError(CodeInvalid, nil, "\n[et.InitExecTask] task [%s] wave %d depends on task [%s] in later wave %d.", et.task.Name, et.task.Wave, relTask.Name, relTask.Wave)
+		}
 		et.relExecTasks[relTask.Id] = retask
 
 		//将execTask设置为依赖任务的下级任务
@@ -353,32 +994,44 @@ func (et *ExecTask) InitExecTask(es *ExecSchedule) error { // {{{
 	return nil
 } // }}}
 
-type Reply struct { // {{{
-	Err    string //错误信息
-	Stdout string //标准输出
-} // }}}
-
 //Run方法负责执行任务。
 //首先会判断是否符合执行条件，符合则执行
-//执行时会从任务执行结构中取出需要执行的信息，通过RPC发送给执行模块执行。
+//执行时会从任务执行结构中取出需要执行的信息，交给GlobalConfigStruct.Executor执行。
 //完成后更新执行信息，并将任务置入taskChan变量中，供后续处理。
+//执行中发生panic时恢复，任务记录为意外中止（状态4）并同样置入taskChan，不影响批次中的其它任务。
 func (et *ExecTask) Run(taskChan chan *ExecTask) { // {{{
 	rl := &Reply{}
 	defer func() { // {{{
 		if err := recover(); err != nil {
 			var buf bytes.Buffer
 			buf.Write(debug.Stack())
+			g.metrics().Panic(metrics.PanicTask)
 			et.endTime = time.Now().Local()
 			et.state = 4
-			g.L.Warningln("task run error", "batchTaskId[", et.batchTaskId, "] TaskName=",
+			et.output = fmt.Sprintf("%stask panic %v", et.output, err)
+			et.log.Warningln("task run error", "batchTaskId[", et.batchTaskId, "] TaskName=",
 				et.task.Name, "output=", et.output, "err=", err, " stack=", buf.String())
 			et.Log()
+			if e := et.logAttempt(); e != nil {
+				et.log.Warningln(fmt.Sprintf("[et.Run] %s", e.Error()))
+			}
 
 			taskChan <- et
 			return
 		}
 	}() // }}}
 
+	//禁用的任务不执行，无论上级任务的结果如何都记录为跳过
+	if et.task.Disabled {
+		et.state = 6
+		et.startTime = time.Now().Local()
+		et.endTime = et.startTime
+		et.output = "task is skipped, the task is disabled"
+		et.Log()
+		taskChan <- et
+		return
+	}
+
 	//暂停状态的处理
 	if et.state == 2 {
 		et.Log()
@@ -386,10 +1039,22 @@ func (et *ExecTask) Run(taskChan chan *ExecTask) { // {{{
 		return
 	}
 
+	//执行条件不满足，跳过任务
+	if et.state == 6 {
+		et.startTime = time.Now().Local()
+		et.endTime = et.startTime
+		et.output = "task is skipped, the condition on upstream tasks is not met"
+		et.Log()
+		taskChan <- et
+		return
+	}
+
 	et.startTime = time.Now().Local()
 	et.state = 1
+	et.setStatus(TaskRunning)
 	et.Log()
-	g.L.Infoln("task", et.task.Name,
+	et.publishStart()
+	et.log.Infoln("task", et.task.Name,
 		"is start batchTaskId[", et.batchTaskId, "] cmd =",
 		et.task.Cmd, " arg=", et.task.Param)
 
@@ -397,40 +1062,218 @@ func (et *ExecTask) Run(taskChan chan *ExecTask) { // {{{
 	if et.task.TaskCyc != "" && !et.isReady() {
 		et.state = 5
 		et.output = "task is ignored"
-		g.L.Infoln("task", et.task.Name, "is ignore batchTaskId[", et.batchTaskId, "]")
+		et.log.Infoln("task", et.task.Name, "is ignore batchTaskId[", et.batchTaskId, "]")
 		et.Log()
 		taskChan <- et
 		return
 	}
 
-	//执行任务
+	//执行任务，参数使用替换产出物引用后的参数
+	g.metrics().TaskStart()
+	defer g.metrics().TaskEnd()
 	task := et.task
-	et.state = 3
+	if et.param != nil || et.params != nil {
+		t := *et.task
+		if et.param != nil {
+			t.Param = et.param
+		}
+		t.Params = et.params
+		task = &t
+	}
+	for {
+		et.state = 3
+		et.attempt++
 
-	if client, err := rpc.Dial("tcp", et.task.Address+g.Port); err == nil {
-		_ = client.Call("CmdExecuter.Run", task, &rl)
-		if rl.Err != "" {
-			et.output = rl.Err
-			et.state = 4
-			g.L.Infoln("task", et.task.Name, "is error", rl.Stdout)
+		//使用资源池的任务占用位置后再执行，等待超时或批次被取消时任务失败
+		release, err := et.acquirePool()
+		if err != nil {
+			et.state, et.output = 4, err.Error()
+			et.log.Warningln("task", et.task.Name, "is fail batchTaskId[", et.batchTaskId, "]", err.Error())
+			break
+		}
+
+		et.attemptTime = time.Now().Local()
+		rl = &Reply{}
+		journal(et.execJob.job.ScheduleId, et.batchId, JournalTaskStart, et.task, et.attempt, 1, "")
+
+		err = func() error {
+			defer release()
+			return et.dispatch(task, rl)
+		}()
+		if err == nil {
+			et.exitCode = rl.ExitCode
 		}
-	} else {
-		e := fmt.Sprintf("connect task.Address[%s] error %s", et.task.Address+g.Port,
-			err.Error())
-		panic(e)
+		if err == nil && rl.Err == "" {
+			break
+		}
+		//批次已被取消时不再重试
+		if et.attempt > task.RetryCount || et.cancelled() {
+			if err != nil {
+				et.state, et.output = 4, err.Error()
+			}
+			break
+		}
+
+		//重试会超过调度或作业的超时时间时不再重试
+		wait := retryWait(time.Duration(task.RetryInterval) * time.Second)
+		if !et.deadline.IsZero() && time.Now().Add(wait).After(et.deadline) {
+			et.log.Warningln("task", et.task.Name, "attempt", et.attempt, "is fail batchTaskId[", et.batchTaskId,
+				"] retry after", wait, "would run past the", et.deadlineBy, "timeout, give up")
+			if err != nil {
+				et.state, et.output = 4, err.Error()
+			}
+			break
+		}
+
+		//本次执行失败，记录后等待重试
+		et.state, et.endTime = 4, time.Now().Local()
+		if err != nil {
+			et.output = err.Error()
+		} else {
+			et.output = rl.Err + rl.Stdout
+		}
+		if e := et.logAttempt(); e != nil {
+			et.log.Warningln(fmt.Sprintf("[et.Run] %s", e.Error()))
+		}
+
+		journal(et.execJob.job.ScheduleId, et.batchId, JournalTaskRetry, et.task, et.attempt, et.state,
+			fmt.Sprintf("retry after %s, %s", wait, et.output))
+		et.log.Infoln("task", et.task.Name, "attempt", et.attempt, "is fail batchTaskId[", et.batchTaskId,
+			"] retry after", wait)
+		select {
+		case <-time.After(wait):
+		case <-et.done:
+		}
+		et.output = ""
+	}
+
+	if rl.Err != "" {
+		et.output = rl.Err
+		et.state = 4
+		et.log.Infoln("task", et.task.Name, "is error", rl.Stdout)
 	}
 
 	et.output = et.output + rl.Stdout
 	et.endTime = time.Now().Local()
+	et.artifacts = parseArtifacts(rl.Stdout)
 	et.Log()
+	if err := et.logArtifacts(); err != nil {
+		et.log.Warningln(fmt.Sprintf("[et.Run] %s", err.Error()))
+	}
+	if err := et.logAttempt(); err != nil {
+		et.log.Warningln(fmt.Sprintf("[et.Run] %s", err.Error()))
+	}
 
-	g.L.Infoln("task", et.task.Name, "is end batchTaskId[", et.batchTaskId, "] state =",
+	et.log.Infoln("task", et.task.Name, "is end batchTaskId[", et.batchTaskId, "] state =",
 		et.state, "StartTime", et.startTime, "EndTime", et.endTime)
 
 	taskChan <- et
 
 } // }}}
 
+//execute将任务交给GlobalConfigStruct.Executor执行一次。
+//任务未指定执行地址时从Worker池中选择Worker，执行结束后归还；
+//无法发送到所选的Worker时将其标记为不可用，之后的重试会选择其它Worker。
+func (et *ExecTask) execute(task *Task, reply *Reply) error { // {{{
+	t := *task
+	t.BatchTaskId = et.batchTaskId
+	et.worker = t.Address
+	if t.ExecutorType != "" {
+		et.worker = ""
+		return et.executeLocal(&t, reply)
+	}
+
+	var wp *workerPool
+	if t.Address == "" {
+		wp = g.Schedules.workers
+		addr, err := wp.acquire(task)
+		if err != nil {
+			return newError(CodeConflict, err, "\n[et.execute] task [%s] %s", task.Name, err.Error())
+		}
+		if addr == "" {
+			wp = nil
+		} else {
+			defer wp.release(addr)
+			t.Address, et.worker = addr, addr
+		}
+	}
+
+	et.setSent(&t)
+	defer et.setSent(nil)
+	err := g.Executor.Run(&t, reply)
+	if err != nil && wp != nil {
+		wp.setHealth(t.Address, err)
+	}
+	return err
+} // }}}
+
+//setSent记录正在执行的任务，执行结束后置为nil
+func (et *ExecTask) setSent(t *Task) { // {{{
+	et.lock.Lock()
+	defer et.lock.Unlock()
+	et.sent = t
+	if t == nil {
+		et.cancel = nil
+	}
+} // }}}
+
+//abort在任务正在执行时通知Executor中止，返回中止的结果，任务未在执行时返回空字符串。
+//Executor未实现Aborter时任务继续执行，结束后的结果不再影响批次。
+func (et *ExecTask) abort() string { // {{{
+	et.lock.Lock()
+	t, cancel := et.sent, et.cancel
+	et.lock.Unlock()
+	if t == nil {
+		return ""
+	}
+	if cancel != nil {
+		cancel()
+		return fmt.Sprintf("task [%s] batchTaskId[%s] is aborted in process", et.task.Name, et.batchTaskId)
+	}
+
+	a, ok := g.Executor.(Aborter)
+	if !ok {
+		return fmt.Sprintf("executor can not abort task [%s] batchTaskId[%s]", et.task.Name, et.batchTaskId)
+	}
+	if err := a.Abort(t); err != nil {
+		return fmt.Sprintf("abort task [%s] batchTaskId[%s] error %s", et.task.Name, et.batchTaskId, err.Error())
+	}
+	return fmt.Sprintf("task [%s] batchTaskId[%s] is aborted on worker [%s]", et.task.Name, et.batchTaskId, t.Address)
+} // }}}
+
+//markTimedOut在任务正在执行时记录任务因level级别的超时被中止，已结束的任务不做处理
+func (et *ExecTask) markTimedOut(level string) { // {{{
+	et.lock.Lock()
+	defer et.lock.Unlock()
+	if et.sent != nil {
+		et.timedOut, et.timeoutLevel = true, level
+	}
+} // }}}
+
+//isTimedOut判断任务是否因调度或作业超时被中止
+func (et *ExecTask) isTimedOut() bool { // {{{
+	et.lock.Lock()
+	defer et.lock.Unlock()
+	return et.timedOut
+} // }}}
+
+//getTimeoutLevel返回中止任务的超时级别，未超时时为空
+func (et *ExecTask) getTimeoutLevel() string { // {{{
+	et.lock.Lock()
+	defer et.lock.Unlock()
+	return et.timeoutLevel
+} // }}}
+
+//cancelled判断任务所在的批次是否已结束或被取消
+func (et *ExecTask) cancelled() bool { // {{{
+	select {
+	case <-et.done:
+		return true
+	default:
+		return false
+	}
+} // }}}
+
 //isReady方法会根据Task的调度周期与启动时间判断是否符合执行条件
 //符合返回true，反之false
 func (et *ExecTask) isReady() (b bool) { // {{{
@@ -443,50 +1286,24 @@ func (et *ExecTask) isReady() (b bool) { // {{{
 	return b
 } // }}}
 
-//ExecSchedule.Restore(batchId string)方法修复执行指定的调度。
-//根据传入的batchId，构建调度执行结构，并调用Run方法执行其中的任务
+//Restore修复执行调度scdId中失败的批次batchId，执行结束后才返回。
+//批次的恢复方式与ResumeRun相同，批次不属于调度scdId时返回error。
 func Restore(batchId string, scdId int64) (err error) { // {{{
 
 	g.L.Infoln("Restore schedule by ", " batchid[", batchId, "] scdId=", scdId)
 
-	//获取执行成功的Task
-	successTaskId := getSuccessTaskId(batchId)
-
-	//创建ExecSchedule结构
-	s := g.Schedules.ScheduleList[scdId]
-	execSchedule := &ExecSchedule{
-		batchId:   batchId,
-		schedule:  s,
-		state:     1,
-		result:    0,
-		execType:  3,
-		jobCnt:    s.JobCnt,
-		taskCnt:   s.TaskCnt,
-		execTasks: make(map[int64]*ExecTask), //设置任务列表
+	es, err := g.Schedules.resumeExecSchedule(batchId)
+	if err != nil {
+		return newError(CodeStore, err, "\n[Restore] %s", err.Error())
 	}
-	err = execSchedule.InitExecSchedule()
-
-	//删除成功的任务
-	for _, tId := range successTaskId {
-		t := execSchedule.execTasks[tId]
-		for _, nextaks := range t.nextExecTasks {
-			delete(nextaks.relExecTasks, t.task.Id)
-		}
-		delete(execSchedule.execTasks, tId)
-		execSchedule.taskCnt--
-	}
-
-	//设置作业、任务的初始状态
-	for _, t := range execSchedule.execTasks {
-		t.execType = 3
-		t.state = 1
-		t.execJob.execType = 3
-		t.execJob.state = 1
+	if es.schedule.Id != scdId {
+		g.Schedules.RemoveExecSchedule(batchId)
+		return newError(CodeInvalid, nil, "\n[Restore] batch [%s] is not of schedule %d", batchId, scdId)
 	}
 	g.L.Infoln("schedule will restore")
 
 	//执行
-	execSchedule.Run()
+	es.Run()
 	g.L.Infoln("schedule was restored")
 
 	return nil
@@ -0,0 +1,183 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+//内置的Executor名称，见NewExecutor
+const (
+	ExecutorRPC = "rpc" //通过net/rpc发送给Worker执行，Worker端见worker包
+)
+
+//已注册的Executor，键为名称
+var executors = struct {
+	sync.RWMutex
+	factories map[string]func() Executor
+}{factories: map[string]func() Executor{
+	ExecutorRPC: func() Executor { return &rpcExecutor{} },
+}}
+
+//RegisterExecutor以name注册一种Executor，之后可通过配置文件中的executor选择使用，
+//用于接入gRPC、HTTP等其它与Worker的通信方式。同名的Executor以最后一次注册为准。
+func RegisterExecutor(name string, factory func() Executor) { // {{{
+	executors.Lock()
+	defer executors.Unlock()
+	executors.factories[name] = factory
+} // }}}
+
+//NewExecutor按名称创建已注册的Executor，名称不存在时返回error。
+func NewExecutor(name string) (Executor, error) { // {{{
+	executors.RLock()
+	defer executors.RUnlock()
+	if f, ok := executors.factories[name]; ok {
+		return f(), nil
+	}
+
+	names := make([]string, 0, len(executors.factories))
+	for n := range executors.factories {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	e := fmt.Sprintf("\n[NewExecutor] unknown executor [%s], registered %v.", name, names)
+	return nil, errors.New(e)
+} // }}}
+
+//任务执行一次的结果，由Executor写入
+type Reply struct { // {{{
+	Err      string //错误信息
+	Stdout   string //标准输出
+	ExitCode int    //任务命令的退出码，未能执行命令时为-1
+} // }}}
+
+//Executor负责将任务发送到Worker执行，执行结果写入reply中。
+//调度通过GlobalConfigStruct.Executor发送全部任务，不依赖具体的通信方式；
+//测试时可替换为SyncExecutor等不需要Worker的实现。
+//任务本身执行出错时设置reply.Err，无法发送执行时返回error。
+type Executor interface {
+	Run(task *Task, reply *Reply) error
+}
+
+//Aborter由可以中止正在执行的任务的Executor实现，CancelRun或调度执行超过TimeOut时通知其中止批次中正在执行的任务。
+//task为发送执行时的任务，包含实际的执行地址与BatchTaskId。
+type Aborter interface {
+	Abort(task *Task) error
+}
+
+//rpcExecutor通过RPC将任务发送给任务执行地址上的Worker执行，为默认的Executor。
+type rpcExecutor struct{}
+
+//连接的TLS与认证见dialWorker。
+func (r *rpcExecutor) Run(task *Task, reply *Reply) error { // {{{
+	client, err := dialWorker(task.Address + g.Port)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	_ = client.Call("CmdExecuter.Run", task, reply)
+	return nil
+} // }}}
+
+//Abort通知任务执行地址上的Worker中止批次任务ID为task.BatchTaskId的任务
+func (r *rpcExecutor) Abort(task *Task) error { // {{{
+	client, err := dialWorker(task.Address + g.Port)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.Call("CmdExecuter.Abort", task, &Reply{})
+} // }}}
+
+//TaskExecutor在调度进程内执行任务，用于不需要Worker的简单任务，省去发送任务的网络开销。
+//按名称设置在GlobalConfigStruct.TaskExecutors中，由Task.ExecutorType选择。
+//ctx在任务超过TimeOut、调度或作业超时、批次被取消时结束，实现应据此及时返回；
+//执行结果写入reply，任务本身执行出错时设置reply.Err，无法执行时返回error，与Executor相同。
+type TaskExecutor interface {
+	Execute(ctx context.Context, task *Task, reply *Reply) error
+}
+
+//TaskFunc将普通函数适配为TaskExecutor
+type TaskFunc func(ctx context.Context, task *Task, reply *Reply) error
+
+func (f TaskFunc) Execute(ctx context.Context, task *Task, reply *Reply) error { // {{{
+	return f(ctx, task, reply)
+} // }}}
+
+//runLocal使用task.ExecutorType对应的TaskExecutor在进程内执行任务，任务的TimeOut作为ctx的超时时间。
+//ctx结束时不再等待TaskExecutor返回，任务按超时或取消失败，退出码为-1；
+//TaskExecutor发生panic时任务失败，不影响调度进程。ExecutorType未注册时返回error。
+func runLocal(ctx context.Context, task *Task, reply *Reply) error { // {{{
+	te, ok := g.TaskExecutors[task.ExecutorType]
+	if !ok {
+		e := fmt.Sprintf("\n[runLocal] task [%s] executor type [%s] is not registered.", task.Name, task.ExecutorType)
+		return errors.New(e)
+	}
+
+	if task.TimeOut > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(task.TimeOut)*time.Second)
+		defer cancel()
+	}
+
+	//TaskExecutor写入单独的reply，正常返回后再复制，超时后继续执行也不影响任务的结果
+	rl := &Reply{}
+	errc := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				rl.Err, rl.ExitCode = fmt.Sprintf("task panic %v", r), -1
+				errc <- nil
+			}
+		}()
+		errc <- te.Execute(ctx, task, rl)
+	}()
+
+	select {
+	case err := <-errc:
+		*reply = *rl
+		return err
+	case <-ctx.Done():
+		reply.ExitCode = -1
+		if ctx.Err() == context.DeadlineExceeded {
+			reply.Err = fmt.Sprintf("task is timeout, run over %ds", task.TimeOut)
+			if task.TimeOut <= 0 {
+				reply.Err = "task is timeout"
+			}
+		} else {
+			reply.Err = "task is cancelled"
+		}
+		return nil
+	}
+} // }}}
+
+//executeLocal在进程内执行任务，调度与作业超时时间中较早的一个作为截止时间，
+//批次结束或被取消、CancelRun中止任务时取消执行，见abort。
+func (et *ExecTask) executeLocal(task *Task, reply *Reply) error { // {{{
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if !et.deadline.IsZero() {
+		var stop context.CancelFunc
+		ctx, stop = context.WithDeadline(ctx, et.deadline)
+		defer stop()
+	}
+	go func() {
+		select {
+		case <-et.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	et.lock.Lock()
+	et.sent, et.cancel = task, cancel
+	et.lock.Unlock()
+	defer et.setSent(nil)
+
+	return runLocal(ctx, task, reply)
+} // }}}
@@ -0,0 +1,133 @@
+package schedule
+
+import (
+	"fmt"
+	"sort"
+)
+
+//调度结构图中节点与边的类型
+const (
+	GraphJob  = "job"  //节点为作业
+	GraphTask = "task" //节点为任务
+
+	GraphNext     = "next"     //作业指向调度链中的下级作业
+	GraphContains = "contains" //作业指向其中的任务
+	GraphDepends  = "depends"  //依赖的任务指向依赖它的任务
+)
+
+//调度的结构图，由GetScheduleGraph生成，供界面绘制调度链与任务依赖，可直接序列化为JSON。
+//节点按作业在调度链中的顺序排列，每个作业之后为其中的任务，任务按ID排序。
+type ScheduleGraph struct { // {{{
+	ScheduleId   int64       `json:"schedule_id"`   //调度ID
+	ScheduleName string      `json:"schedule_name"` //调度名称
+	Cyc          string      `json:"cyc"`           //调度周期
+	Nodes        []GraphNode `json:"nodes"`         //作业与任务节点
+	Edges        []GraphEdge `json:"edges"`         //节点之间的关系
+} // }}}
+
+//结构图中的节点
+type GraphNode struct { // {{{
+	Key           string `json:"key"`                      //节点标识，格式为类型:ID，如job:1、task:2
+	Type          string `json:"type"`                     //节点类型，取值见GraphJob、GraphTask
+	Id            int64  `json:"id"`                       //作业或任务的ID
+	Name          string `json:"name"`                     //作业或任务的名称
+	Desc          string `json:"desc,omitempty"`           //作业或任务的说明
+	Seq           int    `json:"seq"`                      //所属作业在调度链中的位置，从0开始，不在调度链中的任务为-1
+	JobId         int64  `json:"job_id,omitempty"`         //任务所属的作业ID
+	ParallelGroup int    `json:"parallel_group,omitempty"` //作业的并行组
+	TaskType      int64  `json:"task_type,omitempty"`      //任务类型
+	ExecutorType  string `json:"executor_type,omitempty"`  //任务的执行方式
+	Cmd           string `json:"cmd,omitempty"`            //任务执行的命令
+	Wave          int    `json:"wave,omitempty"`           //任务的执行阶段
+	Disabled      bool   `json:"disabled,omitempty"`       //任务是否禁用
+	Warmup        bool   `json:"warmup,omitempty"`         //是否为调度的预热任务
+} // }}}
+
+//结构图中的边
+type GraphEdge struct { // {{{
+	From      string `json:"from"`                //起点的节点标识
+	To        string `json:"to"`                  //终点的节点标识
+	Type      string `json:"type"`                //边的类型，取值见GraphNext、GraphContains、GraphDepends
+	Condition string `json:"condition,omitempty"` //依赖的执行条件，只用于GraphDepends，取值见RelOnSuccess、RelOnFailure、RelAlways
+} // }}}
+
+//graphKey返回节点标识
+func graphKey(typ string, id int64) string { // {{{
+	return fmt.Sprintf("%s:%d", typ, id)
+} // }}}
+
+//GetScheduleGraph返回id对应调度的结构图，包含调度链中的作业、作业中的任务以及任务之间的依赖。
+//结构图按内存中的调度链生成，不执行调度，也不修改调度；调度尚未初始化调度链时在副本中初始化，见Schedule.chain。
+//依赖调度之外的任务时，该任务同样作为节点输出，Seq为-1。
+func (sl *ScheduleManager) GetScheduleGraph(id int64) (*ScheduleGraph, error) { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return nil, err
+	}
+	s := sl.GetScheduleById(id)
+	if s == nil {
+		return nil, newError(CodeNotFound, nil, "\n[sl.GetScheduleGraph] not found schedule by id %d", id)
+	}
+
+	cs, err := s.chain()
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.GetScheduleGraph] init schedule [%d] error %s.", id, err.Error())
+	}
+	return cs.graph(), nil
+} // }}}
+
+//graph按调度链生成调度的结构图
+func (s *Schedule) graph() *ScheduleGraph { // {{{
+	sg := &ScheduleGraph{ScheduleId: s.Id, ScheduleName: s.Name, Cyc: s.Cyc,
+		Nodes: make([]GraphNode, 0), Edges: make([]GraphEdge, 0)}
+
+	nodes := make(map[string]bool)
+	addTask := func(t *Task, seq int) string {
+		key := graphKey(GraphTask, t.Id)
+		if !nodes[key] {
+			nodes[key] = true
+			sg.Nodes = append(sg.Nodes, GraphNode{Key: key, Type: GraphTask, Id: t.Id, Name: t.Name, Desc: t.Desc, Seq: seq,
+				JobId: t.JobId, TaskType: t.TaskType, ExecutorType: t.ExecutorType, Cmd: t.Cmd, Wave: t.Wave,
+				Disabled: t.Disabled, Warmup: s.WarmupTaskId != 0 && t.Id == s.WarmupTaskId})
+		}
+		return key
+	}
+
+	tasks := make([]*Task, 0, s.TaskCnt)
+	var pre string
+	for j, seq := s.Job, 0; j != nil; j, seq = j.NextJob, seq+1 {
+		key := graphKey(GraphJob, j.Id)
+		nodes[key] = true
+		sg.Nodes = append(sg.Nodes, GraphNode{Key: key, Type: GraphJob, Id: j.Id, Name: j.Name, Desc: j.Desc, Seq: seq,
+			ParallelGroup: j.ParallelGroup})
+		if pre != "" {
+			sg.Edges = append(sg.Edges, GraphEdge{From: pre, To: key, Type: GraphNext})
+		}
+		pre = key
+
+		jt := make([]*Task, 0, len(j.Tasks))
+		for _, t := range j.Tasks {
+			jt = append(jt, t)
+		}
+		sort.Sort(taskById(jt))
+		for _, t := range jt {
+			sg.Edges = append(sg.Edges, GraphEdge{From: key, To: addTask(t, seq), Type: GraphContains})
+		}
+		tasks = append(tasks, jt...)
+	}
+
+	//依赖关系在全部作业的任务之后输出，依赖的任务按ID排序
+	for _, t := range tasks {
+		rts := make([]*Task, 0, len(t.RelTasks))
+		for _, rt := range t.RelTasks {
+			if rt != nil {
+				rts = append(rts, rt)
+			}
+		}
+		sort.Sort(taskById(rts))
+		for _, rt := range rts {
+			sg.Edges = append(sg.Edges, GraphEdge{From: addTask(rt, -1), To: graphKey(GraphTask, t.Id),
+				Type: GraphDepends, Condition: t.relCondition(rt.Id)})
+		}
+	}
+	return sg
+} // }}}
@@ -0,0 +1,113 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+//WorkerHealthCheck检查是否有可用的Worker，可供就绪探针调用。
+//Worker池不为空时连接池中全部可分配任务的Worker，有一个可以连接即视为可用，
+//检查结果同时记录到Worker池中，连接失败的Worker在WorkerRecheck内不再分配任务；
+//池为空时检查本机的Worker，即未指定执行地址的任务使用的地址。
+//每个Worker的连接超时时间为GlobalConfigStruct.HealthTimeout，全部不可用时返回error。
+func (sl *ScheduleManager) WorkerHealthCheck() error { // {{{
+	addrs, _ := sl.Workers()
+	if len(addrs) == 0 {
+		if err := pingWorker(""); err != nil {
+			e := fmt.Sprintf("\n[sl.WorkerHealthCheck] no healthy worker, %s", err.Error())
+			return errors.New(e)
+		}
+		return nil
+	}
+
+	errs := make([]string, 0)
+	for _, addr := range addrs {
+		err := pingWorker(addr)
+		sl.workers.setHealth(addr, err)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) < len(addrs) {
+		return nil
+	}
+
+	e := fmt.Sprintf("\n[sl.WorkerHealthCheck] no healthy worker, %s", strings.Join(errs, "; "))
+	return errors.New(e)
+} // }}}
+
+//pingWorker在HealthTimeout内连接Worker的地址addr，addr为空时连接本机的Worker。
+func pingWorker(addr string) error { // {{{
+	conn, err := net.DialTimeout("tcp", addr+g.Port, g.HealthTimeout)
+	if err != nil {
+		return errors.New(fmt.Sprintf("connect worker [%s] error %s", addr+g.Port, err.Error()))
+	}
+	return conn.Close()
+} // }}}
+
+//checkWorkers检查调度中任务使用的Worker是否可用：指定了执行地址的任务检查对应的Worker，
+//未指定执行地址的任务需WorkerHealthCheck通过。
+func (s *Schedule) checkWorkers() error { // {{{
+	addrs := make(map[string]bool)
+	for _, t := range s.Tasks {
+		addrs[t.Address] = true
+	}
+
+	list := make([]string, 0, len(addrs))
+	for addr := range addrs {
+		list = append(list, addr)
+	}
+	sort.Strings(list)
+
+	for _, addr := range list {
+		var err error
+		if addr == "" {
+			err = g.Schedules.WorkerHealthCheck()
+		} else {
+			err = pingWorker(addr)
+		}
+		if err != nil {
+			e := fmt.Sprintf("\n[s.checkWorkers] %s", err.Error())
+			return errors.New(e)
+		}
+	}
+	return nil
+} // }}}
+
+//waitWorkers在启动前按GlobalConfigStruct.WorkerDownPolicy检查Worker是否可用，
+//返回false时不启动本次执行。
+//跳过时记录警告并重新计时等待下一周期，queued为true时由上一批次设置下次执行时间，不再重新计时；
+//推迟时记录警告，每隔WorkerDelay重新检查，直到Worker可用或监听停止。
+func (s *Schedule) waitWorkers(ctx context.Context, queued bool) bool { // {{{
+	if g.WorkerDownPolicy == "" || g.WorkerDownPolicy == WorkerDownIgnore {
+		return true
+	}
+
+	for {
+		err := s.checkWorkers()
+		if err == nil {
+			return true
+		}
+
+		if g.WorkerDownPolicy == WorkerDownSkip {
+			s.logEntry().Warningln(fmt.Sprintf("[s.waitWorkers] schedule [%d %s] is skipped because workers are down. %s",
+				s.Id, s.Name, err.Error()))
+			if !queued {
+				go s.Timer()
+			}
+			return false
+		}
+
+		s.logEntry().Warningln(fmt.Sprintf("[s.waitWorkers] schedule [%d %s] is deferred for %s because workers are down. %s",
+			s.Id, s.Name, g.WorkerDelay, err.Error()))
+		select {
+		case <-g.clock().After(g.WorkerDelay):
+		case <-ctx.Done():
+			return false
+		}
+	}
+} // }}}
@@ -0,0 +1,341 @@
+package schedule
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+//调度的运行状态
+type ScheduleStatus string
+
+const (
+	StatusIdle     ScheduleStatus = "idle"     //等待下次启动
+	StatusRunning  ScheduleStatus = "running"  //有批次正在执行
+	StatusPaused   ScheduleStatus = "paused"   //已暂停，见PauseScheduleById
+	StatusDisabled ScheduleStatus = "disabled" //已禁用，不自动启动，见Schedule.Enabled
+	StatusError    ScheduleStatus = "error"    //上一批次执行失败或异常中止，下次执行成功后恢复
+)
+
+//调度的只读信息，由ListSchedules生成，修改不会影响调度本身
+type ScheduleInfo struct { // {{{
+	Id        int64          //调度ID
+	Name      string         //调度名称
+	Cyc       string         //调度周期
+	NextStart time.Time      //下次启动时间
+	Status    ScheduleStatus //运行状态
+
+	LastRunTime     time.Time     //最近一个批次的开始时间，零值表示没有执行记录
+	LastRunStatus   RunStatus     //最近一个批次的执行结果
+	LastRunDuration time.Duration //最近一个批次的执行时长
+	LastError       string        //最近一个批次失败的原因
+} // }}}
+
+//ListSchedules返回全部调度信息的快照，按调度ID排序。
+//快照在持有调度列表锁的情况下生成，与调度的增加、删除互斥，返回的是复制后的数据，
+//供监控面板等外部调用方使用，避免直接读取ScheduleList。
+func (sl *ScheduleManager) ListSchedules() []ScheduleInfo { // {{{
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	scds := make([]*Schedule, len(sl.ScheduleList))
+	copy(scds, sl.ScheduleList)
+	sort.Sort(scheduleById(scds))
+
+	infos := make([]ScheduleInfo, 0, len(scds))
+	for _, s := range scds {
+		infos = append(infos, ScheduleInfo{
+			Id:        s.Id,
+			Name:      s.Name,
+			Cyc:       s.Cyc,
+			NextStart: s.NextStart,
+			Status:    sl.status(s),
+
+			LastRunTime:     s.LastRunTime,
+			LastRunStatus:   s.LastRunStatus,
+			LastRunDuration: s.LastRunDuration,
+			LastError:       s.LastError,
+		})
+	}
+	return infos
+} // }}}
+
+//GetScheduleStatus返回指定调度的运行状态，调度不存在时返回错误。
+func (sl *ScheduleManager) GetScheduleStatus(id int64) (ScheduleStatus, error) { // {{{
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	for _, s := range sl.ScheduleList {
+		if s.Id == id {
+			return sl.status(s), nil
+		}
+	}
+
+	return "", newError(CodeNotFound, nil, "\n[sl.GetScheduleStatus] not found schedule by id %d", id)
+} // }}}
+
+//status计算调度的运行状态，调用方需持有锁。
+//正在执行优先于暂停、禁用，暂停、禁用期间不会再启动新的批次，但已启动的批次会执行完。
+func (sl *ScheduleManager) status(s *Schedule) ScheduleStatus { // {{{
+	for _, es := range sl.ExecScheduleList {
+		if es.schedule.Id == s.Id {
+			return StatusRunning
+		}
+	}
+
+	switch {
+	case s.Status == 1:
+		return StatusPaused
+	case !s.Enabled:
+		return StatusDisabled
+	case sl.runFailed[s.Id]:
+		return StatusError
+	}
+	return StatusIdle
+} // }}}
+
+//批次的执行结果，用于更新调度的LastRun各字段
+type runResult struct { // {{{
+	startTime time.Time //开始时间
+	endTime   time.Time //结束时间
+	failed    bool      //是否执行失败，有任务失败或批次异常中止时为true
+	err       string    //失败原因
+} // }}}
+
+//setRunResult记录调度最近一个批次的执行结果。
+//与Timer设置NextStart一样持有调度列表的锁，读取时通过ListSchedules获得一致的快照。
+func (sl *ScheduleManager) setRunResult(id int64, r runResult) { // {{{
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	if r.failed {
+		sl.runFailed[id] = true
+	} else {
+		delete(sl.runFailed, id)
+	}
+
+	for _, s := range sl.ScheduleList {
+		if s.Id == id {
+			s.LastRunTime, s.LastRunDuration = r.startTime, r.endTime.Sub(r.startTime)
+			s.LastRunStatus, s.LastError = RunSuccess, ""
+			if r.failed {
+				s.LastRunStatus, s.LastError = RunFailed, r.err
+			}
+			return
+		}
+	}
+} // }}}
+
+//restoreRunResults从日志库读取各调度最近一个已结束的批次，恢复调度的执行结果。
+//不记录日志（NoLog）时不恢复；读取失败只记录警告，调度照常启动，之后的批次结束时会重新设置。
+func (sl *ScheduleManager) restoreRunResults() { // {{{
+	if sl.Global.NoLog || sl.Global.LogConn == nil {
+		return
+	}
+
+	results, err := getLastRunResults()
+	if err != nil {
+		sl.Global.L.Warningln(fmt.Sprintf("\n[sl.restoreRunResults] %s", err.Error()))
+		return
+	}
+	for id, r := range results {
+		sl.setRunResult(id, r)
+	}
+} // }}}
+
+//runResult返回批次的执行结果，failed为true时视为执行失败。
+//批次未开始即中止时，开始、结束时间均为中止的时间。
+func (es *ExecSchedule) runResult(failed bool) runResult { // {{{
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	r := runResult{startTime: es.startTime, endTime: es.endTime, failed: failed, err: es.lastError}
+	if r.endTime.IsZero() {
+		r.endTime = time.Now().Local()
+	}
+	if r.startTime.IsZero() {
+		r.startTime = r.endTime
+	}
+	return r
+} // }}}
+
+//调度批次的执行状态
+type RunStatus string
+
+const (
+	RunPending RunStatus = "pending" //已创建，尚未开始执行
+	RunRunning RunStatus = "running" //执行中
+	RunSuccess RunStatus = "success" //执行结束，任务全部成功
+	RunFailed  RunStatus = "failed"  //执行结束但有任务失败，或批次异常中止
+)
+
+//调度批次的执行进度，由GetExecSchedule生成，修改不会影响执行中的批次
+type ExecScheduleInfo struct { // {{{
+	BatchId        string    //批次ID
+	ScheduleId     int64     //调度ID
+	Status         RunStatus //执行状态
+	TaskCnt        int       //批次中任务数量
+	DoneTaskCnt    int       //已结束的任务数量，包括成功、失败和跳过的任务
+	SuccessTaskCnt int       //执行成功任务数量
+	FailTaskCnt    int       //执行失败任务数量
+	SkipTaskCnt    int       //执行条件不满足被跳过的任务数量
+	StartTime      time.Time //开始时间，未开始时为零值
+	EndTime        time.Time //结束时间，未结束时为零值
+	Window         time.Time //补数执行的启动时间，其余批次为零值，见Backfill
+	RetryNo        int       //调度级重试的序号，首次执行为0，见Schedule.ScheduleRetryCount
+	PrevBatchId    string    //重试前一次执行的批次ID，首次执行为空
+	RetryBatchId   string    //失败后重试的批次ID，没有重试时为空，只能从日志库读取
+} // }}}
+
+//GetExecSchedule返回批次batchId的执行进度，可供界面轮询显示进度。
+//批次正在执行时返回内存中的实时状态；已结束的批次从日志库读取，
+//不记录日志（NoLog）时只能查询执行中的批次。批次不存在时返回error。
+func (sl *ScheduleManager) GetExecSchedule(batchId string) (ExecScheduleInfo, error) { // {{{
+	sl.lock.RLock()
+	es, ok := sl.ExecScheduleList[batchId]
+	sl.lock.RUnlock()
+	if ok {
+		return es.snapshot(), nil
+	}
+
+	if !g.NoLog && g.LogConn != nil {
+		info, err := getExecScheduleLog(batchId)
+		if err != nil {
+			return ExecScheduleInfo{}, newError(CodeStore, err, "\n[sl.GetExecSchedule] %s", err.Error())
+		}
+		if info != nil {
+			return *info, nil
+		}
+	}
+
+	return ExecScheduleInfo{}, newError(CodeNotFound, nil, "\n[sl.GetExecSchedule] not found batch [%s]", batchId)
+} // }}}
+
+//snapshot在持有es.lock的情况下复制批次的执行进度
+func (es *ExecSchedule) snapshot() ExecScheduleInfo { // {{{
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	total := es.schedule.TaskCnt
+	info := ExecScheduleInfo{
+		BatchId:        es.batchId,
+		ScheduleId:     es.schedule.Id,
+		TaskCnt:        total,
+		DoneTaskCnt:    total - es.taskCnt,
+		SuccessTaskCnt: es.successTaskCnt,
+		FailTaskCnt:    es.failTaskCnt,
+		SkipTaskCnt:    es.skipTaskCnt,
+		StartTime:      es.startTime,
+		EndTime:        es.endTime,
+		Window:         es.window,
+		RetryNo:        es.retryNo,
+		PrevBatchId:    es.prevBatchId,
+	}
+	info.Status = runStatus(es.state, es.failTaskCnt)
+	return info
+} // }}}
+
+//runStatus根据批次状态与失败任务数量计算执行状态
+func runStatus(state int8, failCnt int) RunStatus { // {{{
+	switch state {
+	case 0:
+		return RunPending
+	case 3:
+		if failCnt == 0 {
+			return RunSuccess
+		}
+		return RunFailed
+	case 4:
+		return RunFailed
+	}
+	return RunRunning
+} // }}}
+
+//任务的执行状态
+type TaskStatus string
+
+const (
+	TaskPending TaskStatus = "pending" //等待执行，依赖的任务或之前的执行阶段尚未结束
+	TaskRunning TaskStatus = "running" //执行中，包括等待重试
+	TaskPaused  TaskStatus = "paused"  //依赖的任务失败，任务暂停
+	TaskSuccess TaskStatus = "success" //执行成功
+	TaskFailed  TaskStatus = "failed"  //执行失败，或被超时、CancelRun中止
+	TaskIgnored TaskStatus = "ignored" //不在任务的执行周期内，被忽略
+	TaskSkipped TaskStatus = "skipped" //执行条件不满足，被跳过
+)
+
+//taskStatus根据任务执行结构的状态计算执行状态
+func taskStatus(state int8) TaskStatus { // {{{
+	switch state {
+	case 0:
+		return TaskPending
+	case 1:
+		return TaskRunning
+	case 2:
+		return TaskPaused
+	case 3:
+		return TaskSuccess
+	case 5:
+		return TaskIgnored
+	case 6:
+		return TaskSkipped
+	}
+	return TaskFailed
+} // }}}
+
+//执行中批次里任务的执行状态，由FindExecTasksByStatus生成，修改不会影响执行中的任务
+type ExecTaskInfo struct { // {{{
+	BatchId     string     //批次ID
+	BatchTaskId string     //任务执行ID
+	ScheduleId  int64      //调度ID
+	JobId       int64      //作业ID
+	TaskId      int64      //任务ID
+	TaskName    string     //任务名称
+	Status      TaskStatus //执行状态
+	Since       time.Time  //进入当前执行状态的时间
+} // }}}
+
+//FindExecTasksByStatus返回执行列表中全部批次里处于status状态的任务，可供监控界面轮询，
+//结果按批次ID、任务ID排序。只包含执行中的批次，已结束的批次可通过GetExecSchedule查询。
+//任务状态在各自的锁内读取，调用方不需要也不应直接访问执行结构。
+func (sl *ScheduleManager) FindExecTasksByStatus(status TaskStatus) []ExecTaskInfo { // {{{
+	sl.lock.RLock()
+	ess := make([]*ExecSchedule, 0, len(sl.ExecScheduleList))
+	for _, es := range sl.ExecScheduleList {
+		ess = append(ess, es)
+	}
+	sl.lock.RUnlock()
+
+	tasks := make([]ExecTaskInfo, 0)
+	for _, es := range ess {
+		es.lock.Lock()
+		ets := es.tasks
+		es.lock.Unlock()
+		for _, et := range ets {
+			et.lock.Lock()
+			st, since := et.status, et.statusTime
+			et.lock.Unlock()
+			if st != status {
+				continue
+			}
+			tasks = append(tasks, ExecTaskInfo{
+				BatchId:     es.batchId,
+				BatchTaskId: et.batchTaskId,
+				ScheduleId:  es.schedule.Id,
+				JobId:       et.task.JobId,
+				TaskId:      et.task.Id,
+				TaskName:    et.task.Name,
+				Status:      st,
+				Since:       since,
+			})
+		}
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].BatchId != tasks[j].BatchId {
+			return tasks[i].BatchId < tasks[j].BatchId
+		}
+		return tasks[i].TaskId < tasks[j].TaskId
+	})
+	return tasks
+} // }}}
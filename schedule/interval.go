@@ -0,0 +1,68 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//调度周期按固定间隔时的前缀，例如 interval:90s 表示每90秒，interval:3h 表示每3小时。
+//间隔的格式同time.ParseDuration，不能小于1秒。
+const IntervalPrefix = "interval:"
+
+//ParseInterval解析以IntervalPrefix开头的调度周期，返回启动的间隔。
+func ParseInterval(cyc string) (time.Duration, error) { // {{{
+	if !strings.HasPrefix(cyc, IntervalPrefix) {
+		e := fmt.Sprintf("\n[ParseInterval] cycle [%s] does not start with %s.", cyc, IntervalPrefix)
+		return 0, errors.New(e)
+	}
+
+	d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(cyc, IntervalPrefix)))
+	if err != nil {
+		e := fmt.Sprintf("\n[ParseInterval] cycle [%s] error %s.", cyc, err.Error())
+		return 0, errors.New(e)
+	}
+	if d < time.Second {
+		e := fmt.Sprintf("\n[ParseInterval] cycle [%s] interval must be at least 1s.", cyc)
+		return 0, errors.New(e)
+	}
+	return d, nil
+} // }}}
+
+//intervalNext返回晚于now的第一个启动时间，启动时间为anchor加上interval的整数倍，
+//因此每次启动都在上次启动时间加interval，不会因执行耗时而漂移。
+func intervalNext(anchor time.Time, interval time.Duration, now time.Time) time.Time { // {{{
+	if now.Before(anchor) {
+		return anchor
+	}
+	n := now.Sub(anchor)/interval + 1
+	return anchor.Add(n * interval)
+} // }}}
+
+//intervalAnchor返回按间隔调度的锚点，即调度的首次启动时间：
+//调度创建当日（loc中）的零点加上启动列表中的第一个启动时间，启动列表为空时为当日零点。
+//创建时间未知时以Unix纪元当日为准。
+func (s *Schedule) intervalAnchor(loc *time.Location) time.Time { // {{{
+	created := s.CreateTime
+	if created.IsZero() {
+		created = time.Unix(0, 0)
+	}
+	created = created.In(loc)
+
+	anchor := time.Date(created.Year(), created.Month(), created.Day(), 0, 0, 0, 0, loc)
+	if len(s.StartSecond) > 0 {
+		anchor = anchor.Add(s.StartSecond[0])
+	}
+	return anchor
+} // }}}
+
+//missedStarts返回从saved到now之间错过的启动次数，saved为错过的第一个启动时间。
+//按间隔调度时按间隔计算，其余周期只计为一次。
+func (s *Schedule) missedStarts(saved, now time.Time) int { // {{{
+	interval, err := ParseInterval(s.Cyc)
+	if err != nil || now.Before(saved) {
+		return 1
+	}
+	return int(now.Sub(saved)/interval) + 1
+} // }}}
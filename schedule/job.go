@@ -1,53 +1,53 @@
 package schedule
 
 import (
-	"errors"
-	"fmt"
+	"context"
+	"sort"
+	"strings"
 	"time"
 )
 
 //作业信息结构
 type Job struct { // {{{
-	Id           int64            //作业ID
-	ScheduleId   int64            //调度ID
-	ScheduleCyc  string           //调度周期
-	Name         string           //作业名称
-	Desc         string           //作业说明
-	PreJobId     int64            //上级作业ID
-	PreJob       *Job             `json:"-"` //上级作业
-	NextJobId    int64            //下级作业ID
-	NextJob      *Job             `json:"-"` //下级作业
-	Tasks        map[string]*Task //作业中的任务
-	TaskCnt      int              //调度中任务数量
-	CreateUserId int64            //创建人
-	CreateTime   time.Time        //创人
-	ModifyUserId int64            //修改人
-	ModifyTime   time.Time        //修改时间
+	Id            int64            //作业ID
+	ScheduleId    int64            //调度ID
+	ScheduleCyc   string           //调度周期
+	Name          string           //作业名称
+	Desc          string           //作业说明
+	ParallelGroup int              //并行组，调度链中连续且并行组相同的作业组成一组，组内作业同时执行，0表示不分组
+	TimeOut       int64            //最大执行时间，单位秒，从作业开始执行起计算，超过后中止作业中尚未结束的任务，0表示不限制
+	PreJobId      int64            //上级作业ID
+	PreJob        *Job             `json:"-"` //上级作业
+	NextJobId     int64            //下级作业ID
+	NextJob       *Job             `json:"-"` //下级作业
+	Tasks         map[string]*Task //作业中的任务
+	TaskCnt       int              //调度中任务数量
+	CreateUserId  int64            //创建人
+	CreateTime    time.Time        //创人
+	ModifyUserId  int64            //修改人
+	ModifyTime    time.Time        //修改时间
 } // }}}
 
 //根据Job.Id初始化Job结构，从元数据库获取Job的基本信息初始化后
 //继续初始化Job所属的Task列表，同时递归调用自身，初始化下级Job结构
 //失败返回error信息。
 func (j *Job) InitJob(s *Schedule) error { // {{{
-	err := j.getJob()
+	err := g.store().GetJob(context.Background(), j)
 	if err != nil {
-		e := fmt.Sprintf("\n[j.InitJob] init job [%d] error %s.", j.Id, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[j.InitJob] init job [%d] error %s.", j.Id, err.Error())
 	}
 
 	if j.PreJobId != 0 {
 		j.PreJob = &Job{Id: j.PreJobId}
-		err = j.PreJob.getJob()
+		err = g.store().GetJob(context.Background(), j.PreJob)
 		if err != nil {
-			e := fmt.Sprintf("\n[j.InitJob] get pre job [%d] error %s.", j.PreJobId, err.Error())
-			return errors.New(e)
+			return newError(CodeStore, err, "\n[j.InitJob] get pre job [%d] error %s.", j.PreJobId, err.Error())
 		}
 	}
 
 	err = j.InitTasksForJob(s)
 	if err != nil {
-		e := fmt.Sprintf("\n[j.InitJob] init task for job [%d] error %s.", j.Id, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[j.InitJob] init task for job [%d] error %s.", j.Id, err.Error())
 	}
 
 	//获取下级作业
@@ -56,16 +56,14 @@ func (j *Job) InitJob(s *Schedule) error { // {{{
 	}
 
 	nj := &Job{Id: j.NextJobId}
-	err = nj.getJob()
+	err = g.store().GetJob(context.Background(), nj)
 	if err != nil {
-		e := fmt.Sprintf("\n[j.InitJob] init job [%d] error %s.", j.NextJobId, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[j.InitJob] init job [%d] error %s.", j.NextJobId, err.Error())
 	}
 
 	nj.ScheduleId, nj.ScheduleCyc = j.ScheduleId, j.ScheduleCyc
 	if err := nj.InitJob(s); err != nil {
-		e := fmt.Sprintf("\n[j.InitJob] init job [%d] error %s.", nj.Id, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[j.InitJob] init job [%d] error %s.", nj.Id, err.Error())
 	}
 	j.NextJob = nj
 
@@ -78,20 +76,18 @@ func (j *Job) InitJob(s *Schedule) error { // {{{
 func (j *Job) InitTasksForJob(s *Schedule) error { // {{{
 	j.Tasks = make(map[string]*Task)
 
-	tasksId, err := j.getTasksId()
+	tasksId, err := g.store().GetJobTaskIds(j)
 	if err != nil {
-		e := fmt.Sprintf("\n[j.GetTasks] getTasksId error %s.", err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[j.GetTasks] getTasksId error %s.", err.Error())
 	}
 
 	for _, taskid := range tasksId {
 		task := &Task{Id: taskid}
 		err := task.InitTask(s)
 		if err != nil {
-			e := fmt.Sprintf("\n[t.InitTaskForJob] %s.", err.Error())
-			return errors.New(e)
+			return newError(CodeStore, err, "\n[t.InitTaskForJob] %s.", err.Error())
 		}
-		j.Tasks[string(taskid)] = task
+		j.Tasks[taskKey(taskid)] = task
 
 		task.ScheduleCyc = j.ScheduleCyc
 		j.TaskCnt++
@@ -104,19 +100,20 @@ func (j *Job) InitTasksForJob(s *Schedule) error { // {{{
 //它会根据参数查找本Job下符合的Task，找到后更新信息
 //并调用Task的add方法进行持久化操作。
 func (j *Job) UpdateTask(task *Task) (err error) { // {{{
-	t, ok := j.Tasks[string(task.Id)]
+	t, ok := j.Tasks[taskKey(task.Id)]
 	if !ok {
-		e := fmt.Sprintf("\n[j.UpdateTask] update error. not found task by id %d", task.Id)
-		return errors.New(e)
+		return newError(CodeNotFound, nil, "\n[j.UpdateTask] update error. not found task by id %d", task.Id)
 	}
 	t.Name, t.Desc, t.Address = task.Name, task.Desc, task.Address
 	t.TaskType, t.TaskCyc, t.StartSecond = task.TaskType, task.TaskCyc, task.StartSecond
 	t.Cmd, t.TimeOut, t.Param = task.Cmd, task.TimeOut, task.Param
+	t.RetryCount, t.RetryInterval, t.Wave = task.RetryCount, task.RetryInterval, task.Wave
+	t.ResourcePool, t.ExecutorType, t.Params = task.ResourcePool, task.ExecutorType, task.Params
+	t.Disabled = task.Disabled
 	t.Attr, t.ModifyUserId, t.ModifyTime = task.Attr, task.ModifyUserId, time.Now()
 
 	if err := t.UpdateTask(); err != nil {
-		e := fmt.Sprintf("\n[j.UpdateTask] UpdateTask error %s.", err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[j.UpdateTask] UpdateTask error %s.", err.Error())
 	}
 
 	return nil
@@ -124,8 +121,75 @@ func (j *Job) UpdateTask(task *Task) (err error) { // {{{
 
 //删除作业任务映射关系至元数据库
 func (j *Job) DeleteTask(taskid int64) (err error) { // {{{
-	delete(j.Tasks, string(taskid))
+	delete(j.Tasks, taskKey(taskid))
 	j.TaskCnt--
 
 	return nil
 } // }}}
+
+//DetectCycles检查作业中任务的依赖关系（RelTasks）是否存在循环。
+//依赖关系构成有向无环图时，执行时互不依赖的任务并行执行，任务在其依赖的任务全部结束后开始；
+//存在循环时循环中的任务永远无法开始，返回包含循环路径的error信息。
+//检查沿依赖关系进入其它作业的任务，按任务ID的顺序进行，结果是确定的。
+func (j *Job) DetectCycles() error { // {{{
+	//0.未访问 1.访问中（在当前路径上） 2.已完成
+	state := make(map[int64]int)
+	path := make([]*Task, 0)
+
+	var visit func(t *Task) error
+	visit = func(t *Task) error {
+		switch state[t.Id] {
+		case 1:
+			//循环路径从t开始，沿依赖关系回到t
+			names := make([]string, 0)
+			for i := len(path) - 1; i >= 0 && len(names) == 0; i-- {
+				if path[i].Id == t.Id {
+					for _, pt := range path[i:] {
+						names = append(names, pt.Name)
+					}
+				}
+			}
+			names = append(names, t.Name)
+			return newError(CodeInvalid, nil, "\n[j.DetectCycles] job [%d %s] has task dependency cycle %s.", j.Id, j.Name, strings.Join(names, " -> "))
+		case 2:
+			return nil
+		}
+
+		state[t.Id] = 1
+		path = append(path, t)
+		for _, rt := range sortTasks(t.RelTasks) {
+			if err := visit(rt); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[t.Id] = 2
+		return nil
+	}
+
+	for _, t := range sortTasks(j.Tasks) {
+		if err := visit(t); err != nil {
+			return err
+		}
+	}
+	return nil
+} // }}}
+
+//sortTasks返回按任务ID排序的任务列表，忽略为nil的任务
+func sortTasks(tasks map[string]*Task) []*Task { // {{{
+	list := make([]*Task, 0, len(tasks))
+	for _, t := range tasks {
+		if t != nil {
+			list = append(list, t)
+		}
+	}
+	sort.Sort(taskById(list))
+	return list
+} // }}}
+
+//按任务ID排序
+type taskById []*Task
+
+func (s taskById) Len() int           { return len(s) }
+func (s taskById) Less(i, j int) bool { return s[i].Id < s[j].Id }
+func (s taskById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,125 @@
+package schedule
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+//只记录在执行日志中的状态变化类型，其余类型与调度事件相同
+const (
+	JournalTaskStart EventType = EventTaskStart //任务发送执行，每次重试各记录一次
+	JournalTaskRetry EventType = "task_retry"   //任务本次执行失败，等待重试
+)
+
+//执行日志的序号，以进程启动时间为初值，保证修复执行复用批次ID时序号仍然递增
+var journalSeq = time.Now().UnixNano()
+
+//执行日志中的一条状态变化记录
+type JournalEntry struct { // {{{
+	BatchId  string    //批次ID
+	Seq      int64     //序号，按发生先后递增
+	Time     time.Time //发生时间
+	Type     EventType //状态变化类型
+	TaskId   int64     //任务ID，调度的状态变化为0
+	TaskName string    //任务名称
+	Attempt  int       //任务的执行次数
+	State    int8      //调度或任务变化后的状态
+	Message  string    //附加信息
+} // }}}
+
+//调度一次执行的时间线
+type Timeline struct { // {{{
+	BatchId    string         //批次ID
+	ScheduleId int64          //调度ID
+	State      int8           //最终状态 1. 执行中（日志中没有结束记录） 3. 完成 4.意外中止
+	StartTime  time.Time      //开始时间
+	EndTime    time.Time      //结束时间，未结束时为零值
+	Entries    []JournalEntry //按发生先后排列的状态变化
+} // }}}
+
+//journal将调度执行过程中的一次状态变化追加到执行日志中。
+//执行日志只用于事后排查，写入失败时记录警告，不影响调度的执行。
+func journal(scdId int64, batchId string, t EventType, task *Task, attempt int, state int8, msg string) { // {{{
+	if g == nil || g.NoLog || g.LogConn == nil {
+		return
+	}
+
+	var taskId int64
+	var taskName string
+	if task != nil {
+		taskId, taskName = task.Id, task.Name
+	}
+
+	seq := atomic.AddInt64(&journalSeq, 1)
+	if err := logJournal(scdId, batchId, seq, t, taskId, taskName, attempt, state, msg); err != nil {
+		g.L.Warningln(fmt.Sprintf("[journal] %s", err.Error()))
+	}
+} // }}}
+
+//ReplayRun从执行日志中还原指定批次的执行过程，按发生先后返回全部状态变化，
+//包括调度的开始与结束、任务的发送、每次失败后的重试、任务的结果以及执行预警。
+//ReplayRun只读取日志，不会重新执行任何任务。
+//设置了GlobalConfigStruct.NoLog的执行不会写入执行日志，无法还原。
+func (sl *ScheduleManager) ReplayRun(batchId string) (*Timeline, error) { // {{{
+	entries, err := getJournal(batchId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.ReplayRun] %s", err.Error())
+	}
+
+	if len(entries) == 0 {
+		return nil, newError(CodeNotFound, nil, "\n[sl.ReplayRun] batch [%s] is not found in journal.", batchId)
+	}
+
+	return newTimeline(entries), nil
+} // }}}
+
+//newTimeline根据按序号排列的状态变化生成时间线，并得出最终状态。
+func newTimeline(entries []JournalEntry) *Timeline { // {{{
+	tl := &Timeline{BatchId: entries[0].BatchId, State: 1, Entries: entries}
+	for _, en := range entries {
+		switch en.Type {
+		case EventRunStart:
+			//修复执行会复用批次ID，以最后一次开始为准
+			tl.StartTime, tl.EndTime, tl.State = en.Time, time.Time{}, 1
+		case EventRunEnd, EventRunFail:
+			tl.EndTime, tl.State = en.Time, en.State
+			if en.Type == EventRunFail {
+				tl.State = 4
+			}
+		}
+	}
+	if tl.StartTime.IsZero() {
+		tl.StartTime = entries[0].Time
+	}
+	return tl
+} // }}}
+
+//String按行输出时间线，每行为发生时间、距开始的时长、状态变化类型及相关信息，最后一行为最终状态。
+func (tl *Timeline) String() string { // {{{
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "batch [%s]\n", tl.BatchId)
+	for _, en := range tl.Entries {
+		fmt.Fprintf(&buf, "%s %+9.3fs %-10s", en.Time.Format("2006-01-02 15:04:05.000"),
+			en.Time.Sub(tl.StartTime).Seconds(), en.Type)
+		if en.TaskId != 0 {
+			fmt.Fprintf(&buf, " task [%d %s]", en.TaskId, en.TaskName)
+		}
+		if en.Attempt != 0 {
+			fmt.Fprintf(&buf, " attempt=%d", en.Attempt)
+		}
+		fmt.Fprintf(&buf, " state=%d", en.State)
+		if en.Message != "" {
+			fmt.Fprintf(&buf, " %q", en.Message)
+		}
+		buf.WriteString("\n")
+	}
+
+	if tl.EndTime.IsZero() {
+		fmt.Fprintf(&buf, "state=%d not finished\n", tl.State)
+	} else {
+		fmt.Fprintf(&buf, "state=%d elapsed %s\n", tl.State, tl.EndTime.Sub(tl.StartTime))
+	}
+	return buf.String()
+} // }}}
@@ -0,0 +1,199 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+//多实例部署时的主节点选举。
+//
+//设置GlobalConfigStruct.Elector后，StartListener不直接启动调度的监听，而是开始选举：
+//每隔LeaderLease的三分之一通过Elector竞选或续约一次，成为主节点时启动监听（同未设置Elector时的StartListener），
+//失去主节点身份时调用StopListener停止全部Timer，正在执行的批次不受影响。
+//续约出错且距上次成功续约已超过LeaderLease时视为失去主节点身份，避免与新的主节点同时启动调度。
+//主节点变化时记录日志并调用GlobalConfigStruct.OnLeaderChange。
+//非主节点不启动任何Timer，手动执行、补数执行不受影响。Shutdown时停止选举并放弃主节点身份。
+//与RunLock同时使用时，主节点切换期间的启动仍按幂等键抢占。
+
+//LeaderElector竞选主节点，同一时刻只有一个实例的Campaign返回true。
+type LeaderElector interface {
+	//Campaign竞选或续约主节点，lease为本次续约的有效时间，返回本实例是否为主节点
+	Campaign(lease time.Duration) (bool, error)
+	//Resign放弃主节点身份，其它实例可以立即当选
+	Resign() error
+}
+
+//TableLeaderElector通过元数据库表Table中名称为Name的租约记录竞选主节点，
+//租约过期或属于本实例时更新为本实例并延长，没有记录时插入。
+//租约时间按各实例的系统时间计算，各实例的时间偏差应远小于LeaderLease。表结构见script中的scd_leader。
+type TableLeaderElector struct { // {{{
+	Table string //租约记录的表名
+	Name  string //租约名称，同一元数据库中的多组实例使用不同的名称
+	Owner string //本实例的标识，默认为主机名与进程号
+} // }}}
+
+//NewTableLeaderElector返回使用表table中名称为name的租约竞选的TableLeaderElector，表名不合法时返回error
+func NewTableLeaderElector(table, name string) (*TableLeaderElector, error) { // {{{
+	if !tableName.MatchString(table) {
+		e := fmt.Sprintf("\n[NewTableLeaderElector] invalid leader table name [%s].", table)
+		return nil, errors.New(e)
+	}
+	host, _ := os.Hostname()
+	return &TableLeaderElector{Table: table, Name: name, Owner: fmt.Sprintf("%s:%d", host, os.Getpid())}, nil
+} // }}}
+
+//Campaign租约属于本实例或已过期时更新租约，没有租约记录时插入
+func (te *TableLeaderElector) Campaign(lease time.Duration) (bool, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	now := time.Now()
+	until := now.Add(lease).UnixNano() / int64(time.Millisecond)
+
+	sql := fmt.Sprintf(`UPDATE %s SET owner=?, lease_until=? WHERE name=? AND (owner=? OR lease_until<?)`, te.Table)
+	r, err := execDB(ctx, g.HiveConn, sql, te.Owner, until, te.Name, te.Owner, now.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		e := fmt.Sprintf("\n[te.Campaign] Exec sql [%s] error %s.", sql, err.Error())
+		return false, errors.New(e)
+	}
+	if n, err := r.RowsAffected(); err == nil && n > 0 {
+		return true, nil
+	}
+
+	//没有租约记录时插入，插入失败说明其它实例持有租约
+	sql = fmt.Sprintf(`INSERT INTO %s (name, owner, lease_until) VALUES (?, ?, ?)`, te.Table)
+	if _, err = execDB(ctx, g.HiveConn, sql, te.Name, te.Owner, until); err != nil {
+		return false, nil
+	}
+	return true, nil
+} // }}}
+
+//Resign删除属于本实例的租约记录
+func (te *TableLeaderElector) Resign() error { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := fmt.Sprintf(`DELETE FROM %s WHERE name=? AND owner=?`, te.Table)
+	if _, err := execDB(ctx, g.HiveConn, sql, te.Name, te.Owner); err != nil {
+		e := fmt.Sprintf("\n[te.Resign] Exec sql [%s] error %s.", sql, err.Error())
+		return errors.New(e)
+	}
+	return nil
+} // }}}
+
+//选举的运行状态
+type election struct { // {{{
+	lock    sync.Mutex
+	leader  bool          //本实例是否为主节点
+	renewed time.Time     //最近一次成功续约的时间
+	stop    chan struct{} //停止选举
+	done    chan struct{} //选举线程已退出
+} // }}}
+
+//IsLeader判断本实例是否为主节点，未设置Elector时总是返回true
+func (sl *ScheduleManager) IsLeader() bool { // {{{
+	if sl.Global.Elector == nil {
+		return true
+	}
+	sl.electLock.Lock()
+	el := sl.election
+	sl.electLock.Unlock()
+	if el == nil {
+		return false
+	}
+	el.lock.Lock()
+	defer el.lock.Unlock()
+	return el.leader
+} // }}}
+
+//startElection开始选举，已在选举时不重复开始。成为主节点前停止监听，Timer不会启动。
+func (sl *ScheduleManager) startElection() { // {{{
+	sl.electLock.Lock()
+	defer sl.electLock.Unlock()
+	if sl.election != nil {
+		return
+	}
+	sl.listener.stop()
+
+	el := &election{stop: make(chan struct{}), done: make(chan struct{})}
+	sl.election = el
+	lease := sl.Global.LeaderLease
+	if lease <= 0 {
+		lease = 15 * time.Second
+	}
+	go func() {
+		defer close(el.done)
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+		for {
+			sl.campaign(el, lease)
+			select {
+			case <-ticker.C:
+			case <-el.stop:
+				return
+			}
+		}
+	}()
+} // }}}
+
+//campaign竞选或续约一次，主节点身份变化时启动或停止监听
+func (sl *ScheduleManager) campaign(el *election, lease time.Duration) { // {{{
+	leader, err := sl.Global.Elector.Campaign(lease)
+	el.lock.Lock()
+	was := el.leader
+	if err != nil {
+		sl.Global.L.Warningln(fmt.Sprintf("[sl.campaign] %s", err.Error()))
+		//续约失败，租约到期前保持主节点身份
+		leader = was && time.Since(el.renewed) < lease
+	} else if leader {
+		el.renewed = time.Now()
+	}
+	el.leader = leader
+	el.lock.Unlock()
+
+	if leader == was {
+		return
+	}
+	if leader {
+		sl.Global.L.Infoln("[sl.campaign] this instance becomes the leader, start schedule timers")
+		if err := sl.startListener(); err != nil {
+			sl.Global.L.Warningln(fmt.Sprintf("[sl.campaign] %s", err.Error()))
+		}
+	} else {
+		sl.Global.L.Warningln("[sl.campaign] this instance loses the leadership, stop schedule timers")
+		sl.StopListener()
+	}
+	if f := sl.Global.OnLeaderChange; f != nil {
+		f(leader)
+	}
+} // }}}
+
+//stopElection停止选举，本实例为主节点时放弃主节点身份
+func (sl *ScheduleManager) stopElection() { // {{{
+	sl.electLock.Lock()
+	el := sl.election
+	sl.election = nil
+	sl.electLock.Unlock()
+	if el == nil {
+		return
+	}
+	close(el.stop)
+	<-el.done
+
+	el.lock.Lock()
+	leader := el.leader
+	el.leader = false
+	el.lock.Unlock()
+	if !leader {
+		return
+	}
+	if err := sl.Global.Elector.Resign(); err != nil {
+		sl.Global.L.Warningln(fmt.Sprintf("[sl.stopElection] %s", err.Error()))
+	}
+	sl.Global.L.Infoln("[sl.stopElection] this instance resigns the leadership")
+	if f := sl.Global.OnLeaderChange; f != nil {
+		f(false)
+	}
+} // }}}
@@ -0,0 +1,87 @@
+package schedule
+
+import (
+	"context"
+	"sync"
+)
+
+//调度监听的运行状态，记录正在等待启动的Timer，
+//StopListener时通过上下文通知全部Timer退出，并等待它们结束。
+type listener struct { // {{{
+	lock    sync.Mutex
+	done    *sync.Cond         //Timer全部退出的通知
+	ctx     context.Context    //监听的上下文，停止监听时取消
+	cancel  context.CancelFunc //取消监听的上下文
+	cnt     int                //正在运行的Timer数量
+	started bool               //StartListener已运行
+	stopped bool               //监听已停止，新的Timer不再启动
+} // }}}
+
+//创建监听状态
+func newListener() *listener { // {{{
+	l := &listener{}
+	l.done = sync.NewCond(&l.lock)
+	l.ctx, l.cancel = context.WithCancel(context.Background())
+	return l
+} // }}}
+
+//enter在Timer开始时调用，返回监听的上下文；监听已停止时返回false，Timer应直接退出。
+func (l *listener) enter() (context.Context, bool) { // {{{
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.stopped {
+		return nil, false
+	}
+	l.cnt++
+	return l.ctx, true
+} // }}}
+
+//exit在Timer退出时调用
+func (l *listener) exit() { // {{{
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.cnt--; l.cnt == 0 {
+		l.done.Broadcast()
+	}
+} // }}}
+
+//stop取消监听的上下文并等待全部Timer退出
+func (l *listener) stop() { // {{{
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.stopped = true
+	l.cancel()
+	for l.cnt > 0 {
+		l.done.Wait()
+	}
+} // }}}
+
+//reset在开始监听时调用，停止后重新开始监听时使用新的上下文
+func (l *listener) reset() { // {{{
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.started = true
+	if l.stopped {
+		l.ctx, l.cancel = context.WithCancel(context.Background())
+		l.stopped = false
+	}
+} // }}}
+
+//running判断监听是否已开始且未停止
+func (l *listener) running() bool { // {{{
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.started && !l.stopped
+} // }}}
+
+//StopListener停止全部调度的监听，通知正在等待启动的Timer退出并等待它们结束，
+//之后不会再有新的批次启动。正在执行的批次不受影响，执行结束后也不再设置下次执行时间。
+//用于进程退出前的清理，之后可再次调用StartListener重新开始监听。
+func (sl *ScheduleManager) StopListener() { // {{{
+	sl.listener.stop()
+	sl.Global.L.Infoln("[sl.StopListener] all schedule timers are stopped")
+} // }}}
@@ -0,0 +1,425 @@
+package schedule
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//调度定义文件的结构，一个文件描述一个调度，文件可以是YAML（.yaml、.yml）或JSON（.json）格式，
+//两种格式的字段名称相同。调度名称作为调度的唯一标识，同步时按名称匹配元数据库中的调度。
+//
+//	name: daily_etl
+//	cyc: d
+//	tags: [finance, daily]
+//	enabled: true
+//	timeout: 3600
+//	soft_timeout: 1800
+//	start_jitter: 300
+//	retry_count: 2
+//	retry_delay: 600
+//	on_startup: run-if-missed
+//	priority: 10
+//	start:
+//	  - month: 0
+//	    second: 7200
+//	params:
+//	  DT: "{{.RunDate}}"
+//	jobs:
+//	  - name: extract
+//	    tasks:
+//	      - name: load_order
+//	        address: 127.0.0.1
+//	        cmd: /opt/etl/load_order.sh
+//	        param: [order]
+//	      - name: load_user
+//	        address: 127.0.0.1
+//	        cmd: /opt/etl/load_user.sh
+//	        rel: [load_order]
+type scheduleDef struct { // {{{
+	Name        string            `yaml:"name" json:"name"`                 //调度名称
+	Group       string            `yaml:"group" json:"group"`               //调度分组
+	Tags        []string          `yaml:"tags" json:"tags"`                 //调度标签
+	Enabled     *bool             `yaml:"enabled" json:"enabled"`           //是否启用，未设置时启用
+	Count       int8              `yaml:"count" json:"count"`               //调度次数
+	Cyc         string            `yaml:"cyc" json:"cyc"`                   //调度周期
+	TimeOut     int64             `yaml:"timeout" json:"timeout"`           //最大执行时间
+	SoftTimeOut int64             `yaml:"soft_timeout" json:"soft_timeout"` //预警执行时间
+	Overlap     string            `yaml:"overlap" json:"overlap"`           //上一批次未结束时的处理策略
+	Misfire     string            `yaml:"misfire" json:"misfire"`           //重启后错过启动时间的处理策略
+	TimeZone    string            `yaml:"timezone" json:"timezone"`         //启动时间所在的时区
+	StartJitter int64             `yaml:"start_jitter" json:"start_jitter"` //启动时间后随机推迟的最长时间，单位秒
+	RetryCount  int               `yaml:"retry_count" json:"retry_count"`   //批次失败后重新执行整个调度链的次数
+	RetryDelay  int64             `yaml:"retry_delay" json:"retry_delay"`   //重新执行前的等待时间，单位秒
+	OnStartup   string            `yaml:"on_startup" json:"on_startup"`     //进程启动时是否立即执行
+	Priority    int               `yaml:"priority" json:"priority"`         //等待并发位置时的优先级，数值大的优先
+	Desc        string            `yaml:"desc" json:"desc"`                 //调度说明
+	Start       []startDef        `yaml:"start" json:"start"`               //启动时间列表
+	Weekdays    []int             `yaml:"weekdays" json:"weekdays"`         //按周调度时的启动星期，0为星期日
+	Params      map[string]string `yaml:"params" json:"params"`             //调度的运行参数，任务中的同名参数覆盖调度的参数
+	Jobs        []jobDef          `yaml:"jobs" json:"jobs"`                 //按执行先后排列的作业
+} // }}}
+
+//定义文件的扩展名
+var defExts = map[string]bool{".yaml": true, ".yml": true, ".json": true}
+
+//parseScheduleDef按文件扩展名解析调度定义，.json按JSON解析，其它按YAML解析
+func parseScheduleDef(file string, content []byte) (*scheduleDef, error) { // {{{
+	def := &scheduleDef{}
+	var err error
+	if strings.ToLower(filepath.Ext(file)) == ".json" {
+		err = json.Unmarshal(content, def)
+	} else {
+		err = yaml.Unmarshal(content, def)
+	}
+	return def, err
+} // }}}
+
+//schedule返回按定义设置了调度属性与启动列表的Schedule，不包含作业和任务
+func (def *scheduleDef) schedule() *Schedule { // {{{
+	s := &Schedule{
+		Name:               def.Name,
+		Group:              def.Group,
+		Tags:               append(make([]string, 0, len(def.Tags)), def.Tags...),
+		Enabled:            def.Enabled == nil || *def.Enabled,
+		Count:              def.Count,
+		Cyc:                def.Cyc,
+		TimeOut:            def.TimeOut,
+		SoftTimeOut:        def.SoftTimeOut,
+		Overlap:            def.Overlap,
+		Misfire:            def.Misfire,
+		TimeZone:           def.TimeZone,
+		StartJitter:        time.Duration(def.StartJitter) * time.Second,
+		ScheduleRetryCount: def.RetryCount,
+		ScheduleRetryDelay: def.RetryDelay,
+		OnStartupPolicy:    def.OnStartup,
+		Priority:           def.Priority,
+		Desc:               def.Desc,
+		Params:             def.Params,
+		StartMonth:         make([]int, 0),
+		StartSecond:        make([]time.Duration, 0),
+		StartWeekday:       def.weekdays(),
+	}
+	for _, st := range def.Start {
+		s.StartMonth = append(s.StartMonth, st.Month)
+		s.StartSecond = append(s.StartSecond, time.Duration(st.Second)*time.Second)
+	}
+	return s
+} // }}}
+
+//validate在修改元数据库前校验调度定义，校验规则与UpdateSchedule相同，见Schedule.Validate，
+//同时检查参数、任务名称是否重复以及任务依赖是否存在，全部通过时返回定义对应的Schedule。
+func (def *scheduleDef) validate() (*Schedule, error) { // {{{
+	s := def.schedule()
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	tasks := make(map[string]bool)
+	for _, jd := range def.Jobs {
+		for _, td := range jd.Tasks {
+			if tasks[td.Name] {
+				return nil, newError(CodeInvalid, nil, "task name [%s] is duplicated in schedule [%s].", td.Name, def.Name)
+			}
+			tasks[td.Name] = true
+			s.Tasks = append(s.Tasks, &Task{Name: td.Name, Params: td.Params})
+		}
+	}
+	for _, jd := range def.Jobs {
+		for _, td := range jd.Tasks {
+			for _, rel := range td.Rel {
+				if !tasks[rel] {
+					return nil, newError(CodeInvalid, nil, "task [%s] depends on unknown task [%s].", td.Name, rel)
+				}
+			}
+			for rel, cond := range td.RelOn {
+				switch cond {
+				case RelOnSuccess, RelOnFailure, RelAlways:
+				default:
+					return nil, newError(CodeInvalid, nil, "unknown condition [%s] of task [%s] on [%s].", cond, td.Name, rel)
+				}
+			}
+		}
+	}
+	if err := s.checkParams(); err != nil {
+		return nil, err
+	}
+
+	s.Tasks = nil
+	return s, nil
+} // }}}
+
+//weekdays返回定义中的启动星期
+func (def *scheduleDef) weekdays() []time.Weekday { // {{{
+	wds := make([]time.Weekday, 0, len(def.Weekdays))
+	for _, wd := range def.Weekdays {
+		wds = append(wds, time.Weekday(wd))
+	}
+	return wds
+} // }}}
+
+//启动时间的定义，month为第几月（0表示不指定），second为周期内启动时间（秒）
+type startDef struct { // {{{
+	Month  int   `yaml:"month" json:"month"`
+	Second int64 `yaml:"second" json:"second"`
+} // }}}
+
+//作业的定义
+type jobDef struct { // {{{
+	Name          string    `yaml:"name" json:"name"`                     //作业名称
+	Desc          string    `yaml:"desc" json:"desc"`                     //作业说明
+	ParallelGroup int       `yaml:"parallel_group" json:"parallel_group"` //并行组
+	TimeOut       int64     `yaml:"timeout" json:"timeout"`               //最大执行时间，单位秒
+	Tasks         []taskDef `yaml:"tasks" json:"tasks"`                   //作业中的任务
+} // }}}
+
+//任务的定义，rel中填写依赖任务的名称，名称在调度内需唯一
+type taskDef struct { // {{{
+	Name          string            `yaml:"name" json:"name"`                     //任务名称
+	Address       string            `yaml:"address" json:"address"`               //任务的执行地址
+	Type          int64             `yaml:"type" json:"type"`                     //任务类型
+	Cyc           string            `yaml:"cyc" json:"cyc"`                       //任务周期
+	Start         int64             `yaml:"start" json:"start"`                   //周期内启动时间（秒）
+	Cmd           string            `yaml:"cmd" json:"cmd"`                       //任务执行的命令
+	Desc          string            `yaml:"desc" json:"desc"`                     //任务说明
+	TimeOut       int64             `yaml:"timeout" json:"timeout"`               //超时时间（秒）
+	Retry         int               `yaml:"retry" json:"retry"`                   //失败后的重试次数
+	RetryInterval int64             `yaml:"retry_interval" json:"retry_interval"` //重试间隔（秒）
+	Wave          int               `yaml:"wave" json:"wave"`                     //执行阶段
+	ResourcePool  string            `yaml:"resource_pool" json:"resource_pool"`   //使用的资源池
+	ExecutorType  string            `yaml:"executor_type" json:"executor_type"`   //在进程内执行任务的TaskExecutor名称，为空时发送给Worker执行
+	Disabled      bool              `yaml:"disabled" json:"disabled"`             //禁用任务，执行时跳过
+	Param         []string          `yaml:"param" json:"param"`                   //任务参数
+	Params        map[string]string `yaml:"params" json:"params"`                 //任务的运行参数
+	Rel           []string          `yaml:"rel" json:"rel"`                       //依赖的任务名称
+	RelOn         map[string]string `yaml:"rel_on" json:"rel_on"`                 //依赖任务结束后的执行条件，键为依赖的任务名称，未设置时为success
+} // }}}
+
+//LoadFromDir读取目录下全部的调度定义文件（.yaml、.yml、.json），按调度名称在元数据库中
+//新增或更新对应的调度，目录中的文件合并为一组调度，同一调度名称只能在一个文件中定义。
+//同步前按UpdateSchedule的规则校验每个文件，校验失败的文件不修改对应的调度。
+//GlobalConfigStruct.MetaStore为NewMemStore返回的内存存储时，调度只注册在内存中，不需要元数据库。
+//每个文件的内容摘要会记录在scd_schedule_source表中，
+//内容未发生变化的文件直接跳过，保证重复同步不产生多余的修改。
+//内容变化的调度会删除原有的作业、任务后按文件重新创建，调度Id保持不变。
+//GlobalConfigStruct.PruneOnLoad为true时，之前由文件同步、但目录中已不存在
+//对应文件的调度会被删除。
+//新增的调度需调用StartScheduleById启动监听，已在监听的调度在下次启动时生效。
+//返回目录中全部文件对应的调度。
+func (sl *ScheduleManager) LoadFromDir(path string) ([]*Schedule, error) { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, newError(CodeUnknown, err, "\n[sl.LoadFromDir] read dir [%s] error %s.", path, err.Error())
+	}
+
+	sources, err := g.store().GetScheduleSources()
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.LoadFromDir] %s", err.Error())
+	}
+
+	names := make([]string, 0)
+	for _, f := range files {
+		ext := strings.ToLower(filepath.Ext(f.Name()))
+		if !f.IsDir() && defExts[ext] {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+
+	scds := make([]*Schedule, 0)
+	loaded := make(map[string]string) //已加载的调度名称与文件的对应关系
+	for _, name := range names {
+		file := filepath.Join(path, name)
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return scds, newError(CodeUnknown, err, "\n[sl.LoadFromDir] read file [%s] error %s.", file, err.Error())
+		}
+
+		def, err := parseScheduleDef(file, content)
+		if err != nil {
+			return scds, newError(CodeInvalid, err, "\n[sl.LoadFromDir] parse file [%s] error %s.", file, err.Error())
+		}
+
+		if def.Name == "" {
+			return scds, newError(CodeInvalid, nil, "\n[sl.LoadFromDir] file [%s] schedule name is required.", file)
+		}
+		if f, ok := loaded[def.Name]; ok {
+			return scds, newError(CodeInvalid, nil, "\n[sl.LoadFromDir] schedule [%s] is defined in both [%s] and [%s].", def.Name, f, file)
+		}
+		loaded[def.Name] = file
+
+		sum := sha1.Sum(content)
+		hash := hex.EncodeToString(sum[:])
+		s, err := sl.syncScheduleDef(def, sources[def.Name], hash)
+		if err != nil {
+			return scds, newError(CodeStore, err, "\n[sl.LoadFromDir] sync file [%s] error %s", file, err.Error())
+		}
+
+		if err = g.store().SaveScheduleSource(def.Name, &ScheduleSource{ScheduleId: s.Id, File: file, Hash: hash}); err != nil {
+			return scds, newError(CodeStore, err, "\n[sl.LoadFromDir] %s", err.Error())
+		}
+		scds = append(scds, s)
+	}
+
+	if !sl.Global.PruneOnLoad {
+		return scds, nil
+	}
+
+	//删除文件已不存在的调度
+	for name, src := range sources {
+		if _, ok := loaded[name]; ok {
+			continue
+		}
+
+		if sl.GetScheduleById(src.ScheduleId) != nil {
+			if err = sl.DeleteSchedule(src.ScheduleId); err != nil {
+				return scds, newError(CodeStore, err, "\n[sl.LoadFromDir] prune schedule [%d %s] error %s", src.ScheduleId, name, err.Error())
+			}
+		}
+
+		if err = g.store().DeleteScheduleSource(name); err != nil {
+			return scds, newError(CodeStore, err, "\n[sl.LoadFromDir] %s", err.Error())
+		}
+		g.L.Infoln("[sl.LoadFromDir] schedule", name, "is pruned, source file", src.File, "was removed")
+	}
+
+	return scds, nil
+} // }}}
+
+//syncScheduleDef将调度定义同步至元数据库。
+//src为调度上次同步的记录，内容摘要一致且调度仍存在时不做修改。
+func (sl *ScheduleManager) syncScheduleDef(def *scheduleDef, src *ScheduleSource, hash string) (*Schedule, error) { // {{{
+	//修改元数据库前先校验调度定义
+	ds, err := def.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var s *Schedule
+	if src != nil {
+		s = sl.GetScheduleById(src.ScheduleId)
+		if s != nil && src.Hash == hash {
+			return s, nil
+		}
+	}
+
+	if s == nil {
+		s = sl.GetScheduleByName(def.Name)
+	}
+
+	if s == nil {
+		s = &Schedule{Name: def.Name, Enabled: true, Jobs: make([]*Job, 0), Tasks: make([]*Task, 0)}
+		if _, err := sl.AddSchedule(s); err != nil {
+			return nil, err
+		}
+	} else {
+		//从元数据库初始化调度链，清除原有的作业和任务
+		if err := s.InitSchedule(); err != nil {
+			return nil, err
+		}
+		if err := s.clearChain(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.Count, s.Cyc, s.TimeOut, s.Desc, s.Group = ds.Count, ds.Cyc, ds.TimeOut, ds.Desc, ds.Group
+	s.SoftTimeOut, s.Overlap, s.Misfire, s.TimeZone = ds.SoftTimeOut, ds.Overlap, ds.Misfire, ds.TimeZone
+	s.StartJitter, s.Priority = ds.StartJitter, ds.Priority
+	s.ScheduleRetryCount, s.ScheduleRetryDelay, s.OnStartupPolicy = ds.ScheduleRetryCount, ds.ScheduleRetryDelay, ds.OnStartupPolicy
+	s.Tags, s.Params, s.Enabled = ds.Tags, ds.Params, ds.Enabled
+	s.StartMonth, s.StartSecond, s.StartWeekday = ds.StartMonth, ds.StartSecond, ds.StartWeekday
+	s.ModifyTime = time.Now()
+
+	if err := s.AddScheduleStart(); err != nil {
+		return nil, err
+	}
+	if err := g.store().UpdateSchedule(s); err != nil {
+		return nil, err
+	}
+	s.savedEnabled = s.Enabled
+	if err := g.store().SaveScheduleTags(s); err != nil {
+		return nil, err
+	}
+	if err := g.store().SaveScheduleParams(s); err != nil {
+		return nil, err
+	}
+	if err := g.store().GetScheduleStart(s); err != nil {
+		return nil, err
+	}
+
+	//依次创建作业和任务，任务依赖在全部任务创建后再建立
+	tasks := make(map[string]*Task)
+	for _, jd := range def.Jobs {
+		job := &Job{
+			Name:          jd.Name,
+			Desc:          jd.Desc,
+			ParallelGroup: jd.ParallelGroup,
+			TimeOut:       jd.TimeOut,
+			ScheduleId:    s.Id,
+			ScheduleCyc:   s.Cyc,
+			CreateUserId:  s.ModifyUserId,
+			ModifyUserId:  s.ModifyUserId,
+		}
+		if _, err := s.AddJob(job); err != nil {
+			return nil, err
+		}
+
+		for _, td := range jd.Tasks {
+			task := &Task{
+				Name:          td.Name,
+				Address:       td.Address,
+				TaskType:      td.Type,
+				ScheduleCyc:   s.Cyc,
+				TaskCyc:       td.Cyc,
+				StartSecond:   time.Duration(td.Start) * time.Second,
+				Cmd:           td.Cmd,
+				Desc:          td.Desc,
+				TimeOut:       td.TimeOut,
+				RetryCount:    td.Retry,
+				RetryInterval: td.RetryInterval,
+				Wave:          td.Wave,
+				ResourcePool:  td.ResourcePool,
+				ExecutorType:  td.ExecutorType,
+				Disabled:      td.Disabled,
+				Param:         td.Param,
+				Params:        td.Params,
+				JobId:         job.Id,
+				CreateUserId:  s.ModifyUserId,
+				CreateTime:    time.Now(),
+				ModifyUserId:  s.ModifyUserId,
+				ModifyTime:    time.Now(),
+			}
+			if err := s.AddTask(task); err != nil {
+				return nil, err
+			}
+			tasks[td.Name] = task
+		}
+	}
+
+	for _, jd := range def.Jobs {
+		for _, td := range jd.Tasks {
+			for _, rel := range td.Rel {
+				rt := tasks[rel]
+				cond := RelOnSuccess
+				if c, ok := td.RelOn[rel]; ok {
+					cond = c
+				}
+				if err := tasks[td.Name].AddRelTaskOn(rt, cond); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	g.L.Infoln("[sl.LoadFromDir] schedule", s.Id, s.Name, "is synced")
+	return s, nil
+} // }}}
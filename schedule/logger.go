@@ -0,0 +1,152 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//LoggerFactory根据调度的信息返回该调度使用的log对象。
+//调度的启动、执行以及其中作业、任务的执行日志均写入该对象，
+//返回nil时使用共用的GlobalConfigStruct.L。
+//同一调度每次执行都会调用，实现时需自行缓存创建的log对象。
+type LoggerFactory func(s *Schedule) *logrus.Logger
+
+//日志的分流方式
+const (
+	LogRouteSchedule = "schedule" //每个调度写入单独的日志文件schedule_<调度ID>.log
+	LogRouteGroup    = "group"    //同一分组的调度写入同一日志文件group_<分组>.log，未分组的调度使用共用的log
+)
+
+//日志的格式，见SetLogFormat
+const (
+	LogFormatText = "text" //文本格式，默认的格式
+	LogFormatJSON = "json" //JSON格式，每行一个JSON对象，便于日志采集系统解析
+)
+
+//调度执行过程中附加在每条日志上的字段
+const (
+	LogFieldSchedule = "schedule_id" //调度ID
+	LogFieldRun      = "run_id"      //执行批次ID
+	LogFieldJob      = "job_id"      //作业ID
+	LogFieldTask     = "task_id"     //任务ID
+	LogFieldWindow   = "window"      //补数执行的启动时间，见Backfill
+)
+
+//设置初始日志级别的环境变量，优先于配置文件中的loglevel，见SetLogLevel
+const LogLevelEnv = "HIVE_LOG_LEVEL"
+
+//日志级别的名称，与logrus.Level的取值对应
+var logLevels = map[string]logrus.Level{
+	"panic":   logrus.Panic,
+	"fatal":   logrus.Fatal,
+	"error":   logrus.Error,
+	"warn":    logrus.Warn,
+	"warning": logrus.Warn,
+	"info":    logrus.Info,
+	"debug":   logrus.Debug,
+}
+
+//ParseLogLevel解析日志级别，level为panic、fatal、error、warn(warning)、info、debug，不区分大小写，
+//也可以是logrus.Level对应的数字0-5。无法识别时返回error。
+func ParseLogLevel(level string) (logrus.Level, error) { // {{{
+	level = strings.ToLower(strings.TrimSpace(level))
+	if l, ok := logLevels[level]; ok {
+		return l, nil
+	}
+	if n, err := strconv.Atoi(level); err == nil && n >= int(logrus.Panic) && n <= int(logrus.Debug) {
+		return logrus.Level(n), nil
+	}
+	return 0, errors.New(fmt.Sprintf("\n[ParseLogLevel] unknown log level [%s].", level))
+} // }}}
+
+//SetLogLevel在运行期间修改日志级别，取值见ParseLogLevel，无法识别时返回error，原有级别不变。
+//FileLoggerFactory创建的log对象在下次使用时沿用新的级别。
+func (sc *GlobalConfigStruct) SetLogLevel(level string) error { // {{{
+	l, err := ParseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	sc.L.Level = l
+	sc.L.Infoln("[sc.SetLogLevel] log level is set to", l.String())
+	return nil
+} // }}}
+
+//SetLogFormat按format设置l的日志格式，取值见LogFormatText、LogFormatJSON，为空时使用文本格式。
+//LoggerFactory创建的log对象沿用base的格式，需在创建LoggerFactory之前设置。
+func SetLogFormat(l *logrus.Logger, format string) error { // {{{
+	switch format {
+	case "", LogFormatText:
+		l.Formatter = new(logrus.TextFormatter)
+	case LogFormatJSON:
+		l.Formatter = new(logrus.JSONFormatter)
+	default:
+		return errors.New(fmt.Sprintf("\n[SetLogFormat] unknown log format [%s].", format))
+	}
+	return nil
+} // }}}
+
+//logger返回调度使用的log对象，未设置GlobalConfigStruct.LoggerFactory时为共用的log对象。
+func (s *Schedule) logger() *logrus.Logger { // {{{
+	if g.LoggerFactory != nil {
+		if l := g.LoggerFactory(s); l != nil {
+			return l
+		}
+	}
+	return g.L
+} // }}}
+
+//logEntry返回附加了调度ID字段的日志对象，调度的初始化、启动和执行过程的日志均通过它记录，
+//执行过程中再逐级附加批次、作业、任务的ID，见ExecSchedule、ExecJob、ExecTask的log。
+func (s *Schedule) logEntry() *logrus.Entry { // {{{
+	return s.logger().WithField(LogFieldSchedule, s.Id)
+} // }}}
+
+//FileLoggerFactory返回按route将调度日志分流至目录dir下不同文件的LoggerFactory，
+//route取值见LogRouteSchedule、LogRouteGroup。
+//新建的log对象沿用base的日志级别和格式，base的级别修改后在下次使用时同步，
+//文件以追加方式打开，进程运行期间不会关闭。
+//文件无法打开时在base中记录警告，该调度继续使用共用的log对象。
+func FileLoggerFactory(base *logrus.Logger, dir string, route string) LoggerFactory { // {{{
+	var lock sync.Mutex
+	loggers := make(map[string]*logrus.Logger)
+
+	return func(s *Schedule) *logrus.Logger {
+		var name string
+		switch route {
+		case LogRouteSchedule:
+			name = fmt.Sprintf("schedule_%d.log", s.Id)
+		case LogRouteGroup:
+			if s.Group == "" {
+				return nil
+			}
+			name = fmt.Sprintf("group_%s.log", filepath.Base(s.Group))
+		default:
+			return nil
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		if l, ok := loggers[name]; ok {
+			l.Level = base.Level
+			return l
+		}
+
+		file := filepath.Join(dir, name)
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			base.Warningln(fmt.Sprintf("[FileLoggerFactory] open log file [%s] error %s.", file, err.Error()))
+			return nil
+		}
+
+		l := logrus.New()
+		l.Out, l.Formatter, l.Level = f, base.Formatter, base.Level
+		loggers[name] = l
+		return l
+	}
+} // }}}
@@ -0,0 +1,654 @@
+package schedule
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+//MemStore为内存中的MetaStore，不需要数据库，进程退出后数据丢失，用于测试或临时执行。
+//保存与读取时复制结构，调用方修改读取到的结构不影响已保存的数据。
+//Id与元数据库相同，按已有的最大Id加1生成。
+type MemStore struct { // {{{
+	lock sync.Mutex
+	tx   sync.Mutex //串行执行InTx
+	data *memData
+} // }}}
+
+//memData为MemStore中保存的数据。已保存的结构只整体替换，不在原结构上修改，
+//因此InTx开始时复制各个map即可作为回滚的快照。
+type memData struct { // {{{
+	schedules map[int64]*Schedule        //调度的基本信息、启动列表、暂缓时间、下次启动时间、依赖的调度、标签与运行参数
+	remains   map[int64]memRemain        //调度剩余的调度次数
+	jobs      map[int64]*Job             //作业
+	jobTasks  map[int64][]int64          //作业下的任务Id
+	tasks     map[int64]*Task            //任务的基本信息、参数、属性、运行参数、依赖的任务与执行条件
+	sources   map[string]*ScheduleSource //调度定义文件的同步记录
+} // }}}
+
+//memRemain为保存剩余次数时的调度次数与剩余次数，调度次数被修改后剩余次数重置，见setRemain
+type memRemain struct { // {{{
+	count  int8
+	remain int
+} // }}}
+
+//NewMemStore返回一个空的内存存储
+func NewMemStore() *MemStore { // {{{
+	return &MemStore{data: &memData{
+		schedules: make(map[int64]*Schedule),
+		remains:   make(map[int64]memRemain),
+		jobs:      make(map[int64]*Job),
+		jobTasks:  make(map[int64][]int64),
+		tasks:     make(map[int64]*Task),
+		sources:   make(map[string]*ScheduleSource),
+	}}
+} // }}}
+
+//snapshot复制各个map，作为InTx回滚时恢复的数据
+func (d *memData) snapshot() *memData { // {{{
+	c := &memData{
+		schedules: make(map[int64]*Schedule, len(d.schedules)),
+		remains:   make(map[int64]memRemain, len(d.remains)),
+		jobs:      make(map[int64]*Job, len(d.jobs)),
+		jobTasks:  make(map[int64][]int64, len(d.jobTasks)),
+		tasks:     make(map[int64]*Task, len(d.tasks)),
+		sources:   make(map[string]*ScheduleSource, len(d.sources)),
+	}
+	for k, v := range d.schedules {
+		c.schedules[k] = v
+	}
+	for k, v := range d.remains {
+		c.remains[k] = v
+	}
+	for k, v := range d.jobs {
+		c.jobs[k] = v
+	}
+	for k, v := range d.jobTasks {
+		c.jobTasks[k] = v
+	}
+	for k, v := range d.tasks {
+		c.tasks[k] = v
+	}
+	for k, v := range d.sources {
+		c.sources[k] = v
+	}
+	return c
+} // }}}
+
+//copyIds、copyStrings、copyParams复制切片或map，nil时返回空的切片或map
+func copyIds(ids []int64) []int64 { // {{{
+	return append(make([]int64, 0, len(ids)), ids...)
+} // }}}
+
+func copyStrings(ss []string) []string { // {{{
+	return append(make([]string, 0, len(ss)), ss...)
+} // }}}
+
+func copyParams(params map[string]string) map[string]string { // {{{
+	c := make(map[string]string, len(params))
+	for k, v := range params {
+		c[k] = v
+	}
+	return c
+} // }}}
+
+//setScheduleRow将src中调度的基本信息复制到dst，不包含Id
+func setScheduleRow(dst *Schedule, src *Schedule) { // {{{
+	dst.Name, dst.Group, dst.Status, dst.Enabled = src.Name, src.Group, src.Status, src.Enabled
+	dst.Count, dst.Cyc, dst.TimeOut, dst.SoftTimeOut = src.Count, src.Cyc, src.TimeOut, src.SoftTimeOut
+	dst.Overlap, dst.Misfire, dst.TimeZone, dst.StartJitter = src.Overlap, src.Misfire, src.TimeZone, src.StartJitter
+	dst.ScheduleRetryCount, dst.ScheduleRetryDelay, dst.OnStartupPolicy = src.ScheduleRetryCount, src.ScheduleRetryDelay, src.OnStartupPolicy
+	dst.Priority = src.Priority
+	dst.JobId, dst.WarmupTaskId, dst.Desc = src.JobId, src.WarmupTaskId, src.Desc
+	dst.CreateUserId, dst.CreateTime, dst.ModifyUserId, dst.ModifyTime = src.CreateUserId, src.CreateTime, src.ModifyUserId, src.ModifyTime
+} // }}}
+
+//setJobRow将src中作业的基本信息复制到dst，不包含Id
+func setJobRow(dst *Job, src *Job) { // {{{
+	dst.Name, dst.Desc, dst.ParallelGroup, dst.TimeOut = src.Name, src.Desc, src.ParallelGroup, src.TimeOut
+	dst.PreJobId, dst.NextJobId = src.PreJobId, src.NextJobId
+	dst.CreateUserId, dst.CreateTime, dst.ModifyUserId, dst.ModifyTime = src.CreateUserId, src.CreateTime, src.ModifyUserId, src.ModifyTime
+} // }}}
+
+//setTaskRow将src中任务的基本信息复制到dst，不包含Id
+func setTaskRow(dst *Task, src *Task) { // {{{
+	dst.Address, dst.Name, dst.TaskType, dst.TaskCyc, dst.StartSecond = src.Address, src.Name, src.TaskType, src.TaskCyc, src.StartSecond
+	dst.Cmd, dst.Desc, dst.TimeOut, dst.RetryCount, dst.RetryInterval = src.Cmd, src.Desc, src.TimeOut, src.RetryCount, src.RetryInterval
+	dst.Wave, dst.ResourcePool, dst.ExecutorType, dst.Disabled = src.Wave, src.ResourcePool, src.ExecutorType, src.Disabled
+	dst.CreateUserId, dst.CreateTime, dst.ModifyUserId, dst.ModifyTime = src.CreateUserId, src.CreateTime, src.ModifyUserId, src.ModifyTime
+} // }}}
+
+//schedule返回已保存调度的副本，用于修改后整体替换，不存在时返回error
+func (ms *MemStore) schedule(id int64) (*Schedule, error) { // {{{
+	r, ok := ms.data.schedules[id]
+	if !ok {
+		return nil, newError(CodeNotFound, nil, "\n[ms.schedule] schedule [%d] not found.", id)
+	}
+	c := *r
+	return &c, nil
+} // }}}
+
+//task返回已保存任务的副本，用于修改后整体替换，不存在时返回error
+func (ms *MemStore) task(id int64) (*Task, error) { // {{{
+	r, ok := ms.data.tasks[id]
+	if !ok {
+		return nil, newError(CodeNotFound, nil, "\n[ms.task] task [%d] not found.", id)
+	}
+	c := *r
+	return &c, nil
+} // }}}
+
+//loadSchedule按已保存的调度r设置s，与元数据库相同，启动月份减去1，没有启动时间时为每周期开始时启动
+func (ms *MemStore) loadSchedule(s *Schedule, r *Schedule) { // {{{
+	setScheduleRow(s, r)
+	ms.loadStart(s, r)
+	s.SnoozeUntil, s.NextStart = r.SnoozeUntil, r.NextStart
+	s.DependsOn = copyIds(r.DependsOn)
+	s.Triggers = append(make([]Trigger, 0, len(r.Triggers)), r.Triggers...)
+	s.Tags = copyStrings(r.Tags)
+	s.Params = copyParams(r.Params)
+
+	s.Remain = int(s.Count)
+	if rm, ok := ms.data.remains[s.Id]; ok && rm.count == s.Count {
+		s.Remain = rm.remain
+	}
+} // }}}
+
+//loadStart按已保存的调度r设置s的启动列表与启动星期
+func (ms *MemStore) loadStart(s *Schedule, r *Schedule) { // {{{
+	s.StartSecond = append(make([]time.Duration, 0, len(r.StartSecond)), r.StartSecond...)
+	s.StartMonth = make([]int, 0, len(r.StartMonth))
+	for _, m := range r.StartMonth {
+		if m > 0 {
+			m -= 1
+		}
+		s.StartMonth = append(s.StartMonth, m)
+	}
+	if len(s.StartSecond) == 0 {
+		s.StartSecond, s.StartMonth = []time.Duration{0}, []int{0}
+	}
+	s.StartWeekday = append(make([]time.Weekday, 0, len(r.StartWeekday)), r.StartWeekday...)
+	s.sortStart()
+} // }}}
+
+func (ms *MemStore) GetAllSchedules(ctx context.Context) ([]*Schedule, error) { // {{{
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	ids := make([]int64, 0, len(ms.data.schedules))
+	for id := range ms.data.schedules {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	scds := make([]*Schedule, 0, len(ids))
+	for _, id := range ids {
+		s := &Schedule{Id: id}
+		ms.loadSchedule(s, ms.data.schedules[id])
+		scds = append(scds, s)
+	}
+	return scds, nil
+} // }}}
+
+func (ms *MemStore) GetSchedule(ctx context.Context, s *Schedule) error { // {{{
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	r, ok := ms.data.schedules[s.Id]
+	if !ok {
+		return newError(CodeNotFound, nil, "\n[ms.GetSchedule] schedule [%d] not found.", s.Id)
+	}
+	ms.loadSchedule(s, r)
+	return nil
+} // }}}
+
+func (ms *MemStore) GetScheduleStart(s *Schedule) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	r, ok := ms.data.schedules[s.Id]
+	if !ok {
+		//与元数据库相同，没有启动时间时为每周期开始时启动
+		r = &Schedule{}
+	}
+	ms.loadStart(s, r)
+	return nil
+} // }}}
+
+func (ms *MemStore) AddSchedule(s *Schedule) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	var id int64
+	for k := range ms.data.schedules {
+		if k > id {
+			id = k
+		}
+	}
+	s.Id = id + 1
+
+	r := &Schedule{Id: s.Id}
+	setScheduleRow(r, s)
+	ms.data.schedules[s.Id] = r
+	return nil
+} // }}}
+
+func (ms *MemStore) UpdateSchedule(s *Schedule) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	r, err := ms.schedule(s.Id)
+	if err != nil {
+		return err
+	}
+	setScheduleRow(r, s)
+	ms.data.schedules[s.Id] = r
+	return nil
+} // }}}
+
+//DeleteSchedule删除调度，并删除其它调度对它的依赖与触发关系
+func (ms *MemStore) DeleteSchedule(s *Schedule) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	delete(ms.data.schedules, s.Id)
+	delete(ms.data.remains, s.Id)
+
+	for id, r := range ms.data.schedules {
+		c := *r
+		c.DependsOn = make([]int64, 0, len(r.DependsOn))
+		for _, v := range r.DependsOn {
+			if v != s.Id {
+				c.DependsOn = append(c.DependsOn, v)
+			}
+		}
+		c.Triggers = make([]Trigger, 0, len(r.Triggers))
+		for _, t := range r.Triggers {
+			if t.ScheduleId != s.Id {
+				c.Triggers = append(c.Triggers, t)
+			}
+		}
+		if len(c.DependsOn) != len(r.DependsOn) || len(c.Triggers) != len(r.Triggers) {
+			ms.data.schedules[id] = &c
+		}
+	}
+	return nil
+} // }}}
+
+//update按id获取调度的副本，fn修改后替换已保存的调度
+func (ms *MemStore) update(id int64, fn func(r *Schedule)) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	r, err := ms.schedule(id)
+	if err != nil {
+		return err
+	}
+	fn(r)
+	ms.data.schedules[id] = r
+	return nil
+} // }}}
+
+func (ms *MemStore) SaveScheduleStart(s *Schedule) error { // {{{
+	return ms.update(s.Id, func(r *Schedule) {
+		r.StartSecond = append(make([]time.Duration, 0, len(s.StartSecond)), s.StartSecond...)
+		r.StartMonth = append(make([]int, 0, len(s.StartMonth)), s.StartMonth...)
+		r.StartWeekday = append(make([]time.Weekday, 0, len(s.StartWeekday)), s.StartWeekday...)
+	})
+} // }}}
+
+func (ms *MemStore) SaveScheduleTags(s *Schedule) error { // {{{
+	return ms.update(s.Id, func(r *Schedule) {
+		r.Tags = copyStrings(s.Tags)
+		sort.Strings(r.Tags)
+	})
+} // }}}
+
+func (ms *MemStore) SaveScheduleParams(s *Schedule) error { // {{{
+	return ms.update(s.Id, func(r *Schedule) { r.Params = copyParams(s.Params) })
+} // }}}
+
+func (ms *MemStore) SaveScheduleStatus(s *Schedule) error { // {{{
+	return ms.update(s.Id, func(r *Schedule) { r.Status = s.Status })
+} // }}}
+
+func (ms *MemStore) SaveScheduleSnooze(s *Schedule) error { // {{{
+	return ms.update(s.Id, func(r *Schedule) { r.SnoozeUntil = s.SnoozeUntil })
+} // }}}
+
+func (ms *MemStore) SaveScheduleRemain(s *Schedule) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	ms.data.remains[s.Id] = memRemain{count: s.Count, remain: s.Remain}
+	return nil
+} // }}}
+
+func (ms *MemStore) SaveScheduleNextStart(s *Schedule) error { // {{{
+	return ms.update(s.Id, func(r *Schedule) { r.NextStart = s.NextStart })
+} // }}}
+
+func (ms *MemStore) AddRelSchedule(s *Schedule, relId int64) error { // {{{
+	return ms.update(s.Id, func(r *Schedule) {
+		r.DependsOn = append(copyIds(r.DependsOn), relId)
+		sort.Slice(r.DependsOn, func(i, j int) bool { return r.DependsOn[i] < r.DependsOn[j] })
+	})
+} // }}}
+
+func (ms *MemStore) SaveScheduleTriggers(s *Schedule) error { // {{{
+	return ms.update(s.Id, func(r *Schedule) { r.Triggers = append(make([]Trigger, 0, len(s.Triggers)), s.Triggers...) })
+} // }}}
+
+func (ms *MemStore) DeleteRelSchedule(s *Schedule, relId int64) error { // {{{
+	return ms.update(s.Id, func(r *Schedule) {
+		ids := make([]int64, 0, len(r.DependsOn))
+		for _, id := range r.DependsOn {
+			if id != relId {
+				ids = append(ids, id)
+			}
+		}
+		r.DependsOn = ids
+	})
+} // }}}
+
+func (ms *MemStore) GetJob(ctx context.Context, j *Job) error { // {{{
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	r, ok := ms.data.jobs[j.Id]
+	if !ok {
+		return newError(CodeNotFound, nil, "\n[ms.GetJob] job [%d] not found.", j.Id)
+	}
+	setJobRow(j, r)
+	j.Tasks = make(map[string]*Task)
+	return nil
+} // }}}
+
+func (ms *MemStore) GetJobTaskIds(j *Job) ([]int64, error) { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	return copyIds(ms.data.jobTasks[j.Id]), nil
+} // }}}
+
+func (ms *MemStore) GetJobNext() (map[int64]int64, error) { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	next := make(map[int64]int64, len(ms.data.jobs))
+	for id, j := range ms.data.jobs {
+		next[id] = j.NextJobId
+	}
+	return next, nil
+} // }}}
+
+func (ms *MemStore) AddJob(j *Job) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	var id int64
+	for k := range ms.data.jobs {
+		if k > id {
+			id = k
+		}
+	}
+	j.Id = id + 1
+
+	r := &Job{Id: j.Id}
+	setJobRow(r, j)
+	ms.data.jobs[j.Id] = r
+	return nil
+} // }}}
+
+func (ms *MemStore) UpdateJob(j *Job) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	r, ok := ms.data.jobs[j.Id]
+	if !ok {
+		return newError(CodeNotFound, nil, "\n[ms.UpdateJob] job [%d] not found.", j.Id)
+	}
+	c := *r
+	setJobRow(&c, j)
+	c.CreateUserId, c.CreateTime = r.CreateUserId, r.CreateTime
+	ms.data.jobs[j.Id] = &c
+	return nil
+} // }}}
+
+func (ms *MemStore) MoveJobTasks(j *Job, jobId int64) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	ms.data.jobTasks[jobId] = append(copyIds(ms.data.jobTasks[jobId]), ms.data.jobTasks[j.Id]...)
+	delete(ms.data.jobTasks, j.Id)
+	return nil
+} // }}}
+
+func (ms *MemStore) DeleteJob(j *Job) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	delete(ms.data.jobs, j.Id)
+	return nil
+} // }}}
+
+func (ms *MemStore) GetTask(t *Task) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	r, ok := ms.data.tasks[t.Id]
+	if !ok {
+		return newError(CodeNotFound, nil, "\n[ms.GetTask] task [%d] not found.", t.Id)
+	}
+
+	setTaskRow(t, r)
+	t.Param = copyStrings(r.Param)
+	t.Attr = copyParams(r.Attr)
+	t.Params = copyParams(r.Params)
+	t.RelTasksId = copyIds(r.RelTasksId)
+	t.RelConditions = make(map[int64]string, len(r.RelConditions))
+	for k, v := range r.RelConditions {
+		t.RelConditions[k] = v
+	}
+	return nil
+} // }}}
+
+func (ms *MemStore) GetTaskJobIds(taskId int64) ([]int64, error) { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	ids := make([]int64, 0)
+	for jobId, tasks := range ms.data.jobTasks {
+		for _, id := range tasks {
+			if id == taskId {
+				ids = append(ids, jobId)
+				break
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+} // }}}
+
+func (ms *MemStore) AddTask(t *Task) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	var id int64
+	for k := range ms.data.tasks {
+		if k > id {
+			id = k
+		}
+	}
+	t.Id = id + 1
+
+	r := &Task{
+		Id:            t.Id,
+		Param:         copyStrings(t.Param),
+		Attr:          copyParams(t.Attr),
+		Params:        copyParams(t.Params),
+		RelTasksId:    make([]int64, 0, len(t.RelTasks)),
+		RelConditions: make(map[int64]string),
+	}
+	setTaskRow(r, t)
+	for _, rt := range t.RelTasks {
+		r.RelTasksId = append(r.RelTasksId, rt.Id)
+		if cond := t.relCondition(rt.Id); cond != RelOnSuccess {
+			r.RelConditions[rt.Id] = cond
+		}
+	}
+	ms.data.tasks[t.Id] = r
+	ms.data.jobTasks[t.JobId] = append(copyIds(ms.data.jobTasks[t.JobId]), t.Id)
+	return nil
+} // }}}
+
+func (ms *MemStore) UpdateTask(t *Task) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	r, err := ms.task(t.Id)
+	if err != nil {
+		return err
+	}
+	setTaskRow(r, t)
+	r.CreateUserId, r.CreateTime = ms.data.tasks[t.Id].CreateUserId, ms.data.tasks[t.Id].CreateTime
+	r.Param, r.Params = copyStrings(t.Param), copyParams(t.Params)
+	ms.data.tasks[t.Id] = r
+	return nil
+} // }}}
+
+func (ms *MemStore) SaveTaskDisabled(t *Task) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	r, err := ms.task(t.Id)
+	if err != nil {
+		return err
+	}
+	r.Disabled = t.Disabled
+	ms.data.tasks[t.Id] = r
+	return nil
+} // }}}
+
+//DeleteTask删除任务，并删除其它任务对它的依赖以及它与作业t.JobId的关系
+func (ms *MemStore) DeleteTask(t *Task) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	delete(ms.data.tasks, t.Id)
+
+	for id, r := range ms.data.tasks {
+		if _, ok := ms.relIndex(r, t.Id); ok {
+			ms.data.tasks[id] = ms.withoutRel(r, t.Id)
+		}
+	}
+
+	ids := make([]int64, 0)
+	for _, id := range ms.data.jobTasks[t.JobId] {
+		if id != t.Id {
+			ids = append(ids, id)
+		}
+	}
+	ms.data.jobTasks[t.JobId] = ids
+	return nil
+} // }}}
+
+//relIndex返回任务r对relId的依赖在RelTasksId中的位置
+func (ms *MemStore) relIndex(r *Task, relId int64) (int, bool) { // {{{
+	for i, id := range r.RelTasksId {
+		if id == relId {
+			return i, true
+		}
+	}
+	return -1, false
+} // }}}
+
+//withoutRel返回去掉对relId依赖后的任务r的副本
+func (ms *MemStore) withoutRel(r *Task, relId int64) *Task { // {{{
+	c := *r
+	c.RelTasksId = make([]int64, 0, len(r.RelTasksId))
+	for _, id := range r.RelTasksId {
+		if id != relId {
+			c.RelTasksId = append(c.RelTasksId, id)
+		}
+	}
+	c.RelConditions = make(map[int64]string, len(r.RelConditions))
+	for k, v := range r.RelConditions {
+		if k != relId {
+			c.RelConditions[k] = v
+		}
+	}
+	return &c
+} // }}}
+
+func (ms *MemStore) AddRelTask(t *Task, relId int64, cond string) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	r, err := ms.task(t.Id)
+	if err != nil {
+		return err
+	}
+	r.RelTasksId = append(copyIds(r.RelTasksId), relId)
+	r.RelConditions = make(map[int64]string, len(r.RelConditions)+1)
+	for k, v := range ms.data.tasks[t.Id].RelConditions {
+		r.RelConditions[k] = v
+	}
+	if cond != "" && cond != RelOnSuccess {
+		r.RelConditions[relId] = cond
+	}
+	ms.data.tasks[t.Id] = r
+	return nil
+} // }}}
+
+func (ms *MemStore) DeleteRelTask(t *Task, relId int64) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	if r, ok := ms.data.tasks[t.Id]; ok {
+		ms.data.tasks[t.Id] = ms.withoutRel(r, relId)
+	}
+	return nil
+} // }}}
+
+func (ms *MemStore) GetScheduleSources() (map[string]*ScheduleSource, error) { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	sources := make(map[string]*ScheduleSource, len(ms.data.sources))
+	for name, src := range ms.data.sources {
+		c := *src
+		sources[name] = &c
+	}
+	return sources, nil
+} // }}}
+
+func (ms *MemStore) SaveScheduleSource(name string, src *ScheduleSource) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	c := *src
+	ms.data.sources[name] = &c
+	return nil
+} // }}}
+
+func (ms *MemStore) DeleteScheduleSource(name string) error { // {{{
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	delete(ms.data.sources, name)
+	return nil
+} // }}}
+
+//InTx串行执行fn，fn返回error时恢复到事务开始时的数据。
+//事务之间不并发，但不隔离事务外的写入，回滚时事务外的写入同样被撤销。
+func (ms *MemStore) InTx(fn func(ms MetaStore) error) error { // {{{
+	ms.tx.Lock()
+	defer ms.tx.Unlock()
+
+	ms.lock.Lock()
+	snap := ms.data.snapshot()
+	ms.lock.Unlock()
+
+	if err := fn(memTx{ms}); err != nil {
+		ms.lock.Lock()
+		ms.data = snap
+		ms.lock.Unlock()
+		return err
+	}
+	return nil
+} // }}}
+
+//memTx为InTx中传给fn的MemStore，在事务中再调用InTx时直接执行
+type memTx struct { // {{{
+	*MemStore
+} // }}}
+
+func (mt memTx) InTx(fn func(ms MetaStore) error) error { // {{{
+	return fn(mt)
+} // }}}
@@ -0,0 +1,83 @@
+package schedule
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+//调度目录指标的名称
+const CatalogMetricName = "hivego_schedule_info"
+
+//WriteCatalogMetrics按Prometheus文本格式输出调度目录的信息指标。
+//每个调度输出一条值恒为1的gauge，标签为调度的静态属性，供监控面板展示调度清单，
+//或按id与执行指标关联：
+//
+//	hivego_schedule_info{id="1",name="数据仓库调度",cycle="d",group="dw",state="enabled"} 1
+//
+//标签只取调度数量级、变化不频繁的属性，避免指标基数膨胀：
+//
+//	id     调度ID，用于与其它指标关联
+//	name   调度名称
+//	cycle  调度周期（ss、mi、h、d、w、m、q、y）
+//	group  调度分组，未分组时为空
+//	state  enabled 正常调度；paused 已暂停（见PauseScheduleById）；snoozed 暂缓执行中（见Snooze）；
+//	       disabled 未设置周期，不会被调度
+//
+//下次启动时间、执行批次等随时间变化的属性不作为标签，应使用独立的指标。
+//指标按调度ID排序，每次调用时读取当前的调度列表，调度的增删改即时生效。
+func (sl *ScheduleManager) WriteCatalogMetrics(w io.Writer) error { // {{{
+	scds := sl.AllSchedules()
+	sort.Sort(scheduleById(scds))
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# HELP %s Schedule catalog, the value is always 1.\n", CatalogMetricName)
+	fmt.Fprintf(bw, "# TYPE %s gauge\n", CatalogMetricName)
+
+	//暂缓时间由Timer、Snooze在调度列表的锁内修改，读取状态时同样持有锁
+	now := time.Now()
+	states := make([]string, len(scds))
+	sl.lock.RLock()
+	for i, s := range scds {
+		states[i] = s.catalogState(now)
+	}
+	sl.lock.RUnlock()
+
+	for i, s := range scds {
+		fmt.Fprintf(bw, "%s{id=\"%d\",name=\"%s\",cycle=\"%s\",group=\"%s\",state=\"%s\"} 1\n",
+			CatalogMetricName, s.Id, escapeLabel(s.Name), escapeLabel(s.Cyc),
+			escapeLabel(s.Group), states[i])
+	}
+
+	return bw.Flush()
+} // }}}
+
+//catalogState返回调度在目录指标中的状态
+func (s *Schedule) catalogState(now time.Time) string { // {{{
+	switch {
+	case s.Cyc == "":
+		return "disabled"
+	case s.Status == 1:
+		return "paused"
+	case s.SnoozeUntil.After(now):
+		return "snoozed"
+	}
+	return "enabled"
+} // }}}
+
+//escapeLabel按Prometheus文本格式转义标签值中的反斜杠、双引号和换行
+func escapeLabel(v string) string { // {{{
+	return labelReplacer.Replace(v)
+} // }}}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+//按调度ID排序
+type scheduleById []*Schedule
+
+func (s scheduleById) Len() int           { return len(s) }
+func (s scheduleById) Less(i, j int) bool { return s[i].Id < s[j].Id }
+func (s scheduleById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,124 @@
+package schedule
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"text/template"
+	"time"
+)
+
+//运行参数的名称，发送至Worker后设置为环境变量，需符合环境变量的命名
+var paramName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+//ParamData为运行参数模板可以引用的批次信息，参数的值按text/template解析，例如：
+//
+//	DT={{.RunDate}}
+//	HOUR={{.RunTime.Format "15"}}
+//	YESTERDAY={{(.RunTime.AddDate 0 0 -1).Format "20060102"}}
+//
+//RunTime为批次的逻辑时间，补数执行时为补数的启动时间，其余批次为批次的启动时间，见resolveWindow。
+type ParamData struct { // {{{
+	RunDate  string    //逻辑时间的日期，格式为2006-01-02
+	RunTime  time.Time //批次的逻辑时间
+	BatchId  string    //批次ID
+	Schedule string    //调度名称
+	Task     string    //任务名称
+} // }}}
+
+//parseParam按模板解析运行参数的值
+func parseParam(name, value string) (*template.Template, error) { // {{{
+	return template.New(name).Option("missingkey=error").Parse(value)
+} // }}}
+
+//mergeParams合并调度与任务的运行参数，同名时任务的参数覆盖调度的参数，都为空时返回nil
+func mergeParams(scd map[string]string, task map[string]string) map[string]string { // {{{
+	if len(scd) == 0 && len(task) == 0 {
+		return nil
+	}
+
+	params := make(map[string]string, len(scd)+len(task))
+	for k, v := range scd {
+		params[k] = v
+	}
+	for k, v := range task {
+		params[k] = v
+	}
+	return params
+} // }}}
+
+//checkParams校验调度及其下各任务的运行参数，参数名需符合环境变量的命名，
+//参数值需为可以执行的模板，只能引用ParamData中的字段。
+//在InitSchedule时调用，有参数不合法时返回全部不合法参数的信息。
+func (s *Schedule) checkParams() error { // {{{
+	errs := make([]string, 0)
+	check := func(owner string, params map[string]string) {
+		names := make([]string, 0, len(params))
+		for name := range params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if !paramName.MatchString(name) {
+				errs = append(errs, fmt.Sprintf("%s param [%s] is not a valid environment variable name", owner, name))
+				continue
+			}
+			tpl, err := parseParam(name, params[name])
+			if err == nil {
+				err = tpl.Execute(ioutil.Discard, ParamData{})
+			}
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s param [%s] %s", owner, name, err.Error()))
+			}
+		}
+	}
+
+	check("schedule", s.Params)
+	for _, t := range s.Tasks {
+		check(fmt.Sprintf("task [%d %s]", t.Id, t.Name), t.Params)
+	}
+
+	if len(errs) > 0 {
+		return newError(CodeInvalid, nil, "\n[s.checkParams] schedule [%d %s] has invalid params: %v.", s.Id, s.Name, errs)
+	}
+	return nil
+} // }}}
+
+//resolveParams合并调度与任务的运行参数，并按批次的逻辑时间执行其中的模板，没有运行参数时返回nil。
+//模板执行失败的参数记录警告并保留原值。
+func (es *ExecSchedule) resolveParams(et *ExecTask) map[string]string { // {{{
+	params := mergeParams(es.schedule.Params, et.task.Params)
+	if params == nil {
+		return nil
+	}
+
+	es.lock.Lock()
+	rt := es.logicalTime()
+	es.lock.Unlock()
+	data := ParamData{
+		RunDate:  rt.Format("2006-01-02"),
+		RunTime:  rt,
+		BatchId:  es.batchId,
+		Schedule: es.schedule.Name,
+		Task:     et.task.Name,
+	}
+
+	for name, value := range params {
+		var buf bytes.Buffer
+		tpl, err := parseParam(name, value)
+		if err == nil {
+			err = tpl.Execute(&buf, data)
+		}
+		if err != nil {
+			es.log.Warningln("[es.resolveParams] task", et.task.Name, "batchTaskId[", et.batchTaskId,
+				"] param", name, "error", err.Error())
+			continue
+		}
+		params[name] = buf.String()
+	}
+
+	return params
+} // }}}
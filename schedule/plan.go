@@ -0,0 +1,197 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+//任务在执行计划中的处理方式
+const (
+	PlanRun    = "run"    //发送执行
+	PlanSkip   = "skip"   //依赖任务的执行条件不满足，跳过
+	PlanIgnore = "ignore" //不在任务的执行周期内，忽略
+)
+
+//调度的执行计划，由设置了DryRun的ExecSchedule.Run生成
+type ExecPlan struct { // {{{
+	ScheduleId   int64      //调度ID
+	ScheduleName string     //调度名称
+	Steps        int        //执行的步数
+	Tasks        []PlanTask //任务按预计的开始顺序排列
+} // }}}
+
+//执行计划中的任务
+type PlanTask struct { // {{{
+	Step          int       //预计在第几步开始，从1开始，同一步的任务可以同时开始
+	JobId         int64     //所属作业ID
+	JobName       string    //所属作业名称
+	TaskId        int64     //任务ID
+	TaskName      string    //任务名称
+	Wave          int       //执行阶段
+	ParallelGroup int       //所属作业的并行组
+	Action        string    //处理方式，取值见PlanRun、PlanSkip、PlanIgnore
+	Deps          []PlanDep //依赖的任务，按任务ID排序
+} // }}}
+
+//执行计划中任务的依赖
+type PlanDep struct { // {{{
+	TaskId    int64  //依赖的任务ID
+	TaskName  string //依赖的任务名称
+	Condition string //执行条件，取值见RelOnSuccess、RelOnFailure、RelAlways
+	Met       bool   //按计划执行时条件是否满足
+} // }}}
+
+//Plan返回DryRun生成的执行计划，未设置DryRun或尚未执行时返回nil。
+func (es *ExecSchedule) Plan() *ExecPlan { // {{{
+	return es.plan
+} // }}}
+
+//buildPlan按任务的依赖关系、执行阶段与作业的并行组推演调度的执行过程，生成执行计划。
+//推演时假设发送执行的任务全部成功，每一步开始全部满足执行条件的任务，
+//不考虑MaxParallelJobs、资源池等运行时的并发限制，实际执行时同一步的任务可能分多次开始。
+//推演只读取执行结构，不发送任务，也不修改任务的状态。
+func (es *ExecSchedule) buildPlan() (*ExecPlan, error) { // {{{
+	plan := &ExecPlan{ScheduleId: es.schedule.Id, ScheduleName: es.schedule.Name, Tasks: make([]PlanTask, 0)}
+
+	//作业在调度链中的位置，同一步的任务按作业顺序排列
+	jobSeq := make(map[*ExecJob]int)
+	for ej, i := es.execJob, 0; ej != nil; ej, i = ej.nextJob, i+1 {
+		jobSeq[ej] = i
+	}
+
+	state := make(map[int64]int8) //推演得到的任务状态 3. 完成 5.忽略 6.跳过
+	for len(state) < len(es.execTasks) {
+		plan.Steps++
+		ready := make([]*ExecTask, 0)
+		for id, et := range es.execTasks {
+			if _, ok := state[id]; !ok && es.planReady(et, state) {
+				ready = append(ready, et)
+			}
+		}
+		if len(ready) == 0 {
+			e := fmt.Sprintf("\n[es.buildPlan] schedule [%d %s] has %d tasks that can never start.",
+				es.schedule.Id, es.schedule.Name, len(es.execTasks)-len(state))
+			return nil, errors.New(e)
+		}
+		sort.Sort(planOrder{ready, jobSeq})
+
+		//同一步的任务互不依赖，全部确定后再记录状态
+		step := make(map[int64]int8)
+		for _, et := range ready {
+			pt := PlanTask{
+				Step:          plan.Steps,
+				JobId:         et.execJob.job.Id,
+				JobName:       et.execJob.job.Name,
+				TaskId:        et.task.Id,
+				TaskName:      et.task.Name,
+				Wave:          et.task.Wave,
+				ParallelGroup: et.execJob.job.ParallelGroup,
+				Action:        PlanRun,
+				Deps:          make([]PlanDep, 0),
+			}
+			st := int8(3)
+			for _, rel := range et.relExecTasks {
+				cond := et.task.relCondition(rel.task.Id)
+				//推演中不会有失败的任务，RelOnFailure的条件总是不满足
+				met := cond == RelAlways || (cond != RelOnFailure && state[rel.task.Id] != 6)
+				if !met {
+					st = 6
+				}
+				pt.Deps = append(pt.Deps, PlanDep{TaskId: rel.task.Id, TaskName: rel.task.Name, Condition: cond, Met: met})
+			}
+			sort.Sort(depById(pt.Deps))
+			if st == 3 && et.task.TaskCyc != "" && !et.isReady() {
+				st = 5
+			}
+
+			switch st {
+			case 5:
+				pt.Action = PlanIgnore
+			case 6:
+				pt.Action = PlanSkip
+			}
+			step[et.task.Id] = st
+			plan.Tasks = append(plan.Tasks, pt)
+		}
+		for id, st := range step {
+			state[id] = st
+		}
+	}
+
+	return plan, nil
+} // }}}
+
+//planReady判断推演中任务是否可以开始：依赖的任务、之前执行阶段的任务、
+//作业需等待的并行组中的任务均已结束。
+func (es *ExecSchedule) planReady(et *ExecTask, state map[int64]int8) bool { // {{{
+	for id := range et.relExecTasks {
+		if _, ok := state[id]; !ok {
+			return false
+		}
+	}
+
+	for id, other := range es.execTasks {
+		if _, ok := state[id]; ok {
+			continue
+		}
+		if other.task.Wave < et.task.Wave {
+			return false
+		}
+		for _, gid := range et.execJob.waitGroups {
+			if other.execJob.job.ParallelGroup == gid {
+				return false
+			}
+		}
+	}
+	return true
+} // }}}
+
+//同一步的任务按作业在调度链中的顺序、任务ID排序
+type planOrder struct {
+	ets    []*ExecTask
+	jobSeq map[*ExecJob]int
+}
+
+func (p planOrder) Len() int      { return len(p.ets) }
+func (p planOrder) Swap(i, j int) { p.ets[i], p.ets[j] = p.ets[j], p.ets[i] }
+func (p planOrder) Less(i, j int) bool {
+	a, b := p.ets[i], p.ets[j]
+	if sa, sb := p.jobSeq[a.execJob], p.jobSeq[b.execJob]; sa != sb {
+		return sa < sb
+	}
+	return a.task.Id < b.task.Id
+}
+
+//按依赖的任务ID排序
+type depById []PlanDep
+
+func (d depById) Len() int           { return len(d) }
+func (d depById) Less(i, j int) bool { return d[i].TaskId < d[j].TaskId }
+func (d depById) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+//DryRunSchedule从元数据库加载调度链的副本后生成调度的执行计划，不发送任务，
+//也不写入执行日志，只记录一条计划的概要信息。正在监听的调度不被修改。
+func (sl *ScheduleManager) DryRunSchedule(id int64) (*ExecPlan, error) { // {{{
+	s := sl.GetScheduleById(id)
+	if s == nil {
+		return nil, newError(CodeNotFound, nil, "\n[sl.DryRunSchedule] not found schedule by id %d", id)
+	}
+
+	s, err := s.loadCopy()
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.DryRunSchedule] init schedule [%d] error %s.", id, err.Error())
+	}
+
+	es := ExecScheduleWarper(s)
+	es.execType, es.DryRun = 2, true
+	if err = es.InitExecSchedule(); err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.DryRunSchedule] %s", err.Error())
+	}
+	es.Run()
+
+	if es.plan == nil {
+		return nil, newError(CodeInvalid, nil, "\n[sl.DryRunSchedule] schedule [%d %s] has no valid plan.", id, s.Name)
+	}
+	return es.plan, nil
+} // }}}
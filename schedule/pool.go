@@ -0,0 +1,152 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+//资源池限制使用同一资源（如共享的数据库）的任务同时执行的数量，跨调度生效。
+//资源池的容量由GlobalConfigStruct.ResourcePools设置，首次使用时按当时的设置创建，
+//之后修改容量不影响已创建的资源池。
+type resourcePools struct { // {{{
+	lock  sync.Mutex
+	pools map[string]*resourcePool //已创建的资源池，键为资源池名称
+} // }}}
+
+//单个资源池，slots中的元素数量即正在占用的数量
+type resourcePool struct { // {{{
+	name    string        //资源池名称
+	slots   chan struct{} //资源池的占用情况，容量为资源池的容量
+	lock    sync.Mutex
+	waiting int //等待占用的任务数量
+} // }}}
+
+//资源池的使用情况，由ResourcePoolUsage返回
+type PoolUsage struct { // {{{
+	Name     string //资源池名称
+	Capacity int    //容量
+	InUse    int    //正在占用的任务数量
+	Waiting  int    //等待占用的任务数量
+} // }}}
+
+//创建空的资源池列表
+func newResourcePools() *resourcePools { // {{{
+	return &resourcePools{pools: make(map[string]*resourcePool)}
+} // }}}
+
+//get返回指定名称的资源池，尚未创建时按GlobalConfigStruct.ResourcePools创建，
+//资源池未设置或容量小于等于0时返回error信息。
+func (rp *resourcePools) get(name string) (*resourcePool, error) { // {{{
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+
+	if p, ok := rp.pools[name]; ok {
+		return p, nil
+	}
+
+	size := g.ResourcePools[name]
+	if size <= 0 {
+		e := fmt.Sprintf("\n[rp.get] resource pool [%s] is not defined.", name)
+		return nil, errors.New(e)
+	}
+	p := &resourcePool{name: name, slots: make(chan struct{}, size)}
+	rp.pools[name] = p
+	return p, nil
+} // }}}
+
+//acquire占用资源池中的一个位置，资源池已满时等待。
+//等待超过deadline（零值表示不限制）或done被关闭时放弃等待，返回error信息。
+func (p *resourcePool) acquire(deadline time.Time, done <-chan struct{}) error { // {{{
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	p.lock.Lock()
+	p.waiting++
+	p.lock.Unlock()
+	defer func() {
+		p.lock.Lock()
+		p.waiting--
+		p.lock.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(deadline.Sub(time.Now()))
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		e := fmt.Sprintf("\n[p.acquire] wait for resource pool [%s] run over the timeout of the task.", p.name)
+		return errors.New(e)
+	case <-done:
+		e := fmt.Sprintf("\n[p.acquire] wait for resource pool [%s] is cancelled.", p.name)
+		return errors.New(e)
+	}
+} // }}}
+
+//release归还占用的位置
+func (p *resourcePool) release() { // {{{
+	<-p.slots
+} // }}}
+
+//usage返回资源池的使用情况
+func (p *resourcePool) usage() PoolUsage { // {{{
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return PoolUsage{Name: p.name, Capacity: cap(p.slots), InUse: len(p.slots), Waiting: p.waiting}
+} // }}}
+
+//ResourcePoolUsage返回全部资源池的使用情况，按名称排序。
+//已设置但尚未使用的资源池按设置的容量返回，占用与等待数量为0。
+func (sl *ScheduleManager) ResourcePoolUsage() []PoolUsage { // {{{
+	rp := sl.pools
+	rp.lock.Lock()
+	usages := make([]PoolUsage, 0, len(sl.Global.ResourcePools))
+	for _, p := range rp.pools {
+		usages = append(usages, p.usage())
+	}
+	for name, size := range sl.Global.ResourcePools {
+		if _, ok := rp.pools[name]; !ok && size > 0 {
+			usages = append(usages, PoolUsage{Name: name, Capacity: size})
+		}
+	}
+	rp.lock.Unlock()
+
+	sort.Sort(poolByName(usages))
+	return usages
+} // }}}
+
+//按资源池名称排序
+type poolByName []PoolUsage
+
+func (p poolByName) Len() int           { return len(p) }
+func (p poolByName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p poolByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+//acquirePool在任务使用资源池时占用其中的一个位置，返回归还位置的方法。
+//等待不超过任务的截止时间，见ExecTask.deadline，批次被取消时放弃等待。
+func (et *ExecTask) acquirePool() (func(), error) { // {{{
+	name := et.task.ResourcePool
+	if name == "" {
+		return func() {}, nil
+	}
+
+	p, err := g.Schedules.pools.get(name)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("\n[et.acquirePool] %s", err.Error()))
+	}
+	if err = p.acquire(et.deadline, et.done); err != nil {
+		return nil, errors.New(fmt.Sprintf("\n[et.acquirePool] task [%s] %s", et.task.Name, err.Error()))
+	}
+	return p.release, nil
+} // }}}
@@ -0,0 +1,106 @@
+package schedule
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+//孤立批次的检查。
+//
+//批次加入ExecScheduleList后由执行线程在结束或被中止时移除，执行线程因错误提前退出时由cleanup移除。
+//设置GlobalConfigStruct.ExecMaxAge后，监听期间每隔ReapInterval检查一次执行列表，
+//加入时间超过ExecMaxAge的批次视为孤立的批次：先记录警告并请求中止，
+//下一次检查时仍在执行列表中（执行线程已退出或没有响应中止）则直接移除。
+
+//listed判断es是否仍在执行列表中
+func (sl *ScheduleManager) listed(es *ExecSchedule) bool { // {{{
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+	return sl.ExecScheduleList[es.batchId] == es
+} // }}}
+
+//cleanup在执行线程退出时调用，批次仍在执行列表中说明因错误提前结束，
+//按意外中止移除并接收正在执行的任务，避免成为孤立的批次。
+func (es *ExecSchedule) cleanup() { // {{{
+	if !g.Schedules.listed(es) {
+		return
+	}
+	es.log.Warningln("[es.cleanup] schedule", es.schedule.Name, "batchId=[", es.batchId, "] is ended abnormally, remove it")
+	es.abandon()
+} // }}}
+
+//startReaper在StartListener时启动孤立批次的检查，ExecMaxAge小于等于0时不检查。
+//检查线程随监听一起停止，已在检查时不重复启动。
+func (sl *ScheduleManager) startReaper() { // {{{
+	if sl.Global.ExecMaxAge <= 0 || !atomic.CompareAndSwapInt32(&sl.reaping, 0, 1) {
+		return
+	}
+	ctx, ok := sl.listener.enter()
+	if !ok {
+		atomic.StoreInt32(&sl.reaping, 0)
+		return
+	}
+
+	interval := sl.Global.ReapInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	go func() {
+		defer sl.listener.exit()
+		defer atomic.StoreInt32(&sl.reaping, 0)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		cancelled := make(map[string]bool)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				sl.reap(now, cancelled)
+			}
+		}
+	}()
+} // }}}
+
+//reap检查执行列表中加入时间早于now减ExecMaxAge的批次，首次发现时请求中止，
+//已请求过中止的批次直接移除。cancelled记录已请求中止的批次，在各次检查之间保留，
+//返回本次移除的批次数量。
+func (sl *ScheduleManager) reap(now time.Time, cancelled map[string]bool) int { // {{{
+	maxAge := sl.Global.ExecMaxAge
+	stale := make([]*ExecSchedule, 0)
+	sl.lock.RLock()
+	for _, es := range sl.ExecScheduleList {
+		if !es.addTime.IsZero() && now.Sub(es.addTime) > maxAge {
+			stale = append(stale, es)
+		}
+	}
+	sl.lock.RUnlock()
+
+	n := 0
+	found := make(map[string]bool)
+	for _, es := range stale {
+		found[es.batchId] = true
+		age := now.Sub(es.addTime)
+		if !cancelled[es.batchId] && sl.CancelRun(es.batchId) == nil {
+			cancelled[es.batchId] = true
+			sl.Global.L.Warningln(fmt.Sprintf("[sl.reap] batch [%s] has been in the execution list for %s, longer than %s, cancel it.",
+				es.batchId, age, maxAge))
+			continue
+		}
+
+		sl.Global.L.Warningln(fmt.Sprintf("[sl.reap] batch [%s] is orphaned for %s, remove it from the execution list.", es.batchId, age))
+		sl.RemoveExecSchedule(es.batchId)
+		delete(cancelled, es.batchId)
+		n++
+	}
+
+	//已结束的批次不再记录
+	for batchId := range cancelled {
+		if !found[batchId] {
+			delete(cancelled, batchId)
+		}
+	}
+	return n
+} // }}}
@@ -0,0 +1,132 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+//ResumeRun从日志库中恢复失败的批次batchId，从第一个未完成的任务开始继续执行，返回批次ID。
+//执行成功或被忽略的任务保留原有的执行结果，不再执行，它们登记的产出物仍可被下级任务引用，
+//依赖它们的任务视为依赖已满足；失败、暂停、跳过以及尚未执行的任务重新执行。
+//恢复的批次沿用原批次ID，执行日志更新到原有的记录中，执行类型记录为3（修复执行）。
+//批次正在执行、已全部成功、日志中不存在或没有需要执行的任务时返回error，
+//不记录执行日志（NoLog）时无法恢复。
+func (sl *ScheduleManager) ResumeRun(batchId string) (string, error) { // {{{
+	es, err := sl.resumeExecSchedule(batchId)
+	if err != nil {
+		return "", newError(CodeStore, err, "\n[sl.ResumeRun] %s", err.Error())
+	}
+
+	es.log.Infoln(fmt.Sprintf("[sl.ResumeRun] schedule [%d %s] is resumed batchId=[%s] %d tasks left",
+		es.schedule.Id, es.schedule.Name, batchId, es.taskCnt))
+	es.publishEvent(EventScheduleFired, 0, es.state, "resume")
+	go es.Run()
+
+	return batchId, nil
+} // }}}
+
+//resumeExecSchedule根据日志库中批次batchId的执行情况构建恢复执行的结构，并加入执行列表。
+//已完成的任务从执行结构中移除，按执行结果设置其下级任务的状态，见ExecTask.relDone。
+func (sl *ScheduleManager) resumeExecSchedule(batchId string) (*ExecSchedule, error) { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return nil, err
+	}
+	if g.NoLog || g.LogConn == nil {
+		return nil, newError(CodeConflict, nil, "\n[sl.resumeExecSchedule] execution log is disabled, batch [%s] can not be resumed.", batchId)
+	}
+	if sl.running(batchId) {
+		return nil, newError(CodeConflict, nil, "\n[sl.resumeExecSchedule] batch [%s] is running.", batchId)
+	}
+
+	info, err := getExecScheduleLog(batchId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.resumeExecSchedule] %s", err.Error())
+	}
+	if info == nil {
+		return nil, newError(CodeNotFound, nil, "\n[sl.resumeExecSchedule] not found batch [%s] in execution log.", batchId)
+	}
+	if info.Status == RunSuccess {
+		return nil, newError(CodeConflict, nil, "\n[sl.resumeExecSchedule] batch [%s] is already completed.", batchId)
+	}
+
+	s := sl.GetScheduleById(info.ScheduleId)
+	if s == nil {
+		return nil, newError(CodeNotFound, nil, "\n[sl.resumeExecSchedule] not found schedule by id %d", info.ScheduleId)
+	}
+	if s, err = s.loadCopy(); err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.resumeExecSchedule] init schedule [%d] error %s.", info.ScheduleId, err.Error())
+	}
+
+	doneIds, err := getDoneTaskIds(batchId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.resumeExecSchedule] %s", err.Error())
+	}
+	attempts, err := getTaskAttemptNo(batchId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.resumeExecSchedule] %s", err.Error())
+	}
+
+	//沿用原批次ID，执行日志已存在，初始化时不再写入
+	es := ExecScheduleWarper(s)
+	es.batchId, es.log = batchId, s.logEntry().WithField(LogFieldRun, batchId)
+	es.execType, es.resume = 3, true
+	if err = es.InitExecSchedule(); err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.resumeExecSchedule] %s", err.Error())
+	}
+
+	//移除已完成的任务，恢复它们登记的产出物
+	for _, id := range doneIds {
+		et, ok := es.execTasks[id]
+		if !ok { //任务已从调度中删除
+			continue
+		}
+		artifacts, err := getTaskArtifacts(batchId, id)
+		if err != nil {
+			return nil, newError(CodeStore, err, "\n[sl.resumeExecSchedule] %s", err.Error())
+		}
+		et.state, et.artifacts = 3, make(map[string]string)
+		et.setStatus(TaskSuccess)
+		for _, a := range artifacts {
+			et.artifacts[a.Name] = a.Uri
+		}
+		es.addArtifacts(et)
+
+		for _, next := range et.nextExecTasks {
+			next.relDone(et)
+			delete(next.relExecTasks, id)
+		}
+		delete(es.execTasks, id)
+		delete(et.execJob.execTasks, id)
+		et.execJob.taskCnt--
+		es.taskCnt--
+		es.successTaskCnt++
+	}
+	if es.taskCnt == 0 {
+		return nil, newError(CodeConflict, nil, "\n[sl.resumeExecSchedule] batch [%s] has no task to resume.", batchId)
+	}
+
+	//重新执行的任务从原有的执行次数之后记录执行尝试
+	for _, et := range es.execTasks {
+		et.prevAttempts = attempts[et.task.Id]
+	}
+
+	//检查与加入执行列表之间不能有其它恢复请求加入同一批次
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	if _, ok := sl.ExecScheduleList[batchId]; ok {
+		return nil, newError(CodeConflict, nil, "\n[sl.resumeExecSchedule] batch [%s] is running.", batchId)
+	}
+	es.addTime = time.Now()
+	sl.ExecScheduleList[batchId] = es
+	sl.Global.metrics().ExecSchedules(len(sl.ExecScheduleList))
+
+	return es, nil
+} // }}}
+
+//running判断批次batchId是否在执行列表中
+func (sl *ScheduleManager) running(batchId string) bool { // {{{
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+	_, ok := sl.ExecScheduleList[batchId]
+	return ok
+} // }}}
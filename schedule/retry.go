@@ -0,0 +1,159 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//调度级重试。
+//
+//调度设置了ScheduleRetryCount时，批次失败（有任务失败或意外中止）后等待ScheduleRetryDelay秒，
+//以新的批次重新执行完整的调度链，最多重试ScheduleRetryCount次，任务自身的重试见Task.RetryCount。
+//重试的批次在日志库中记录重试序号与前一次执行的批次ID，通过GetFinalExecSchedule查询最后一次执行的结果。
+//
+//超时时间从首次执行开始计算，等待后会超过调度的TimeOut时不再重试，重试的批次只能使用剩余的时间。
+//等待结束时调度有其它批次在执行，按调度的Overlap策略处理：OverlapAllow直接重试，
+//OverlapQueue等待其结束后重试，其余情况放弃重试。
+//通过CancelRun中止的批次、补数执行的批次不重试。
+//重试的批次结束后不设置下次执行时间，由首次执行的批次设置；下游调度在最后一次执行结束后触发。
+//
+//重试在新的线程中等待和执行，失败批次的执行线程随即返回，调度的Timer照常计时。
+//等待期间停止监听（StopListener、Shutdown）时放弃重试，之后不再启动新的批次。
+
+//afterRun在批次结束后调用，需要重试时在新的线程中重新执行调度链，否则触发下游调度，见fireTriggers。
+//每次重试都在各自的线程中执行，多次重试不会嵌套在同一个调用栈中。
+func (es *ExecSchedule) afterRun() { // {{{
+	if !es.retryable() {
+		es.fireTriggers()
+		return
+	}
+	g.Schedules.retries.Add(1)
+	go func() {
+		defer g.Schedules.retries.Done()
+		if !es.retry() {
+			es.fireTriggers()
+		}
+	}()
+} // }}}
+
+//retryable判断批次结束后是否需要重新执行调度链
+func (es *ExecSchedule) retryable() bool { // {{{
+	s := es.schedule
+	es.lock.Lock()
+	failed := es.state == 4 || (es.state == 3 && es.failTaskCnt > 0)
+	stopped := es.stopped
+	es.lock.Unlock()
+
+	if !failed || stopped || es.execType == 4 || es.retryNo >= s.ScheduleRetryCount {
+		return false
+	}
+
+	delay := time.Duration(s.ScheduleRetryDelay) * time.Second
+	if s.TimeOut > 0 && !es.runStart().Add(time.Duration(s.TimeOut)*time.Second).After(time.Now().Add(delay)) {
+		es.log.Warningln(fmt.Sprintf("[es.retryable] schedule [%d %s] batchId=[%s] will run over timeout %ds, give up retry.",
+			s.Id, s.Name, es.batchId, s.TimeOut))
+		return false
+	}
+	return true
+} // }}}
+
+//retry等待ScheduleRetryDelay后以新的批次重新执行调度链，执行结束后才返回。
+//放弃重试时返回false。
+func (es *ExecSchedule) retry() bool { // {{{
+	s := es.schedule
+	if !es.waitRetry() {
+		return false
+	}
+	if err := g.Schedules.checkOpen(); err != nil {
+		es.log.Warningln(fmt.Sprintf("[es.retry] schedule [%d %s] batchId=[%s] give up retry %s", s.Id, s.Name, es.batchId, err.Error()))
+		return false
+	}
+
+	next := ExecScheduleWarper(es.origin)
+	next.execType, next.window = es.execType, es.window
+	next.retryNo, next.prevBatchId, next.firstStart = es.retryNo+1, es.batchId, es.runStart()
+	next.queued = true //首次执行的批次已设置下次执行时间
+	g.Schedules.AddExecSchedule(next)
+	if err := next.InitExecSchedule(); err != nil {
+		g.Schedules.RemoveExecSchedule(next.batchId)
+		es.log.Warningln(fmt.Sprintf("[es.retry] schedule [%d %s] batchId=[%s] give up retry %s", s.Id, s.Name, es.batchId, err.Error()))
+		return false
+	}
+
+	es.log.Infoln(fmt.Sprintf("[es.retry] schedule [%d %s] batchId=[%s] is retried batchId=[%s]", s.Id, s.Name, es.batchId, next.batchId))
+	next.publishEvent(EventScheduleFired, 0, next.state, "retry")
+	next.Run()
+	return true
+} // }}}
+
+//waitRetry等待ScheduleRetryDelay以及调度的其它批次，返回false时放弃重试。
+//等待计入监听的线程，停止监听时取消等待，StopListener、Shutdown等待它退出后返回。
+func (es *ExecSchedule) waitRetry() bool { // {{{
+	s := es.schedule
+	ctx, ok := g.Schedules.listener.enter()
+	if !ok {
+		es.log.Warningln(fmt.Sprintf("[es.waitRetry] schedule [%d %s] batchId=[%s] give up retry, listener is stopped.", s.Id, s.Name, es.batchId))
+		return false
+	}
+	defer g.Schedules.listener.exit()
+
+	delay := time.Duration(s.ScheduleRetryDelay) * time.Second
+	es.log.Infoln(fmt.Sprintf("[es.waitRetry] schedule [%d %s] batchId=[%s] is failed, retry %d/%d after %s.",
+		s.Id, s.Name, es.batchId, es.retryNo+1, s.ScheduleRetryCount, delay))
+	select {
+	case <-g.clock().After(delay):
+	case <-ctx.Done():
+		es.log.Warningln(fmt.Sprintf("[es.waitRetry] schedule [%d %s] batchId=[%s] give up retry, listener is stopped.", s.Id, s.Name, es.batchId))
+		return false
+	}
+	return es.waitOverlap(ctx)
+} // }}}
+
+//waitOverlap在重试前检查调度是否有其它批次在执行，按调度的Overlap策略处理，返回false时放弃重试。
+//ctx取消时放弃等待。
+func (es *ExecSchedule) waitOverlap(ctx context.Context) bool { // {{{
+	s := es.schedule
+	for {
+		prev := g.Schedules.runningBatch(s.Id)
+		if prev == nil || s.Overlap == OverlapAllow {
+			return true
+		}
+		if s.Overlap != OverlapQueue {
+			es.log.Warningln(fmt.Sprintf("[es.waitOverlap] schedule [%d %s] batchId=[%s] is still running, give up retry of batchId=[%s].",
+				s.Id, s.Name, prev.batchId, es.batchId))
+			return false
+		}
+
+		es.log.Infoln(fmt.Sprintf("[es.waitOverlap] retry of batchId=[%s] is waiting for batchId=[%s] to end.", es.batchId, prev.batchId))
+		select {
+		case <-prev.done:
+		case <-ctx.Done():
+			es.log.Warningln(fmt.Sprintf("[es.waitOverlap] retry of batchId=[%s] is given up, listener is stopped.", es.batchId))
+			return false
+		}
+	}
+} // }}}
+
+//runStart返回计算超时时间的起点，重试的批次为首次执行的开始时间，其余为本批次的开始时间
+func (es *ExecSchedule) runStart() time.Time { // {{{
+	if !es.firstStart.IsZero() {
+		return es.firstStart
+	}
+	return es.startTime
+} // }}}
+
+//GetFinalExecSchedule返回批次batchId所在的调度级重试中最后一次执行的执行进度，
+//没有重试时即为批次本身，可据此判断重试后调度链是否最终执行成功。
+//重试的关联关系从日志库读取，不记录日志（NoLog）时只能查询批次本身；
+//等待重试期间返回的是前一次失败的执行。批次不存在时返回error。
+func (sl *ScheduleManager) GetFinalExecSchedule(batchId string) (ExecScheduleInfo, error) { // {{{
+	info, err := sl.GetExecSchedule(batchId)
+	for err == nil && info.RetryBatchId != "" {
+		info, err = sl.GetExecSchedule(info.RetryBatchId)
+	}
+	if err != nil {
+		return ExecScheduleInfo{}, newError(CodeStore, err, "\n[sl.GetFinalExecSchedule] %s", err.Error())
+	}
+	return info, nil
+} // }}}
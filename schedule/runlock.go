@@ -0,0 +1,110 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+)
+
+//多实例部署时的启动抢占。
+//
+//多个调度进程监听同一元数据库时，同一调度在同一启动时间会在每个进程中各启动一次。
+//设置GlobalConfigStruct.RunLock后，Timer按周期启动、runOnStartup按错过的启动时间执行前
+//以调度Id与启动时间生成幂等键（见RunKey）并通过RunLock抢占，只有抢占成功的进程执行，
+//其它进程记录该启动已由其它进程执行后等待下一周期。抢占出错时不执行，避免重复执行。
+//按周期启动的启动时间为不含StartJitter的计划启动时间，精确到秒；
+//进程重启后因Misfire立即执行、补齐错过的启动时，启动时间为实际的启动时间，各进程不一定相同。
+//手动执行、补数执行、触发执行不抢占。
+
+//RunLock抢占一次调度启动，key为RunKey生成的幂等键，window为启动时间。
+//同一key只有一个调用方返回true，同一调用方重复抢占同一key时仍返回true。
+type RunLock interface {
+	Claim(key string, s *Schedule, window time.Time) (bool, error)
+}
+
+//RunKey返回调度s在启动时间window的幂等键，window按UTC精确到秒
+func RunKey(s *Schedule, window time.Time) string { // {{{
+	return fmt.Sprintf("%d@%s", s.Id, window.UTC().Format("20060102T150405Z"))
+} // }}}
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+//TableRunLock在元数据库的表Table中插入以幂等键为主键的记录抢占启动，插入成功的进程执行。
+//表结构见script中的scd_run_lock，表名可以不同，每次启动一条记录，需要时按create_time定期清理。
+type TableRunLock struct { // {{{
+	Table string //抢占记录的表名
+	Owner string //抢占方的标识，默认为主机名与进程号
+} // }}}
+
+//NewTableRunLock返回使用表table抢占启动的TableRunLock，表名不合法时返回error
+func NewTableRunLock(table string) (*TableRunLock, error) { // {{{
+	if !tableName.MatchString(table) {
+		e := fmt.Sprintf("\n[NewTableRunLock] invalid run lock table name [%s].", table)
+		return nil, errors.New(e)
+	}
+	host, _ := os.Hostname()
+	return &TableRunLock{Table: table, Owner: fmt.Sprintf("%s:%d", host, os.Getpid())}, nil
+} // }}}
+
+//Claim插入key对应的记录，主键冲突时按已有记录的抢占方判断是否为本进程
+func (tl *TableRunLock) Claim(key string, s *Schedule, window time.Time) (bool, error) { // {{{
+	ctx, cancel := dbContext(context.Background())
+	defer cancel()
+	sql := fmt.Sprintf(`INSERT INTO %s (run_key, scd_id, run_window, owner, create_time) VALUES (?, ?, ?, ?, ?)`, tl.Table)
+	_, err := execDB(ctx, g.HiveConn, sql, key, s.Id, window, tl.Owner, time.Now())
+	if err == nil {
+		return true, nil
+	}
+
+	owner, qerr := tl.owner(ctx, key)
+	if qerr != nil {
+		e := fmt.Sprintf("\n[tl.Claim] Exec sql [%s] error %s. %s", sql, err.Error(), qerr.Error())
+		return false, errors.New(e)
+	}
+	if owner == "" {
+		e := fmt.Sprintf("\n[tl.Claim] Exec sql [%s] error %s.", sql, err.Error())
+		return false, errors.New(e)
+	}
+	return owner == tl.Owner, nil
+} // }}}
+
+//owner返回抢占了key的进程，没有记录时返回空字符串
+func (tl *TableRunLock) owner(ctx context.Context, key string) (string, error) { // {{{
+	sql := fmt.Sprintf(`SELECT owner FROM %s WHERE run_key=?`, tl.Table)
+	rows, err := queryHive(ctx, sql, key)
+	if err != nil {
+		e := fmt.Sprintf("[tl.owner] Query sql [%s] error %s.\n", sql, err.Error())
+		return "", errors.New(e)
+	}
+	defer rows.Close()
+
+	var owner string
+	for rows.Next() {
+		if err = rows.Scan(&owner); err != nil {
+			e := fmt.Sprintf("[tl.owner] Scan sql [%s] error %s.\n", sql, err.Error())
+			return "", errors.New(e)
+		}
+	}
+	return owner, rows.Err()
+} // }}}
+
+//claimRun在设置了RunLock时抢占调度在启动时间window的执行，未设置时总是返回true。
+//其它进程已执行或抢占出错时记录日志并返回false。
+func (s *Schedule) claimRun(window time.Time) bool { // {{{
+	if g.RunLock == nil {
+		return true
+	}
+	key := RunKey(s, window)
+	ok, err := g.RunLock.Claim(key, s, window)
+	if err != nil {
+		s.logEntry().Warningln(fmt.Sprintf("[s.claimRun] schedule [%d %s] claim run [%s] error, skip it. %s", s.Id, s.Name, key, err.Error()))
+		return false
+	}
+	if !ok {
+		s.logEntry().Infoln(fmt.Sprintf("[s.claimRun] schedule [%d %s] run [%s] is claimed elsewhere, skip it.", s.Id, s.Name, key))
+	}
+	return ok
+} // }}}
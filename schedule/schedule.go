@@ -3,10 +3,17 @@
 package schedule
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
-	"errors"
+	"encoding/json"
 	"fmt"
 	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rprp/hivego/metrics"
+	"math/rand"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -17,14 +24,126 @@ var (
 
 //GlobalConfigStruct结构中定义了程序中的一些配置信息
 type GlobalConfigStruct struct { // {{{
-	L           *logrus.Logger   //log对象
-	HiveConn    *sql.DB          //元数据库链接
-	LogConn     *sql.DB          //日志数据库链接
-	ManagerPort string           //管理模块的web服务端口
-	Port        string           //Schedule与Worker模块通信端口
-	Schedules   *ScheduleManager //包含全部Schedule列表的结构
+	L                      *logrus.Logger          //log对象
+	HiveConn               *sql.DB                 //元数据库链接
+	MetaStore              MetaStore               //调度元数据的存储，为nil时使用HiveConn中的元数据库，见MetaStore
+	LogConn                *sql.DB                 //日志数据库链接
+	ManagerPort            string                  //管理模块的web服务端口
+	ApiAddr                string                  //REST接口的监听地址，如":3001"，为空时不启动，见api包
+	Port                   string                  //Schedule与Worker模块通信端口
+	Schedules              *ScheduleManager        //包含全部Schedule列表的结构
+	EmptyPolicy            string                  //空调度（调度下没有任何任务）的处理策略，取值见EmptySkip、EmptyRefuse
+	EventBuffer            int                     //事件订阅者通道的容量
+	EventOverflow          string                  //事件订阅者通道已满时的处理策略，取值见EventOverflowDrop、EventOverflowDropOldest、EventOverflowBuffer
+	LogAttempts            bool                    //是否将任务的每一次执行单独记录至日志库
+	PruneOnLoad            bool                    //LoadFromDir时是否删除定义文件已不存在的调度
+	UniqueNames            bool                    //是否要求调度名称唯一，为true时AddSchedule、UpdateSchedule拒绝与其它调度重名
+	Executor               Executor                //任务的执行者，默认通过RPC发送给Worker执行
+	TaskExecutors          map[string]TaskExecutor //在进程内执行任务的TaskExecutor，键为名称，见Task.ExecutorType，应在启动监听前设置
+	NoLog                  bool                    //不记录调度、作业、任务的执行日志，TestRun时使用
+	RetryJitter            string                  //任务重试等待时间的浮动策略，取值见JitterNone、JitterFull、JitterEqual
+	MaxTasksPerRun         int                     //每个批次最多包含的任务数量，超过时拒绝执行，小于等于0表示不限制
+	LoggerFactory          LoggerFactory           //按调度分流执行日志，为nil时全部调度使用L
+	MaxParallelJobs        int                     //同一并行组中同时执行的作业数量上限，小于等于0表示不限制
+	GroupFailPolicy        string                  //并行组中任务失败时的处理策略，取值见GroupFailContinue、GroupFailAbort
+	DisabledTaskPolicy     string                  //依赖禁用任务的下级任务的处理策略，取值见DisabledTaskPass、DisabledTaskSkip，见Task.Disabled
+	HealthTimeout          time.Duration           //检查Worker是否可用时的连接超时时间
+	WorkerDownPolicy       string                  //调度启动时Worker不可用的处理策略，取值见WorkerDownIgnore、WorkerDownSkip、WorkerDownDelay
+	WorkerDelay            time.Duration           //WorkerDownDelay策略下重新检查Worker的间隔
+	DBMaxRetry             int                     //元数据库查询遇到连接中断等临时错误时的重试次数，小于等于0表示不重试
+	DBBackoff              time.Duration           //元数据库查询首次重试前的等待时间，之后每次翻倍
+	DBTimeout              time.Duration           //元数据库、日志库单次操作的超时时间，小于等于0表示不限制
+	Registry               *prometheus.Registry    //记录调度执行指标的注册表，为nil时不记录，见metrics包
+	Clock                  Clock                   //调度计时使用的时钟，为nil时使用系统时间
+	Webhooks               []string                //批次结束时POST执行结果的地址列表，内容见WebhookPayload
+	WebhookRetry           int                     //调用Webhook失败时的重试次数，小于等于0表示不重试
+	WebhookBackoff         time.Duration           //调用Webhook首次重试前的等待时间，之后每次翻倍
+	ResourcePools          map[string]int          //资源池的名称与容量，限制使用同一资源的任务同时执行的数量，见Task.ResourcePool
+	WorkerSelector         WorkerSelector          //从Worker池中为任务选择Worker的策略，为nil时轮流选择
+	WorkerRecheck          time.Duration           //Worker被标记为不可用后不再分配任务的时间，之后重新参与分配
+	MisfireLimit           int                     //MisfireCatchUp策略下补齐错过的启动的最大次数，小于等于0表示不限制
+	MaxConcurrentSchedules int                     //同时执行的调度批次数量上限，小于等于0表示不限制，运行期间通过SetMaxConcurrentSchedules调整
+	ScheduleThrottle       string                  //同时执行的批次达到上限时的处理策略，取值见ThrottleWait、ThrottleDrop
+	TaskOutputLimit        int                     //保存至日志库的任务输出的最大字节数，超过时截断，小于等于0表示不限制
+	WorkerTLS              *tls.Config             //连接Worker时使用的TLS配置，见LoadWorkerTLS
+	WorkerToken            string                  //连接Worker时发送的认证信息，为空时不认证，需与Worker一致
+	WorkerInsecure         bool                    //未设置WorkerTLS时使用明文连接Worker，只用于本地测试
+	DispatchRetry          int                     //发送任务遇到暂时性错误时的重试次数，小于等于0表示不重试，与任务的RetryCount分别计算
+	DispatchBackoff        time.Duration           //发送任务首次重试前的等待时间，之后每次翻倍，按RetryJitter浮动
+	DispatchBackoffMax     time.Duration           //发送任务重试前等待时间的上限，小于等于0表示不限制
+	DispatchClassifier     DispatchClassifier      //判断发送任务的错误是否为暂时性错误，为nil时使用NewCodeClassifier()
+	ExecMaxAge             time.Duration           //批次在执行列表中的最长时间，超过后视为孤立的批次，中止并移除，小于等于0表示不检查，见reap
+	ReapInterval           time.Duration           //检查孤立批次的间隔，小于等于0时为1分钟
+	RunLock                RunLock                 //多实例部署时抢占调度启动，只有抢占成功的进程执行，为nil时不抢占，见NewTableRunLock
+	Elector                LeaderElector           //多实例部署时竞选主节点，只有主节点启动调度的监听，为nil时不选举，见NewTableLeaderElector
+	LeaderLease            time.Duration           //主节点租约的有效时间，每隔三分之一续约一次，小于等于0时为15秒
+	OnLeaderChange         func(leader bool)       //本实例成为或失去主节点时调用，leader为变化后的身份
+
+	metricsOnce sync.Once        //首次使用时在Registry中注册指标
+	collector   *metrics.Metrics //调度执行的指标，未设置Registry时为nil
 } // }}}
 
+//空调度的处理策略
+const (
+	EmptySkip   = "skip"   //记录警告，跳过本次执行，继续等待下一周期
+	EmptyRefuse = "refuse" //拒绝启动，停止该调度的监听
+)
+
+//调度启动时上一批次仍未结束的处理策略
+const (
+	OverlapSkip  = "skip"  //记录日志，跳过本次启动，继续等待下一周期
+	OverlapQueue = "queue" //等待上一批次结束后再启动
+	OverlapAllow = "allow" //不等待，与上一批次同时执行
+)
+
+//进程重启后发现停止期间错过了启动时间的处理策略
+const (
+	MisfireSkip = "skip" //记录警告，等待下一周期启动
+	MisfireRun  = "run"  //立即启动一次，之后恢复正常的周期
+	//立即启动，并逐次补齐错过的启动，最多MisfireLimit次，超出的部分跳过。
+	//只有按间隔调度时可以计算错过的次数，其余周期同MisfireRun
+	MisfireCatchUp = "catchup"
+)
+
+//进程启动后开始监听调度时的处理策略，见runOnStartup
+const (
+	StartupWait        = "wait"          //不立即执行，按周期等待启动，错过的启动按Misfire策略处理
+	StartupRunIfMissed = "run-if-missed" //停止期间错过了启动时，立即以错过的启动时间作为逻辑时间执行一次
+	StartupAlwaysRun   = "always-run"    //总是立即执行一次
+)
+
+//调度启动时Worker不可用的处理策略，见WorkerHealthCheck
+const (
+	WorkerDownIgnore = "ignore" //不检查Worker，直接启动
+	WorkerDownSkip   = "skip"   //记录警告，跳过本次启动，等待下一周期
+	WorkerDownDelay  = "delay"  //记录警告，推迟启动，每隔WorkerDelay重新检查直到Worker可用
+)
+
+//并行组中任务失败时的处理策略
+const (
+	GroupFailContinue = "continue" //只暂停依赖失败任务的下级任务，组内其它作业继续执行
+	GroupFailAbort    = "abort"    //组内尚未开始的任务全部暂停，不再执行
+)
+
+//依赖被禁用任务的下级任务的处理策略，禁用的任务本身总是记录为跳过（状态6）
+const (
+	DisabledTaskPass = "pass" //视同依赖的任务成功，下级任务照常执行
+	DisabledTaskSkip = "skip" //同执行条件不满足被跳过的任务，按下级任务的执行条件处理
+)
+
+//任务重试等待时间的浮动策略，浮动后的等待时间不会超过任务的RetryInterval
+const (
+	JitterNone  = "none"  //不浮动，按RetryInterval等待
+	JitterFull  = "full"  //在[0, RetryInterval]之间随机等待
+	JitterEqual = "equal" //在[RetryInterval/2, RetryInterval]之间随机等待
+)
+
+//任务截止时间所属的超时级别，见Schedule.TimeOut、Job.TimeOut
+const (
+	TimeoutSchedule = "schedule" //调度的超时时间
+	TimeoutJob      = "job"      //作业的超时时间
+)
+
 //返回GlobalConfigStruct的默认值。
 func DefaultGlobal() *GlobalConfigStruct { // {{{
 	sc := &GlobalConfigStruct{}
@@ -33,75 +152,461 @@ func DefaultGlobal() *GlobalConfigStruct { // {{{
 	sc.L.Level = logrus.Info
 	sc.Port = ":3128"
 	sc.ManagerPort = ":3000"
-	sc.Schedules = &ScheduleManager{Global: sc, ExecScheduleList: make(map[string]*ExecSchedule)}
+	sc.EmptyPolicy = EmptySkip
+	sc.EventBuffer = 100
+	sc.EventOverflow = EventOverflowDrop
+	sc.LogAttempts = true
+	sc.Executor = &rpcExecutor{}
+	sc.RetryJitter = JitterEqual
+	sc.MaxTasksPerRun = 10000
+	sc.GroupFailPolicy = GroupFailContinue
+	sc.DisabledTaskPolicy = DisabledTaskPass
+	sc.HealthTimeout = 3 * time.Second
+	sc.WorkerDownPolicy = WorkerDownIgnore
+	sc.WorkerDelay = time.Minute
+	sc.DBMaxRetry = 3
+	sc.DBBackoff = time.Second
+	sc.DBTimeout = 30 * time.Second
+	sc.DispatchRetry = 3
+	sc.DispatchBackoff = time.Second
+	sc.DispatchBackoffMax = 30 * time.Second
+	sc.DispatchClassifier = NewCodeClassifier()
+	sc.ReapInterval = time.Minute
+	sc.Clock = realClock{}
+	sc.WebhookRetry = 2
+	sc.WebhookBackoff = time.Second
+	sc.WorkerRecheck = 30 * time.Second
+	sc.MisfireLimit = 10
+	sc.ScheduleThrottle = ThrottleWait
+	sc.TaskOutputLimit = 4096
+	sc.Schedules = &ScheduleManager{Global: sc, ExecScheduleList: make(map[string]*ExecSchedule), events: newEventBus(), workers: newWorkerPool(), pools: newResourcePools(), listener: newListener(), runFailed: make(map[int64]bool), gate: newScheduleGate()}
 	return sc
 } // }}}
 
+//metrics返回调度执行的指标，首次调用时注册到Registry中。
+//未设置Registry时返回nil，记录指标的调用不做任何处理。
+func (sc *GlobalConfigStruct) metrics() *metrics.Metrics { // {{{
+	sc.metricsOnce.Do(func() {
+		sc.collector = metrics.New(sc.Registry)
+	})
+	return sc.collector
+} // }}}
+
 //ScheduleManager通过成员ScheduleList持有全部的Schedule。
 //并提供获取、增加、删除以及启动、停止Schedule的功能。
+//ScheduleList、ExecScheduleList与调度的Timer等线程并发读写，均需持有lock，
+//外部调用方应通过AllSchedules、GetScheduleById、ListSchedules读取。
 type ScheduleManager struct { // {{{
+	lock             sync.RWMutex             //保护ScheduleList、ExecScheduleList的增删
 	ScheduleList     []*Schedule              //全部的调度列表
 	ExecScheduleList map[string]*ExecSchedule //当前执行的调度列表
 	Global           *GlobalConfigStruct      //配置信息
+	events           *eventBus                //调度事件的订阅者
+	workers          *workerPool              //未指定执行地址的任务使用的Worker池
+	pools            *resourcePools           //任务使用的资源池
+	listener         *listener                //调度监听的运行状态
+	retries          sync.WaitGroup           //正在等待或执行的调度级重试，见afterRun
+	runFailed        map[int64]bool           //最近一个批次执行失败的调度
+	gate             *scheduleGate            //限制同时执行的调度批次数量
+	state            int32                    //运行状态，取值见stateRunning、stateStopping、stateClosed
+	reaping          int32                    //是否正在检查孤立的批次，见startReaper
+	names            sync.Mutex               //UniqueNames时串行执行名称检查与调度的增加、修改，见checkName
+	triggerLock      sync.Mutex               //串行执行触发关系的修改，使循环检查与修改不被其它调用穿插，见AddTrigger
+	electLock        sync.Mutex               //保护election
+	election         *election                //设置了Elector时的选举状态，未开始选举时为nil，见startElection
 } // }}}
 
-//初始化ScheduleList，设置全局变量g
-func (sl *ScheduleManager) InitScheduleList() { // {{{
+//初始化ScheduleList，设置全局变量g。
+//元数据库连接中断时按DBMaxRetry重试，仍失败时返回error，调度列表保持不变，可稍后再次调用。
+//调度最近一次的执行结果从日志库中最新的批次记录恢复，见restoreRunResults。
+func (sl *ScheduleManager) InitScheduleList() error { // {{{
 	g = sl.Global
+	if err := sl.checkOpen(); err != nil {
+		return err
+	}
 	//从元数据库读取调度信息,初始化调度列表
-	err := sl.getAllSchedules()
+	err := sl.getAllSchedules(context.Background())
 	if err != nil {
-		e := fmt.Sprintf("[sl.InitScheduleList] init scheduleList error %s.\n", err.Error())
-		g.L.Fatalln(e)
+		return newError(CodeStore, err, "\n[sl.InitScheduleList] init scheduleList error %s.", err.Error())
 	}
+	sl.restoreRunResults()
+	return nil
 } // }}}
 
-//增加一个调度执行结构
+//增加一个调度执行结构，记录加入的时间，见reap
 func (sl *ScheduleManager) AddExecSchedule(es *ExecSchedule) { // {{{
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	es.addTime = time.Now()
 	sl.ExecScheduleList[es.batchId] = es
+	sl.Global.metrics().ExecSchedules(len(sl.ExecScheduleList))
 	return
 } // }}}
 
 //移除一个调度执行结构
 func (sl *ScheduleManager) RemoveExecSchedule(batchId string) { // {{{
-	var lock sync.Mutex
-	lock.Lock()
-	defer lock.Unlock()
-	delete(sl.ExecScheduleList, batchId)
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	if es, ok := sl.ExecScheduleList[batchId]; ok {
+		delete(sl.ExecScheduleList, batchId)
+		sl.Global.metrics().ExecSchedules(len(sl.ExecScheduleList))
+		if es.done != nil {
+			close(es.done)
+		}
+	}
 } // }}}
 
-//开始监听Schedule，遍历列表中的Schedule并启动它的Timer方法。
-func (sl *ScheduleManager) StartListener() { // {{{
-	for _, scd := range sl.ScheduleList {
-		//从元数据库初始化调度链信息
+//RunScheduleNow立即手动执行一次指定的调度，返回本次执行的批次ID，可用于查询执行状态。
+//执行前从元数据库加载调度链的副本，正在监听的调度不被修改，执行日志的执行类型记录为2（手动人工调度）。
+//手动执行不影响调度的计时，NextStart保持不变，结束后也不会设置下次执行时间；
+//执行期间调度按时启动时，视为上一批次未结束，按调度的Overlap策略处理。
+//暂停、禁用的调度同样可以手动执行。
+func (sl *ScheduleManager) RunScheduleNow(id int64) (string, error) { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return "", err
+	}
+	s := sl.GetScheduleById(id)
+	if s == nil {
+		return "", newError(CodeNotFound, nil, "\n[sl.RunScheduleNow] not found schedule by id %d", id)
+	}
+
+	//从元数据库加载调度链信息
+	s, err := s.loadCopy()
+	if err != nil {
+		return "", newError(CodeStore, err, "\n[sl.RunScheduleNow] init schedule [%d] error %s.", id, err.Error())
+	}
+
+	//空调度执行时没有任务完成，无法正常结束
+	if s.isEmpty() {
+		return "", newError(CodeInvalid, nil, "\n[sl.RunScheduleNow] schedule [%d %s] has no task.", id, s.Name)
+	}
+
+	es := ExecScheduleWarper(s)
+	es.execType = 2
+	sl.AddExecSchedule(es)
+	if err := es.InitExecSchedule(); err != nil {
+		sl.RemoveExecSchedule(es.batchId)
+		return "", newError(CodeStore, err, "\n[sl.RunScheduleNow] %s", err.Error())
+	}
+
+	s.logEntry().Infoln(fmt.Sprintf("[sl.RunScheduleNow] schedule [%d %s] is manually started batchId=[%s]", id, s.Name, es.batchId))
+	es.publishEvent(EventScheduleFired, 0, es.state, "manual")
+	go es.Run()
+
+	return es.batchId, nil
+} // }}}
+
+//CancelRun中止正在执行的批次batchId，批次ID即RunScheduleNow返回的批次ID。
+//尚未开始的任务记录为已取消，正在执行的任务通知Worker中止，已结束的任务保持原有的执行结果，见ExecSchedule.stop。
+//中止由批次的执行线程异步完成，批次尚在等待开始时在开始后立即中止。批次不在执行中时返回error。
+func (sl *ScheduleManager) CancelRun(batchId string) error { // {{{
+	sl.lock.RLock()
+	es, ok := sl.ExecScheduleList[batchId]
+	sl.lock.RUnlock()
+	if !ok || es.stopRun == nil {
+		return newError(CodeNotFound, nil, "\n[sl.CancelRun] batch [%s] is not running.", batchId)
+	}
+
+	//重复的中止请求忽略
+	select {
+	case es.stopRun <- struct{}{}:
+		g.L.Infoln(fmt.Sprintf("[sl.CancelRun] batch [%s] is cancelling.", batchId))
+	default:
+	}
+	return nil
+} // }}}
+
+//ReloadSchedule从元数据库重新加载指定调度的调度链，用于直接修改元数据库后刷新内存中的调度。
+//新的调度链加载完成后才替换内存中的调度，加载失败时内存中的调度保持不变。
+//正在执行的批次使用初始化时的快照，不受影响，新的调度链从下次启动开始生效。
+//调度正在等待启动时，先停止原有的监听，替换后按新的调度周期、启动时间重新监听；
+//原来禁用的调度重新加载后启动监听；重新加载的调度已暂停或禁用时不再监听。
+func (sl *ScheduleManager) ReloadSchedule(id int64) error { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return err
+	}
+	s := sl.GetScheduleById(id)
+	if s == nil {
+		return newError(CodeNotFound, nil, "\n[sl.ReloadSchedule] not found schedule by id %d", id)
+	}
+
+	ns := &Schedule{Id: id}
+	if err := ns.InitSchedule(); err != nil {
+		return newError(CodeStore, err, "\n[sl.ReloadSchedule] %s", err.Error())
+	}
+
+	//调度正在等待启动时停止监听，避免替换期间监听读取调度信息
+	waiting := false
+	select {
+	case s.isRefresh <- true:
+		waiting = true
+	default:
+	}
+
+	//只替换调度的定义，监听使用的isRefresh、已计算的下次启动时间、暂缓时间和执行结果等运行状态保持不变
+	sl.lock.Lock()
+	enabled := s.Enabled
+	setScheduleDef(s, ns)
+	sl.lock.Unlock()
+	g.L.Infoln("[sl.ReloadSchedule] schedule", s.Id, s.Name, "is reloaded jobs=", s.JobCnt, "tasks=", s.TaskCnt)
+
+	//禁用的调度没有监听，重新启用时需启动监听
+	if (waiting || !enabled) && s.Status != 1 && s.Enabled {
+		go s.Timer()
+	}
+
+	return nil
+} // }}}
+
+//setScheduleDef将src中调度的定义（基本信息、启动时间、依赖与触发关系以及调度链）复制到dst，
+//不包含Id以及NextStart、SnoozeUntil、Remain、LastRun等运行状态，见ReloadSchedule
+func setScheduleDef(dst *Schedule, src *Schedule) { // {{{
+	setScheduleRow(dst, src)
+	dst.Tags, dst.Params, dst.savedEnabled = src.Tags, src.Params, src.savedEnabled
+	dst.StartSecond, dst.StartMonth, dst.StartWeekday = src.StartSecond, src.StartMonth, src.StartWeekday
+	dst.DependsOn, dst.Triggers = src.DependsOn, src.Triggers
+	dst.Job, dst.Jobs, dst.Tasks, dst.JobCnt, dst.TaskCnt = src.Job, src.Jobs, src.Tasks, src.JobCnt, src.TaskCnt
+} // }}}
+
+//runningBatch返回指定调度正在执行的一个批次，没有时返回nil
+func (sl *ScheduleManager) runningBatch(id int64) *ExecSchedule { // {{{
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+	for _, es := range sl.ExecScheduleList {
+		if es.schedule.Id == id {
+			return es
+		}
+	}
+	return nil
+} // }}}
+
+//开始监听Schedule，按调度间的依赖关系依次启动Schedule的Timer方法，
+//上游调度先于依赖它的调度启动。存在循环依赖的调度记录警告后最后启动。
+//初始化失败的调度记录警告后跳过，其余调度照常启动，全部失败信息汇总在*StartError中返回。
+//设置了Elector时只开始选举并返回nil，成为主节点时再启动监听，失败信息记录在日志中，见LeaderElector。
+func (sl *ScheduleManager) StartListener() error { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return err
+	}
+	if sl.Global.Elector != nil {
+		sl.startElection()
+		return nil
+	}
+	return sl.startListener()
+} // }}}
+
+//startListener启动全部调度的监听，见StartListener
+func (sl *ScheduleManager) startListener() error { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return err
+	}
+	sl.listener.reset()
+
+	scds, cyclic := sl.startOrder()
+	if len(cyclic) > 0 {
+		names := make([]string, 0)
+		for _, s := range cyclic {
+			names = append(names, fmt.Sprintf("[%d %s]", s.Id, s.Name))
+		}
+		e := fmt.Sprintf("[sl.StartListener] schedules %s have cyclic dependency, start them in list order.", strings.Join(names, " "))
+		g.L.Warningln(e)
+		scds = append(scds, cyclic...)
+	}
+
+	se := &StartError{}
+	for _, scd := range scds {
+		//从元数据库初始化调度链信息，初始化失败的调度不影响其它调度的启动
 		err := scd.InitSchedule()
 		if err != nil {
-			e := fmt.Sprintf("[sl.StartListener] init schedule [%d] error %s.\n", scd.Id, err.Error())
+			e := fmt.Sprintf("[sl.StartListener] init schedule [%d %s] error %s.\n", scd.Id, scd.Name, err.Error())
 			g.L.Warningln(e)
-			return
+			se.Errors = append(se.Errors, ScheduleError{Code: ErrorCodeOf(err), ScheduleId: scd.Id, ScheduleName: scd.Name, Err: err})
+			continue
+		}
+
+		//暂停的调度在恢复时再启动监听
+		if scd.Status == 1 {
+			g.L.Infoln("[sl.StartListener] schedule", scd.Id, scd.Name, "is paused")
+			continue
+		}
+
+		//禁用的调度只能手动执行，启用时再启动监听
+		if !scd.Enabled {
+			g.L.Infoln("[sl.StartListener] schedule", scd.Id, scd.Name, "is disabled")
+			continue
 		}
 
-		//启动监听，按时启动Schedule
+		//空调度按策略处理，拒绝启动的调度不启动监听
+		if _, err = scd.checkEmpty(); err != nil {
+			g.L.Warningln(fmt.Sprintf("[sl.StartListener] %s", err.Error()))
+			continue
+		}
+
+		//预热失败的调度不启动监听
+		if err = scd.warmup(); err != nil {
+			g.L.Warningln(fmt.Sprintf("[sl.StartListener] %s", err.Error()))
+			continue
+		}
+
+		//按OnStartupPolicy立即执行一次，再启动监听，按时启动Schedule
+		scd.runOnStartup()
 		go scd.Timer()
 	}
+	sl.startReaper()
+
+	if len(se.Errors) > 0 {
+		return se
+	}
+	return nil
+} // }}}
+
+//StartError汇总StartListener中初始化失败的调度
+type StartError struct { // {{{
+	Errors []ScheduleError //初始化失败的调度
+} // }}}
+
+func (se *StartError) Error() string { // {{{
+	msgs := make([]string, 0, len(se.Errors))
+	for _, e := range se.Errors {
+		msgs = append(msgs, e.Error())
+	}
+	return fmt.Sprintf("\n[sl.StartListener] %d schedules failed to start: %s.", len(se.Errors), strings.Join(msgs, "; "))
+} // }}}
+
+//startOrder按调度间的依赖关系对ScheduleList排序，上游调度排在依赖它的调度之前，
+//没有依赖关系的调度保持列表中的顺序。
+//依赖关系中不存在的调度会被忽略。无法排序的调度（处于循环依赖中或依赖了
+//循环中的调度）在cyclic中按列表顺序返回。
+func (sl *ScheduleManager) startOrder() (scds []*Schedule, cyclic []*Schedule) { // {{{
+	//每个调度尚未启动的上游调度数量，以及依赖它的下游调度
+	list := sl.AllSchedules()
+	wait := make(map[int64]int)
+	next := make(map[int64][]int64)
+	for _, s := range list {
+		for _, rid := range s.DependsOn {
+			if rid == s.Id || sl.GetScheduleById(rid) == nil {
+				continue
+			}
+			wait[s.Id]++
+			next[rid] = append(next[rid], s.Id)
+		}
+	}
+
+	scds = make([]*Schedule, 0)
+	started := make(map[int64]bool)
+	for len(scds) < len(list) {
+		found := false
+		for _, s := range list {
+			if started[s.Id] || wait[s.Id] > 0 {
+				continue
+			}
+			started[s.Id], found = true, true
+			scds = append(scds, s)
+			for _, nid := range next[s.Id] {
+				wait[nid]--
+			}
+		}
+		if !found {
+			break
+		}
+	}
+
+	cyclic = make([]*Schedule, 0)
+	for _, s := range list {
+		if !started[s.Id] {
+			cyclic = append(cyclic, s)
+		}
+	}
+
+	return scds, cyclic
+} // }}}
+
+//AddRelSchedule设置调度id依赖上游调度relId，并持久化到元数据库。
+//依赖关系会影响StartListener中调度的启动顺序，形成循环依赖时返回error信息。
+func (sl *ScheduleManager) AddRelSchedule(id int64, relId int64) error { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return err
+	}
+	s, rs := sl.GetScheduleById(id), sl.GetScheduleById(relId)
+	if s == nil || rs == nil {
+		return newError(CodeNotFound, nil, "\n[sl.AddRelSchedule] not found schedule by id %d or %d", id, relId)
+	}
+	if id == relId {
+		return newError(CodeInvalid, nil, "\n[sl.AddRelSchedule] schedule [%d] can not depend on itself.", id)
+	}
+	for _, rid := range s.DependsOn {
+		if rid == relId {
+			return nil
+		}
+	}
+
+	s.DependsOn = append(s.DependsOn, relId)
+	if _, cyclic := sl.startOrder(); len(cyclic) > 0 {
+		s.DependsOn = s.DependsOn[0 : len(s.DependsOn)-1]
+		return newError(CodeInvalid, nil, "\n[sl.AddRelSchedule] schedule [%d] depends on [%d] will cause cyclic dependency.", id, relId)
+	}
+
+	if err := g.store().AddRelSchedule(s, relId); err != nil {
+		s.DependsOn = s.DependsOn[0 : len(s.DependsOn)-1]
+		return newError(CodeStore, err, "\n[sl.AddRelSchedule] %s", err.Error())
+	}
+
+	return nil
+} // }}}
+
+//DeleteRelSchedule删除调度id对上游调度relId的依赖。
+func (sl *ScheduleManager) DeleteRelSchedule(id int64, relId int64) error { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return err
+	}
+	s := sl.GetScheduleById(id)
+	if s == nil {
+		return newError(CodeNotFound, nil, "\n[sl.DeleteRelSchedule] not found schedule by id %d", id)
+	}
+
+	for i, rid := range s.DependsOn {
+		if rid == relId {
+			s.DependsOn = append(s.DependsOn[0:i], s.DependsOn[i+1:]...)
+			break
+		}
+	}
 
+	if err := g.store().DeleteRelSchedule(s, relId); err != nil {
+		return newError(CodeStore, err, "\n[sl.DeleteRelSchedule] %s", err.Error())
+	}
+
+	return nil
 } // }}}
 
 //启动指定的Schedule，从ScheduleList中获取到指定id的Schedule后，从元数据库获取
 //Schedule的信息初始化一下调度链，然后调用它自身的Timer方法，启动监听。
 //失败返回error信息。
 func (sl *ScheduleManager) StartScheduleById(id int64) error { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return err
+	}
 	s := sl.GetScheduleById(id)
 	if s == nil {
-		e := fmt.Sprintf("\n[sl.StartScheduleById] start schedule. not found schedule by id %d", id)
-		return errors.New(e)
+		return newError(CodeNotFound, nil, "\n[sl.StartScheduleById] start schedule. not found schedule by id %d", id)
 	}
 
 	//从元数据库初始化调度链信息
 	err := s.InitSchedule()
 	if err != nil {
-		e := fmt.Sprintf("\n[sl.StartScheduleById] init schedule [%d] error %s.", id, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[sl.StartScheduleById] init schedule [%d] error %s.", id, err.Error())
+	}
+
+	if s.Status == 1 {
+		return newError(CodeConflict, nil, "\n[sl.StartScheduleById] schedule [%d %s] is paused, resume it instead.", id, s.Name)
+	}
+
+	//空调度按策略处理
+	if _, err = s.checkEmpty(); err != nil {
+		return newError(CodeInvalid, err, "\n[sl.StartScheduleById] %s", err.Error())
+	}
+
+	if err = s.warmup(); err != nil {
+		return newError(CodeConflict, err, "\n[sl.StartScheduleById] %s", err.Error())
 	}
 
 	//启动监听，按时启动Schedule
@@ -110,9 +615,67 @@ func (sl *ScheduleManager) StartScheduleById(id int64) error { // {{{
 	return nil
 } // }}}
 
+//PauseScheduleById暂停指定的调度，停止监听但保留调度的作业、任务等信息。
+//暂停状态会持久化到元数据库，重启后暂停的调度不会启动监听。
+//调度正在执行时，本批次继续执行至结束，结束后不再设置下次执行时间。
+func (sl *ScheduleManager) PauseScheduleById(id int64) error { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return err
+	}
+	s := sl.GetScheduleById(id)
+	if s == nil {
+		return newError(CodeNotFound, nil, "\n[sl.PauseScheduleById] not found schedule by id %d", id)
+	}
+	if s.Status == 1 {
+		return nil
+	}
+
+	s.Status = 1
+	if err := g.store().SaveScheduleStatus(s); err != nil {
+		s.Status = 0
+		return newError(CodeStore, err, "\n[sl.PauseScheduleById] %s", err.Error())
+	}
+	g.L.Infoln("[sl.PauseScheduleById] schedule", s.Id, s.Name, "is paused")
+
+	//调度正在等待启动时，停止监听
+	select {
+	case s.isRefresh <- true:
+	default:
+	}
+
+	return nil
+} // }}}
+
+//ResumeScheduleById恢复暂停的调度，按调度周期计算下次启动时间后重新开始监听，
+//暂停期间错过的批次不会补充执行。
+func (sl *ScheduleManager) ResumeScheduleById(id int64) error { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return err
+	}
+	s := sl.GetScheduleById(id)
+	if s == nil {
+		return newError(CodeNotFound, nil, "\n[sl.ResumeScheduleById] not found schedule by id %d", id)
+	}
+	if s.Status != 1 {
+		return nil
+	}
+
+	s.Status = 0
+	if err := g.store().SaveScheduleStatus(s); err != nil {
+		s.Status = 1
+		return newError(CodeStore, err, "\n[sl.ResumeScheduleById] %s", err.Error())
+	}
+	g.L.Infoln("[sl.ResumeScheduleById] schedule", s.Id, s.Name, "is resumed")
+
+	go s.Timer()
+	return nil
+} // }}}
+
 //查找当前ScheduleList列表中指定id的Schedule，并返回。
 //查不到返回nil
 func (sl *ScheduleManager) GetScheduleById(id int64) *Schedule { // {{{
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
 	for _, s := range sl.ScheduleList {
 		if s.Id == id {
 			return s
@@ -121,131 +684,630 @@ func (sl *ScheduleManager) GetScheduleById(id int64) *Schedule { // {{{
 	return nil
 } // }}}
 
-//增加Schedule，将参数中的Schedule加入的列表中，并调用其Add方法持久化。
-func (sl *ScheduleManager) AddSchedule(s *Schedule) error { // {{{
+//GetScheduleByName查找当前ScheduleList列表中指定名称的Schedule，名称区分大小写。
+//未设置UniqueNames时名称可能重复，返回其中ID最小的调度，需要全部结果时使用GetSchedulesByName。
+//查不到返回nil
+func (sl *ScheduleManager) GetScheduleByName(name string) *Schedule { // {{{
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	var scd *Schedule
+	for _, s := range sl.ScheduleList {
+		if s.Name == name && (scd == nil || s.Id < scd.Id) {
+			scd = s
+		}
+	}
+	return scd
+} // }}}
+
+//GetSchedulesByName返回当前ScheduleList列表中指定名称的全部Schedule，按调度ID排序，没有时返回空列表。
+func (sl *ScheduleManager) GetSchedulesByName(name string) []*Schedule { // {{{
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	scds := make([]*Schedule, 0)
+	for _, s := range sl.ScheduleList {
+		if s.Name == name {
+			scds = append(scds, s)
+		}
+	}
+	sort.Sort(scheduleById(scds))
+	return scds
+} // }}}
+
+//checkName检查ScheduleList中是否有其它调度与s重名，重名时返回*ValidationError。
+//调用方需持有names，使检查与之后的增加、修改不被其它调用穿插。
+func (sl *ScheduleManager) checkName(s *Schedule) error { // {{{
+	for _, ss := range sl.GetSchedulesByName(s.Name) {
+		if ss != s && ss.Id != s.Id {
+			msg := fmt.Sprintf("is used by schedule [%d]", ss.Id)
+			return &ValidationError{ScheduleId: s.Id, ScheduleName: s.Name, Errors: []FieldError{{Field: "Name", Message: msg}}}
+		}
+	}
+	return nil
+} // }}}
+
+//FindSchedulesUsingJob返回调度链中包含指定作业的调度，用于修改、删除作业前的影响分析。
+//查询基于元数据库中的调度链，与调度是否已初始化无关。
+//作业不在多个调度间共享时，返回的只有作业所属的调度；没有调度使用时返回空列表。
+func (sl *ScheduleManager) FindSchedulesUsingJob(jobId int64) ([]*Schedule, error) { // {{{
+	scds, err := sl.findSchedulesUsingJobs([]int64{jobId})
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.FindSchedulesUsingJob] %s", err.Error())
+	}
+	return scds, nil
+} // }}}
+
+//FindSchedulesUsingTask返回包含指定任务的调度，任务可以通过多个作业被不同的调度使用。
+//任务不在多个调度间共享时，返回的只有任务所属的调度；没有调度使用时返回空列表。
+func (sl *ScheduleManager) FindSchedulesUsingTask(taskId int64) ([]*Schedule, error) { // {{{
+	jobsId, err := g.store().GetTaskJobIds(taskId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.FindSchedulesUsingTask] %s", err.Error())
+	}
+
+	scds, err := sl.findSchedulesUsingJobs(jobsId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.FindSchedulesUsingTask] %s", err.Error())
+	}
+	return scds, nil
+} // }}}
+
+//findSchedulesUsingJobs沿着每个调度的调度链查找，返回调度链中包含jobsId中任一作业的调度。
+func (sl *ScheduleManager) findSchedulesUsingJobs(jobsId []int64) ([]*Schedule, error) { // {{{
+	scds := make([]*Schedule, 0)
+	if len(jobsId) == 0 {
+		return scds, nil
+	}
+
+	next, err := g.store().GetJobNext()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make(map[int64]bool)
+	for _, id := range jobsId {
+		jobs[id] = true
+	}
+
+	for _, s := range sl.AllSchedules() {
+		visited := make(map[int64]bool)
+		for id := s.JobId; id != 0 && !visited[id]; id = next[id] {
+			if jobs[id] {
+				scds = append(scds, s)
+				break
+			}
+			visited[id] = true
+		}
+	}
+
+	return scds, nil
+} // }}}
+
+//增加Schedule，将参数中的Schedule加入的列表中，并调用其Add方法持久化，成功时返回新调度的Id。
+//设置了启动列表时一并持久化。
+//StartListener已运行且监听未停止时，设置了周期、未暂停且已启用的调度立即开始计时。
+//Enabled的零值为禁用，需要自动启动的调度应设置Enabled。
+//设置了UniqueNames时，与已有调度重名返回*ValidationError。
+func (sl *ScheduleManager) AddSchedule(s *Schedule) (int64, error) { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return 0, err
+	}
+	if sl.Global.UniqueNames {
+		sl.names.Lock()
+		defer sl.names.Unlock()
+		if err := sl.checkName(s); err != nil {
+			return 0, err
+		}
+	}
 	err := s.Add()
 	if err != nil {
-		e := fmt.Sprintf("\n[sl.AddSchedule] %s.", err.Error())
-		return errors.New(e)
+		return 0, newError(CodeStore, err, "\n[sl.AddSchedule] %s.", err.Error())
 	}
+	if len(s.StartSecond) > 0 || len(s.StartWeekday) > 0 {
+		if err = s.AddScheduleStart(); err != nil {
+			return 0, newError(CodeStore, err, "\n[sl.AddSchedule] %s.", err.Error())
+		}
+	}
+	if s.isRefresh == nil {
+		s.isRefresh = make(chan bool)
+	}
+	sl.lock.Lock()
 	sl.ScheduleList = append(sl.ScheduleList, s)
+	sl.lock.Unlock()
 
-	return nil
+	if sl.listener.running() && s.Cyc != "" && s.Status != 1 && s.Enabled {
+		go s.Timer()
+	}
+	return s.Id, nil
 } // }}}
 
 //从当前ScheduleList列表中移除指定id的Schedule。
 //完成后，调用Schedule自身的Delete方法，删除其中的Job、Task信息并做持久化操作。
 //失败返回error信息
 func (sl *ScheduleManager) DeleteSchedule(id int64) error { // {{{
-	i := -1
-	for k, ss := range sl.ScheduleList {
-		if ss.Id == id {
-			i = k
+	if err := sl.checkOpen(); err != nil {
+		return err
+	}
+	s := sl.removeSchedule(id)
+	if s == nil {
+		return newError(CodeNotFound, nil, "\n[sl.DeleteSchedule] delete error. not found schedule by id %d", id)
+	}
+
+	err := s.Delete()
+	if err != nil {
+		return newError(CodeStore, err, "\n[sl.DeleteSchedule] delete schedule [%d %s] error. %s", id, s.Name, err.Error())
+	}
+
+	return nil
+} // }}}
+
+//removeSchedule从ScheduleList中移除指定id的Schedule并返回，不存在时返回nil。
+//其它调度对它的触发关系一并移除。
+//移除期间持有写锁，其它读写调度列表的操作等待移除完成。
+func (sl *ScheduleManager) removeSchedule(id int64) *Schedule { // {{{
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	for i, s := range sl.ScheduleList {
+		if s.Id == id {
+			list := make([]*Schedule, 0, len(sl.ScheduleList)-1)
+			list = append(list, sl.ScheduleList[:i]...)
+			sl.ScheduleList = append(list, sl.ScheduleList[i+1:]...)
+			delete(sl.runFailed, id)
+			for _, ss := range sl.ScheduleList {
+				triggers := make([]Trigger, 0, len(ss.Triggers))
+				for _, t := range ss.Triggers {
+					if t.ScheduleId != id {
+						triggers = append(triggers, t)
+					}
+				}
+				ss.Triggers = triggers
+			}
+			return s
 		}
 	}
+	return nil
+} // }}}
 
-	if i == -1 {
-		e := fmt.Sprintf("\n[sl.DeleteSchedule] delete error. not found schedule by id %d", id)
-		return errors.New(e)
+//AllSchedules返回当前调度列表的副本，调用方可以安全地遍历，
+//不受调度增加、删除的影响。列表中的Schedule与ScheduleList中的为同一对象。
+func (sl *ScheduleManager) AllSchedules() []*Schedule { // {{{
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	scds := make([]*Schedule, len(sl.ScheduleList))
+	copy(scds, sl.ScheduleList)
+	return scds
+} // }}}
+
+//ListByTag返回带有指定标签的调度，按调度ID排序，标签区分大小写，没有时返回空列表。
+//标签随调度一起加载在内存中，筛选不访问元数据库；元数据库中scd_schedule_tag表按标签建有索引，
+//供外部直接按标签查询。
+func (sl *ScheduleManager) ListByTag(tag string) []*Schedule { // {{{
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	scds := make([]*Schedule, 0)
+	for _, s := range sl.ScheduleList {
+		if s.hasTag(tag) {
+			scds = append(scds, s)
+		}
 	}
+	sort.Sort(scheduleById(scds))
+	return scds
+} // }}}
 
-	s := sl.ScheduleList[i]
-	sl.ScheduleList = append(sl.ScheduleList[0:i], sl.ScheduleList[i+1:]...)
+//Snooze将指定调度的下次启动时间推迟d，调度的周期和启动时间不变。
+//推迟期间原本应启动的批次不再执行，到达推迟后的时间启动一次，之后恢复正常的周期。
+//已暂缓的调度再次调用时在原暂缓时间上继续推迟。暂缓时间会持久化到元数据库，
+//推迟期间重启仍然有效。
+//调度的Timer只在上一批次执行结束后才重新计时，因此暂缓不会造成批次的重叠；
+//调度正在执行时，本批次不受影响，结束后的下一次启动按暂缓时间推迟。
+func (sl *ScheduleManager) Snooze(id int64, d time.Duration) error { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return err
+	}
+	s := sl.GetScheduleById(id)
+	if s == nil {
+		return newError(CodeNotFound, nil, "\n[sl.Snooze] not found schedule by id %d", id)
+	}
+	if d <= 0 {
+		return newError(CodeInvalid, nil, "\n[sl.Snooze] schedule [%d %s] snooze duration %s must be positive.", id, s.Name, d)
+	}
 
-	err := s.Delete()
+	//在下次启动时间的基础上推迟，未在等待启动（未启动监听或正在执行）时重新计算下次启动时间。
+	//NextStart、SnoozeUntil同时被Timer读写，持有调度列表的锁完成读取、修改和保存，
+	//保证多次暂缓按顺序累加并以最后的结果持久化
+	sl.lock.Lock()
+	base := s.NextStart
+	if s.SnoozeUntil.After(base) {
+		base = s.SnoozeUntil
+	}
+	if !base.After(GetNow()) {
+		countDown, err := s.countDown()
+		if err != nil {
+			sl.lock.Unlock()
+			return newError(CodeInvalid, err, "\n[sl.Snooze] get schedule [%d %s] start time error %s.", id, s.Name, err.Error())
+		}
+		base = GetNow().Add(countDown)
+	}
+	s.SnoozeUntil = base.Add(d)
+	until := s.SnoozeUntil
+	err := g.store().SaveScheduleSnooze(s)
+	sl.lock.Unlock()
 	if err != nil {
-		e := fmt.Sprintf("\n[sl.DeleteSchedule] delete schedule [%d %s] error. %s", id, s.Name, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[sl.Snooze] %s", err.Error())
+	}
+	g.L.Infoln("[sl.Snooze] schedule", s.Id, s.Name, "is snoozed until", until)
+
+	//调度正在等待启动时，停止原有的监听并按暂缓时间重新监听
+	select {
+	case s.isRefresh <- true:
+		go s.Timer()
+	default:
 	}
 
 	return nil
 } // }}}
 
+//SetTaskDisabled禁用或重新启用任务taskId，不需要删除、重建任务，设置会持久化到元数据库。
+//禁用的任务在之后开始的批次中不发送执行，记录为跳过（状态6），
+//依赖它的任务按GlobalConfigStruct.DisabledTaskPolicy处理；正在执行的批次不受影响。
+//任务不在已加载的调度中时返回error。
+func (sl *ScheduleManager) SetTaskDisabled(taskId int64, disabled bool) error { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return err
+	}
+
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	tasks := make([]*Task, 0)
+	for _, s := range sl.ScheduleList {
+		if t := s.GetTaskById(taskId); t != nil {
+			tasks = append(tasks, t)
+		}
+	}
+	if len(tasks) == 0 {
+		return newError(CodeNotFound, nil, "\n[sl.SetTaskDisabled] not found task by id %d", taskId)
+	}
+
+	t := *tasks[0]
+	t.Disabled = disabled
+	if err := g.store().SaveTaskDisabled(&t); err != nil {
+		return newError(CodeStore, err, "\n[sl.SetTaskDisabled] %s", err.Error())
+	}
+	for _, t := range tasks {
+		t.Disabled = disabled
+	}
+	g.L.Infoln("[sl.SetTaskDisabled] task", taskId, t.Name, "disabled =", disabled)
+
+	return nil
+} // }}}
+
 //调度信息结构
 type Schedule struct { // {{{
-	Id           int64           //调度ID
-	Name         string          //调度名称
-	Count        int8            //调度次数
-	Cyc          string          //调度周期
-	StartSecond  []time.Duration //启动时间
-	StartMonth   []int           //启动月份
-	NextStart    time.Time       //下次启动时间
-	TimeOut      int64           //最大执行时间
-	JobId        int64           //作业ID
-	Job          *Job            //作业
-	Jobs         []*Job          //作业列表
-	Tasks        []*Task         `json:"-"` //任务列表
-	isRefresh    chan bool       `json:"-"` //是否刷新标志
-	Desc         string          //调度说明
-	JobCnt       int             //调度中作业数量
-	TaskCnt      int             //调度中任务数量
-	CreateUserId int64           //创建人
-	CreateTime   time.Time       //创人
-	ModifyUserId int64           //修改人
-	ModifyTime   time.Time       //修改时间
+	Id                 int64             //调度ID
+	Name               string            //调度名称
+	Group              string            //调度分组，用于按业务归类调度
+	Tags               []string          //调度标签，用于按团队、业务线等筛选调度，一个调度可以有多个标签，见ListByTag
+	Params             map[string]string //调度的运行参数，与任务的Params合并后发送给Worker，同名时以任务的为准，见ParamData
+	Status             int8              //调度状态 0.正常 1.暂停
+	Enabled            bool              //是否启用，禁用的调度不自动启动，只能通过RunScheduleNow手动执行，见Timer
+	Count              int8              //调度次数，小于等于0表示不限次数
+	Remain             int               //剩余的调度次数，Count大于0时有效，用完后不再启动，见Timer
+	Cyc                string            //调度周期，以CronPrefix开头时按cron表达式调度，以IntervalPrefix开头时按固定间隔调度
+	StartSecond        []time.Duration   //启动时间
+	StartMonth         []int             //启动月份
+	StartWeekday       []time.Weekday    //按周调度时的启动星期，设置后StartSecond为当日的启动时间，见starts
+	StartJitter        time.Duration     //启动时间后随机推迟的最长时间，避免同一时刻启动的调度同时执行，0表示不推迟，见startJitter
+	ScheduleRetryCount int               //批次失败后重新执行整个调度链的次数，0表示不重试，见ExecSchedule.retry
+	ScheduleRetryDelay int64             //重新执行整个调度链前的等待时间，单位秒
+	OnStartupPolicy    string            //进程启动后开始监听时是否立即执行一次，取值见StartupWait、StartupRunIfMissed、StartupAlwaysRun，为空时同StartupWait
+	Priority           int               //优先级，同时执行的批次达到MaxConcurrentSchedules时数值大的优先开始，相同时先启动的优先，默认为0
+	NextStart          time.Time         //下次启动时间
+	SnoozeUntil        time.Time         //暂缓执行至该时间，零值表示未暂缓
+	LastRunTime        time.Time         //最近一个批次的开始时间，零值表示没有执行记录，LastRun各字段在批次结束时持有调度列表的锁更新
+	LastRunStatus      RunStatus         //最近一个批次的执行结果，取值为RunSuccess或RunFailed
+	LastRunDuration    time.Duration     //最近一个批次的执行时长
+	LastError          string            //最近一个批次失败的原因，执行成功时为空
+	DependsOn          []int64           //依赖的上游调度Id
+	Triggers           []Trigger         //批次结束后触发执行的下游调度，见AddTrigger
+	TimeOut            int64             //最大执行时间，单位秒，超过后中止本次执行，0表示不限制
+	SoftTimeOut        int64             //预警执行时间，单位秒，超过后发出预警，0表示不预警
+	Overlap            string            //启动时上一批次仍未结束的处理策略，取值见OverlapSkip、OverlapQueue、OverlapAllow，为空时同OverlapSkip
+	Misfire            string            //进程重启后错过启动时间的处理策略，取值见MisfireSkip、MisfireRun、MisfireCatchUp，为空时同MisfireSkip
+	TimeZone           string            //启动时间所在的时区，IANA名称如Asia/Shanghai，为空时使用服务器的时区，见getCountDown
+	JobId              int64             //作业ID
+	WarmupTaskId       int64             //预热任务ID，0表示不需要预热
+	Job                *Job              //作业
+	Jobs               []*Job            //作业列表
+	Tasks              []*Task           `json:"-"` //任务列表
+	isRefresh          chan bool         `json:"-"` //是否刷新标志
+	restored           bool              //NextStart是从元数据库读取的，Timer首次计算启动时间时按它恢复
+	savedEnabled       bool              //元数据库中保存的Enabled，UpdateSchedule据此判断是否需要启动或停止监听
+	catchUp            int               //MisfireCatchUp策略下尚未补齐的启动次数
+	Desc               string            //调度说明
+	JobCnt             int               //调度中作业数量
+	TaskCnt            int               //调度中任务数量
+	CreateUserId       int64             //创建人
+	CreateTime         time.Time         //创人
+	ModifyUserId       int64             //修改人
+	ModifyTime         time.Time         //修改时间
+} // }}}
+
+//UnmarshalJSON解析调度信息，JSON中没有Enabled时按启用处理，
+//避免通过接口新增或修改调度时因缺少该字段而禁用调度。
+func (s *Schedule) UnmarshalJSON(b []byte) error { // {{{
+	type schedule Schedule
+	s.Enabled = true
+	return json.Unmarshal(b, (*schedule)(s))
+} // }}}
+
+//exhausted判断有限次数的调度是否已用完剩余次数
+func (s *Schedule) exhausted() bool { // {{{
+	return s.Count > 0 && s.Remain <= 0
+} // }}}
+
+//location返回调度启动时间所在的时区，TimeZone为空时为服务器的时区。
+func (s *Schedule) location() (*time.Location, error) { // {{{
+	if s.TimeZone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return nil, newError(CodeInvalid, err, "\n[s.location] schedule [%d %s] load time zone [%s] error %s.", s.Id, s.Name, s.TimeZone, err.Error())
+	}
+	return loc, nil
 } // }}}
 
-//按时启动Schedule，Timer中会根据Schedule的周期以及启动时间计算下次
-//启动的时间，并依据此设置一个定时器按时唤醒，Schedule唤醒后，会重新
-//从元数据库初始化一下信息，生成执行结构ExecSchedule，执行其Run方法
-func (s *Schedule) Timer() { // {{{
+//countDown按调度所在的时区获取距下次启动的时间。
+func (s *Schedule) countDown() (time.Duration, error) { // {{{
+	loc, err := s.location()
+	if err != nil {
+		return 0, err
+	}
+	sm, ss := s.starts()
+	return getCountDown(s.Cyc, sm, ss, loc, s.intervalAnchor(loc))
+} // }}}
+
+//startJitter返回启动时间start之后随机推迟的时长，取值0至StartJitter。
+//使用系统时间时随机生成；GlobalConfigStruct.Clock替换为其它时钟时按调度Id与start生成，
+//相同的输入得到相同的推迟时长，测试可以重现。
+func (s *Schedule) startJitter(start time.Time) time.Duration { // {{{
+	if s.StartJitter <= 0 {
+		return 0
+	}
+	n := int64(s.StartJitter) + 1
+	if _, ok := g.clock().(realClock); ok {
+		return time.Duration(rand.Int63n(n))
+	}
+	return time.Duration(rand.New(rand.NewSource(s.Id<<32 ^ start.UnixNano())).Int63n(n))
+} // }}}
+
+//按时启动Schedule，Timer中会根据Schedule的周期以及启动时间计算下次
+//启动的时间，并依据此设置一个定时器按时唤醒，Schedule唤醒后，会重新
+//从元数据库初始化一下信息，生成执行结构ExecSchedule，执行其Run方法
+func (s *Schedule) Timer() { // {{{
+	//监听已停止时不再等待启动
+	ctx, ok := g.Schedules.listener.enter()
+	if !ok {
+		return
+	}
+	defer g.Schedules.listener.exit()
+	log := s.logEntry()
+
+	if s.Status == 1 {
+		log.Infoln(fmt.Sprintf("[s.Timer] Schedule [%d %s] is paused.", s.Id, s.Name))
+		return
+	}
+
+	if !s.Enabled {
+		log.Infoln(fmt.Sprintf("[s.Timer] Schedule [%d %s] is disabled.", s.Id, s.Name))
+		return
+	}
+
 	if s.Cyc == "" {
 		e := fmt.Sprintf("[s.Timer] Schedule [%s] Cyc is not set!", s.Name)
-		g.L.Warningln(e)
+		log.Warningln(e)
 		return
 	}
 
-	//获取距启动的时间（秒）
-	countDown, err := getCountDown(s.Cyc, s.StartMonth, s.StartSecond)
+	if s.exhausted() {
+		log.Infoln(fmt.Sprintf("[s.Timer] Schedule [%d %s] has run %d times, stop scheduling.", s.Id, s.Name, s.Count))
+		return
+	}
+
+	//获取距启动的时间（秒），无法计算时不再启动，记录错误并计入指标
+	countDown, err := s.countDown()
 	if err != nil {
 		e := fmt.Sprintf("[s.Timer] get schedule [%d %s] start time error %s.\n", s.Id, s.Name, err.Error())
-		g.L.Warningln(e)
+		log.Errorln(e)
+		g.metrics().TimerError(s.Id)
 		return
 	}
 
-	s.NextStart = time.Now().Add(countDown)
+	next := GetNow().Add(countDown)
+	window := next.Round(time.Second) //抢占启动使用的计划启动时间，见claimRun
+	if d := s.startJitter(next); d > 0 {
+		next, countDown = next.Add(d), countDown+d
+	}
+
+	//进程重启后按之前保存的启动时间恢复
+	if s.restored {
+		s.restored = false
+		next, countDown = s.misfire(next, countDown)
+		window = next.Round(time.Second)
+	} else if s.catchUp > 0 {
+		//补齐进程停止期间错过的启动，见misfire
+		s.catchUp--
+		next, countDown = GetNow(), 0
+		window = next.Round(time.Second)
+	}
+
+	//暂缓期间的启动推迟到暂缓结束时，暂缓结束后恢复正常的周期。
+	//SnoozeUntil可能被Snooze同时修改，与NextStart一起在调度列表的锁内读取、修改和保存
+	g.Schedules.lock.Lock()
+	if !s.SnoozeUntil.IsZero() {
+		if s.SnoozeUntil.After(GetNow()) {
+			if next.Before(s.SnoozeUntil) {
+				next = s.SnoozeUntil
+				countDown = s.SnoozeUntil.Sub(GetNow())
+			}
+		} else {
+			s.SnoozeUntil = time.Time{}
+			if err = g.store().SaveScheduleSnooze(s); err != nil {
+				log.Warningln(fmt.Sprintf("[s.Timer] %s", err.Error()))
+			}
+		}
+	}
+	s.NextStart = next
+	if err = g.store().SaveScheduleNextStart(s); err != nil {
+		log.Warningln(fmt.Sprintf("[s.Timer] %s", err.Error()))
+	}
+	g.Schedules.lock.Unlock()
+
+	//停止监听后调度可能被ReloadSchedule替换，日志使用等待前的调度信息
+	id, name := s.Id, s.Name
 	select {
-	case <-time.After(countDown):
+	case <-g.clock().After(countDown):
 		//从元数据库初始化调度链信息
 		err := s.InitSchedule()
 		if err != nil {
 			e := fmt.Sprintf("[s.Timer] init schedule [%d] error %s.\n", s.Id, err.Error())
-			g.L.Warningln(e)
+			log.Warningln(e)
+			return
+		}
+
+		//其它进程在等待期间暂停或禁用了调度
+		if s.Status == 1 {
+			log.Infoln(fmt.Sprintf("[s.Timer] Schedule [%d %s] is paused.", s.Id, s.Name))
+			return
+		}
+		if !s.Enabled {
+			log.Infoln(fmt.Sprintf("[s.Timer] Schedule [%d %s] is disabled.", s.Id, s.Name))
+			return
+		}
+		if s.exhausted() {
+			log.Infoln(fmt.Sprintf("[s.Timer] Schedule [%d %s] has run %d times, stop scheduling.", s.Id, s.Name, s.Count))
+			return
+		}
+
+		//空调度按策略处理，跳过时继续等待下一周期
+		skip, err := s.checkEmpty()
+		if err != nil {
+			log.Warningln(fmt.Sprintf("[s.Timer] %s", err.Error()))
+			return
+		}
+		if skip {
+			go s.Timer()
+			return
+		}
+
+		//调度链不完整时执行会在中途出错，跳过本次启动，等待下一周期
+		if err = s.checkChain(); err != nil {
+			log.Warningln(fmt.Sprintf("[s.Timer] schedule [%d %s] is skipped %s", s.Id, s.Name, err.Error()))
+			go s.Timer()
+			return
+		}
+
+		//上一批次仍在执行时按重叠策略处理
+		queued, ok := s.checkOverlap(ctx)
+		if !ok {
+			return
+		}
+
+		//Worker不可用时按策略跳过或推迟
+		if !s.waitWorkers(ctx, queued) {
+			return
+		}
+
+		//多实例部署时只有抢占到本次启动的进程执行
+		if !s.claimRun(window) {
+			if !queued {
+				go s.Timer()
+			}
 			return
 		}
 
 		l := fmt.Sprintf("[s.Timer] schedule [%d %s] is start.\n", s.Id, s.Name)
-		g.L.Print(l)
+		log.Print(l)
+
+		//有限次数的调度每次启动扣减剩余次数，手动执行不扣减
+		if s.Count > 0 {
+			s.Remain--
+			if err = g.store().SaveScheduleRemain(s); err != nil {
+				log.Warningln(fmt.Sprintf("[s.Timer] %s", err.Error()))
+			}
+		}
 
 		//构建执行结构链
 		es := ExecScheduleWarper(s)
+		es.queued = queued
 		g.Schedules.AddExecSchedule(es)
 		err = es.InitExecSchedule()
 
 		if err != nil {
+			g.Schedules.RemoveExecSchedule(es.batchId)
 			e := fmt.Sprintf("[s.Timer] Init Execschedule [%d %s] error %s.\n", s.Id, s.Name, err.Error())
-			g.L.Warningln(e)
+			es.log.Warningln(e)
 			return
 		}
 
 		//启动线程执行调度任务
+		es.publishEvent(EventScheduleFired, 0, es.state, "timer")
 		go es.Run()
 	case <-s.isRefresh:
-		l := fmt.Sprintf("[s.Timer] schedule [%d %s] is refresh.\n", s.Id, s.Name)
-		g.L.Println(l)
+		l := fmt.Sprintf("[s.Timer] schedule [%d %s] is refresh.\n", id, name)
+		log.Println(l)
+		return
+	case <-ctx.Done():
+		l := fmt.Sprintf("[s.Timer] schedule [%d %s] is stopped.\n", id, name)
+		log.Println(l)
 		return
 	}
 	return
 } // }}}
 
+//misfire计算进程重启后的启动时间，next、countDown为按周期计算的下次启动时间及距启动的时间。
+//保存的NextStart晚于当前时间时按它启动；早于当前时间说明进程停止期间错过了启动，
+//Misfire为MisfireRun时立即启动，MisfireCatchUp时立即启动并在之后逐次补齐错过的启动，
+//否则记录警告并按周期等待下次启动。没有保存的启动时间时按周期启动。
+func (s *Schedule) misfire(next time.Time, countDown time.Duration) (time.Time, time.Duration) { // {{{
+	now := GetNow()
+	saved := s.NextStart
+
+	switch {
+	case saved.IsZero():
+	case saved.After(now):
+		return saved, saved.Sub(now)
+	case s.Misfire == MisfireRun:
+		s.logEntry().Warningln(fmt.Sprintf("[s.misfire] schedule [%d %s] missed the start at %s, start now.", s.Id, s.Name, saved))
+		return now, 0
+	case s.Misfire == MisfireCatchUp:
+		missed := s.missedStarts(saved, now)
+		run := missed
+		if g.MisfireLimit > 0 && run > g.MisfireLimit {
+			run = g.MisfireLimit
+		}
+		s.catchUp = run - 1
+		s.logEntry().Warningln(fmt.Sprintf("[s.misfire] schedule [%d %s] missed %d starts since %s, catch up %d and skip %d.",
+			s.Id, s.Name, missed, saved, run, missed-run))
+		return now, 0
+	default:
+		s.logEntry().Warningln(fmt.Sprintf("[s.misfire] schedule [%d %s] missed the start at %s, wait for next start at %s.",
+			s.Id, s.Name, saved, next))
+	}
+	return next, countDown
+} // }}}
+
 //从元数据库初始化Schedule结构，先从元数据库获取Schedule的信息，完成后
 //根据其中的Jobid继续从元数据库读取job信息，并初始化。完成后继续初始化下级Job，
 //同时将初始化完成的Job和Task添加到Schedule的Jobs、Tasks成员中。
 func (s *Schedule) InitSchedule() error { // {{{
-	err := s.getSchedule()
+	err := s.loadSchedule(context.Background())
 	if err != nil {
-		e := fmt.Sprintf("\n[s.InitSchedule] get schedule [%d] error %s.", s.Id, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[s.InitSchedule] get schedule [%d] error %s.", s.Id, err.Error())
 	}
 
 	if s.JobId == 0 {
@@ -253,16 +1315,14 @@ func (s *Schedule) InitSchedule() error { // {{{
 	}
 
 	tj := &Job{Id: s.JobId}
-	err = tj.getJob()
+	err = g.store().GetJob(context.Background(), tj)
 	if err != nil {
-		e := fmt.Sprintf("\n[s.InitSchedule] get job [%d] error %s.", s.JobId, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[s.InitSchedule] get job [%d] error %s.", s.JobId, err.Error())
 	}
 
 	tj.ScheduleId, tj.ScheduleCyc = s.Id, s.Cyc
 	if err = tj.InitJob(s); err != nil {
-		e := fmt.Sprintf("\n[s.InitSchedule] init job [%d] error %s.", s.JobId, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[s.InitSchedule] init job [%d] error %s.", s.JobId, err.Error())
 	}
 	s.Job = tj
 	for j := s.Job; j != nil; {
@@ -271,19 +1331,145 @@ func (s *Schedule) InitSchedule() error { // {{{
 		j = j.NextJob
 	}
 
+	//任务依赖存在循环时执行无法结束，初始化时即返回错误
+	for _, j := range s.Jobs {
+		if err = j.DetectCycles(); err != nil {
+			return newError(CodeInvalid, err, "\n[s.InitSchedule] %s", err.Error())
+		}
+	}
+
+	//运行参数的模板在执行时才解析，初始化时先校验，避免执行时才发现错误
+	if err = s.checkParams(); err != nil {
+		return newError(CodeInvalid, err, "\n[s.InitSchedule] %s", err.Error())
+	}
+
+	s.logEntry().Debugln("[s.InitSchedule] schedule", s.Name, "is initialized jobs=", s.JobCnt, "tasks=", s.TaskCnt)
 	return nil
 } // }}}
 
-//刷新Schedule
-func (s *Schedule) refresh() { // {{{
+//chain返回包含调度链的调度，用于只读取调度链的查询。
+//调度尚未初始化调度链时，从元数据库初始化一个副本返回，不修改内存中正在监听的调度。
+func (s *Schedule) chain() (*Schedule, error) { // {{{
+	if s.Job != nil || s.JobId == 0 {
+		return s, nil
+	}
+	return s.loadCopy()
+} // }}}
 
-	//发送消息停止监听
-	s.isRefresh <- true
+//loadCopy从元数据库初始化调度的一个副本并返回，用于需要最新调度链的执行。
+//与ReloadSchedule一样在副本中加载，内存中的调度及其监听（isRefresh、调度链）保持不变。
+func (s *Schedule) loadCopy() (*Schedule, error) { // {{{
+	ns := &Schedule{Id: s.Id}
+	if err := ns.InitSchedule(); err != nil {
+		return nil, err
+	}
+	return ns, nil
+} // }}}
 
-	//启动监听，按时启动Schedule
-	go s.Timer()
+//isEmpty判断调度是否为空调度，即调度下没有任何任务（包括未设置作业的情况）。
+//空调度执行时不会有任务完成，执行结构无法正常结束。
+func (s *Schedule) isEmpty() bool { // {{{
+	return s.TaskCnt == 0
+} // }}}
 
-	return
+//checkOverlap在启动前检查调度的上一批次是否仍在执行，按Overlap策略处理，
+//返回ok为false时不启动本次执行。
+//上一批次是自动调度的批次时，它结束后会设置下次执行时间，说明本次启动来自重复的计时：
+//跳过时不再重新计时；排队时返回queued为true，本批次结束后不再设置下次执行时间，
+//保证同一调度只保留一个计时。
+func (s *Schedule) checkOverlap(ctx context.Context) (queued bool, ok bool) { // {{{
+	for {
+		prev := g.Schedules.runningBatch(s.Id)
+		if prev == nil {
+			return queued, true
+		}
+		rearm := prev.execType == 1 && !prev.queued
+
+		switch s.Overlap {
+		case OverlapAllow:
+			return queued, true
+		case OverlapQueue:
+			s.logEntry().Infoln(fmt.Sprintf("[s.checkOverlap] schedule [%d %s] is waiting for batchId=[%s] to end.",
+				s.Id, s.Name, prev.batchId))
+			queued = queued || rearm
+			select {
+			case <-prev.done:
+			case <-ctx.Done():
+				return false, false
+			}
+		default:
+			s.logEntry().Warningln(fmt.Sprintf("[s.checkOverlap] schedule [%d %s] batchId=[%s] is still running, skip this start.",
+				s.Id, s.Name, prev.batchId))
+			if !rearm {
+				go s.Timer()
+			}
+			return false, false
+		}
+	}
+} // }}}
+
+//checkEmpty按全局配置的EmptyPolicy检查空调度。
+//非空调度返回false。空调度在EmptySkip策略下记录警告并返回true，
+//在EmptyRefuse策略下返回error信息。
+func (s *Schedule) checkEmpty() (skip bool, err error) { // {{{
+	if !s.isEmpty() {
+		return false, nil
+	}
+
+	if g.EmptyPolicy == EmptyRefuse {
+		return false, newError(CodeInvalid, nil, "schedule [%d %s] has no task, refuse to start.", s.Id, s.Name)
+	}
+
+	l := fmt.Sprintf("[s.checkEmpty] schedule [%d %s] has no task, skip this run.", s.Id, s.Name)
+	s.logEntry().Warningln(l)
+	return true, nil
+} // }}}
+
+//warmup在调度启动监听前执行一次预热任务，用于建立连接、填充缓存等准备工作。
+//预热任务由WarmupTaskId指定，可以是调度链中的任务，也可以是单独的任务；
+//预热只执行这一个任务，不生成批次、不写执行日志，执行失败返回error信息，调度不启动监听。
+//读取预热任务失败时错误类型为CodeStore，任务执行失败时为CodeConflict。
+//与启动时立即执行整个调度链（RunOnStartup）不同，预热不计入调度的执行次数，
+//两者同时设置时先预热，再进入正常的执行流程。
+func (s *Schedule) warmup() error { // {{{
+	if s.WarmupTaskId == 0 {
+		return nil
+	}
+
+	t := s.GetTaskById(s.WarmupTaskId)
+	if t == nil {
+		t = &Task{Id: s.WarmupTaskId}
+		if err := g.store().GetTask(t); err != nil {
+			return newError(CodeStore, err, "[s.warmup] schedule [%d %s] get warmup task error %s", s.Id, s.Name, err.Error())
+		}
+	}
+
+	s.logEntry().Infoln("[s.warmup] schedule", s.Id, s.Name, "warmup task", t.Id, t.Name, "is start")
+	rl := &Reply{}
+	run := g.Executor.Run
+	if t.ExecutorType != "" {
+		run = func(t *Task, rl *Reply) error { return runLocal(context.Background(), t, rl) }
+	}
+	if err := run(t, rl); err != nil {
+		return newError(CodeConflict, err, "[s.warmup] schedule [%d %s] warmup task [%d %s] error %s", s.Id, s.Name, t.Id, t.Name, err.Error())
+	}
+	if rl.Err != "" {
+		return newError(CodeConflict, nil, "[s.warmup] schedule [%d %s] warmup task [%d %s] is fail %s", s.Id, s.Name, t.Id, t.Name, rl.Err)
+	}
+	s.logEntry().Infoln("[s.warmup] schedule", s.Id, s.Name, "warmup task", t.Id, t.Name, "is end", rl.Stdout)
+
+	return nil
+} // }}}
+
+//刷新Schedule，停止正在等待启动的监听后按新的设置重新监听。
+//没有正在等待的监听时（暂停、次数用尽、正在执行或监听已退出）不启动新的监听，
+//避免阻塞或同时存在两个监听，正在执行的批次结束后按新的设置计时。
+func (s *Schedule) refresh() { // {{{
+	select {
+	case s.isRefresh <- true:
+		go s.Timer()
+	default:
+	}
 } // }}}
 
 //addTaskList将传入的*Task添加到*Schedule.Tasks中
@@ -306,8 +1492,7 @@ func (s *Schedule) GetTaskById(id int64) *Task { // {{{
 func (s *Schedule) AddTask(task *Task) error { // {{{
 	err := task.AddTask()
 	if err != nil {
-		e := fmt.Sprintf("\n[s.AddTask] %s.", err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[s.AddTask] %s.", err.Error())
 	}
 
 	s.Tasks = append(s.Tasks, task)
@@ -315,19 +1500,18 @@ func (s *Schedule) AddTask(task *Task) error { // {{{
 
 	j, err := s.GetJobById(task.JobId)
 	if err != nil {
-		e := fmt.Sprintf("\n[s.AddTask] not found job by id %d", task.JobId)
-		return errors.New(e)
+		return newError(CodeNotFound, nil, "\n[s.AddTask] not found job by id %d", task.JobId)
 	}
-	j.Tasks[string(task.Id)] = task
+	j.Tasks[taskKey(task.Id)] = task
 	j.TaskCnt++
 
 	return err
 } // }}}
 
 //DeleteTask方法用来删除指定id的Task。首先会根据传入参数在Schedule的Tasks列
-//表中查出对应的Task。然后将其从Tasks列表中去除，将其从所属Job中去除，调用
-//Task的Delete方法删除Task的依赖关系，完成后删除元数据库的信息。
-//没找到对应Task或删除失败，返回error信息。
+//表中查出对应的Task，在一个事务中删除Task的参数、依赖关系以及Task本身的元数据，
+//事务提交后再将其从Tasks列表、所属Job以及依赖它的Task中去除。
+//没找到对应Task或删除失败，返回error信息，此时元数据库与内存中的调度均保持不变。
 func (s *Schedule) DeleteTask(id int64) error { // {{{
 	i := -1
 	for k, task := range s.Tasks {
@@ -336,33 +1520,26 @@ func (s *Schedule) DeleteTask(id int64) error { // {{{
 		}
 	}
 	if i == -1 {
-		e := fmt.Sprintf("\n[s.DeleteTask] not found task by id %d", id)
-		return errors.New(e)
+		return newError(CodeNotFound, nil, "\n[s.DeleteTask] not found task by id %d", id)
 	}
 
 	t := s.Tasks[i]
-	s.Tasks = append(s.Tasks[0:i], s.Tasks[i+1:]...)
-	s.TaskCnt = len(s.Tasks)
-
 	j, er := s.GetJobById(t.JobId)
 	if er != nil {
-		e := fmt.Sprintf("\n[s.DeleteTask] get job [%d] error %s", id, er.Error())
-		return errors.New(e)
+		return newError(CodeStore, er, "\n[s.DeleteTask] get job [%d] error %s", id, er.Error())
 	}
 
-	err := j.DeleteTask(t.Id)
-	if err != nil {
-		e := fmt.Sprintf("\n[s.DeleteTask] DeleteTask error %s", err.Error())
-		return errors.New(e)
+	if err := g.store().DeleteTask(t); err != nil {
+		return newError(CodeStore, err, "\n[s.DeleteTask] schedule [%d] Delete error %s.", s.Id, err.Error())
 	}
 
-	err = t.Delete()
-	if err != nil {
-		e := fmt.Sprintf("\n[s.DeleteTask] schedule [%d] Delete error %s.", err.Error())
-		return errors.New(e)
+	s.Tasks = append(s.Tasks[0:i], s.Tasks[i+1:]...)
+	s.TaskCnt = len(s.Tasks)
+	t.clearRelTasks()
+	for _, dt := range s.Tasks {
+		dt.removeRelTask(t.Id)
 	}
-
-	return err
+	return j.DeleteTask(t.Id)
 } // }}}
 
 //GetJobById遍历Jobs列表，返回调度中指定Id的Job，若没找到返回nil
@@ -372,37 +1549,116 @@ func (s *Schedule) GetJobById(id int64) (*Job, error) { // {{{
 			return j, nil
 		}
 	}
-	e := fmt.Sprintf("\n[s.GetJobById] not found job  [%d] .", id)
-	return nil, errors.New(e)
+	return nil, newError(CodeNotFound, nil, "\n[s.GetJobById] not found job  [%d] .", id)
 } // }}}
 
-//在调度中添加一个Job，AddJob会接收传入的Job类型的参数，并调用它的
-//Add()方法进行持久化操作。成功后把它添加到调度链中，添加时若调度
-//下无Job则将Job直接添加到调度中，否则添加到调度中的任务链末端。
-func (s *Schedule) AddJob(job *Job) error { // {{{
-	err := job.add()
+//在调度中添加一个Job，AddJob会接收传入的Job类型的参数，在一个事务中将它
+//持久化，并更新调度或前一个Job对它的引用。事务提交后把它添加到调度链中，
+//添加时若调度下无Job则将Job直接添加到调度中，否则添加到调度中的任务链末端。
+//持久化失败时事务回滚，元数据库与内存中的调度链均保持不变。
+//成功时返回新Job的Id，同时设置到job.Id中。
+func (s *Schedule) AddJob(job *Job) (int64, error) { // {{{
+	var pj *Job
+	if len(s.Jobs) > 0 {
+		pj = s.Jobs[len(s.Jobs)-1]
+		job.PreJobId = pj.Id
+	}
+	job.Tasks = make(map[string]*Task)
+	job.CreateTime, job.ModifyTime = time.Now(), time.Now()
+
+	err := g.store().InTx(func(ms MetaStore) error {
+		if err := ms.AddJob(job); err != nil {
+			return newError(CodeStore, err, "\n[s.AddJob] %s.", err.Error())
+		}
+
+		//先更新副本，提交后再修改调度链
+		if pj == nil {
+			ts := *s
+			ts.JobId = job.Id
+			if err := ms.UpdateSchedule(&ts); err != nil {
+				return newError(CodeStore, err, "\n[s.AddJob] update schedule [%d] error %s.", s.Id, err.Error())
+			}
+		} else {
+			tj := *pj
+			tj.NextJobId = job.Id
+			if err := ms.UpdateJob(&tj); err != nil {
+				return newError(CodeStore, err, "\n[s.AddJob] update job [%d] error %s.", pj.Id, err.Error())
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		e := fmt.Sprintf("\n[s.AddJob] %s.", err.Error())
-		return errors.New(e)
+		return 0, err
 	}
 
-	if len(s.Jobs) == 0 {
+	if pj == nil {
 		s.JobId, s.Job = job.Id, job
-		if err = s.update(); err != nil {
-			e := fmt.Sprintf("\n[s.AddJob] update schedule [%d] error %s.", s.Id, err.Error())
-			return errors.New(e)
-		}
 	} else {
-		j := s.Jobs[len(s.Jobs)-1]
-		j.NextJob, j.NextJobId, job.PreJob = job, job.Id, j
-		if err = j.update(); err != nil {
-			e := fmt.Sprintf("\n[s.AddJob] update job [%d] error %s.", job.Id, err.Error())
-			return errors.New(e)
-		}
+		pj.NextJob, pj.NextJobId, job.PreJob = job, job.Id, pj
 	}
 	s.Jobs = append(s.Jobs, job)
 	s.JobCnt = len(s.Jobs)
-	return err
+	return job.Id, nil
+} // }}}
+
+//InsertJobAfter将Job插入到调度链中id为afterId的Job之后，新Job的Id在持久化时生成。
+//在一个事务中持久化新Job，并将前一个Job的下级作业、原下一个Job的上级作业指向它，
+//事务提交后再修改调度链。afterId不在调度中时返回error信息，
+//持久化失败时事务回滚，元数据库与内存中的调度链均保持不变。
+func (s *Schedule) InsertJobAfter(afterId int64, job *Job) error { // {{{
+	i := -1
+	for k, j := range s.Jobs {
+		if j.Id == afterId {
+			i = k
+		}
+	}
+	if i == -1 {
+		return newError(CodeNotFound, nil, "\n[s.InsertJobAfter] not found job by id %d", afterId)
+	}
+
+	pj := s.Jobs[i]
+	var nj *Job
+	if pj.NextJobId > 0 {
+		var err error
+		if nj, err = s.GetJobById(pj.NextJobId); err != nil {
+			return newError(CodeStore, err, "\n[s.InsertJobAfter] get nextjob [%d] error %s", pj.NextJobId, err.Error())
+		}
+	}
+	job.PreJobId, job.NextJobId = pj.Id, pj.NextJobId
+	job.Tasks = make(map[string]*Task)
+	job.CreateTime, job.ModifyTime = time.Now(), time.Now()
+
+	err := g.store().InTx(func(ms MetaStore) error {
+		if err := ms.AddJob(job); err != nil {
+			return newError(CodeStore, err, "\n[s.InsertJobAfter] %s.", err.Error())
+		}
+
+		//先更新副本，提交后再修改调度链
+		tj := *pj
+		tj.NextJobId = job.Id
+		if err := ms.UpdateJob(&tj); err != nil {
+			return newError(CodeStore, err, "\n[s.InsertJobAfter] update job [%d] error %s.", pj.Id, err.Error())
+		}
+		if nj != nil {
+			tj := *nj
+			tj.PreJobId = job.Id
+			if err := ms.UpdateJob(&tj); err != nil {
+				return newError(CodeStore, err, "\n[s.InsertJobAfter] update job [%d] error %s.", nj.Id, err.Error())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	pj.NextJob, pj.NextJobId, job.PreJob, job.NextJob = job, job.Id, pj, nj
+	if nj != nil {
+		nj.PreJob, nj.PreJobId = job, job.Id
+	}
+	s.Jobs = append(s.Jobs[:i+1], append([]*Job{job}, s.Jobs[i+1:]...)...)
+	s.JobCnt = len(s.Jobs)
+	return nil
 } // }}}
 
 //UpdateJob用来在调度中添加一个Job
@@ -411,171 +1667,383 @@ func (s *Schedule) AddJob(job *Job) error { // {{{
 func (s *Schedule) UpdateJob(job *Job) error { // {{{
 	j, err := s.GetJobById(job.Id)
 	if err != nil {
-		e := fmt.Sprintf("\n[s.DeleteTask] not found job by id %d", job.Id)
-		return errors.New(e)
+		return newError(CodeNotFound, nil, "\n[s.DeleteTask] not found job by id %d", job.Id)
 	}
 
-	j.Name, j.Desc = job.Name, job.Desc
+	j.Name, j.Desc, j.ParallelGroup, j.TimeOut = job.Name, job.Desc, job.ParallelGroup, job.TimeOut
 	j.ModifyTime, j.ModifyUserId = time.Now(), job.ModifyUserId
-	err = j.update()
+	err = g.store().UpdateJob(j)
 	if err != nil {
-		e := fmt.Sprintf("\n[s.UpdateJob] update job [%d] error %s.", j.Id, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[s.UpdateJob] update job [%d] error %s.", j.Id, err.Error())
 	}
 	return err
 } // }}}
 
 //DeleteJob删除调度中最后一个Job，它会接收传入的Job Id，并查看是否
-//调度中最后一个Job，是，检查Job下有无Task，无，则执行删除操作，完成
-//后，将该Job的前一个Job的nextJob指针置0，更新调度信息。
-//出错或不符条件则返回error信息
+//调度中最后一个Job，是，检查Job下有无Task，无，则执行删除操作：在一个事务中
+//将该Job的前一个Job的nextJob指针置0，更新调度信息并删除该Job，事务提交后再修改调度链。
+//出错或不符条件则返回error信息，出错时元数据库与内存中的调度链均保持不变。
 func (s *Schedule) DeleteJob(id int64) error { // {{{
 	j, err := s.GetJobById(id)
 	if err != nil {
-		e := fmt.Sprintf("\n[s.DeleteJob] not found job by id %d", id)
-		return errors.New(e)
+		return newError(CodeNotFound, nil, "\n[s.DeleteJob] not found job by id %d", id)
+	}
+	if j.TaskCnt != 0 || j.NextJobId != 0 {
+		return nil
 	}
-	if j.TaskCnt == 0 && j.NextJobId == 0 {
 
-		if j.PreJobId > 0 {
-			pj, er := s.GetJobById(j.PreJobId)
-			if er != nil {
-				e := fmt.Sprintf("\n[s.DeleteJob] get prejob [%d] error %s", j.PreJobId, er.Error())
-				return errors.New(e)
-			}
+	var pj *Job
+	if j.PreJobId > 0 {
+		if pj, err = s.GetJobById(j.PreJobId); err != nil {
+			return newError(CodeStore, err, "\n[s.DeleteJob] get prejob [%d] error %s", j.PreJobId, err.Error())
+		}
+	}
 
-			pj.NextJob, pj.NextJobId = nil, 0
-			if err = pj.update(); err != nil {
-				e := fmt.Sprintf("\n[s.DeleteJob] update job [%d] to schedule [%d] error %s.", j.Id, s.Id, err.Error())
-				return errors.New(e)
+	err = g.store().InTx(func(ms MetaStore) error {
+		if pj != nil {
+			tj := *pj
+			tj.NextJobId = 0
+			if err := ms.UpdateJob(&tj); err != nil {
+				return newError(CodeStore, err, "\n[s.DeleteJob] update job [%d] to schedule [%d] error %s.", j.Id, s.Id, err.Error())
 			}
 		}
 
 		if len(s.Jobs) == 1 {
-			s.Jobs, s.Job, s.JobId = make([]*Job, 0), nil, 0
-			if err = s.update(); err != nil {
-				e := fmt.Sprintf("\n[s.DeleteJob] update schedule [%d] error %s.", s.Id, err.Error())
-				return errors.New(e)
+			ts := *s
+			ts.JobId = 0
+			if err := ms.UpdateSchedule(&ts); err != nil {
+				return newError(CodeStore, err, "\n[s.DeleteJob] update schedule [%d] error %s.", s.Id, err.Error())
+			}
+		}
+
+		if err := ms.DeleteJob(j); err != nil {
+			return newError(CodeStore, err, "\n[s.DeleteJob] delete job [%d] error %s.", j.Id, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if pj != nil {
+		pj.NextJob, pj.NextJobId = nil, 0
+	}
+	if len(s.Jobs) == 1 {
+		s.Jobs, s.Job, s.JobId = make([]*Job, 0), nil, 0
+	} else {
+		s.Jobs = s.Jobs[0 : len(s.Jobs)-1]
+	}
+	s.JobCnt = len(s.Jobs)
+	return nil
+} // }}}
+
+//DeleteJobAndRelink删除调度链中任意位置的Job，并将它的上一个Job（为调度中第一个Job时为调度本身）
+//指向它的下一个Job，下一个Job的上级作业相应指向它的上一个Job。
+//Job下有Task时，force为false则拒绝删除；为true时将Task迁移到上一个Job，没有上一个Job时迁移到下一个Job，
+//迁移后的Task按所在Job的并行组执行。Job为调度中唯一的Job且有Task时无法迁移，返回error。
+//前后Job、调度的更新，Task的迁移以及Job的删除在一个事务中完成，事务提交后再修改调度链，
+//出错时元数据库与内存中的调度链均保持不变。
+func (s *Schedule) DeleteJobAndRelink(id int64, force bool) error { // {{{
+	j, err := s.GetJobById(id)
+	if err != nil {
+		return newError(CodeNotFound, nil, "\n[s.DeleteJobAndRelink] not found job by id %d", id)
+	}
+	if j.TaskCnt > 0 && !force {
+		return newError(CodeConflict, nil, "\n[s.DeleteJobAndRelink] job [%d %s] still has %d tasks.", j.Id, j.Name, j.TaskCnt)
+	}
+
+	var pj, nj *Job
+	if j.PreJobId > 0 {
+		if pj, err = s.GetJobById(j.PreJobId); err != nil {
+			return newError(CodeStore, err, "\n[s.DeleteJobAndRelink] get prejob [%d] error %s", j.PreJobId, err.Error())
+		}
+	}
+	if j.NextJobId > 0 {
+		if nj, err = s.GetJobById(j.NextJobId); err != nil {
+			return newError(CodeStore, err, "\n[s.DeleteJobAndRelink] get nextjob [%d] error %s", j.NextJobId, err.Error())
+		}
+	}
+
+	//Task迁移的目标Job
+	target := pj
+	if target == nil {
+		target = nj
+	}
+	if j.TaskCnt > 0 && target == nil {
+		return newError(CodeConflict, nil, "\n[s.DeleteJobAndRelink] job [%d %s] is the only job of schedule [%d], its tasks can not be moved.",
+			j.Id, j.Name, s.Id)
+	}
+
+	err = g.store().InTx(func(ms MetaStore) error {
+		//先更新副本，提交后再修改调度链
+		if pj != nil {
+			tj := *pj
+			tj.NextJobId = j.NextJobId
+			if err := ms.UpdateJob(&tj); err != nil {
+				return newError(CodeStore, err, "\n[s.DeleteJobAndRelink] update job [%d] error %s.", pj.Id, err.Error())
 			}
 		} else {
-			s.Jobs = s.Jobs[0 : len(s.Jobs)-1]
+			ts := *s
+			ts.JobId = j.NextJobId
+			if err := ms.UpdateSchedule(&ts); err != nil {
+				return newError(CodeStore, err, "\n[s.DeleteJobAndRelink] update schedule [%d] error %s.", s.Id, err.Error())
+			}
 		}
 
-		s.JobCnt = len(s.Jobs)
-		err = j.deleteJob()
-		if err != nil {
-			e := fmt.Sprintf("\n[s.DeleteJob] delete job [%d] error %s.", j.Id, err.Error())
-			return errors.New(e)
+		if nj != nil {
+			tj := *nj
+			tj.PreJobId = j.PreJobId
+			if err := ms.UpdateJob(&tj); err != nil {
+				return newError(CodeStore, err, "\n[s.DeleteJobAndRelink] update job [%d] error %s.", nj.Id, err.Error())
+			}
+		}
+
+		if j.TaskCnt > 0 {
+			if err := ms.MoveJobTasks(j, target.Id); err != nil {
+				return newError(CodeStore, err, "\n[s.DeleteJobAndRelink] move tasks of job [%d] error %s.", j.Id, err.Error())
+			}
+		}
+
+		if err := ms.DeleteJob(j); err != nil {
+			return newError(CodeStore, err, "\n[s.DeleteJobAndRelink] delete job [%d] error %s.", j.Id, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if pj != nil {
+		pj.NextJobId, pj.NextJob = j.NextJobId, nj
+	} else {
+		s.JobId, s.Job = j.NextJobId, nj
+	}
+	if nj != nil {
+		nj.PreJobId, nj.PreJob = j.PreJobId, pj
+	}
+	for k, t := range j.Tasks {
+		t.JobId = target.Id
+		target.Tasks[k] = t
+		target.TaskCnt++
+	}
+
+	for i, jj := range s.Jobs {
+		if jj.Id == j.Id {
+			s.Jobs = append(s.Jobs[0:i], s.Jobs[i+1:]...)
+			break
 		}
 	}
-	return err
+	s.JobCnt = len(s.Jobs)
+	return nil
 } // }}}
 
-//增加Schedule信息
+//增加Schedule信息，校验失败时返回*ValidationError
 func (s *Schedule) Add() error { // {{{
+	if err := s.Validate(); err != nil {
+		return err
+	}
 	s.CreateTime, s.ModifyTime = time.Now(), time.Now()
-	err := s.add()
+	s.Remain = int(s.Count)
+	err := g.store().AddSchedule(s)
 	if err != nil {
-		e := fmt.Sprintf("\n[s.Add] %s.", err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[s.Add] %s.", err.Error())
+	}
+	s.savedEnabled = s.Enabled
+	if err = g.store().SaveScheduleTags(s); err != nil {
+		return newError(CodeStore, err, "\n[s.Add] %s.", err.Error())
+	}
+	if err = g.store().SaveScheduleParams(s); err != nil {
+		return newError(CodeStore, err, "\n[s.Add] %s.", err.Error())
 	}
 	return nil
 } // }}}
 
 //UpdateSchedule方法会将传入参数的信息更新到Schedule结构并持久化到数据库中
-//在持久化之前会调用addStart方法将启动列表持久化
+//在持久化之前会调用addStart方法将启动列表持久化，标签按Tags整体替换，运行参数按Params整体替换
+//持久化前先调用Validate校验，校验失败时直接返回*ValidationError，不修改数据库
+//Enabled由禁用改为启用时启动监听，由启用改为禁用时停止正在等待的监听，
+//正在执行的批次不受影响，结束后不再启动下一次
+//设置了UniqueNames时，与其它调度重名同样返回*ValidationError
 func (s *Schedule) UpdateSchedule() error { // {{{
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if g.UniqueNames && g.Schedules != nil {
+		g.Schedules.names.Lock()
+		defer g.Schedules.names.Unlock()
+		if err := g.Schedules.checkName(s); err != nil {
+			return err
+		}
+	}
+
 	err := s.AddScheduleStart()
 	if err != nil {
-		e := fmt.Sprintf("\n[s.UpdateSchedule] addstart error %s.", err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[s.UpdateSchedule] addstart error %s.", err.Error())
+	}
+
+	err = g.store().UpdateSchedule(s)
+	if err != nil {
+		return newError(CodeStore, err, "\n[s.UpdateSchedule] update schedule [%d] error %s.", s.Id, err.Error())
+	}
+
+	err = g.store().SaveScheduleTags(s)
+	if err != nil {
+		return newError(CodeStore, err, "\n[s.UpdateSchedule] %s", err.Error())
 	}
 
-	err = s.update()
+	err = g.store().SaveScheduleParams(s)
 	if err != nil {
-		e := fmt.Sprintf("\n[s.UpdateSchedule] update schedule [%d] error %s.", s.Id, err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[s.UpdateSchedule] %s", err.Error())
 	}
 
-	s.refresh()
+	enabled := s.savedEnabled
+	s.savedEnabled = s.Enabled
+	switch {
+	case s.Enabled && enabled:
+		s.refresh()
+	case s.Enabled:
+		//禁用期间没有监听，直接启动
+		go s.Timer()
+	default:
+		//停止正在等待的监听，之后启动的监听因调度已禁用直接返回
+		select {
+		case s.isRefresh <- true:
+		default:
+		}
+	}
 	return err
 } // }}}
 
 //Delete方法删除Schedule下的Job、Task信息并持久化。
 func (s *Schedule) Delete() error { // {{{
-	for _, t := range s.Tasks {
+	err := s.clearChain()
+	if err != nil {
+		return newError(CodeStore, err, "\n[s.Delete] %s", err.Error())
+	}
+
+	err = g.store().DeleteSchedule(s)
+	if err != nil {
+		return newError(CodeStore, err, "\n[s.Delete] delete schedule [%d] error %s.", s.Id, err.Error())
+	}
+	return nil
+} // }}}
+
+//clearChain删除调度下全部的Task和Job并持久化，调度本身的信息保留。
+//先删除全部Task，再从调度链末端开始依次删除Job。
+func (s *Schedule) clearChain() error { // {{{
+	tasks := make([]*Task, len(s.Tasks))
+	copy(tasks, s.Tasks)
+	for _, t := range tasks {
 		err := s.DeleteTask(t.Id)
 		if err != nil {
-			e := fmt.Sprintf("\n[s.Delete] DeleteTask [%d] error %s.", t.Id, err.Error())
-			return errors.New(e)
+			return newError(CodeStore, err, "\n[s.clearChain] DeleteTask [%d] error %s.", t.Id, err.Error())
 		}
 	}
 
-	for _, j := range s.Jobs {
+	for len(s.Jobs) > 0 {
+		j := s.Jobs[len(s.Jobs)-1]
 		err := s.DeleteJob(j.Id)
 		if err != nil {
-			e := fmt.Sprintf("\n[s.Delete] DeleteJob [%d] error %s.", j.Id, err.Error())
-			return errors.New(e)
+			return newError(CodeStore, err, "\n[s.clearChain] DeleteJob [%d] error %s.", j.Id, err.Error())
 		}
-	}
 
-	err := s.delStart()
-	if err != nil {
-		e := fmt.Sprintf("\n[s.Delete] delStart error %s.", err.Error())
-		return errors.New(e)
+		//不满足删除条件时DeleteJob不做处理，此时中止以免重复删除
+		if len(s.Jobs) > 0 && s.Jobs[len(s.Jobs)-1] == j {
+			return newError(CodeConflict, nil, "\n[s.clearChain] job [%d] can not be deleted.", j.Id)
+		}
 	}
 
-	err = s.deleteSchedule()
-	if err != nil {
-		e := fmt.Sprintf("\n[s.Delete] deleteSchedule [%d] error %s.", s.Id, err.Error())
-		return errors.New(e)
-	}
 	return nil
 } // }}}
 
+//hasTag判断调度是否带有标签tag
+func (s *Schedule) hasTag(tag string) bool { // {{{
+	for _, t := range s.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+} // }}}
+
 //addStart将Schedule的启动列表持久化到数据库
-//添加前先调用delStart方法将Schedule中的原有启动列表清空
+//添加前先按启动月份、启动时间排序，再调用delStart方法将Schedule中的原有启动列表清空
 //需要注意的是：内存中的启动列表单位为纳秒，存储前需要转成秒
 //若成功则开始添加，失败返回err信息
 func (s *Schedule) AddScheduleStart() error { // {{{
-	err := s.delStart()
-	if err != nil {
-		e := fmt.Sprintf("\n[s.AddScheduleStart] delStart error %s.", err.Error())
-		return errors.New(e)
+	if len(s.StartMonth) != len(s.StartSecond) {
+		return newError(CodeInvalid, nil, "\n[s.AddScheduleStart] schedule [%d %s] has %d start months but %d start seconds.",
+			s.Id, s.Name, len(s.StartMonth), len(s.StartSecond))
 	}
+	s.sortStart()
 
-	for i, st := range s.StartSecond {
-		err = s.addStart(time.Duration(st)/time.Second, s.StartMonth[i])
-		if err != nil {
-			e := fmt.Sprintf("\n[s.AddScheduleStart] error %s.", err.Error())
-			return errors.New(e)
-		}
+	if err := g.store().SaveScheduleStart(s); err != nil {
+		return newError(CodeStore, err, "\n[s.AddScheduleStart] %s", err.Error())
 	}
 
-	return err
+	return nil
 } // }}}
 
-//启动时间排序
-//算法选择排序
+//启动时间排序，按启动月份、启动时间升序，相同的启动时间保持原有顺序。
+//StartMonth与StartSecond按下标成对排序，长度不一致时只排序成对的部分，多出的元素保持不变。
 func (s *Schedule) sortStart() { // {{{
-	var i, j, k int
+	n := len(s.StartMonth)
+	if len(s.StartSecond) < n {
+		n = len(s.StartSecond)
+	}
 
-	for i = 0; i < len(s.StartMonth); i++ {
-		k = i
+	sts := make(startList, n)
+	for i := 0; i < n; i++ {
+		sts[i] = startTime{month: s.StartMonth[i], second: s.StartSecond[i]}
+	}
+	sort.Stable(sts)
+	for i, st := range sts {
+		s.StartMonth[i], s.StartSecond[i] = st.month, st.second
+	}
+	sort.Slice(s.StartWeekday, func(i, j int) bool { return s.StartWeekday[i] < s.StartWeekday[j] })
+} // }}}
 
-		for j = i + 1; j < len(s.StartMonth); j++ {
-			if s.StartMonth[j] < s.StartMonth[k] {
-				k = j
-			} else if (s.StartMonth[j] == s.StartMonth[k]) && (s.StartSecond[j] < s.StartSecond[k]) {
-				k = j
-			}
-		}
+//starts返回计算启动时间使用的启动月份与启动时间列表。
+//按周调度且设置了StartWeekday时，每个启动星期与StartSecond中的每个当日启动时间组合为周内的启动时间，
+//按先后排序，启动时间按调度所在时区的钟表时间计算，见startAt；其余情况即StartMonth与StartSecond。
+func (s *Schedule) starts() ([]int, []time.Duration) { // {{{
+	if s.Cyc != "w" || len(s.StartWeekday) == 0 {
+		return s.StartMonth, s.StartSecond
+	}
 
-		if k != i {
-			s.StartMonth[k], s.StartMonth[i] = s.StartMonth[i], s.StartMonth[k]
-			s.StartSecond[k], s.StartSecond[i] = s.StartSecond[i], s.StartSecond[k]
+	seconds := s.StartSecond
+	if len(seconds) == 0 {
+		seconds = []time.Duration{0}
+	}
+	sts := make(startList, 0, len(s.StartWeekday)*len(seconds))
+	for _, wd := range s.StartWeekday {
+		for _, st := range seconds {
+			sts = append(sts, startTime{second: time.Duration(wd)*24*time.Hour + st})
 		}
-
 	}
+	sort.Stable(sts)
 
+	sm, ss := make([]int, len(sts)), make([]time.Duration, len(sts))
+	for i, st := range sts {
+		ss[i] = st.second
+	}
+	return sm, ss
 } // }}}
+
+//一个启动时间，由启动月份与周期内的启动时间组成
+type startTime struct {
+	month  int
+	second time.Duration
+}
+
+//按启动月份、启动时间排序
+type startList []startTime
+
+func (l startList) Len() int      { return len(l) }
+func (l startList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l startList) Less(i, j int) bool {
+	if l[i].month != l[j].month {
+		return l[i].month < l[j].month
+	}
+	return l[i].second < l[j].second
+}
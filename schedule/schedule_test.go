@@ -0,0 +1,5736 @@
+package schedule
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckEmpty(t *testing.T) {
+	g = DefaultGlobal()
+
+	//未设置作业的调度
+	s := &Schedule{Id: 1, Name: "empty", JobId: 0}
+
+	g.EmptyPolicy = EmptySkip
+	skip, err := s.checkEmpty()
+	if err != nil || !skip {
+		t.Fatalf("skip policy: want skip=true err=nil, got skip=%v err=%v", skip, err)
+	}
+
+	g.EmptyPolicy = EmptyRefuse
+	skip, err = s.checkEmpty()
+	if err == nil || skip {
+		t.Fatalf("refuse policy: want error, got skip=%v err=%v", skip, err)
+	}
+
+	//包含任务的调度不受策略影响
+	s.addTaskList(&Task{Id: 1, Name: "task"})
+	skip, err = s.checkEmpty()
+	if err != nil || skip {
+		t.Fatalf("non-empty schedule: want skip=false err=nil, got skip=%v err=%v", skip, err)
+	}
+}
+
+//构建测试用的调度链 job1:a -> job2:b,c（依赖a） -> job3:d（依赖b、c）
+func newTestSchedule() *Schedule { // {{{
+	s := &Schedule{Id: 1, Name: "test", Enabled: true, Jobs: make([]*Job, 0), Tasks: make([]*Task, 0)}
+	a := &Task{Id: 1, Name: "a", Cmd: "echo", Param: []string{"a"}}
+	b := &Task{Id: 2, Name: "b", Cmd: "echo", RelTasks: map[string]*Task{"1": a}}
+	c := &Task{Id: 3, Name: "c", Cmd: "echo", RelTasks: map[string]*Task{"1": a}}
+	d := &Task{Id: 4, Name: "d", Cmd: "echo", RelTasks: map[string]*Task{"2": b, "3": c}}
+
+	var pj *Job
+	for i, ts := range [][]*Task{{a}, {b, c}, {d}} {
+		j := &Job{Id: int64(i + 1), Tasks: make(map[string]*Task)}
+		for _, t := range ts {
+			t.JobId = j.Id
+			j.Tasks[t.Name] = t
+			s.addTaskList(t)
+		}
+		if pj == nil {
+			s.Job = j
+		} else {
+			pj.NextJob = j
+		}
+		s.Jobs = append(s.Jobs, j)
+		pj = j
+	}
+	return s
+} // }}}
+
+//addTestSchedule将newTestSchedule的调度链保存到元数据存储，返回保存后的调度。
+//使用新的内存存储时，作业与任务的ID与newTestSchedule相同。
+func addTestSchedule(t *testing.T) *Schedule { // {{{
+	ts := newTestSchedule()
+	s := &Schedule{Name: ts.Name, Cyc: "d", Enabled: true}
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	saved := make(map[int64]*Task)
+	for _, j := range ts.Jobs {
+		nj := &Job{Name: fmt.Sprintf("job%d", j.Id)}
+		if _, err := s.AddJob(nj); err != nil {
+			t.Fatal(err)
+		}
+		jts := make([]*Task, 0, len(j.Tasks))
+		for _, task := range j.Tasks {
+			jts = append(jts, task)
+		}
+		sort.Sort(taskById(jts))
+		for _, task := range jts {
+			nt := &Task{Name: task.Name, JobId: nj.Id, Cmd: task.Cmd, Param: task.Param, RelTasks: make(map[string]*Task)}
+			for _, rt := range task.RelTasks {
+				nt.RelTasks[taskKey(saved[rt.Id].Id)] = saved[rt.Id]
+			}
+			if err := s.AddTask(nt); err != nil {
+				t.Fatal(err)
+			}
+			saved[task.Id] = nt
+		}
+	}
+	return s
+} // }}}
+
+func TestTestRun(t *testing.T) {
+	g = DefaultGlobal()
+	s := newTestSchedule()
+
+	exec := &SyncExecutor{}
+	r, err := TestRun(s, map[string][]string{"d": {"2015-01-01"}}, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Success() || r.SuccessTaskCnt != 4 || len(r.Tasks) != 4 {
+		t.Fatalf("want success with 4 tasks, got %+v", r)
+	}
+	if len(exec.Order) != 4 || exec.Order[0] != "a" || exec.Order[3] != "d" {
+		t.Fatalf("bad task order %v", exec.Order)
+	}
+	if out := r.Tasks[3].Output; out != "echo 2015-01-01" {
+		t.Fatalf("param of d not replaced, output %q", out)
+	}
+	if s.Tasks[3].Param != nil {
+		t.Fatal("original schedule is modified")
+	}
+
+	//b执行失败，依赖b的d不会执行
+	exec = &SyncExecutor{Fail: map[string]string{"b": "error"}}
+	r, err = TestRun(s, nil, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Success() || r.FailTaskCnt != 2 || r.SuccessTaskCnt != 2 {
+		t.Fatalf("want 2 failed tasks, got %+v", r)
+	}
+	if len(exec.Order) != 3 {
+		t.Fatalf("d should not be executed, order %v", exec.Order)
+	}
+}
+
+func TestStartOrder(t *testing.T) {
+	g = DefaultGlobal()
+	sl := g.Schedules
+
+	//3依赖1、2，1依赖2；4、5互相依赖，6依赖4
+	sl.ScheduleList = []*Schedule{
+		{Id: 3, DependsOn: []int64{1, 2}},
+		{Id: 1, DependsOn: []int64{2}},
+		{Id: 2},
+		{Id: 4, DependsOn: []int64{5}},
+		{Id: 5, DependsOn: []int64{4}},
+		{Id: 6, DependsOn: []int64{4, 99}},
+	}
+
+	scds, cyclic := sl.startOrder()
+	ids := make([]int64, 0)
+	for _, s := range scds {
+		ids = append(ids, s.Id)
+	}
+	if len(ids) != 3 || ids[0] != 2 || ids[1] != 1 || ids[2] != 3 {
+		t.Fatalf("want start order [2 1 3], got %v", ids)
+	}
+	if len(cyclic) != 3 {
+		t.Fatalf("want 3 cyclic schedules, got %d", len(cyclic))
+	}
+}
+
+func TestArtifactRef(t *testing.T) {
+	g = DefaultGlobal()
+	s := newTestSchedule()
+
+	//a登记产出物，d通过参数引用
+	s.Tasks[0].Cmd = "##artifact orders"
+	s.Tasks[0].Param = []string{"s3://bucket/orders.csv"}
+	s.Tasks[3].Param = []string{"--in=${artifact:a.orders}", "${artifact:b.none}"}
+
+	r, err := TestRun(s, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := r.Tasks[len(r.Tasks)-1].Output
+	if out != "echo --in=s3://bucket/orders.csv ${artifact:b.none}" {
+		t.Fatalf("artifact reference not resolved, output %q", out)
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	g = DefaultGlobal()
+	interval := 10 * time.Second
+
+	g.RetryJitter = JitterNone
+	if w := retryWait(interval); w != interval {
+		t.Fatalf("none jitter: want %s, got %s", interval, w)
+	}
+
+	//多个任务同时重试时，等待时间应分散在区间内而不是相同
+	for _, c := range []struct {
+		jitter string
+		min    time.Duration
+	}{{JitterFull, 0}, {JitterEqual, interval / 2}} {
+		g.RetryJitter = c.jitter
+		waits := make(map[time.Duration]bool)
+		var lo, hi time.Duration = interval, 0
+		for i := 0; i < 100; i++ {
+			w := retryWait(interval)
+			if w < c.min || w > interval {
+				t.Fatalf("%s jitter: wait %s out of [%s, %s]", c.jitter, w, c.min, interval)
+			}
+			if w < lo {
+				lo = w
+			}
+			if w > hi {
+				hi = w
+			}
+			waits[w] = true
+		}
+		if len(waits) < 90 || hi-lo < (interval-c.min)/2 {
+			t.Fatalf("%s jitter: waits are not spread, %d distinct in [%s, %s]", c.jitter, len(waits), lo, hi)
+		}
+	}
+}
+
+func TestWave(t *testing.T) {
+	g = DefaultGlobal()
+	s := newTestSchedule()
+
+	//e不依赖任何任务，处于阶段1时需等待阶段0的a、b、c全部结束
+	e := &Task{Id: 5, Name: "e", Cmd: "echo", Wave: 1, JobId: 1}
+	s.Job.Tasks[e.Name] = e
+	s.addTaskList(e)
+	s.Tasks[3].Wave = 1
+
+	exec := &SyncExecutor{}
+	r, err := TestRun(s, nil, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Success() || len(exec.Order) != 5 {
+		t.Fatalf("want success with 5 tasks, got %+v order %v", r, exec.Order)
+	}
+	pos := make(map[string]int)
+	for i, name := range exec.Order {
+		pos[name] = i
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if pos[name] > pos["e"] {
+			t.Fatalf("task e of wave 1 started before %s, order %v", name, exec.Order)
+		}
+	}
+
+	//依赖更晚阶段的任务无法执行
+	s.Tasks[0].Wave = 2
+	if _, err = TestRun(s, nil, exec); err == nil {
+		t.Fatal("want error when task depends on a later wave")
+	}
+}
+
+func TestCatalogMetrics(t *testing.T) {
+	g = DefaultGlobal()
+	sl := g.Schedules
+	sl.ScheduleList = []*Schedule{
+		{Id: 2, Name: `a "b"`, Cyc: "d", Group: "dw", Status: 1},
+		{Id: 1, Name: "c", Cyc: "h"},
+		{Id: 3, Name: "d"},
+	}
+
+	var buf bytes.Buffer
+	if err := sl.WriteCatalogMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP hivego_schedule_info Schedule catalog, the value is always 1.
+# TYPE hivego_schedule_info gauge
+hivego_schedule_info{id="1",name="c",cycle="h",group="",state="enabled"} 1
+hivego_schedule_info{id="2",name="a \"b\"",cycle="d",group="dw",state="paused"} 1
+hivego_schedule_info{id="3",name="d",cycle="",group="",state="disabled"} 1
+`
+	if buf.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+//blockExecutor执行名称在block中的任务时一直等待，直到release关闭
+type blockExecutor struct {
+	SyncExecutor
+	block   map[string]bool
+	release chan struct{}
+}
+
+func (be *blockExecutor) Run(task *Task, reply *Reply) error {
+	if be.block[task.Name] {
+		<-be.release
+	}
+	return be.SyncExecutor.Run(task, reply)
+}
+
+func TestTimeOut(t *testing.T) {
+	g = DefaultGlobal()
+	g.NoLog = true
+	s := newTestSchedule()
+	s.SoftTimeOut, s.TimeOut = 1, 2
+
+	//b在超时中止时才结束
+	exec := &killExecutor{killed: make(chan struct{})}
+
+	start := time.Now()
+	r, err := TestRun(s, nil, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 2*time.Second || d > 4*time.Second {
+		t.Fatalf("want cancel after 2s, got %s", d)
+	}
+	if r.State != 4 || r.Success() {
+		t.Fatalf("want cancelled run, got %+v", r)
+	}
+	//b、c均依赖a，c完成而b仍在执行，d不会开始
+	if len(r.Tasks) != 2 {
+		t.Fatalf("want a and c done before cancel, got %+v", r.Tasks)
+	}
+}
+
+//killExecutor执行b时等待Abort，被中止后返回错误信息
+type killExecutor struct {
+	SyncExecutor
+	killed chan struct{}
+}
+
+func (ke *killExecutor) Run(task *Task, reply *Reply) error {
+	if task.Name == "b" {
+		<-ke.killed
+		reply.Err = "killed"
+		return nil
+	}
+	return ke.SyncExecutor.Run(task, reply)
+}
+
+func (ke *killExecutor) Abort(task *Task) error {
+	close(ke.killed)
+	return nil
+}
+
+func TestTimeOutAbort(t *testing.T) {
+	g = DefaultGlobal()
+	//被中止的任务结束后才能结束测试，避免其使用之后测试的全局配置
+	drained := &signalWriter{match: "of cancelled batchTaskId", c: make(chan struct{})}
+	g.L.Out = drained
+	g.NoLog = true
+	g.Executor = &killExecutor{killed: make(chan struct{})}
+
+	s := newTestSchedule()
+	s.TimeOut = 1
+	es := ExecScheduleWarper(s)
+	es.execType = 2
+	g.Schedules.AddExecSchedule(es)
+	if err := es.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	tasks := make(map[string]*ExecTask)
+	for _, et := range es.execTasks {
+		tasks[et.task.Name] = et
+	}
+	end := make(chan struct{})
+	go func() {
+		es.Run()
+		close(end)
+	}()
+	for _, c := range []chan struct{}{end, drained.c} {
+		select {
+		case <-c:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out run did not end")
+		}
+	}
+
+	//b正在执行时被通知中止，d尚未开始，均记录为超时中止；a、c自然结束
+	for name, want := range map[string]bool{"a": false, "b": true, "c": false, "d": true} {
+		if et := tasks[name]; et.isTimedOut() != want || want && (et.state != 4 || et.getTimeoutLevel() != TimeoutSchedule) {
+			t.Fatalf("task %s want timed out %v, got %v state %d level %q", name, want, et.isTimedOut(), et.state, et.getTimeoutLevel())
+		}
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	start := time.Date(2015, 1, 1, 0, 0, 0, 0, time.Local)
+	tl := newTimeline([]JournalEntry{
+		{BatchId: "b1", Seq: 1, Time: start, Type: EventRunStart, State: 1},
+		{BatchId: "b1", Seq: 2, Time: start.Add(time.Second), Type: JournalTaskStart, TaskId: 1, TaskName: "a", Attempt: 1, State: 1},
+		{BatchId: "b1", Seq: 3, Time: start.Add(2 * time.Second), Type: JournalTaskRetry, TaskId: 1, TaskName: "a", Attempt: 1, State: 4},
+		{BatchId: "b1", Seq: 4, Time: start.Add(3 * time.Second), Type: EventRunFail, State: 1, Message: "timeout"},
+	})
+	if tl.State != 4 || !tl.StartTime.Equal(start) || tl.EndTime.Sub(tl.StartTime) != 3*time.Second {
+		t.Fatalf("bad timeline %+v", tl)
+	}
+	if out := tl.String(); !strings.Contains(out, "task_retry task [1 a] attempt=1 state=4") {
+		t.Fatalf("retry not in output\n%s", out)
+	}
+
+	//没有结束记录的批次
+	tl = newTimeline(tl.Entries[:2])
+	if tl.State != 1 || !tl.EndTime.IsZero() {
+		t.Fatalf("want unfinished timeline, got %+v", tl)
+	}
+}
+
+func TestJobTimeOut(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.NoLog = true
+
+	//作业2限时1秒，b在超时后才结束，依赖b的c尚未开始即被中止，依赖c的d暂停
+	s := newTestSchedule()
+	s.Jobs[1].TimeOut = 1
+	s.Tasks[2].RelTasks = map[string]*Task{"2": s.Tasks[1]}
+	exec := &blockExecutor{block: map[string]bool{"b": true}, release: make(chan struct{})}
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		close(exec.release)
+	}()
+
+	start := time.Now()
+	r, err := TestRun(s, nil, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Fatalf("run ends before job timeout, %s", d)
+	}
+
+	states := make(map[string]int8)
+	for _, tr := range r.Tasks {
+		states[tr.Name] = tr.State
+		if tr.Name == "c" && !strings.Contains(tr.Output, "job timeout") {
+			t.Fatalf("want job timeout output for c, got %q", tr.Output)
+		}
+	}
+	if states["a"] != 3 || states["b"] != 3 || states["c"] != 4 || states["d"] != 2 {
+		t.Fatalf("unexpected task states %v", states)
+	}
+	if r.State != 3 || r.FailTaskCnt != 2 || len(exec.Order) != 2 {
+		t.Fatalf("want run finished with 2 failed tasks, got %+v order %v", r, exec.Order)
+	}
+}
+
+func TestJobTimeOutAbort(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.NoLog = true
+
+	//作业2限时1秒，正在执行的b被通知中止，依赖b的c尚未开始，均记录为作业超时中止
+	s := newTestSchedule()
+	s.Jobs[1].TimeOut = 1
+	s.Tasks[2].RelTasks = map[string]*Task{"2": s.Tasks[1]}
+	exec := &killExecutor{killed: make(chan struct{})}
+
+	start := time.Now()
+	r, err := TestRun(s, nil, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < time.Second || d > 3*time.Second {
+		t.Fatalf("want b aborted after 1s, got %s", d)
+	}
+
+	results := make(map[string]TaskResult)
+	for _, tr := range r.Tasks {
+		results[tr.Name] = tr
+	}
+	for _, name := range []string{"b", "c"} {
+		if tr := results[name]; tr.State != 4 || !tr.TimedOut || tr.TimeoutLevel != TimeoutJob {
+			t.Fatalf("want %s aborted by job timeout, got %+v", name, tr)
+		}
+	}
+	if a := results["a"]; a.State != 3 || a.TimedOut || a.TimeoutLevel != "" {
+		t.Fatalf("unexpected result of a %+v", a)
+	}
+	//依赖c的d暂停，其它作业继续执行，批次正常结束
+	if d := results["d"]; d.State != 2 || d.TimedOut {
+		t.Fatalf("unexpected result of d %+v", d)
+	}
+	if r.State != 3 || r.FailTaskCnt != 3 {
+		t.Fatalf("want run finished with 3 failed tasks, got %+v", r)
+	}
+}
+
+//slowExecutor每个任务执行前等待一段时间，使执行与调度的重新初始化交错
+type slowExecutor struct {
+	SyncExecutor
+}
+
+func (se *slowExecutor) Run(task *Task, reply *Reply) error {
+	time.Sleep(5 * time.Millisecond)
+	return se.SyncExecutor.Run(task, reply)
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.NoLog = true
+	g.Executor = &SyncExecutor{}
+	s := newTestSchedule()
+	g.Schedules.ScheduleList = append(g.Schedules.ScheduleList, s)
+
+	events, cancel := g.Schedules.Subscribe()
+	defer cancel()
+	es := ExecScheduleWarper(s)
+	es.execType = 2
+	if err := es.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	g.Schedules.AddExecSchedule(es)
+	es.Run()
+
+	cnt := make(map[EventType]int)
+	started := make(map[int64]bool)
+	for len(events) > 0 {
+		ev := <-events
+		cnt[ev.Type]++
+		switch ev.Type {
+		case EventTaskStart:
+			started[ev.TaskId] = true
+		case EventTaskDone:
+			if !started[ev.TaskId] || ev.JobId == 0 {
+				t.Fatalf("task done before start or without job id %+v", ev)
+			}
+		case EventJobStart:
+			if ev.JobId == 0 || ev.Time.IsZero() {
+				t.Fatalf("bad job event %+v", ev)
+			}
+		}
+	}
+	if cnt[EventRunStart] != 1 || cnt[EventJobStart] != 3 || cnt[EventTaskStart] != 4 || cnt[EventTaskDone] != 4 || cnt[EventRunEnd] != 1 {
+		t.Fatalf("unexpected events %v", cnt)
+	}
+
+	//通道已满时按策略丢弃或排队，发布不等待订阅者
+	publish := func(policy string) []int8 {
+		b := newEventBus()
+		ch, cancel := b.subscribe(1, policy)
+		defer cancel()
+		for i := 1; i <= 3; i++ {
+			b.publish(Event{State: int8(i)})
+		}
+		got := make([]int8, 0)
+		for {
+			select {
+			case ev := <-ch:
+				got = append(got, ev.State)
+			case <-time.After(50 * time.Millisecond):
+				return got
+			}
+		}
+	}
+	if got := publish(EventOverflowDrop); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("drop policy want [1], got %v", got)
+	}
+	if got := publish(EventOverflowDropOldest); len(got) != 1 || got[0] != 3 {
+		t.Fatalf("drop_oldest policy want [3], got %v", got)
+	}
+	if got := publish(EventOverflowBuffer); len(got) != 3 || got[0] != 1 || got[2] != 3 {
+		t.Fatalf("buffer policy want [1 2 3], got %v", got)
+	}
+
+	//排队发送中取消订阅，通道被关闭
+	b := newEventBus()
+	ch, cancel2 := b.subscribe(1, EventOverflowBuffer)
+	b.publish(Event{})
+	b.publish(Event{})
+	cancel2()
+	for range ch {
+	}
+}
+
+//执行期间模拟InitSchedule重建调度链，执行中的批次应使用初始化时的快照。
+//使用go test -race运行可检查两者间的数据竞争。
+func TestExecSnapshot(t *testing.T) {
+	g = DefaultGlobal()
+	g.NoLog = true
+	exec := &slowExecutor{}
+	g.Executor = exec
+	s := newTestSchedule()
+	g.Schedules.ScheduleList = append(g.Schedules.ScheduleList, s)
+
+	events, cancel := g.Schedules.Subscribe()
+	defer cancel()
+
+	es := ExecScheduleWarper(s)
+	es.execType = 2
+	if err := es.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	g.Schedules.AddExecSchedule(es)
+	end := make(chan struct{})
+	go func() {
+		es.Run()
+		close(end)
+	}()
+
+	//按InitSchedule的方式原地重建调度链
+	for i := 0; i < 20; i++ {
+		ns := newTestSchedule()
+		s.Name, s.Job, s.Jobs, s.Tasks, s.TaskCnt = "reinit", ns.Job, ns.Jobs, ns.Tasks, ns.TaskCnt
+		for _, task := range s.Tasks {
+			task.Name = task.Name + "x"
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for ended := false; !ended; {
+		select {
+		case ev := <-events:
+			if ev.Type == EventRunFail {
+				t.Fatalf("run fail %s", ev.Message)
+			}
+			ended = ev.Type == EventRunEnd
+		case <-time.After(5 * time.Second):
+			t.Fatal("run is not finished")
+		}
+	}
+
+	//发布EventRunEnd后Run仍在使用全局配置，返回后再结束测试
+	select {
+	case <-end:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run is not returned")
+	}
+	exec.lock.Lock()
+	defer exec.lock.Unlock()
+	if len(exec.Order) != 4 || exec.Order[0] != "a" || exec.Order[3] != "d" {
+		t.Fatalf("run should use the snapshot, got order %v", exec.Order)
+	}
+}
+
+func TestMaxTasksPerRun(t *testing.T) {
+	g = DefaultGlobal()
+	g.NoLog = true
+	exec := &SyncExecutor{}
+	g.Executor = exec
+	g.MaxTasksPerRun = 3
+
+	events, cancel := g.Schedules.Subscribe()
+	defer cancel()
+
+	es := ExecScheduleWarper(newTestSchedule())
+	es.execType = 2
+	if err := es.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	es.Run()
+
+	if es.state != 4 || len(exec.Order) != 0 {
+		t.Fatalf("want run rejected before dispatch, got state %d order %v", es.state, exec.Order)
+	}
+	if ev := <-events; ev.Type != EventRunFail || !strings.Contains(ev.Message, "exceeds the limit 3") {
+		t.Fatalf("want run_fail event, got %+v", ev)
+	}
+}
+
+//addrExecutor在任务的执行地址属于down时返回发送失败，记录每次发送的地址
+type addrExecutor struct {
+	lock  sync.Mutex
+	down  map[string]bool
+	addrs []string
+}
+
+func (ae *addrExecutor) Run(task *Task, reply *Reply) error {
+	ae.lock.Lock()
+	defer ae.lock.Unlock()
+	ae.addrs = append(ae.addrs, task.Address)
+	if ae.down[task.Address] {
+		return errors.New("connect " + task.Address + " refused")
+	}
+	return nil
+}
+
+func TestWorkerSelector(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	sl := g.Schedules
+	g.WorkerSelector = LeastLoadedSelector{}
+	if err := sl.UpdateWorkers([]string{"w1", "w2", "w3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	//选择执行中任务最少的Worker，数量相同时选择靠前的Worker
+	got := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		w, err := sl.workers.acquire(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, w)
+	}
+	sl.workers.release("w2")
+	w, _ := sl.workers.acquire(nil)
+	if strings.Join(got, ",") != "w1,w2,w3" || w != "w2" {
+		t.Fatalf("want least loaded w1,w2,w3 then w2, got %v %s", got, w)
+	}
+	for _, w := range []string{"w1", "w2", "w2", "w3"} {
+		sl.workers.release(w)
+	}
+
+	//发送失败的Worker被标记为不可用，之后的任务跳过它
+	g.WorkerSelector = &RoundRobinSelector{}
+	exec := &addrExecutor{down: map[string]bool{"w1": true}}
+	g.Executor = exec
+	task := &Task{Id: 1, Name: "a"}
+	et := &ExecTask{task: task}
+	if err := et.execute(task, &Reply{}); err == nil {
+		t.Fatal("want dispatch error on w1")
+	}
+	for i := 0; i < 4; i++ {
+		if err := et.execute(task, &Reply{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if down := sl.DownWorkers(); len(down) != 1 || down[0] != "w1" {
+		t.Fatalf("want w1 down, got %v", down)
+	}
+	for _, a := range exec.addrs[1:] {
+		if a == "w1" {
+			t.Fatalf("down worker is selected, addrs %v", exec.addrs)
+		}
+	}
+
+	//全部Worker不可用时立即返回error，不等待
+	exec.down["w2"], exec.down["w3"] = true, true
+	et.execute(task, &Reply{})
+	et.execute(task, &Reply{})
+	err := et.execute(task, &Reply{})
+	if err == nil || !strings.Contains(err.Error(), "all 3 workers are down") {
+		t.Fatalf("want all workers down error, got %v", err)
+	}
+
+	//超过WorkerRecheck后重新参与分配
+	g.WorkerRecheck = 0
+	if w, err := sl.workers.acquire(nil); err != nil || w == "" {
+		t.Fatalf("want worker after recheck, got %q %v", w, err)
+	}
+
+	if _, err := NewWorkerSelector("sticky"); err == nil {
+		t.Fatal("want error for unknown selector")
+	}
+}
+
+func TestUpdateWorkers(t *testing.T) {
+	g = DefaultGlobal()
+	sl := g.Schedules
+
+	if w, _ := sl.workers.acquire(nil); w != "" {
+		t.Fatalf("empty pool should not select worker, got %s", w)
+	}
+
+	if err := sl.UpdateWorkers([]string{"w1", "w2", "w1"}); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := sl.workers.acquire(nil)
+	b, _ := sl.workers.acquire(nil)
+	if a != "w1" || b != "w2" {
+		t.Fatalf("want round robin w1 w2, got %s %s", a, b)
+	}
+
+	//w1仍有任务在执行，移除后进入排空状态，不再分配任务
+	sl.workers.release(b)
+	if err := sl.UpdateWorkers([]string{"w2", "w3"}); err != nil {
+		t.Fatal(err)
+	}
+	if active, draining := sl.Workers(); len(active) != 2 || len(draining) != 1 || draining[0] != "w1" {
+		t.Fatalf("want w1 draining, got active %v draining %v", active, draining)
+	}
+	for i := 0; i < 4; i++ {
+		w, _ := sl.workers.acquire(nil)
+		if w == "w1" {
+			t.Fatal("draining worker should not be selected")
+		}
+		sl.workers.release(w)
+	}
+
+	sl.workers.release(a)
+	if _, draining := sl.Workers(); len(draining) != 0 {
+		t.Fatalf("w1 should be drained, got %v", draining)
+	}
+
+	if err := sl.UpdateWorkers([]string{""}); err == nil {
+		t.Fatal("want error for empty address")
+	}
+}
+
+func TestFileLoggerFactory(t *testing.T) {
+	g = DefaultGlobal()
+	dir, err := ioutil.TempDir("", "hivego")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g.LoggerFactory = FileLoggerFactory(g.L, dir, LogRouteGroup)
+	s1 := &Schedule{Id: 1, Name: "s1", Group: "dw"}
+	s2 := &Schedule{Id: 2, Name: "s2", Group: "dw"}
+	s3 := &Schedule{Id: 3, Name: "s3"}
+	if s1.logger() != s2.logger() || s1.logger() == g.L {
+		t.Fatal("schedules in the same group should share a dedicated logger")
+	}
+	if s3.logger() != g.L {
+		t.Fatal("schedule without group should use the shared logger")
+	}
+
+	s1.logger().Infoln("hello dw")
+	b, err := ioutil.ReadFile(filepath.Join(dir, "group_dw.log"))
+	if err != nil || !strings.Contains(string(b), "hello dw") {
+		t.Fatalf("log is not written to group file, err %v content %q", err, b)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	local := time.Local
+	time.Local = loc
+	defer func() { time.Local = local }()
+
+	at := func(s string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	for _, c := range []struct {
+		expr, from, want string
+	}{
+		{"cron:*/15 * * * 1-5", "2015-01-02 23:50", "2015-01-05 00:00"},
+		{"0 9 1 * 1", "2015-06-01 10:00", "2015-06-08 09:00"},  //日与周满足其一即可
+		{"0 0 * * 7", "2015-01-01 00:00", "2015-01-04 00:00"},  //7表示周日
+		{"30 2 * * *", "2015-03-08 00:00", "2015-03-09 02:30"}, //夏令时开始，02:30不存在
+	} {
+		spec, err := ParseCronSpec(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := spec.Next(at(c.from)); !next.Equal(at(c.want)) {
+			t.Errorf("cron [%s] from %s want %s, got %s", c.expr, c.from, c.want, next)
+		}
+	}
+
+	//夏令时结束，01:30出现两次，只在第一次触发
+	spec, _ := ParseCronSpec("30 1 * * *")
+	first := spec.Next(at("2015-11-01 00:00"))
+	if _, offset := first.Zone(); first.Hour() != 1 || offset != -4*3600 {
+		t.Fatalf("want first 01:30 EDT, got %s", first)
+	}
+	if next := spec.Next(first); next.Day() != 2 {
+		t.Fatalf("repeated 01:30 should fire once, got %s", next)
+	}
+
+	for _, expr := range []string{"61 * * * *", "* * * *", "5-1 * * * *", "*/0 * * * *"} {
+		if _, err := ParseCronSpec(expr); err == nil {
+			t.Errorf("cron [%s] should be invalid", expr)
+		}
+	}
+	if _, err := ParseCron("0 0 30 2 *"); err == nil {
+		t.Error("cron on Feb 30 should never fire")
+	}
+}
+
+func TestSortStart(t *testing.T) {
+	//同一启动时间出现两次，排序后应成对保持一致
+	s := &Schedule{
+		StartMonth:  []int{2, 0, 1, 0, 2, 1, 0},
+		StartSecond: []time.Duration{5, 30, 10, 5, 1, 10, 20},
+	}
+	s.sortStart()
+	wantMonth := []int{0, 0, 0, 1, 1, 2, 2}
+	wantSecond := []time.Duration{5, 20, 30, 10, 10, 1, 5}
+	if !reflect.DeepEqual(s.StartMonth, wantMonth) || !reflect.DeepEqual(s.StartSecond, wantSecond) {
+		t.Fatalf("want %v %v, got %v %v", wantMonth, wantSecond, s.StartMonth, s.StartSecond)
+	}
+
+	//长度不一致时只排序成对的部分
+	s = &Schedule{StartMonth: []int{1, 0, 0}, StartSecond: []time.Duration{3, 2}}
+	s.sortStart()
+	if !reflect.DeepEqual(s.StartMonth, []int{0, 1, 0}) || !reflect.DeepEqual(s.StartSecond, []time.Duration{2, 3}) {
+		t.Fatalf("mismatched starts sorted to %v %v", s.StartMonth, s.StartSecond)
+	}
+
+	g = DefaultGlobal()
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+
+	s = &Schedule{Name: "start", Cyc: "y",
+		StartMonth:  []int{1, 0, 1},
+		StartSecond: []time.Duration{2 * time.Hour, 3 * time.Hour, time.Hour}}
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddScheduleStart(); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query("SELECT scd_start, scd_start_month FROM scd_start WHERE scd_id=? ORDER BY rowid", s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	got := make([]string, 0)
+	for rows.Next() {
+		var sec, month int
+		if err := rows.Scan(&sec, &month); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%d:%d", month, sec))
+	}
+	if want := []string{"0:10800", "1:3600", "1:7200"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("persisted starts want %v, got %v", want, got)
+	}
+
+	s.StartSecond = s.StartSecond[:1]
+	if err := s.AddScheduleStart(); err == nil {
+		t.Fatal("mismatched starts should not be persisted")
+	}
+}
+
+func TestStopListener(t *testing.T) {
+	g = DefaultGlobal()
+	g.NoLog = true
+
+	done := make(chan bool)
+	for i := 0; i < 3; i++ {
+		s := &Schedule{Id: int64(i), Name: "stop", Enabled: true, Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{0}}
+		go func() {
+			s.Timer()
+			done <- true
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	g.Schedules.StopListener()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("timer did not return after StopListener")
+		}
+	}
+
+	//停止后启动的Timer直接退出
+	s := &Schedule{Id: 9, Name: "stop", Enabled: true, Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{0}}
+	go func() {
+		s.Timer()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timer started after StopListener")
+	}
+}
+
+func TestListSchedules(t *testing.T) {
+	g = DefaultGlobal()
+	sl := g.Schedules
+	for _, s := range []*Schedule{{Id: 3, Name: "c", Enabled: true}, {Id: 1, Name: "a", Enabled: true}, {Id: 2, Name: "b", Enabled: true, Status: 1}, {Id: 4, Name: "d", Enabled: true}} {
+		sl.ScheduleList = append(sl.ScheduleList, s)
+	}
+	sl.AddExecSchedule(&ExecSchedule{batchId: "b1", schedule: sl.ScheduleList[0]})
+	sl.setRunResult(4, runResult{failed: true})
+
+	infos := sl.ListSchedules()
+	want := []ScheduleStatus{StatusIdle, StatusPaused, StatusRunning, StatusError}
+	for i, info := range infos {
+		if info.Id != int64(i+1) || info.Status != want[i] {
+			t.Fatalf("schedule %d want id %d status %s, got %+v", i, i+1, want[i], info)
+		}
+	}
+
+	//批次执行结束后恢复为idle
+	sl.RemoveExecSchedule("b1")
+	sl.setRunResult(4, runResult{})
+	for _, id := range []int64{3, 4} {
+		if st, err := sl.GetScheduleStatus(id); err != nil || st != StatusIdle {
+			t.Fatalf("schedule %d want idle, got %s %v", id, st, err)
+		}
+	}
+	if _, err := sl.GetScheduleStatus(9); err == nil {
+		t.Fatal("want error for unknown schedule")
+	}
+}
+
+//与go test -race一起运行，检查调度列表的并发读写
+func TestScheduleListRace(t *testing.T) {
+	g = DefaultGlobal()
+	sl := g.Schedules
+	for i := 0; i < 50; i++ {
+		sl.ScheduleList = append(sl.ScheduleList, &Schedule{Id: int64(i), Name: fmt.Sprintf("s%d", i)})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(3)
+		go func(id int64) { //启动
+			defer wg.Done()
+			batchId := fmt.Sprintf("b%d", id)
+			if s := sl.GetScheduleById(id); s != nil {
+				sl.AddExecSchedule(&ExecSchedule{batchId: batchId, schedule: s})
+				sl.RemoveExecSchedule(batchId)
+			}
+		}(int64(i))
+		go func(id int64) { //查询
+			defer wg.Done()
+			sl.ListSchedules()
+			sl.GetScheduleStatus(id)
+			for _, s := range sl.AllSchedules() {
+				_ = s.Name
+			}
+		}(int64(i))
+		go func(id int64) { //删除
+			defer wg.Done()
+			sl.removeSchedule(id)
+		}(int64(i))
+	}
+	wg.Wait()
+
+	if n := len(sl.AllSchedules()); n != 0 {
+		t.Fatalf("want all schedules removed, got %d", n)
+	}
+}
+
+func TestCheckOverlap(t *testing.T) {
+	g = DefaultGlobal()
+	s := &Schedule{Id: 1, Name: "overlap"}
+	g.Schedules.AddExecSchedule(&ExecSchedule{batchId: "b1", schedule: s, execType: 1, done: make(chan struct{})})
+
+	ctx := context.Background()
+	if _, ok := s.checkOverlap(ctx); ok {
+		t.Fatal("default policy should skip while the previous batch is running")
+	}
+	s.Overlap = OverlapAllow
+	if queued, ok := s.checkOverlap(ctx); !ok || queued {
+		t.Fatalf("allow policy want start without queue, got queued=%v ok=%v", queued, ok)
+	}
+
+	s.Overlap = OverlapQueue
+	type result struct{ queued, ok bool }
+	c := make(chan result)
+	go func() {
+		queued, ok := s.checkOverlap(ctx)
+		c <- result{queued, ok}
+	}()
+	select {
+	case <-c:
+		t.Fatal("queue policy should wait for the previous batch")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	//上一批次结束后启动，由上一批次设置下次执行时间
+	g.Schedules.RemoveExecSchedule("b1")
+	select {
+	case r := <-c:
+		if !r.ok || !r.queued {
+			t.Fatalf("want queued start, got %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued start did not happen after the previous batch ended")
+	}
+}
+
+func TestParallelGroup(t *testing.T) {
+	g = DefaultGlobal()
+
+	//j2、j3组成并行组1，j4需等待组1结束；j1未分组，不阻塞之后的作业
+	s := &Schedule{Id: 1, Name: "group", Jobs: make([]*Job, 0), Tasks: make([]*Task, 0)}
+	var pj *Job
+	for i, pg := range []int{0, 1, 1, 0} {
+		j := &Job{Id: int64(i + 1), ParallelGroup: pg, Tasks: make(map[string]*Task)}
+		task := &Task{Id: int64(i + 1), Name: fmt.Sprintf("t%d", i+1), Cmd: "echo", JobId: j.Id}
+		j.Tasks[task.Name] = task
+		s.addTaskList(task)
+		if pj == nil {
+			s.Job = j
+		} else {
+			pj.NextJob = j
+		}
+		s.Jobs = append(s.Jobs, j)
+		pj = j
+	}
+
+	//t4之前执行的组1任务数量
+	before := func(order []string) int {
+		n := 0
+		for _, name := range order {
+			switch name {
+			case "t2", "t3":
+				n++
+			case "t4":
+				return n
+			}
+		}
+		return -1
+	}
+
+	exec := &SyncExecutor{}
+	r, err := TestRun(s, nil, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Success() || len(exec.Order) != 4 || before(exec.Order) != 2 {
+		t.Fatalf("t4 should start after parallel group 1, got %+v order %v", r, exec.Order)
+	}
+
+	//组内同时只执行一个作业，先执行的任务失败后组内其余任务不再执行
+	g.MaxParallelJobs, g.GroupFailPolicy = 1, GroupFailAbort
+	exec = &SyncExecutor{Fail: map[string]string{"t2": "boom", "t3": "boom"}}
+	if r, err = TestRun(s, nil, exec); err != nil {
+		t.Fatal(err)
+	}
+	if len(exec.Order) != 3 || r.FailTaskCnt != 2 || before(exec.Order) != 1 {
+		t.Fatalf("want one task of group 1 executed, got %+v order %v", r, exec.Order)
+	}
+
+	//同一并行组的作业不连续
+	s.Jobs[2].ParallelGroup, s.Jobs[3].ParallelGroup = 0, 1
+	if _, err = TestRun(s, nil, exec); err == nil {
+		t.Fatal("want error when jobs of a parallel group are not adjacent")
+	}
+}
+
+func TestDetectCycles(t *testing.T) {
+	g = DefaultGlobal()
+	s := newTestSchedule()
+	for _, j := range s.Jobs {
+		if err := j.DetectCycles(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//a依赖d，形成a -> d -> b -> a
+	a, d := s.Tasks[0], s.Tasks[3]
+	if err := a.AddRelTask(d); err == nil {
+		t.Fatal("want error when the relation makes a cycle")
+	}
+	a.RelTasks = map[string]*Task{"4": d}
+	err := s.Jobs[0].DetectCycles()
+	if err == nil || !strings.Contains(err.Error(), "a -> d -> b -> a") || !IsInvalid(err) {
+		t.Fatalf("want cycle a -> d -> b -> a, got %v", err)
+	}
+}
+
+func TestRelCondition(t *testing.T) {
+	g = DefaultGlobal()
+	s := newTestSchedule()
+	a, b, c := s.Tasks[0], s.Tasks[1], s.Tasks[2]
+
+	//b在a失败时执行，c总是执行，d依赖b、c
+	b.RelConditions = map[int64]string{a.Id: RelOnFailure}
+	c.RelConditions = map[int64]string{a.Id: RelAlways}
+
+	//a成功：b被跳过，d的上级b被跳过，d也被跳过
+	exec := &SyncExecutor{}
+	r, err := TestRun(s, nil, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Success() || r.SuccessTaskCnt != 2 || r.SkipTaskCnt != 2 || len(exec.Order) != 2 {
+		t.Fatalf("want a, c executed and b, d skipped, got %+v order %v", r, exec.Order)
+	}
+	for _, tr := range r.Tasks {
+		if (tr.Name == "b" || tr.Name == "d") && tr.State != 6 {
+			t.Fatalf("task %s want state 6, got %d", tr.Name, tr.State)
+		}
+	}
+
+	//a失败：b、c执行，d执行
+	exec = &SyncExecutor{Fail: map[string]string{"a": "error"}}
+	r, err = TestRun(s, nil, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.FailTaskCnt != 1 || r.SuccessTaskCnt != 3 || r.SkipTaskCnt != 0 || len(exec.Order) != 4 {
+		t.Fatalf("want b, c, d executed after a failed, got %+v order %v", r, exec.Order)
+	}
+
+	//未知的执行条件
+	if err := c.AddRelTaskOn(b, "maybe"); err == nil {
+		t.Fatal("want error for unknown condition")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	//未设置Registry时不记录指标
+	g = DefaultGlobal()
+	es := &ExecSchedule{schedule: &Schedule{Id: 1}, state: 3, startTime: time.Now().Add(-time.Second)}
+	es.observe()
+	if g.metrics() != nil {
+		t.Fatal("metrics should be disabled without registry")
+	}
+
+	reg := prometheus.NewRegistry()
+	g = DefaultGlobal()
+	g.Registry = reg
+	es.observe()
+	g.metrics().TaskStart()
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+	for _, n := range []string{"schedule_runs_total", "schedule_duration_seconds", "tasks_running"} {
+		if !names[n] {
+			t.Fatalf("metric %s is not registered, got %v", n, names)
+		}
+	}
+}
+
+func TestLogFields(t *testing.T) {
+	g = DefaultGlobal()
+	var buf bytes.Buffer
+	g.L.Out = &buf
+	if err := SetLogFormat(g.L, "xml"); err == nil {
+		t.Fatal("want error for unknown log format")
+	}
+	if err := SetLogFormat(g.L, LogFormatJSON); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := TestRun(newTestSchedule(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//任务结束的日志附带调度、批次、作业、任务的ID，批次结束时输出一条执行摘要
+	found, summaries := false, 0
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("log line is not json %q: %s", line, err)
+		}
+		msg, _ := m["msg"].(string)
+		if msg == RunSummaryMessage {
+			summaries++
+			if m[LogFieldRun] != r.BatchId || m[LogFieldSchedule] != float64(1) || m[LogFieldTaskCount] != float64(4) ||
+				m[LogFieldSucceeded] != float64(4) || m[LogFieldFailed] != float64(0) || m[LogFieldSkipped] != float64(0) ||
+				m[LogFieldOutcome] != "success" || m[LogFieldDuration] == nil {
+				t.Fatalf("bad summary %v", m)
+			}
+		}
+		if !strings.Contains(msg, "task d is end") {
+			continue
+		}
+		found = true
+		if m[LogFieldSchedule] != float64(1) || m[LogFieldRun] != r.BatchId ||
+			m[LogFieldJob] != float64(3) || m[LogFieldTask] != float64(4) {
+			t.Fatalf("bad fields %v", m)
+		}
+	}
+	if !found {
+		t.Fatalf("end log of task d not found in %s", buf.String())
+	}
+	if summaries != 1 {
+		t.Fatalf("want 1 run summary, got %d", summaries)
+	}
+
+	//执行中panic时恢复后仍输出摘要，结果为error
+	buf.Reset()
+	es := ExecScheduleWarper(newTestSchedule())
+	es.state = 1
+	func() {
+		defer es.summarize()
+		defer es.recoverRun()
+		panic("injected")
+	}()
+	if es.state != 4 || !strings.Contains(buf.String(), `"outcome":"error"`) || !strings.Contains(buf.String(), RunSummaryMessage) {
+		t.Fatalf("want error summary after panic, got %s", buf.String())
+	}
+}
+
+func TestMisfire(t *testing.T) {
+	g = DefaultGlobal()
+	now := time.Now()
+	next, countDown := now.Add(time.Hour), time.Hour
+
+	//没有保存的启动时间时按周期启动
+	s := &Schedule{Id: 1, Name: "misfire"}
+	if n, c := s.misfire(next, countDown); !n.Equal(next) || c != countDown {
+		t.Fatalf("no saved start: got %s %s", n, c)
+	}
+
+	//保存的启动时间未到，按它恢复
+	s.NextStart = now.Add(10 * time.Minute)
+	if n, c := s.misfire(next, countDown); !n.Equal(s.NextStart) || c <= 0 || c > 10*time.Minute {
+		t.Fatalf("future saved start: got %s %s", n, c)
+	}
+
+	//错过了启动时间，默认等待下一周期
+	s.NextStart = now.Add(-10 * time.Minute)
+	if n, c := s.misfire(next, countDown); !n.Equal(next) || c != countDown {
+		t.Fatalf("skip policy: got %s %s", n, c)
+	}
+
+	//错过了启动时间，立即启动
+	s.Misfire = MisfireRun
+	if _, c := s.misfire(next, countDown); c != 0 {
+		t.Fatalf("run policy: want start now, got %s", c)
+	}
+
+	//按间隔调度时补齐错过的启动，超过MisfireLimit的部分跳过
+	s.Cyc, s.Misfire = "interval:1m", MisfireCatchUp
+	s.NextStart = now.Add(-150 * time.Second)
+	if _, c := s.misfire(next, countDown); c != 0 || s.catchUp != 2 {
+		t.Fatalf("catchup policy: want start now and catch up 2 more, got %s %d", c, s.catchUp)
+	}
+	g.MisfireLimit = 2
+	if s.misfire(next, countDown); s.catchUp != 1 {
+		t.Fatalf("catchup over limit: want catch up 1 more, got %d", s.catchUp)
+	}
+}
+
+func TestIntervalCycle(t *testing.T) {
+	g = DefaultGlobal()
+	created := time.Date(2015, 1, 2, 15, 0, 0, 0, time.Local)
+	clock := newFakeClock(time.Date(2015, 1, 3, 1, 0, 30, 0, time.Local))
+	g.Clock = clock
+
+	//首次启动为创建当日的01:00，之后每90分钟启动一次
+	s := &Schedule{Cyc: "interval:90m", CreateTime: created, StartMonth: []int{0}, StartSecond: []time.Duration{time.Hour}}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		advance time.Duration
+		want    time.Duration
+	}{
+		{0, 89*time.Minute + 30*time.Second},                //01:00:30 -> 02:30
+		{89*time.Minute + 30*time.Second, 90 * time.Minute}, //正好在启动时间，等待下一次
+		{100 * time.Minute, 80 * time.Minute},               //04:10 -> 05:30
+	} {
+		clock.Advance(c.advance)
+		if cd, err := s.countDown(); err != nil || cd != c.want {
+			t.Fatalf("at %s want %s, got %s %v", clock.Now(), c.want, cd, err)
+		}
+	}
+
+	//首次启动之前等待首次启动
+	s.CreateTime = clock.Now()
+	s.StartSecond[0] = 23 * time.Hour
+	want := s.intervalAnchor(time.Local).Sub(clock.Now())
+	if cd, _ := s.countDown(); cd != want {
+		t.Fatalf("before first start want %s, got %s", want, cd)
+	}
+
+	for _, cyc := range []string{"interval:", "interval:90", "interval:500ms", "interval:-1h"} {
+		s.Cyc = cyc
+		if err := s.Validate(); err == nil {
+			t.Errorf("cycle [%s] should be invalid", cyc)
+		}
+	}
+}
+
+func TestWorkerHealthCheck(t *testing.T) {
+	g = DefaultGlobal()
+	g.HealthTimeout = time.Second
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	up := ln.Addr().String()
+	ln2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := ln2.Addr().String()
+	ln2.Close()
+
+	//地址中已包含端口
+	g.Port = ""
+	if err := g.Schedules.UpdateWorkers([]string{down, up}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Schedules.WorkerHealthCheck(); err != nil {
+		t.Fatalf("want healthy, got %s", err)
+	}
+	g.Schedules.UpdateWorkers([]string{down})
+	if err := g.Schedules.WorkerHealthCheck(); err == nil {
+		t.Fatal("want error when all workers are down")
+	}
+
+	//跳过策略下不启动，推迟策略下在监听停止时退出
+	s := &Schedule{Id: 1, Name: "health", Enabled: true, Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{0}}
+	s.addTaskList(&Task{Id: 1, Name: "a", Address: up})
+	ctx, cancel := context.WithCancel(context.Background())
+	g.WorkerDownPolicy = WorkerDownSkip
+	if !s.waitWorkers(ctx, true) {
+		t.Fatal("task address is up, want start")
+	}
+	s.addTaskList(&Task{Id: 2, Name: "b"})
+	if s.waitWorkers(ctx, true) {
+		t.Fatal("pool workers are down, want skip")
+	}
+	g.WorkerDownPolicy, g.WorkerDelay = WorkerDownDelay, time.Hour
+	cancel()
+	if s.waitWorkers(ctx, true) {
+		t.Fatal("want no start after the listener is stopped")
+	}
+}
+
+//flakyDriver前fails次建立连接时返回连接错误，之后的查询返回一行一列的结果
+type flakyDriver struct {
+	lock  sync.Mutex
+	fails int
+	opens int
+}
+
+func (d *flakyDriver) Open(name string) (driver.Conn, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.opens++
+	if d.fails > 0 {
+		d.fails--
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	return flakyConn{}, nil
+}
+
+type flakyConn struct{}
+
+func (c flakyConn) Prepare(query string) (driver.Stmt, error) { return flakyStmt{}, nil }
+func (c flakyConn) Close() error                              { return nil }
+func (c flakyConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+type flakyStmt struct{}
+
+func (s flakyStmt) Close() error  { return nil }
+func (s flakyStmt) NumInput() int { return -1 }
+func (s flakyStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+func (s flakyStmt) Query(args []driver.Value) (driver.Rows, error) { return &flakyRows{}, nil }
+
+type flakyRows struct{ done bool }
+
+func (r *flakyRows) Columns() []string { return []string{"n"} }
+func (r *flakyRows) Close() error      { return nil }
+func (r *flakyRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done, dest[0] = true, int64(1)
+	return nil
+}
+
+func TestQueryHiveRetry(t *testing.T) {
+	d := &flakyDriver{}
+	sql.Register("hivego-flaky", d)
+	db, err := sql.Open("hivego-flaky", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	g = DefaultGlobal()
+	g.HiveConn, g.DBBackoff = db, time.Millisecond
+
+	//连接恢复前的失败会被重试
+	d.fails, g.DBMaxRetry = 2, 3
+	rows, err := queryHive(context.Background(), "SELECT 1")
+	if err != nil {
+		t.Fatalf("want success after retry, got %s", err)
+	}
+	var n int
+	for rows.Next() {
+		rows.Scan(&n)
+	}
+	rows.Close()
+	if n != 1 || d.opens != 3 {
+		t.Fatalf("want 1 after 3 opens, got %d after %d", n, d.opens)
+	}
+
+	//重试次数用完后返回error，不会退出进程
+	db.SetMaxIdleConns(0)
+	d.fails, d.opens, g.DBMaxRetry = 10, 0, 2
+	if _, err = queryHive(context.Background(), "SELECT 1"); err == nil || !isTransient(err) {
+		t.Fatalf("want connection error, got %v", err)
+	}
+	if d.opens != 3 {
+		t.Fatalf("want 3 attempts, got %d", d.opens)
+	}
+	if err = g.Schedules.InitScheduleList(); err == nil {
+		t.Fatal("want error from InitScheduleList")
+	}
+}
+
+//打开临时的sqlite元数据库
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "hive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile("../script/hive_sqlite.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(string(b)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+//openUpgradedDB按模板库hive_tp.db、log_tp.db的表结构建立升级前的数据库，再执行升级脚本
+func openUpgradedDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "hive.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"../hive_tp.db", "../log_tp.db"} {
+		tp, err := sql.Open("sqlite3", "file:"+f+"?mode=ro")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := tp.Query("SELECT sql FROM sqlite_master WHERE sql IS NOT NULL")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var stmt string
+			if err = rows.Scan(&stmt); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = db.Exec(stmt); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rows.Close()
+		tp.Close()
+	}
+	b, err := ioutil.ReadFile("../script/hive_sqlite_upgrade.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(string(b)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+//count返回表中的记录数
+func count(t *testing.T, db *sql.DB, table string) (n int) {
+	if err := db.QueryRow("SELECT count(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+//logTestRun在日志库中写入调度scdId的一个批次，开始后1分钟结束，批次中第i个任务的ID为i+1，状态为states[i]
+func logTestRun(t *testing.T, db *sql.DB, batchId string, scdId int64, start time.Time, state string, states ...string) {
+	if _, err := db.Exec(`INSERT INTO scd_schedule_log (batch_id, scd_id, start_time, end_time, state, result, batch_type)
+		VALUES (?, ?, ?, ?, ?, 1, '1')`, batchId, scdId, start, start.Add(time.Minute), state); err != nil {
+		t.Fatal(err)
+	}
+	for i, ts := range states {
+		if _, err := db.Exec(`INSERT INTO scd_task_log (batch_task_id, batch_job_id, batch_id, task_id, start_time, end_time, state, batch_type)
+			VALUES (?, ?, ?, ?, ?, ?, ?, '1')`, fmt.Sprintf("%s.%d", batchId, i+1), batchId, batchId, i+1,
+			start, start.Add(time.Minute), ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+//waitFor等待cond成立，超过5秒时测试失败
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition is not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestChainTx(t *testing.T) {
+	g = DefaultGlobal()
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+
+	s := &Schedule{Name: "tx", Cyc: "d"}
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	fail := func(table, op string) func() {
+		trigger := fmt.Sprintf("fail_%s_%s", table, op)
+		sql := fmt.Sprintf("CREATE TRIGGER %s BEFORE %s ON %s BEGIN SELECT RAISE(ABORT, 'injected'); END", trigger, op, table)
+		if _, err := db.Exec(sql); err != nil {
+			t.Fatal(err)
+		}
+		return func() { db.Exec("DROP TRIGGER " + trigger) }
+	}
+
+	//第一个作业：新增作业后更新调度失败
+	restore := fail("scd_schedule", "UPDATE")
+	if _, err := s.AddJob(&Job{Name: "j1"}); err == nil {
+		t.Fatal("want error when updating schedule fails")
+	}
+	if count(t, db, "scd_job") != 0 || len(s.Jobs) != 0 || s.JobId != 0 || s.Job != nil {
+		t.Fatalf("add job is not rolled back, jobs %d in db %d", len(s.Jobs), count(t, db, "scd_job"))
+	}
+	restore()
+	j1 := &Job{Name: "j1"}
+	if _, err := s.AddJob(j1); err != nil {
+		t.Fatal(err)
+	}
+
+	//第二个作业：新增作业后更新前一个作业失败
+	restore = fail("scd_job", "UPDATE")
+	if _, err := s.AddJob(&Job{Name: "j2"}); err == nil {
+		t.Fatal("want error when updating previous job fails")
+	}
+	if count(t, db, "scd_job") != 1 || len(s.Jobs) != 1 || j1.NextJobId != 0 || j1.NextJob != nil {
+		t.Fatalf("add job is not rolled back, jobs %d in db %d", len(s.Jobs), count(t, db, "scd_job"))
+	}
+	restore()
+
+	//删除任务：删除参数后删除任务失败
+	task := &Task{Name: "a", JobId: j1.Id, Cmd: "echo", Param: []string{"p"}, RelTasks: make(map[string]*Task)}
+	if err := s.AddTask(task); err != nil {
+		t.Fatal(err)
+	}
+	restore = fail("scd_task", "DELETE")
+	if err := s.DeleteTask(task.Id); err == nil {
+		t.Fatal("want error when deleting task fails")
+	}
+	if count(t, db, "scd_task_param") != 1 || count(t, db, "scd_job_task") != 1 || len(s.Tasks) != 1 || j1.TaskCnt != 1 {
+		t.Fatalf("delete task is not rolled back, tasks %d", len(s.Tasks))
+	}
+	restore()
+	if err := s.DeleteTask(task.Id); err != nil {
+		t.Fatal(err)
+	}
+	if count(t, db, "scd_task") != 0 || len(s.Tasks) != 0 || j1.TaskCnt != 0 {
+		t.Fatalf("task is not deleted, tasks %d", len(s.Tasks))
+	}
+
+	//删除作业：更新调度失败
+	restore = fail("scd_schedule", "UPDATE")
+	if err := s.DeleteJob(j1.Id); err == nil {
+		t.Fatal("want error when updating schedule fails")
+	}
+	if count(t, db, "scd_job") != 1 || len(s.Jobs) != 1 || s.JobId != j1.Id {
+		t.Fatalf("delete job is not rolled back, jobs %d", len(s.Jobs))
+	}
+	restore()
+	if err := s.DeleteJob(j1.Id); err != nil || count(t, db, "scd_job") != 0 || len(s.Jobs) != 0 {
+		t.Fatalf("job is not deleted, err %v jobs %d", err, len(s.Jobs))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := []*Schedule{
+		{Name: "none"},
+		{Name: "daily", Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{86399 * time.Second}},
+		{Name: "yearly", Cyc: "y", StartMonth: []int{12}, StartSecond: []time.Duration{40 * 24 * time.Hour}},
+		{Name: "cron", Cyc: CronPrefix + "*/5 * * * *"},
+	}
+	for _, s := range valid {
+		if err := s.Validate(); err != nil {
+			t.Errorf("schedule %s: %v", s.Name, err)
+		}
+	}
+
+	//每一项校验失败都需要返回
+	s := &Schedule{Name: "bad", Cyc: "d",
+		StartMonth:  []int{13, -1, 0},
+		StartSecond: []time.Duration{86400 * time.Second, -time.Second},
+	}
+	err := s.Validate()
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("want *ValidationError, got %v", err)
+	}
+	fields := make([]string, 0)
+	for _, fe := range ve.Errors {
+		fields = append(fields, fe.Field)
+	}
+	want := "StartMonth StartMonth[0] StartMonth[1] StartSecond[0] StartSecond[1]"
+	if strings.Join(fields, " ") != want {
+		t.Fatalf("want fields %s, got %s", want, strings.Join(fields, " "))
+	}
+
+	for _, cyc := range []string{"x", CronPrefix + "* *"} {
+		s = &Schedule{Name: "cyc", Cyc: cyc}
+		if err = s.Validate(); !errors.As(err, &ve) || ve.Errors[0].Field != "Cyc" {
+			t.Errorf("cyc %s: want Cyc error, got %v", cyc, err)
+		}
+	}
+
+	//校验失败时不修改数据库
+	g = DefaultGlobal()
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+
+	s = &Schedule{Name: "v", Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{time.Hour}}
+	if err = s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.AddScheduleStart(); err != nil {
+		t.Fatal(err)
+	}
+	s.Cyc, s.StartSecond = "h", []time.Duration{2 * time.Hour}
+	if err = s.UpdateSchedule(); !errors.As(err, &ve) {
+		t.Fatalf("want *ValidationError, got %v", err)
+	}
+	var cyc string
+	if err = db.QueryRow("SELECT scd_cyc FROM scd_schedule WHERE scd_id=?", s.Id).Scan(&cyc); err != nil {
+		t.Fatal(err)
+	}
+	if cyc != "d" || count(t, db, "scd_start") != 1 {
+		t.Fatalf("invalid schedule is persisted, cyc %s starts %d", cyc, count(t, db, "scd_start"))
+	}
+	if err = (&Schedule{Name: "bad", Cyc: "x"}).Add(); err == nil || count(t, db, "scd_schedule") != 1 {
+		t.Fatal("invalid schedule is added")
+	}
+}
+
+func TestDeleteTaskRel(t *testing.T) {
+	g = DefaultGlobal()
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+
+	s := &Schedule{Name: "rel", Cyc: "d"}
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	j := &Job{Name: "j", Tasks: make(map[string]*Task)}
+	if _, err := s.AddJob(j); err != nil {
+		t.Fatal(err)
+	}
+	a := &Task{Name: "a", JobId: j.Id, Cmd: "echo", RelTasks: make(map[string]*Task)}
+	b := &Task{Name: "b", JobId: j.Id, Cmd: "echo", RelTasks: make(map[string]*Task)}
+	for _, task := range []*Task{a, b} {
+		if err := s.AddTask(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.AddRelTask(a); err != nil {
+		t.Fatal(err)
+	}
+
+	key := strconv.FormatInt(a.Id, 10)
+	if b.RelTasks[key] != a || j.Tasks[key] != a {
+		t.Fatalf("task [%d] is not keyed by %q", a.Id, key)
+	}
+	if count(t, db, "scd_task_rel") != 1 {
+		t.Fatalf("want 1 relation, got %d", count(t, db, "scd_task_rel"))
+	}
+
+	//删除被依赖的任务后，依赖关系需从元数据库与内存中一并删除
+	if err := s.DeleteTask(a.Id); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, db, "scd_task_rel"); n != 0 {
+		t.Fatalf("relation is not deleted, %d left", n)
+	}
+	if len(b.RelTasks) != 0 || len(b.RelTasksId) != 0 || b.RelTaskCnt != 0 {
+		t.Fatalf("task b still depends on deleted task, %v", b.RelTasksId)
+	}
+	if _, ok := j.Tasks[key]; ok || j.TaskCnt != 1 {
+		t.Fatalf("task [%d] is not removed from job, tasks %d", a.Id, j.TaskCnt)
+	}
+}
+
+//progressExecutor执行任务d前记录所在批次的执行进度
+type progressExecutor struct {
+	SyncExecutor
+	info ExecScheduleInfo
+	err  error
+}
+
+func (pe *progressExecutor) Run(task *Task, reply *Reply) error {
+	if task.Name == "d" {
+		sl := g.Schedules
+		sl.lock.RLock()
+		var batchId string
+		for id := range sl.ExecScheduleList {
+			batchId = id
+		}
+		sl.lock.RUnlock()
+		pe.info, pe.err = sl.GetExecSchedule(batchId)
+	}
+	return pe.SyncExecutor.Run(task, reply)
+}
+
+func TestGetExecSchedule(t *testing.T) {
+	g = DefaultGlobal()
+	g.NoLog = true
+	s := newTestSchedule()
+
+	es := ExecScheduleWarper(s)
+	g.Schedules.AddExecSchedule(es)
+	info, err := g.Schedules.GetExecSchedule(es.batchId)
+	if err != nil || info.Status != RunPending || info.TaskCnt != 4 || info.DoneTaskCnt != 0 {
+		t.Fatalf("want pending run with 4 tasks, got %+v err %v", info, err)
+	}
+	g.Schedules.RemoveExecSchedule(es.batchId)
+	if _, err = g.Schedules.GetExecSchedule(es.batchId); err == nil {
+		t.Fatal("want error for unknown batch")
+	}
+
+	//d依赖b、c，开始时a、b、c均已结束，c执行失败
+	exec := &progressExecutor{SyncExecutor: SyncExecutor{Fail: map[string]string{"c": "error"}}}
+	s.Tasks[3].RelConditions = map[int64]string{3: RelAlways}
+	if _, err = TestRun(s, nil, exec); err != nil {
+		t.Fatal(err)
+	}
+	info = exec.info
+	if exec.err != nil || info.Status != RunRunning || info.ScheduleId != s.Id || info.StartTime.IsZero() {
+		t.Fatalf("want running run, got %+v err %v", info, exec.err)
+	}
+	if info.TaskCnt != 4 || info.DoneTaskCnt != 3 || info.SuccessTaskCnt != 2 || info.FailTaskCnt != 1 {
+		t.Fatalf("want 3 of 4 tasks done, got %+v", info)
+	}
+
+	if runStatus(3, 0) != RunSuccess || runStatus(3, 1) != RunFailed || runStatus(4, 0) != RunFailed {
+		t.Fatal("bad status of finished run")
+	}
+}
+
+//fakeClock是可手动推进的时钟，每次调用After时将等待时间发送到waits
+type fakeClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+	waits  chan time.Duration
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, waits: make(chan time.Duration, 10)}
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return fc.now
+}
+
+func (fc *fakeClock) After(d time.Duration) <-chan time.Time {
+	fc.lock.Lock()
+	c := make(chan time.Time, 1)
+	fc.timers = append(fc.timers, fakeTimer{at: fc.now.Add(d), c: c})
+	fc.lock.Unlock()
+	fc.fire()
+	fc.waits <- d
+	return c
+}
+
+//wait返回Timer下一次等待的时间，5秒内没有等待时测试失败
+func (fc *fakeClock) wait(t *testing.T) time.Duration {
+	select {
+	case d := <-fc.waits:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("timer is not waiting")
+	}
+	return 0
+}
+
+//Advance推进时钟，触发到期的定时器
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.lock.Lock()
+	fc.now = fc.now.Add(d)
+	fc.lock.Unlock()
+	fc.fire()
+}
+
+func (fc *fakeClock) fire() {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	timers := fc.timers[:0]
+	for _, ft := range fc.timers {
+		if ft.at.After(fc.now) {
+			timers = append(timers, ft)
+		} else {
+			ft.c <- fc.now
+		}
+	}
+	fc.timers = timers
+}
+
+func TestTimerClock(t *testing.T) {
+	g = DefaultGlobal()
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+	start := time.Date(2015, 1, 1, 0, 30, 0, 0, time.Local)
+	clock := newFakeClock(start)
+	g.Clock = clock
+
+	//按日调度，每天1点启动，调度中没有任务，启动时按EmptySkip策略等待下一周期
+	s := &Schedule{Name: "clock", Enabled: true, Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{time.Hour}}
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddScheduleStart(); err != nil {
+		t.Fatal(err)
+	}
+	g.Schedules.ScheduleList = append(g.Schedules.ScheduleList, s)
+	defer g.Schedules.StopListener()
+
+	go s.Timer()
+	if d := clock.wait(t); d != 30*time.Minute {
+		t.Fatalf("want countdown 30m, got %s", d)
+	}
+	g.Schedules.lock.RLock()
+	next := s.NextStart
+	g.Schedules.lock.RUnlock()
+	if want := start.Add(30 * time.Minute); !next.Equal(want) {
+		t.Fatalf("want next start %s, got %s", want, next)
+	}
+
+	//到达NextStart之前不会启动
+	clock.Advance(30*time.Minute - time.Second)
+	select {
+	case d := <-clock.waits:
+		t.Fatalf("timer fired before next start, waits %s", d)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	//到达NextStart时启动，跳过空调度后等待第二天1点
+	clock.Advance(time.Second)
+	if d := clock.wait(t); d != 24*time.Hour {
+		t.Fatalf("want countdown 24h after start, got %s", d)
+	}
+}
+
+func TestStartListenerErrors(t *testing.T) {
+	g = DefaultGlobal()
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+	clock := newFakeClock(time.Date(2015, 1, 1, 0, 30, 0, 0, time.Local))
+	g.Clock = clock
+
+	newScd := func(name string) *Schedule {
+		s := &Schedule{Name: name, Enabled: true, Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{time.Hour}}
+		if err := s.Add(); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AddScheduleStart(); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	//元数据库中不存在的调度初始化失败，不影响之后的调度启动
+	good1, good2 := newScd("good1"), newScd("good2")
+	bad := &Schedule{Id: 999, Name: "bad"}
+	g.Schedules.ScheduleList = []*Schedule{good1, bad, good2}
+	defer g.Schedules.StopListener()
+
+	err := g.Schedules.StartListener()
+	se, ok := err.(*StartError)
+	if !ok || len(se.Errors) != 1 || se.Errors[0].ScheduleId != 999 {
+		t.Fatalf("want StartError for schedule 999, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-clock.waits:
+		case <-time.After(5 * time.Second):
+			t.Fatal("good schedules are not waiting")
+		}
+	}
+	g.Schedules.lock.RLock()
+	defer g.Schedules.lock.RUnlock()
+	for _, s := range []*Schedule{good1, good2} {
+		if s.NextStart.IsZero() {
+			t.Errorf("schedule %s is not started", s.Name)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.WebhookBackoff = time.Millisecond
+
+	//第一次请求返回500，重试后成功
+	var calls int32
+	received := make(chan WebhookPayload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var p WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		received <- p
+	}))
+	defer srv.Close()
+	g.Webhooks = []string{srv.URL}
+
+	start := time.Date(2015, 1, 1, 1, 0, 0, 0, time.Local)
+	es := &ExecSchedule{batchId: "1.20150101", schedule: &Schedule{Id: 1, Name: "hook"}, log: g.L.WithField("schedule_id", 1),
+		state: 3, startTime: start, endTime: start.Add(90 * time.Second), failTaskCnt: 1, failedTasks: []int64{7}}
+	es.notify()
+
+	select {
+	case p := <-received:
+		if p.ScheduleId != 1 || p.RunId != "1.20150101" || p.Outcome != RunFailed || p.Duration != 90 ||
+			len(p.FailedTaskIds) != 1 || p.FailedTaskIds[0] != 7 {
+			t.Fatalf("unexpected payload %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook is not called")
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("want 2 calls, got %d", n)
+	}
+
+	//重试次数用完后返回error
+	g.WebhookRetry = 1
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer bad.Close()
+	if err := postWebhook(bad.URL, []byte("{}")); err == nil || !strings.Contains(err.Error(), "2 attempts") {
+		t.Fatalf("want error after 2 attempts, got %v", err)
+	}
+}
+
+func TestReloadSchedule(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.NoLog = true
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+	clock := newFakeClock(time.Now())
+	g.Clock = clock
+
+	s := &Schedule{Name: "reload", Enabled: true, Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{time.Hour}}
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddScheduleStart(); err != nil {
+		t.Fatal(err)
+	}
+	j := &Job{Name: "j", Tasks: make(map[string]*Task)}
+	if _, err := s.AddJob(j); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddTask(&Task{Name: "a", JobId: j.Id, Cmd: "echo", RelTasks: make(map[string]*Task)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.InitSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	g.Schedules.ScheduleList = append(g.Schedules.ScheduleList, s)
+	defer g.Schedules.StopListener()
+
+	//执行中的批次使用初始化时的快照
+	es := ExecScheduleWarper(s)
+	if err := es.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	go s.Timer()
+	select {
+	case <-clock.waits:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timer is not waiting")
+	}
+
+	//直接修改元数据库：增加任务，调度周期改为按小时
+	other := &Schedule{Id: s.Id}
+	if err := other.InitSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.AddTask(&Task{Name: "b", JobId: j.Id, Cmd: "echo", RelTasks: make(map[string]*Task)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE scd_schedule SET scd_cyc='h' WHERE scd_id=?", s.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE scd_start SET scd_start=60 WHERE scd_id=?", s.Id); err != nil {
+		t.Fatal(err)
+	}
+	if s.TaskCnt != 1 {
+		t.Fatalf("in-memory schedule changed before reload, tasks %d", s.TaskCnt)
+	}
+	last := time.Now().Add(-time.Hour)
+	g.Schedules.setRunResult(s.Id, runResult{startTime: last, endTime: last.Add(time.Minute), failed: true, err: "boom"})
+	refresh := s.isRefresh
+
+	if err := g.Schedules.ReloadSchedule(s.Id); err != nil {
+		t.Fatal(err)
+	}
+	if s.TaskCnt != 2 || s.Cyc != "h" || g.Schedules.GetScheduleById(s.Id) != s {
+		t.Fatalf("want reloaded schedule in place, got tasks %d cyc %s", s.TaskCnt, s.Cyc)
+	}
+	//只替换调度的定义，监听的通道与最近的执行结果保持不变
+	if s.isRefresh != refresh {
+		t.Fatal("want refresh channel kept after reload")
+	}
+	if !s.LastRunTime.Equal(last) || s.LastRunStatus != RunFailed || s.LastError != "boom" {
+		t.Fatalf("want last run kept after reload, got %s %v %q", s.LastRunTime, s.LastRunStatus, s.LastError)
+	}
+	if es.schedule.TaskCnt != 1 || es.taskCnt != 1 {
+		t.Fatalf("running batch is changed by reload, tasks %d", es.schedule.TaskCnt)
+	}
+
+	//调度周期变化后按新的周期重新等待，不超过1小时
+	select {
+	case d := <-clock.waits:
+		if d > time.Hour {
+			t.Fatalf("want wait within an hour after reload, got %s", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timer is not restarted after reload")
+	}
+
+	if err := g.Schedules.ReloadSchedule(s.Id + 100); err == nil {
+		t.Fatal("want error for unknown schedule")
+	}
+}
+
+func TestScheduleCount(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.NoLog = true
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+	exec := &SyncExecutor{}
+	g.Executor = exec
+	clock := newFakeClock(time.Date(2015, 1, 1, 0, 30, 0, 0, time.Local))
+	g.Clock = clock
+
+	//每天1点启动，共执行3次
+	s := &Schedule{Name: "count", Enabled: true, Cyc: "d", Count: 3, StartMonth: []int{0}, StartSecond: []time.Duration{time.Hour}}
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddScheduleStart(); err != nil {
+		t.Fatal(err)
+	}
+	j := &Job{Name: "j", Tasks: make(map[string]*Task)}
+	if _, err := s.AddJob(j); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddTask(&Task{Name: "a", JobId: j.Id, Cmd: "echo", RelTasks: make(map[string]*Task)}); err != nil {
+		t.Fatal(err)
+	}
+	g.Schedules.ScheduleList = append(g.Schedules.ScheduleList, s)
+	defer g.Schedules.StopListener()
+
+	go s.Timer()
+	for i := 0; i < 3; i++ {
+		select {
+		case d := <-clock.waits:
+			clock.Advance(d)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timer is not waiting after %d runs", i)
+		}
+	}
+
+	//第3次执行结束后不再等待启动
+	select {
+	case d := <-clock.waits:
+		t.Fatalf("schedule is rescheduled after 3 runs, waits %s", d)
+	case <-time.After(200 * time.Millisecond):
+	}
+	exec.lock.Lock()
+	runs := len(exec.Order)
+	exec.lock.Unlock()
+	if runs != 3 {
+		t.Fatalf("want 3 runs, got %d", runs)
+	}
+
+	//剩余次数已持久化，重新加载后不再启动
+	ns := &Schedule{Id: s.Id}
+	if err := ns.getSchedule(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ns.Remain != 0 || !ns.exhausted() {
+		t.Fatalf("want remain 0 after reload, got %d", ns.Remain)
+	}
+}
+
+func TestQueryRunHistory(t *testing.T) {
+	g = DefaultGlobal()
+	db := openTestDB(t)
+	defer db.Close()
+	g.LogConn = db
+
+	//5个批次，每天1个，第3天的批次中任务2重试后仍然失败
+	day := time.Date(2015, 1, 1, 1, 0, 0, 0, time.Local)
+	for i := 0; i < 5; i++ {
+		st := day.AddDate(0, 0, i)
+		batchId := fmt.Sprintf("1.%d", i)
+		if _, err := db.Exec(`INSERT INTO scd_schedule_log (batch_id, scd_id, start_time, end_time, state, result, batch_type)
+			VALUES (?, 1, ?, ?, '3', 1, '1')`, batchId, st, st.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		states := []string{"3", "3"}
+		if i == 2 {
+			states = []string{"3", "3", "4"}
+		}
+		for n, state := range states {
+			taskId := n + 1
+			if taskId > 2 {
+				taskId = 2
+			}
+			if _, err := db.Exec(`INSERT INTO scd_task_log (batch_task_id, batch_job_id, batch_id, task_id, start_time, end_time, state, batch_type)
+				VALUES (?, ?, ?, ?, ?, ?, ?, '1')`, fmt.Sprintf("%s.%d", batchId, taskId), batchId, batchId, taskId,
+				st.Add(time.Duration(n)*time.Second), st.Add(time.Minute), state); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	//[第2天, 第5天)内的批次，每页2个，按开始时间倒序
+	from, to := day.AddDate(0, 0, 1), day.AddDate(0, 0, 4)
+	page1, err := g.Schedules.QueryRunHistory(1, from, to, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page2, err := g.Schedules.QueryRunHistory(1, from, to, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page1) != 2 || len(page2) != 1 || page1[0].BatchId != "1.3" || page1[1].BatchId != "1.2" || page2[0].BatchId != "1.1" {
+		t.Fatalf("unexpected pages %+v %+v", page1, page2)
+	}
+
+	r := page1[1]
+	if r.Outcome != RunFailed || len(r.Tasks) != 2 || r.Tasks[1].TaskId != 2 || r.Tasks[1].State != 4 || r.ExecType != 1 {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	if page1[0].Outcome != RunSuccess || !page1[0].EndTime.Equal(page1[0].StartTime.Add(time.Minute)) {
+		t.Fatalf("unexpected record %+v", page1[0])
+	}
+
+	if _, err = g.Schedules.QueryRunHistory(1, to, from, 0, 0); err == nil {
+		t.Fatal("want error for inverted range")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	exec := &SyncExecutor{}
+	g.Executor = exec
+
+	//c只在a失败时执行，推演中a总是成功，c与依赖c的d被跳过
+	//未设置NoLog且没有日志库，写入日志时会出错
+	s := newTestSchedule()
+	s.Tasks[2].RelConditions = map[int64]string{1: RelOnFailure}
+	es := ExecScheduleWarper(s)
+	es.execType, es.DryRun = 2, true
+	if err := es.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	es.Run()
+
+	plan := es.Plan()
+	if plan == nil || plan.Steps != 3 || len(plan.Tasks) != 4 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	want := []struct {
+		name   string
+		step   int
+		action string
+	}{{"a", 1, PlanRun}, {"b", 2, PlanRun}, {"c", 2, PlanSkip}, {"d", 3, PlanSkip}}
+	for i, w := range want {
+		pt := plan.Tasks[i]
+		if pt.TaskName != w.name || pt.Step != w.step || pt.Action != w.action {
+			t.Fatalf("task %d want %+v, got %+v", i, w, pt)
+		}
+	}
+	d := plan.Tasks[3].Deps
+	if len(d) != 2 || d[0].TaskId != 2 || !d[0].Met || d[1].TaskId != 3 || d[1].Met || d[1].Condition != RelOnSuccess {
+		t.Fatalf("unexpected deps of d %+v", d)
+	}
+	if len(exec.Order) != 0 || es.state != 0 {
+		t.Fatalf("dry run executed tasks %v, state %d", exec.Order, es.state)
+	}
+}
+
+//concurrentExecutor记录同时执行的任务数量的最大值
+type concurrentExecutor struct {
+	SyncExecutor
+	running int32
+	max     int32
+}
+
+func (ce *concurrentExecutor) Run(task *Task, reply *Reply) error {
+	n := atomic.AddInt32(&ce.running, 1)
+	for {
+		m := atomic.LoadInt32(&ce.max)
+		if n <= m || atomic.CompareAndSwapInt32(&ce.max, m, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	atomic.AddInt32(&ce.running, -1)
+	return ce.SyncExecutor.Run(task, reply)
+}
+
+func TestResourcePool(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.ResourcePools = map[string]int{"db": 2}
+
+	//6个互不依赖的任务使用同一资源池，同时执行的数量不超过2
+	s := &Schedule{Id: 1, Name: "pool", Jobs: make([]*Job, 0), Tasks: make([]*Task, 0)}
+	j := &Job{Id: 1, Tasks: make(map[string]*Task)}
+	for i := 1; i <= 6; i++ {
+		task := &Task{Id: int64(i), Name: fmt.Sprintf("t%d", i), Cmd: "echo", JobId: 1, ResourcePool: "db"}
+		j.Tasks[task.Name] = task
+		s.addTaskList(task)
+	}
+	s.Job, s.Jobs = j, append(s.Jobs, j)
+
+	exec := &concurrentExecutor{}
+	r, err := TestRun(s, nil, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Success() || len(exec.Order) != 6 {
+		t.Fatalf("want 6 tasks success, got %+v", r)
+	}
+	if m := atomic.LoadInt32(&exec.max); m != 2 {
+		t.Fatalf("want at most 2 tasks running, got %d", m)
+	}
+
+	//资源池已满时，等待到超时或批次被取消后放弃
+	pools := newResourcePools()
+	p, err := pools.get("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = p.acquire(time.Time{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = p.acquire(time.Now().Add(20*time.Millisecond), nil); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("want timeout error, got %v", err)
+	}
+	done := make(chan struct{})
+	close(done)
+	if err = p.acquire(time.Time{}, done); err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("want cancelled error, got %v", err)
+	}
+	p.release()
+	if err = p.acquire(time.Time{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	g.Schedules.pools = pools
+	usage := g.Schedules.ResourcePoolUsage()
+	if len(usage) != 1 || usage[0] != (PoolUsage{Name: "db", Capacity: 2, InUse: 2}) {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+	if _, err = pools.get("none"); err == nil {
+		t.Fatal("want error for undefined pool")
+	}
+}
+
+func TestTimeZone(t *testing.T) {
+	g = DefaultGlobal()
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	cases := []struct {
+		name  string
+		zone  string
+		now   time.Time
+		start time.Duration
+		want  time.Time
+	}{
+		//夏令时开始当天02:30被跳过，顺延到03:30（EDT）启动
+		{"gap", "America/New_York", time.Date(2015, 3, 8, 0, 0, 0, 0, ny), 2*time.Hour + 30*time.Minute,
+			time.Date(2015, 3, 8, 7, 30, 0, 0, time.UTC)},
+		//夏令时结束当天01:30出现两次，在第一次出现时启动
+		{"overlap", "America/New_York", time.Date(2015, 11, 1, 0, 0, 0, 0, ny), time.Hour + 30*time.Minute,
+			time.Date(2015, 11, 1, 5, 30, 0, 0, time.UTC)},
+		//第二次出现的01:30不再启动，下次启动为第二天
+		{"overlap again", "America/New_York", time.Date(2015, 11, 1, 5, 45, 0, 0, time.UTC), time.Hour + 30*time.Minute,
+			time.Date(2015, 11, 2, 6, 30, 0, 0, time.UTC)},
+		//启动时间按调度的时区计算，与服务器的时区无关
+		{"zone", "Asia/Shanghai", time.Date(2015, 1, 1, 20, 0, 0, 0, time.UTC), time.Hour,
+			time.Date(2015, 1, 1, 17, 0, 0, 0, time.UTC).AddDate(0, 0, 1)},
+	}
+	for _, c := range cases {
+		g.Clock = newFakeClock(c.now)
+		s := &Schedule{Name: c.name, Cyc: "d", TimeZone: c.zone, StartMonth: []int{0}, StartSecond: []time.Duration{c.start}}
+		d, err := s.countDown()
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if got := c.now.Add(d); !got.Equal(c.want) {
+			t.Errorf("%s: want next start %s, got %s", c.name, c.want, got.In(ny))
+		}
+	}
+
+	s := &Schedule{Name: "bad zone", Cyc: "d", TimeZone: "Mars/Olympus", StartMonth: []int{0}, StartSecond: []time.Duration{0}}
+	ve, ok := s.Validate().(*ValidationError)
+	if !ok || len(ve.Errors) != 1 || ve.Errors[0].Field != "TimeZone" {
+		t.Fatalf("want TimeZone validation error, got %v", s.Validate())
+	}
+	if _, err := s.countDown(); err == nil {
+		t.Fatal("want error for bad time zone")
+	}
+}
+
+func TestSetLogLevel(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.LoggerFactory = FileLoggerFactory(g.L, t.TempDir(), LogRouteSchedule)
+	s := &Schedule{Id: 1}
+	l := s.logger()
+
+	levels := []struct {
+		level string
+		want  logrus.Level
+	}{{"debug", logrus.Debug}, {" WARN ", logrus.Warn}, {"warning", logrus.Warn},
+		{"2", logrus.Error}, {"info", logrus.Info}}
+	for _, lv := range levels {
+		if err := g.SetLogLevel(lv.level); err != nil {
+			t.Fatal(err)
+		}
+		if g.L.Level != lv.want || s.logger() != l || l.Level != lv.want {
+			t.Fatalf("level %q: want %s, got %s and %s", lv.level, lv.want, g.L.Level, l.Level)
+		}
+	}
+
+	//无法识别的级别返回error，原有级别不变
+	for _, level := range []string{"", "trace", "6", "-1"} {
+		if err := g.SetLogLevel(level); err == nil {
+			t.Fatalf("level %q: want error", level)
+		}
+		if g.L.Level != logrus.Info {
+			t.Fatalf("level %q: level is changed to %s", level, g.L.Level)
+		}
+	}
+}
+
+func TestDeleteJobAndRelink(t *testing.T) {
+	g = DefaultGlobal()
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+
+	s := &Schedule{Name: "relink", Cyc: "d"}
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	jobs := make([]*Job, 3)
+	for i := range jobs {
+		jobs[i] = &Job{Name: fmt.Sprintf("j%d", i+1), Tasks: make(map[string]*Task)}
+		if _, err := s.AddJob(jobs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j1, j2, j3 := jobs[0], jobs[1], jobs[2]
+	task := &Task{Name: "a", JobId: j2.Id, Cmd: "echo", RelTasks: make(map[string]*Task)}
+	if err := s.AddTask(task); err != nil {
+		t.Fatal(err)
+	}
+	//jobOf返回元数据库中任务所属的作业
+	jobOf := func() (id int64) {
+		if err := db.QueryRow("SELECT job_id FROM scd_job_task WHERE task_id=?", task.Id).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	//links返回元数据库中作业的上下级作业
+	links := func(j *Job) (pre, next int64) {
+		if err := db.QueryRow("SELECT prev_job_id, next_job_id FROM scd_job WHERE job_id=?", j.Id).Scan(&pre, &next); err != nil {
+			t.Fatal(err)
+		}
+		return pre, next
+	}
+
+	//有任务的作业不指定force时拒绝删除
+	if err := s.DeleteJobAndRelink(j2.Id, false); err == nil || len(s.Jobs) != 3 {
+		t.Fatalf("want error for job with tasks, got %v", err)
+	}
+
+	//迁移任务失败时整体回滚
+	if _, err := db.Exec("CREATE TRIGGER fail_move BEFORE UPDATE ON scd_job_task BEGIN SELECT RAISE(ABORT, 'injected'); END"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteJobAndRelink(j2.Id, true); err == nil {
+		t.Fatal("want error when moving tasks fails")
+	}
+	if _, next := links(j1); next != j2.Id || j1.NextJob != j2 || count(t, db, "scd_job") != 3 || len(s.Jobs) != 3 {
+		t.Fatalf("relink is not rolled back, next of j1 %d", next)
+	}
+	db.Exec("DROP TRIGGER fail_move")
+
+	//删除中间的作业，任务迁移到上一个作业
+	if err := s.DeleteJobAndRelink(j2.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if j1.NextJob != j3 || j3.PreJob != j1 || j1.NextJobId != j3.Id || j3.PreJobId != j1.Id || s.JobCnt != 2 {
+		t.Fatalf("bad chain after deleting j2, jobs %d", s.JobCnt)
+	}
+	if _, next := links(j1); next != j3.Id {
+		t.Fatalf("next of j1 in db is %d", next)
+	}
+	if pre, _ := links(j3); pre != j1.Id {
+		t.Fatalf("pre of j3 in db is %d", pre)
+	}
+	if jobOf() != j1.Id || task.JobId != j1.Id || j1.TaskCnt != 1 || len(j1.Tasks) != 1 {
+		t.Fatalf("task is not moved to j1, job %d", jobOf())
+	}
+
+	//删除第一个作业，任务迁移到下一个作业，调度指向下一个作业
+	if err := s.DeleteJobAndRelink(j1.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	var head int64
+	if err := db.QueryRow("SELECT scd_job_id FROM scd_schedule WHERE scd_id=?", s.Id).Scan(&head); err != nil {
+		t.Fatal(err)
+	}
+	if s.Job != j3 || s.JobId != j3.Id || head != j3.Id || j3.PreJobId != 0 || jobOf() != j3.Id {
+		t.Fatalf("bad chain after deleting j1, head %d", head)
+	}
+
+	//唯一的作业有任务时无法迁移
+	if err := s.DeleteJobAndRelink(j3.Id, true); err == nil || count(t, db, "scd_job") != 1 {
+		t.Fatalf("want error for the only job, got %v", err)
+	}
+}
+
+func TestInsertJobAfter(t *testing.T) {
+	g = DefaultGlobal()
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+
+	s := &Schedule{Name: "insert", Cyc: "d"}
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	j1, j3 := &Job{Name: "j1"}, &Job{Name: "j3"}
+	for _, j := range []*Job{j1, j3} {
+		if _, err := s.AddJob(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := func(j *Job) (pre, next int64) {
+		if err := db.QueryRow("SELECT prev_job_id, next_job_id FROM scd_job WHERE job_id=?", j.Id).Scan(&pre, &next); err != nil {
+			t.Fatal(err)
+		}
+		return pre, next
+	}
+
+	if err := s.InsertJobAfter(999, &Job{Name: "x"}); err == nil || count(t, db, "scd_job") != 2 {
+		t.Fatalf("want error for unknown job, got %v", err)
+	}
+
+	//更新下一个作业失败时整体回滚
+	if _, err := db.Exec("CREATE TRIGGER fail_job BEFORE UPDATE ON scd_job WHEN new.job_id=" + strconv.FormatInt(j3.Id, 10) +
+		" BEGIN SELECT RAISE(ABORT, 'injected'); END"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.InsertJobAfter(j1.Id, &Job{Name: "x"}); err == nil {
+		t.Fatal("want error when updating next job fails")
+	}
+	if _, next := links(j1); next != j3.Id || j1.NextJob != j3 || count(t, db, "scd_job") != 2 || len(s.Jobs) != 2 {
+		t.Fatalf("insert is not rolled back, next of j1 %d", next)
+	}
+	db.Exec("DROP TRIGGER fail_job")
+
+	j2 := &Job{Name: "j2"}
+	if err := s.InsertJobAfter(j1.Id, j2); err != nil {
+		t.Fatal(err)
+	}
+	if j2.Id == 0 || j1.NextJob != j2 || j2.PreJob != j1 || j2.NextJob != j3 || j3.PreJob != j2 || j3.PreJobId != j2.Id {
+		t.Fatalf("bad chain after insert, j2 %+v", j2)
+	}
+	if _, next := links(j1); next != j2.Id {
+		t.Fatalf("next of j1 in db is %d", next)
+	}
+	if pre, next := links(j2); pre != j1.Id || next != j3.Id {
+		t.Fatalf("links of j2 in db are %d %d", pre, next)
+	}
+	if pre, _ := links(j3); pre != j2.Id {
+		t.Fatalf("pre of j3 in db is %d", pre)
+	}
+	if s.JobCnt != 3 || s.Jobs[1] != j2 {
+		t.Fatalf("j2 is not the second job, jobs %d", s.JobCnt)
+	}
+
+	//插入到最后一个作业之后
+	j4 := &Job{Name: "j4"}
+	if err := s.InsertJobAfter(j3.Id, j4); err != nil {
+		t.Fatal(err)
+	}
+	if j3.NextJob != j4 || j4.NextJobId != 0 || s.Jobs[3] != j4 {
+		t.Fatalf("j4 is not appended, jobs %d", s.JobCnt)
+	}
+}
+
+func TestMaxConcurrentSchedules(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.NoLog = true
+	reg := prometheus.NewRegistry()
+	g.Registry = reg
+	g.MaxConcurrentSchedules = 1
+	sg := g.Schedules.gate
+
+	if throttled, err := sg.acquire(nil, 0, time.Now()); throttled || err != nil {
+		t.Fatalf("first acquire want no throttle, got %v %v", throttled, err)
+	}
+
+	//达到上限时等待，放宽上限后立即开始
+	c := make(chan bool)
+	go func() {
+		throttled, _ := sg.acquire(nil, 0, time.Now())
+		c <- throttled
+	}()
+	waitFor(t, func() bool { return g.Schedules.ConcurrentSchedules().Waiting == 1 })
+	g.Schedules.SetMaxConcurrentSchedules(2)
+	select {
+	case throttled := <-c:
+		if !throttled {
+			t.Fatal("waited acquire should be reported as throttled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("raising the limit should wake the waiting run")
+	}
+
+	//放弃等待时不占用位置
+	done := make(chan struct{})
+	go func() {
+		_, err := sg.acquire(done, 0, time.Now())
+		c <- err != nil
+	}()
+	waitFor(t, func() bool { return g.Schedules.ConcurrentSchedules().Waiting == 1 })
+	close(done)
+	if !<-c {
+		t.Fatal("cancelled wait should return error")
+	}
+	if u := g.Schedules.ConcurrentSchedules(); u.Running != 2 || u.Waiting != 0 {
+		t.Fatalf("want 2 running and none waiting, got %+v", u)
+	}
+	sg.release()
+	sg.release()
+
+	//批次执行中达到上限，drop策略放弃新的批次
+	g.Schedules.SetMaxConcurrentSchedules(1)
+	g.ScheduleThrottle = ThrottleDrop
+	exec := &blockExecutor{block: map[string]bool{"a": true}, release: make(chan struct{})}
+	g.Executor = exec
+	es1 := ExecScheduleWarper(newTestSchedule())
+	es1.execType = 2
+	if err := es1.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	end := make(chan struct{})
+	go func() {
+		es1.Run()
+		close(end)
+	}()
+	waitFor(t, func() bool { return g.Schedules.ConcurrentSchedules().Running == 1 })
+
+	es2 := ExecScheduleWarper(newTestSchedule())
+	es2.execType = 2
+	if err := es2.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	es2.Run()
+	if es2.state != 4 || len(exec.Order) != 0 {
+		t.Fatalf("dropped run want state 4 and no task sent, got %d %v", es2.state, exec.Order)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+	for _, n := range []string{"schedules_running", "schedule_throttled_total"} {
+		if !names[n] {
+			t.Fatalf("metric %s is not registered, got %v", n, names)
+		}
+	}
+
+	close(exec.release)
+	select {
+	case <-end:
+	case <-time.After(5 * time.Second):
+		t.Fatal("first run did not finish")
+	}
+	if u := g.Schedules.ConcurrentSchedules(); u.Running != 0 {
+		t.Fatalf("want no running schedules after the run ends, got %+v", u)
+	}
+}
+
+func TestGatePriority(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	reg := prometheus.NewRegistry()
+	g.Registry = reg
+	g.MaxConcurrentSchedules = 1
+	sg := g.Schedules.gate
+	if _, err := sg.acquire(nil, 0, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	//优先级高的先开始，优先级相同时先启动的先开始
+	now := time.Now()
+	waiters := []struct {
+		name     string
+		priority int
+		fired    time.Time
+	}{
+		{"low-late", 0, now.Add(time.Second)},
+		{"high", 5, now.Add(2 * time.Second)},
+		{"low-early", 0, now},
+	}
+	started := make(chan string, len(waiters))
+	for i, w := range waiters {
+		go func(name string, priority int, fired time.Time) {
+			if _, err := sg.acquire(nil, priority, fired); err == nil {
+				started <- name
+			}
+		}(w.name, w.priority, w.fired)
+		n := i + 1
+		waitFor(t, func() bool { return g.Schedules.ConcurrentSchedules().Waiting == n })
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var depth float64 = -1
+	for _, mf := range mfs {
+		if mf.GetName() == "schedules_waiting" {
+			depth = mf.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	if depth != 3 {
+		t.Fatalf("want schedules_waiting 3, got %v", depth)
+	}
+
+	order := make([]string, 0)
+	for range waiters {
+		sg.release()
+		select {
+		case name := <-started:
+			order = append(order, name)
+		case <-time.After(time.Second):
+			t.Fatal("released slot did not wake a waiting run")
+		}
+	}
+	if got := strings.Join(order, " "); got != "high low-early low-late" {
+		t.Fatalf("want order high low-early low-late, got %s", got)
+	}
+	sg.release()
+}
+
+func TestAddSchedule(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+	clock := newFakeClock(time.Now())
+	g.Clock = clock
+
+	//监听尚未开始，只持久化不计时
+	s1 := &Schedule{Name: "s1", Enabled: true, Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{time.Hour}}
+	id, err := g.Schedules.AddSchedule(s1)
+	if err != nil || id == 0 || id != s1.Id {
+		t.Fatalf("want new schedule id, got %d %v", id, err)
+	}
+	if n := count(t, db, "scd_start"); n != 1 {
+		t.Fatalf("want start list persisted, got %d rows", n)
+	}
+	select {
+	case d := <-clock.waits:
+		t.Fatalf("timer should not start before StartListener, waiting %s", d)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	j := &Job{Name: "j", Tasks: make(map[string]*Task)}
+	jid, err := s1.AddJob(j)
+	if err != nil || jid == 0 || jid != j.Id {
+		t.Fatalf("want new job id, got %d %v", jid, err)
+	}
+
+	//监听开始后新增的调度立即开始计时
+	g.Schedules.ScheduleList = []*Schedule{}
+	if err = g.Schedules.StartListener(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Schedules.StopListener()
+	s2 := &Schedule{Name: "s2", Enabled: true, Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{time.Hour}}
+	if id, err = g.Schedules.AddSchedule(s2); err != nil || id == s1.Id {
+		t.Fatalf("want another schedule id, got %d %v", id, err)
+	}
+	select {
+	case <-clock.waits:
+	case <-time.After(5 * time.Second):
+		t.Fatal("new schedule is not waiting after StartListener")
+	}
+	if g.Schedules.GetScheduleById(id) != s2 {
+		t.Fatal("new schedule is not registered")
+	}
+}
+
+//abortExecutor阻塞任务b，收到中止通知后b以失败结束
+type abortExecutor struct {
+	SyncExecutor
+	started chan string
+	abort   chan struct{}
+	lock    sync.Mutex
+	aborted []string
+}
+
+func (ae *abortExecutor) Run(task *Task, reply *Reply) error {
+	if task.Name == "b" {
+		ae.started <- task.BatchTaskId
+		<-ae.abort
+		reply.Err = "killed"
+		return nil
+	}
+	return ae.SyncExecutor.Run(task, reply)
+}
+
+func (ae *abortExecutor) Abort(task *Task) error {
+	ae.lock.Lock()
+	defer ae.lock.Unlock()
+	ae.aborted = append(ae.aborted, task.BatchTaskId)
+	close(ae.abort)
+	return nil
+}
+
+//signalWriter在写入的日志包含match时关闭c
+type signalWriter struct {
+	once  sync.Once
+	match string
+	c     chan struct{}
+}
+
+func (sw *signalWriter) Write(p []byte) (int, error) {
+	if strings.Contains(string(p), sw.match) {
+		sw.once.Do(func() { close(sw.c) })
+	}
+	return len(p), nil
+}
+
+func TestCancelRun(t *testing.T) {
+	g = DefaultGlobal()
+	//被中止的任务结束后才能结束测试，避免其使用之后测试的全局配置
+	drained := &signalWriter{match: "of cancelled batchTaskId", c: make(chan struct{})}
+	g.L.Out = drained
+	g.NoLog = true
+	exec := &abortExecutor{started: make(chan string, 1), abort: make(chan struct{})}
+	g.Executor = exec
+
+	es := ExecScheduleWarper(newTestSchedule())
+	es.execType = 2
+	g.Schedules.AddExecSchedule(es)
+	if err := es.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	tasks := make(map[string]*ExecTask)
+	for _, et := range es.execTasks {
+		tasks[et.task.Name] = et
+	}
+	end := make(chan struct{})
+	go func() {
+		es.Run()
+		close(end)
+	}()
+
+	var running string
+	select {
+	case running = <-exec.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task b is not started")
+	}
+	if err := g.Schedules.CancelRun("no such batch"); err == nil {
+		t.Fatal("cancel an unknown batch should fail")
+	}
+	if err := g.Schedules.CancelRun(es.batchId); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-end:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled run did not end")
+	}
+
+	if es.state != 4 {
+		t.Fatalf("cancelled run want state 4, got %d", es.state)
+	}
+	if a := tasks["a"]; a.state != 3 {
+		t.Fatalf("finished task a should stay successful, got state %d", a.state)
+	}
+	if d := tasks["d"]; d.state != 4 || d.output != "task is cancelled" {
+		t.Fatalf("pending task d should be cancelled, got %d %q", d.state, d.output)
+	}
+	exec.lock.Lock()
+	defer exec.lock.Unlock()
+	if len(exec.aborted) != 1 || exec.aborted[0] != running {
+		t.Fatalf("want running task %s aborted, got %v", running, exec.aborted)
+	}
+	if err := g.Schedules.CancelRun(es.batchId); err == nil {
+		t.Fatal("cancel a finished batch should fail")
+	}
+	select {
+	case <-drained.c:
+	case <-time.After(5 * time.Second):
+		t.Fatal("aborted task did not end")
+	}
+}
+
+//TestCancelRunLeak检查中止批次后上下文逐级取消，且没有遗留的goroutine
+func TestCancelRunLeak(t *testing.T) {
+	g = DefaultGlobal()
+	drained := &signalWriter{match: "of cancelled batchTaskId", c: make(chan struct{})}
+	g.L.Out = drained
+	g.NoLog = true
+	exec := &abortExecutor{started: make(chan string, 1), abort: make(chan struct{})}
+	g.Executor = exec
+	base := runtime.NumGoroutine()
+
+	es := ExecScheduleWarper(newTestSchedule())
+	es.execType = 2
+	g.Schedules.AddExecSchedule(es)
+	if err := es.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	tasks := make(map[string]*ExecTask)
+	for _, et := range es.execTasks {
+		tasks[et.task.Name] = et
+	}
+	end := make(chan struct{})
+	go func() {
+		es.Run()
+		close(end)
+	}()
+
+	select {
+	case <-exec.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task b is not started")
+	}
+	if err := g.Schedules.CancelRun(es.batchId); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-end:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled run did not end")
+	}
+	select {
+	case <-drained.c:
+	case <-time.After(5 * time.Second):
+		t.Fatal("aborted task did not end")
+	}
+
+	if tasks["b"].ctx.Err() == nil || tasks["b"].execJob.ctx.Err() == nil {
+		t.Fatal("cancel should propagate to the job and the running task")
+	}
+	exec.lock.Lock()
+	aborted := len(exec.aborted)
+	exec.lock.Unlock()
+	if aborted != 1 {
+		t.Fatalf("want only the running task aborted, got %d", aborted)
+	}
+	waitFor(t, func() bool { return runtime.NumGoroutine() <= base })
+}
+
+//exitExecutor在SyncExecutor的基础上，为任务a输出多字节字符，任务c以退出码2失败
+type exitExecutor struct {
+	SyncExecutor
+}
+
+func (ee *exitExecutor) Run(task *Task, reply *Reply) error {
+	if err := ee.SyncExecutor.Run(task, reply); err != nil {
+		return err
+	}
+	switch task.Name {
+	case "a":
+		reply.Stdout = strings.Repeat("中", 5)
+	case "c":
+		reply.Err, reply.ExitCode = "error", 2
+	}
+	return nil
+}
+
+func TestTaskResults(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	db := openTestDB(t)
+	defer db.Close()
+	g.LogConn = db
+	g.TaskOutputLimit = 8
+	g.Executor = &exitExecutor{}
+
+	s := newTestSchedule()
+	g.Schedules.ScheduleList = append(g.Schedules.ScheduleList, s)
+	es := ExecScheduleWarper(s)
+	es.execType = 2
+	if err := es.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	es.Run()
+
+	results, err := g.Schedules.GetTaskResults(es.batchId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("want 4 task results, got %+v", results)
+	}
+	byId := make(map[int64]TaskResult)
+	for _, r := range results {
+		byId[r.TaskId] = r
+	}
+
+	//a的输出截断在字符边界
+	if a := byId[1]; a.Name != "a" || a.State != 3 || a.Attempt != 1 || a.ExitCode != 0 || a.Output != "中中...(truncated, 15 bytes)" || a.EndTime.Before(a.StartTime) {
+		t.Fatalf("unexpected result of a %+v", a)
+	}
+	if c := byId[3]; c.State != 4 || c.ExitCode != 2 || !strings.HasPrefix(c.Output, "error") {
+		t.Fatalf("unexpected result of c %+v", c)
+	}
+	//d依赖失败的c，没有执行
+	if d := byId[4]; d.State == 3 || d.ExitCode != -1 {
+		t.Fatalf("unexpected result of d %+v", d)
+	}
+
+	if _, err = g.Schedules.GetTaskResults("no such batch"); err == nil {
+		t.Fatal("want error for unknown batch")
+	}
+	g.TaskOutputLimit = -1
+	if out := truncateOutput(strings.Repeat("x", 10000)); len(out) != 10000 {
+		t.Fatalf("want untruncated output, got %d bytes", len(out))
+	}
+}
+
+//paramExecutor记录任务发送执行时的参数
+type paramExecutor struct {
+	SyncExecutor
+	params [][]string
+	env    []map[string]string
+}
+
+func (pe *paramExecutor) Run(task *Task, reply *Reply) error {
+	pe.lock.Lock()
+	pe.params = append(pe.params, task.Param)
+	pe.env = append(pe.env, task.Params)
+	pe.lock.Unlock()
+	return pe.SyncExecutor.Run(task, reply)
+}
+
+func TestBackfill(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.NoLog = true
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+	exec := &paramExecutor{}
+	g.Executor = exec
+
+	s := &Schedule{Name: "backfill", Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{2 * time.Hour}}
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddScheduleStart(); err != nil {
+		t.Fatal(err)
+	}
+	j := &Job{Name: "j", Tasks: make(map[string]*Task)}
+	if _, err := s.AddJob(j); err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Name: "a", JobId: j.Id, Cmd: "echo", Param: []string{"${window:20060102}", "${window}"}, RelTasks: make(map[string]*Task)}
+	if err := s.AddTask(task); err != nil {
+		t.Fatal(err)
+	}
+	g.Schedules.ScheduleList = append(g.Schedules.ScheduleList, s)
+
+	//[1日0点, 4日0点)内每日2点启动，共3个批次
+	from := time.Date(2015, 1, 1, 0, 0, 0, 0, time.Local)
+	to := from.AddDate(0, 0, 3)
+	windows, err := s.Windows(from, to)
+	if err != nil || len(windows) != 3 || !windows[0].Equal(from.Add(2*time.Hour)) {
+		t.Fatalf("want 3 windows, got %v err %v", windows, err)
+	}
+	if err = g.Schedules.Backfill(context.Background(), s.Id, from, to); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"20150101", "2015-01-01 02:00:00"},
+		{"20150102", "2015-01-02 02:00:00"},
+		{"20150103", "2015-01-03 02:00:00"},
+	}
+	if !reflect.DeepEqual(exec.params, want) {
+		t.Fatalf("want params %v, got %v", want, exec.params)
+	}
+
+	//任务失败时停止补数
+	exec.params, exec.Fail = nil, map[string]string{"a": "error"}
+	if err = g.Schedules.Backfill(context.Background(), s.Id, from, to); err == nil || len(exec.params) != 1 {
+		t.Fatalf("want backfill stopped at first window, got %d runs err %v", len(exec.params), err)
+	}
+
+	//已取消的ctx不启动任何批次
+	exec.params, exec.Fail = nil, nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = g.Schedules.Backfill(ctx, s.Id, from, to); err == nil || len(exec.params) != 0 {
+		t.Fatalf("want cancelled backfill, got %d runs err %v", len(exec.params), err)
+	}
+	if _, err = s.Windows(from, from.AddDate(10, 0, 0)); err == nil {
+		t.Fatal("want error for too many windows")
+	}
+}
+
+func TestNewExecutor(t *testing.T) {
+	if e, err := NewExecutor(ExecutorRPC); err != nil || reflect.TypeOf(e) != reflect.TypeOf(&rpcExecutor{}) {
+		t.Fatalf("want rpc executor, got %T err %v", e, err)
+	}
+
+	RegisterExecutor("sync", func() Executor { return &SyncExecutor{} })
+	e, err := NewExecutor("sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.(*SyncExecutor); !ok {
+		t.Fatalf("want sync executor, got %T", e)
+	}
+	if _, err = NewExecutor("grpc"); err == nil || !strings.Contains(err.Error(), "rpc sync") {
+		t.Fatalf("want error listing registered executors, got %v", err)
+	}
+}
+
+//CmdExecuter是测试用的Worker，返回任务的命令
+type CmdExecuter struct{}
+
+func (ce *CmdExecuter) Run(task *Task, reply *Reply) error {
+	reply.Stdout = task.Cmd
+	return nil
+}
+
+//newTestCert生成127.0.0.1的自签名证书
+func newTestCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestDialWorker(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.Registry = prometheus.NewRegistry()
+
+	cert, pool := newTestCert(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	server := rpc.NewServer()
+	server.Register(&CmdExecuter{})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				line, err := readLine(conn, 64)
+				if err != nil || line != authPrefix+"secret" {
+					fmt.Fprintln(conn, authDenied)
+					conn.Close()
+					return
+				}
+				fmt.Fprintln(conn, authOK)
+				server.ServeConn(conn)
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	g.Port = ":" + port
+	task := &Task{Name: "a", Address: "127.0.0.1", Cmd: "echo"}
+	exec := &rpcExecutor{}
+
+	//未设置TLS时不使用明文连接
+	if err = exec.Run(task, &Reply{}); err == nil || !strings.Contains(err.Error(), "TLS is not configured") {
+		t.Fatalf("want TLS not configured error, got %v", err)
+	}
+
+	g.WorkerTLS, g.WorkerToken = &tls.Config{RootCAs: pool}, "secret"
+	reply := &Reply{}
+	if err = exec.Run(task, reply); err != nil || reply.Stdout != "echo" {
+		t.Fatalf("want echo, got %q err %v", reply.Stdout, err)
+	}
+
+	g.WorkerToken = "wrong"
+	if err = exec.Run(task, &Reply{}); err == nil || !strings.Contains(err.Error(), "auth is rejected") {
+		t.Fatalf("want auth error, got %v", err)
+	}
+
+	//不信任Worker的证书
+	g.WorkerTLS, g.WorkerToken = &tls.Config{RootCAs: x509.NewCertPool()}, "secret"
+	if err = exec.Run(task, &Reply{}); err == nil || !strings.Contains(err.Error(), "TLS handshake") {
+		t.Fatalf("want handshake error, got %v", err)
+	}
+
+	mfs, err := g.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, mf := range mfs {
+		names[mf.GetName()] = true
+	}
+	if !names["worker_conn_rejected_total"] {
+		t.Fatalf("want rejected connections counted, got %v", names)
+	}
+}
+
+func TestScheduleTags(t *testing.T) {
+	g = DefaultGlobal()
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+	g.L.Out = ioutil.Discard
+	sl := g.Schedules
+
+	fin := &Schedule{Name: "fin", Tags: []string{"finance", "daily"}}
+	ops := &Schedule{Name: "ops", Tags: []string{"ops"}}
+	for _, s := range []*Schedule{ops, fin} {
+		if _, err := sl.AddSchedule(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if scds := sl.ListByTag("finance"); len(scds) != 1 || scds[0] != fin {
+		t.Fatalf("want schedule fin, got %v", scds)
+	}
+	if scds := sl.ListByTag("Finance"); len(scds) != 0 {
+		t.Fatalf("tags should be case sensitive, got %v", scds)
+	}
+
+	//UpdateSchedule整体替换标签，成功后会通知Timer重新计时
+	update := func(s *Schedule) {
+		go func() { <-s.isRefresh }()
+		if err := s.UpdateSchedule(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ops.Tags = []string{"ops", "finance"}
+	update(ops)
+	fin.Tags = []string{"daily", "daily"}
+	var ve *ValidationError
+	if err := fin.UpdateSchedule(); !errors.As(err, &ve) || ve.Errors[0].Field != "Tags[1]" {
+		t.Fatalf("want duplicated tag error, got %v", err)
+	}
+	fin.Tags = []string{"daily"}
+	update(fin)
+
+	//重新加载后标签从元数据库恢复
+	if err := sl.getAllSchedules(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	scds := sl.ListByTag("finance")
+	if len(scds) != 1 || scds[0].Id != ops.Id || !reflect.DeepEqual(scds[0].Tags, []string{"finance", "ops"}) {
+		t.Fatalf("want schedule ops with tags [finance ops], got %v", scds)
+	}
+
+	if err := sl.DeleteSchedule(ops.Id); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, db, "scd_schedule_tag"); n != 1 {
+		t.Fatalf("want 1 tag left, got %d", n)
+	}
+}
+
+//dispatchExecutor依次返回errs中的错误，用完后执行成功
+type dispatchExecutor struct {
+	errs []error
+	sent int
+}
+
+func (de *dispatchExecutor) Run(task *Task, reply *Reply) error {
+	de.sent++
+	if len(de.errs) == 0 {
+		reply.Stdout = "ok"
+		return nil
+	}
+	err := de.errs[0]
+	de.errs = de.errs[1:]
+	return err
+}
+
+func TestDispatchBackoff(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.RetryJitter = JitterNone
+	g.DispatchBackoff, g.DispatchBackoffMax = 10*time.Millisecond, 15*time.Millisecond
+	newTask := func() *ExecTask {
+		return &ExecTask{task: &Task{Name: "a", Address: "w1"}, log: logrus.NewEntry(g.L), done: make(chan struct{})}
+	}
+	refused := errors.New("connect task.Address[w1:3128] error dial tcp: connection refused")
+
+	//暂时性错误退避后重试，等待时间翻倍且不超过上限
+	exec := &dispatchExecutor{errs: []error{refused, &DispatchError{Addr: "w1", Code: 503}, refused}}
+	g.Executor = exec
+	et, reply := newTask(), &Reply{}
+	if err := et.dispatch(et.task, reply); err != nil || reply.Stdout != "ok" {
+		t.Fatalf("want dispatched after retries, got %q err %v", reply.Stdout, err)
+	}
+	if et.dispatchRetry != 3 || et.dispatchBackoff != 40*time.Millisecond {
+		t.Fatalf("want 3 retries with 40ms backoff, got %d %s", et.dispatchRetry, et.dispatchBackoff)
+	}
+
+	//重试次数用完时返回最后一次的错误
+	g.DispatchRetry = 1
+	exec = &dispatchExecutor{errs: []error{refused, refused}}
+	g.Executor = exec
+	et = newTask()
+	if err := et.dispatch(et.task, &Reply{}); err != refused || exec.sent != 2 {
+		t.Fatalf("want refused after 2 dispatches, got %v sent %d", err, exec.sent)
+	}
+
+	//永久性错误立即失败，自定义状态码按暂时性错误重试
+	g.DispatchRetry = 3
+	rejected := &DispatchError{Addr: "w1", Code: 403}
+	exec = &dispatchExecutor{errs: []error{rejected}}
+	g.Executor = exec
+	et = newTask()
+	if err := et.dispatch(et.task, &Reply{}); err != rejected || exec.sent != 1 || et.dispatchRetry != 0 {
+		t.Fatalf("want fail fast, got %v sent %d", err, exec.sent)
+	}
+	g.DispatchClassifier = NewCodeClassifier(403)
+	exec = &dispatchExecutor{errs: []error{rejected}}
+	g.Executor = exec
+	et = newTask()
+	if err := et.dispatch(et.task, &Reply{}); err != nil || et.dispatchRetry != 1 {
+		t.Fatalf("want code 403 retried, got %v retry %d", err, et.dispatchRetry)
+	}
+
+	//等待会超过超时时间时不再重试
+	exec = &dispatchExecutor{errs: []error{refused}}
+	g.Executor = exec
+	et = newTask()
+	et.deadline, et.deadlineBy = time.Now().Add(time.Millisecond), TimeoutSchedule
+	if err := et.dispatch(et.task, &Reply{}); err != refused || exec.sent != 1 {
+		t.Fatalf("want give up before deadline, got %v sent %d", err, exec.sent)
+	}
+}
+
+func TestScheduleEnabled(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+	clock := newFakeClock(time.Now())
+	g.Clock = clock
+	sl := g.Schedules
+
+	//JSON中没有Enabled时按启用处理
+	var js Schedule
+	if err := json.Unmarshal([]byte(`{"Name":"j"}`), &js); err != nil || !js.Enabled {
+		t.Fatalf("want enabled by default, got %v %v", js.Enabled, err)
+	}
+	if err := json.Unmarshal([]byte(`{"Name":"j","Enabled":false}`), &js); err != nil || js.Enabled {
+		t.Fatalf("want disabled, got %v %v", js.Enabled, err)
+	}
+
+	//禁用的调度加载后不启动监听
+	s := &Schedule{Name: "manual", Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{time.Hour}}
+	if _, err := sl.AddSchedule(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := sl.InitScheduleList(); err != nil {
+		t.Fatal(err)
+	}
+	s = sl.GetScheduleById(s.Id)
+	if s == nil || s.Enabled {
+		t.Fatalf("want disabled schedule loaded, got %+v", s)
+	}
+	if err := sl.StartListener(); err != nil {
+		t.Fatal(err)
+	}
+	defer sl.StopListener()
+	select {
+	case d := <-clock.waits:
+		t.Fatalf("disabled schedule should not wait, waiting %s", d)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if st, err := sl.GetScheduleStatus(s.Id); err != nil || st != StatusDisabled {
+		t.Fatalf("want disabled, got %s %v", st, err)
+	}
+
+	//启用后开始计时，禁用后停止
+	wait := func() {
+		select {
+		case <-clock.waits:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timer is not waiting")
+		}
+	}
+	s.Enabled = true
+	if err := s.UpdateSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+	time.Sleep(50 * time.Millisecond)
+	s.Enabled = false
+	if err := s.UpdateSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s.isRefresh <- true:
+		t.Fatal("timer is still waiting after disabled")
+	case <-time.After(50 * time.Millisecond):
+	}
+	var enabled bool
+	if err := db.QueryRow("SELECT scd_enabled FROM scd_schedule WHERE scd_id=?", s.Id).Scan(&enabled); err != nil || enabled {
+		t.Fatalf("want disabled persisted, got %v %v", enabled, err)
+	}
+	s.Enabled = true
+	if err := s.UpdateSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+}
+
+func TestDBContextCancelled(t *testing.T) {
+	g = DefaultGlobal()
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn, g.LogConn = db, db
+	g.L.Out = ioutil.Discard
+	sl := g.Schedules
+
+	s := &Schedule{Name: "ctx"}
+	if _, err := sl.AddSchedule(s); err != nil {
+		t.Fatal(err)
+	}
+
+	//已取消的context不会执行查询，也不会重试，调度列表保持不变
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g.DBMaxRetry, g.DBBackoff = 3, time.Hour
+	if err := sl.getAllSchedules(ctx); err == nil || !strings.Contains(err.Error(), "canceled") {
+		t.Fatalf("want canceled error, got %v", err)
+	}
+	if len(sl.ScheduleList) != 1 {
+		t.Fatalf("schedule list should be kept, got %d", len(sl.ScheduleList))
+	}
+	if err := (&Schedule{Id: s.Id}).getSchedule(ctx); err == nil || !strings.Contains(err.Error(), "canceled") {
+		t.Fatalf("want canceled error, got %v", err)
+	}
+	if err := (&Job{Id: 1}).getJob(ctx); err == nil || !strings.Contains(err.Error(), "canceled") {
+		t.Fatalf("want canceled error, got %v", err)
+	}
+
+	//超过DBTimeout的操作返回注明超时的error
+	g.DBTimeout = time.Nanosecond
+	ctx, cancel = dbContext(context.Background())
+	defer cancel()
+	<-ctx.Done()
+	if _, err := execDB(ctx, db, "DELETE FROM scd_schedule"); err == nil || !strings.Contains(err.Error(), "timeout after 1ns") {
+		t.Fatalf("want timeout error, got %v", err)
+	}
+	if err := sl.InitScheduleList(); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("want timeout error, got %v", err)
+	}
+	if n := count(t, db, "scd_schedule"); n != 1 {
+		t.Fatalf("want 1 schedule, got %d", n)
+	}
+}
+
+func TestStartWeekday(t *testing.T) {
+	g = DefaultGlobal()
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+	g.L.Out = ioutil.Discard
+
+	//启动星期取值0-6且不重复，只用于按周调度，设置后启动时间不超过一天
+	bad := []*Schedule{
+		{Cyc: "w", StartWeekday: []time.Weekday{7}},
+		{Cyc: "w", StartWeekday: []time.Weekday{1, 1}},
+		{Cyc: "d", StartWeekday: []time.Weekday{1}},
+		{Cyc: "w", StartWeekday: []time.Weekday{1}, StartMonth: []int{0}, StartSecond: []time.Duration{24 * time.Hour}},
+	}
+	for _, s := range bad {
+		if err := s.Validate(); err == nil {
+			t.Fatalf("want validation error for %v", s.StartWeekday)
+		}
+	}
+
+	//每周一、周四6点启动，按纽约时间计算，3月10日切换夏令时
+	s := &Schedule{Name: "weekly", Cyc: "w", TimeZone: "America/New_York",
+		StartWeekday: []time.Weekday{time.Thursday, time.Monday},
+		StartMonth:   []int{0}, StartSecond: []time.Duration{6 * time.Hour}}
+	if _, err := g.Schedules.AddSchedule(s); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.StartWeekday, []time.Weekday{time.Monday, time.Thursday}) {
+		t.Fatalf("want weekdays sorted, got %v", s.StartWeekday)
+	}
+	loc, _ := time.LoadLocation("America/New_York")
+	from := time.Date(2024, 3, 6, 0, 0, 0, 0, loc)
+	windows, err := s.Windows(from, from.AddDate(0, 0, 14))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Time{
+		time.Date(2024, 3, 7, 6, 0, 0, 0, loc),
+		time.Date(2024, 3, 11, 6, 0, 0, 0, loc),
+		time.Date(2024, 3, 14, 6, 0, 0, 0, loc),
+		time.Date(2024, 3, 18, 6, 0, 0, 0, loc),
+	}
+	if len(windows) != len(want) {
+		t.Fatalf("want %v, got %v", want, windows)
+	}
+	for i := range want {
+		if !windows[i].Equal(want[i]) {
+			t.Fatalf("want %v, got %v", want, windows)
+		}
+	}
+
+	//启动星期持久化后重新加载
+	if err = g.Schedules.getAllSchedules(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ls := g.Schedules.GetScheduleById(s.Id)
+	if ls == nil || !reflect.DeepEqual(ls.StartWeekday, []time.Weekday{time.Monday, time.Thursday}) {
+		t.Fatalf("want weekdays [Monday Thursday] after reload, got %v", ls)
+	}
+	if err = g.Schedules.DeleteSchedule(s.Id); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, db, "scd_start_weekday"); n != 0 {
+		t.Fatalf("want weekdays deleted, got %d", n)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	//没有执行中的批次时直接关闭连接
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	db := openTestDB(t)
+	g.HiveConn, g.LogConn = db, db
+	if err := g.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err == nil {
+		t.Fatal("database should be closed")
+	}
+
+	g = DefaultGlobal()
+	drained := &signalWriter{match: "of cancelled batchTaskId", c: make(chan struct{})}
+	g.L.Out = drained
+	g.NoLog = true
+	db = openTestDB(t)
+	g.HiveConn, g.LogConn = db, db
+	exec := &abortExecutor{started: make(chan string, 1), abort: make(chan struct{})}
+	g.Executor = exec
+	events, _ := g.Schedules.Subscribe()
+
+	es := ExecScheduleWarper(newTestSchedule())
+	es.execType = 2
+	g.Schedules.AddExecSchedule(es)
+	if err := es.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	end := make(chan struct{})
+	go func() {
+		es.Run()
+		close(end)
+	}()
+	select {
+	case <-exec.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task b is not started")
+	}
+
+	//超时后中止执行中的批次，仍然关闭连接
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := g.Shutdown(ctx); err == nil || !strings.Contains(err.Error(), "1 running batches are cancelled") {
+		t.Fatalf("want batch cancelled, got %v", err)
+	}
+	select {
+	case <-end:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled run did not end")
+	}
+	select {
+	case <-drained.c:
+	case <-time.After(5 * time.Second):
+		t.Fatal("aborted task did not end")
+	}
+
+	//事件通道被关闭
+	timeout := time.After(5 * time.Second)
+	for open := true; open; {
+		select {
+		case _, open = <-events:
+		case <-timeout:
+			t.Fatal("event channel is not closed")
+		}
+	}
+
+	//之后的操作返回ErrShutdown
+	if _, err := g.Schedules.RunScheduleNow(1); err != ErrShutdown {
+		t.Fatalf("want ErrShutdown, got %v", err)
+	}
+	if _, err := g.Schedules.AddSchedule(&Schedule{Name: "new"}); err != ErrShutdown {
+		t.Fatalf("want ErrShutdown, got %v", err)
+	}
+	if _, err := queryHive(context.Background(), "SELECT 1"); err != ErrShutdown {
+		t.Fatalf("want ErrShutdown, got %v", err)
+	}
+	if err := g.Shutdown(context.Background()); err != ErrShutdown {
+		t.Fatalf("want ErrShutdown, got %v", err)
+	}
+}
+
+func TestTaskParams(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.NoLog = true
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+	exec := &paramExecutor{}
+	g.Executor = exec
+
+	//任务的参数覆盖调度的同名参数
+	merged := mergeParams(map[string]string{"ENV": "prod", "DT": "x"}, map[string]string{"DT": "y"})
+	if !reflect.DeepEqual(merged, map[string]string{"ENV": "prod", "DT": "y"}) {
+		t.Fatalf("want task param to win, got %v", merged)
+	}
+
+	s := &Schedule{Name: "params", Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{2 * time.Hour},
+		Params: map[string]string{"ENV": "prod", "DT": "{{.RunDate}}"}}
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddScheduleStart(); err != nil {
+		t.Fatal(err)
+	}
+	j := &Job{Name: "j", Tasks: make(map[string]*Task)}
+	if _, err := s.AddJob(j); err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Name: "a", JobId: j.Id, Cmd: "echo", RelTasks: make(map[string]*Task),
+		Params: map[string]string{"HOUR": `{{.RunTime.Format "15"}}`, "TASK": "{{.Task}}"}}
+	if err := s.AddTask(task); err != nil {
+		t.Fatal(err)
+	}
+
+	//运行参数持久化后重新初始化
+	ls := &Schedule{Id: s.Id}
+	if err := ls.InitSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ls.Params, s.Params) || len(ls.Tasks) != 1 || !reflect.DeepEqual(ls.Tasks[0].Params, task.Params) {
+		t.Fatalf("want params reloaded, got %v %v", ls.Params, ls.Tasks)
+	}
+	g.Schedules.ScheduleList = append(g.Schedules.ScheduleList, ls)
+
+	//补数时按补数的启动时间执行模板
+	from := time.Date(2015, 1, 1, 0, 0, 0, 0, time.Local)
+	if err := g.Schedules.Backfill(context.Background(), s.Id, from, from.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]string{{"ENV": "prod", "DT": "2015-01-01", "HOUR": "02", "TASK": "a"}}
+	if !reflect.DeepEqual(exec.env, want) {
+		t.Fatalf("want params %v, got %v", want, exec.env)
+	}
+
+	//模板不合法或引用了不存在的字段时初始化失败
+	for _, p := range []map[string]string{{"DT": "{{.RunDate"}, {"DT": "{{.Unknown}}"}, {"1DT": "x"}} {
+		task.Params = p
+		if err := task.UpdateTask(); err != nil {
+			t.Fatal(err)
+		}
+		if err := (&Schedule{Id: s.Id}).InitSchedule(); err == nil {
+			t.Fatalf("want init error for params %v", p)
+		}
+	}
+
+	if err := s.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, db, "scd_schedule_env") + count(t, db, "scd_task_env"); n != 0 {
+		t.Fatalf("want params deleted, got %d", n)
+	}
+}
+
+func TestExecScheduleReap(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.NoLog = true
+	g.Registry = prometheus.NewRegistry()
+	g.Executor = &SyncExecutor{}
+	listed := func() int {
+		g.Schedules.lock.RLock()
+		defer g.Schedules.lock.RUnlock()
+		return len(g.Schedules.ExecScheduleList)
+	}
+	gauge := func() float64 {
+		mfs, err := g.Registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, mf := range mfs {
+			if mf.GetName() == "exec_schedules" {
+				return mf.GetMetric()[0].GetGauge().GetValue()
+			}
+		}
+		return -1
+	}
+
+	//批次结束后从执行列表中移除
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		es := ExecScheduleWarper(newTestSchedule())
+		es.batchId, es.execType = fmt.Sprintf("b%d", i), 2
+		g.Schedules.AddExecSchedule(es)
+		if err := es.InitExecSchedule(); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			es.Run()
+		}()
+	}
+	wg.Wait()
+	if n := listed(); n != 0 {
+		t.Fatalf("want execution list empty, got %d", n)
+	}
+	if v := gauge(); v != 0 {
+		t.Fatalf("want exec_schedules 0, got %v", v)
+	}
+
+	//执行线程因错误提前退出时同样移除
+	es := ExecScheduleWarper(newTestSchedule())
+	es.execType = 2
+	g.Schedules.AddExecSchedule(es)
+	if err := es.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "log.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	g.NoLog, g.LogConn = false, db
+	es.Run()
+	g.NoLog = true
+	if listed() != 0 || es.state != 4 {
+		t.Fatalf("want failed run removed with state 4, got %d runs state %d", listed(), es.state)
+	}
+
+	//超过ExecMaxAge的批次先请求中止，仍未结束时移除
+	g.ExecMaxAge = time.Hour
+	orphan := ExecScheduleWarper(newTestSchedule())
+	g.Schedules.AddExecSchedule(orphan)
+	cancelled := make(map[string]bool)
+	if n := g.Schedules.reap(time.Now(), cancelled); n != 0 || len(cancelled) != 0 {
+		t.Fatalf("want young batch kept, got %d removed", n)
+	}
+	later := time.Now().Add(2 * time.Hour)
+	if n := g.Schedules.reap(later, cancelled); n != 0 || !cancelled[orphan.batchId] {
+		t.Fatalf("want stale batch cancelled first, got %d removed %v", n, cancelled)
+	}
+	if n := g.Schedules.reap(later, cancelled); n != 1 || len(cancelled) != 0 {
+		t.Fatalf("want stale batch removed, got %d removed %v", n, cancelled)
+	}
+	if listed() != 0 || gauge() != 0 {
+		t.Fatalf("want execution list empty, got %d gauge %v", listed(), gauge())
+	}
+}
+
+func TestMemStore(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	ms := NewMemStore()
+	g.MetaStore = ms
+
+	s := &Schedule{Name: "mem", Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{time.Hour},
+		Tags: []string{"ops", "finance"}, Params: map[string]string{"DT": "{{.RunDate}}"}}
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddScheduleStart(); err != nil {
+		t.Fatal(err)
+	}
+	j1, j2 := &Job{Name: "j1"}, &Job{Name: "j2"}
+	for _, j := range []*Job{j1, j2} {
+		if _, err := s.AddJob(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := &Task{Name: "a", JobId: j1.Id, Cmd: "echo", Param: []string{"p"}}
+	b := &Task{Name: "b", JobId: j2.Id, Cmd: "echo", Params: map[string]string{"TASK": "{{.Task}}"}}
+	for _, task := range []*Task{a, b} {
+		if err := s.AddTask(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.AddRelTaskOn(a, RelAlways); err != nil {
+		t.Fatal(err)
+	}
+
+	//不连接数据库，从内存存储初始化调度链
+	ls := &Schedule{Id: s.Id}
+	if err := ls.InitSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	if ls.Name != "mem" || !reflect.DeepEqual(ls.Tags, []string{"finance", "ops"}) || !reflect.DeepEqual(ls.Params, s.Params) {
+		t.Fatalf("want schedule reloaded, got %v %v %v", ls.Name, ls.Tags, ls.Params)
+	}
+	if len(ls.Jobs) != 2 || ls.Jobs[0].Id != j1.Id || ls.Jobs[1].Id != j2.Id || len(ls.Tasks) != 2 {
+		t.Fatalf("want 2 jobs and 2 tasks, got %d %d", len(ls.Jobs), len(ls.Tasks))
+	}
+	lb := ls.GetTaskById(b.Id)
+	if lb == nil || lb.RelTaskCnt != 1 || lb.relCondition(a.Id) != RelAlways || !reflect.DeepEqual(lb.Params, b.Params) {
+		t.Fatalf("want task b depends on a always, got %v", lb)
+	}
+	if la := ls.GetTaskById(a.Id); la == nil || !reflect.DeepEqual(la.Param, []string{"p"}) {
+		t.Fatalf("want task a param [p], got %v", la)
+	}
+
+	//修改读取到的结构不影响已保存的数据
+	ls.Tags[0], lb.Params["TASK"] = "x", "y"
+	if err := g.Schedules.getAllSchedules(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Schedules.ScheduleList) != 1 || g.Schedules.ScheduleList[0].Tags[0] != "finance" {
+		t.Fatalf("want stored schedule unchanged, got %v", g.Schedules.ScheduleList)
+	}
+	if tb := (&Task{Id: b.Id}); ms.GetTask(tb) != nil || tb.Params["TASK"] != "{{.Task}}" {
+		t.Fatalf("want stored task unchanged, got %v", tb.Params)
+	}
+
+	//事务中出错时回滚
+	err := ms.InTx(func(tx MetaStore) error {
+		if err := tx.AddJob(&Job{Name: "j3"}); err != nil {
+			return err
+		}
+		return errors.New("abort")
+	})
+	if next, _ := ms.GetJobNext(); err == nil || len(next) != 2 || next[j1.Id] != j2.Id {
+		t.Fatalf("want add job rolled back, got %v %v", err, next)
+	}
+
+	//删除调度后元数据全部清除
+	if err := s.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	scds, _ := ms.GetAllSchedules(context.Background())
+	next, _ := ms.GetJobNext()
+	if len(scds) != 0 || len(next) != 0 || len(ms.data.tasks) != 0 {
+		t.Fatalf("want store empty, got %d schedules %d jobs %d tasks", len(scds), len(next), len(ms.data.tasks))
+	}
+}
+
+func TestStartJitter(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	ms := NewMemStore()
+	g.MetaStore = ms
+	start := time.Date(2015, 1, 1, 0, 30, 0, 0, time.Local)
+	clock := newFakeClock(start)
+	g.Clock = clock
+
+	s := &Schedule{Name: "jitter", Enabled: true, Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{time.Hour}, StartJitter: -time.Second}
+	var ve *ValidationError
+	if err := s.Add(); !errors.As(err, &ve) || ve.Errors[0].Field != "StartJitter" {
+		t.Fatalf("want negative jitter error, got %v", err)
+	}
+	s.StartJitter = 10 * time.Minute
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddScheduleStart(); err != nil {
+		t.Fatal(err)
+	}
+	g.Schedules.ScheduleList = append(g.Schedules.ScheduleList, s)
+	defer g.Schedules.StopListener()
+
+	//使用注入的时钟时推迟时长可以重现
+	nominal := start.Add(30 * time.Minute)
+	jitter := s.startJitter(nominal)
+	if jitter < 0 || jitter > s.StartJitter || s.startJitter(nominal) != jitter {
+		t.Fatalf("want reproducible jitter in 0-10m, got %s", jitter)
+	}
+
+	//使用系统时间时随机推迟
+	g.Clock = realClock{}
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 10; i++ {
+		seen[s.startJitter(nominal)] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("want random jitter with the real clock")
+	}
+	g.Clock = clock
+
+	go s.Timer()
+	select {
+	case d := <-clock.waits:
+		if d != 30*time.Minute+jitter {
+			t.Fatalf("want countdown %s, got %s", 30*time.Minute+jitter, d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timer is not waiting")
+	}
+
+	//保存与报告的是推迟后的启动时间
+	g.Schedules.lock.RLock()
+	next := s.NextStart
+	g.Schedules.lock.RUnlock()
+	ls := &Schedule{Id: s.Id}
+	if err := ms.GetSchedule(context.Background(), ls); err != nil {
+		t.Fatal(err)
+	}
+	if want := nominal.Add(jitter); !next.Equal(want) || !ls.NextStart.Equal(want) || ls.StartJitter != s.StartJitter {
+		t.Fatalf("want next start %s, got %s saved %s", want, next, ls.NextStart)
+	}
+}
+
+func TestScheduleByName(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.MetaStore = NewMemStore()
+	sl := g.Schedules
+
+	a := &Schedule{Name: "dup"}
+	b := &Schedule{Name: "dup"}
+	c := &Schedule{Name: "other"}
+	for _, s := range []*Schedule{a, b, c} {
+		if _, err := sl.AddSchedule(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s := sl.GetScheduleByName("dup"); s != a {
+		t.Fatalf("want schedule %d, got %v", a.Id, s)
+	}
+	if s := sl.GetScheduleByName("none"); s != nil {
+		t.Fatalf("want nil, got %d", s.Id)
+	}
+	if scds := sl.GetSchedulesByName("dup"); len(scds) != 2 || scds[0] != a || scds[1] != b {
+		t.Fatalf("want 2 schedules, got %v", scds)
+	}
+	if scds := sl.GetSchedulesByName("none"); scds == nil || len(scds) != 0 {
+		t.Fatalf("want empty list, got %v", scds)
+	}
+
+	//要求名称唯一时拒绝重名
+	g.UniqueNames = true
+	var ve *ValidationError
+	if _, err := sl.AddSchedule(&Schedule{Name: "other"}); !errors.As(err, &ve) || ve.Errors[0].Field != "Name" {
+		t.Fatalf("want name error, got %v", err)
+	}
+	c.Name = "dup"
+	if err := c.UpdateSchedule(); !errors.As(err, &ve) || ve.Errors[0].Field != "Name" {
+		t.Fatalf("want name error, got %v", err)
+	}
+	c.Name = "other"
+	if err := c.UpdateSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sl.AllSchedules()) != 3 {
+		t.Fatalf("want 3 schedules, got %d", len(sl.AllSchedules()))
+	}
+}
+
+func TestLastRun(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	db := openTestDB(t)
+	defer db.Close()
+	g.LogConn = db
+
+	s := newTestSchedule()
+	g.Schedules.ScheduleList = append(g.Schedules.ScheduleList, s)
+	run := func(exec Executor) *ExecSchedule {
+		g.Executor = exec
+		es := ExecScheduleWarper(s)
+		es.execType = 2
+		if err := es.InitExecSchedule(); err != nil {
+			t.Fatal(err)
+		}
+		es.Run()
+		return es
+	}
+
+	es := run(&SyncExecutor{})
+	info := g.Schedules.ListSchedules()[0]
+	if info.LastRunStatus != RunSuccess || info.LastError != "" || !info.LastRunTime.Equal(es.startTime) ||
+		info.LastRunDuration != es.endTime.Sub(es.startTime) {
+		t.Fatalf("want success of batch %s, got %+v", es.batchId, info)
+	}
+
+	//b执行失败，记录失败的原因
+	es = run(&SyncExecutor{Fail: map[string]string{"b": "boom"}})
+	info = g.Schedules.ListSchedules()[0]
+	if info.LastRunStatus != RunFailed || !strings.Contains(info.LastError, "boom") || !info.LastRunTime.Equal(es.startTime) {
+		t.Fatalf("want failure of batch %s, got %+v", es.batchId, info)
+	}
+
+	//重启后从日志库中最新的批次记录恢复
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.LogConn = db
+	g.Schedules.ScheduleList = append(g.Schedules.ScheduleList, &Schedule{Id: s.Id, Enabled: true})
+	g.Schedules.restoreRunResults()
+	restored := g.Schedules.ListSchedules()[0]
+	if restored.LastRunStatus != RunFailed || restored.LastError != info.LastError || restored.LastRunTime.Unix() != info.LastRunTime.Unix() ||
+		restored.Status != StatusError {
+		t.Fatalf("want restored %+v, got %+v", info, restored)
+	}
+}
+
+func TestTaskExecutor(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	stuck := make(chan struct{})
+	defer close(stuck)
+	g.TaskExecutors = map[string]TaskExecutor{
+		"local": TaskFunc(func(ctx context.Context, task *Task, reply *Reply) error {
+			reply.Stdout = "local " + task.Cmd
+			return nil
+		}),
+		"block": TaskFunc(func(ctx context.Context, task *Task, reply *Reply) error {
+			<-ctx.Done()
+			reply.Err = ctx.Err().Error()
+			return nil
+		}),
+		//不响应ctx的TaskExecutor
+		"stuck": TaskFunc(func(ctx context.Context, task *Task, reply *Reply) error {
+			<-stuck
+			return nil
+		}),
+	}
+
+	s := newTestSchedule()
+	s.Tasks[0].ExecutorType = "local"
+	s.Tasks[1].ExecutorType, s.Tasks[1].TimeOut = "block", 1
+	s.Tasks[2].ExecutorType, s.Tasks[2].TimeOut = "stuck", 1
+	exec := &SyncExecutor{}
+	r, err := TestRun(s, nil, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exec.Order) != 0 {
+		t.Fatalf("want no task sent to worker, got %v", exec.Order)
+	}
+	byId := make(map[int64]TaskResult)
+	for _, tr := range r.Tasks {
+		byId[tr.TaskId] = tr
+	}
+	if a := byId[1]; a.State != 3 || a.Output != "local echo" {
+		t.Fatalf("unexpected result of a %+v", a)
+	}
+	//超过TimeOut时任务失败，不响应ctx的TaskExecutor也不会阻塞批次
+	if b := byId[2]; b.State != 4 {
+		t.Fatalf("want timeout of b, got %+v", b)
+	}
+	if c := byId[3]; c.State != 4 || c.ExitCode != -1 || !strings.HasPrefix(c.Output, "task is timeout") {
+		t.Fatalf("want timeout of c, got %+v", c)
+	}
+
+	//CancelRun中止在进程内执行的任务
+	task := &Task{Id: 9, Name: "block", ExecutorType: "block"}
+	et := &ExecTask{batchTaskId: "b.9", task: task, done: make(chan struct{})}
+	rl := &Reply{}
+	errc := make(chan error, 1)
+	go func() { errc <- et.execute(task, rl) }()
+	for et.abort() == "" {
+		time.Sleep(time.Millisecond)
+	}
+	if err = <-errc; err != nil || rl.Err != "task is cancelled" || rl.ExitCode != -1 {
+		t.Fatalf("want cancelled task, got %v %+v", err, rl)
+	}
+
+	task.ExecutorType = "none"
+	if err = et.execute(task, &Reply{}); err == nil {
+		t.Fatal("want error for unregistered executor type")
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	g = DefaultGlobal()
+	//被触发的调度结束后才能结束测试，d没有任务，触发d失败时c的执行已经结束
+	fired := &signalWriter{match: "[es.fireTriggers] trigger schedule [4]", c: make(chan struct{})}
+	g.L.Out = fired
+	g.NoLog = true
+	g.EventBuffer = 100
+	ms := NewMemStore()
+	g.MetaStore = ms
+	exec := &SyncExecutor{Fail: map[string]string{"ta": "boom"}}
+	g.Executor = exec
+	sl := g.Schedules
+
+	scds := make([]*Schedule, 0)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		s := &Schedule{Name: name}
+		if _, err := sl.AddSchedule(s); err != nil {
+			t.Fatal(err)
+		}
+		scds = append(scds, s)
+		if name == "d" {
+			break
+		}
+		j := &Job{Name: "j" + name}
+		if _, err := s.AddJob(j); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AddTask(&Task{Name: "t" + name, JobId: j.Id, Cmd: "echo"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b, c, d := scds[0], scds[1], scds[2], scds[3]
+
+	for _, tr := range [][2]int64{{a.Id, b.Id}, {b.Id, c.Id}, {c.Id, d.Id}} {
+		if err := sl.AddTrigger(tr[0], Trigger{ScheduleId: tr[1]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sl.AddTrigger(d.Id, Trigger{ScheduleId: a.Id}); err == nil {
+		t.Fatal("want error for cyclic triggers")
+	}
+	if err := sl.AddTrigger(a.Id, Trigger{ScheduleId: a.Id}); err == nil {
+		t.Fatal("want error for triggering itself")
+	}
+
+	events, cancel := sl.Subscribe()
+	defer cancel()
+	run := func() {
+		es := ExecScheduleWarper(a)
+		es.execType = 2
+		if err := es.InitExecSchedule(); err != nil {
+			t.Fatal(err)
+		}
+		es.Run()
+	}
+
+	//a执行失败，默认不触发下游调度
+	run()
+	for len(events) > 0 {
+		if ev := <-events; ev.Type == EventScheduleFired {
+			t.Fatalf("want no schedule fired, got %+v", ev)
+		}
+	}
+
+	//设置失败时也触发后，依次执行b、c
+	if err := sl.AddTrigger(a.Id, Trigger{ScheduleId: b.Id, OnFailure: true}); err != nil {
+		t.Fatal(err)
+	}
+	run()
+	select {
+	case <-fired.c:
+	case <-time.After(5 * time.Second):
+		t.Fatal("schedule c is not triggered")
+	}
+	exec.lock.Lock()
+	order := strings.Join(exec.Order, ",")
+	exec.lock.Unlock()
+	if order != "ta,ta,tb,tc" {
+		t.Fatalf("want order ta,ta,tb,tc, got %s", order)
+	}
+
+	//触发关系已持久化，删除下游调度时一并删除
+	la := &Schedule{Id: a.Id}
+	if err := ms.GetSchedule(context.Background(), la); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(la.Triggers, []Trigger{{ScheduleId: b.Id, OnFailure: true}}) {
+		t.Fatalf("want stored trigger, got %+v", la.Triggers)
+	}
+	if err := sl.DeleteSchedule(c.Id); err != nil {
+		t.Fatal(err)
+	}
+	lb := &Schedule{Id: b.Id}
+	if err := ms.GetSchedule(context.Background(), lb); err != nil || len(lb.Triggers) != 0 || len(sl.getTriggers(b.Id)) != 0 {
+		t.Fatalf("want triggers of b removed, got %+v %v", lb.Triggers, err)
+	}
+	if err := sl.DeleteTrigger(a.Id, b.Id); err != nil || len(sl.getTriggers(a.Id)) != 0 {
+		t.Fatalf("want trigger deleted, got %+v %v", sl.getTriggers(a.Id), err)
+	}
+}
+
+func TestResumeRun(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	db := openTestDB(t)
+	defer db.Close()
+	g.LogConn = db
+	g.LogAttempts = true
+	g.MetaStore = NewMemStore()
+	exec := &SyncExecutor{Fail: map[string]string{"b": "error"}}
+	g.Executor = exec
+	sl := g.Schedules
+
+	//b、c依赖a，d依赖b、c并引用c的产出物
+	s := &Schedule{Name: "resume"}
+	if _, err := sl.AddSchedule(s); err != nil {
+		t.Fatal(err)
+	}
+	j := &Job{Name: "j"}
+	if _, err := s.AddJob(j); err != nil {
+		t.Fatal(err)
+	}
+	tasks := make(map[string]*Task)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		task := &Task{Name: name, JobId: j.Id, Cmd: "echo", RelTasks: make(map[string]*Task)}
+		if name == "c" {
+			task.Cmd, task.Param = "##artifact", []string{"out", "s3://x"}
+		} else if name == "d" {
+			task.Param = []string{"${artifact:c.out}"}
+		}
+		if err := s.AddTask(task); err != nil {
+			t.Fatal(err)
+		}
+		tasks[name] = task
+	}
+	for _, rel := range [][2]string{{"b", "a"}, {"c", "a"}, {"d", "b"}, {"d", "c"}} {
+		if err := tasks[rel[0]].AddRelTask(tasks[rel[1]]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.InitSchedule(); err != nil {
+		t.Fatal(err)
+	}
+
+	//b失败，d暂停
+	es := ExecScheduleWarper(s)
+	es.execType = 2
+	if err := es.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	es.Run()
+	if es.failTaskCnt != 2 {
+		t.Fatalf("want 2 failed tasks, got %d", es.failTaskCnt)
+	}
+
+	if _, err := sl.ResumeRun("no such batch"); err == nil {
+		t.Fatal("resume an unknown batch should fail")
+	}
+	sl.AddExecSchedule(&ExecSchedule{batchId: es.batchId})
+	if _, err := sl.ResumeRun(es.batchId); err == nil {
+		t.Fatal("resume a running batch should fail")
+	}
+	sl.RemoveExecSchedule(es.batchId)
+	if err := Restore(es.batchId, s.Id+1); err == nil || sl.running(es.batchId) {
+		t.Fatal("restore a batch of another schedule should fail")
+	}
+
+	//只执行未完成的b、d，d仍能引用c的产出物
+	exec = &SyncExecutor{}
+	g.Executor = exec
+	if err := Restore(es.batchId, s.Id); err != nil {
+		t.Fatal(err)
+	}
+	if order := strings.Join(exec.Order, ","); order != "b,d" {
+		t.Fatalf("want order b,d, got %s", order)
+	}
+	info, err := sl.GetExecSchedule(es.batchId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Status != RunSuccess || info.SuccessTaskCnt != 4 || info.TaskCnt != 4 {
+		t.Fatalf("want resumed batch successful, got %+v", info)
+	}
+	var output string
+	if err = db.QueryRow("SELECT output FROM scd_task_log WHERE task_id=?", tasks["d"].Id).Scan(&output); err != nil || output != "echo s3://x" {
+		t.Fatalf("want artifact of c resolved, got %q %v", output, err)
+	}
+	if n := count(t, db, "scd_task_attempt_log"); n != 5 {
+		t.Fatalf("want 5 attempts, got %d", n)
+	}
+
+	if _, err = sl.ResumeRun(es.batchId); err == nil {
+		t.Fatal("resume a completed batch should fail")
+	}
+}
+
+func TestCheckChain(t *testing.T) {
+	g = DefaultGlobal()
+	g.NoLog = true
+
+	if err := ExecScheduleWarper(newTestSchedule()).InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+
+	//最后一个作业的NextJobId指向不存在的作业
+	s := newTestSchedule()
+	s.Jobs[2].NextJobId = 99
+	err := ExecScheduleWarper(s).InitExecSchedule()
+	if err == nil || !strings.Contains(err.Error(), "job [3] next job [99] is not found") {
+		t.Fatalf("want dangling next job error, got %v", err)
+	}
+
+	//d依赖的任务不在调度链中，不存在的任务全部列出
+	s = newTestSchedule()
+	d := s.Tasks[3]
+	d.RelTasks["7"] = nil
+	d.RelTasksId = []int64{2, 8}
+	err = ExecScheduleWarper(s).InitExecSchedule()
+	if err == nil || !strings.Contains(err.Error(), "task [4 d] depends on tasks [7 8] which are not found") {
+		t.Fatalf("want missing rel tasks error, got %v", err)
+	}
+}
+
+//flakyExecutor在SyncExecutor的基础上，任务a的前fails次执行失败
+type flakyExecutor struct {
+	SyncExecutor
+	fails int
+}
+
+func (fe *flakyExecutor) Run(task *Task, reply *Reply) error {
+	fe.lock.Lock()
+	fail := task.Name == "a" && fe.fails > 0
+	if fail {
+		fe.fails--
+	}
+	fe.lock.Unlock()
+	if err := fe.SyncExecutor.Run(task, reply); err != nil || !fail {
+		return err
+	}
+	reply.Err = "flaky"
+	return nil
+}
+
+func TestScheduleRetry(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	db := openTestDB(t)
+	defer db.Close()
+	g.LogConn = db
+
+	run := func(s *Schedule, fails int) (*ExecSchedule, *flakyExecutor) {
+		exec := &flakyExecutor{fails: fails}
+		g.Executor = exec
+		es := ExecScheduleWarper(s)
+		es.execType = 2
+		if err := es.InitExecSchedule(); err != nil {
+			t.Fatal(err)
+		}
+		es.Run()
+		g.Schedules.retries.Wait()
+		return es, exec
+	}
+
+	//a失败2次，第2次重试成功，3个批次依次关联
+	s := newTestSchedule()
+	s.ScheduleRetryCount = 3
+	es, exec := run(s, 2)
+	if n := len(exec.Order); n != 6 {
+		t.Fatalf("want 6 task runs, got %d %v", n, exec.Order)
+	}
+	final, err := g.Schedules.GetFinalExecSchedule(es.batchId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if final.Status != RunSuccess || final.RetryNo != 2 || final.BatchId == es.batchId {
+		t.Fatalf("want the second retry successful, got %+v", final)
+	}
+	prev, err := g.Schedules.GetExecSchedule(final.PrevBatchId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev.Status != RunFailed || prev.RetryNo != 1 || prev.PrevBatchId != es.batchId || prev.RetryBatchId != final.BatchId {
+		t.Fatalf("unexpected first retry %+v", prev)
+	}
+
+	//重试次数用完后仍然失败
+	s.ScheduleRetryCount = 1
+	es, exec = run(s, 5)
+	if final, err = g.Schedules.GetFinalExecSchedule(es.batchId); err != nil {
+		t.Fatal(err)
+	}
+	if final.Status != RunFailed || final.RetryNo != 1 || len(exec.Order) != 2 {
+		t.Fatalf("want 1 failed retry, got %+v %v", final, exec.Order)
+	}
+
+	//等待后会超过TimeOut时不重试
+	s.TimeOut, s.ScheduleRetryDelay = 1, 2
+	es, exec = run(s, 1)
+	if len(exec.Order) != 1 {
+		t.Fatalf("want no retry over timeout, got %v", exec.Order)
+	}
+
+	//调度有其它批次在执行，Overlap为跳过时不重试
+	s.TimeOut, s.ScheduleRetryDelay = 0, 0
+	other := ExecScheduleWarper(s)
+	g.Schedules.AddExecSchedule(other)
+	es, exec = run(s, 1)
+	g.Schedules.RemoveExecSchedule(other.batchId)
+	if len(exec.Order) != 1 {
+		t.Fatalf("want no retry while another batch is running, got %v", exec.Order)
+	}
+}
+
+//等待重试时批次的执行线程已返回，停止监听时放弃等待中的重试
+func TestScheduleRetryStop(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.NoLog = true
+	clock := newFakeClock(time.Now())
+	g.Clock = clock
+	exec := &flakyExecutor{fails: 1}
+	g.Executor = exec
+
+	s := newTestSchedule()
+	s.ScheduleRetryCount, s.ScheduleRetryDelay = 3, 60
+	es := ExecScheduleWarper(s)
+	es.execType = 2
+	if err := es.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	es.Run()
+	if d := clock.wait(t); d != time.Minute {
+		t.Fatalf("want retry after 1m, got %s", d)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		g.Schedules.StopListener()
+		g.Schedules.retries.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("retry is still waiting after the listener is stopped")
+	}
+	if len(exec.Order) != 1 {
+		t.Fatalf("want no retry after the listener is stopped, got %v", exec.Order)
+	}
+}
+
+func TestOnStartupPolicy(t *testing.T) {
+	now := time.Date(2024, 3, 10, 8, 0, 0, 0, time.Local)
+	saved := now.Add(-2 * time.Hour)
+
+	cases := []struct {
+		policy  string
+		next    time.Time
+		lastRun time.Time
+		run     bool
+		window  time.Time
+	}{
+		{StartupRunIfMissed, saved, saved.Add(-24 * time.Hour), true, saved},
+		{StartupRunIfMissed, saved, time.Time{}, true, saved},
+		{StartupRunIfMissed, saved, saved, false, time.Time{}},
+		{StartupRunIfMissed, now.Add(time.Hour), time.Time{}, false, time.Time{}},
+		{StartupRunIfMissed, time.Time{}, time.Time{}, false, time.Time{}},
+		{StartupAlwaysRun, now.Add(time.Hour), now, true, time.Time{}},
+		{StartupWait, saved, time.Time{}, false, time.Time{}},
+		{"", saved, time.Time{}, false, time.Time{}},
+	}
+	for i, c := range cases {
+		s := &Schedule{OnStartupPolicy: c.policy, NextStart: c.next, LastRunTime: c.lastRun}
+		run, window := s.startupWindow(now)
+		if run != c.run || !window.Equal(c.window) {
+			t.Errorf("case %d %s: want (%v %s), got (%v %s)", i, c.policy, c.run, c.window, run, window)
+		}
+	}
+
+	s := &Schedule{Name: "startup", OnStartupPolicy: "later"}
+	var ve *ValidationError
+	if err := s.Validate(); !errors.As(err, &ve) || len(ve.Errors) != 1 || ve.Errors[0].Field != "OnStartupPolicy" {
+		t.Fatalf("want OnStartupPolicy error, got %v", err)
+	}
+}
+
+//statusExecutor执行任务d前按状态查询执行中的任务
+type statusExecutor struct {
+	SyncExecutor
+	found map[TaskStatus][]ExecTaskInfo
+}
+
+func (se *statusExecutor) Run(task *Task, reply *Reply) error {
+	if task.Name == "d" {
+		se.found = make(map[TaskStatus][]ExecTaskInfo)
+		for _, st := range []TaskStatus{TaskPending, TaskRunning, TaskSuccess, TaskFailed} {
+			se.found[st] = g.Schedules.FindExecTasksByStatus(st)
+		}
+	}
+	return se.SyncExecutor.Run(task, reply)
+}
+
+func TestFindExecTasksByStatus(t *testing.T) {
+	g = DefaultGlobal()
+	g.NoLog = true
+	s := newTestSchedule()
+
+	es := ExecScheduleWarper(s)
+	g.Schedules.AddExecSchedule(es)
+	if err := es.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	if tasks := g.Schedules.FindExecTasksByStatus(TaskPending); len(tasks) != 4 || tasks[0].TaskName != "a" {
+		t.Fatalf("want 4 pending tasks, got %+v", tasks)
+	}
+	g.Schedules.RemoveExecSchedule(es.batchId)
+	if tasks := g.Schedules.FindExecTasksByStatus(TaskPending); len(tasks) != 0 {
+		t.Fatalf("want no task of removed batch, got %+v", tasks)
+	}
+
+	//d依赖b、c，开始时a、b成功，c执行失败
+	exec := &statusExecutor{SyncExecutor: SyncExecutor{Fail: map[string]string{"c": "error"}}}
+	s.Tasks[3].RelConditions = map[int64]string{3: RelAlways}
+	res, err := TestRun(s, nil, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := func(st TaskStatus) string {
+		ns := make([]string, 0)
+		for _, ti := range exec.found[st] {
+			if ti.BatchId != res.BatchId || ti.ScheduleId != s.Id || ti.Status != st || ti.Since.IsZero() {
+				t.Errorf("bad task info %+v", ti)
+			}
+			ns = append(ns, ti.TaskName)
+		}
+		return strings.Join(ns, " ")
+	}
+	if p, r, ok, f := names(TaskPending), names(TaskRunning), names(TaskSuccess), names(TaskFailed); p != "" || r != "d" || ok != "a b" || f != "c" {
+		t.Fatalf("want running d, success a b, failed c, got pending [%s] running [%s] success [%s] failed [%s]", p, r, ok, f)
+	}
+}
+
+func TestGetCountDown(t *testing.T) {
+	g = DefaultGlobal()
+	now := time.Date(2015, 1, 3, 10, 0, 0, 0, time.UTC)
+	g.Clock = newFakeClock(now)
+
+	cases := []struct {
+		name string
+		cyc  string
+		ss   []time.Duration
+		want time.Duration
+	}{
+		{"later today", "d", []time.Duration{12 * time.Hour}, 2 * time.Hour},
+		{"exactly now", "d", []time.Duration{10 * time.Hour}, 24 * time.Hour},
+		{"earlier today", "d", []time.Duration{8 * time.Hour}, 22 * time.Hour},
+		{"earliest later start", "d", []time.Duration{8 * time.Hour, 11 * time.Hour}, time.Hour},
+	}
+	for _, c := range cases {
+		sm := make([]int, len(c.ss))
+		d, err := getCountDown(c.cyc, sm, c.ss, time.UTC, time.Time{})
+		if err != nil || d != c.want {
+			t.Errorf("%s: want %s, got %s err %v", c.name, c.want, d, err)
+		}
+	}
+
+	//启动时间列表为空、周期无法计算时返回error，不返回小于等于0的时长
+	if d, err := getCountDown("d", nil, nil, time.UTC, time.Time{}); err == nil {
+		t.Errorf("empty StartSecond: want error, got %s", d)
+	}
+	if d, err := getCountDown("q", []int{0}, []time.Duration{0}, time.UTC, time.Time{}); err == nil {
+		t.Errorf("unsupported cycle: want error, got %s", d)
+	}
+}
+
+func TestTaskDisabled(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	s := newTestSchedule()
+	s.Tasks[1].Disabled = true
+
+	//禁用的b不发送执行，默认策略下依赖b的d仍然执行
+	exec := &SyncExecutor{}
+	r, err := TestRun(s, nil, exec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exec.Order) != 3 || exec.Order[2] != "d" || r.SkipTaskCnt != 1 {
+		t.Fatalf("want b skipped and d executed, got %+v order %v", r, exec.Order)
+	}
+	for _, n := range exec.Order {
+		if n == "b" {
+			t.Fatalf("disabled task b is dispatched, order %v", exec.Order)
+		}
+	}
+
+	//DisabledTaskSkip策略下依赖b的d同样被跳过
+	g.DisabledTaskPolicy = DisabledTaskSkip
+	exec = &SyncExecutor{}
+	if r, err = TestRun(s, nil, exec); err != nil {
+		t.Fatal(err)
+	}
+	if len(exec.Order) != 2 || r.SkipTaskCnt != 2 {
+		t.Fatalf("want b and d skipped, got %+v order %v", r, exec.Order)
+	}
+
+	//SetTaskDisabled修改并保存设置
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.MetaStore = NewMemStore()
+	ms := &Schedule{Name: "disabled"}
+	if _, err := g.Schedules.AddSchedule(ms); err != nil {
+		t.Fatal(err)
+	}
+	j := &Job{Name: "j"}
+	if _, err := ms.AddJob(j); err != nil {
+		t.Fatal(err)
+	}
+	a := &Task{Name: "a", JobId: j.Id, Cmd: "echo"}
+	if err := ms.AddTask(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Schedules.SetTaskDisabled(a.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	ls := &Schedule{Id: ms.Id}
+	if err := ls.InitSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Disabled || !ls.GetTaskById(a.Id).Disabled {
+		t.Fatal("want task disabled in memory and in the store")
+	}
+	if err := g.Schedules.SetTaskDisabled(a.Id+100, true); err == nil {
+		t.Fatal("want error for unknown task")
+	}
+}
+
+func TestScheduleErrorCode(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.MetaStore = NewMemStore()
+	sl := g.Schedules
+
+	//不存在的调度
+	err := sl.ReloadSchedule(99)
+	var se *ScheduleError
+	if !errors.As(err, &se) || se.Code != CodeNotFound || !IsNotFound(err) || IsConflict(err) {
+		t.Fatalf("want not found, got %#v", err)
+	}
+	if !strings.Contains(err.Error(), "[sl.ReloadSchedule] not found schedule by id 99") {
+		t.Fatalf("message is changed: %s", err)
+	}
+
+	//校验失败的调度，仍然可以取得ValidationError
+	_, err = sl.AddSchedule(&Schedule{Name: "bad", Cyc: "x"})
+	var ve *ValidationError
+	if !IsInvalid(err) || !errors.As(err, &ve) || IsNotFound(err) {
+		t.Fatalf("want invalid, got %v", err)
+	}
+
+	s := &Schedule{Name: "ok"}
+	if _, err = sl.AddSchedule(s); err != nil {
+		t.Fatal(err)
+	}
+	if err = sl.Snooze(s.Id, -time.Minute); !IsInvalid(err) {
+		t.Fatalf("want invalid, got %v", err)
+	}
+
+	//按策略拒绝启动的空调度
+	g.EmptyPolicy = EmptyRefuse
+	if err = sl.StartScheduleById(s.Id); !IsInvalid(err) || IsStoreError(err) {
+		t.Fatalf("want invalid for empty schedule, got %v", err)
+	}
+
+	//元数据库返回的不存在错误包装后保持原有的类型
+	if err = (&Schedule{Id: 99}).InitSchedule(); !IsNotFound(err) || IsStoreError(err) {
+		t.Fatalf("want not found from store, got %v", err)
+	}
+
+	//运行参数不正确时初始化失败为参数错误
+	cs := addTestSchedule(t)
+	cs.Params = map[string]string{"1DT": "{{.RunDate}}"}
+	if err = g.store().SaveScheduleParams(cs); err != nil {
+		t.Fatal(err)
+	}
+	if err = (&Schedule{Id: cs.Id}).InitSchedule(); !IsInvalid(err) || IsStoreError(err) {
+		t.Fatalf("want invalid for bad params, got %v", err)
+	}
+
+	//元数据库的读写错误在产生处即为CodeStore，不依赖上层调用的包装
+	db := openTestDB(t)
+	g.HiveConn = db
+	db.Close()
+	if err = (&Schedule{Id: s.Id}).setSnooze(); !IsStoreError(err) {
+		t.Fatalf("want store error, got %v", err)
+	}
+	if err = (&Schedule{Id: s.Id}).saveStatus(); !IsStoreError(err) {
+		t.Fatalf("want store error, got %v", err)
+	}
+
+	g.Shutdown(context.Background())
+	if _, err = sl.RunScheduleNow(s.Id); err != ErrShutdown || ErrorCodeOf(err) != CodeShutdown {
+		t.Fatalf("want ErrShutdown, got %v", err)
+	}
+}
+
+func TestLoadFromDirMemory(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.MetaStore = NewMemStore()
+	sl := g.Schedules
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("etl.yaml", `
+name: etl
+cyc: d
+retry_count: 1
+start:
+  - second: 7200
+jobs:
+  - name: extract
+    tasks:
+      - name: a
+        cmd: echo
+      - name: b
+        cmd: echo
+        rel: [a]
+`)
+	write("report.json", `{
+	"name": "report",
+	"cyc": "d",
+	"priority": 5,
+	"start": [{"month": 0, "second": 3600}],
+	"jobs": [{"name": "j", "tasks": [{"name": "r", "cmd": "echo", "disabled": true}]}]
+}`)
+	write("readme.txt", "not a schedule")
+
+	//目录中的YAML、JSON文件合并加载，调度只保存在内存存储中
+	scds, err := sl.LoadFromDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scds) != 2 || scds[0].Name != "etl" || scds[1].Name != "report" {
+		t.Fatalf("want schedules etl and report, got %v", scds)
+	}
+	etl, report := scds[0], scds[1]
+	if report.Priority != 5 || report.StartSecond[0] != time.Hour || !report.Tasks[0].Disabled {
+		t.Fatalf("json definition is not applied: %+v", report)
+	}
+	ls := &Schedule{Id: etl.Id}
+	if err = ls.InitSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	rels := int64(0)
+	for _, task := range ls.Tasks {
+		rels += task.RelTaskCnt
+	}
+	if ls.ScheduleRetryCount != 1 || ls.TaskCnt != 2 || rels != 1 {
+		t.Fatalf("yaml definition is not stored: %+v", ls)
+	}
+
+	//校验失败的文件不修改已有的调度
+	write("etl.yaml", `
+name: etl
+cyc: d
+retry_count: -1
+jobs:
+  - name: extract
+    tasks:
+      - name: a
+        cmd: echo
+`)
+	if _, err = sl.LoadFromDir(dir); !IsInvalid(err) {
+		t.Fatalf("want invalid definition, got %v", err)
+	}
+	write("etl.yaml", `
+name: etl
+cyc: d
+jobs:
+  - name: extract
+    tasks:
+      - name: a
+        cmd: echo
+        rel: [x]
+`)
+	if _, err = sl.LoadFromDir(dir); !IsInvalid(err) {
+		t.Fatalf("want unknown rel task, got %v", err)
+	}
+	ls = &Schedule{Id: etl.Id}
+	if err = ls.InitSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	if ls.ScheduleRetryCount != 1 || ls.TaskCnt != 2 {
+		t.Fatalf("schedule is modified by an invalid definition: %+v", ls)
+	}
+
+	//同一调度在两个文件中定义
+	write("etl.yaml", "name: etl\ncyc: d\n")
+	write("etl2.json", `{"name": "etl", "cyc": "d"}`)
+	if _, err = sl.LoadFromDir(dir); !IsInvalid(err) || !strings.Contains(err.Error(), "is defined in both") {
+		t.Fatalf("want duplicated schedule error, got %v", err)
+	}
+}
+
+func TestCloneSchedule(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+
+	s := &Schedule{Name: "src", Cyc: "d", Enabled: true, Tags: []string{"etl"}, Params: map[string]string{"k": "v"}}
+	if _, err := g.Schedules.AddSchedule(s); err != nil {
+		t.Fatal(err)
+	}
+	j1, j2 := &Job{Name: "j1"}, &Job{Name: "j2"}
+	for _, j := range []*Job{j1, j2} {
+		if _, err := s.AddJob(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := &Task{Name: "a", JobId: j1.Id, Cmd: "echo", RelTasks: make(map[string]*Task)}
+	b := &Task{Name: "b", JobId: j2.Id, Cmd: "echo", RelTasks: make(map[string]*Task)}
+	for _, task := range []*Task{a, b} {
+		if err := s.AddTask(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.AddRelTaskOn(a, RelAlways); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := g.Schedules.CloneSchedule(s.Id, "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := &Schedule{Id: id}
+	if err = cs.InitSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	if id == s.Id || cs.Name != "copy" || cs.Enabled || len(cs.Tags) != 1 || cs.Params["k"] != "v" {
+		t.Fatalf("bad clone %+v", cs)
+	}
+	if len(cs.Jobs) != 2 || cs.Job.Id == j1.Id || cs.Job.NextJob.Id == j2.Id || cs.Job.NextJob.PreJobId != cs.Job.Id {
+		t.Fatalf("bad job chain of clone %+v", cs.Jobs)
+	}
+	var ca, cb *Task
+	for _, task := range cs.Job.Tasks {
+		ca = task
+	}
+	for _, task := range cs.Job.NextJob.Tasks {
+		cb = task
+	}
+	if ca == nil || cb == nil || ca.Name != "a" || cb.Name != "b" || ca.Id == a.Id || cb.Id == b.Id {
+		t.Fatalf("bad tasks of clone %+v", cs.Tasks)
+	}
+	if cb.RelTasks[taskKey(ca.Id)] == nil || cb.relCondition(ca.Id) != RelAlways {
+		t.Fatalf("want b of clone depends on a of clone, got %v", cb.RelTasksId)
+	}
+	if g.Schedules.GetScheduleById(id) == nil || len(s.Jobs) != 2 || len(b.RelTasksId) != 1 || b.RelTasksId[0] != a.Id {
+		t.Fatal("want clone in schedule list and source unchanged")
+	}
+
+	//复制依赖关系失败时整体回滚
+	n := count(t, db, "scd_schedule")
+	if _, err = db.Exec("CREATE TRIGGER fail_rel BEFORE INSERT ON scd_task_rel BEGIN SELECT RAISE(ABORT, 'injected'); END"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Schedules.CloneSchedule(s.Id, "copy2"); err == nil {
+		t.Fatal("want error when adding reltask fails")
+	}
+	if count(t, db, "scd_schedule") != n || count(t, db, "scd_task") != 4 || len(g.Schedules.ScheduleList) != 2 {
+		t.Fatal("clone is not rolled back")
+	}
+
+	if _, err = g.Schedules.CloneSchedule(s.Id+100, "copy3"); !IsNotFound(err) {
+		t.Fatalf("want not found error, got %v", err)
+	}
+}
+
+//panicExecutor执行名称在panic中的任务时发生panic
+type panicExecutor struct {
+	SyncExecutor
+	panic map[string]bool
+}
+
+func (pe *panicExecutor) Run(task *Task, reply *Reply) error {
+	if pe.panic[task.Name] {
+		panic("injected panic of task " + task.Name)
+	}
+	return pe.SyncExecutor.Run(task, reply)
+}
+
+func TestTaskPanic(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.NoLog = true
+	reg := prometheus.NewRegistry()
+	g.Registry = reg
+	g.Executor = &panicExecutor{panic: map[string]bool{"c": true}}
+
+	es := ExecScheduleWarper(newTestSchedule())
+	es.execType = 2
+	if err := es.InitExecSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	es.Run()
+
+	//c的panic被恢复，c失败，依赖它的d暂停，批次正常结束
+	if es.state != 3 || es.successTaskCnt != 2 || es.failTaskCnt != 2 || es.outcome() != "fail" {
+		t.Fatalf("want run done with 2 failed tasks, got state %d success %d fail %d", es.state, es.successTaskCnt, es.failTaskCnt)
+	}
+	if !strings.Contains(es.lastError, "injected panic of task c") {
+		t.Fatalf("want panic in last error, got %q", es.lastError)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	panics, fails := 0.0, 0.0
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			switch mf.GetName() {
+			case "panics_recovered_total":
+				panics += m.GetCounter().GetValue()
+			case "schedule_runs_total":
+				for _, l := range m.GetLabel() {
+					if l.GetName() == "outcome" && l.GetValue() == "fail" {
+						fails += m.GetCounter().GetValue()
+					}
+				}
+			}
+		}
+	}
+	if panics != 1 || fails != 1 {
+		t.Fatalf("want 1 recovered panic and 1 failed run in metrics, got %v %v", panics, fails)
+	}
+}
+
+func TestRunLock(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+
+	if _, err := NewTableRunLock("scd_run_lock; DROP TABLE scd_task"); err == nil {
+		t.Fatal("want error for invalid table name")
+	}
+	a, err := NewTableRunLock("scd_run_lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &TableRunLock{Table: "scd_run_lock", Owner: "other:1"}
+
+	s := &Schedule{Id: 7, Name: "ha"}
+	window := time.Date(2020, 1, 2, 3, 0, 0, 0, time.Local)
+	if key := RunKey(s, window); key != "7@"+window.UTC().Format("20060102T150405Z") {
+		t.Fatalf("bad run key %s", key)
+	}
+
+	//同一启动只有一个进程抢占成功，抢占成功的进程重复抢占仍成功
+	g.RunLock = a
+	if !s.claimRun(window) || !s.claimRun(window) {
+		t.Fatal("first instance should claim the run")
+	}
+	g.RunLock = b
+	if s.claimRun(window) {
+		t.Fatal("second instance should lose the run")
+	}
+	if !s.claimRun(window.Add(time.Hour)) || count(t, db, "scd_run_lock") != 2 {
+		t.Fatal("second instance should claim the next run")
+	}
+
+	//表不存在时抢占失败，不执行
+	g.RunLock = &TableRunLock{Table: "scd_no_lock", Owner: "other:1"}
+	if s.claimRun(window.Add(2 * time.Hour)) {
+		t.Fatal("want no run when claim fails")
+	}
+	g.RunLock = nil
+	if !s.claimRun(window) {
+		t.Fatal("want run without RunLock")
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+
+	if _, err := NewTableLeaderElector("scd leader", "test"); err == nil {
+		t.Fatal("want error for invalid table name")
+	}
+	a := &TableLeaderElector{Table: "scd_leader", Name: "test", Owner: "a:1"}
+	b := &TableLeaderElector{Table: "scd_leader", Name: "test", Owner: "b:1"}
+
+	//租约有效期间只有持有者当选，放弃或过期后其它实例可以当选
+	for i, c := range []struct {
+		e    *TableLeaderElector
+		want bool
+	}{{a, true}, {b, false}, {a, true}} {
+		if ok, err := c.e.Campaign(time.Minute); err != nil || ok != c.want {
+			t.Fatalf("campaign %d want %v, got %v %v", i, c.want, ok, err)
+		}
+	}
+	if err := a.Resign(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := b.Campaign(-time.Second); !ok {
+		t.Fatal("b should be the leader after a resigns")
+	}
+	if ok, _ := a.Campaign(time.Minute); !ok {
+		t.Fatal("a should take over the expired lease")
+	}
+
+	//成为主节点时启动监听，失去主节点身份时停止监听
+	if err := a.Resign(); err != nil {
+		t.Fatal(err)
+	}
+	var lock sync.Mutex
+	changes := make([]bool, 0)
+	g.Elector, g.LeaderLease = a, 150*time.Millisecond
+	g.OnLeaderChange = func(leader bool) {
+		lock.Lock()
+		changes = append(changes, leader)
+		lock.Unlock()
+	}
+	sl := g.Schedules
+	if err := sl.StartListener(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return sl.IsLeader() && sl.listener.running() })
+
+	if _, err := db.Exec("UPDATE scd_leader SET owner='b:1', lease_until=?", time.Now().Add(time.Hour).UnixNano()/int64(time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !sl.IsLeader() && !sl.listener.running() })
+	if _, ok := sl.listener.enter(); ok {
+		t.Fatal("timers should not start on a follower")
+	}
+
+	sl.stopElection()
+	lock.Lock()
+	defer lock.Unlock()
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Fatalf("want leader changes [true false], got %v", changes)
+	}
+}
+
+func TestScheduleGraph(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	db := openTestDB(t)
+	defer db.Close()
+	g.HiveConn = db
+
+	s := &Schedule{Name: "graph", Cyc: "d", Enabled: true}
+	if _, err := g.Schedules.AddSchedule(s); err != nil {
+		t.Fatal(err)
+	}
+	j1, j2 := &Job{Name: "j1"}, &Job{Name: "j2", ParallelGroup: 1}
+	for _, j := range []*Job{j1, j2} {
+		if _, err := s.AddJob(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := &Task{Name: "a", JobId: j1.Id, Cmd: "echo", RelTasks: make(map[string]*Task)}
+	b := &Task{Name: "b", JobId: j2.Id, Cmd: "echo", RelTasks: make(map[string]*Task)}
+	for _, task := range []*Task{a, b} {
+		if err := s.AddTask(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.AddRelTaskOn(a, RelOnFailure); err != nil {
+		t.Fatal(err)
+	}
+
+	//未初始化调度链的调度在副本中从元数据库初始化
+	sl := g.Schedules
+	sl.ScheduleList = []*Schedule{{Id: s.Id, JobId: s.JobId}}
+	sg, err := sl.GetScheduleGraph(s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl.ScheduleList[0].Job != nil {
+		t.Fatal("want the listening schedule not initialized")
+	}
+	if sg.ScheduleName != "graph" || len(sg.Nodes) != 4 || len(sg.Edges) != 4 {
+		t.Fatalf("bad graph %+v", sg)
+	}
+	keys := make([]string, 0)
+	for _, n := range sg.Nodes {
+		keys = append(keys, n.Key)
+	}
+	want := []string{graphKey(GraphJob, j1.Id), graphKey(GraphTask, a.Id), graphKey(GraphJob, j2.Id), graphKey(GraphTask, b.Id)}
+	if fmt.Sprint(keys) != fmt.Sprint(want) || sg.Nodes[2].ParallelGroup != 1 || sg.Nodes[3].Seq != 1 {
+		t.Fatalf("want nodes %v, got %+v", want, sg.Nodes)
+	}
+	dep := sg.Edges[3]
+	if dep.Type != GraphDepends || dep.From != want[1] || dep.To != want[3] || dep.Condition != RelOnFailure {
+		t.Fatalf("bad dependency edge %+v", dep)
+	}
+
+	buf, err := json.Marshal(sg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buf), `"type":"depends"`) {
+		t.Fatalf("bad json %s", buf)
+	}
+
+	if _, err = sl.GetScheduleGraph(s.Id + 100); !IsNotFound(err) {
+		t.Fatalf("want not found, got %v", err)
+	}
+}
+
+func TestCriticalPath(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.MetaStore = NewMemStore()
+	db := openTestDB(t)
+	defer db.Close()
+	g.LogConn = db
+
+	//菱形依赖a -> b,c -> d，b与c的平均时长相同
+	s := addTestSchedule(t)
+	st := time.Date(2015, 1, 1, 1, 0, 0, 0, time.Local)
+	if _, err := db.Exec(`INSERT INTO scd_schedule_log (batch_id, scd_id, start_time, end_time, state, result, batch_type)
+		VALUES ('1.0', ?, ?, ?, '3', 1, '1')`, s.Id, st, st.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	for id, d := range map[int64]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 2 * time.Second, 4: time.Second} {
+		if _, err := db.Exec(`INSERT INTO scd_task_log (batch_task_id, batch_job_id, batch_id, task_id, start_time, end_time, state, batch_type)
+			VALUES (?, '1.0', '1.0', ?, ?, ?, '3', '1')`, fmt.Sprintf("1.0.%d", id), id, st, st.Add(d)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//未初始化调度链的调度在副本中初始化，时长相同时取ID较小的b，多次计算结果一致
+	live := &Schedule{Id: s.Id, JobId: s.JobId}
+	g.Schedules.ScheduleList = []*Schedule{live}
+	for i := 0; i < 20; i++ {
+		path, total, err := g.Schedules.CriticalPath(s.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0)
+		for _, ti := range path {
+			names = append(names, ti.Name)
+		}
+		if strings.Join(names, ",") != "a,b,d" || total != 4*time.Second {
+			t.Fatalf("want path a,b,d of 4s, got %v %s", names, total)
+		}
+	}
+	if live.Job != nil || live.isRefresh != nil {
+		t.Fatal("want the listening schedule not initialized")
+	}
+}
+
+func TestSnooze(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	ms := NewMemStore()
+	g.MetaStore = ms
+	start := time.Date(2015, 1, 1, 0, 30, 0, 0, time.Local)
+	clock := newFakeClock(start)
+	g.Clock = clock
+
+	//按日调度，每天1点启动，调度中没有任务，启动时按EmptySkip策略等待下一周期
+	s := &Schedule{Name: "snooze", Enabled: true, Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{time.Hour}}
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddScheduleStart(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.InitSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	sl := g.Schedules
+	sl.ScheduleList = []*Schedule{s}
+	defer sl.StopListener()
+	saved := func() time.Time {
+		ls := &Schedule{Id: s.Id}
+		if err := ms.GetSchedule(context.Background(), ls); err != nil {
+			t.Fatal(err)
+		}
+		return ls.SnoozeUntil
+	}
+
+	go s.Timer()
+	if d := clock.wait(t); d != 30*time.Minute {
+		t.Fatalf("want countdown 30m, got %s", d)
+	}
+
+	//在下次启动时间1点的基础上推迟，正在等待的Timer按暂缓时间重新等待，再次暂缓时继续推迟
+	if err := sl.Snooze(s.Id, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if d := clock.wait(t); d != 90*time.Minute {
+		t.Fatalf("want countdown 90m after snooze, got %s", d)
+	}
+	if err := sl.Snooze(s.Id, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if d := clock.wait(t); d != 150*time.Minute {
+		t.Fatalf("want countdown 150m after second snooze, got %s", d)
+	}
+	if want := start.Add(150 * time.Minute); !saved().Equal(want) {
+		t.Fatalf("want snooze until %s saved, got %s", want, saved())
+	}
+
+	//原本1点的启动不执行，3点启动后清除暂缓时间，恢复每天1点启动
+	clock.Advance(30 * time.Minute)
+	select {
+	case d := <-clock.waits:
+		t.Fatalf("timer fired during snooze, waits %s", d)
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(2 * time.Hour)
+	if d := clock.wait(t); d != 22*time.Hour {
+		t.Fatalf("want countdown 22h after snooze, got %s", d)
+	}
+	if !saved().IsZero() {
+		t.Fatalf("want snooze cleared, got %s", saved())
+	}
+
+	if err := sl.Snooze(s.Id, 0); !IsInvalid(err) {
+		t.Fatalf("want invalid error for zero duration, got %v", err)
+	}
+	if err := sl.Snooze(s.Id+1, time.Hour); !IsNotFound(err) {
+		t.Fatalf("want not found, got %v", err)
+	}
+}
+
+//Snooze与正在计时的Timer同时读写暂缓时间和下次启动时间，需使用-race运行
+func TestSnoozeWhileTimer(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	ms := NewMemStore()
+	g.MetaStore = ms
+	start := time.Date(2015, 1, 1, 0, 30, 0, 0, time.Local)
+	clock := newFakeClock(start)
+	g.Clock = clock
+
+	s := &Schedule{Name: "snooze", Enabled: true, Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{time.Hour}}
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddScheduleStart(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.InitSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	sl := g.Schedules
+	sl.ScheduleList = []*Schedule{s}
+	defer sl.StopListener()
+
+	go s.Timer()
+	clock.wait(t)
+
+	//每次暂缓都会让Timer重新计时，重新计时的Timer与其余的暂缓同时进行。
+	//等待计时的Timer全部开始等待后才结束，避免测试结束后仍有Timer读取g
+	done := make(chan struct{})
+	quiet := make(chan struct{})
+	go func() {
+		defer close(quiet)
+		for {
+			select {
+			case <-clock.waits:
+			case <-done:
+				for {
+					select {
+					case <-clock.waits:
+					case <-time.After(200 * time.Millisecond):
+						return
+					}
+				}
+			}
+		}
+	}()
+	const n = 5
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sl.Snooze(s.Id, time.Hour); err != nil {
+				t.Error(err)
+			}
+			sl.WriteCatalogMetrics(ioutil.Discard)
+		}()
+	}
+	wg.Wait()
+	close(done)
+	<-quiet
+
+	//暂缓按顺序累加，保存的是最后的结果
+	want := start.Add(30*time.Minute + n*time.Hour)
+	sl.lock.RLock()
+	until := s.SnoozeUntil
+	sl.lock.RUnlock()
+	if !until.Equal(want) {
+		t.Fatalf("want snooze until %s, got %s", want, until)
+	}
+	ls := &Schedule{Id: s.Id}
+	if err := ms.GetSchedule(context.Background(), ls); err != nil {
+		t.Fatal(err)
+	}
+	if !ls.SnoozeUntil.Equal(want) {
+		t.Fatalf("want snooze until %s saved, got %s", want, ls.SnoozeUntil)
+	}
+}
+
+func TestSuccessRate(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	db := openTestDB(t)
+	defer db.Close()
+	g.LogConn = db
+
+	//忽略、跳过的任务视为成功；任务失败、暂停以及批次意外中止（如超时）均为失败
+	now := time.Now()
+	logTestRun(t, db, "1.1", 1, now.Add(-5*time.Hour), "3", "3", "5", "6")
+	logTestRun(t, db, "1.2", 1, now.Add(-4*time.Hour), "3", "3", "4")
+	logTestRun(t, db, "1.3", 1, now.Add(-3*time.Hour), "3", "3", "2")
+	logTestRun(t, db, "1.4", 1, now.Add(-2*time.Hour), "4", "3")
+	logTestRun(t, db, "1.5", 1, now.Add(-time.Hour), "3", "3")
+	//正在执行、window之外以及其它调度的批次不计入
+	logTestRun(t, db, "1.6", 1, now.Add(-time.Minute), "1", "1")
+	logTestRun(t, db, "1.7", 1, now.Add(-48*time.Hour), "3", "3")
+	logTestRun(t, db, "2.1", 2, now.Add(-time.Hour), "4", "4")
+
+	rate, n, err := g.Schedules.SuccessRate(1, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 || rate != 0.4 {
+		t.Fatalf("want rate 0.4 of 5 runs, got %v of %d", rate, n)
+	}
+
+	//window内没有已结束的批次
+	if rate, n, err = g.Schedules.SuccessRate(1, 30*time.Second); err != nil || rate != 0 || n != 0 {
+		t.Fatalf("want no runs, got %v %d %v", rate, n, err)
+	}
+	if _, _, err = g.Schedules.SuccessRate(1, 0); !IsInvalid(err) {
+		t.Fatalf("want invalid error for zero window, got %v", err)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.MetaStore = NewMemStore()
+	clock := newFakeClock(time.Date(2015, 1, 1, 0, 30, 0, 0, time.Local))
+	g.Clock = clock
+	exec := &SyncExecutor{Fail: map[string]string{"a": "no connection"}}
+	g.Executor = exec
+
+	//以调度链中的a作为预热任务，预热只执行a，不执行调度链
+	s := addTestSchedule(t)
+	s.WarmupTaskId = s.Tasks[0].Id
+	if err := g.store().UpdateSchedule(s); err != nil {
+		t.Fatal(err)
+	}
+	sl := g.Schedules
+	sl.ScheduleList = []*Schedule{{Id: s.Id}}
+	defer sl.StopListener()
+
+	//预热失败时不启动监听
+	if err := sl.StartScheduleById(s.Id); !IsConflict(err) {
+		t.Fatalf("want conflict when warmup fails, got %v", err)
+	}
+	select {
+	case d := <-clock.waits:
+		t.Fatalf("timer started after failed warmup, waits %s", d)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	//预热成功后启动监听，等待下一周期开始
+	delete(exec.Fail, "a")
+	if err := sl.StartScheduleById(s.Id); err != nil {
+		t.Fatal(err)
+	}
+	if d := clock.wait(t); d != 23*time.Hour+30*time.Minute {
+		t.Fatalf("want countdown 23h30m, got %s", d)
+	}
+	if strings.Join(exec.Order, ",") != "a,a" {
+		t.Fatalf("want only warmup task a run twice, got %v", exec.Order)
+	}
+}
+
+func TestFindSchedulesUsing(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.MetaStore = NewMemStore()
+
+	//shared的调度链从s的第2个作业开始，共享job2、job3及其中的任务
+	s := addTestSchedule(t)
+	shared := &Schedule{Name: "shared", Cyc: "d", Enabled: true, JobId: s.Jobs[1].Id}
+	if err := shared.Add(); err != nil {
+		t.Fatal(err)
+	}
+	sl := g.Schedules
+	sl.ScheduleList = []*Schedule{s, shared}
+
+	names := func(scds []*Schedule, err error) string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := make([]string, 0)
+		for _, scd := range scds {
+			r = append(r, scd.Name)
+		}
+		return strings.Join(r, ",")
+	}
+	for _, c := range []struct {
+		got, want string
+	}{
+		{names(sl.FindSchedulesUsingJob(s.Jobs[0].Id)), "test"},
+		{names(sl.FindSchedulesUsingJob(s.Jobs[2].Id)), "test,shared"},
+		{names(sl.FindSchedulesUsingJob(100)), ""},
+		{names(sl.FindSchedulesUsingTask(s.Tasks[0].Id)), "test"},
+		{names(sl.FindSchedulesUsingTask(s.Tasks[3].Id)), "test,shared"},
+		{names(sl.FindSchedulesUsingTask(100)), ""},
+	} {
+		if c.got != c.want {
+			t.Fatalf("want schedules [%s], got [%s]", c.want, c.got)
+		}
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	ms := NewMemStore()
+	g.MetaStore = ms
+	clock := newFakeClock(time.Date(2015, 1, 1, 0, 30, 0, 0, time.Local))
+	g.Clock = clock
+
+	s := &Schedule{Name: "pause", Enabled: true, Cyc: "d", StartMonth: []int{0}, StartSecond: []time.Duration{time.Hour}}
+	if err := s.Add(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddScheduleStart(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.InitSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	sl := g.Schedules
+	sl.ScheduleList = []*Schedule{s}
+	defer sl.StopListener()
+	status := func() int8 {
+		ls := &Schedule{Id: s.Id}
+		if err := ms.GetSchedule(context.Background(), ls); err != nil {
+			t.Fatal(err)
+		}
+		return ls.Status
+	}
+	idle := func() {
+		select {
+		case d := <-clock.waits:
+			t.Fatalf("timer is waiting %s", d)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	go s.Timer()
+	clock.wait(t)
+
+	//暂停后停止监听，重复暂停不报错
+	for i := 0; i < 2; i++ {
+		if err := sl.PauseScheduleById(s.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status() != 1 {
+		t.Fatal("want paused status saved")
+	}
+
+	//暂停期间修改调度不会阻塞，也不启动监听
+	updated := make(chan error, 1)
+	go func() {
+		s.Desc = "updated"
+		updated <- s.UpdateSchedule()
+	}()
+	select {
+	case err := <-updated:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("update of a paused schedule is blocked")
+	}
+	clock.Advance(time.Hour)
+	idle()
+
+	//恢复后按周期计算下次启动时间，错过的1点不补充执行
+	if err := sl.ResumeScheduleById(s.Id); err != nil {
+		t.Fatal(err)
+	}
+	if d := clock.wait(t); d != 23*time.Hour+30*time.Minute {
+		t.Fatalf("want countdown 23h30m after resume, got %s", d)
+	}
+	if status() != 0 {
+		t.Fatal("want resumed status saved")
+	}
+
+	//未暂停的调度恢复时不再启动监听
+	if err := sl.ResumeScheduleById(s.Id); err != nil {
+		t.Fatal(err)
+	}
+	idle()
+	if err := sl.PauseScheduleById(s.Id + 1); !IsNotFound(err) {
+		t.Fatalf("want not found, got %v", err)
+	}
+}
+
+//tableColumns返回库中各表的字段名称及索引名称，索引记录在"#index"下
+func tableColumns(t *testing.T, db *sql.DB) map[string][]string {
+	r := make(map[string][]string)
+	rows, err := db.Query("SELECT type, name, tbl_name FROM sqlite_master WHERE type IN ('table', 'index') AND sql IS NOT NULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := make([]string, 0)
+	for rows.Next() {
+		var typ, name, table string
+		if err = rows.Scan(&typ, &name, &table); err != nil {
+			t.Fatal(err)
+		}
+		if typ == "index" {
+			r["#index"] = append(r["#index"], name)
+		} else {
+			tables = append(tables, name)
+		}
+	}
+	rows.Close()
+
+	for _, table := range tables {
+		rows, err = db.Query("SELECT name FROM pragma_table_info(?)", table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cols := make([]string, 0)
+		for rows.Next() {
+			var name string
+			if err = rows.Scan(&name); err != nil {
+				t.Fatal(err)
+			}
+			cols = append(cols, name)
+		}
+		rows.Close()
+		sort.Strings(cols)
+		r[table] = cols
+	}
+	sort.Strings(r["#index"])
+	return r
+}
+
+func TestUpgradeScript(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	db := openUpgradedDB(t)
+	defer db.Close()
+	fresh := openTestDB(t)
+	defer fresh.Close()
+
+	//升级后的表结构与新建的元数据库一致
+	want, got := tableColumns(t, fresh), tableColumns(t, db)
+	for table, cols := range want {
+		if !reflect.DeepEqual(got[table], cols) {
+			t.Fatalf("%s after upgrade want %v, got %v", table, cols, got[table])
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %d tables after upgrade, got %d", len(want), len(got))
+	}
+
+	//升级后的库可以保存、加载调度
+	g.HiveConn, g.LogConn = db, db
+	sl := g.Schedules
+	s := &Schedule{Name: "upgraded", Cyc: "d", Enabled: true, StartMonth: []int{0}, StartSecond: []time.Duration{time.Hour}}
+	if _, err := sl.AddSchedule(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := sl.InitScheduleList(); err != nil {
+		t.Fatal(err)
+	}
+	if ls := sl.GetScheduleById(s.Id); ls == nil || ls.Name != s.Name {
+		t.Fatalf("want schedule %d loaded, got %+v", s.Id, ls)
+	}
+
+	//升级前的日志库中没有timed_out，执行升级脚本后可以读取任务的超时标记
+	logTestRun(t, db, "1.1", 1, time.Now(), "4", "4", "3")
+	if _, err := db.Exec("UPDATE scd_task_log SET timed_out = 1 WHERE task_id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	results, err := getTaskResults("1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || !results[0].TimedOut || results[1].TimedOut {
+		t.Fatalf("want task 1 timed out, got %+v", results)
+	}
+}
+
+func TestRunScheduleNowCopy(t *testing.T) {
+	g = DefaultGlobal()
+	g.L.Out = ioutil.Discard
+	g.NoLog = true
+	g.MetaStore = NewMemStore()
+	clock := newFakeClock(time.Date(2015, 1, 1, 0, 30, 0, 0, time.Local))
+	g.Clock = clock
+	exec := &SyncExecutor{}
+	g.Executor = exec
+
+	s := addTestSchedule(t)
+	live := &Schedule{Id: s.Id}
+	if err := live.InitSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	sl := g.Schedules
+	sl.ScheduleList = []*Schedule{live}
+	defer sl.StopListener()
+	go live.Timer()
+	clock.wait(t)
+
+	//手动执行、预演、补数在副本中加载调度链，正在等待的Timer及其调度链不变
+	jobs, refresh := live.Jobs, live.isRefresh
+	id, err := sl.RunScheduleNow(s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return !sl.running(id) })
+	if _, err = sl.DryRunSchedule(s.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = sl.Backfill(context.Background(), s.Id, clock.Now().Add(-time.Hour), clock.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if live.isRefresh != refresh || len(live.Jobs) != len(jobs) || live.Jobs[0] != jobs[0] {
+		t.Fatal("want the listening schedule unchanged")
+	}
+	exec.lock.Lock()
+	n := len(exec.Order)
+	exec.lock.Unlock()
+	if n != 8 {
+		t.Fatalf("want manual run and backfill run all 4 tasks, got %d", n)
+	}
+
+	//Timer仍在监听，暂缓后按新的时间等待
+	if err = sl.Snooze(s.Id, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if d := clock.wait(t); d != 24*time.Hour+30*time.Minute {
+		t.Fatalf("want countdown 24h30m after snooze, got %s", d)
+	}
+}
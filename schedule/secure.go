@@ -0,0 +1,146 @@
+package schedule
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/rprp/hivego/metrics"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"strings"
+)
+
+//与Worker通信的安全设置。
+//
+//Worker默认只接受TLS连接，发送任务时使用GlobalConfigStruct.WorkerTLS建立连接，
+//其中需包含验证Worker证书的CA，Worker要求客户端证书（mTLS）时还需包含调度的证书，见LoadWorkerTLS。
+//设置WorkerToken后，连接建立后先发送一行认证信息，Worker验证通过后再进行RPC调用：
+//
+//	HIVE-AUTH <token>    调度发送
+//	OK 或 DENIED         Worker返回
+//
+//Worker端需设置相同的token，见worker.Security。
+//明文连接只用于本地测试，需显式设置WorkerInsecure，未设置WorkerTLS时不会退回明文连接。
+const (
+	authPrefix = "HIVE-AUTH " //认证信息的前缀
+	authOK     = "OK"         //认证通过
+	authDenied = "DENIED"     //认证失败
+)
+
+//LoadWorkerTLS根据PEM格式的文件创建连接Worker时使用的TLS配置。
+//caFile为验证Worker证书的CA，为空时使用系统的CA；certFile、keyFile为调度的证书与私钥，
+//Worker要求客户端证书时需要设置，为空时不发送客户端证书。
+func LoadWorkerTLS(caFile, certFile, keyFile string) (*tls.Config, error) { // {{{
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			e := fmt.Sprintf("\n[LoadWorkerTLS] read ca file error %s.", err.Error())
+			return nil, errors.New(e)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			e := fmt.Sprintf("\n[LoadWorkerTLS] no certificate found in ca file [%s].", caFile)
+			return nil, errors.New(e)
+		}
+		conf.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			e := fmt.Sprintf("\n[LoadWorkerTLS] load certificate error %s.", err.Error())
+			return nil, errors.New(e)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	return conf, nil
+} // }}}
+
+//dialWorker按安全设置连接Worker的地址addr，完成TLS握手与认证后返回RPC客户端。
+//握手或认证失败时记录日志并计入worker_conn_rejected_total。
+func dialWorker(addr string) (*rpc.Client, error) { // {{{
+	if g.WorkerTLS == nil && !g.WorkerInsecure {
+		e := fmt.Sprintf("connect worker [%s] error TLS is not configured, set WorkerInsecure to use plaintext", addr)
+		return nil, errors.New(e)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		e := fmt.Sprintf("connect task.Address[%s] error %s", addr, err.Error())
+		return nil, errors.New(e)
+	}
+
+	if g.WorkerTLS != nil {
+		tc := tls.Client(conn, workerTLSConfig(addr))
+		if err = tc.Handshake(); err != nil {
+			conn.Close()
+			g.metrics().WorkerRejected(metrics.RejectTLS)
+			e := fmt.Sprintf("connect worker [%s] TLS handshake error %s", addr, err.Error())
+			g.L.Warningln("[dialWorker]", e)
+			return nil, errors.New(e)
+		}
+		conn = tc
+	}
+
+	if g.WorkerToken != "" {
+		if err = authenticate(conn, g.WorkerToken); err != nil {
+			conn.Close()
+			g.metrics().WorkerRejected(metrics.RejectAuth)
+			e := fmt.Sprintf("connect worker [%s] %s", addr, err.Error())
+			g.L.Warningln("[dialWorker]", e)
+			return nil, errors.New(e)
+		}
+	}
+
+	return rpc.NewClient(conn), nil
+} // }}}
+
+//workerTLSConfig复制WorkerTLS，未指定ServerName时按addr中的主机名验证Worker的证书
+func workerTLSConfig(addr string) *tls.Config { // {{{
+	conf := g.WorkerTLS.Clone()
+	if conf.ServerName == "" {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			conf.ServerName = host
+		}
+	}
+	return conf
+} // }}}
+
+//authenticate发送认证信息并等待Worker的应答，Worker拒绝时返回error。
+//应答逐字节读取，避免读取应答之后属于RPC的数据。
+func authenticate(conn net.Conn, token string) error { // {{{
+	if _, err := fmt.Fprintf(conn, "%s%s\n", authPrefix, token); err != nil {
+		return errors.New(fmt.Sprintf("send auth error %s", err.Error()))
+	}
+
+	reply, err := readLine(conn, 64)
+	if err != nil {
+		return errors.New(fmt.Sprintf("read auth reply error %s", err.Error()))
+	}
+	if reply != authOK {
+		return errors.New(fmt.Sprintf("auth is rejected, reply [%s]", reply))
+	}
+	return nil
+} // }}}
+
+//readLine逐字节读取一行，不含换行符，超过max字节仍未读到换行符时返回error
+func readLine(conn net.Conn, max int) (string, error) { // {{{
+	line := make([]byte, 0, max)
+	b := make([]byte, 1)
+	for len(line) < max {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSpace(string(line)), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New(fmt.Sprintf("line is longer than %d bytes", max))
+} // }}}
@@ -0,0 +1,132 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+//ScheduleManager的运行状态，见Shutdown
+const (
+	stateRunning  int32 = iota //正常运行
+	stateStopping              //正在关闭，拒绝新的操作，等待正在执行的批次结束
+	stateClosed                //已关闭，数据库连接已关闭
+)
+
+//ErrShutdown为Shutdown之后调用ScheduleManager的操作时返回的错误
+var ErrShutdown error = &ScheduleError{Code: CodeShutdown, Message: "schedule manager is shut down"}
+
+//checkOpen在Shutdown开始后返回ErrShutdown，用于拒绝新的操作
+func (sl *ScheduleManager) checkOpen() error { // {{{
+	if atomic.LoadInt32(&sl.state) != stateRunning {
+		return ErrShutdown
+	}
+	return nil
+} // }}}
+
+//closed判断数据库连接是否已由Shutdown关闭
+func (sl *ScheduleManager) closed() bool { // {{{
+	return atomic.LoadInt32(&sl.state) == stateClosed
+} // }}}
+
+//Shutdown停止调度并释放资源，用于嵌入的程序退出或重新启动前的清理：
+//
+//	停止全部调度的监听，之后ScheduleManager拒绝新的操作，返回ErrShutdown；设置了Elector时停止选举并放弃主节点身份；
+//	等待正在执行的批次以及调度级重试结束，等待中的重试随监听的停止而放弃，ctx超时或被取消时中止全部批次，见CancelRun；
+//	关闭全部事件订阅者的通道；
+//	关闭HiveConn与LogConn，之后元数据库与日志库的操作返回ErrShutdown。
+//
+//ctx超时时中止批次后不再等待，仍会关闭连接，返回注明中止批次数量的error。重复调用返回ErrShutdown。
+func (sc *GlobalConfigStruct) Shutdown(ctx context.Context) error { // {{{
+	sl := sc.Schedules
+	if !atomic.CompareAndSwapInt32(&sl.state, stateRunning, stateStopping) {
+		return ErrShutdown
+	}
+	sl.stopElection()
+	sl.listener.stop()
+
+	var err error
+	e := sl.waitRuns(ctx)
+	if e == nil {
+		e = sl.waitRetries(ctx)
+	}
+	if e != nil {
+		n := sl.cancelRuns()
+		err = errors.New(fmt.Sprintf("\n[Shutdown] %d running batches are cancelled, %s.", n, e.Error()))
+		sc.L.Warningln(err.Error())
+	}
+
+	sl.events.close()
+	atomic.StoreInt32(&sl.state, stateClosed)
+
+	if sc.HiveConn != nil {
+		if e := sc.HiveConn.Close(); e != nil && err == nil {
+			err = errors.New(fmt.Sprintf("\n[Shutdown] close metadata database error %s.", e.Error()))
+		}
+	}
+	if sc.LogConn != nil && sc.LogConn != sc.HiveConn {
+		if e := sc.LogConn.Close(); e != nil && err == nil {
+			err = errors.New(fmt.Sprintf("\n[Shutdown] close log database error %s.", e.Error()))
+		}
+	}
+
+	sc.L.Infoln("[Shutdown] schedule manager is shut down")
+	return err
+} // }}}
+
+//waitRuns等待ExecScheduleList中的批次全部结束，ctx超时或被取消时返回ctx的错误
+func (sl *ScheduleManager) waitRuns(ctx context.Context) error { // {{{
+	for {
+		var done chan struct{}
+		sl.lock.RLock()
+		for _, es := range sl.ExecScheduleList {
+			done = es.done
+			break
+		}
+		sl.lock.RUnlock()
+		if done == nil {
+			return nil
+		}
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+} // }}}
+
+//waitRetries等待调度级重试的线程全部结束，ctx超时或被取消时返回ctx的错误
+func (sl *ScheduleManager) waitRetries(ctx context.Context) error { // {{{
+	done := make(chan struct{})
+	go func() {
+		sl.retries.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+} // }}}
+
+//cancelRuns中止ExecScheduleList中的全部批次，返回中止的批次数量
+func (sl *ScheduleManager) cancelRuns() int { // {{{
+	sl.lock.RLock()
+	batchIds := make([]string, 0, len(sl.ExecScheduleList))
+	for batchId := range sl.ExecScheduleList {
+		batchIds = append(batchIds, batchId)
+	}
+	sl.lock.RUnlock()
+
+	n := 0
+	for _, batchId := range batchIds {
+		if sl.CancelRun(batchId) == nil {
+			n++
+		}
+	}
+	return n
+} // }}}
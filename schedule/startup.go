@@ -0,0 +1,66 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+//进程启动时的执行策略。
+//
+//StartListener启动调度监听前按调度的OnStartupPolicy决定是否立即执行一次：
+//StartupAlwaysRun总是立即执行；StartupRunIfMissed在进程停止期间错过了保存的下次启动时间
+//（NextStart已过且最近一个批次在它之前开始）时立即执行，并以错过的启动时间作为批次的逻辑时间，
+//下游任务的日期参数按该时间计算；StartupWait（默认）不立即执行，错过的启动按Misfire策略处理。
+//启动时已立即执行的调度不再按Misfire策略补执行，之后按正常的周期启动。
+//StartupRunIfMissed的执行按错过的启动时间抢占，其它进程已执行时不再执行，见RunLock。
+
+//startupWindow按OnStartupPolicy判断进程启动时是否需要立即执行，
+//需要执行时同时返回批次的逻辑时间，零值表示使用实际的开始时间。
+func (s *Schedule) startupWindow(now time.Time) (bool, time.Time) { // {{{
+	switch s.OnStartupPolicy {
+	case StartupAlwaysRun:
+		return true, time.Time{}
+	case StartupRunIfMissed:
+		saved := s.NextStart
+		if saved.IsZero() || !saved.Before(now) {
+			return false, time.Time{}
+		}
+		if !s.LastRunTime.IsZero() && !s.LastRunTime.Before(saved) {
+			return false, time.Time{}
+		}
+		return true, saved
+	}
+	return false, time.Time{}
+} // }}}
+
+//runOnStartup在启动调度监听前按OnStartupPolicy立即执行一次调度
+func (s *Schedule) runOnStartup() { // {{{
+	run, window := s.startupWindow(GetNow())
+	if !run {
+		return
+	}
+	if !window.IsZero() && !s.claimRun(window) {
+		return
+	}
+
+	//已立即执行，Timer首次计算启动时间时不再按Misfire策略处理错过的启动
+	s.restored = false
+
+	es := ExecScheduleWarper(s)
+	if !window.IsZero() {
+		es.window = window
+		es.log = es.log.WithField(LogFieldWindow, window.Format(windowLayout))
+	}
+	es.queued = true //监听随后启动，批次结束后不再重新启动监听
+	g.Schedules.AddExecSchedule(es)
+	if err := es.InitExecSchedule(); err != nil {
+		g.Schedules.RemoveExecSchedule(es.batchId)
+		es.log.Warningln(fmt.Sprintf("[s.runOnStartup] Init Execschedule [%d %s] error %s.", s.Id, s.Name, err.Error()))
+		return
+	}
+
+	es.log.Infoln(fmt.Sprintf("[s.runOnStartup] schedule [%d %s] is started on startup by policy %s batchId=[%s]",
+		s.Id, s.Name, s.OnStartupPolicy, es.batchId))
+	es.publishEvent(EventScheduleFired, 0, es.state, "startup")
+	go es.Run()
+} // }}}
@@ -0,0 +1,241 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+//任务统计信息结构
+type TaskInfo struct { // {{{
+	Id          int64         //任务ID
+	Name        string        //任务名称
+	JobId       int64         //所属作业ID
+	AvgDuration time.Duration //历史平均执行时长
+} // }}}
+
+//CriticalPath计算指定调度的关键路径。
+//根据日志库中任务执行成功的历史记录得到每个任务的平均执行时长，再按任务的
+//依赖关系找出耗时最长的依赖链，即决定调度整体执行时间的任务路径。
+//返回按执行先后排列的任务列表以及路径的总时长，没有历史记录的任务时长按0计算。
+//方法只读取数据，不会修改调度信息；调度尚未初始化调度链时在副本中初始化，见Schedule.chain。
+func (sl *ScheduleManager) CriticalPath(scheduleId int64) ([]TaskInfo, time.Duration, error) { // {{{
+	s := sl.GetScheduleById(scheduleId)
+	if s == nil {
+		return nil, 0, newError(CodeNotFound, nil, "\n[sl.CriticalPath] not found schedule by id %d", scheduleId)
+	}
+
+	cs, err := s.chain()
+	if err != nil {
+		return nil, 0, newError(CodeStore, err, "\n[sl.CriticalPath] init schedule [%d] error %s.", scheduleId, err.Error())
+	}
+
+	avg, err := getTaskAvgDuration(scheduleId)
+	if err != nil {
+		return nil, 0, newError(CodeStore, err, "\n[sl.CriticalPath] %s", err.Error())
+	}
+
+	path, total, err := longestPath(cs.Tasks, avg)
+	if err != nil {
+		return nil, 0, newError(CodeInvalid, err, "\n[sl.CriticalPath] schedule [%d] %s", scheduleId, err.Error())
+	}
+
+	return path, total, nil
+} // }}}
+
+//longestPath在任务依赖关系构成的有向无环图中，按任务时长找出最长的路径。
+//任务与依赖的任务均按ID顺序访问，时长相同的路径取任务ID较小的一条，结果不受map遍历顺序影响。
+//任务间存在循环依赖时返回error信息。
+func longestPath(tasks []*Task, avg map[int64]time.Duration) ([]TaskInfo, time.Duration, error) { // {{{
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[int64]int)
+	cost := make(map[int64]time.Duration) //以该任务结束的最长路径时长
+	prev := make(map[int64]*Task)         //最长路径上该任务的前一个任务
+
+	var visit func(t *Task) error
+	visit = func(t *Task) error {
+		switch state[t.Id] {
+		case visiting:
+			e := fmt.Sprintf("task [%d %s] has cyclic dependency.", t.Id, t.Name)
+			return errors.New(e)
+		case visited:
+			return nil
+		}
+
+		state[t.Id] = visiting
+		rts := make([]*Task, 0, len(t.RelTasks))
+		for _, rt := range t.RelTasks {
+			if rt != nil {
+				rts = append(rts, rt)
+			}
+		}
+		sort.Sort(taskById(rts))
+
+		var best time.Duration
+		var bestTask *Task
+		for _, rt := range rts {
+			if err := visit(rt); err != nil {
+				return err
+			}
+			if bestTask == nil || cost[rt.Id] > best {
+				best, bestTask = cost[rt.Id], rt
+			}
+		}
+		state[t.Id] = visited
+
+		cost[t.Id] = best + avg[t.Id]
+		prev[t.Id] = bestTask
+		return nil
+	}
+
+	sorted := make([]*Task, len(tasks))
+	copy(sorted, tasks)
+	sort.Sort(taskById(sorted))
+
+	var last *Task
+	for _, t := range sorted {
+		if err := visit(t); err != nil {
+			return nil, 0, err
+		}
+		if last == nil || cost[t.Id] > cost[last.Id] {
+			last = t
+		}
+	}
+
+	if last == nil {
+		return []TaskInfo{}, 0, nil
+	}
+
+	//从路径末端回溯，得到按执行先后排列的任务列表
+	path := make([]TaskInfo, 0)
+	for t := last; t != nil; t = prev[t.Id] {
+		ti := TaskInfo{Id: t.Id, Name: t.Name, JobId: t.JobId, AvgDuration: avg[t.Id]}
+		path = append([]TaskInfo{ti}, path...)
+	}
+
+	return path, cost[last.Id], nil
+} // }}}
+
+//任务单次执行的记录
+type TaskAttempt struct { // {{{
+	BatchId   string    //批次ID
+	TaskId    int64     //任务ID
+	Attempt   int       //执行次数，从1开始
+	Worker    string    //执行任务的worker地址
+	StartTime time.Time //开始时间
+	EndTime   time.Time //结束时间
+	State     int8      //执行结果 3. 完成 4.意外中止
+	Output    string    //任务输出
+} // }}}
+
+//GetTaskAttempts返回指定批次中某个任务的全部执行记录，按执行次数排序。
+//需要GlobalConfigStruct.LogAttempts开启时才会有记录。
+func (sl *ScheduleManager) GetTaskAttempts(batchId string, taskId int64) ([]TaskAttempt, error) { // {{{
+	attempts, err := getTaskAttempts(batchId, taskId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.GetTaskAttempts] %s", err.Error())
+	}
+	return attempts, nil
+} // }}}
+
+//GetTaskResults从日志库返回批次batchId中全部任务的执行结果，按开始时间排序，
+//用于排查较长的调度链中是哪个任务失败。正在执行的批次返回已记录的部分，未开始的任务状态为0。
+//任务名称取自内存中的调度，任务已删除时为空；执行次数需要LogAttempts开启时才有记录。
+//不记录日志（NoLog）或批次不存在时返回error。
+func (sl *ScheduleManager) GetTaskResults(batchId string) ([]TaskResult, error) { // {{{
+	if g.NoLog || g.LogConn == nil {
+		return nil, newError(CodeNotFound, nil, "\n[sl.GetTaskResults] task results of batch [%s] are not logged.", batchId)
+	}
+
+	results, err := getTaskResults(batchId)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.GetTaskResults] %s", err.Error())
+	}
+	if len(results) == 0 {
+		return nil, newError(CodeNotFound, nil, "\n[sl.GetTaskResults] not found batch [%s]", batchId)
+	}
+
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+	for i := range results {
+		for _, s := range sl.ScheduleList {
+			if t := s.GetTaskById(results[i].TaskId); t != nil {
+				results[i].Name = t.Name
+				break
+			}
+		}
+	}
+	return results, nil
+} // }}}
+
+//QueryRunHistory每页默认返回的批次数量
+const RunHistoryLimit = 100
+
+//调度批次的执行记录，由QueryRunHistory从日志库读取
+type RunRecord struct { // {{{
+	BatchId    string       //批次ID
+	ScheduleId int64        //调度ID
+	ExecType   int8         //执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行 4.补数执行
+	StartTime  time.Time    //开始时间
+	EndTime    time.Time    //结束时间
+	State      int8         //批次状态 0.不满足条件未执行 1. 执行中 2. 暂停 3. 完成 4.意外中止
+	Outcome    RunStatus    //执行结果，按批次状态与失败的任务计算
+	Tasks      []TaskRecord //批次中各任务的执行情况，按任务ID排序
+} // }}}
+
+//批次中任务的执行情况，任务重试时为最后一次执行的信息
+type TaskRecord struct { // {{{
+	TaskId    int64     //任务ID
+	StartTime time.Time //开始时间
+	EndTime   time.Time //结束时间
+	State     int8      //任务状态 0.初始状态 1. 执行中 2. 暂停 3. 完成 4.意外中止 5.忽略 6.跳过
+} // }}}
+
+//QueryRunHistory从日志库查询指定调度在[from, to)内启动的批次，按开始时间倒序返回。
+//limit、offset用于分页，limit小于等于0时按RunHistoryLimit返回。
+//调度已删除时仍可查询其历史记录。
+func (sl *ScheduleManager) QueryRunHistory(scheduleId int64, from, to time.Time, limit, offset int) ([]RunRecord, error) { // {{{
+	if !from.Before(to) {
+		return nil, newError(CodeInvalid, nil, "\n[sl.QueryRunHistory] from %s must be before to %s.", from, to)
+	}
+	if offset < 0 {
+		return nil, newError(CodeInvalid, nil, "\n[sl.QueryRunHistory] offset %d must not be negative.", offset)
+	}
+	if limit <= 0 {
+		limit = RunHistoryLimit
+	}
+
+	records, err := getRunHistory(scheduleId, from, to, limit, offset)
+	if err != nil {
+		return nil, newError(CodeStore, err, "\n[sl.QueryRunHistory] %s", err.Error())
+	}
+	return records, nil
+} // }}}
+
+//SuccessRate统计指定调度在最近window时间内的执行成功率，返回成功率和参与统计的批次数。
+//统计范围为启动时间在window内且已经结束的批次，正在执行的批次不计入。
+//批次状态为完成且其中的任务全部成功（被忽略的任务视为成功）时计为成功；
+//任务执行失败（包括超时）或因上级任务失败而暂停，以及批次意外中止均计为失败。
+//window内没有已结束的批次时返回的成功率和批次数均为0，调用方需根据批次数判断。
+func (sl *ScheduleManager) SuccessRate(scheduleId int64, window time.Duration) (float64, int, error) { // {{{
+	if window <= 0 {
+		return 0, 0, newError(CodeInvalid, nil, "\n[sl.SuccessRate] window %s must be positive.", window)
+	}
+
+	total, success, err := getRunStates(scheduleId, time.Now().Add(-window))
+	if err != nil {
+		return 0, 0, newError(CodeStore, err, "\n[sl.SuccessRate] %s", err.Error())
+	}
+
+	if total == 0 {
+		return 0, 0, nil
+	}
+
+	return float64(success) / float64(total), total, nil
+} // }}}
@@ -0,0 +1,328 @@
+package schedule
+
+import (
+	"context"
+	"time"
+)
+
+//MetaStore为调度元数据的存储接口，调度、作业、任务的读取与持久化均通过它完成。
+//GlobalConfigStruct.MetaStore为nil时使用HiveConn中的元数据库，见sqlStore；
+//不需要数据库时可以使用NewMemStore返回的内存存储，例如测试或临时执行。
+//
+//读取方法只设置结构中需要持久化的字段，运行时的状态由调用方初始化。
+//启动月份按第几月存储，读取时减去1，与Schedule.StartMonth的含义一致，见GetScheduleStart。
+type MetaStore interface { // {{{
+	GetAllSchedules(ctx context.Context) ([]*Schedule, error) //获取全部调度，包含启动列表、暂缓时间、剩余次数、下次启动时间、依赖的调度、标签与运行参数
+	GetSchedule(ctx context.Context, s *Schedule) error       //按s.Id获取调度，内容同GetAllSchedules，不存在时返回error
+	GetScheduleStart(s *Schedule) error                       //按s.Id获取调度的启动列表与启动星期，没有启动时间时为每周期开始时启动
+	AddSchedule(s *Schedule) error                            //增加调度的基本信息，并设置新的s.Id
+	UpdateSchedule(s *Schedule) error                         //更新调度的基本信息
+	DeleteSchedule(s *Schedule) error                         //删除调度及其启动列表、暂缓时间、依赖与触发关系、标签与运行参数
+	SaveScheduleStart(s *Schedule) error                      //按StartSecond、StartMonth、StartWeekday整体替换启动列表
+	SaveScheduleTags(s *Schedule) error                       //按Tags整体替换标签
+	SaveScheduleParams(s *Schedule) error                     //按Params整体替换运行参数
+	SaveScheduleStatus(s *Schedule) error                     //保存调度状态
+	SaveScheduleSnooze(s *Schedule) error                     //保存暂缓执行时间，SnoozeUntil为零值时删除
+	SaveScheduleRemain(s *Schedule) error                     //保存剩余的调度次数
+	SaveScheduleNextStart(s *Schedule) error                  //保存下次启动时间
+	AddRelSchedule(s *Schedule, relId int64) error            //增加调度对上游调度relId的依赖
+	DeleteRelSchedule(s *Schedule, relId int64) error         //删除调度对上游调度relId的依赖
+	SaveScheduleTriggers(s *Schedule) error                   //按Triggers整体替换调度触发的下游调度
+
+	GetJob(ctx context.Context, j *Job) error //按j.Id获取作业，不存在时返回error
+	GetJobTaskIds(j *Job) ([]int64, error)    //获取作业下的任务Id
+	GetJobNext() (map[int64]int64, error)     //获取全部作业的下级作业Id，以作业Id为key
+	AddJob(j *Job) error                      //增加作业，并设置新的j.Id
+	UpdateJob(j *Job) error                   //更新作业
+	MoveJobTasks(j *Job, jobId int64) error   //将作业下的任务迁移至作业jobId
+	DeleteJob(j *Job) error                   //删除作业
+
+	GetTask(t *Task) error                              //按t.Id获取任务，包含Param、Attr、Params、RelTasksId与RelConditions，不存在时返回error
+	GetTaskJobIds(taskId int64) ([]int64, error)        //获取包含任务的作业Id
+	AddTask(t *Task) error                              //增加任务及其参数、运行参数、与t.JobId作业的关系、对RelTasks的依赖，并设置新的t.Id
+	UpdateTask(t *Task) error                           //更新任务，参数与运行参数整体替换
+	SaveTaskDisabled(t *Task) error                     //保存任务是否禁用
+	DeleteTask(t *Task) error                           //删除任务及其参数、运行参数、依赖关系、其它任务对它的依赖以及与作业的关系
+	AddRelTask(t *Task, relId int64, cond string) error //增加任务对relId的依赖及执行条件
+	DeleteRelTask(t *Task, relId int64) error           //删除任务对relId的依赖
+
+	GetScheduleSources() (map[string]*ScheduleSource, error)   //获取全部调度定义文件的同步记录，以调度名称为key
+	SaveScheduleSource(name string, src *ScheduleSource) error //保存调度定义文件的同步记录，已有记录时替换
+	DeleteScheduleSource(name string) error                    //删除调度定义文件的同步记录
+
+	//InTx在一个事务中执行fn，fn中的读写需使用传入的MetaStore，fn返回error时回滚，否则提交。
+	InTx(fn func(ms MetaStore) error) error
+} // }}}
+
+//调度定义文件的同步记录，见LoadFromDir
+type ScheduleSource struct { // {{{
+	ScheduleId int64  //调度ID
+	File       string //定义文件
+	Hash       string //文件内容摘要
+} // }}}
+
+//store返回调度元数据的存储，未设置MetaStore时使用HiveConn中的元数据库
+func (sc *GlobalConfigStruct) store() MetaStore { // {{{
+	if sc.MetaStore != nil {
+		return sc.MetaStore
+	}
+	return &sqlStore{}
+} // }}}
+
+//从元数据存储获取Schedule列表，读取完成后一次替换调度列表。
+func (sl *ScheduleManager) getAllSchedules(ctx context.Context) error { // {{{
+	scds, err := g.store().GetAllSchedules(ctx)
+	if err != nil {
+		return newError(CodeStore, err, "\n[sl.getAllSchedules] %s", err.Error())
+	}
+
+	for _, scd := range scds {
+		scd.Jobs, scd.Tasks = make([]*Job, 0), make([]*Task, 0)
+		scd.restored, scd.savedEnabled = true, scd.Enabled
+	}
+
+	sl.lock.Lock()
+	sl.ScheduleList = scds
+	sl.lock.Unlock()
+
+	return nil
+} // }}}
+
+//loadSchedule从元数据存储获取Schedule的信息，并清除原有的调度链
+func (s *Schedule) loadSchedule(ctx context.Context) error { // {{{
+	err := g.store().GetSchedule(ctx, s)
+	s.savedEnabled = s.Enabled
+	s.Jobs = make([]*Job, 0)
+	s.Tasks = make([]*Task, 0)
+	s.isRefresh = make(chan bool)
+	s.JobCnt, s.TaskCnt = 0, 0
+	return err
+} // }}}
+
+//sqlStore为基于元数据库的MetaStore，是GlobalConfigStruct.MetaStore的默认实现。
+//InTx中的写入使用同一事务，其余写入直接使用GlobalConfigStruct.HiveConn。
+type sqlStore struct { // {{{
+	tx execer //InTx中的事务，为nil时不在事务中
+} // }}}
+
+//conn返回写入使用的连接，在事务中时为事务
+func (ss *sqlStore) conn() execer { // {{{
+	if ss.tx != nil {
+		return ss.tx
+	}
+	return g.HiveConn
+} // }}}
+
+func (ss *sqlStore) GetAllSchedules(ctx context.Context) ([]*Schedule, error) { // {{{
+	return getSchedules(ctx)
+} // }}}
+
+func (ss *sqlStore) GetSchedule(ctx context.Context, s *Schedule) error { // {{{
+	return s.getSchedule(ctx)
+} // }}}
+
+func (ss *sqlStore) GetScheduleStart(s *Schedule) error { // {{{
+	return s.setStart()
+} // }}}
+
+func (ss *sqlStore) AddSchedule(s *Schedule) error { // {{{
+	return s.add(ss.conn())
+} // }}}
+
+func (ss *sqlStore) UpdateSchedule(s *Schedule) error { // {{{
+	return s.update(ss.conn())
+} // }}}
+
+//DeleteSchedule依次删除启动列表、启动星期、暂缓时间、依赖与触发关系、标签、运行参数与调度
+func (ss *sqlStore) DeleteSchedule(s *Schedule) error { // {{{
+	tx := ss.conn()
+	dels := []func() error{
+		func() error { return s.delStart(tx) },
+		func() error { return s.delWeekdays(tx) },
+		s.delSnooze, s.deleteAllRelSchedule, s.deleteAllTriggers,
+		func() error { return s.delTags(tx) },
+		func() error { return s.delEnv(tx) },
+		s.deleteSchedule,
+	}
+	for _, del := range dels {
+		if err := del(); err != nil {
+			return err
+		}
+	}
+	return nil
+} // }}}
+
+//SaveScheduleStart删除原有的启动列表后逐个添加，内存中的启动时间单位为纳秒，存储时转成秒
+func (ss *sqlStore) SaveScheduleStart(s *Schedule) error { // {{{
+	if err := s.delStart(ss.conn()); err != nil {
+		return err
+	}
+	for i, st := range s.StartSecond {
+		if err := s.addStart(ss.conn(), time.Duration(st)/time.Second, s.StartMonth[i]); err != nil {
+			return err
+		}
+	}
+	return s.saveWeekdays(ss.conn())
+} // }}}
+
+func (ss *sqlStore) SaveScheduleTags(s *Schedule) error { // {{{
+	return s.saveTags(ss.conn())
+} // }}}
+
+func (ss *sqlStore) SaveScheduleParams(s *Schedule) error { // {{{
+	return s.saveEnv(ss.conn())
+} // }}}
+
+func (ss *sqlStore) SaveScheduleStatus(s *Schedule) error { // {{{
+	return s.saveStatus()
+} // }}}
+
+func (ss *sqlStore) SaveScheduleSnooze(s *Schedule) error { // {{{
+	if s.SnoozeUntil.IsZero() {
+		return s.delSnooze()
+	}
+	return s.saveSnooze()
+} // }}}
+
+func (ss *sqlStore) SaveScheduleRemain(s *Schedule) error { // {{{
+	return s.saveRemain()
+} // }}}
+
+func (ss *sqlStore) SaveScheduleNextStart(s *Schedule) error { // {{{
+	return s.saveNextStart()
+} // }}}
+
+func (ss *sqlStore) AddRelSchedule(s *Schedule, relId int64) error { // {{{
+	return s.addRelSchedule(relId)
+} // }}}
+
+func (ss *sqlStore) DeleteRelSchedule(s *Schedule, relId int64) error { // {{{
+	return s.deleteRelSchedule(relId)
+} // }}}
+
+func (ss *sqlStore) SaveScheduleTriggers(s *Schedule) error { // {{{
+	return s.saveTriggers()
+} // }}}
+
+func (ss *sqlStore) GetJob(ctx context.Context, j *Job) error { // {{{
+	return j.getJob(ctx)
+} // }}}
+
+func (ss *sqlStore) GetJobTaskIds(j *Job) ([]int64, error) { // {{{
+	return j.getTasksId()
+} // }}}
+
+func (ss *sqlStore) GetJobNext() (map[int64]int64, error) { // {{{
+	return getJobNext()
+} // }}}
+
+func (ss *sqlStore) AddJob(j *Job) error { // {{{
+	return j.add(ss.conn())
+} // }}}
+
+func (ss *sqlStore) UpdateJob(j *Job) error { // {{{
+	return j.update(ss.conn())
+} // }}}
+
+func (ss *sqlStore) MoveJobTasks(j *Job, jobId int64) error { // {{{
+	return j.moveTasks(ss.conn(), jobId)
+} // }}}
+
+func (ss *sqlStore) DeleteJob(j *Job) error { // {{{
+	return j.deleteJob(ss.conn())
+} // }}}
+
+//GetTask依次获取任务的基本信息、属性、参数、运行参数与依赖的任务
+func (ss *sqlStore) GetTask(t *Task) error { // {{{
+	for _, get := range []func() error{t.getTask, t.getTaskAttr, t.getTaskParam, t.getTaskEnv} {
+		if err := get(); err != nil {
+			return err
+		}
+	}
+	t.RelTasksId = make([]int64, 0)
+	t.RelConditions = make(map[int64]string)
+	return t.getRelTaskId()
+} // }}}
+
+func (ss *sqlStore) SaveTaskDisabled(t *Task) error { // {{{
+	return t.saveDisabled()
+} // }}}
+
+func (ss *sqlStore) GetTaskJobIds(taskId int64) ([]int64, error) { // {{{
+	return getTaskJobsId(taskId)
+} // }}}
+
+//AddTask增加任务后，依次保存与作业的关系、依赖的任务、参数与运行参数
+func (ss *sqlStore) AddTask(t *Task) error { // {{{
+	if err := t.add(ss.conn()); err != nil {
+		return err
+	}
+	if err := t.addRelJob(ss.conn()); err != nil {
+		return err
+	}
+	for _, rt := range t.RelTasks {
+		if err := t.addRelTask(ss.conn(), rt.Id, t.relCondition(rt.Id)); err != nil {
+			return err
+		}
+	}
+	for _, p := range t.Param {
+		if err := t.addParam(ss.conn(), p); err != nil {
+			return err
+		}
+	}
+	return t.saveEnv(ss.conn())
+} // }}}
+
+//UpdateTask更新任务后，删除原有的参数重新添加，并替换运行参数
+func (ss *sqlStore) UpdateTask(t *Task) error { // {{{
+	if err := t.update(); err != nil {
+		return err
+	}
+	if err := t.delParam(ss.conn()); err != nil {
+		return err
+	}
+	for _, p := range t.Param {
+		if err := t.addParam(ss.conn(), p); err != nil {
+			return err
+		}
+	}
+	return t.saveEnv(ss.conn())
+} // }}}
+
+//DeleteTask不在事务中时开启事务删除，失败时元数据库保持不变
+func (ss *sqlStore) DeleteTask(t *Task) error { // {{{
+	if ss.tx != nil {
+		return t.delete(ss.tx)
+	}
+	return inTx(t.delete)
+} // }}}
+
+func (ss *sqlStore) AddRelTask(t *Task, relId int64, cond string) error { // {{{
+	return t.addRelTask(ss.conn(), relId, cond)
+} // }}}
+
+func (ss *sqlStore) DeleteRelTask(t *Task, relId int64) error { // {{{
+	return t.deleteRelTask(ss.conn(), relId)
+} // }}}
+
+func (ss *sqlStore) GetScheduleSources() (map[string]*ScheduleSource, error) { // {{{
+	return getScheduleSources()
+} // }}}
+
+func (ss *sqlStore) SaveScheduleSource(name string, src *ScheduleSource) error { // {{{
+	return saveScheduleSource(name, src.ScheduleId, src.File, src.Hash)
+} // }}}
+
+func (ss *sqlStore) DeleteScheduleSource(name string) error { // {{{
+	return deleteScheduleSource(name)
+} // }}}
+
+//InTx已在事务中时直接执行fn，否则开启元数据库事务，见inTx
+func (ss *sqlStore) InTx(fn func(ms MetaStore) error) error { // {{{
+	if ss.tx != nil {
+		return fn(ss)
+	}
+	return inTx(func(tx execer) error {
+		return fn(&sqlStore{tx: tx})
+	})
+} // }}}
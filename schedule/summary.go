@@ -0,0 +1,85 @@
+package schedule
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/rprp/hivego/metrics"
+	"runtime/debug"
+	"time"
+)
+
+//批次执行摘要。
+//
+//每个批次结束时ExecSchedule.Run输出一条固定格式的Info日志，消息为RunSummaryMessage，
+//字段为下列常量，供日志采集系统解析；字段集合与取值保持稳定，新增信息使用新的字段。
+//无论是否配置Webhooks都会输出，批次执行中发生panic时恢复后同样输出，结果为error。
+//日志格式为LogFormatJSON时每个摘要为一行JSON，见SetLogFormat。
+
+//执行摘要的日志消息
+const RunSummaryMessage = "run summary"
+
+//执行摘要的字段，另有LogFieldRun、LogFieldSchedule
+const (
+	LogFieldTaskCount = "task_count"  //批次中的任务数量
+	LogFieldSucceeded = "succeeded"   //执行成功或可以忽略的任务数量
+	LogFieldFailed    = "failed"      //执行失败或暂停的任务数量
+	LogFieldSkipped   = "skipped"     //被跳过的任务数量
+	LogFieldDuration  = "duration_ms" //批次的执行时长，单位毫秒
+	LogFieldOutcome   = "outcome"     //批次的执行结果，取值同metrics.OutcomeSuccess、OutcomeFail、OutcomeError
+)
+
+//outcome返回批次的执行结果：正常结束时按是否有失败的任务区分，其它情况视为异常中止
+func (es *ExecSchedule) outcome() string { // {{{
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	if es.state != 3 {
+		return metrics.OutcomeError
+	}
+	if es.failTaskCnt > 0 {
+		return metrics.OutcomeFail
+	}
+	return metrics.OutcomeSuccess
+} // }}}
+
+//summarize在批次结束时输出执行摘要
+func (es *ExecSchedule) summarize() { // {{{
+	es.lock.Lock()
+	f := logrus.Fields{
+		LogFieldRun:       es.batchId,
+		LogFieldSchedule:  es.schedule.Id,
+		LogFieldTaskCount: es.taskCnt + es.successTaskCnt + es.failTaskCnt + es.skipTaskCnt,
+		LogFieldSucceeded: es.successTaskCnt,
+		LogFieldFailed:    es.failTaskCnt,
+		LogFieldSkipped:   es.skipTaskCnt,
+		LogFieldDuration:  int64(0),
+	}
+	if !es.startTime.IsZero() {
+		end := es.endTime
+		if end.IsZero() {
+			end = time.Now().Local()
+		}
+		f[LogFieldDuration] = int64(end.Sub(es.startTime) / time.Millisecond)
+	}
+	es.lock.Unlock()
+
+	f[LogFieldOutcome] = es.outcome()
+	es.log.WithFields(f).Infoln(RunSummaryMessage)
+} // }}}
+
+//recoverRun恢复批次执行中的panic，记录失败原因后由cleanup中止批次，
+//之后的执行摘要、Webhooks等仍按意外中止处理。
+func (es *ExecSchedule) recoverRun() { // {{{
+	err := recover()
+	if err == nil {
+		return
+	}
+	var buf bytes.Buffer
+	buf.Write(debug.Stack())
+	g.metrics().Panic(metrics.PanicRun)
+	msg := fmt.Sprintf("schedule run panic: %v", err)
+	es.setError(msg)
+	es.setState(4)
+	es.log.Warningln("[es.recoverRun] schedule", es.schedule.Name, "batchId=[", es.batchId, "] run error", err, " stack=", buf.String())
+	es.publishEvent(EventRunFail, 0, 4, msg)
+} // }}}
@@ -1,68 +1,71 @@
 package schedule
 
 import (
-	"errors"
 	"fmt"
+	"strconv"
 	"time"
 )
 
 // 任务信息结构
 type Task struct { // {{{
-	Id           int64             // 任务的ID
-	Address      string            // 任务的执行地址
-	Name         string            // 任务名称
-	TaskType     int64             // 任务类型
-	ScheduleCyc  string            //调度周期
-	TaskCyc      string            //调度周期
-	StartSecond  time.Duration     //周期内启动时间
-	Cmd          string            // 任务执行的命令或脚本、函数名等。
-	Desc         string            //任务说明
-	TimeOut      int64             // 设定超时时间，0表示不做超时限制。单位秒
-	Param        []string          // 任务的参数信息
-	Attr         map[string]string // 任务的属性信息
-	JobId        int64             //所属作业ID
-	RelTasksId   []int64           //依赖的任务Id
-	RelTasks     map[string]*Task  //`json:"-"` //依赖的任务
-	RelTaskCnt   int64             //依赖的任务数量
-	CreateUserId int64             //创建人
-	CreateTime   time.Time         //创人
-	ModifyUserId int64             //修改人
-	ModifyTime   time.Time         //修改时间
+	Id            int64             // 任务的ID
+	Address       string            // 任务的执行地址，为空时从Worker池中选择
+	Name          string            // 任务名称
+	TaskType      int64             // 任务类型
+	ScheduleCyc   string            //调度周期
+	TaskCyc       string            //调度周期
+	StartSecond   time.Duration     //周期内启动时间
+	Cmd           string            // 任务执行的命令或脚本、函数名等。
+	Desc          string            //任务说明
+	TimeOut       int64             // 设定超时时间，0表示不做超时限制。单位秒
+	RetryCount    int               //失败后的重试次数，0表示不重试，重试会超过调度的TimeOut时不再重试
+	RetryInterval int64             //重试前的等待时间，单位秒，实际等待时间按GlobalConfigStruct.RetryJitter浮动
+	Wave          int               //执行阶段，调度中阶段较小的任务全部结束后，才开始执行阶段较大的任务
+	ResourcePool  string            //使用的资源池，名称见GlobalConfigStruct.ResourcePools，为空时不限制
+	ExecutorType  string            //任务的执行方式，为空时发送给Worker执行，否则为GlobalConfigStruct.TaskExecutors中在进程内执行的TaskExecutor名称
+	Disabled      bool              //禁用的任务不发送执行，记录为跳过，依赖它的任务按GlobalConfigStruct.DisabledTaskPolicy处理，见SetTaskDisabled
+	Param         []string          // 任务的参数信息
+	Attr          map[string]string // 任务的属性信息
+	Params        map[string]string //任务的运行参数，与调度的Params合并后发送给Worker，见ParamData
+	JobId         int64             //所属作业ID
+	RelTasksId    []int64           //依赖的任务Id
+	RelTasks      map[string]*Task  //`json:"-"` //依赖的任务
+	RelConditions map[int64]string  //依赖任务的执行条件，键为依赖的任务Id，取值见RelOnSuccess、RelOnFailure、RelAlways，未设置时为RelOnSuccess
+	RelTaskCnt    int64             //依赖的任务数量
+	CreateUserId  int64             //创建人
+	CreateTime    time.Time         //创人
+	ModifyUserId  int64             //修改人
+	ModifyTime    time.Time         //修改时间
+	BatchTaskId   string            //发送执行时设置为任务的批次ID，Worker据此中止正在执行的任务
 } // }}}
 
 //根据Task.Id从元数据库获取信息初始化Task结构，包含以下动作
+//依赖任务结束后，任务的执行条件
+//依赖任务成功指完成或忽略，失败指意外中止或因上级失败而暂停。
+//条件不满足时任务被跳过（状态6），依赖它的任务视同依赖的任务被跳过。
+const (
+	RelOnSuccess = "success" //依赖的任务成功时执行，依赖的任务失败时暂停，被跳过时跳过
+	RelOnFailure = "failure" //依赖的任务失败时执行，否则跳过
+	RelAlways    = "always"  //无论依赖的任务结果如何都执行
+)
+
 //初始化Task基本信息
 //      Task属性信息
 //      Task的参数信息
+//      Task的运行参数
 //      依赖的Task列表
 //失败返回错误信息。
 func (t *Task) InitTask(s *Schedule) error { // {{{
-	err := t.getTask()
-	if err != nil {
-		e := fmt.Sprintf("\n[t.InitTask] %s.", err.Error())
-		return errors.New(e)
-	}
-
-	err = t.getTaskAttr()
-	if err != nil {
-		e := fmt.Sprintf("\n[t.InitTask] %s.", err.Error())
-		return errors.New(e)
-	}
-
-	err = t.getTaskParam()
+	err := g.store().GetTask(t)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.InitTask] %s.", err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.InitTask] %s.", err.Error())
 	}
 
-	t.RelTasksId = make([]int64, 0)
 	t.RelTasks = make(map[string]*Task)
 	t.RelTaskCnt = 0
-
-	err = t.getRelTaskId()
 	for _, rtid := range t.RelTasksId {
 		rt := s.GetTaskById(rtid)
-		t.RelTasks[string(rtid)] = rt
+		t.RelTasks[taskKey(rtid)] = rt
 		if rt == nil {
 			e := fmt.Sprintf("[t.InitTask] Task [%d] not found RelTask [%d] .\n", t.Id, rtid)
 			g.L.Warningln(e)
@@ -76,62 +79,40 @@ func (t *Task) InitTask(s *Schedule) error { // {{{
 	return nil
 } // }}}
 
-//更新Task信息到元数据库。
+//更新Task信息到元数据存储。
 //更新基本信息后，更新参数信息
 func (t *Task) UpdateTask() error { // {{{
-	err := t.update()
-	if err != nil {
-		e := fmt.Sprintf("\n[t.UpdateTask] %s.", err.Error())
-		return errors.New(e)
-	}
-
-	err = t.delParam()
+	err := g.store().UpdateTask(t)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.UpdateTask] %s.", err.Error())
-		return errors.New(e)
-	}
-
-	for _, p := range t.Param {
-		err = t.addParam(p)
-		if err != nil {
-			e := fmt.Sprintf("\n[t.UpdateTask] %s.", err.Error())
-			return errors.New(e)
-		}
+		return newError(CodeStore, err, "\n[t.UpdateTask] %s.", err.Error())
 	}
 
 	return err
 } // }}}
 
 //AddTask方法持久化当前的Task信息。
-//调用add方法将Task基本信息持久化。
-//完成后处理作业关联信息、Task依赖关系、参数列表。
+//Task基本信息持久化后，处理作业关联信息、Task依赖关系、参数列表。
 func (t *Task) AddTask() (err error) { // {{{
-	err = t.add()
-	if err != nil {
-		e := fmt.Sprintf("\n[t.AddTask] %s.", err.Error())
-		return errors.New(e)
+	//保留调用方设置的依赖任务和参数，供持久化
+	if t.RelTasksId == nil {
+		t.RelTasksId = make([]int64, 0)
 	}
-
-	err = t.addRelJob()
-	if err != nil {
-		e := fmt.Sprintf("\n[t.AddTask] %s.", err.Error())
-		return errors.New(e)
+	if t.RelTasks == nil {
+		t.RelTasks = make(map[string]*Task)
 	}
-
-	for _, rt := range t.RelTasks {
-		err = t.addRelTask(rt.Id)
-		if err != nil {
-			e := fmt.Sprintf("\n[t.AddTask] %s.", err.Error())
-			return errors.New(e)
-		}
+	if t.Attr == nil {
+		t.Attr = make(map[string]string)
+	}
+	if t.Param == nil {
+		t.Param = make([]string, 0)
+	}
+	if t.Params == nil {
+		t.Params = make(map[string]string)
 	}
 
-	for _, p := range t.Param {
-		err = t.addParam(p)
-		if err != nil {
-			e := fmt.Sprintf("\n[t.AddTask] %s.", err.Error())
-			return errors.New(e)
-		}
+	err = g.store().AddTask(t)
+	if err != nil {
+		return newError(CodeStore, err, "\n[t.AddTask] %s.", err.Error())
 	}
 
 	return err
@@ -139,67 +120,144 @@ func (t *Task) AddTask() (err error) { // {{{
 
 //删除依赖的任务关系
 func (t *Task) DeleteRelTask(relid int64) error { // {{{
-	var i int
+	err := g.store().DeleteRelTask(t, relid)
+	if err != nil {
+		return newError(CodeStore, err, "\n[t.DeleteRelTask] %s.", err.Error())
+	}
+
+	t.removeRelTask(relid)
+	return err
+} // }}}
+
+//removeRelTask从内存中移除对任务relid的依赖，未依赖该任务时不做处理
+func (t *Task) removeRelTask(relid int64) { // {{{
 	for k, v := range t.RelTasksId {
 		if v == relid {
-			i = k
+			t.RelTasksId = append(t.RelTasksId[0:k], t.RelTasksId[k+1:]...)
+			t.RelTaskCnt--
+			break
 		}
 	}
-	t.RelTasksId = append(t.RelTasksId[0:i], t.RelTasksId[i+1:]...)
-	t.RelTaskCnt--
-	delete(t.RelTasks, string(relid))
-
-	err := t.deleteRelTask(relid)
-	if err != nil {
-		e := fmt.Sprintf("\n[t.DeleteRelTask] %s.", err.Error())
-		return errors.New(e)
-	}
+	delete(t.RelTasks, taskKey(relid))
+	delete(t.RelConditions, relid)
+} // }}}
 
-	return err
+//taskKey返回任务id在Job.Tasks、Task.RelTasks中的键，即id的十进制字符串
+func taskKey(id int64) string { // {{{
+	return strconv.FormatInt(id, 10)
 } // }}}
 
-//增加依赖的任务
+//增加依赖的任务，依赖关系形成循环时返回error信息
 func (t *Task) AddRelTask(rt *Task) (err error) { // {{{
+	return t.AddRelTaskOn(rt, RelOnSuccess)
+} // }}}
+
+//AddRelTaskOn增加依赖的任务，并设置依赖任务结束后本任务的执行条件cond，
+//取值见RelOnSuccess、RelOnFailure、RelAlways。依赖关系形成循环时返回error信息。
+func (t *Task) AddRelTaskOn(rt *Task, cond string) (err error) { // {{{
+	switch cond {
+	case RelOnSuccess, RelOnFailure, RelAlways:
+	default:
+		return newError(CodeInvalid, nil, "\n[t.AddRelTaskOn] unknown condition [%s] of task [%s] on [%s].", cond, t.Name, rt.Name)
+	}
+	if rt.Id == t.Id || rt.dependsOn(t.Id) {
+		return newError(CodeInvalid, nil, "\n[t.AddRelTaskOn] task [%s] already depends on task [%s], the relation makes a cycle.", rt.Name, t.Name)
+	}
+
 	t.RelTasksId = append(t.RelTasksId, rt.Id)
 	t.RelTaskCnt++
-	t.RelTasks[string(rt.Id)] = rt
+	t.RelTasks[taskKey(rt.Id)] = rt
+	if cond != RelOnSuccess {
+		if t.RelConditions == nil {
+			t.RelConditions = make(map[int64]string)
+		}
+		t.RelConditions[rt.Id] = cond
+	}
 
-	err = t.addRelTask(rt.Id)
+	err = g.store().AddRelTask(t, rt.Id, cond)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.AddRelTask] error %s.", err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.AddRelTaskOn] error %s.", err.Error())
 	}
 	return err
 } // }}}
 
-//删除Task,依次删除Param、RelTask关系、Task
+//dependsOn判断任务是否直接或间接依赖指定id的任务
+func (t *Task) dependsOn(id int64) bool { // {{{
+	visited := make(map[int64]bool)
+	var walk func(t *Task) bool
+	walk = func(t *Task) bool {
+		for _, rt := range t.RelTasks {
+			if rt == nil || visited[rt.Id] {
+				continue
+			}
+			visited[rt.Id] = true
+			if rt.Id == id || walk(rt) {
+				return true
+			}
+		}
+		return false
+	}
+	return walk(t)
+} // }}}
+
+//relCondition返回任务对依赖任务relId的执行条件
+func (t *Task) relCondition(relId int64) string { // {{{
+	if cond, ok := t.RelConditions[relId]; ok {
+		return cond
+	}
+	return RelOnSuccess
+} // }}}
+
+//删除Task,在一个事务中依次删除Param、RelTask关系、Task，
+//事务提交后再清除内存中的依赖关系，失败时元数据库与内存均保持不变。
 func (t *Task) Delete() (err error) { // {{{
-	err = t.delParam()
+	if err = g.store().DeleteTask(t); err != nil {
+		return newError(CodeStore, err, "\n[t.Delete] %s", err.Error())
+	}
+
+	t.clearRelTasks()
+	return nil
+} // }}}
+
+//delete在事务tx中删除Task的元数据，依次删除Param、运行参数、RelTask关系、其它任务对它的依赖、作业映射关系、Task
+func (t *Task) delete(tx execer) (err error) { // {{{
+	err = t.delParam(tx)
+	if err != nil {
+		return newError(CodeStore, err, "\n[t.delete] error %s.", err.Error())
+	}
+
+	err = t.delEnv(tx)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.Delete] error %s.", err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.delete] error %s.", err.Error())
 	}
 
 	for _, rid := range t.RelTasksId {
-		err = t.DeleteRelTask(rid)
+		err = t.deleteRelTask(tx, rid)
 		if err != nil {
-			e := fmt.Sprintf("\n[t.Delete] %s.", err.Error())
-			return errors.New(e)
+			return newError(CodeStore, err, "\n[t.delete] %s.", err.Error())
 		}
+	}
 
+	err = t.deleteDependents(tx)
+	if err != nil {
+		return newError(CodeStore, err, "\n[t.delete] %s.", err.Error())
 	}
 
-	err = t.deleteJobTaskRel()
+	err = t.deleteJobTaskRel(tx)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.Delete] error %s.", err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.delete] error %s.", err.Error())
 	}
 
-	err = t.deleteTask()
+	err = t.deleteTask(tx)
 	if err != nil {
-		e := fmt.Sprintf("\n[t.Delete] error %s.", err.Error())
-		return errors.New(e)
+		return newError(CodeStore, err, "\n[t.delete] error %s.", err.Error())
 	}
 	return err
+} // }}}
 
+//clearRelTasks清除内存中Task的全部依赖关系
+func (t *Task) clearRelTasks() { // {{{
+	t.RelTasksId, t.RelTaskCnt = make([]int64, 0), 0
+	t.RelTasks = make(map[string]*Task)
+	t.RelConditions = nil
 } // }}}
@@ -0,0 +1,276 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+//SyncExecutor是在本进程内执行任务的模拟执行者，不会真正执行任务的命令。
+//任务按调用顺序逐个执行，执行顺序记录在Order中，用于验证调度中任务的先后关系。
+//Fail中指定的任务会以对应的错误信息执行失败，其余任务均执行成功，
+//成功时任务的输出为命令与参数。
+type SyncExecutor struct { // {{{
+	lock  sync.Mutex
+	Fail  map[string]string //执行失败的任务名称与错误信息
+	Order []string          //任务的执行顺序，记录任务名称
+} // }}}
+
+//Run执行任务，记录执行顺序并按Fail的设置返回执行结果。
+func (se *SyncExecutor) Run(task *Task, reply *Reply) error { // {{{
+	se.lock.Lock()
+	defer se.lock.Unlock()
+
+	se.Order = append(se.Order, task.Name)
+	if msg, ok := se.Fail[task.Name]; ok {
+		reply.Err = msg
+		return nil
+	}
+
+	reply.Stdout = strings.TrimSpace(task.Cmd + " " + strings.Join(task.Param, " "))
+	return nil
+} // }}}
+
+//调度的执行结果
+type ExecResult struct { // {{{
+	BatchId        string       //批次ID
+	ScheduleId     int64        //调度ID
+	State          int8         //状态 3. 完成 4.意外中止
+	Result         float32      //结果,调度中执行成功任务的百分比
+	SuccessTaskCnt int          //执行成功任务数量
+	FailTaskCnt    int          //执行失败任务数量
+	SkipTaskCnt    int          //执行条件不满足被跳过的任务数量
+	StartTime      time.Time    //开始时间
+	EndTime        time.Time    //结束时间
+	Tasks          []TaskResult //任务的执行结果，按完成的先后排列
+} // }}}
+
+//任务的执行结果
+type TaskResult struct { // {{{
+	TaskId        int64         //任务ID
+	Name          string        //任务名称
+	State         int8          //状态 2. 暂停 3. 完成 4.意外中止 5.忽略 6.跳过
+	Attempt       int           //执行次数
+	ExitCode      int           //任务命令的退出码，未执行或未取得时为-1
+	DispatchRetry int           //发送任务遇到暂时性错误后的重试次数
+	Backoff       time.Duration //发送重试累计等待的时间
+	Output        string        //任务输出，从日志库读取时超过GlobalConfigStruct.TaskOutputLimit的部分已截断
+	TimedOut      bool          //任务因调度或作业执行超过TimeOut被中止，而不是自然结束
+	TimeoutLevel  string        //中止任务的超时级别，取值见TimeoutSchedule、TimeoutJob，未超时为空
+	StartTime     time.Time     //开始时间
+	EndTime       time.Time     //结束时间
+} // }}}
+
+//Success判断调度中的任务是否全部执行成功，被忽略、被跳过的任务视为成功。
+func (r *ExecResult) Success() bool { // {{{
+	return r.State == 3 && r.FailTaskCnt == 0
+} // }}}
+
+//TestRun不会并发执行，执行期间会替换全局配置
+var testRunLock sync.Mutex
+
+//TestRun在隔离的测试环境中完整执行一次调度，返回执行结果。
+//执行时使用内存中的ScheduleManager，不读写元数据库和日志库，任务交给参数exec执行，
+//exec为nil时使用SyncExecutor。params按任务名称替换任务的参数，未指定的任务使用原有参数。
+//调度s需包含完整的调度链（Job、Job.Tasks、Task.RelTasks），执行时使用的是它的副本，
+//s本身不会被修改。
+//TestRun执行期间会替换包内的全局配置，只应在测试中使用，不能与正在运行的调度共存。
+//调度超时中止时，等待正在执行的任务结束后才恢复全局配置并返回，exec需实现Aborter使被中止的任务能够结束。
+func TestRun(s *Schedule, params map[string][]string, exec Executor) (*ExecResult, error) { // {{{
+	testRunLock.Lock()
+	defer testRunLock.Unlock()
+
+	ts, err := cloneSchedule(s, params)
+	if err != nil {
+		e := fmt.Sprintf("\n[TestRun] %s", err.Error())
+		return nil, errors.New(e)
+	}
+
+	//空调度执行时不会有任务完成，无法正常结束
+	if ts.isEmpty() {
+		e := fmt.Sprintf("\n[TestRun] schedule [%d %s] has no task.", ts.Id, ts.Name)
+		return nil, errors.New(e)
+	}
+
+	//使用内存中的全局配置执行，结束后恢复
+	og := g
+	tg := DefaultGlobal()
+	if og != nil {
+		tg.L = og.L
+		tg.MaxParallelJobs, tg.GroupFailPolicy = og.MaxParallelJobs, og.GroupFailPolicy
+		tg.ResourcePools, tg.TaskExecutors = og.ResourcePools, og.TaskExecutors
+		tg.DisabledTaskPolicy = og.DisabledTaskPolicy
+	}
+	tg.NoLog = true
+	tg.EventBuffer = ts.TaskCnt*2 + ts.JobCnt*2 + 4
+	if exec != nil {
+		tg.Executor = exec
+	} else {
+		tg.Executor = &SyncExecutor{}
+	}
+	g = tg
+	defer func() { g = og }()
+
+	tg.Schedules.ScheduleList = append(tg.Schedules.ScheduleList, ts)
+	events, cancel := tg.Schedules.Subscribe()
+	defer cancel()
+
+	es := ExecScheduleWarper(ts)
+	es.execType = 2
+	if err = es.InitExecSchedule(); err != nil {
+		e := fmt.Sprintf("\n[TestRun] %s", err.Error())
+		return nil, errors.New(e)
+	}
+	ets := make(map[int64]*ExecTask)
+	for id, et := range es.execTasks {
+		ets[id] = et
+	}
+
+	tg.Schedules.AddExecSchedule(es)
+	es.Run()
+
+	//超时中止的批次结束时仍有任务在执行，结束后才能恢复全局配置
+	es.taskRuns.Wait()
+
+	r := &ExecResult{
+		BatchId:        es.batchId,
+		ScheduleId:     ts.Id,
+		State:          es.state,
+		Result:         es.result,
+		SuccessTaskCnt: es.successTaskCnt,
+		FailTaskCnt:    es.failTaskCnt,
+		SkipTaskCnt:    es.skipTaskCnt,
+		StartTime:      es.startTime,
+		EndTime:        es.endTime,
+		Tasks:          make([]TaskResult, 0),
+	}
+
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == EventRunFail {
+				r.State = 4
+			}
+			if ev.Type != EventTaskDone {
+				continue
+			}
+			et := ets[ev.TaskId]
+			r.Tasks = append(r.Tasks, TaskResult{
+				TaskId:        et.task.Id,
+				Name:          et.task.Name,
+				State:         et.state,
+				Attempt:       et.attempt,
+				ExitCode:      et.exitCode,
+				DispatchRetry: et.dispatchRetry,
+				Backoff:       et.dispatchBackoff,
+				Output:        et.output,
+				TimedOut:      et.isTimedOut(),
+				TimeoutLevel:  et.getTimeoutLevel(),
+				StartTime:     et.startTime,
+				EndTime:       et.endTime,
+			})
+		default:
+			return r, nil
+		}
+	}
+} // }}}
+
+//cloneSchedule复制调度链，得到可独立执行的调度副本。
+//副本中的作业、任务及依赖关系均为新的对象，可作为执行时调度链的快照。
+//params中指定的任务参数会替换副本中对应任务的参数。
+func cloneSchedule(s *Schedule, params map[string][]string) (*Schedule, error) { // {{{
+	if s == nil {
+		return nil, errors.New("schedule is nil.")
+	}
+
+	ts := &Schedule{
+		Id:                 s.Id,
+		Name:               s.Name,
+		Group:              s.Group,
+		Status:             s.Status,
+		Enabled:            s.Enabled,
+		Count:              s.Count,
+		Remain:             s.Remain,
+		Cyc:                s.Cyc,
+		StartSecond:        s.StartSecond,
+		StartMonth:         s.StartMonth,
+		StartWeekday:       s.StartWeekday,
+		Params:             s.Params,
+		TimeOut:            s.TimeOut,
+		SoftTimeOut:        s.SoftTimeOut,
+		Overlap:            s.Overlap,
+		Misfire:            s.Misfire,
+		ScheduleRetryCount: s.ScheduleRetryCount,
+		ScheduleRetryDelay: s.ScheduleRetryDelay,
+		OnStartupPolicy:    s.OnStartupPolicy,
+		Priority:           s.Priority,
+		TimeZone:           s.TimeZone,
+		Desc:               s.Desc,
+		Jobs:               make([]*Job, 0),
+		Tasks:              make([]*Task, 0),
+	}
+
+	//复制作业及作业中的任务
+	tasks := make(map[int64]*Task)
+	var pj *Job
+	for j := s.Job; j != nil; j = j.NextJob {
+		tj := &Job{
+			Id:            j.Id,
+			Name:          j.Name,
+			Desc:          j.Desc,
+			ParallelGroup: j.ParallelGroup,
+			TimeOut:       j.TimeOut,
+			ScheduleId:    ts.Id,
+			ScheduleCyc:   ts.Cyc,
+			Tasks:         make(map[string]*Task),
+		}
+
+		for k, t := range j.Tasks {
+			if _, ok := tasks[t.Id]; ok {
+				e := fmt.Sprintf("task id [%d] is duplicated in schedule [%s].", t.Id, s.Name)
+				return nil, errors.New(e)
+			}
+
+			tt := *t
+			tt.JobId, tt.ScheduleCyc = tj.Id, ts.Cyc
+			if p, ok := params[t.Name]; ok {
+				tt.Param = p
+			}
+			tasks[t.Id] = &tt
+			tj.Tasks[k] = &tt
+			tj.TaskCnt++
+			ts.addTaskList(&tt)
+		}
+
+		if pj == nil {
+			ts.Job, ts.JobId = tj, tj.Id
+		} else {
+			pj.NextJob, pj.NextJobId = tj, tj.Id
+			tj.PreJob, tj.PreJobId = pj, pj.Id
+		}
+		ts.Jobs = append(ts.Jobs, tj)
+		ts.JobCnt++
+		pj = tj
+	}
+
+	//依赖关系指向副本中的任务
+	for _, t := range ts.Tasks {
+		rts := make(map[string]*Task)
+		for k, rt := range t.RelTasks {
+			if rt == nil {
+				continue
+			}
+			nt, ok := tasks[rt.Id]
+			if !ok {
+				e := fmt.Sprintf("task [%d %s] depends on task [%d] which is not in schedule [%s].", t.Id, t.Name, rt.Id, s.Name)
+				return nil, errors.New(e)
+			}
+			rts[k] = nt
+		}
+		t.RelTasks = rts
+	}
+
+	return ts, nil
+} // }}}
@@ -0,0 +1,182 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//同时执行的调度批次达到MaxConcurrentSchedules时，新启动批次的处理策略
+const (
+	ThrottleWait = "wait" //等待执行中的批次结束后按优先级、启动顺序开始，见Schedule.Priority
+	ThrottleDrop = "drop" //记录警告，放弃本次执行，等待下一周期
+)
+
+//调度批次的并发闸门，限制同时执行的批次数量，跨调度生效。
+//上限与处理策略读取GlobalConfigStruct.MaxConcurrentSchedules、ScheduleThrottle，
+//运行期间通过SetMaxConcurrentSchedules调整，放宽上限后等待中的批次立即开始。
+//有位置时等待中的批次按优先级从高到低开始，优先级相同时先启动的批次先开始。
+type scheduleGate struct { // {{{
+	lock    sync.Mutex
+	running int           //正在执行的批次数量
+	waiters []*gateWaiter //等待开始的批次，按开始的顺序排列，轮到时关闭对应的通道
+} // }}}
+
+//等待并发位置的批次
+type gateWaiter struct { // {{{
+	priority int           //调度的优先级，见Schedule.Priority
+	fired    time.Time     //批次的启动时间
+	ch       chan struct{} //轮到时关闭
+} // }}}
+
+//创建调度批次的并发闸门
+func newScheduleGate() *scheduleGate { // {{{
+	return &scheduleGate{waiters: make([]*gateWaiter, 0)}
+} // }}}
+
+//acquire占用一个执行位置，throttled表示是否达到了上限。
+//达到上限时按ScheduleThrottle处理：ThrottleDrop返回error信息；
+//ThrottleWait按优先级priority与启动时间fired排队等待其它批次结束，done被关闭时放弃等待并返回error信息。
+func (sg *scheduleGate) acquire(done <-chan struct{}, priority int, fired time.Time) (throttled bool, err error) { // {{{
+	sg.lock.Lock()
+	limit := g.MaxConcurrentSchedules
+	if limit <= 0 || sg.running < limit {
+		sg.running++
+		sg.lock.Unlock()
+		return false, nil
+	}
+	if g.ScheduleThrottle == ThrottleDrop {
+		sg.lock.Unlock()
+		e := fmt.Sprintf("\n[sg.acquire] %d schedules are running, reach the limit %d.", sg.running, limit)
+		return true, errors.New(e)
+	}
+	ch := make(chan struct{})
+	sg.enqueue(&gateWaiter{priority: priority, fired: fired, ch: ch})
+	sg.lock.Unlock()
+
+	select {
+	case <-ch:
+		return true, nil
+	case <-done:
+	}
+
+	sg.lock.Lock()
+	defer sg.lock.Unlock()
+	granted := true
+	for i, w := range sg.waiters {
+		if w.ch == ch {
+			sg.waiters = append(sg.waiters[:i], sg.waiters[i+1:]...)
+			g.metrics().SchedulesWaiting(len(sg.waiters))
+			granted = false
+			break
+		}
+	}
+	//放弃等待的同时已轮到执行，归还占用的位置
+	if granted {
+		sg.running--
+		sg.wake()
+	}
+	return true, errors.New("\n[sg.acquire] wait for running schedules is cancelled.")
+} // }}}
+
+//enqueue将批次按优先级从高到低、启动时间从早到晚的顺序加入等待队列，调用方需持有锁
+func (sg *scheduleGate) enqueue(w *gateWaiter) { // {{{
+	i := len(sg.waiters)
+	for i > 0 {
+		prev := sg.waiters[i-1]
+		if prev.priority > w.priority || (prev.priority == w.priority && !prev.fired.After(w.fired)) {
+			break
+		}
+		i--
+	}
+	sg.waiters = append(sg.waiters, nil)
+	copy(sg.waiters[i+1:], sg.waiters[i:])
+	sg.waiters[i] = w
+	g.metrics().SchedulesWaiting(len(sg.waiters))
+} // }}}
+
+//release归还占用的执行位置，并按顺序唤醒等待中的批次
+func (sg *scheduleGate) release() { // {{{
+	sg.lock.Lock()
+	defer sg.lock.Unlock()
+	sg.running--
+	sg.wake()
+} // }}}
+
+//wake在未达到上限时按队列顺序唤醒批次，唤醒的批次计入执行中的数量，调用方需持有锁
+func (sg *scheduleGate) wake() { // {{{
+	n := len(sg.waiters)
+	for len(sg.waiters) > 0 && (g.MaxConcurrentSchedules <= 0 || sg.running < g.MaxConcurrentSchedules) {
+		sg.running++
+		close(sg.waiters[0].ch)
+		sg.waiters = sg.waiters[1:]
+	}
+	if len(sg.waiters) != n {
+		g.metrics().SchedulesWaiting(len(sg.waiters))
+	}
+} // }}}
+
+//SetMaxConcurrentSchedules在运行期间调整同时执行的调度批次数量上限，小于等于0表示不限制。
+//调小上限时不影响已开始的批次，执行中的数量降到上限以下后才开始新的批次。
+func (sl *ScheduleManager) SetMaxConcurrentSchedules(n int) { // {{{
+	sg := sl.gate
+	sg.lock.Lock()
+	defer sg.lock.Unlock()
+	sl.Global.MaxConcurrentSchedules = n
+	sg.wake()
+	sl.Global.L.Infoln("[sl.SetMaxConcurrentSchedules] max concurrent schedules is set to", n)
+} // }}}
+
+//调度批次的并发情况，由ConcurrentSchedules返回
+type ConcurrencyUsage struct { // {{{
+	Limit   int //同时执行的批次数量上限，小于等于0表示不限制
+	Running int //正在执行的批次数量
+	Waiting int //等待开始的批次数量，即等待队列的长度
+} // }}}
+
+//ConcurrentSchedules返回同时执行的调度批次的上限、执行中与等待中的数量
+func (sl *ScheduleManager) ConcurrentSchedules() ConcurrencyUsage { // {{{
+	sg := sl.gate
+	sg.lock.Lock()
+	defer sg.lock.Unlock()
+	return ConcurrencyUsage{Limit: sl.Global.MaxConcurrentSchedules, Running: sg.running, Waiting: len(sg.waiters)}
+} // }}}
+
+//throttle在批次开始前占用并发闸门中的一个位置，返回归还位置的方法。
+//等待时按调度的Priority排队，启动时间取批次加入执行列表的时间。
+//无法占用时结束批次并记录日志，自动调度的批次重新计时等待下一周期。
+func (es *ExecSchedule) throttle() (func(), error) { // {{{
+	sg := g.Schedules.gate
+	fired := es.addTime
+	if fired.IsZero() {
+		fired = time.Now()
+	}
+	throttled, err := sg.acquire(es.done, es.schedule.Priority, fired)
+	if throttled {
+		g.metrics().ScheduleThrottled()
+	}
+	if err != nil {
+		g.Schedules.RemoveExecSchedule(es.batchId)
+		es.lock.Lock()
+		es.startTime = time.Now().Local()
+		es.lock.Unlock()
+		es.finish(4)
+		if err := es.Log(); err != nil {
+			es.log.Warningln(fmt.Sprintf("\n[es.throttle] %s", err.Error()))
+		}
+		if es.execType == 1 && !es.queued {
+			go es.origin.Timer()
+		}
+
+		e := fmt.Sprintf("\n[es.throttle] schedule [%d %s] batchId=[%s] is not started. %s",
+			es.schedule.Id, es.schedule.Name, es.batchId, err.Error())
+		return nil, errors.New(e)
+	}
+
+	g.metrics().ScheduleStart()
+	return func() {
+		g.metrics().ScheduleEnd()
+		sg.release()
+	}, nil
+} // }}}
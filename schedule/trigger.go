@@ -0,0 +1,148 @@
+package schedule
+
+import (
+	"fmt"
+)
+
+//Trigger为调度间的触发关系，上游调度的批次结束后立即执行下游调度，
+//用于表达单个调度的作业链无法表达的跨调度顺序。
+type Trigger struct { // {{{
+	ScheduleId int64 //被触发的下游调度ID
+	OnFailure  bool  //上游批次执行失败时是否也触发，为false时只在批次中的任务全部成功后触发
+} // }}}
+
+//AddTrigger设置调度id的批次结束后触发执行下游调度t.ScheduleId，并持久化到元数据库。
+//到同一下游调度的触发关系已存在时按t更新。
+//触发关系形成循环时返回error信息，避免调度之间无限地互相触发。
+func (sl *ScheduleManager) AddTrigger(id int64, t Trigger) error { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return err
+	}
+	s, ts := sl.GetScheduleById(id), sl.GetScheduleById(t.ScheduleId)
+	if s == nil || ts == nil {
+		return newError(CodeNotFound, nil, "\n[sl.AddTrigger] not found schedule by id %d or %d", id, t.ScheduleId)
+	}
+	if id == t.ScheduleId {
+		return newError(CodeInvalid, nil, "\n[sl.AddTrigger] schedule [%d] can not trigger itself.", id)
+	}
+
+	sl.triggerLock.Lock()
+	defer sl.triggerLock.Unlock()
+	if sl.triggerPath(t.ScheduleId, id) {
+		return newError(CodeInvalid, nil, "\n[sl.AddTrigger] schedule [%d] triggers [%d] will cause cyclic triggers.", id, t.ScheduleId)
+	}
+
+	triggers := make([]Trigger, 0)
+	for _, st := range sl.getTriggers(id) {
+		if st.ScheduleId != t.ScheduleId {
+			triggers = append(triggers, st)
+		}
+	}
+	triggers = append(triggers, t)
+
+	if err := sl.saveTriggers(s, triggers); err != nil {
+		return newError(CodeStore, err, "\n[sl.AddTrigger] %s", err.Error())
+	}
+	return nil
+} // }}}
+
+//DeleteTrigger删除调度id对下游调度scheduleId的触发关系，触发关系不存在时不做处理。
+func (sl *ScheduleManager) DeleteTrigger(id int64, scheduleId int64) error { // {{{
+	if err := sl.checkOpen(); err != nil {
+		return err
+	}
+	s := sl.GetScheduleById(id)
+	if s == nil {
+		return newError(CodeNotFound, nil, "\n[sl.DeleteTrigger] not found schedule by id %d", id)
+	}
+
+	sl.triggerLock.Lock()
+	defer sl.triggerLock.Unlock()
+	old := sl.getTriggers(id)
+	triggers := make([]Trigger, 0, len(old))
+	for _, t := range old {
+		if t.ScheduleId != scheduleId {
+			triggers = append(triggers, t)
+		}
+	}
+	if len(triggers) == len(old) {
+		return nil
+	}
+
+	if err := sl.saveTriggers(s, triggers); err != nil {
+		return newError(CodeStore, err, "\n[sl.DeleteTrigger] %s", err.Error())
+	}
+	return nil
+} // }}}
+
+//saveTriggers持久化调度s的触发关系，成功后持有调度列表的锁替换s.Triggers
+func (sl *ScheduleManager) saveTriggers(s *Schedule, triggers []Trigger) error { // {{{
+	if err := g.store().SaveScheduleTriggers(&Schedule{Id: s.Id, ModifyUserId: s.ModifyUserId, Triggers: triggers}); err != nil {
+		return err
+	}
+
+	sl.lock.Lock()
+	s.Triggers = triggers
+	sl.lock.Unlock()
+	return nil
+} // }}}
+
+//getTriggers返回调度id的触发关系的副本，调度不存在时返回nil
+func (sl *ScheduleManager) getTriggers(id int64) []Trigger { // {{{
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+
+	for _, s := range sl.ScheduleList {
+		if s.Id == id {
+			return append(make([]Trigger, 0, len(s.Triggers)), s.Triggers...)
+		}
+	}
+	return nil
+} // }}}
+
+//triggerPath判断沿触发关系能否从调度from到达调度to
+func (sl *ScheduleManager) triggerPath(from int64, to int64) bool { // {{{
+	visited := make(map[int64]bool)
+	next := []int64{from}
+	for len(next) > 0 {
+		id := next[0]
+		next = next[1:]
+		if id == to {
+			return true
+		}
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		for _, t := range sl.getTriggers(id) {
+			next = append(next, t.ScheduleId)
+		}
+	}
+	return false
+} // }}}
+
+//fireTriggers在批次结束后通过RunScheduleNow执行调度的下游调度。
+//批次中的任务全部成功时触发全部下游调度，否则只触发设置了OnFailure的下游调度。
+//补数执行的批次不触发；下游调度启动失败时只记录警告，不影响其它下游调度。
+func (es *ExecSchedule) fireTriggers() { // {{{
+	if es.execType == 4 || g == nil || g.Schedules == nil {
+		return
+	}
+
+	es.lock.Lock()
+	success := es.state == 3 && es.failTaskCnt == 0
+	es.lock.Unlock()
+
+	for _, t := range g.Schedules.getTriggers(es.schedule.Id) {
+		if !success && !t.OnFailure {
+			continue
+		}
+		batchId, err := g.Schedules.RunScheduleNow(t.ScheduleId)
+		if err != nil {
+			es.log.Warningln(fmt.Sprintf("[es.fireTriggers] trigger schedule [%d] error %s", t.ScheduleId, err.Error()))
+			continue
+		}
+		es.log.Infoln("schedule", es.schedule.Name, "batchId=[", es.batchId, "] triggers schedule", t.ScheduleId,
+			"batchId=[", batchId, "]")
+	}
+} // }}}
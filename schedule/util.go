@@ -1,25 +1,97 @@
 package schedule
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
 	"reflect"
+	"strings"
 	"time"
 )
 
-//获取距启动的时间（秒）
-func getCountDown(cyc string, sm []int, ss []time.Duration) (countDown time.Duration, err error) { // {{{
+//retryWait按GlobalConfigStruct.RetryJitter计算任务重试前的等待时间。
+//同时失败的任务各自在区间内随机等待，避免同时重试，等待时间不会超过interval。
+func retryWait(interval time.Duration) time.Duration { // {{{
+	if interval <= 0 {
+		return 0
+	}
+
+	switch g.RetryJitter {
+	case JitterFull:
+		return time.Duration(rand.Int63n(int64(interval) + 1))
+	case JitterEqual:
+		half := interval / 2
+		return interval - half + time.Duration(rand.Int63n(int64(half)+1))
+	}
+	return interval
+} // }}}
+
+//获取距启动的时间（秒），启动时间按loc所在的时区计算。
+//
+//按秒、分、时调度时启动时间为周期开始后经过的时长；按日、周、月、年调度时
+//启动时间为loc中的钟表时间，夏令时切换时的处理见inZone。
+//按间隔调度时（以IntervalPrefix开头）启动时间为anchor加上间隔的整数倍，其余周期不使用anchor。
+//计算得到的启动时间不晚于当前时间时返回error，不返回小于等于0的时长。
+func getCountDown(cyc string, sm []int, ss []time.Duration, loc *time.Location, anchor time.Time) (countDown time.Duration, err error) { // {{{
 	now := GetNow()
-	var startTime time.Time
+	startTime, err := nextStart(cyc, sm, ss, loc, anchor, now)
+	if err != nil {
+		return 0, err
+	}
+
+	countDown = startTime.Sub(now)
+	g.L.Debugln(fmt.Sprintf("[getCountDown] cyc=%s StartMonth=%v StartSecond=%v now=%s next start=%s count down=%s",
+		cyc, sm, ss, now, startTime, countDown))
+	if countDown <= 0 {
+		e := fmt.Sprintf("\n[getCountDown] cyc=%s StartMonth=%v StartSecond=%v next start %s is not after now %s.",
+			cyc, sm, ss, startTime, now)
+		return 0, errors.New(e)
+	}
+	return countDown, nil
+} // }}}
+
+//nextStart返回晚于now的第一个启动时间，参数与计算方式同getCountDown。
+//补数执行时据此列出一段时间内的启动时间，见Backfill。
+func nextStart(cyc string, sm []int, ss []time.Duration, loc *time.Location, anchor time.Time, now time.Time) (startTime time.Time, err error) { // {{{
+	now = now.In(loc)
+
+	//按间隔调度时从首次启动时间起每隔固定时长启动一次，不使用启动时间列表
+	if strings.HasPrefix(cyc, IntervalPrefix) {
+		interval, err := ParseInterval(cyc)
+		if err != nil {
+			return startTime, err
+		}
+		return intervalNext(anchor, interval, now), nil
+	}
+
+	//cron表达式按表达式计算下次启动时间，不使用启动时间列表
+	if strings.HasPrefix(cyc, CronPrefix) {
+		spec, err := ParseCronSpec(cyc)
+		if err != nil {
+			return startTime, err
+		}
+		next := spec.Next(now)
+		if next.IsZero() {
+			e := fmt.Sprintf("\n[nextStart] cron [%s] never fires.", cyc)
+			return startTime, errors.New(e)
+		}
+		return next, nil
+	}
+	//启动时间列表为空或不完整时无法计算
+	if len(ss) == 0 || len(sm) < len(ss) {
+		e := fmt.Sprintf("\n[nextStart] cycle [%s] has %d StartMonth and %d StartSecond, want at least one start time.", cyc, len(sm), len(ss))
+		return startTime, errors.New(e)
+	}
 	var b bool //执行时间是否在当前时间之后的标志
 
 	//按周期取整
 	s := TruncDate(cyc, now)
 
 	for i, st := range ss {
-		if s.AddDate(0, sm[i], 0).Add(st).After(now) {
+		if t := startAt(cyc, s, sm[i], st, loc); t.After(now) {
 			//执行时间在当前时间之后，设置标志，跳出循环进行下一步
 			b = true
-			startTime = s.AddDate(0, sm[i], 0).Add(st)
+			startTime = t
 			break
 		}
 	}
@@ -28,73 +100,109 @@ func getCountDown(cyc string, sm []int, ss []time.Duration) (countDown time.Dura
 		//解析周期并取得距下一周期的时间
 		switch {
 		case cyc == "ss":
-			startTime = s.AddDate(0, sm[0], 0).Add(time.Second).Add(ss[0])
+			startTime = startAt(cyc, s.Add(time.Second), sm[0], ss[0], loc)
 		case cyc == "mi":
 			//按分钟取整
-			startTime = s.AddDate(0, sm[0], 0).Add(time.Minute).Add(ss[0])
+			startTime = startAt(cyc, s.Add(time.Minute), sm[0], ss[0], loc)
 		case cyc == "h":
 			//按小时取整
-			startTime = s.AddDate(0, sm[0], 0).Add(time.Hour).Add(ss[0])
+			startTime = startAt(cyc, s.Add(time.Hour), sm[0], ss[0], loc)
 		case cyc == "d":
 			//按日取整
-			startTime = s.AddDate(0, sm[0], 0).AddDate(0, 0, 1).Add(ss[0])
+			startTime = startAt(cyc, s.AddDate(0, 0, 1), sm[0], ss[0], loc)
 		case cyc == "m":
 			//按月取整
-			startTime = s.AddDate(0, sm[0], 0).AddDate(0, 1, 0).Add(ss[0])
+			startTime = startAt(cyc, s.AddDate(0, 1, 0), sm[0], ss[0], loc)
 		case cyc == "w":
 			//按周取整
-			startTime = s.AddDate(0, sm[0], 0).AddDate(0, 0, 7).Add(ss[0])
+			startTime = startAt(cyc, s.AddDate(0, 0, 7), sm[0], ss[0], loc)
 		case cyc == "q":
 			//回头再处理
 		case cyc == "y":
 			//按年取整
-			startTime = s.AddDate(0, sm[0], 0).AddDate(1, 0, 0).Add(ss[0])
+			startTime = startAt(cyc, s.AddDate(1, 0, 0), sm[0], ss[0], loc)
 		}
 
 	}
-	countDown = startTime.Sub(time.Now())
 
-	return countDown, nil
+	return startTime, nil
+
+} // }}}
+
+//startAt返回周期开始时间base之后，偏移sm个月、st时长的启动时间。
+//按日、周、月、年调度时st为loc中当日的钟表时间，其余周期按实际经过的时长计算。
+func startAt(cyc string, base time.Time, sm int, st time.Duration, loc *time.Location) time.Time { // {{{
+	switch cyc {
+	case "d", "w", "m", "y":
+		wall := time.Date(base.Year(), base.Month()+time.Month(sm), base.Day(), 0, 0, 0, 0, time.UTC).Add(st)
+		return inZone(wall, loc)
+	}
+	return base.AddDate(0, sm, 0).Add(st)
+} // }}}
+
+//inZone将以UTC表示的钟表时间wall转换为loc中相同钟表时间的时刻。
+//
+//夏令时结束时重复出现的钟表时间取第一次出现的时刻，即每个周期只启动一次；
+//夏令时开始时被跳过的钟表时间按切换前的时差换算，启动时间顺延跳过的时长，
+//如America/New_York在切换日的02:30启动的调度会在03:30（EDT）启动，不会跳过当天。
+//cron表达式的处理不同，被跳过的钟表时间不启动，见CronSpec.Next。
+func inZone(wall time.Time, loc *time.Location) time.Time { // {{{
+	//时区切换的间隔大于一天，前后一天的时差覆盖了wall附近可能的全部时差
+	var first time.Time
+	for _, d := range []time.Duration{-24 * time.Hour, 24 * time.Hour} {
+		_, off := wall.Add(d).In(loc).Zone()
+		t := wall.Add(-time.Duration(off) * time.Second).In(loc)
+		local := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+		if local.Equal(wall) && (first.IsZero() || t.Before(first)) {
+			first = t
+		}
+	}
+	if !first.IsZero() {
+		return first
+	}
 
+	//被跳过的钟表时间
+	_, off := wall.Add(-24 * time.Hour).In(loc).Zone()
+	return wall.Add(-time.Duration(off) * time.Second).In(loc)
 } // }}}
 
-//时间取整
+//时间取整，按now所在的时区取整
 func TruncDate(cyc string, now time.Time) time.Time { // {{{
 
 	//解析周期并取得距下一周期的时间
 	switch {
 	case cyc == "ss":
 		//按秒取整
-		return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), 0, time.Local)
+		return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), 0, now.Location())
 	case cyc == "mi":
 		//按分钟取整
-		return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, time.Local)
+		return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, now.Location())
 
 	case cyc == "h":
 		//按小时取整
-		return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, time.Local)
+		return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
 	case cyc == "d":
 		//按日取整
-		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 	case cyc == "m":
 		//按月取整
-		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
 	case cyc == "w":
 		//按周取整
-		return time.Date(now.Year(), now.Month(), now.Day()-int(now.Weekday()), 0, 0, 0, 0, time.Local)
+		return time.Date(now.Year(), now.Month(), now.Day()-int(now.Weekday()), 0, 0, 0, 0, now.Location())
 	case cyc == "q":
 		//回头再处理
 	case cyc == "y":
 		//按年取整
-		return time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.Local)
+		return time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
 	}
-	return time.Now()
+	return GetNow()
 
 } // }}}
 
-//获取当前时间
+//获取当前时间，使用GlobalConfigStruct.Clock
 func GetNow() time.Time { // {{{
-	return time.Now().Local()
+	return g.clock().Now().Local()
 } // }}}
 
 //CheckErr检查错误信息，若有错误则打印并抛出异常。
@@ -0,0 +1,244 @@
+package schedule
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//各调度周期的长度，启动时间需小于所在周期的长度。
+//月、季、年按最长的情况计算。
+var cycLength = map[string]time.Duration{
+	"ss": time.Second,
+	"mi": time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+	"m":  31 * 24 * time.Hour,
+	"q":  92 * 24 * time.Hour,
+	"y":  366 * 24 * time.Hour,
+}
+
+//标签的最大长度，与scd_schedule_tag表中tag字段的长度一致
+const TagMaxLength = 64
+
+//FieldError记录一个字段的校验失败信息
+type FieldError struct { // {{{
+	Field   string //字段名称，启动列表的字段带有序号，如StartSecond[1]
+	Message string //失败原因
+} // }}}
+
+func (fe FieldError) Error() string { // {{{
+	return fmt.Sprintf("%s %s", fe.Field, fe.Message)
+} // }}}
+
+//ValidationError记录Schedule校验失败的全部信息
+type ValidationError struct { // {{{
+	ScheduleId   int64        //调度ID
+	ScheduleName string       //调度名称
+	Errors       []FieldError //校验失败的字段
+} // }}}
+
+func (ve *ValidationError) Error() string { // {{{
+	msgs := make([]string, 0, len(ve.Errors))
+	for _, fe := range ve.Errors {
+		msgs = append(msgs, fe.Error())
+	}
+	return fmt.Sprintf("\n[s.Validate] schedule [%d %s] is invalid: %s.", ve.ScheduleId, ve.ScheduleName, strings.Join(msgs, "; "))
+} // }}}
+
+//Validate校验Schedule的调度周期与启动列表，全部通过时返回nil，
+//否则返回*ValidationError，其中包含每一项校验失败的信息：
+//
+//	Cyc为空（不调度）、ss、mi、h、d、w、m、q、y、以CronPrefix开头的cron表达式
+//	或以IntervalPrefix开头的间隔；
+//	StartMonth与StartSecond长度一致；
+//	StartMonth为启动月份的偏移，取值0-12，0表示未指定；
+//	StartSecond不小于0且小于调度周期的长度，如按日调度时为0-86399秒，
+//	按周调度且设置了StartWeekday时为当日的启动时间，取值0-86399秒；
+//	StartWeekday只用于按周调度，取值0-6（星期日至星期六）且不重复；
+//	TimeZone为空或可以加载的IANA时区名称；
+//	StartJitter不小于0，按秒存储；
+//	ScheduleRetryCount、ScheduleRetryDelay不小于0；
+//	OnStartupPolicy为空或StartupWait、StartupRunIfMissed、StartupAlwaysRun之一；
+//	Tags中的标签不为空、不含首尾空白、长度不超过TagMaxLength且不重复。
+func (s *Schedule) Validate() error { // {{{
+	ve := &ValidationError{ScheduleId: s.Id, ScheduleName: s.Name}
+	add := func(field, format string, args ...interface{}) {
+		ve.Errors = append(ve.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length, known := cycLength[s.Cyc]
+	switch {
+	case s.Cyc == "" || known:
+	case strings.HasPrefix(s.Cyc, IntervalPrefix):
+		if _, err := ParseInterval(s.Cyc); err != nil {
+			add("Cyc", "is not a valid interval: %s", strings.TrimSpace(err.Error()))
+		}
+	case strings.HasPrefix(s.Cyc, CronPrefix):
+		if _, err := ParseCronSpec(s.Cyc); err != nil {
+			add("Cyc", "is not a valid cron expression: %s", strings.TrimSpace(err.Error()))
+		}
+	default:
+		add("Cyc", "[%s] is not a recognized cycle", s.Cyc)
+	}
+
+	weekdays := make(map[time.Weekday]bool)
+	for i, wd := range s.StartWeekday {
+		field := fmt.Sprintf("StartWeekday[%d]", i)
+		switch {
+		case wd < time.Sunday || wd > time.Saturday:
+			add(field, "[%d] is out of range 0-6", wd)
+		case weekdays[wd]:
+			add(field, "[%d] is duplicated", wd)
+		}
+		weekdays[wd] = true
+	}
+	if len(s.StartWeekday) > 0 {
+		if s.Cyc == "w" {
+			length = cycLength["d"]
+		} else {
+			add("StartWeekday", "is only valid for weekly cycle w, got [%s]", s.Cyc)
+		}
+	}
+
+	if len(s.StartMonth) != len(s.StartSecond) {
+		add("StartMonth", "has %d entries but StartSecond has %d", len(s.StartMonth), len(s.StartSecond))
+	}
+
+	for i, sm := range s.StartMonth {
+		if sm < 0 || sm > 12 {
+			add(fmt.Sprintf("StartMonth[%d]", i), "[%d] is out of range 0-12", sm)
+		}
+	}
+
+	for i, st := range s.StartSecond {
+		field := fmt.Sprintf("StartSecond[%d]", i)
+		switch {
+		case known && (st < 0 || st >= length):
+			add(field, "[%d] is out of range 0-%d", st/time.Second, (length-time.Second)/time.Second)
+		case st < 0:
+			add(field, "[%d] is negative", st/time.Second)
+		}
+	}
+
+	if s.TimeZone != "" {
+		if _, err := time.LoadLocation(s.TimeZone); err != nil {
+			add("TimeZone", "[%s] is not a valid IANA time zone", s.TimeZone)
+		}
+	}
+
+	switch {
+	case s.StartJitter < 0:
+		add("StartJitter", "[%s] is negative", s.StartJitter)
+	case s.StartJitter%time.Second != 0:
+		add("StartJitter", "[%s] is not a whole number of seconds", s.StartJitter)
+	}
+
+	if s.ScheduleRetryCount < 0 {
+		add("ScheduleRetryCount", "[%d] is negative", s.ScheduleRetryCount)
+	}
+	if s.ScheduleRetryDelay < 0 {
+		add("ScheduleRetryDelay", "[%d] is negative", s.ScheduleRetryDelay)
+	}
+	switch s.OnStartupPolicy {
+	case "", StartupWait, StartupRunIfMissed, StartupAlwaysRun:
+	default:
+		add("OnStartupPolicy", "[%s] is unknown", s.OnStartupPolicy)
+	}
+
+	tags := make(map[string]bool)
+	for i, tag := range s.Tags {
+		field := fmt.Sprintf("Tags[%d]", i)
+		switch {
+		case tag == "":
+			add(field, "is empty")
+		case strings.TrimSpace(tag) != tag:
+			add(field, "[%s] has leading or trailing spaces", tag)
+		case len(tag) > TagMaxLength:
+			add(field, "[%s] is longer than %d bytes", tag, TagMaxLength)
+		case tags[tag]:
+			add(field, "[%s] is duplicated", tag)
+		}
+		tags[tag] = true
+	}
+
+	if len(ve.Errors) > 0 {
+		return ve
+	}
+	return nil
+} // }}}
+
+//checkChain检查调度链的结构是否完整，出现以下情况时返回列出全部问题作业、任务ID的error信息：
+//
+//	设置了JobId但没有加载作业；
+//	作业的NextJobId指向的作业不在调度链中，或作业在调度链中重复出现；
+//	任务依赖的任务（RelTasksId、RelTasks）不在调度链中。
+//
+//结构不完整的调度链在执行中途才会出错，构建执行结构前先行检查，见InitExecSchedule。
+func (s *Schedule) checkChain() error { // {{{
+	errs := make([]string, 0)
+	if s.JobId != 0 && s.Job == nil {
+		errs = append(errs, fmt.Sprintf("first job [%d] is not found", s.JobId))
+	}
+
+	jobs := make([]*Job, 0)
+	ids := make(map[int64]bool)
+	for j := s.Job; j != nil; j = j.NextJob {
+		if ids[j.Id] {
+			errs = append(errs, fmt.Sprintf("job [%d] appears more than once", j.Id))
+			break
+		}
+		ids[j.Id] = true
+		jobs = append(jobs, j)
+		if j.NextJobId != 0 && (j.NextJob == nil || j.NextJob.Id != j.NextJobId) {
+			errs = append(errs, fmt.Sprintf("job [%d] next job [%d] is not found", j.Id, j.NextJobId))
+		}
+	}
+
+	tasks := make(map[int64]bool)
+	for _, j := range jobs {
+		for _, t := range j.Tasks {
+			tasks[t.Id] = true
+		}
+	}
+	for _, j := range jobs {
+		ts := make([]*Task, 0, len(j.Tasks))
+		for _, t := range j.Tasks {
+			ts = append(ts, t)
+		}
+		sort.Slice(ts, func(a, b int) bool { return ts[a].Id < ts[b].Id })
+
+		for _, t := range ts {
+			missing := make(map[int64]bool)
+			for _, id := range t.RelTasksId {
+				missing[id] = !tasks[id]
+			}
+			for k, rt := range t.RelTasks {
+				if rt != nil {
+					missing[rt.Id] = !tasks[rt.Id]
+				} else if id, err := strconv.ParseInt(k, 10, 64); err == nil {
+					missing[id] = true
+				}
+			}
+
+			rids := make([]int64, 0)
+			for id, m := range missing {
+				if m {
+					rids = append(rids, id)
+				}
+			}
+			if len(rids) > 0 {
+				sort.Slice(rids, func(a, b int) bool { return rids[a] < rids[b] })
+				errs = append(errs, fmt.Sprintf("task [%d %s] depends on tasks %v which are not found", t.Id, t.Name, rids))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return newError(CodeInvalid, nil, "\n[s.checkChain] schedule [%d %s] has an invalid chain: %s.", s.Id, s.Name, strings.Join(errs, "; "))
+	}
+	return nil
+} // }}}
@@ -0,0 +1,112 @@
+package schedule
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+//调用Webhook的超时时间
+const webhookTimeout = 10 * time.Second
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+//WebhookPayload为批次结束时POST给GlobalConfigStruct.Webhooks的JSON内容，
+//Content-Type为application/json，字段名见json标签。
+type WebhookPayload struct { // {{{
+	ScheduleId    int64     `json:"schedule_id"`     //调度ID
+	ScheduleName  string    `json:"schedule_name"`   //调度名称
+	RunId         string    `json:"run_id"`          //批次ID，可用于GetExecSchedule查询
+	Outcome       RunStatus `json:"outcome"`         //执行结果，取值为RunSuccess或RunFailed
+	StartTime     time.Time `json:"start_time"`      //开始时间，RFC3339格式
+	EndTime       time.Time `json:"end_time"`        //结束时间，RFC3339格式
+	Duration      float64   `json:"duration"`        //执行时长（秒）
+	FailedTaskIds []int64   `json:"failed_task_ids"` //执行失败的任务ID，没有时为空数组
+} // }}}
+
+//payload生成批次结束时的Webhook内容。
+//任务全部成功时结果为RunSuccess，有任务失败或批次异常结束时为RunFailed。
+func (es *ExecSchedule) payload() *WebhookPayload { // {{{
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	p := &WebhookPayload{
+		ScheduleId:    es.schedule.Id,
+		ScheduleName:  es.schedule.Name,
+		RunId:         es.batchId,
+		Outcome:       RunFailed,
+		StartTime:     es.startTime,
+		EndTime:       es.endTime,
+		FailedTaskIds: append([]int64{}, es.failedTasks...),
+	}
+	if runStatus(es.state, es.failTaskCnt) == RunSuccess {
+		p.Outcome = RunSuccess
+	}
+	if p.EndTime.IsZero() {
+		p.EndTime = time.Now().Local()
+	}
+	if !p.StartTime.IsZero() {
+		p.Duration = p.EndTime.Sub(p.StartTime).Seconds()
+	}
+	return p
+} // }}}
+
+//notify在批次结束时将执行结果POST给GlobalConfigStruct.Webhooks中的每个地址。
+//每个地址在单独的线程中发送，不等待结果，发送失败只记录警告，不影响调度的执行。
+func (es *ExecSchedule) notify() { // {{{
+	if len(g.Webhooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(es.payload())
+	if err != nil {
+		es.log.Warningln(fmt.Sprintf("[es.notify] marshal webhook payload error %s.", err.Error()))
+		return
+	}
+
+	for _, url := range g.Webhooks {
+		go func(url string) {
+			if err := postWebhook(url, body); err != nil {
+				es.log.Warningln(fmt.Sprintf("[es.notify] %s", err.Error()))
+			}
+		}(url)
+	}
+} // }}}
+
+//postWebhook将body POST给url，返回2xx以外的状态码或请求失败时
+//按GlobalConfigStruct.WebhookRetry重试，第n次重试前等待WebhookBackoff的2^(n-1)倍。
+func postWebhook(url string, body []byte) error { // {{{
+	wait := g.WebhookBackoff
+	for attempt := 0; ; attempt++ {
+		err := postOnce(url, body)
+		if err == nil {
+			return nil
+		}
+		if attempt >= g.WebhookRetry {
+			e := fmt.Sprintf("\n[postWebhook] post to [%s] failed after %d attempts %s.", url, attempt+1, err.Error())
+			return errors.New(e)
+		}
+
+		g.L.Debugln(fmt.Sprintf("[postWebhook] post to [%s] error %s, retry %d/%d after %s.",
+			url, err.Error(), attempt+1, g.WebhookRetry, wait))
+		time.Sleep(wait)
+		wait *= 2
+	}
+} // }}}
+
+//postOnce发送一次请求，状态码不是2xx时返回error
+func postOnce(url string, body []byte) error { // {{{
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("status %s", resp.Status))
+	}
+	return nil
+} // }}}
@@ -0,0 +1,249 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Worker池，为未指定执行地址的任务选择执行的Worker。
+//Worker由GlobalConfigStruct.WorkerSelector从可用的Worker中选择；移出池的Worker进入排空状态，
+//不再分配新的任务，已分配的任务继续执行，全部结束后从池中清除。
+//健康检查失败或无法发送任务的Worker被标记为不可用，WorkerRecheck内不再分配任务。
+//池为空时任务按原有方式使用任务自身的执行地址。
+type workerPool struct { // {{{
+	lock     sync.Mutex
+	addrs    []string             //可分配任务的Worker地址
+	inflight map[string]int       //各Worker正在执行的任务数量
+	draining map[string]bool      //排空中的Worker
+	down     map[string]time.Time //不可用的Worker及被标记的时间
+	rr       *RoundRobinSelector  //未设置WorkerSelector时使用的轮询策略
+} // }}}
+
+//WorkerLoad是可分配任务的Worker及其负载，供WorkerSelector选择
+type WorkerLoad struct { // {{{
+	Addr     string //Worker地址
+	Inflight int    //正在执行的任务数量
+} // }}}
+
+//WorkerSelector是Worker的选择策略，从可用的Worker中为任务选择一个，返回其地址。
+//workers不为空，按池中Worker的顺序排列；Select在Worker池的锁内调用，不应阻塞。
+type WorkerSelector interface {
+	Select(task *Task, workers []WorkerLoad) string
+}
+
+//Worker的选择策略名称，见NewWorkerSelector
+const (
+	SelectRoundRobin  = "round_robin"  //按顺序轮流选择
+	SelectLeastLoaded = "least_loaded" //选择正在执行的任务最少的Worker
+)
+
+//RoundRobinSelector按顺序轮流选择Worker
+type RoundRobinSelector struct { // {{{
+	next int //下一次轮询的位置
+} // }}}
+
+func (rr *RoundRobinSelector) Select(task *Task, workers []WorkerLoad) string { // {{{
+	w := workers[rr.next%len(workers)]
+	rr.next = (rr.next + 1) % len(workers)
+	return w.Addr
+} // }}}
+
+//LeastLoadedSelector选择正在执行的任务最少的Worker，数量相同时选择靠前的Worker
+type LeastLoadedSelector struct{}
+
+func (ls LeastLoadedSelector) Select(task *Task, workers []WorkerLoad) string { // {{{
+	w := workers[0]
+	for _, l := range workers[1:] {
+		if l.Inflight < w.Inflight {
+			w = l
+		}
+	}
+	return w.Addr
+} // }}}
+
+//NewWorkerSelector按名称返回Worker的选择策略，名称见SelectRoundRobin、SelectLeastLoaded。
+func NewWorkerSelector(name string) (WorkerSelector, error) { // {{{
+	switch name {
+	case SelectRoundRobin:
+		return &RoundRobinSelector{}, nil
+	case SelectLeastLoaded:
+		return LeastLoadedSelector{}, nil
+	}
+	e := fmt.Sprintf("\n[NewWorkerSelector] unknown worker selector [%s].", name)
+	return nil, errors.New(e)
+} // }}}
+
+//创建空的Worker池
+func newWorkerPool() *workerPool { // {{{
+	return &workerPool{
+		addrs:    make([]string, 0),
+		inflight: make(map[string]int),
+		draining: make(map[string]bool),
+		down:     make(map[string]time.Time),
+		rr:       &RoundRobinSelector{},
+	}
+} // }}}
+
+//acquire为任务选择一个可用的Worker并增加其执行中的任务数量，池为空时返回空字符串。
+//池中的Worker全部不可用时返回error信息，任务按发送失败处理，不会等待Worker恢复。
+func (wp *workerPool) acquire(task *Task) (string, error) { // {{{
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+
+	if len(wp.addrs) == 0 {
+		return "", nil
+	}
+
+	loads := make([]WorkerLoad, 0, len(wp.addrs))
+	for _, addr := range wp.addrs {
+		if wp.isDown(addr) {
+			continue
+		}
+		loads = append(loads, WorkerLoad{Addr: addr, Inflight: wp.inflight[addr]})
+	}
+	if len(loads) == 0 {
+		e := fmt.Sprintf("\n[workerPool.acquire] all %d workers are down: %s", len(wp.addrs), strings.Join(wp.addrs, ", "))
+		return "", errors.New(e)
+	}
+
+	var sel WorkerSelector = wp.rr
+	if g.WorkerSelector != nil {
+		sel = g.WorkerSelector
+	}
+	addr := sel.Select(task, loads)
+	found := false
+	for _, l := range loads {
+		found = found || l.Addr == addr
+	}
+	if !found {
+		e := fmt.Sprintf("\n[workerPool.acquire] selector returns worker [%s] which is not available.", addr)
+		return "", errors.New(e)
+	}
+
+	wp.inflight[addr]++
+	return addr, nil
+} // }}}
+
+//isDown判断Worker是否被标记为不可用，超过WorkerRecheck的标记失效，调用方需持有锁
+func (wp *workerPool) isDown(addr string) bool { // {{{
+	at, ok := wp.down[addr]
+	if !ok {
+		return false
+	}
+	if time.Since(at) >= g.WorkerRecheck {
+		delete(wp.down, addr)
+		return false
+	}
+	return true
+} // }}}
+
+//setHealth记录Worker的检查结果，err不为nil时标记为不可用，为nil时清除标记
+func (wp *workerPool) setHealth(addr string, err error) { // {{{
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+
+	if err == nil {
+		delete(wp.down, addr)
+		return
+	}
+	if _, ok := wp.down[addr]; !ok {
+		g.L.Warningln("[workerPool] worker", addr, "is down, skip it for", g.WorkerRecheck, err.Error())
+	}
+	wp.down[addr] = time.Now()
+} // }}}
+
+//release在任务执行结束后减少Worker执行中的任务数量，排空中的Worker任务全部结束后被清除。
+func (wp *workerPool) release(addr string) { // {{{
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+
+	if wp.inflight[addr]--; wp.inflight[addr] > 0 {
+		return
+	}
+	delete(wp.inflight, addr)
+	if wp.draining[addr] {
+		delete(wp.draining, addr)
+		g.L.Infoln("[workerPool] worker", addr, "is drained")
+	}
+} // }}}
+
+//UpdateWorkers替换Worker池中的Worker列表，调度运行中即可生效。
+//新加入的Worker立即参与任务分配；被移除的Worker不再分配新的任务，
+//正在其上执行的任务不受影响，结束后该Worker被清除；重新加入排空中的Worker会恢复分配。
+//列表中的地址不能为空，重复的地址只保留一个；传入空列表时任务恢复使用自身的执行地址。
+func (sl *ScheduleManager) UpdateWorkers(addrs []string) error { // {{{
+	list := make([]string, 0, len(addrs))
+	seen := make(map[string]bool)
+	for _, addr := range addrs {
+		if addr == "" {
+			return errors.New("\n[sl.UpdateWorkers] worker address is empty.")
+		}
+		if !seen[addr] {
+			seen[addr] = true
+			list = append(list, addr)
+		}
+	}
+
+	wp := sl.workers
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+
+	for _, addr := range wp.addrs {
+		if !seen[addr] && wp.inflight[addr] > 0 {
+			wp.draining[addr] = true
+		}
+	}
+	for _, addr := range list {
+		delete(wp.draining, addr)
+	}
+	for addr := range wp.down {
+		if !seen[addr] {
+			delete(wp.down, addr)
+		}
+	}
+	wp.addrs = list
+
+	sl.Global.L.Infoln(fmt.Sprintf("[sl.UpdateWorkers] workers %v draining %v", list, wp.drainingList()))
+	return nil
+} // }}}
+
+//Workers返回可分配任务的Worker和排空中的Worker
+func (sl *ScheduleManager) Workers() (active []string, draining []string) { // {{{
+	wp := sl.workers
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+
+	active = make([]string, len(wp.addrs))
+	copy(active, wp.addrs)
+	return active, wp.drainingList()
+} // }}}
+
+//DownWorkers返回池中被标记为不可用的Worker，按地址排序
+func (sl *ScheduleManager) DownWorkers() []string { // {{{
+	wp := sl.workers
+	wp.lock.Lock()
+	defer wp.lock.Unlock()
+
+	list := make([]string, 0, len(wp.down))
+	for _, addr := range wp.addrs {
+		if wp.isDown(addr) {
+			list = append(list, addr)
+		}
+	}
+	sort.Strings(list)
+	return list
+} // }}}
+
+//drainingList返回排序后的排空中Worker列表，调用方需持有锁
+func (wp *workerPool) drainingList() []string { // {{{
+	list := make([]string, 0, len(wp.draining))
+	for addr := range wp.draining {
+		list = append(list, addr)
+	}
+	sort.Strings(list)
+	return list
+} // }}}
@@ -51,6 +51,8 @@ CREATE TABLE `scd_job` (
   `job_id` bigint(20) NOT NULL COMMENT '调度id',
   `job_name` varchar(256) NOT NULL COMMENT '作业名称',
   `job_desc` varchar(500) DEFAULT NULL COMMENT '作业说明',
+  `job_parallel_group` int(11) DEFAULT 0 COMMENT '并行组，同一调度中连续且并行组相同的作业同时执行，0表示不分组',
+  `job_timeout` int(11) DEFAULT 0 COMMENT '作业的最大执行时间，单位秒，超过后中止作业中尚未结束的任务，0表示不限制',
   `prev_job_id` bigint(20) NOT NULL COMMENT '上级作业id',
   `next_job_id` bigint(20) NOT NULL COMMENT '下级作业id',
   `create_user_id` varchar(30) DEFAULT '' COMMENT '创建人',
@@ -67,7 +69,7 @@ CREATE TABLE `scd_job` (
 
 LOCK TABLES `scd_job` WRITE;
 /*!40000 ALTER TABLE `scd_job` DISABLE KEYS */;
-INSERT INTO `scd_job` VALUES (1,'作业1','0',0,2,'',NULL,NULL,NULL),(2,'作业2','0',1,3,'',NULL,NULL,NULL),(3,'作业3','0',2,9,'',NULL,NULL,NULL),(4,'作业4','0',0,5,'',NULL,NULL,NULL),(5,'作业5','0',5,6,'',NULL,NULL,NULL),(6,'作业6','0',6,7,'',NULL,NULL,NULL),(7,'作业7','0',7,8,'',NULL,NULL,NULL),(8,'作业8','0',8,0,'',NULL,NULL,NULL),(9,'作业9','0',3,10,'',NULL,NULL,NULL),(10,'作业10','0',9,0,'',NULL,NULL,NULL);
+INSERT INTO `scd_job` VALUES (1,'作业1','0',0,0,0,2,'',NULL,NULL,NULL),(2,'作业2','0',0,0,1,3,'',NULL,NULL,NULL),(3,'作业3','0',0,0,2,9,'',NULL,NULL,NULL),(4,'作业4','0',0,0,0,5,'',NULL,NULL,NULL),(5,'作业5','0',0,0,5,6,'',NULL,NULL,NULL),(6,'作业6','0',0,0,6,7,'',NULL,NULL,NULL),(7,'作业7','0',0,0,7,8,'',NULL,NULL,NULL),(8,'作业8','0',0,0,8,0,'',NULL,NULL,NULL),(9,'作业9','0',0,0,3,10,'',NULL,NULL,NULL),(10,'作业10','0',0,0,9,0,'',NULL,NULL,NULL);
 /*!40000 ALTER TABLE `scd_job` ENABLE KEYS */;
 UNLOCK TABLES;
 
@@ -86,7 +88,7 @@ CREATE TABLE `scd_job_log` (
   `end_time` datetime NOT NULL ON UPDATE CURRENT_TIMESTAMP COMMENT '结束时间',
   `state` varchar(1) DEFAULT NULL COMMENT '状态 0.不满足条件未执行 1. 执行中 2. 暂停 3. 完成 4.意外中止',
   `result` decimal(10,2) DEFAULT NULL COMMENT '结果,作业中执行成功任务的百分比',
-  `batch_type` varchar(1) NOT NULL COMMENT '执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行',
+  `batch_type` varchar(1) NOT NULL COMMENT '执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行 4.补数执行',
   PRIMARY KEY (`batch_job_id`,`job_id`,`start_time`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='作业执行信息表：\n           日志部分，记录作业执行情况。';
 /*!40101 SET character_set_client = @saved_cs_client */;
@@ -129,6 +131,136 @@ INSERT INTO `scd_job_task` VALUES (1,1,1,1,'1','2014-05-28'),(2,1,2,2,'1','2014-
 /*!40000 ALTER TABLE `scd_job_task` ENABLE KEYS */;
 UNLOCK TABLES;
 
+--
+-- Table structure for table `scd_next_start`
+--
+
+DROP TABLE IF EXISTS `scd_next_start`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `scd_next_start` (
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `next_start` datetime NOT NULL COMMENT '下次启动时间',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度的下次启动时间';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `scd_next_start`
+--
+
+LOCK TABLES `scd_next_start` WRITE;
+/*!40000 ALTER TABLE `scd_next_start` DISABLE KEYS */;
+/*!40000 ALTER TABLE `scd_next_start` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `scd_remain`
+--
+
+DROP TABLE IF EXISTS `scd_remain`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `scd_remain` (
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `scd_num` int(11) NOT NULL COMMENT '计算剩余次数时调度的执行次数，与调度当前的执行次数不同时重新计算',
+  `remain` int(11) NOT NULL COMMENT '剩余的执行次数',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度剩余的执行次数';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `scd_remain`
+--
+
+LOCK TABLES `scd_remain` WRITE;
+/*!40000 ALTER TABLE `scd_remain` DISABLE KEYS */;
+/*!40000 ALTER TABLE `scd_remain` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `scd_leader`
+--
+
+DROP TABLE IF EXISTS `scd_leader`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `scd_leader` (
+  `name` varchar(64) NOT NULL COMMENT '租约名称',
+  `owner` varchar(128) NOT NULL COMMENT '持有租约的主节点，主机名:进程号',
+  `lease_until` bigint(20) NOT NULL COMMENT '租约的到期时间，Unix时间戳(毫秒)',
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='多实例部署时主节点的租约';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `scd_leader`
+--
+
+LOCK TABLES `scd_leader` WRITE;
+/*!40000 ALTER TABLE `scd_leader` DISABLE KEYS */;
+/*!40000 ALTER TABLE `scd_leader` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `scd_run_lock`
+--
+
+DROP TABLE IF EXISTS `scd_run_lock`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `scd_run_lock` (
+  `run_key` varchar(128) NOT NULL COMMENT '幂等键，规则scheduleId@启动时间(UTC)',
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `run_window` timestamp NOT NULL COMMENT '启动时间',
+  `owner` varchar(128) NOT NULL COMMENT '抢占到启动的进程，主机名:进程号',
+  `create_time` timestamp NOT NULL COMMENT '抢占时间',
+  PRIMARY KEY (`run_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='多实例部署时调度启动的抢占记录';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `scd_run_lock`
+--
+
+LOCK TABLES `scd_run_lock` WRITE;
+/*!40000 ALTER TABLE `scd_run_lock` DISABLE KEYS */;
+/*!40000 ALTER TABLE `scd_run_lock` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `scd_run_journal`
+--
+
+DROP TABLE IF EXISTS `scd_run_journal`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `scd_run_journal` (
+  `batch_id` varchar(128) NOT NULL COMMENT '批次ID，规则scheduleId + 周期开始时间(不含周期内启动时间)',
+  `seq` bigint(20) NOT NULL COMMENT '序号，按发生先后递增',
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `event_time` timestamp NOT NULL COMMENT '发生时间',
+  `event_type` varchar(32) NOT NULL COMMENT '状态变化类型 run_start task_start task_retry task_done run_warn run_end run_fail',
+  `task_id` bigint(20) DEFAULT 0 COMMENT '任务id，调度的状态变化为0',
+  `task_name` varchar(128) DEFAULT NULL COMMENT '任务名称',
+  `attempt_no` int(11) DEFAULT 0 COMMENT '任务的执行次数',
+  `state` varchar(1) DEFAULT NULL COMMENT '调度或任务变化后的状态',
+  `message` text COMMENT '附加信息',
+  PRIMARY KEY (`batch_id`,`seq`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度执行日志：\n           日志部分，按发生先后记录调度执行过程中的每一次状态变化。';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `scd_run_journal`
+--
+
+LOCK TABLES `scd_run_journal` WRITE;
+/*!40000 ALTER TABLE `scd_run_journal` DISABLE KEYS */;
+/*!40000 ALTER TABLE `scd_run_journal` ENABLE KEYS */;
+UNLOCK TABLES;
+
 --
 -- Table structure for table `scd_schedule`
 --
@@ -139,10 +271,23 @@ DROP TABLE IF EXISTS `scd_schedule`;
 CREATE TABLE `scd_schedule` (
   `scd_id` bigint(20) NOT NULL COMMENT '调度id',
   `scd_name` varchar(256) NOT NULL COMMENT '调度名称',
+  `scd_group` varchar(64) DEFAULT '' COMMENT '调度分组',
+  `scd_status` int(11) DEFAULT 0 COMMENT '调度状态 0.正常 1.暂停',
+  `scd_enabled` tinyint(1) NOT NULL DEFAULT 1 COMMENT '是否启用 1.启用 0.禁用，禁用的调度不自动启动，只能手动执行',
   `scd_num` int(11) NOT NULL COMMENT '调度次数 0.不限次数 ',
-  `scd_cyc` varchar(2) NOT NULL COMMENT '调度周期 ss 秒 mi 分钟 h 小时 d 日 m 月 w 周 q 季度 y 年',
+  `scd_cyc` varchar(64) NOT NULL COMMENT '调度周期 ss 秒 mi 分钟 h 小时 d 日 m 月 w 周 q 季度 y 年 cron:<表达式> 按cron表达式 interval:<间隔> 按固定间隔如interval:90s',
   `scd_timeout` bigint(20) DEFAULT NULL COMMENT '最大执行时间，单位 秒',
+  `scd_soft_timeout` bigint(20) DEFAULT 0 COMMENT '预警执行时间，单位 秒，超过后发出预警',
+  `scd_overlap` varchar(8) DEFAULT 'skip' COMMENT '上一批次未结束时的处理策略 skip.跳过 queue.排队 allow.允许重叠',
+  `scd_misfire` varchar(8) DEFAULT 'skip' COMMENT '重启后错过启动时间的处理策略 skip.等待下一周期 run.立即执行 catchup.补齐错过的启动',
+  `scd_timezone` varchar(64) DEFAULT '' COMMENT '启动时间所在的时区，IANA名称如Asia/Shanghai，为空时使用服务器的时区',
+  `scd_start_jitter` bigint(20) DEFAULT 0 COMMENT '启动时间后随机推迟的最长时间，单位 秒，0表示不推迟',
+  `scd_retry_count` int(11) DEFAULT 0 COMMENT '批次失败后重新执行整个调度链的次数，0表示不重试',
+  `scd_retry_delay` bigint(20) DEFAULT 0 COMMENT '重新执行整个调度链前的等待时间，单位 秒',
+  `scd_on_startup` varchar(16) DEFAULT 'wait' COMMENT '进程启动时的处理策略 wait.按周期等待 run-if-missed.错过启动时立即执行 always-run.总是立即执行',
+  `scd_priority` int(11) DEFAULT 0 COMMENT '优先级，等待并发位置时数值大的优先',
   `scd_job_id` bigint(20) DEFAULT NULL COMMENT '作业id',
+  `scd_warmup_task_id` bigint(20) DEFAULT 0 COMMENT '预热任务id，调度启动监听前执行一次',
   `scd_desc` varchar(500) DEFAULT NULL COMMENT '调度说明',
   `create_user_id` varchar(30) NOT NULL COMMENT '创建人',
   `create_time` date NOT NULL COMMENT '创建时间',
@@ -158,7 +303,7 @@ CREATE TABLE `scd_schedule` (
 
 LOCK TABLES `scd_schedule` WRITE;
 /*!40000 ALTER TABLE `scd_schedule` DISABLE KEYS */;
-INSERT INTO `scd_schedule` VALUES (1,'数据仓库调度',0,'mi',0,1,'数据仓库日常调度','1','2014-05-28','1','2014-05-28'),(2,'数据市场调度',0,'h',0,4,'数据市场日常调度','1','2014-05-28','1','2014-05-28');
+INSERT INTO `scd_schedule` VALUES (1,'数据仓库调度','',0,1,0,'mi',0,0,'skip','skip','',0,0,0,'wait',0,1,0,'数据仓库日常调度','1','2014-05-28','1','2014-05-28'),(2,'数据市场调度','',0,1,0,'h',0,0,'skip','skip','',0,0,0,'wait',0,4,0,'数据市场日常调度','1','2014-05-28','1','2014-05-28');
 /*!40000 ALTER TABLE `scd_schedule` ENABLE KEYS */;
 UNLOCK TABLES;
 
@@ -176,7 +321,10 @@ CREATE TABLE `scd_schedule_log` (
   `end_time` datetime NOT NULL COMMENT '结束时间',
   `state` varchar(1) DEFAULT NULL COMMENT '状态 0.不满足条件未执行 1. 执行中 2. 暂停 3. 完成 4.失败',
   `result` decimal(10,2) DEFAULT NULL COMMENT '结果,调度中执行成功任务的百分比',
-  `batch_type` varchar(1) NOT NULL COMMENT '执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行',
+  `batch_type` varchar(1) NOT NULL COMMENT '执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行 4.补数执行',
+  `error_msg` text COMMENT '批次失败的原因，超过task_output_limit时截断',
+  `retry_no` int(11) DEFAULT 0 COMMENT '调度级重试的序号，首次执行为0',
+  `prev_batch_id` varchar(128) DEFAULT NULL COMMENT '重试前一次执行的批次ID，首次执行为空',
   PRIMARY KEY (`batch_id`,`scd_id`,`start_time`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='用户调度权限表：\n           日志部分，记录调度执行情况。';
 /*!40101 SET character_set_client = @saved_cs_client */;
@@ -187,10 +335,154 @@ CREATE TABLE `scd_schedule_log` (
 
 LOCK TABLES `scd_schedule_log` WRITE;
 /*!40000 ALTER TABLE `scd_schedule_log` DISABLE KEYS */;
-INSERT INTO `scd_schedule_log` VALUES ('2014-06-16 09:48:00.047067 1',1,'2014-06-16 01:48:00','2014-06-16 01:48:50','3',1.00,'1'),('2014-06-16 09:49:00.039637 1',1,'2014-06-16 01:49:00','0000-00-00 00:00:00','1',0.00,'1'),('2014-06-16 09:50:00.043007 1',1,'2014-06-16 01:50:00','2014-06-16 01:50:50','3',1.00,'1'),('2014-06-16 09:51:00.041106 1',1,'2014-06-16 01:51:00','2014-06-16 01:51:50','3',1.00,'1');
+INSERT INTO `scd_schedule_log` VALUES ('2014-06-16 09:48:00.047067 1',1,'2014-06-16 01:48:00','2014-06-16 01:48:50','3',1.00,'1',NULL,0,NULL),('2014-06-16 09:49:00.039637 1',1,'2014-06-16 01:49:00','0000-00-00 00:00:00','1',0.00,'1',NULL,0,NULL),('2014-06-16 09:50:00.043007 1',1,'2014-06-16 01:50:00','2014-06-16 01:50:50','3',1.00,'1',NULL,0,NULL),('2014-06-16 09:51:00.041106 1',1,'2014-06-16 01:51:00','2014-06-16 01:51:50','3',1.00,'1',NULL,0,NULL);
 /*!40000 ALTER TABLE `scd_schedule_log` ENABLE KEYS */;
 UNLOCK TABLES;
 
+--
+-- Table structure for table `scd_schedule_rel`
+--
+
+DROP TABLE IF EXISTS `scd_schedule_rel`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `scd_schedule_rel` (
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `rel_scd_id` bigint(20) NOT NULL COMMENT '依赖的上游调度id',
+  `create_user_id` bigint(20) NOT NULL COMMENT '创建人',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`,`rel_scd_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度间的依赖关系';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `scd_schedule_rel`
+--
+
+LOCK TABLES `scd_schedule_rel` WRITE;
+/*!40000 ALTER TABLE `scd_schedule_rel` DISABLE KEYS */;
+/*!40000 ALTER TABLE `scd_schedule_rel` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `scd_schedule_trigger`
+--
+
+DROP TABLE IF EXISTS `scd_schedule_trigger`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `scd_schedule_trigger` (
+  `scd_id` bigint(20) NOT NULL COMMENT '上游调度id',
+  `trigger_scd_id` bigint(20) NOT NULL COMMENT '批次结束后触发执行的下游调度id',
+  `on_failure` tinyint(1) NOT NULL DEFAULT 0 COMMENT '上游批次执行失败时是否也触发 1.触发 0.不触发',
+  `create_user_id` bigint(20) NOT NULL COMMENT '创建人',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`,`trigger_scd_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度间的触发关系';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `scd_schedule_source`
+--
+
+DROP TABLE IF EXISTS `scd_schedule_source`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `scd_schedule_source` (
+  `scd_name` varchar(128) NOT NULL COMMENT '调度名称',
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `source_file` varchar(500) NOT NULL COMMENT '调度定义文件',
+  `content_hash` varchar(64) NOT NULL COMMENT '文件内容摘要',
+  `modify_time` datetime DEFAULT NULL COMMENT '同步时间',
+  PRIMARY KEY (`scd_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度定义文件同步表：\n           调度部分，记录由定义文件同步的调度信息。';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `scd_schedule_source`
+--
+
+LOCK TABLES `scd_schedule_source` WRITE;
+/*!40000 ALTER TABLE `scd_schedule_source` DISABLE KEYS */;
+/*!40000 ALTER TABLE `scd_schedule_source` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `scd_schedule_env`
+--
+
+DROP TABLE IF EXISTS `scd_schedule_env`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `scd_schedule_env` (
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `env_name` varchar(128) NOT NULL COMMENT '运行参数名称',
+  `env_value` text COMMENT '运行参数值，可包含模板',
+  `create_user_id` bigint(20) NOT NULL COMMENT '创建人',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`,`env_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度的运行参数，任务执行时与任务的运行参数合并后发送给Worker';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `scd_schedule_env`
+--
+
+LOCK TABLES `scd_schedule_env` WRITE;
+/*!40000 ALTER TABLE `scd_schedule_env` DISABLE KEYS */;
+/*!40000 ALTER TABLE `scd_schedule_env` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `scd_schedule_tag`
+--
+
+DROP TABLE IF EXISTS `scd_schedule_tag`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `scd_schedule_tag` (
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `tag` varchar(64) NOT NULL COMMENT '调度标签',
+  `create_user_id` bigint(20) NOT NULL COMMENT '创建人',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`,`tag`),
+  KEY `idx_scd_schedule_tag_tag` (`tag`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度标签';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `scd_schedule_tag`
+--
+
+LOCK TABLES `scd_schedule_tag` WRITE;
+/*!40000 ALTER TABLE `scd_schedule_tag` DISABLE KEYS */;
+/*!40000 ALTER TABLE `scd_schedule_tag` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `scd_snooze`
+--
+
+DROP TABLE IF EXISTS `scd_snooze`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `scd_snooze` (
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `snooze_until` datetime NOT NULL COMMENT '暂缓至该时间后再启动',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度暂缓执行信息';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `scd_snooze`
+--
+
+LOCK TABLES `scd_snooze` WRITE;
+/*!40000 ALTER TABLE `scd_snooze` DISABLE KEYS */;
+/*!40000 ALTER TABLE `scd_snooze` ENABLE KEYS */;
+UNLOCK TABLES;
+
 --
 -- Table structure for table `scd_start`
 --
@@ -201,6 +493,7 @@ DROP TABLE IF EXISTS `scd_start`;
 CREATE TABLE `scd_start` (
   `scd_id` bigint(20) NOT NULL COMMENT '调度id',
   `scd_start` bigint(20) NOT NULL COMMENT '周期内启动时间单位秒',
+  `scd_start_month` int(11) NOT NULL DEFAULT 0 COMMENT '启动月份，周期内的月份偏移',
   `create_user_id` bigint(20) NOT NULL COMMENT '创建人',
   `create_time` date NOT NULL COMMENT '创建时间'
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='异常处理设置信息：\n           调度部分，记录异常的处理信息。';
@@ -212,10 +505,35 @@ CREATE TABLE `scd_start` (
 
 LOCK TABLES `scd_start` WRITE;
 /*!40000 ALTER TABLE `scd_start` DISABLE KEYS */;
-INSERT INTO `scd_start` VALUES (2,60,1,'2014-06-12'),(2,2500,1,'2014-06-12'),(2,2700,1,'2014-06-12'),(2,2400,1,'2014-06-12'),(2,600,1,'2014-06-12');
+INSERT INTO `scd_start` VALUES (2,60,0,1,'2014-06-12'),(2,2500,0,1,'2014-06-12'),(2,2700,0,1,'2014-06-12'),(2,2400,0,1,'2014-06-12'),(2,600,0,1,'2014-06-12');
 /*!40000 ALTER TABLE `scd_start` ENABLE KEYS */;
 UNLOCK TABLES;
 
+--
+-- Table structure for table `scd_start_weekday`
+--
+
+DROP TABLE IF EXISTS `scd_start_weekday`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `scd_start_weekday` (
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `scd_weekday` tinyint(4) NOT NULL COMMENT '按周调度时的启动星期，0为星期日',
+  `create_user_id` bigint(20) NOT NULL COMMENT '创建人',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`,`scd_weekday`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度启动星期';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `scd_start_weekday`
+--
+
+LOCK TABLES `scd_start_weekday` WRITE;
+/*!40000 ALTER TABLE `scd_start_weekday` DISABLE KEYS */;
+/*!40000 ALTER TABLE `scd_start_weekday` ENABLE KEYS */;
+UNLOCK TABLES;
+
 --
 -- Table structure for table `scd_task`
 --
@@ -229,6 +547,12 @@ CREATE TABLE `scd_task` (
   `task_name` varchar(256) NOT NULL COMMENT '任务名称',
   `task_cyc` varchar(2) DEFAULT '' COMMENT '调度周期 ss 秒 mi 分钟 h 小时 d 日 m 月 w 周 q 季度 y 年',
   `task_time_out` bigint(20) DEFAULT '0' COMMENT '超时时间',
+  `task_retry_count` int(11) DEFAULT 0 COMMENT '失败后的重试次数',
+  `task_retry_interval` bigint(20) DEFAULT 0 COMMENT '重试前的等待时间，单位 秒',
+  `task_wave` int(11) DEFAULT 0 COMMENT '执行阶段，前一阶段的任务全部结束后才开始执行',
+  `task_resource_pool` varchar(64) DEFAULT '' COMMENT '任务使用的资源池，限制使用同一资源的任务同时执行的数量，为空时不限制',
+  `task_executor_type` varchar(64) DEFAULT '' COMMENT '在调度进程内执行任务的执行者名称，为空时发送给Worker执行',
+  `task_disabled` tinyint(1) DEFAULT 0 COMMENT '任务是否禁用，禁用的任务不执行，记录为跳过',
   `task_start` bigint(20) DEFAULT NULL COMMENT '周期内启动时间，格式 mm-dd hh24:mi:ss，最大单位小于调度周期',
   `task_type_id` bigint(20) DEFAULT NULL COMMENT '任务类型ID',
   `task_cmd` varchar(500) NOT NULL COMMENT '任务命令行',
@@ -247,10 +571,93 @@ CREATE TABLE `scd_task` (
 
 LOCK TABLES `scd_task` WRITE;
 /*!40000 ALTER TABLE `scd_task` DISABLE KEYS */;
-INSERT INTO `scd_task` VALUES (1,'127.0.0.1','任务1','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py',NULL,'1','2014-05-28',NULL,NULL),(2,'127.0.0.1','ping2','h',60,2950,1,'ping',NULL,'1','2014-05-28',NULL,NULL),(3,'127.0.0.1','任务3','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py',NULL,'1','2014-05-28',NULL,NULL),(4,'127.0.0.1','任务4','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py',NULL,'1','2014-05-28',NULL,NULL),(5,'127.0.0.1','任务5','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py',NULL,'1','2014-05-28',NULL,NULL),(6,'127.0.0.1','任务6','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(7,'127.0.0.1','任务7','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(8,'127.0.0.1','任务8','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(9,'127.0.0.1','任务9','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(10,'127.0.0.1','任务10','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(11,'127.0.0.1','任务11','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(12,'127.0.0.1','任务12','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(13,'127.0.0.1','任务13','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(14,'127.0.0.1','任务14','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(15,'127.0.0.1','任务15','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(16,'127.0.0.1','任务16','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(17,'127.0.0.1','任务17','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(18,'127.0.0.1','任务18','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(19,'127.0.0.1','任务19','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(20,'127.0.0.1','任务20','',60,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL);
+INSERT INTO `scd_task` VALUES (1,'127.0.0.1','任务1','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py',NULL,'1','2014-05-28',NULL,NULL),(2,'127.0.0.1','ping2','h',60,0,0,0,'','',0,2950,1,'ping',NULL,'1','2014-05-28',NULL,NULL),(3,'127.0.0.1','任务3','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py',NULL,'1','2014-05-28',NULL,NULL),(4,'127.0.0.1','任务4','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py',NULL,'1','2014-05-28',NULL,NULL),(5,'127.0.0.1','任务5','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py',NULL,'1','2014-05-28',NULL,NULL),(6,'127.0.0.1','任务6','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(7,'127.0.0.1','任务7','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(8,'127.0.0.1','任务8','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(9,'127.0.0.1','任务9','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(10,'127.0.0.1','任务10','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(11,'127.0.0.1','任务11','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(12,'127.0.0.1','任务12','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(13,'127.0.0.1','任务13','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(14,'127.0.0.1','任务14','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(15,'127.0.0.1','任务15','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(16,'127.0.0.1','任务16','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(17,'127.0.0.1','任务17','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(18,'127.0.0.1','任务18','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(19,'127.0.0.1','任务19','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL),(20,'127.0.0.1','任务20','',60,0,0,0,'','',0,0,1,'/Users/rp/develop/code/py/testSchedule.py\n',NULL,'1','2014-05-28',NULL,NULL);
 /*!40000 ALTER TABLE `scd_task` ENABLE KEYS */;
 UNLOCK TABLES;
 
+--
+-- Table structure for table `scd_task_artifact`
+--
+
+DROP TABLE IF EXISTS `scd_task_artifact`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `scd_task_artifact` (
+  `batch_task_id` varchar(128) NOT NULL COMMENT '任务批次id',
+  `batch_id` varchar(128) NOT NULL COMMENT '批次id',
+  `task_id` bigint(20) NOT NULL COMMENT '任务id',
+  `artifact_name` varchar(128) NOT NULL COMMENT '产出物名称',
+  `artifact_uri` varchar(1024) NOT NULL COMMENT '产出物的外部存储地址',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`batch_task_id`,`artifact_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='任务产出物的引用信息';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `scd_task_artifact`
+--
+
+LOCK TABLES `scd_task_artifact` WRITE;
+/*!40000 ALTER TABLE `scd_task_artifact` DISABLE KEYS */;
+/*!40000 ALTER TABLE `scd_task_artifact` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `scd_task_attempt_log`
+--
+
+DROP TABLE IF EXISTS `scd_task_attempt_log`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `scd_task_attempt_log` (
+  `batch_task_id` varchar(128) NOT NULL COMMENT '任务批次id，规则作业批次id+任务id',
+  `batch_id` varchar(128) NOT NULL COMMENT '批次ID，规则scheduleId + 周期开始时间(不含周期内启动时间)',
+  `task_id` bigint(20) NOT NULL COMMENT '任务id',
+  `attempt_no` int(11) NOT NULL COMMENT '执行次数，从1开始',
+  `worker_addr` varchar(128) DEFAULT NULL COMMENT '执行任务的worker地址',
+  `start_time` datetime NOT NULL COMMENT '开始时间',
+  `end_time` datetime NOT NULL COMMENT '结束时间',
+  `state` varchar(1) DEFAULT NULL COMMENT '状态 3. 完成 4.意外中止 5.忽略',
+  `output` text COMMENT '任务输出',
+  PRIMARY KEY (`batch_task_id`,`attempt_no`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='任务执行尝试记录表：\n           日志部分，记录任务每一次执行的情况。';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `scd_task_attempt_log`
+--
+
+LOCK TABLES `scd_task_attempt_log` WRITE;
+/*!40000 ALTER TABLE `scd_task_attempt_log` DISABLE KEYS */;
+/*!40000 ALTER TABLE `scd_task_attempt_log` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `scd_task_env`
+--
+
+DROP TABLE IF EXISTS `scd_task_env`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `scd_task_env` (
+  `task_id` bigint(20) NOT NULL COMMENT '任务id',
+  `env_name` varchar(128) NOT NULL COMMENT '运行参数名称',
+  `env_value` text COMMENT '运行参数值，可包含模板',
+  `create_user_id` bigint(20) NOT NULL COMMENT '创建人',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`task_id`,`env_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='任务的运行参数，与调度的同名参数同时存在时以任务的为准';
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `scd_task_env`
+--
+
+LOCK TABLES `scd_task_env` WRITE;
+/*!40000 ALTER TABLE `scd_task_env` DISABLE KEYS */;
+/*!40000 ALTER TABLE `scd_task_env` ENABLE KEYS */;
+UNLOCK TABLES;
+
 --
 -- Table structure for table `scd_task_attr`
 --
@@ -321,8 +728,14 @@ CREATE TABLE `scd_task_log` (
   `task_id` bigint(20) NOT NULL COMMENT '任务id',
   `start_time` datetime NOT NULL ON UPDATE CURRENT_TIMESTAMP COMMENT '开始时间',
   `end_time` datetime NOT NULL ON UPDATE CURRENT_TIMESTAMP COMMENT '结束时间',
-  `state` varchar(1) DEFAULT NULL COMMENT '状态 0.初始状态 1. 执行中 2. 暂停 3. 完成 4.忽略 5.意外中止',
-  `batch_type` varchar(1) NOT NULL COMMENT '执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行',
+  `state` varchar(1) DEFAULT NULL COMMENT '状态 0.初始状态 1. 执行中 2. 暂停 3. 完成 4.意外中止 5.忽略 6.跳过',
+  `batch_type` varchar(1) NOT NULL COMMENT '执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行 4.补数执行',
+  `exit_code` int(11) DEFAULT -1 COMMENT '任务命令的退出码，-1表示未取得',
+  `output` text COMMENT '任务输出，超过task_output_limit时截断',
+  `dispatch_retry` int(11) DEFAULT 0 COMMENT '发送任务遇到暂时性错误后的重试次数',
+  `dispatch_backoff` bigint(20) DEFAULT 0 COMMENT '发送重试累计等待的时间，单位 毫秒',
+  `timed_out` tinyint(1) DEFAULT 0 COMMENT '任务是否因调度执行超过超时时间被中止',
+  `timeout_level` varchar(16) DEFAULT '' COMMENT '中止任务的超时级别 schedule.调度超时 job.作业超时',
   PRIMARY KEY (`batch_task_id`,`task_id`,`start_time`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='任务执行信息表：\n           日志部分，记录任务执行情况。';
 /*!40101 SET character_set_client = @saved_cs_client */;
@@ -333,7 +746,7 @@ CREATE TABLE `scd_task_log` (
 
 LOCK TABLES `scd_task_log` WRITE;
 /*!40000 ALTER TABLE `scd_task_log` DISABLE KEYS */;
-INSERT INTO `scd_task_log` VALUES ('2014-06-16 09:48:00.047067 1.1.1','2014-06-16 09:48:00.047067 1.1','2014-06-16 09:48:00.047067 1',1,'2014-06-16 01:48:00','2014-06-16 01:48:10','3','1'),('2014-06-16 09:48:00.047067 1.1.2','2014-06-16 09:48:00.047067 1.1','2014-06-16 09:48:00.047067 1',2,'2014-06-16 01:48:00','2014-06-16 01:48:00','4','1'),('2014-06-16 09:48:00.047067 1.10.20','2014-06-16 09:48:00.047067 1.10','2014-06-16 09:48:00.047067 1',20,'2014-06-16 01:48:40','2014-06-16 01:48:50','3','1'),('2014-06-16 09:48:00.047067 1.2.3','2014-06-16 09:48:00.047067 1.2','2014-06-16 09:48:00.047067 1',3,'2014-06-16 01:48:10','2014-06-16 01:48:20','3','1'),('2014-06-16 09:48:00.047067 1.2.4','2014-06-16 09:48:00.047067 1.2','2014-06-16 09:48:00.047067 1',4,'2014-06-16 01:48:10','2014-06-16 01:48:20','3','1'),('2014-06-16 09:48:00.047067 1.2.5','2014-06-16 09:48:00.047067 1.2','2014-06-16 09:48:00.047067 1',5,'2014-06-16 01:48:00','2014-06-16 01:48:10','3','1'),('2014-06-16 09:48:00.047067 1.3.6','2014-06-16 09:48:00.047067 1.3','2014-06-16 09:48:00.047067 1',6,'2014-06-16 01:48:20','2014-06-16 01:48:30','3','1'),('2014-06-16 09:48:00.047067 1.9.7','2014-06-16 09:48:00.047067 1.9','2014-06-16 09:48:00.047067 1',7,'2014-06-16 01:48:30','2014-06-16 01:48:40','3','1'),('2014-06-16 09:48:00.047067 1.9.8','2014-06-16 09:48:00.047067 1.9','2014-06-16 09:48:00.047067 1',8,'2014-06-16 01:48:30','2014-06-16 01:48:40','3','1'),('2014-06-16 09:49:00.039637 1.1.1','2014-06-16 09:49:00.039637 1.1','2014-06-16 09:49:00.039637 1',1,'2014-06-16 01:49:00','2014-06-16 01:49:10','3','1'),('2014-06-16 09:49:00.039637 1.1.2','2014-06-16 09:49:00.039637 1.1','2014-06-16 09:49:00.039637 1',2,'2014-06-16 01:49:00','2014-06-16 01:49:05','3','1'),('2014-06-16 09:49:00.039637 1.10.20','2014-06-16 09:49:00.039637 1.10','2014-06-16 09:49:00.039637 1',20,'0000-00-00 00:00:00','0000-00-00 00:00:00','0','1'),('2014-06-16 09:49:00.039637 1.2.3','2014-06-16 09:49:00.039637 1.2','2014-06-16 09:49:00.039637 1',3,'2014-06-16 01:49:10','0000-00-00 00:00:00','1','1'),('2014-06-16 09:49:00.039637 1.2.4','2014-06-16 09:49:00.039637 1.2','2014-06-16 09:49:00.039637 1',4,'2014-06-16 01:49:10','0000-00-00 00:00:00','1','1'),('2014-06-16 09:49:00.039637 1.2.5','2014-06-16 09:49:00.039637 1.2','2014-06-16 09:49:00.039637 1',5,'2014-06-16 01:49:00','2014-06-16 01:49:10','3','1'),('2014-06-16 09:49:00.039637 1.3.6','2014-06-16 09:49:00.039637 1.3','2014-06-16 09:49:00.039637 1',6,'0000-00-00 00:00:00','0000-00-00 00:00:00','0','1'),('2014-06-16 09:49:00.039637 1.9.7','2014-06-16 09:49:00.039637 1.9','2014-06-16 09:49:00.039637 1',7,'0000-00-00 00:00:00','0000-00-00 00:00:00','0','1'),('2014-06-16 09:49:00.039637 1.9.8','2014-06-16 09:49:00.039637 1.9','2014-06-16 09:49:00.039637 1',8,'0000-00-00 00:00:00','0000-00-00 00:00:00','0','1'),('2014-06-16 09:50:00.043007 1.1.1','2014-06-16 09:50:00.043007 1.1','2014-06-16 09:50:00.043007 1',1,'2014-06-16 01:50:00','2014-06-16 01:50:10','3','1'),('2014-06-16 09:50:00.043007 1.1.2','2014-06-16 09:50:00.043007 1.1','2014-06-16 09:50:00.043007 1',2,'2014-06-16 01:50:00','2014-06-16 01:50:00','4','1'),('2014-06-16 09:50:00.043007 1.10.20','2014-06-16 09:50:00.043007 1.10','2014-06-16 09:50:00.043007 1',20,'2014-06-16 01:50:40','2014-06-16 01:50:50','3','1'),('2014-06-16 09:50:00.043007 1.2.3','2014-06-16 09:50:00.043007 1.2','2014-06-16 09:50:00.043007 1',3,'2014-06-16 01:50:10','2014-06-16 01:50:20','3','1'),('2014-06-16 09:50:00.043007 1.2.4','2014-06-16 09:50:00.043007 1.2','2014-06-16 09:50:00.043007 1',4,'2014-06-16 01:50:10','2014-06-16 01:50:20','3','1'),('2014-06-16 09:50:00.043007 1.2.5','2014-06-16 09:50:00.043007 1.2','2014-06-16 09:50:00.043007 1',5,'2014-06-16 01:50:00','2014-06-16 01:50:10','3','1'),('2014-06-16 09:50:00.043007 1.3.6','2014-06-16 09:50:00.043007 1.3','2014-06-16 09:50:00.043007 1',6,'2014-06-16 01:50:20','2014-06-16 01:50:30','3','1'),('2014-06-16 09:50:00.043007 1.9.7','2014-06-16 09:50:00.043007 1.9','2014-06-16 09:50:00.043007 1',7,'2014-06-16 01:50:30','2014-06-16 01:50:40','3','1'),('2014-06-16 09:50:00.043007 1.9.8','2014-06-16 09:50:00.043007 1.9','2014-06-16 09:50:00.043007 1',8,'2014-06-16 01:50:30','2014-06-16 01:50:40','3','1'),('2014-06-16 09:51:00.041106 1.1.1','2014-06-16 09:51:00.041106 1.1','2014-06-16 09:51:00.041106 1',1,'2014-06-16 01:51:00','2014-06-16 01:51:10','3','1'),('2014-06-16 09:51:00.041106 1.1.2','2014-06-16 09:51:00.041106 1.1','2014-06-16 09:51:00.041106 1',2,'2014-06-16 01:51:00','2014-06-16 01:51:00','4','1'),('2014-06-16 09:51:00.041106 1.10.20','2014-06-16 09:51:00.041106 1.10','2014-06-16 09:51:00.041106 1',20,'2014-06-16 01:51:40','2014-06-16 01:51:50','3','1'),('2014-06-16 09:51:00.041106 1.2.3','2014-06-16 09:51:00.041106 1.2','2014-06-16 09:51:00.041106 1',3,'2014-06-16 01:51:10','2014-06-16 01:51:20','3','1'),('2014-06-16 09:51:00.041106 1.2.4','2014-06-16 09:51:00.041106 1.2','2014-06-16 09:51:00.041106 1',4,'2014-06-16 01:51:10','2014-06-16 01:51:20','3','1'),('2014-06-16 09:51:00.041106 1.2.5','2014-06-16 09:51:00.041106 1.2','2014-06-16 09:51:00.041106 1',5,'2014-06-16 01:51:00','2014-06-16 01:51:10','3','1'),('2014-06-16 09:51:00.041106 1.3.6','2014-06-16 09:51:00.041106 1.3','2014-06-16 09:51:00.041106 1',6,'2014-06-16 01:51:20','2014-06-16 01:51:30','3','1'),('2014-06-16 09:51:00.041106 1.9.7','2014-06-16 09:51:00.041106 1.9','2014-06-16 09:51:00.041106 1',7,'2014-06-16 01:51:30','2014-06-16 01:51:40','3','1'),('2014-06-16 09:51:00.041106 1.9.8','2014-06-16 09:51:00.041106 1.9','2014-06-16 09:51:00.041106 1',8,'2014-06-16 01:51:30','2014-06-16 01:51:40','3','1');
+INSERT INTO `scd_task_log` VALUES ('2014-06-16 09:48:00.047067 1.1.1','2014-06-16 09:48:00.047067 1.1','2014-06-16 09:48:00.047067 1',1,'2014-06-16 01:48:00','2014-06-16 01:48:10','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:48:00.047067 1.1.2','2014-06-16 09:48:00.047067 1.1','2014-06-16 09:48:00.047067 1',2,'2014-06-16 01:48:00','2014-06-16 01:48:00','4','1',-1,NULL,0,0,0,''),('2014-06-16 09:48:00.047067 1.10.20','2014-06-16 09:48:00.047067 1.10','2014-06-16 09:48:00.047067 1',20,'2014-06-16 01:48:40','2014-06-16 01:48:50','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:48:00.047067 1.2.3','2014-06-16 09:48:00.047067 1.2','2014-06-16 09:48:00.047067 1',3,'2014-06-16 01:48:10','2014-06-16 01:48:20','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:48:00.047067 1.2.4','2014-06-16 09:48:00.047067 1.2','2014-06-16 09:48:00.047067 1',4,'2014-06-16 01:48:10','2014-06-16 01:48:20','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:48:00.047067 1.2.5','2014-06-16 09:48:00.047067 1.2','2014-06-16 09:48:00.047067 1',5,'2014-06-16 01:48:00','2014-06-16 01:48:10','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:48:00.047067 1.3.6','2014-06-16 09:48:00.047067 1.3','2014-06-16 09:48:00.047067 1',6,'2014-06-16 01:48:20','2014-06-16 01:48:30','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:48:00.047067 1.9.7','2014-06-16 09:48:00.047067 1.9','2014-06-16 09:48:00.047067 1',7,'2014-06-16 01:48:30','2014-06-16 01:48:40','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:48:00.047067 1.9.8','2014-06-16 09:48:00.047067 1.9','2014-06-16 09:48:00.047067 1',8,'2014-06-16 01:48:30','2014-06-16 01:48:40','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:49:00.039637 1.1.1','2014-06-16 09:49:00.039637 1.1','2014-06-16 09:49:00.039637 1',1,'2014-06-16 01:49:00','2014-06-16 01:49:10','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:49:00.039637 1.1.2','2014-06-16 09:49:00.039637 1.1','2014-06-16 09:49:00.039637 1',2,'2014-06-16 01:49:00','2014-06-16 01:49:05','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:49:00.039637 1.10.20','2014-06-16 09:49:00.039637 1.10','2014-06-16 09:49:00.039637 1',20,'0000-00-00 00:00:00','0000-00-00 00:00:00','0','1',-1,NULL,0,0,0,''),('2014-06-16 09:49:00.039637 1.2.3','2014-06-16 09:49:00.039637 1.2','2014-06-16 09:49:00.039637 1',3,'2014-06-16 01:49:10','0000-00-00 00:00:00','1','1',-1,NULL,0,0,0,''),('2014-06-16 09:49:00.039637 1.2.4','2014-06-16 09:49:00.039637 1.2','2014-06-16 09:49:00.039637 1',4,'2014-06-16 01:49:10','0000-00-00 00:00:00','1','1',-1,NULL,0,0,0,''),('2014-06-16 09:49:00.039637 1.2.5','2014-06-16 09:49:00.039637 1.2','2014-06-16 09:49:00.039637 1',5,'2014-06-16 01:49:00','2014-06-16 01:49:10','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:49:00.039637 1.3.6','2014-06-16 09:49:00.039637 1.3','2014-06-16 09:49:00.039637 1',6,'0000-00-00 00:00:00','0000-00-00 00:00:00','0','1',-1,NULL,0,0,0,''),('2014-06-16 09:49:00.039637 1.9.7','2014-06-16 09:49:00.039637 1.9','2014-06-16 09:49:00.039637 1',7,'0000-00-00 00:00:00','0000-00-00 00:00:00','0','1',-1,NULL,0,0,0,''),('2014-06-16 09:49:00.039637 1.9.8','2014-06-16 09:49:00.039637 1.9','2014-06-16 09:49:00.039637 1',8,'0000-00-00 00:00:00','0000-00-00 00:00:00','0','1',-1,NULL,0,0,0,''),('2014-06-16 09:50:00.043007 1.1.1','2014-06-16 09:50:00.043007 1.1','2014-06-16 09:50:00.043007 1',1,'2014-06-16 01:50:00','2014-06-16 01:50:10','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:50:00.043007 1.1.2','2014-06-16 09:50:00.043007 1.1','2014-06-16 09:50:00.043007 1',2,'2014-06-16 01:50:00','2014-06-16 01:50:00','4','1',-1,NULL,0,0,0,''),('2014-06-16 09:50:00.043007 1.10.20','2014-06-16 09:50:00.043007 1.10','2014-06-16 09:50:00.043007 1',20,'2014-06-16 01:50:40','2014-06-16 01:50:50','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:50:00.043007 1.2.3','2014-06-16 09:50:00.043007 1.2','2014-06-16 09:50:00.043007 1',3,'2014-06-16 01:50:10','2014-06-16 01:50:20','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:50:00.043007 1.2.4','2014-06-16 09:50:00.043007 1.2','2014-06-16 09:50:00.043007 1',4,'2014-06-16 01:50:10','2014-06-16 01:50:20','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:50:00.043007 1.2.5','2014-06-16 09:50:00.043007 1.2','2014-06-16 09:50:00.043007 1',5,'2014-06-16 01:50:00','2014-06-16 01:50:10','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:50:00.043007 1.3.6','2014-06-16 09:50:00.043007 1.3','2014-06-16 09:50:00.043007 1',6,'2014-06-16 01:50:20','2014-06-16 01:50:30','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:50:00.043007 1.9.7','2014-06-16 09:50:00.043007 1.9','2014-06-16 09:50:00.043007 1',7,'2014-06-16 01:50:30','2014-06-16 01:50:40','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:50:00.043007 1.9.8','2014-06-16 09:50:00.043007 1.9','2014-06-16 09:50:00.043007 1',8,'2014-06-16 01:50:30','2014-06-16 01:50:40','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:51:00.041106 1.1.1','2014-06-16 09:51:00.041106 1.1','2014-06-16 09:51:00.041106 1',1,'2014-06-16 01:51:00','2014-06-16 01:51:10','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:51:00.041106 1.1.2','2014-06-16 09:51:00.041106 1.1','2014-06-16 09:51:00.041106 1',2,'2014-06-16 01:51:00','2014-06-16 01:51:00','4','1',-1,NULL,0,0,0,''),('2014-06-16 09:51:00.041106 1.10.20','2014-06-16 09:51:00.041106 1.10','2014-06-16 09:51:00.041106 1',20,'2014-06-16 01:51:40','2014-06-16 01:51:50','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:51:00.041106 1.2.3','2014-06-16 09:51:00.041106 1.2','2014-06-16 09:51:00.041106 1',3,'2014-06-16 01:51:10','2014-06-16 01:51:20','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:51:00.041106 1.2.4','2014-06-16 09:51:00.041106 1.2','2014-06-16 09:51:00.041106 1',4,'2014-06-16 01:51:10','2014-06-16 01:51:20','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:51:00.041106 1.2.5','2014-06-16 09:51:00.041106 1.2','2014-06-16 09:51:00.041106 1',5,'2014-06-16 01:51:00','2014-06-16 01:51:10','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:51:00.041106 1.3.6','2014-06-16 09:51:00.041106 1.3','2014-06-16 09:51:00.041106 1',6,'2014-06-16 01:51:20','2014-06-16 01:51:30','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:51:00.041106 1.9.7','2014-06-16 09:51:00.041106 1.9','2014-06-16 09:51:00.041106 1',7,'2014-06-16 01:51:30','2014-06-16 01:51:40','3','1',-1,NULL,0,0,0,''),('2014-06-16 09:51:00.041106 1.9.8','2014-06-16 09:51:00.041106 1.9','2014-06-16 09:51:00.041106 1',8,'2014-06-16 01:51:30','2014-06-16 01:51:40','3','1',-1,NULL,0,0,0,'');
 /*!40000 ALTER TABLE `scd_task_log` ENABLE KEYS */;
 UNLOCK TABLES;
 
@@ -376,6 +789,7 @@ CREATE TABLE `scd_task_rel` (
   `task_rel_id` bigint(20) NOT NULL COMMENT '自增id',
   `task_id` bigint(20) NOT NULL COMMENT '任务id',
   `rel_task_id` bigint(20) NOT NULL COMMENT '依赖的任务id',
+  `rel_condition` varchar(16) DEFAULT 'success' COMMENT '执行条件 success.依赖的任务成功时执行 failure.依赖的任务失败时执行 always.总是执行',
   `create_user_id` varchar(30) NOT NULL COMMENT '创建人',
   `create_time` date NOT NULL COMMENT '创建时间',
   PRIMARY KEY (`task_rel_id`)
@@ -388,7 +802,7 @@ CREATE TABLE `scd_task_rel` (
 
 LOCK TABLES `scd_task_rel` WRITE;
 /*!40000 ALTER TABLE `scd_task_rel` DISABLE KEYS */;
-INSERT INTO `scd_task_rel` VALUES (1,3,1,'1','2014-05-29'),(2,3,2,'1','2014-05-29'),(3,4,1,'1','2014-05-29'),(4,4,2,'1','2014-05-29'),(5,6,3,'1','2014-05-29'),(6,6,5,'1','2014-05-29'),(7,7,4,'1','2014-05-29'),(8,7,6,'1','2014-05-29'),(9,8,6,'1','2014-05-29'),(10,20,7,'1','2014-05-29'),(11,20,8,'1','2014-05-29'),(12,12,9,'1','2014-05-29'),(13,12,10,'1','2014-05-29'),(14,13,12,'1','2014-05-29'),(15,14,13,'1','2014-05-29'),(16,15,13,'1','2014-05-29'),(17,16,11,'1','2014-05-29'),(18,16,13,'1','2014-05-29'),(19,17,14,'1','2014-05-29'),(20,17,15,'1','2014-05-29'),(21,17,16,'1','2014-05-29'),(22,18,15,'1','2014-05-29'),(23,18,16,'1','2014-05-29'),(24,19,15,'1','2014-05-29');
+INSERT INTO `scd_task_rel` VALUES (1,3,1,'success','1','2014-05-29'),(2,3,2,'success','1','2014-05-29'),(3,4,1,'success','1','2014-05-29'),(4,4,2,'success','1','2014-05-29'),(5,6,3,'success','1','2014-05-29'),(6,6,5,'success','1','2014-05-29'),(7,7,4,'success','1','2014-05-29'),(8,7,6,'success','1','2014-05-29'),(9,8,6,'success','1','2014-05-29'),(10,20,7,'success','1','2014-05-29'),(11,20,8,'success','1','2014-05-29'),(12,12,9,'success','1','2014-05-29'),(13,12,10,'success','1','2014-05-29'),(14,13,12,'success','1','2014-05-29'),(15,14,13,'success','1','2014-05-29'),(16,15,13,'success','1','2014-05-29'),(17,16,11,'success','1','2014-05-29'),(18,16,13,'success','1','2014-05-29'),(19,17,14,'success','1','2014-05-29'),(20,17,15,'success','1','2014-05-29'),(21,17,16,'success','1','2014-05-29'),(22,18,15,'success','1','2014-05-29'),(23,18,16,'success','1','2014-05-29'),(24,19,15,'success','1','2014-05-29');
 /*!40000 ALTER TABLE `scd_task_rel` ENABLE KEYS */;
 UNLOCK TABLES;
 
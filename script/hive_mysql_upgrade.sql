@@ -0,0 +1,390 @@
+-- 已有元数据库的升级脚本，按顺序执行尚未执行过的语句。
+-- 新建的元数据库使用hive_mysql.sql，其中已包含以下修改，不需要执行。
+
+--
+-- scd_task_attempt_log：任务执行尝试记录表
+--
+
+CREATE TABLE `scd_task_attempt_log` (
+  `batch_task_id` varchar(128) NOT NULL COMMENT '任务批次id，规则作业批次id+任务id',
+  `batch_id` varchar(128) NOT NULL COMMENT '批次ID，规则scheduleId + 周期开始时间(不含周期内启动时间)',
+  `task_id` bigint(20) NOT NULL COMMENT '任务id',
+  `attempt_no` int(11) NOT NULL COMMENT '执行次数，从1开始',
+  `worker_addr` varchar(128) DEFAULT NULL COMMENT '执行任务的worker地址',
+  `start_time` datetime NOT NULL COMMENT '开始时间',
+  `end_time` datetime NOT NULL COMMENT '结束时间',
+  `state` varchar(1) DEFAULT NULL COMMENT '状态 3. 完成 4.意外中止 5.忽略',
+  `output` text COMMENT '任务输出',
+  PRIMARY KEY (`batch_task_id`,`attempt_no`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='任务执行尝试记录表：\n           日志部分，记录任务每一次执行的情况。';
+
+--
+-- scd_schedule_source：调度定义文件同步表
+--
+
+CREATE TABLE `scd_schedule_source` (
+  `scd_name` varchar(128) NOT NULL COMMENT '调度名称',
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `source_file` varchar(500) NOT NULL COMMENT '调度定义文件',
+  `content_hash` varchar(64) NOT NULL COMMENT '文件内容摘要',
+  `modify_time` datetime DEFAULT NULL COMMENT '同步时间',
+  PRIMARY KEY (`scd_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度定义文件同步表：\n           调度部分，记录由定义文件同步的调度信息。';
+
+--
+-- scd_snooze：调度暂缓执行信息
+--
+
+CREATE TABLE `scd_snooze` (
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `snooze_until` datetime NOT NULL COMMENT '暂缓至该时间后再启动',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度暂缓执行信息';
+
+--
+-- scd_schedule_rel：调度间的依赖关系
+--
+
+CREATE TABLE `scd_schedule_rel` (
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `rel_scd_id` bigint(20) NOT NULL COMMENT '依赖的上游调度id',
+  `create_user_id` bigint(20) NOT NULL COMMENT '创建人',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`,`rel_scd_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度间的依赖关系';
+
+--
+-- scd_task_artifact：任务产出物的引用信息
+--
+
+CREATE TABLE `scd_task_artifact` (
+  `batch_task_id` varchar(128) NOT NULL COMMENT '任务批次id',
+  `batch_id` varchar(128) NOT NULL COMMENT '批次id',
+  `task_id` bigint(20) NOT NULL COMMENT '任务id',
+  `artifact_name` varchar(128) NOT NULL COMMENT '产出物名称',
+  `artifact_uri` varchar(1024) NOT NULL COMMENT '产出物的外部存储地址',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`batch_task_id`,`artifact_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='任务产出物的引用信息';
+
+--
+-- scd_schedule.scd_warmup_task_id：预热任务id，调度启动监听前执行一次
+--
+
+ALTER TABLE `scd_schedule` ADD COLUMN `scd_warmup_task_id` bigint(20) DEFAULT 0 COMMENT '预热任务id，调度启动监听前执行一次' AFTER `scd_job_id`;
+
+--
+-- scd_task.task_retry_count：失败后的重试次数
+--
+
+ALTER TABLE `scd_task` ADD COLUMN `task_retry_count` int(11) DEFAULT 0 COMMENT '失败后的重试次数' AFTER `task_time_out`;
+
+--
+-- scd_task.task_retry_interval：重试前的等待时间，单位 秒
+--
+
+ALTER TABLE `scd_task` ADD COLUMN `task_retry_interval` bigint(20) DEFAULT 0 COMMENT '重试前的等待时间，单位 秒' AFTER `task_retry_count`;
+
+--
+-- scd_task.task_wave：执行阶段，前一阶段的任务全部结束后才开始执行
+--
+
+ALTER TABLE `scd_task` ADD COLUMN `task_wave` int(11) DEFAULT 0 COMMENT '执行阶段，前一阶段的任务全部结束后才开始执行' AFTER `task_retry_interval`;
+
+--
+-- scd_schedule.scd_group：调度分组
+--
+
+ALTER TABLE `scd_schedule` ADD COLUMN `scd_group` varchar(64) DEFAULT '' COMMENT '调度分组' AFTER `scd_name`;
+
+--
+-- scd_schedule.scd_soft_timeout：预警执行时间，单位 秒，超过后发出预警
+--
+
+ALTER TABLE `scd_schedule` ADD COLUMN `scd_soft_timeout` bigint(20) DEFAULT 0 COMMENT '预警执行时间，单位 秒，超过后发出预警' AFTER `scd_timeout`;
+
+--
+-- scd_run_journal：调度执行日志
+--
+
+CREATE TABLE `scd_run_journal` (
+  `batch_id` varchar(128) NOT NULL COMMENT '批次ID，规则scheduleId + 周期开始时间(不含周期内启动时间)',
+  `seq` bigint(20) NOT NULL COMMENT '序号，按发生先后递增',
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `event_time` timestamp NOT NULL COMMENT '发生时间',
+  `event_type` varchar(32) NOT NULL COMMENT '状态变化类型 run_start task_start task_retry task_done run_warn run_end run_fail',
+  `task_id` bigint(20) DEFAULT 0 COMMENT '任务id，调度的状态变化为0',
+  `task_name` varchar(128) DEFAULT NULL COMMENT '任务名称',
+  `attempt_no` int(11) DEFAULT 0 COMMENT '任务的执行次数',
+  `state` varchar(1) DEFAULT NULL COMMENT '调度或任务变化后的状态',
+  `message` text COMMENT '附加信息',
+  PRIMARY KEY (`batch_id`,`seq`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度执行日志：\n           日志部分，按发生先后记录调度执行过程中的每一次状态变化。';
+
+--
+-- scd_schedule.scd_cyc：调度周期 ss 秒 mi 分钟 h 小时 d 日 m 月 w 周 q 季度 y 年 cron:<表达式> 按cron表达式
+--
+
+ALTER TABLE `scd_schedule` MODIFY COLUMN `scd_cyc` varchar(64) NOT NULL COMMENT '调度周期 ss 秒 mi 分钟 h 小时 d 日 m 月 w 周 q 季度 y 年 cron:<表达式> 按cron表达式';
+
+--
+-- scd_schedule.scd_status：调度状态 0.正常 1.暂停
+--
+
+ALTER TABLE `scd_schedule` ADD COLUMN `scd_status` int(11) DEFAULT 0 COMMENT '调度状态 0.正常 1.暂停' AFTER `scd_group`;
+
+--
+-- scd_task_log.timed_out：任务是否因调度执行超过超时时间被中止
+--
+
+ALTER TABLE `scd_task_log` ADD COLUMN `timed_out` tinyint(1) DEFAULT 0 COMMENT '任务是否因调度执行超过超时时间被中止' AFTER `batch_type`;
+
+--
+-- scd_schedule.scd_overlap：上一批次未结束时的处理策略 skip.跳过 queue.排队 allow.允许重叠
+--
+
+ALTER TABLE `scd_schedule` ADD COLUMN `scd_overlap` varchar(8) DEFAULT 'skip' COMMENT '上一批次未结束时的处理策略 skip.跳过 queue.排队 allow.允许重叠' AFTER `scd_soft_timeout`;
+
+--
+-- scd_job.job_parallel_group：并行组，同一调度中连续且并行组相同的作业同时执行，0表示不分组
+--
+
+ALTER TABLE `scd_job` ADD COLUMN `job_parallel_group` int(11) DEFAULT 0 COMMENT '并行组，同一调度中连续且并行组相同的作业同时执行，0表示不分组' AFTER `job_desc`;
+
+--
+-- scd_task_rel.rel_condition：执行条件 success.依赖的任务成功时执行 failure.依赖的任务失败时执行 always.总是执行
+--
+
+ALTER TABLE `scd_task_rel` ADD COLUMN `rel_condition` varchar(16) DEFAULT 'success' COMMENT '执行条件 success.依赖的任务成功时执行 failure.依赖的任务失败时执行 always.总是执行' AFTER `rel_task_id`;
+
+--
+-- scd_next_start：调度的下次启动时间
+--
+
+CREATE TABLE `scd_next_start` (
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `next_start` datetime NOT NULL COMMENT '下次启动时间',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度的下次启动时间';
+
+--
+-- scd_schedule.scd_misfire：重启后错过启动时间的处理策略 skip.等待下一周期 run.立即执行
+--
+
+ALTER TABLE `scd_schedule` ADD COLUMN `scd_misfire` varchar(8) DEFAULT 'skip' COMMENT '重启后错过启动时间的处理策略 skip.等待下一周期 run.立即执行' AFTER `scd_overlap`;
+
+--
+-- scd_schedule.scd_timezone：启动时间所在的时区，IANA名称如Asia/Shanghai，为空时使用服务器的时区
+--
+
+ALTER TABLE `scd_schedule` ADD COLUMN `scd_timezone` varchar(64) DEFAULT '' COMMENT '启动时间所在的时区，IANA名称如Asia/Shanghai，为空时使用服务器的时区' AFTER `scd_misfire`;
+
+--
+-- scd_remain：调度剩余的执行次数
+--
+
+CREATE TABLE `scd_remain` (
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `scd_num` int(11) NOT NULL COMMENT '计算剩余次数时调度的执行次数，与调度当前的执行次数不同时重新计算',
+  `remain` int(11) NOT NULL COMMENT '剩余的执行次数',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度剩余的执行次数';
+
+--
+-- scd_task.task_resource_pool：任务使用的资源池，限制使用同一资源的任务同时执行的数量，为空时不限制
+--
+
+ALTER TABLE `scd_task` ADD COLUMN `task_resource_pool` varchar(64) DEFAULT '' COMMENT '任务使用的资源池，限制使用同一资源的任务同时执行的数量，为空时不限制' AFTER `task_wave`;
+
+--
+-- scd_job.job_timeout：作业的最大执行时间，单位秒，超过后中止作业中尚未结束的任务，0表示不限制
+--
+
+ALTER TABLE `scd_job` ADD COLUMN `job_timeout` int(11) DEFAULT 0 COMMENT '作业的最大执行时间，单位秒，超过后中止作业中尚未结束的任务，0表示不限制' AFTER `job_parallel_group`;
+
+--
+-- scd_task_log.timeout_level：中止任务的超时级别 schedule.调度超时 job.作业超时
+--
+
+ALTER TABLE `scd_task_log` ADD COLUMN `timeout_level` varchar(16) DEFAULT '' COMMENT '中止任务的超时级别 schedule.调度超时 job.作业超时' AFTER `timed_out`;
+
+--
+-- scd_task_log.exit_code：任务命令的退出码，-1表示未取得
+--
+
+ALTER TABLE `scd_task_log` ADD COLUMN `exit_code` int(11) DEFAULT -1 COMMENT '任务命令的退出码，-1表示未取得' AFTER `batch_type`;
+
+--
+-- scd_task_log.output：任务输出，超过task_output_limit时截断
+--
+
+ALTER TABLE `scd_task_log` ADD COLUMN `output` text COMMENT '任务输出，超过task_output_limit时截断' AFTER `exit_code`;
+
+--
+-- scd_schedule_tag：调度标签
+--
+
+CREATE TABLE `scd_schedule_tag` (
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `tag` varchar(64) NOT NULL COMMENT '调度标签',
+  `create_user_id` bigint(20) NOT NULL COMMENT '创建人',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`,`tag`),
+  KEY `idx_scd_schedule_tag_tag` (`tag`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度标签';
+
+--
+-- scd_task_log.dispatch_retry：发送任务遇到暂时性错误后的重试次数
+--
+
+ALTER TABLE `scd_task_log` ADD COLUMN `dispatch_retry` int(11) DEFAULT 0 COMMENT '发送任务遇到暂时性错误后的重试次数' AFTER `output`;
+
+--
+-- scd_task_log.dispatch_backoff：发送重试累计等待的时间，单位 毫秒
+--
+
+ALTER TABLE `scd_task_log` ADD COLUMN `dispatch_backoff` bigint(20) DEFAULT 0 COMMENT '发送重试累计等待的时间，单位 毫秒' AFTER `dispatch_retry`;
+
+--
+-- scd_schedule.scd_enabled：是否启用 1.启用 0.禁用，禁用的调度不自动启动，只能手动执行
+--
+
+ALTER TABLE `scd_schedule` ADD COLUMN `scd_enabled` tinyint(1) NOT NULL DEFAULT 1 COMMENT '是否启用 1.启用 0.禁用，禁用的调度不自动启动，只能手动执行' AFTER `scd_status`;
+
+--
+-- scd_start_weekday：调度启动星期
+--
+
+CREATE TABLE `scd_start_weekday` (
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `scd_weekday` tinyint(4) NOT NULL COMMENT '按周调度时的启动星期，0为星期日',
+  `create_user_id` bigint(20) NOT NULL COMMENT '创建人',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`,`scd_weekday`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度启动星期';
+
+--
+-- scd_schedule_env：调度的运行参数，任务执行时与任务的运行参数合并后发送给Worker
+--
+
+CREATE TABLE `scd_schedule_env` (
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `env_name` varchar(128) NOT NULL COMMENT '运行参数名称',
+  `env_value` text COMMENT '运行参数值，可包含模板',
+  `create_user_id` bigint(20) NOT NULL COMMENT '创建人',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`,`env_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度的运行参数，任务执行时与任务的运行参数合并后发送给Worker';
+
+--
+-- scd_task_env：任务的运行参数，与调度的同名参数同时存在时以任务的为准
+--
+
+CREATE TABLE `scd_task_env` (
+  `task_id` bigint(20) NOT NULL COMMENT '任务id',
+  `env_name` varchar(128) NOT NULL COMMENT '运行参数名称',
+  `env_value` text COMMENT '运行参数值，可包含模板',
+  `create_user_id` bigint(20) NOT NULL COMMENT '创建人',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`task_id`,`env_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='任务的运行参数，与调度的同名参数同时存在时以任务的为准';
+
+--
+-- scd_schedule.scd_start_jitter：启动时间后随机推迟的最长时间，单位 秒，0表示不推迟
+--
+
+ALTER TABLE `scd_schedule` ADD COLUMN `scd_start_jitter` bigint(20) DEFAULT 0 COMMENT '启动时间后随机推迟的最长时间，单位 秒，0表示不推迟' AFTER `scd_timezone`;
+
+--
+-- scd_schedule_log.error_msg：批次失败的原因，超过task_output_limit时截断
+--
+
+ALTER TABLE `scd_schedule_log` ADD COLUMN `error_msg` text COMMENT '批次失败的原因，超过task_output_limit时截断' AFTER `batch_type`;
+
+--
+-- scd_task.task_executor_type：在调度进程内执行任务的执行者名称，为空时发送给Worker执行
+--
+
+ALTER TABLE `scd_task` ADD COLUMN `task_executor_type` varchar(64) DEFAULT '' COMMENT '在调度进程内执行任务的执行者名称，为空时发送给Worker执行' AFTER `task_resource_pool`;
+
+--
+-- scd_schedule_trigger：调度间的触发关系
+--
+
+CREATE TABLE `scd_schedule_trigger` (
+  `scd_id` bigint(20) NOT NULL COMMENT '上游调度id',
+  `trigger_scd_id` bigint(20) NOT NULL COMMENT '批次结束后触发执行的下游调度id',
+  `on_failure` tinyint(1) NOT NULL DEFAULT 0 COMMENT '上游批次执行失败时是否也触发 1.触发 0.不触发',
+  `create_user_id` bigint(20) NOT NULL COMMENT '创建人',
+  `create_time` datetime NOT NULL COMMENT '创建时间',
+  PRIMARY KEY (`scd_id`,`trigger_scd_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='调度间的触发关系';
+
+--
+-- scd_schedule.scd_retry_count：批次失败后重新执行整个调度链的次数，0表示不重试
+--
+
+ALTER TABLE `scd_schedule` ADD COLUMN `scd_retry_count` int(11) DEFAULT 0 COMMENT '批次失败后重新执行整个调度链的次数，0表示不重试' AFTER `scd_start_jitter`;
+
+--
+-- scd_schedule.scd_retry_delay：重新执行整个调度链前的等待时间，单位 秒
+--
+
+ALTER TABLE `scd_schedule` ADD COLUMN `scd_retry_delay` bigint(20) DEFAULT 0 COMMENT '重新执行整个调度链前的等待时间，单位 秒' AFTER `scd_retry_count`;
+
+--
+-- scd_schedule_log.retry_no：调度级重试的序号，首次执行为0
+--
+
+ALTER TABLE `scd_schedule_log` ADD COLUMN `retry_no` int(11) DEFAULT 0 COMMENT '调度级重试的序号，首次执行为0' AFTER `error_msg`;
+
+--
+-- scd_schedule_log.prev_batch_id：重试前一次执行的批次ID，首次执行为空
+--
+
+ALTER TABLE `scd_schedule_log` ADD COLUMN `prev_batch_id` varchar(128) DEFAULT NULL COMMENT '重试前一次执行的批次ID，首次执行为空' AFTER `retry_no`;
+
+--
+-- scd_schedule.scd_on_startup：进程启动时的处理策略 wait.按周期等待 run-if-missed.错过启动时立即执行 always-run.总是立即执行
+--
+
+ALTER TABLE `scd_schedule` ADD COLUMN `scd_on_startup` varchar(16) DEFAULT 'wait' COMMENT '进程启动时的处理策略 wait.按周期等待 run-if-missed.错过启动时立即执行 always-run.总是立即执行' AFTER `scd_retry_delay`;
+
+--
+-- scd_schedule.scd_priority：优先级，等待并发位置时数值大的优先
+--
+
+ALTER TABLE `scd_schedule` ADD COLUMN `scd_priority` int(11) DEFAULT 0 COMMENT '优先级，等待并发位置时数值大的优先' AFTER `scd_on_startup`;
+
+--
+-- scd_task.task_disabled：任务是否禁用，禁用的任务不执行，记录为跳过
+--
+
+ALTER TABLE `scd_task` ADD COLUMN `task_disabled` tinyint(1) DEFAULT 0 COMMENT '任务是否禁用，禁用的任务不执行，记录为跳过' AFTER `task_executor_type`;
+
+--
+-- scd_run_lock：多实例部署时调度启动的抢占记录
+--
+
+CREATE TABLE `scd_run_lock` (
+  `run_key` varchar(128) NOT NULL COMMENT '幂等键，规则scheduleId@启动时间(UTC)',
+  `scd_id` bigint(20) NOT NULL COMMENT '调度id',
+  `run_window` timestamp NOT NULL COMMENT '启动时间',
+  `owner` varchar(128) NOT NULL COMMENT '抢占到启动的进程，主机名:进程号',
+  `create_time` timestamp NOT NULL COMMENT '抢占时间',
+  PRIMARY KEY (`run_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='多实例部署时调度启动的抢占记录';
+
+--
+-- scd_leader：多实例部署时主节点的租约
+--
+
+CREATE TABLE `scd_leader` (
+  `name` varchar(64) NOT NULL COMMENT '租约名称',
+  `owner` varchar(128) NOT NULL COMMENT '持有租约的主节点，主机名:进程号',
+  `lease_until` bigint(20) NOT NULL COMMENT '租约的到期时间，Unix时间戳(毫秒)',
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COMMENT='多实例部署时主节点的租约';
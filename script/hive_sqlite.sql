@@ -3,6 +3,8 @@ CREATE TABLE scd_job (
  job_id integer NOT NULL ,/* '调度id',*/
   job_name varchar(128) NOT NULL ,/* '作业名称',*/
   job_desc varchar(500) DEFAULT NULL ,/* '作业说明',*/
+  job_parallel_group integer DEFAULT 0 ,/* '并行组，同一调度中连续且并行组相同的作业同时执行，0表示不分组',*/
+  job_timeout integer DEFAULT 0 ,/* '作业的最大执行时间，单位秒，超过后中止作业中尚未结束的任务，0表示不限制',*/
   prev_job_id integer NOT NULL ,/* '上级作业id',*/
   next_job_id integer NOT NULL ,/* '下级作业id',*/
   create_user_id varchar(30) DEFAULT '' ,/* '创建人',*/
@@ -22,7 +24,7 @@ CREATE TABLE scd_job_log (
   end_time timestamp NOT NULL  ,/* '结束时间',*/
   state varchar(1) DEFAULT NULL ,/* '状态 0.不满足条件未执行 1. 执行中 2. 暂停 3. 完成 4.意外中止',*/
   result real DEFAULT NULL ,/* '结果,作业中执行成功任务的百分比',*/
-  batch_type varchar(1) NOT NULL ,/* '执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行',*/
+  batch_type varchar(1) NOT NULL ,/* '执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行 4.补数执行',*/
   PRIMARY KEY (batch_job_id,job_id,start_time)
 );/*='作业执行信息表：\n           日志部分，记录作业执行情况。';*/
 
@@ -40,13 +42,81 @@ CREATE TABLE scd_job_task (
 
 
 
+CREATE TABLE scd_next_start (
+  scd_id integer NOT NULL ,/* '调度id',*/
+  next_start timestamp NOT NULL ,/* '下次启动时间',*/
+  create_time timestamp NOT NULL ,/* '创建时间',*/
+  PRIMARY KEY (scd_id)
+);/*='调度的下次启动时间';*/
+
+
+
+CREATE TABLE scd_remain (
+  scd_id integer NOT NULL ,/* '调度id',*/
+  scd_num integer NOT NULL ,/* '计算剩余次数时调度的执行次数，与调度当前的执行次数不同时重新计算',*/
+  remain integer NOT NULL ,/* '剩余的执行次数',*/
+  create_time timestamp NOT NULL ,/* '创建时间',*/
+  PRIMARY KEY (scd_id)
+);/*='调度剩余的执行次数';*/
+
+
+
+CREATE TABLE scd_leader (
+  name varchar(64) NOT NULL ,/* '租约名称',*/
+  owner varchar(128) NOT NULL ,/* '持有租约的主节点，主机名:进程号',*/
+  lease_until integer NOT NULL ,/* '租约的到期时间，Unix时间戳(毫秒)',*/
+  PRIMARY KEY (name)
+);/*='多实例部署时主节点的租约';*/
+
+
+
+CREATE TABLE scd_run_lock (
+  run_key varchar(128) NOT NULL ,/* '幂等键，规则scheduleId@启动时间(UTC)',*/
+  scd_id integer NOT NULL ,/* '调度id',*/
+  run_window timestamp NOT NULL ,/* '启动时间',*/
+  owner varchar(128) NOT NULL ,/* '抢占到启动的进程，主机名:进程号',*/
+  create_time timestamp NOT NULL ,/* '抢占时间',*/
+  PRIMARY KEY (run_key)
+);/*='多实例部署时调度启动的抢占记录';*/
+
+
+
+CREATE TABLE scd_run_journal (
+  batch_id varchar(128) NOT NULL ,/* '批次ID，规则scheduleId + 周期开始时间(不含周期内启动时间)',*/
+  seq integer NOT NULL ,/* '序号，按发生先后递增',*/
+  scd_id integer NOT NULL ,/* '调度id',*/
+  event_time timestamp NOT NULL ,/* '发生时间',*/
+  event_type varchar(32) NOT NULL ,/* '状态变化类型 run_start task_start task_retry task_done run_warn run_end run_fail',*/
+  task_id integer DEFAULT 0 ,/* '任务id，调度的状态变化为0',*/
+  task_name varchar(128) DEFAULT NULL ,/* '任务名称',*/
+  attempt_no integer DEFAULT 0 ,/* '任务的执行次数',*/
+  state varchar(1) DEFAULT NULL ,/* '调度或任务变化后的状态',*/
+  message text ,/* '附加信息',*/
+  PRIMARY KEY (batch_id,seq)
+);/*='调度执行日志：\n           日志部分，按发生先后记录调度执行过程中的每一次状态变化。';*/
+
+
+
 CREATE TABLE scd_schedule (
   scd_id integer NOT NULL ,/* '调度id',*/
   scd_name varchar(128) NOT NULL ,/* '调度名称',*/
+  scd_group varchar(64) DEFAULT '' ,/* '调度分组',*/
+  scd_status integer DEFAULT 0 ,/* '调度状态 0.正常 1.暂停',*/
+  scd_enabled integer NOT NULL DEFAULT 1 ,/* '是否启用 1.启用 0.禁用，禁用的调度不自动启动，只能手动执行',*/
   scd_num integer NOT NULL ,/* '调度次数 0.不限次数 ',*/
-  scd_cyc varchar(2) NOT NULL ,/* '调度周期 ss 秒 mi 分钟 h 小时 d 日 m 月 w 周 q 季度 y 年',*/
+  scd_cyc varchar(64) NOT NULL ,/* '调度周期 ss 秒 mi 分钟 h 小时 d 日 m 月 w 周 q 季度 y 年 cron:<表达式> 按cron表达式 interval:<间隔> 按固定间隔如interval:90s',*/
   scd_timeout integer DEFAULT NULL ,/* '最大执行时间，单位 秒',*/
+  scd_soft_timeout integer DEFAULT 0 ,/* '预警执行时间，单位 秒，超过后发出预警',*/
+  scd_overlap varchar(8) DEFAULT 'skip' ,/* '上一批次未结束时的处理策略 skip.跳过 queue.排队 allow.允许重叠',*/
+  scd_misfire varchar(8) DEFAULT 'skip' ,/* '重启后错过启动时间的处理策略 skip.等待下一周期 run.立即执行 catchup.补齐错过的启动',*/
+  scd_timezone varchar(64) DEFAULT '' ,/* '启动时间所在的时区，IANA名称如Asia/Shanghai，为空时使用服务器的时区',*/
+  scd_start_jitter integer DEFAULT 0 ,/* '启动时间后随机推迟的最长时间，单位 秒，0表示不推迟',*/
+  scd_retry_count integer DEFAULT 0 ,/* '批次失败后重新执行整个调度链的次数，0表示不重试',*/
+  scd_retry_delay integer DEFAULT 0 ,/* '重新执行整个调度链前的等待时间，单位 秒',*/
+  scd_on_startup varchar(16) DEFAULT 'wait' ,/* '进程启动时的处理策略 wait.按周期等待 run-if-missed.错过启动时立即执行 always-run.总是立即执行',*/
+  scd_priority integer DEFAULT 0 ,/* '优先级，等待并发位置时数值大的优先',*/
   scd_job_id integer DEFAULT NULL ,/* '作业id',*/
+  scd_warmup_task_id integer DEFAULT 0 ,/* '预热任务id，调度启动监听前执行一次',*/
   scd_desc varchar(500) DEFAULT NULL ,/* '调度说明',*/
   create_user_id varchar(30) NOT NULL ,/* '创建人',*/
   create_time timestamp NOT NULL  ,/* '创建时间',*/
@@ -64,27 +134,111 @@ CREATE TABLE scd_schedule_log (
   end_time timestamp NOT NULL ,/* '结束时间',*/
   state varchar(1) DEFAULT NULL ,/* '状态 0.不满足条件未执行 1. 执行中 2. 暂停 3. 完成 4.失败',*/
   result real DEFAULT NULL ,/* '结果,调度中执行成功任务的百分比',*/
-  batch_type varchar(1) NOT NULL ,/* '执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行',*/
+  batch_type varchar(1) NOT NULL ,/* '执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行 4.补数执行',*/
+  error_msg text DEFAULT NULL ,/* '批次失败的原因，超过task_output_limit时截断',*/
+  retry_no integer DEFAULT 0 ,/* '调度级重试的序号，首次执行为0',*/
+  prev_batch_id varchar(128) DEFAULT NULL ,/* '重试前一次执行的批次ID，首次执行为空',*/
   PRIMARY KEY (batch_id,scd_id,start_time)
 );/*='用户调度权限表：\n           日志部分，记录调度执行情况。';*/
 
 
 
+CREATE TABLE scd_schedule_rel (
+  scd_id integer NOT NULL ,/* '调度id',*/
+  rel_scd_id integer NOT NULL ,/* '依赖的上游调度id',*/
+  create_user_id integer NOT NULL ,/* '创建人',*/
+  create_time timestamp NOT NULL ,/* '创建时间',*/
+  PRIMARY KEY (scd_id,rel_scd_id)
+);/*='调度间的依赖关系';*/
+
+
+
+CREATE TABLE scd_schedule_trigger (
+  scd_id integer NOT NULL ,/* '上游调度id',*/
+  trigger_scd_id integer NOT NULL ,/* '批次结束后触发执行的下游调度id',*/
+  on_failure integer NOT NULL DEFAULT 0 ,/* '上游批次执行失败时是否也触发 1.触发 0.不触发',*/
+  create_user_id integer NOT NULL ,/* '创建人',*/
+  create_time timestamp NOT NULL ,/* '创建时间',*/
+  PRIMARY KEY (scd_id,trigger_scd_id)
+);/*='调度间的触发关系';*/
+
+
+
+CREATE TABLE scd_schedule_source (
+  scd_name varchar(128) NOT NULL ,/* '调度名称',*/
+  scd_id integer NOT NULL ,/* '调度id',*/
+  source_file varchar(500) NOT NULL ,/* '调度定义文件',*/
+  content_hash varchar(64) NOT NULL ,/* '文件内容摘要',*/
+  modify_time timestamp NULL DEFAULT NULL ,/* '同步时间',*/
+  PRIMARY KEY (scd_name)
+);/*='调度定义文件同步表：\n           调度部分，记录由定义文件同步的调度信息。';*/
+
+
+
+CREATE TABLE scd_schedule_env (
+  scd_id integer NOT NULL ,/* '调度id',*/
+  env_name varchar(128) NOT NULL ,/* '运行参数名称',*/
+  env_value text ,/* '运行参数值，可包含模板',*/
+  create_user_id integer NOT NULL ,/* '创建人',*/
+  create_time timestamp NOT NULL ,/* '创建时间',*/
+  PRIMARY KEY (scd_id,env_name)
+);/*='调度的运行参数，任务执行时与任务的运行参数合并后发送给Worker';*/
+
+
+
+CREATE TABLE scd_schedule_tag (
+  scd_id integer NOT NULL ,/* '调度id',*/
+  tag varchar(64) NOT NULL ,/* '调度标签',*/
+  create_user_id integer NOT NULL ,/* '创建人',*/
+  create_time timestamp NOT NULL ,/* '创建时间',*/
+  PRIMARY KEY (scd_id,tag)
+);/*='调度标签';*/
+
+CREATE INDEX idx_scd_schedule_tag_tag ON scd_schedule_tag (tag);
+
+
+
+CREATE TABLE scd_snooze (
+  scd_id integer NOT NULL ,/* '调度id',*/
+  snooze_until timestamp NOT NULL ,/* '暂缓至该时间后再启动',*/
+  create_time timestamp NOT NULL ,/* '创建时间',*/
+  PRIMARY KEY (scd_id)
+);/*='调度暂缓执行信息';*/
+
+
+
 CREATE TABLE scd_start (
   scd_id integer NOT NULL ,/* '调度id',*/
   scd_start integer NOT NULL ,/* '周期内启动时间单位秒',*/
+  scd_start_month integer NOT NULL DEFAULT 0 ,/* '启动月份，周期内的月份偏移',*/
   create_user_id integer NOT NULL ,/* '创建人',*/
   create_time timestamp NOT NULL  /* '创建时间'*/
 );/*='异常处理设置信息：\n           调度部分，记录异常的处理信息。';*/
 
 
 
+CREATE TABLE scd_start_weekday (
+  scd_id integer NOT NULL ,/* '调度id',*/
+  scd_weekday integer NOT NULL ,/* '按周调度时的启动星期，0为星期日',*/
+  create_user_id integer NOT NULL ,/* '创建人',*/
+  create_time timestamp NOT NULL ,/* '创建时间',*/
+  PRIMARY KEY (scd_id,scd_weekday)
+);/*='调度启动星期';*/
+
+
+
 CREATE TABLE scd_task (
   task_id integer NOT NULL ,/* '任务id',*/
   task_address varchar(128) NOT NULL ,/* '任务地址',*/
   task_name varchar(128) NOT NULL ,/* '任务名称',*/
   task_cyc varchar(2) DEFAULT '' ,/* '调度周期 ss 秒 mi 分钟 h 小时 d 日 m 月 w 周 q 季度 y 年',*/
   task_time_out integer DEFAULT '0' ,/* '超时时间',*/
+  task_retry_count integer DEFAULT 0 ,/* '失败后的重试次数',*/
+  task_retry_interval integer DEFAULT 0 ,/* '重试前的等待时间，单位 秒',*/
+  task_wave integer DEFAULT 0 ,/* '执行阶段，前一阶段的任务全部结束后才开始执行',*/
+  task_resource_pool varchar(64) DEFAULT '' ,/* '任务使用的资源池，限制使用同一资源的任务同时执行的数量，为空时不限制',*/
+  task_executor_type varchar(64) DEFAULT '' ,/* '在调度进程内执行任务的执行者名称，为空时发送给Worker执行',*/
+  task_disabled integer DEFAULT 0 ,/* '任务是否禁用，禁用的任务不执行，记录为跳过',*/
   task_start integer DEFAULT NULL ,/* '周期内启动时间，格式 mm-dd hh24:mi:ss，最大单位小于调度周期',*/
   task_type_id integer DEFAULT NULL ,/* '任务类型ID',*/
   task_cmd varchar(500) NOT NULL ,/* '任务命令行',*/
@@ -98,6 +252,44 @@ CREATE TABLE scd_task (
 
 
 
+CREATE TABLE scd_task_artifact (
+  batch_task_id varchar(128) NOT NULL ,/* '任务批次id',*/
+  batch_id varchar(128) NOT NULL ,/* '批次id',*/
+  task_id integer NOT NULL ,/* '任务id',*/
+  artifact_name varchar(128) NOT NULL ,/* '产出物名称',*/
+  artifact_uri varchar(1024) NOT NULL ,/* '产出物的外部存储地址',*/
+  create_time timestamp NOT NULL ,/* '创建时间',*/
+  PRIMARY KEY (batch_task_id,artifact_name)
+);/*='任务产出物的引用信息';*/
+
+
+
+CREATE TABLE scd_task_attempt_log (
+  batch_task_id varchar(128) NOT NULL ,/* '任务批次id，规则作业批次id+任务id',*/
+  batch_id varchar(128) NOT NULL ,/* '批次ID，规则scheduleId + 周期开始时间(不含周期内启动时间)',*/
+  task_id integer NOT NULL ,/* '任务id',*/
+  attempt_no integer NOT NULL ,/* '执行次数，从1开始',*/
+  worker_addr varchar(128) DEFAULT NULL ,/* '执行任务的worker地址',*/
+  start_time timestamp NOT NULL ,/* '开始时间',*/
+  end_time timestamp NOT NULL ,/* '结束时间',*/
+  state varchar(1) DEFAULT NULL ,/* '状态 3. 完成 4.意外中止 5.忽略',*/
+  output text ,/* '任务输出',*/
+  PRIMARY KEY (batch_task_id,attempt_no)
+);/*='任务执行尝试记录表：\n           日志部分，记录任务每一次执行的情况。';*/
+
+
+
+CREATE TABLE scd_task_env (
+  task_id integer NOT NULL ,/* '任务id',*/
+  env_name varchar(128) NOT NULL ,/* '运行参数名称',*/
+  env_value text ,/* '运行参数值，可包含模板',*/
+  create_user_id integer NOT NULL ,/* '创建人',*/
+  create_time timestamp NOT NULL ,/* '创建时间',*/
+  PRIMARY KEY (task_id,env_name)
+);/*='任务的运行参数，与调度的同名参数同时存在时以任务的为准';*/
+
+
+
 CREATE TABLE scd_task_attr (
   task_attr_id integer NOT NULL ,/* '自增id',*/
   task_id integer NOT NULL ,/* '任务id',*/
@@ -116,8 +308,14 @@ CREATE TABLE scd_task_log (
   task_id integer NOT NULL ,/* '任务id',*/
   start_time timestamp NOT NULL  ,/* '开始时间',*/
   end_time timestamp NOT NULL  ,/* '结束时间',*/
-  state varchar(1) DEFAULT NULL ,/* '状态 0.初始状态 1. 执行中 2. 暂停 3. 完成 4.忽略 5.意外中止',*/
-  batch_type varchar(1) NOT NULL ,/* '执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行',*/
+  state varchar(1) DEFAULT NULL ,/* '状态 0.初始状态 1. 执行中 2. 暂停 3. 完成 4.意外中止 5.忽略 6.跳过',*/
+  batch_type varchar(1) NOT NULL ,/* '执行类型 1. 自动定时调度 2.手动人工调度 3.修复执行 4.补数执行',*/
+  exit_code integer DEFAULT -1 ,/* '任务命令的退出码，-1表示未取得',*/
+  output text ,/* '任务输出，超过task_output_limit时截断',*/
+  dispatch_retry integer DEFAULT 0 ,/* '发送任务遇到暂时性错误后的重试次数',*/
+  dispatch_backoff integer DEFAULT 0 ,/* '发送重试累计等待的时间，单位 毫秒',*/
+  timed_out integer DEFAULT 0 ,/* '任务是否因调度执行超过超时时间被中止',*/
+  timeout_level varchar(16) DEFAULT '' ,/* '中止任务的超时级别 schedule.调度超时 job.作业超时',*/
   PRIMARY KEY (batch_task_id,task_id,start_time)
 );/*='任务执行信息表：\n           日志部分，记录任务执行情况。';*/
 
@@ -139,6 +337,7 @@ CREATE TABLE scd_task_rel (
   task_rel_id integer NOT NULL ,/* '自增id',*/
   task_id integer NOT NULL ,/* '任务id',*/
   rel_task_id integer NOT NULL ,/* '依赖的任务id',*/
+  rel_condition varchar(16) DEFAULT 'success' ,/* '执行条件 success.依赖的任务成功时执行 failure.依赖的任务失败时执行 always.总是执行',*/
   create_user_id varchar(30) NOT NULL ,/* '创建人',*/
   create_time timestamp NOT NULL  ,/* '创建时间',*/
   PRIMARY KEY (task_rel_id)
@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//Worker接受连接时的安全设置。
+//
+//设置TLS后只接受TLS连接；设置Token后，连接建立后调度需先发送一行认证信息，
+//格式与schedule包中的dialWorker一致：
+//
+//	HIVE-AUTH <token>    调度发送
+//	OK 或 DENIED         Worker返回
+//
+//未设置TLS时需显式设置Insecure才会接受明文连接，只用于本地测试。
+type Security struct { // {{{
+	TLS      *tls.Config //接受连接时使用的TLS配置，见LoadServerTLS
+	Token    string      //调度连接时需发送的认证信息，为空时不认证
+	Insecure bool        //未设置TLS时接受明文连接
+} // }}}
+
+const (
+	authPrefix  = "HIVE-AUTH "     //认证信息的前缀
+	authOK      = "OK"             //认证通过
+	authDenied  = "DENIED"         //认证失败
+	authTimeout = 10 * time.Second //TLS握手与认证的超时时间
+)
+
+//握手或认证失败的连接数量
+var rejected struct {
+	tls  int64
+	auth int64
+}
+
+//Rejected返回Worker启动后TLS握手失败与认证失败的连接数量
+func Rejected() (tlsFailed, authFailed int64) { // {{{
+	return atomic.LoadInt64(&rejected.tls), atomic.LoadInt64(&rejected.auth)
+} // }}}
+
+//LoadServerTLS根据PEM格式的文件创建Worker接受连接时使用的TLS配置。
+//certFile、keyFile为Worker的证书与私钥；clientCAFile不为空时要求调度提供由该CA签发的证书（mTLS）。
+func LoadServerTLS(certFile, keyFile, clientCAFile string) (*tls.Config, error) { // {{{
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		e := fmt.Sprintf("\n[LoadServerTLS] load certificate error %s.", err.Error())
+		return nil, errors.New(e)
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if clientCAFile != "" {
+		pem, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			e := fmt.Sprintf("\n[LoadServerTLS] read client ca file error %s.", err.Error())
+			return nil, errors.New(e)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			e := fmt.Sprintf("\n[LoadServerTLS] no certificate found in client ca file [%s].", clientCAFile)
+			return nil, errors.New(e)
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return conf, nil
+} // }}}
+
+//accept按安全设置完成连接的TLS握手与认证，失败时关闭连接、记录日志并计数。
+//只建立连接后即关闭的连接（如调度检查Worker是否可用）不计为失败。
+func (sec Security) accept(conn net.Conn) (net.Conn, bool) { // {{{
+	conn.SetDeadline(time.Now().Add(authTimeout))
+
+	if sec.TLS != nil {
+		tc := tls.Server(conn, sec.TLS)
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			if err != io.EOF {
+				atomic.AddInt64(&rejected.tls, 1)
+				l.Warnln("TLS handshake from", conn.RemoteAddr(), "is failed", err)
+			}
+			return nil, false
+		}
+		conn = tc
+	}
+
+	if sec.Token != "" {
+		line, err := readLine(conn, len(authPrefix)+len(sec.Token)+64)
+		if err == io.EOF {
+			conn.Close()
+			return nil, false
+		}
+		token := strings.TrimPrefix(line, authPrefix)
+		if err != nil || !strings.HasPrefix(line, authPrefix) || subtle.ConstantTimeCompare([]byte(token), []byte(sec.Token)) != 1 {
+			fmt.Fprintf(conn, "%s\n", authDenied)
+			conn.Close()
+			atomic.AddInt64(&rejected.auth, 1)
+			l.Warnln("auth from", conn.RemoteAddr(), "is rejected", err)
+			return nil, false
+		}
+		if _, err = fmt.Fprintf(conn, "%s\n", authOK); err != nil {
+			conn.Close()
+			return nil, false
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, true
+} // }}}
+
+//readLine逐字节读取一行，不含换行符，避免读取之后属于RPC的数据。超过max字节仍未读到换行符时返回error
+func readLine(conn net.Conn, max int) (string, error) { // {{{
+	line := make([]byte, 0, max)
+	b := make([]byte, 1)
+	for len(line) < max {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSpace(string(line)), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New(fmt.Sprintf("line is longer than %d bytes", max))
+} // }}}
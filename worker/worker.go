@@ -4,13 +4,18 @@ package worker
 
 import (
 	"bytes"
+	"errors"
 	"github.com/Sirupsen/logrus"
 	sh "github.com/rprp/go-sh"
 	"net"
 	"net/rpc"
+	"os"
+	"os/exec"
 	"runtime"
 	"runtime/debug"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -19,6 +24,12 @@ var (
 	//全局log对象
 	l = logrus.New()
 	p = l.WithFields
+
+	//正在执行的任务，键为任务的批次ID，供Abort中止
+	running = struct {
+		sync.Mutex
+		sessions map[string]*sh.Session
+	}{sessions: make(map[string]*sh.Session)}
 )
 
 func init() { // {{{
@@ -40,15 +51,18 @@ type Task struct {
 	TimeOut     int64             // 设定超时时间，0表示不做超时限制。单位秒
 	Param       []string          // 任务的参数信息
 	Attr        map[string]string // 任务的属性信息
+	Params      map[string]string // 任务的运行参数，执行命令时设置为环境变量
 	JobId       int64             //所属作业ID
 	RelTasks    map[string]*Task  //依赖的任务
 	RelTaskCnt  int64             //依赖的任务数量
+	BatchTaskId string            //任务的批次ID，Abort据此找到正在执行的任务
 }
 
 //返回的消息
 type Reply struct {
-	Err    string //错误信息
-	Stdout string //标准输出
+	Err      string //错误信息
+	Stdout   string //标准输出
+	ExitCode int    //命令的退出码，未能执行命令时为-1
 }
 
 //RPC结构
@@ -66,6 +80,22 @@ func (this *CmdExecuter) Run(task *Task, reply *Reply) error { // {{{
 	return nil
 } // }}}
 
+//Abort中止正在执行的批次任务task.BatchTaskId，任务已结束或不存在时不做处理。
+//被中止的任务在Run中返回错误信息。
+func (this *CmdExecuter) Abort(task *Task, reply *Reply) error { // {{{
+	running.Lock()
+	s, ok := running.sessions[task.BatchTaskId]
+	running.Unlock()
+	if !ok {
+		l.Infoln(task.Name, "batchTaskId[", task.BatchTaskId, "] is not running")
+		return nil
+	}
+
+	s.Kill(os.Kill)
+	l.Warnln(task.Name, "batchTaskId[", task.BatchTaskId, "] is aborted")
+	return nil
+} // }}}
+
 //runCmd用来执行参数cmd中指定的命令，并返回执行时间和错误信息。
 func runCmd(task *Task, reply *Reply) { // {{{
 	defer func() {
@@ -74,6 +104,7 @@ func runCmd(task *Task, reply *Reply) { // {{{
 			buf.Write(debug.Stack())
 			l.Warnln("panic=", buf.String())
 			reply.Err = "error"
+			reply.ExitCode = -1
 			return
 		}
 	}()
@@ -89,11 +120,33 @@ func runCmd(task *Task, reply *Reply) { // {{{
 	//启动一个goroutine执行任务，超时则直接返回，
 	//正常结束则设置成功执行标志ok
 	//go func() {
-	out, err := sh.Command(cmd, cmdArgs).SetTimeout(time.Duration(task.TimeOut) * 1000 * time.Millisecond).Output()
+	//运行参数设置为命令的环境变量，需在Command之前设置
+	session := sh.NewSession()
+	for k, v := range task.Params {
+		session.SetEnv(k, v)
+	}
+	session = session.Command(cmd, cmdArgs).SetTimeout(time.Duration(task.TimeOut) * 1000 * time.Millisecond)
+	if task.BatchTaskId != "" {
+		running.Lock()
+		running.sessions[task.BatchTaskId] = session
+		running.Unlock()
+		defer func() {
+			running.Lock()
+			delete(running.sessions, task.BatchTaskId)
+			running.Unlock()
+		}()
+	}
+	out, err := session.Output()
 	reply.Stdout = string(out)
 	l.Infoln("StdOut:", string(out))
 	if err != nil {
 		reply.Err = "error"
+		reply.ExitCode = -1
+		if ee, ok := err.(*exec.ExitError); ok {
+			if ws, ok := ee.Sys().(syscall.WaitStatus); ok {
+				reply.ExitCode = ws.ExitStatus()
+			}
+		}
 		l.Warnln("error", err)
 		l.Warnln(task.Name, "is error TaskCmd=", task.Cmd, "TaskArg=", cmdArgs)
 		return
@@ -105,12 +158,20 @@ func runCmd(task *Task, reply *Reply) { // {{{
 	return
 } // }}}
 
-//启动HTTP服务监控指定端口
-func ListenAndServer(port string) { // {{{
+//启动HTTP服务监控指定端口，连接的TLS与认证按sec设置，见Security。
+//未设置TLS且未设置Insecure时拒绝启动，避免误用明文连接。
+func ListenAndServer(port string, sec Security) { // {{{
+	if sec.TLS == nil && !sec.Insecure {
+		checkErr(errors.New("worker TLS is not configured, set Insecure to accept plaintext connections"))
+	}
+	if sec.TLS == nil {
+		l.Warnln("Worker accepts plaintext connections, use it only for local testing")
+	}
+
 	executer := new(CmdExecuter)
 	rpc.Register(executer)
 
-	l.Infoln("Worker is running Port:", port)
+	l.Infoln("Worker is running Port:", port, "tls:", sec.TLS != nil, "auth:", sec.Token != "")
 
 	tcpAddr, err := net.ResolveTCPAddr("tcp", port)
 	checkErr(err)
@@ -125,7 +186,9 @@ func ListenAndServer(port string) { // {{{
 				continue
 			}
 			go func() {
-				rpc.ServeConn(conn)
+				if conn, ok := sec.accept(conn); ok {
+					rpc.ServeConn(conn)
+				}
 			}()
 		}
 	}()